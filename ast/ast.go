@@ -2,6 +2,7 @@ package ast
 
 import (
 	"minlang/lexer"
+	"strconv"
 	"strings"
 )
 
@@ -81,7 +82,7 @@ type StringLiteral struct {
 
 func (sl *StringLiteral) expressionNode()      {}
 func (sl *StringLiteral) TokenLiteral() string { return sl.Token.Literal }
-func (sl *StringLiteral) String() string       { return "\"" + sl.Value + "\"" }
+func (sl *StringLiteral) String() string       { return "\"" + sl.Token.Literal + "\"" }
 
 // BooleanLiteral represents a boolean literal
 type BooleanLiteral struct {
@@ -159,6 +160,27 @@ func (ie *IndexExpression) String() string {
 	return "(" + ie.Left.String() + "[" + ie.Index.String() + "])"
 }
 
+// SliceExpression represents slicing an array or string (e.g., a[1:4])
+type SliceExpression struct {
+	Token lexer.Token // The '[' token
+	Left  Expression  // The array or string
+	Low   Expression  // The first index, or nil for the start
+	High  Expression  // The index past the last, or nil for the end
+}
+
+func (se *SliceExpression) expressionNode()      {}
+func (se *SliceExpression) TokenLiteral() string { return se.Token.Literal }
+func (se *SliceExpression) String() string {
+	var low, high string
+	if se.Low != nil {
+		low = se.Low.String()
+	}
+	if se.High != nil {
+		high = se.High.String()
+	}
+	return "(" + se.Left.String() + "[" + low + ":" + high + "])"
+}
+
 // FieldAccessExpression represents field access (e.g., x.y)
 type FieldAccessExpression struct {
 	Token lexer.Token // The '.' token
@@ -172,6 +194,20 @@ func (fae *FieldAccessExpression) String() string {
 	return "(" + fae.Left.String() + "." + fae.Field.String() + ")"
 }
 
+// TupleElementExpression represents t.0, the element of a tuple at a
+// position
+type TupleElementExpression struct {
+	Token lexer.Token // The '.' token
+	Left  Expression  // The tuple
+	Index int
+}
+
+func (te *TupleElementExpression) expressionNode()      {}
+func (te *TupleElementExpression) TokenLiteral() string { return te.Token.Literal }
+func (te *TupleElementExpression) String() string {
+	return "(" + te.Left.String() + "." + strconv.Itoa(te.Index) + ")"
+}
+
 // ArrayLiteral represents an array literal
 type ArrayLiteral struct {
 	Token    lexer.Token // The '[' token
@@ -188,20 +224,37 @@ func (al *ArrayLiteral) String() string {
 	return "[" + strings.Join(elements, ", ") + "]"
 }
 
+// TupleLiteral represents a tuple (a, b, ...) of two or more elements
+type TupleLiteral struct {
+	Token    lexer.Token // The '(' token
+	Elements []Expression
+}
+
+func (tl *TupleLiteral) expressionNode()      {}
+func (tl *TupleLiteral) TokenLiteral() string { return tl.Token.Literal }
+func (tl *TupleLiteral) String() string {
+	var elements []string
+	for _, el := range tl.Elements {
+		elements = append(elements, el.String())
+	}
+	return "(" + strings.Join(elements, ", ") + ")"
+}
+
 // MapLiteral represents a map literal
 type MapLiteral struct {
-	Token lexer.Token // The 'map' token
+	Token     lexer.Token // The 'map' token
 	KeyType   *TypeAnnotation
 	ValueType *TypeAnnotation
 	Pairs     map[Expression]Expression
+	Keys      []Expression // The keys of Pairs in the order they are written
 }
 
 func (ml *MapLiteral) expressionNode()      {}
 func (ml *MapLiteral) TokenLiteral() string { return ml.Token.Literal }
 func (ml *MapLiteral) String() string {
 	var pairs []string
-	for k, v := range ml.Pairs {
-		pairs = append(pairs, k.String()+": "+v.String())
+	for _, k := range ml.Keys {
+		pairs = append(pairs, k.String()+": "+ml.Pairs[k].String())
 	}
 	return "map[" + ml.KeyType.String() + "]" + ml.ValueType.String() + "{" + strings.Join(pairs, ", ") + "}"
 }
@@ -223,6 +276,39 @@ func (sl *StructLiteral) String() string {
 	return sl.Name.String() + "{" + strings.Join(fields, ", ") + "}"
 }
 
+// NewExpression makes a struct from its fields in the order the struct
+// declares them: `new Point(1, 2)`. Fields after the arguments take their
+// default values.
+type NewExpression struct {
+	Token     lexer.Token // The 'new' token
+	Type      *Identifier
+	Arguments []Expression
+}
+
+func (ne *NewExpression) expressionNode()      {}
+func (ne *NewExpression) TokenLiteral() string { return ne.Token.Literal }
+func (ne *NewExpression) String() string {
+	var args []string
+	for _, a := range ne.Arguments {
+		args = append(args, a.String())
+	}
+	return "new " + ne.Type.String() + "(" + strings.Join(args, ", ") + ")"
+}
+
+// CastExpression checks that a value has a type as the program runs,
+// stopping it with an error if not: `v as int`. It is the value, typed.
+type CastExpression struct {
+	Token lexer.Token // The 'as' token
+	Value Expression
+	Type  *TypeAnnotation
+}
+
+func (ce *CastExpression) expressionNode()      {}
+func (ce *CastExpression) TokenLiteral() string { return ce.Token.Literal }
+func (ce *CastExpression) String() string {
+	return "(" + ce.Value.String() + " as " + ce.Type.String() + ")"
+}
+
 // TypeAnnotation represents a type annotation
 type TypeAnnotation struct {
 	Token lexer.Token
@@ -231,10 +317,12 @@ type TypeAnnotation struct {
 	IsArray     bool
 	IsMap       bool
 	IsFunction  bool
+	IsTuple     bool
 	ElementType *TypeAnnotation   // For arrays
 	KeyType     *TypeAnnotation   // For maps
 	ValueType   *TypeAnnotation   // For maps and function returns
 	ParamTypes  []*TypeAnnotation // For functions
+	Elements    []*TypeAnnotation // For tuples
 }
 
 func (ta *TypeAnnotation) String() string {
@@ -255,6 +343,13 @@ func (ta *TypeAnnotation) String() string {
 		}
 		return "func(" + strings.Join(params, ", ") + ") " + ret
 	}
+	if ta.IsTuple {
+		var elements []string
+		for _, el := range ta.Elements {
+			elements = append(elements, el.String())
+		}
+		return "(" + strings.Join(elements, ", ") + ")"
+	}
 	return ta.Name
 }
 
@@ -284,6 +379,26 @@ func (vs *VarStatement) String() string {
 	return out + ";"
 }
 
+// LookupStatement declares the element a map holds for a key and whether it
+// holds one: `var v, ok = m[k]`. Value is nil when the key is missing.
+type LookupStatement struct {
+	Token     lexer.Token // The 'var' or 'const' token
+	Value     *Identifier
+	Found     *Identifier
+	Index     *IndexExpression
+	IsMutable bool
+}
+
+func (ls *LookupStatement) statementNode()       {}
+func (ls *LookupStatement) TokenLiteral() string { return ls.Token.Literal }
+func (ls *LookupStatement) String() string {
+	keyword := "var"
+	if !ls.IsMutable {
+		keyword = "const"
+	}
+	return keyword + " " + ls.Value.String() + ", " + ls.Found.String() + " = " + ls.Index.String() + ";"
+}
+
 // AssignmentStatement represents an assignment
 type AssignmentStatement struct {
 	Token lexer.Token // The '=' token
@@ -357,6 +472,41 @@ func (fs *ForStatement) String() string {
 	return out
 }
 
+// ForInStatement represents a loop over the elements of an array:
+// for x in xs { ... }
+type ForInStatement struct {
+	Token    lexer.Token // The 'for' token
+	Variable *Identifier // Holds each element in turn
+	Iterable Expression
+	Body     *BlockStatement
+}
+
+func (fs *ForInStatement) statementNode()       {}
+func (fs *ForInStatement) TokenLiteral() string { return fs.Token.Literal }
+func (fs *ForInStatement) String() string {
+	return "for " + fs.Variable.String() + " in " + fs.Iterable.String() + " " + fs.Body.String()
+}
+
+// TryStatement runs Body, and if a runtime error stops it, runs Catch with
+// the error in Variable: `try { ... } catch e { ... }`. Variable is nil when
+// the catch doesn't name the error.
+type TryStatement struct {
+	Token    lexer.Token // The 'try' token
+	Body     *BlockStatement
+	Variable *Identifier
+	Catch    *BlockStatement
+}
+
+func (ts *TryStatement) statementNode()       {}
+func (ts *TryStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *TryStatement) String() string {
+	out := "try " + ts.Body.String() + " catch "
+	if ts.Variable != nil {
+		out += ts.Variable.String() + " "
+	}
+	return out + ts.Catch.String()
+}
+
 // ReturnStatement represents a return statement
 type ReturnStatement struct {
 	Token       lexer.Token // The 'return' token
@@ -416,6 +566,7 @@ type FunctionParameter struct {
 type FunctionStatement struct {
 	Token      lexer.Token // The 'func' token
 	Name       *Identifier
+	TypeParams []*Identifier // Type parameters of a generic function, as in func first<T>(arr: []T): T
 	Parameters []*FunctionParameter
 	ReturnType *TypeAnnotation
 	Body       *BlockStatement
@@ -428,7 +579,15 @@ func (fs *FunctionStatement) String() string {
 	for _, p := range fs.Parameters {
 		params = append(params, p.Name.String()+": "+p.Type.String())
 	}
-	out := "func " + fs.Name.String() + "(" + strings.Join(params, ", ") + ")"
+	out := "func " + fs.Name.String()
+	if len(fs.TypeParams) > 0 {
+		var typeParams []string
+		for _, t := range fs.TypeParams {
+			typeParams = append(typeParams, t.String())
+		}
+		out += "<" + strings.Join(typeParams, ", ") + ">"
+	}
+	out += "(" + strings.Join(params, ", ") + ")"
 	if fs.ReturnType != nil {
 		out += ": " + fs.ReturnType.String()
 	}
@@ -438,8 +597,9 @@ func (fs *FunctionStatement) String() string {
 
 // StructField represents a struct field
 type StructField struct {
-	Name *Identifier
-	Type *TypeAnnotation
+	Name    *Identifier
+	Type    *TypeAnnotation
+	Default Expression // Value of the field in literals that leave it out, nil if they must give it
 }
 
 // TypeStatement represents a type definition
@@ -467,24 +627,41 @@ func (ss *StructStatement) TokenLiteral() string { return ss.Token.Literal }
 func (ss *StructStatement) String() string {
 	var fields []string
 	for _, f := range ss.Fields {
-		fields = append(fields, f.Name.String()+": "+f.Type.String())
+		field := f.Name.String() + ": " + f.Type.String()
+		if f.Default != nil {
+			field += " = " + f.Default.String()
+		}
+		fields = append(fields, field)
 	}
 	return "struct " + ss.Name.String() + " {\n  " + strings.Join(fields, ";\n  ") + ";\n}"
 }
 
 // EnumStatement represents an enum declaration
 type EnumStatement struct {
-	Token    lexer.Token   // The 'enum' token
+	Token    lexer.Token // The 'enum' token
 	Name     *Identifier
-	Variants []*Identifier // List of enum variant names
+	Variants []*Identifier       // List of enum variant names
+	Values   []Expression        // Value given to each variant, nil where left out
+	Payloads [][]*TypeAnnotation // Types of the values each variant carries, nil where it carries none
 }
 
 func (es *EnumStatement) statementNode()       {}
 func (es *EnumStatement) TokenLiteral() string { return es.Token.Literal }
 func (es *EnumStatement) String() string {
 	var variants []string
-	for _, v := range es.Variants {
-		variants = append(variants, v.String())
+	for i, v := range es.Variants {
+		variant := v.String()
+		if i < len(es.Payloads) && es.Payloads[i] != nil {
+			var types []string
+			for _, t := range es.Payloads[i] {
+				types = append(types, t.String())
+			}
+			variant += "(" + strings.Join(types, ", ") + ")"
+		}
+		if i < len(es.Values) && es.Values[i] != nil {
+			variant += " = " + es.Values[i].String()
+		}
+		variants = append(variants, variant)
 	}
 	return "enum " + es.Name.String() + " { " + strings.Join(variants, ", ") + " }"
 }
@@ -521,3 +698,180 @@ type CaseClause struct {
 func (cc *CaseClause) String() string {
 	return "case " + cc.Value.String() + " " + cc.Body.String()
 }
+
+// StructPattern is a case value matching a struct of type Name whose listed
+// fields match their patterns: `case Point{x: 0, y: y}`. A pattern is a
+// literal, compared with ==, a name bound to the value, _ matching anything,
+// or a struct or array pattern.
+type StructPattern struct {
+	Token  lexer.Token // The struct name token
+	Name   *Identifier
+	Fields []*FieldPattern // In source order
+}
+
+func (sp *StructPattern) expressionNode()      {}
+func (sp *StructPattern) TokenLiteral() string { return sp.Token.Literal }
+func (sp *StructPattern) String() string {
+	var fields []string
+	for _, f := range sp.Fields {
+		fields = append(fields, f.Name.String()+": "+f.Pattern.String())
+	}
+	return sp.Name.String() + "{" + strings.Join(fields, ", ") + "}"
+}
+
+// FieldPattern is a field of a StructPattern and the pattern its value
+// matches
+type FieldPattern struct {
+	Name    *Identifier
+	Pattern Expression
+}
+
+// ArrayPattern is a case value matching an array of as many elements as it
+// lists, each matching its pattern: `case [first, _]`
+type ArrayPattern struct {
+	Token    lexer.Token // The '[' token
+	Elements []Expression
+}
+
+func (ap *ArrayPattern) expressionNode()      {}
+func (ap *ArrayPattern) TokenLiteral() string { return ap.Token.Literal }
+func (ap *ArrayPattern) String() string {
+	var elements []string
+	for _, e := range ap.Elements {
+		elements = append(elements, e.String())
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// IfExpression is an if used as a value: `var x = if c { 1 } else { 2 }`.
+// It has an else, and every branch is a block ending with an expression
+// statement giving the branch's value; an else if is a block holding the
+// nested IfExpression.
+type IfExpression struct {
+	*IfStatement
+}
+
+func (ie *IfExpression) expressionNode() {}
+
+// SwitchExpression is a switch used as a value. Every case, and the default
+// if there is one, ends with an expression statement giving its value.
+type SwitchExpression struct {
+	*SwitchStatement
+}
+
+func (se *SwitchExpression) expressionNode() {}
+
+// NodeToken returns the token a node was parsed from, which carries its
+// source line and column. ok is false for nodes without a token.
+func NodeToken(node Node) (tok lexer.Token, ok bool) {
+	switch n := node.(type) {
+	case *Identifier:
+		return n.Token, true
+	case *IntegerLiteral:
+		return n.Token, true
+	case *FloatLiteral:
+		return n.Token, true
+	case *StringLiteral:
+		return n.Token, true
+	case *BooleanLiteral:
+		return n.Token, true
+	case *NilLiteral:
+		return n.Token, true
+	case *PrefixExpression:
+		return n.Token, true
+	case *InfixExpression:
+		return n.Token, true
+	case *CallExpression:
+		return n.Token, true
+	case *IndexExpression:
+		return n.Token, true
+	case *SliceExpression:
+		return n.Token, true
+	case *FieldAccessExpression:
+		return n.Token, true
+	case *TupleElementExpression:
+		return n.Token, true
+	case *ArrayLiteral:
+		return n.Token, true
+	case *TupleLiteral:
+		return n.Token, true
+	case *MapLiteral:
+		return n.Token, true
+	case *StructLiteral:
+		return n.Token, true
+	case *NewExpression:
+		return n.Token, true
+	case *CastExpression:
+		return n.Token, true
+	case *VarStatement:
+		return n.Token, true
+	case *LookupStatement:
+		return n.Token, true
+	case *AssignmentStatement:
+		return n.Token, true
+	case *BlockStatement:
+		return n.Token, true
+	case *IfStatement:
+		return n.Token, true
+	case *ForStatement:
+		return n.Token, true
+	case *ForInStatement:
+		return n.Token, true
+	case *TryStatement:
+		return n.Token, true
+	case *ReturnStatement:
+		return n.Token, true
+	case *BreakStatement:
+		return n.Token, true
+	case *ContinueStatement:
+		return n.Token, true
+	case *ExpressionStatement:
+		return n.Token, true
+	case *FunctionStatement:
+		return n.Token, true
+	case *TypeStatement:
+		return n.Token, true
+	case *StructStatement:
+		return n.Token, true
+	case *EnumStatement:
+		return n.Token, true
+	case *SwitchStatement:
+		return n.Token, true
+	case *IfExpression:
+		return n.Token, true
+	case *SwitchExpression:
+		return n.Token, true
+	case *StructPattern:
+		return n.Token, true
+	case *ArrayPattern:
+		return n.Token, true
+	}
+	return lexer.Token{}, false
+}
+
+// StartNode returns the leftmost part of node, whose token is where node's
+// source text begins. Expression statements, assignments and operators carry
+// the token of their operator or end, not of their first token.
+func StartNode(node Node) Node {
+	switch n := node.(type) {
+	case *ExpressionStatement:
+		return StartNode(n.Expression)
+	case *AssignmentStatement:
+		return StartNode(n.Left)
+	case *InfixExpression:
+		return StartNode(n.Left)
+	case *CallExpression:
+		return StartNode(n.Function)
+	case *IndexExpression:
+		return StartNode(n.Left)
+	case *SliceExpression:
+		return StartNode(n.Left)
+	case *CastExpression:
+		return StartNode(n.Value)
+	case *TupleElementExpression:
+		return StartNode(n.Left)
+	case *FieldAccessExpression:
+		return StartNode(n.Left)
+	}
+	return node
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"minlang/conformance"
+	"os"
+	"strings"
+)
+
+func main() {
+	dir := flag.String("dir", "conformance", "Directory containing conformance programs")
+	output := flag.String("o", "", "Write the compatibility matrix to this file instead of stdout")
+	backends := flag.String("backends", "", "Comma-separated backends to run (default: all)")
+	verbose := flag.Bool("v", false, "Print details for every non-passing result")
+	flag.Parse()
+
+	cases, err := conformance.LoadCases(*dir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading conformance cases: %v\n", err)
+		os.Exit(1)
+	}
+	if len(cases) == 0 {
+		fmt.Fprintf(os.Stderr, "No conformance cases found in %s\n", *dir)
+		os.Exit(1)
+	}
+
+	selected := conformance.Backends
+	if *backends != "" {
+		selected = nil
+		for _, name := range strings.Split(*backends, ",") {
+			found := false
+			for _, b := range conformance.Backends {
+				if b.Name == strings.TrimSpace(name) {
+					selected = append(selected, b)
+					found = true
+				}
+			}
+			if !found {
+				fmt.Fprintf(os.Stderr, "Unknown backend: %s\n", name)
+				os.Exit(1)
+			}
+		}
+	}
+
+	matrix := conformance.RunAll(cases, selected)
+
+	out := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not create output file: %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	if err := matrix.WriteMarkdown(out); err != nil {
+		fmt.Fprintf(os.Stderr, "Error writing matrix: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *verbose {
+		for i, c := range matrix.Cases {
+			for j, b := range matrix.Backends {
+				if r := matrix.Results[i][j]; r.Status != conformance.Pass {
+					fmt.Fprintf(os.Stderr, "%s [%s] %s: %s\n", c.Name, b.Name, r.Status, r.Detail)
+				}
+			}
+		}
+	}
+}
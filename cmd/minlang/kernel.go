@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"minlang/interp"
+	"minlang/lexer"
+	"minlang/parser"
+	"minlang/vm"
+	"strings"
+)
+
+// Notebook kernel
+//
+// "minlang kernel" runs code cells sent as JSON on stdin against one
+// persistent program, so a notebook front end can run a cell, see its
+// result, and build on it in the next. Each request is a JSON object:
+//
+//	{"id": 1, "code": "var x = 6 * 7\nprint(x)\nx + 1"}
+//	{"id": 2, "shutdown": true}
+//
+// and each gets a reply on its own line of stdout, with the cell's output
+// and the value and type of its last expression statement (nil if it has
+// none), or the error that stopped it:
+//
+//	{"id":1,"ok":true,"value":"43","type":"int","stdout":"42\n"}
+//	{"id":2,"ok":true,"stdout":""}
+//
+// Cells run on the tree-walking interpreter, which keeps the globals,
+// functions and types each cell defines for the cells after it; a cell that
+// fails keeps whatever it defined before failing. Shutting down runs the
+// hooks registered with onExit, replying with their output, and ends the
+// session; so does the end of the input, without a reply. A cell that calls
+// exit ends the session the same way, its reply giving the exit status,
+// which the kernel exits with:
+//
+//	{"id":3,"ok":false,"stdout":"bye\n","error":"exit status 3","exit":3}
+
+// kernelRequest is a request read by the kernel
+type kernelRequest struct {
+	ID       json.RawMessage `json:"id,omitempty"`
+	Code     string          `json:"code"`
+	Shutdown bool            `json:"shutdown,omitempty"`
+}
+
+// kernelReply answers a kernelRequest
+type kernelReply struct {
+	ID     json.RawMessage `json:"id,omitempty"`
+	OK     bool            `json:"ok"`
+	Value  string          `json:"value,omitempty"`
+	Type   string          `json:"type,omitempty"`
+	Stdout string          `json:"stdout"`
+	Error  string          `json:"error,omitempty"`
+	Exit   *int            `json:"exit,omitempty"` // Status of an exit that ended the session
+}
+
+// serveKernel answers the requests read from r on w until shutdown, exit or
+// the end of r, running cells on in. After an exit it returns what the
+// program ends with, see vm.FinishExit.
+func serveKernel(r io.Reader, w io.Writer, in *interp.Interpreter) error {
+	var stdout bytes.Buffer
+	in.SetStdout(&stdout)
+	// Requests arrive on stdin, so cells reading input get none
+	in.SetStdin(strings.NewReader(""))
+
+	dec := json.NewDecoder(r)
+	enc := json.NewEncoder(w)
+	for {
+		var req kernelRequest
+		err := dec.Decode(&req)
+		if errors.Is(err, io.EOF) {
+			return in.RunExitHooks()
+		}
+		if err != nil {
+			enc.Encode(kernelReply{Error: fmt.Sprintf("bad request: %v", err)})
+			return err
+		}
+
+		stdout.Reset()
+		var exit *vm.ExitError
+		exited := false
+		if req.Shutdown {
+			err = in.RunExitHooks()
+		} else if err = runCell(in, req.Code); errors.As(err, &exit) {
+			exited = true
+			err = vm.FinishExit(err, in.RunExitHooks)
+		}
+
+		reply := kernelReply{ID: req.ID, OK: err == nil, Stdout: stdout.String()}
+		if err != nil {
+			reply.Error = err.Error()
+		} else if !req.Shutdown && !exited {
+			result := in.LastValue()
+			reply.Value, reply.Type = result.String(), result.Type.String()
+		}
+		if exited {
+			status := 0
+			if errors.As(err, &exit) {
+				status = exit.Code
+			} else if err != nil {
+				status = 1
+			}
+			reply.Exit = &status
+		}
+		if encodeErr := enc.Encode(reply); encodeErr != nil {
+			return encodeErr
+		}
+		if req.Shutdown {
+			return nil
+		}
+		if exited {
+			return err
+		}
+	}
+}
+
+// runCell parses code and runs it as the next part of the program
+func runCell(in *interp.Interpreter, code string) error {
+	p := parser.New(lexer.New(code))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return fmt.Errorf("parse error: %s", strings.Join(p.Errors(), "; "))
+	}
+	return in.Continue(program)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"minlang/interp"
+	"minlang/vm"
+	"strings"
+	"testing"
+)
+
+func TestKernel(t *testing.T) {
+	requests := []string{
+		`{"id": 1, "code": "var x = 6 * 7\nprint(x)\nx + 1"}`,
+		`{"id": "two", "code": "func bye() { print(\"bye\", x) }\nonExit(bye)\nx = x * 2"}`,
+		`{"id": 3, "code": "var y = 1 +"}`,
+		`{"id": 4, "code": "print(\"before\")\nvar z = [1][5]"}`,
+		`{"id": 5, "code": "x / 2.0"}`,
+		`{"id": 6, "shutdown": true}`,
+		`{"id": 7, "code": "print(\"ignored\")"}`,
+	}
+
+	var out bytes.Buffer
+	if err := serveKernel(strings.NewReader(strings.Join(requests, "\n")), &out, interp.New()); err != nil {
+		t.Fatalf("kernel error: %s", err)
+	}
+
+	want := []kernelReply{
+		{ID: json.RawMessage(`1`), OK: true, Value: "43", Type: "int", Stdout: "42\n"},
+		{ID: json.RawMessage(`"two"`), OK: true, Value: "nil", Type: "nil"},
+		{ID: json.RawMessage(`3`), Error: "parse error: no prefix parse function for EOF"},
+		{ID: json.RawMessage(`4`), Stdout: "before\n", Error: "2:12: array index out of bounds: 5"},
+		{ID: json.RawMessage(`5`), OK: true, Value: "42.000000", Type: "float"},
+		{ID: json.RawMessage(`6`), OK: true, Stdout: "bye 84\n"},
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != len(want) {
+		t.Fatalf("expected %d replies, got %d:\n%s", len(want), len(lines), out.String())
+	}
+	for i, line := range lines {
+		var got kernelReply
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("reply %d: %s", i, err)
+		}
+		w := want[i]
+		if string(got.ID) != string(w.ID) || got.OK != w.OK || got.Value != w.Value || got.Type != w.Type ||
+			got.Stdout != w.Stdout || !strings.HasPrefix(got.Error, w.Error) || (w.Error == "") != (got.Error == "") {
+			t.Errorf("reply %d: expected %+v, got %s", i, w, line)
+		}
+	}
+}
+
+func TestKernelExit(t *testing.T) {
+	requests := []string{
+		`{"id": 1, "code": "func bye() { print(\"bye\") }\nonExit(bye)\nprint(\"hi\")"}`,
+		`{"id": 2, "code": "print(\"exiting\")\nexit(3)\nprint(\"not run\")"}`,
+		`{"id": 3, "code": "print(\"ignored\")"}`,
+	}
+
+	var out bytes.Buffer
+	err := serveKernel(strings.NewReader(strings.Join(requests, "\n")), &out, interp.New())
+	var exit *vm.ExitError
+	if !errors.As(err, &exit) || exit.Code != 3 {
+		t.Fatalf("expected exit status 3, got %v", err)
+	}
+
+	want := `{"id":1,"ok":true,"value":"nil","type":"nil","stdout":"hi\n"}
+{"id":2,"ok":false,"stdout":"exiting\nbye\n","error":"exit status 3","exit":3}
+`
+	if out.String() != want {
+		t.Errorf("expected replies:\n%s\ngot:\n%s", want, out.String())
+	}
+
+	// exit(0) ends the session without an error
+	out.Reset()
+	err = serveKernel(strings.NewReader(`{"id": 1, "code": "exit(0)"}`+"\n"+requests[2]), &out, interp.New())
+	if err != nil || out.String() != `{"id":1,"ok":true,"stdout":"","exit":0}`+"\n" {
+		t.Errorf("expected one reply with exit status 0, got %v and:\n%s", err, out.String())
+	}
+}
@@ -1,9 +1,12 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
+	"minlang/ast"
 	"minlang/compiler"
+	"minlang/interp"
 	"minlang/lexer"
 	"minlang/parser"
 	"minlang/vm"
@@ -14,19 +17,111 @@ import (
 
 func main() {
 	// Define flags
-	backend := flag.String("backend", "register", "VM backend: stack or register")
+	backend := flag.String("backend", "register", "Execution backend: stack, register or interp")
 	debug := flag.Bool("debug", false, "Print bytecode debug information")
 	cpuprofile := flag.String("cpuprofile", "", "Write CPU profile to file")
+	translate := flag.Bool("translate", false, "Register backend: run stack compiler output translated to register bytecode")
+	emit := flag.String("emit", "", "Compile to stack bytecode and write it to this .minb file instead of running")
+	callgraph := flag.String("callgraph", "", "Print the program's call graph in this format (dot or json) instead of running")
+	promoteIntDiv := flag.Bool("promote-int-div", false, "Make / between ints produce a float")
+	runtimeChecks := flag.Bool("runtime-checks", false, "Check function arguments and return values against their type annotations as the program runs")
+	int32Mode := flag.Bool("int32", false, "Make ints 32 bits wide, wrapping around on overflow")
+	checkOverflow := flag.Bool("check-overflow", false, "Stop with an error when int arithmetic overflows instead of wrapping around")
+	optimize := flag.Bool("optimize", true, "Apply peephole optimizations to stack bytecode")
+	warn := flag.Bool("warn", false, "Print compiler warnings, such as unreachable code, to stderr")
+	printResult := flag.Bool("print-result", false, "Print the program's result, the value of the last expression statement run outside functions, after running")
+	showTimings := flag.Bool("timings", false, "Print the time spent in each compilation phase to stderr")
+	history := flag.String("history", defaultHistoryFile(), "REPL: file to keep the lines entered in across sessions, empty for none")
+	transcript := flag.String("transcript", "", "REPL: file to append the session's input and output to")
 	flag.Parse()
 
-	if flag.NArg() < 1 {
-		fmt.Println("Usage: minlang [flags] <source-file>")
+	if *checkOverflow && *int32Mode {
+		fmt.Fprintln(os.Stderr, "-check-overflow can't be combined with -int32, whose ints wrap around")
+		os.Exit(1)
+	}
+
+	// The REPL, the kernel and the interp backend share the language options
+	// set on the command line
+	newInterpreter := func() *interp.Interpreter {
+		in := interp.New()
+		in.SetPromoteIntDiv(*promoteIntDiv)
+		in.SetRuntimeChecks(*runtimeChecks)
+		in.SetInt32(*int32Mode)
+		in.SetCheckOverflow(*checkOverflow)
+		return in
+	}
+
+	args := flag.Args()
+	// "minlang run <file>" is accepted as an alias for "minlang <file>"
+	if len(args) > 0 && args[0] == "run" {
+		args = args[1:]
+	}
+
+	// "minlang repl" runs code as it is typed, see repl.go
+	if len(args) > 0 && args[0] == "repl" {
+		in := newInterpreter()
+		if err := runREPL(in, *history, *transcript); err != nil {
+			exitIfRequested(err)
+			fmt.Fprintf(os.Stderr, "REPL error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// "minlang kernel" runs notebook cells sent on stdin, see kernel.go
+	if len(args) > 0 && args[0] == "kernel" {
+		in := newInterpreter()
+		if err := serveKernel(os.Stdin, os.Stdout, in); err != nil {
+			exitIfRequested(err)
+			fmt.Fprintf(os.Stderr, "Kernel error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// "minlang test" runs the tests in test_*.min files, see testrunner.go
+	if len(args) > 0 && args[0] == "test" {
+		paths := args[1:]
+		if len(paths) == 0 {
+			paths = []string{"."}
+		}
+		files, err := findTestFiles(paths)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error finding tests: %v\n", err)
+			os.Exit(1)
+		}
+		if len(files) == 0 {
+			fmt.Println("no test files")
+			return
+		}
+		opts := testOptions{
+			backend:       *backend,
+			translate:     *translate,
+			promoteIntDiv: *promoteIntDiv,
+			runtimeChecks: *runtimeChecks,
+			int32Mode:     *int32Mode,
+			checkOverflow: *checkOverflow,
+			optimize:      *optimize,
+		}
+		if _, failed := runTests(files, opts, os.Stdout); failed > 0 {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if len(args) < 1 {
+		fmt.Println("Usage: minlang [flags] [run] <source-file | bytecode.minb> [args...]")
+		fmt.Println("       minlang [flags] repl")
+		fmt.Println("       minlang [flags] test [paths...]")
+		fmt.Println("       minlang [flags] kernel")
 		fmt.Println("Flags:")
 		flag.PrintDefaults()
 		os.Exit(1)
 	}
 
-	sourceFile := flag.Arg(0)
+	sourceFile := args[0]
+	// The rest are the program's own, for args()
+	programArgs := vm.WithArgs(args[1:])
 
 	// Start CPU profiling if requested
 	if *cpuprofile != "" {
@@ -43,6 +138,24 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
+	// Precompiled bytecode skips lex/parse/compile and always runs on the stack VM
+	if strings.HasSuffix(sourceFile, ".minb") {
+		f, err := os.Open(sourceFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error reading file: %v\n", err)
+			os.Exit(1)
+		}
+		bytecode, err := vm.ReadBytecode(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading bytecode: %v\n", err)
+			os.Exit(1)
+		}
+		// The original source file name isn't stored, so errors report positions only
+		runStack(bytecode, "", *debug, *printResult, programArgs)
+		return
+	}
+
 	// Read source file
 	source, err := os.ReadFile(sourceFile)
 	if err != nil {
@@ -50,12 +163,22 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Each phase is timed for -timings
+	timings := &phaseTimings{}
+	reportTimings := func() {
+		if *showTimings {
+			timings.report(os.Stderr)
+		}
+	}
+
 	// Lex
-	l := lexer.New(string(source))
+	tokens := lexer.NewTokenStream(lexer.New(string(source)))
+	timings.measure("lex", tokens.ReadAll)
 
 	// Parse
-	p := parser.New(l)
-	program := p.ParseProgram()
+	p := parser.NewFromTokens(tokens)
+	var program *ast.Program
+	timings.measure("parse", func() { program = p.ParseProgram() })
 
 	if len(p.Errors()) > 0 {
 		fmt.Fprintln(os.Stderr, "Parser errors:")
@@ -65,79 +188,281 @@ func main() {
 		os.Exit(1)
 	}
 
+	// All compilers share the language options set on the command line
+	newCompiler := func() *compiler.Compiler {
+		c := compiler.New()
+		c.SetPromoteIntDiv(*promoteIntDiv)
+		c.SetRuntimeChecks(*runtimeChecks)
+		c.SetInt32(*int32Mode)
+		c.SetCheckOverflow(*checkOverflow)
+		return c
+	}
+
+	// reportWarnings prints the compiler's warnings if -warn is set
+	reportWarnings := func(warnings []string) {
+		if !*warn {
+			return
+		}
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "warning: %s:%s\n", sourceFile, w)
+		}
+	}
+
+	// compileStack compiles the program for the stack VM. Type checking
+	// runs first, as part of compiling, so it is timed along with it.
+	compileStack := func(c *compiler.Compiler) (err error) {
+		timings.measure("compile (stack)", func() { err = c.Compile(program) })
+		return err
+	}
+
+	// stackBytecode returns the compiled program, optimized unless disabled
+	stackBytecode := func(c *compiler.Compiler) *vm.Bytecode {
+		reportWarnings(c.Warnings())
+		bytecode := c.Bytecode()
+		if *optimize {
+			timings.measure("optimize", func() { bytecode = vm.Optimize(bytecode) })
+		}
+		return bytecode
+	}
+
+	// Print the call graph instead of running
+	if *callgraph != "" {
+		c := newCompiler()
+		c.SetCallGraph(true)
+		if err := compileStack(c); err != nil {
+			reportCompileError(err, sourceFile)
+			os.Exit(1)
+		}
+
+		graph := c.CallGraph()
+		switch *callgraph {
+		case "dot":
+			err = graph.WriteDOT(os.Stdout)
+		case "json":
+			err = graph.WriteJSON(os.Stdout)
+		default:
+			err = fmt.Errorf("unknown format %q (want dot or json)", *callgraph)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing call graph: %v\n", err)
+			os.Exit(1)
+		}
+		reportTimings()
+		return
+	}
+
+	// Emit serialized stack bytecode instead of running
+	if *emit != "" {
+		c := newCompiler()
+		if err := compileStack(c); err != nil {
+			reportCompileError(err, sourceFile)
+			os.Exit(1)
+		}
+
+		f, err := os.Create(*emit)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Could not create output file: %v\n", err)
+			os.Exit(1)
+		}
+		if err := vm.WriteBytecode(f, stackBytecode(c)); err != nil {
+			f.Close()
+			fmt.Fprintf(os.Stderr, "Error writing bytecode: %v\n", err)
+			os.Exit(1)
+		}
+		if err := f.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error writing bytecode: %v\n", err)
+			os.Exit(1)
+		}
+		reportTimings()
+		return
+	}
+
 	// Compile and run based on backend choice
-	if *backend == "register" {
-		// Register backend
-		rc := compiler.NewRegisterCompiler()
-		_, err = rc.CompileToRegister(program)
+	if *backend == "interp" {
+		// Tree-walking interpreter (no compilation step)
+		in := newInterpreter()
+		in.SetArgs(args[1:])
+		reportTimings()
+		err := in.Run(program)
+		reportWarnings(in.Warnings())
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Register compilation error: %v\n", err)
+			exitIfRequested(err)
+			var compileErr *interp.CompileError
+			if errors.As(err, &compileErr) {
+				reportCompileError(compileErr.Err, sourceFile)
+			} else {
+				reportRuntimeError("Runtime error", err, sourceFile)
+			}
 			os.Exit(1)
 		}
 
-		registerBytecode := rc.RegisterBytecode()
+		if *printResult {
+			fmt.Println(in.LastValue().String())
+		}
+	} else if *backend == "register" {
+		// Register backend
+		var registerBytecode *vm.RegisterBytecode
+		rc := compiler.NewRegisterCompiler()
+		rc.SetPromoteIntDiv(*promoteIntDiv)
+		rc.SetRuntimeChecks(*runtimeChecks)
+		rc.SetInt32(*int32Mode)
+		rc.SetCheckOverflow(*checkOverflow)
+		if !*translate {
+			timings.measure("compile (register)", func() { _, err = rc.CompileToRegister(program) })
+			if err == nil {
+				timings.measure("link", func() { registerBytecode = rc.RegisterBytecode() })
+				reportWarnings(rc.Warnings())
+			} else if *debug {
+				fmt.Printf("Register compiler: %v (falling back to translated stack bytecode)\n", err)
+			}
+		}
+
+		// Translate stack bytecode for programs the register compiler can't handle yet
+		if registerBytecode == nil {
+			c := newCompiler()
+			if err := compileStack(c); err != nil {
+				reportCompileError(err, sourceFile)
+				os.Exit(1)
+			}
+			bytecode := stackBytecode(c)
+			timings.measure("translate", func() { registerBytecode, err = vm.TranslateToRegister(bytecode) })
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Register translation error: %v\n", err)
+				os.Exit(1)
+			}
+		}
 
 		if *debug {
 			fmt.Println("=== Register Bytecode Debug ===")
 			fmt.Printf("Total constants: %d\n", len(registerBytecode.Constants))
-			fmt.Printf("Max registers used: %d\n", rc.MaxRegs)
+			fmt.Printf("Max registers used: %d\n", registerBytecode.MainFunction.NumLocals)
 			fmt.Printf("Total instructions: %d\n", len(registerBytecode.Instructions))
-			fmt.Println()
+			for i, constant := range registerBytecode.Constants {
+				if constant.Type != vm.FunctionType {
+					continue
+				}
+				fn := constant.AsFunction()
+				fmt.Printf("\nConstant %d: [Function: %s params=%d registers=%d]\n", i, fn.Name, fn.NumParams, fn.NumLocals)
+				for _, line := range strings.Split(vm.RegisterDisassemble(fn.RegisterInstructions), "\n") {
+					if line != "" {
+						fmt.Println("   ", line)
+					}
+				}
+			}
+			fmt.Println("\n=== Main Register Bytecode ===")
+			fmt.Println(vm.RegisterDisassemble(registerBytecode.Instructions))
 		}
 
+		reportTimings()
+
 		// Run register VM
-		regVM := vm.NewRegisterVM(registerBytecode)
+		regVM := vm.NewRegisterVM(registerBytecode, programArgs)
 		err = regVM.Run()
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Register VM runtime error: %v\n", err)
+			exitIfRequested(err)
+			reportRuntimeError("Register VM runtime error", err, sourceFile)
 			os.Exit(1)
 		}
 
+		if *printResult {
+			fmt.Println(regVM.LastValue().String())
+		}
+
 	} else {
 		// Stack backend (default)
-		c := compiler.New()
-		err = c.Compile(program)
+		c := newCompiler()
+		err = compileStack(c)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Compilation error: %v\n", err)
+			reportCompileError(err, sourceFile)
 			os.Exit(1)
 		}
 
-		bytecode := c.Bytecode()
+		bytecode := stackBytecode(c)
+		reportTimings()
+		runStack(bytecode, sourceFile, *debug, *printResult, programArgs)
+	}
+}
 
-		// Debug: print bytecode if --debug flag is present
-		if *debug {
-			fmt.Println("=== Stack Bytecode Debug ===")
-			fmt.Printf("Total constants: %d\n", len(bytecode.Constants))
-			for i, constant := range bytecode.Constants {
-				fmt.Printf("Constant %d: Type=%d", i, constant.Type)
-				if constant.Type == 7 { // FunctionType
-					fn := constant.AsFunction()
-					fmt.Printf(" [Function: %s params=%d locals=%d]\n", fn.Name, fn.NumParams, fn.NumLocals)
-					fmt.Println("  Function bytecode:")
-					for _, line := range strings.Split(vm.Disassemble(fn.Instructions), "\n") {
-						if line != "" {
-							fmt.Println("   ", line)
-						}
+// runStack executes bytecode on the stack VM, printing the final result if
+// printResult is set. sourceFile names the program in runtime errors.
+func runStack(bytecode *vm.Bytecode, sourceFile string, debug, printResult bool, opts ...vm.Option) {
+	// Debug: print bytecode if --debug flag is present
+	if debug {
+		fmt.Println("=== Stack Bytecode Debug ===")
+		fmt.Printf("Total constants: %d\n", len(bytecode.Constants))
+		for i, constant := range bytecode.Constants {
+			fmt.Printf("Constant %d: Type=%d", i, constant.Type)
+			if constant.Type == 7 { // FunctionType
+				fn := constant.AsFunction()
+				fmt.Printf(" [Function: %s params=%d locals=%d]\n", fn.Name, fn.NumParams, fn.NumLocals)
+				fmt.Println("  Function bytecode:")
+				for _, line := range strings.Split(vm.Disassemble(fn.Instructions), "\n") {
+					if line != "" {
+						fmt.Println("   ", line)
 					}
-				} else {
-					fmt.Printf(" Value=%v\n", constant)
 				}
+			} else {
+				fmt.Printf(" Value=%v\n", constant)
 			}
-			fmt.Println("\n=== Main Bytecode ===")
-			fmt.Println(vm.Disassemble(bytecode.Instructions))
-			fmt.Println()
 		}
+		fmt.Println("\n=== Main Bytecode ===")
+		fmt.Println(vm.Disassemble(bytecode.Instructions))
+		fmt.Println()
+	}
 
-		// Run stack VM
-		machine := vm.New(bytecode)
-		err = machine.Run()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Runtime error: %v\n", err)
-			os.Exit(1)
+	// Run stack VM
+	machine := vm.New(bytecode, opts...)
+	err := machine.Run()
+	if err != nil {
+		exitIfRequested(err)
+		reportRuntimeError("Runtime error", err, sourceFile)
+		os.Exit(1)
+	}
+
+	if printResult {
+		fmt.Println(machine.LastValue().String())
+	}
+}
+
+// exitIfRequested ends the process with the status the program passed to
+// exit, if err is how it ended
+func exitIfRequested(err error) {
+	var exit *vm.ExitError
+	if errors.As(err, &exit) {
+		os.Exit(exit.Code)
+	}
+}
+
+// reportCompileError prints err, an error compiling sourceFile. A program
+// the type checker rejects gets a line for each error it found, listed like
+// parser errors.
+func reportCompileError(err error, sourceFile string) {
+	var typeErrors compiler.TypeErrors
+	if !errors.As(err, &typeErrors) {
+		fmt.Fprintf(os.Stderr, "Compilation error: %v\n", err)
+		return
+	}
+	fmt.Fprintln(os.Stderr, "Type errors:")
+	for _, msg := range typeErrors {
+		fmt.Fprintf(os.Stderr, "\t%s:%s\n", sourceFile, msg)
+	}
+}
+
+// reportRuntimeError prints a runtime error with its source position and,
+// when it happened inside a function call, the call stack
+func reportRuntimeError(prefix string, err error, sourceFile string) {
+	// Exit hooks that fail are reported one by one
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			reportRuntimeError(prefix, err, sourceFile)
 		}
+		return
+	}
+	err = vm.WithSourceFile(err, sourceFile)
+	fmt.Fprintf(os.Stderr, "%s: %v\n", prefix, err)
 
-		// Print result
-		result := machine.LastPoppedStackElem()
-		fmt.Println(result.String())
+	var runtimeErr *vm.RuntimeError
+	if errors.As(err, &runtimeErr) && len(runtimeErr.Trace) > 1 {
+		fmt.Fprint(os.Stderr, runtimeErr.StackTrace())
 	}
 }
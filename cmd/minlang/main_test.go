@@ -0,0 +1,79 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain runs main instead of the tests when MINLANG_MAIN is set, so tests
+// can run the command in a child process
+func TestMain(m *testing.M) {
+	if os.Getenv("MINLANG_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runMain runs the command with args, reading input, and returns its
+// standard output
+func runMain(t *testing.T, input string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "MINLANG_MAIN=1")
+	cmd.Stdin = strings.NewReader(input)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("minlang %v: %v", args, err)
+	}
+	return string(out)
+}
+
+func TestPrintResult(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{"1 + 2; var x = 5;", "3\n"},
+		{"var x = 5; x = 7;", "nil\n"},
+		{"var x = 5; x++", "nil\n"},
+		{"print(\"hi\")\n\"done\"", "hi\ndone\n"},
+		{"func f(n: int): int {\n    n * 2\n    return n + 1\n}\nf(4)\nvar y = f(1)", "5\n"},
+		{"for i in range(3) {\n    i * 10\n}", "20\n"},
+	}
+
+	backends := [][]string{
+		{"-backend", "stack"},
+		{"-backend", "stack", "-optimize=false"},
+		{"-backend", "register"},
+		{"-backend", "register", "-translate"},
+		{"-backend", "interp"},
+	}
+	for i, tt := range tests {
+		file := filepath.Join(t.TempDir(), "program.min")
+		if err := os.WriteFile(file, []byte(tt.source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		for _, backend := range backends {
+			args := append(append([]string{"-print-result"}, backend...), file)
+			if got := runMain(t, "", args...); got != tt.expected {
+				t.Errorf("case %d, %v: expected %q, got %q", i, backend, tt.expected, got)
+			}
+		}
+	}
+}
+
+// TestREPLOptions checks that the REPL runs with the language options given
+// as flags
+func TestREPLOptions(t *testing.T) {
+	input := "9223372036854775807 + 1\n7 / 2\n"
+	out := runMain(t, input, "-check-overflow", "-promote-int-div", "-history", "", "repl")
+	for _, want := range []string{"integer overflow: 9223372036854775807 + 1", "3.5"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in the output, got %q", want, out)
+		}
+	}
+}
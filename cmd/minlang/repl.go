@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"minlang/ast"
+	"minlang/interp"
+	"minlang/lexer"
+	"minlang/parser"
+	"minlang/vm"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// REPL
+//
+// "minlang repl" reads code a line at a time and runs each entry as the next
+// part of one program on the tree-walking interpreter, printing the value of
+// an entry that ends in an expression. A line that leaves a string, a block
+// comment or a bracket open continues on the next, after a "..." prompt.
+// Lines starting with a colon are commands:
+//
+//	:save FILE   write the session's definitions to FILE as a script
+//	:history     list the lines entered, in earlier sessions and this one
+//	:quit        run the onExit hooks and leave, as the end of input does
+//
+// An entry that calls exit leaves too, after the onExit hooks, and the REPL
+// exits with its status.
+//
+// Every line entered is appended to the history file (-history) as it is
+// read, so the history outlives the session. With -transcript, the session
+// is also appended to a file as it appears on the terminal: prompts, input,
+// output and errors.
+//
+// The REPL keeps the AST of each entry that ran without an error. :save
+// writes out its definitions - var and const declarations, functions and
+// types - in the order they ran, each as the source text its statement was
+// parsed from, so running the script rebuilds what the session defined.
+// Other statements are left out, since the script would repeat their
+// effects.
+
+// repl runs the entries read from a terminal on one interpreter
+type repl struct {
+	in          *interp.Interpreter
+	out         io.Writer // Prompts, output, values and errors, also sent to the transcript
+	transcript  io.Writer // Where input is copied, nil for none
+	history     []string  // Lines entered, earlier sessions' first
+	historyFile string    // Appended to as lines are entered, "" for none
+	definitions []string  // Source of each definition run, for :save
+}
+
+// defaultHistoryFile is where the REPL keeps its history unless -history
+// says otherwise: .minlang_history in the home directory
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".minlang_history")
+}
+
+// newREPL creates a REPL running entries on in and writing to out, and to
+// transcript if it isn't nil. It loads the history kept in historyFile, if
+// there is one.
+func newREPL(in *interp.Interpreter, out, transcript io.Writer, historyFile string) (*repl, error) {
+	r := &repl{in: in, out: out, transcript: transcript, historyFile: historyFile}
+	if transcript != nil {
+		r.out = io.MultiWriter(out, transcript)
+	}
+	in.SetStdout(r.out)
+
+	if historyFile != "" {
+		data, err := os.ReadFile(historyFile)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if len(data) > 0 {
+			r.history = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+		}
+	}
+	return r, nil
+}
+
+// run reads and runs entries from input until :quit, exit or the end of
+// input, then runs the onExit hooks. After an exit it returns what the
+// program ends with, see vm.FinishExit.
+func (r *repl) run(input io.Reader) error {
+	scanner := bufio.NewScanner(input)
+	var entry strings.Builder
+	for {
+		if entry.Len() == 0 {
+			fmt.Fprint(r.out, "> ")
+		} else {
+			fmt.Fprint(r.out, "... ")
+		}
+		if !scanner.Scan() {
+			fmt.Fprintln(r.out)
+			break
+		}
+		line := scanner.Text()
+		if r.transcript != nil {
+			fmt.Fprintln(r.transcript, line)
+		}
+		if strings.TrimSpace(line) == "" && entry.Len() == 0 {
+			continue
+		}
+		if err := r.remember(line); err != nil {
+			return err
+		}
+
+		if entry.Len() == 0 && strings.HasPrefix(strings.TrimSpace(line), ":") {
+			if quit := r.command(strings.Fields(line)); quit {
+				break
+			}
+			continue
+		}
+
+		entry.WriteString(line)
+		entry.WriteByte('\n')
+		if incomplete(entry.String()) {
+			continue
+		}
+		if exited, err := r.runEntry(entry.String()); exited {
+			return err
+		}
+		entry.Reset()
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return r.in.RunExitHooks()
+}
+
+// incomplete reports whether source ends inside a string, a block comment or
+// brackets, so the entry goes on to the next line
+func incomplete(source string) bool {
+	l := lexer.New(source)
+	for l.NextToken().Type != lexer.EOF {
+	}
+	return l.State().Incomplete()
+}
+
+// remember adds line to the history, and to the history file if there is one
+func (r *repl) remember(line string) error {
+	r.history = append(r.history, line)
+	if r.historyFile == "" {
+		return nil
+	}
+	f, err := os.OpenFile(r.historyFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(f, line)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// command carries out a colon command, split into fields, reporting whether
+// it ends the session
+func (r *repl) command(fields []string) (quit bool) {
+	switch {
+	case fields[0] == ":quit":
+		return true
+	case fields[0] == ":history":
+		for i, line := range r.history {
+			fmt.Fprintf(r.out, "%5d  %s\n", i+1, line)
+		}
+	case fields[0] == ":save" && len(fields) == 2:
+		if err := r.save(fields[1]); err != nil {
+			fmt.Fprintf(r.out, "error: %v\n", err)
+		}
+	default:
+		fmt.Fprintln(r.out, "commands: :save FILE, :history, :quit")
+	}
+	return false
+}
+
+// runEntry parses and runs source, a complete entry, printing the value of
+// its last statement if that is an expression with a value other than nil.
+// If the entry calls exit, it runs the onExit hooks and reports that the
+// session is over, with what the program ends with.
+func (r *repl) runEntry(source string) (exited bool, err error) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		for _, msg := range p.Errors() {
+			fmt.Fprintf(r.out, "parse error: %s\n", msg)
+		}
+		return false, nil
+	}
+	if err := r.in.Continue(program); err != nil {
+		var exit *vm.ExitError
+		if errors.As(err, &exit) {
+			return true, vm.FinishExit(err, r.in.RunExitHooks)
+		}
+		fmt.Fprintf(r.out, "error: %v\n", err)
+		return false, nil
+	}
+	r.definitions = append(r.definitions, definitions(source, program)...)
+
+	if len(program.Statements) == 0 {
+		return false, nil
+	}
+	if _, ok := program.Statements[len(program.Statements)-1].(*ast.ExpressionStatement); ok {
+		if value := r.in.LastValue(); value.Type != vm.NilType {
+			fmt.Fprintln(r.out, value.String())
+		}
+	}
+	return false, nil
+}
+
+// save writes the definitions run so far to path, one after another
+func (r *repl) save(path string) error {
+	var script strings.Builder
+	for _, def := range r.definitions {
+		script.WriteString(def)
+		script.WriteByte('\n')
+	}
+	if err := os.WriteFile(path, []byte(script.String()), 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(r.out, "saved %d definitions to %s\n", len(r.definitions), path)
+	return nil
+}
+
+// definitions returns the source text of the top-level statements of
+// program, parsed from source, that declare variables, constants, functions
+// or types. Each runs from the token it starts with to where the next
+// statement starts.
+func definitions(source string, program *ast.Program) []string {
+	// Offset of the start of each line, for the tokens' positions
+	lineStarts := []int{0}
+	for i := 0; i < len(source); i++ {
+		if source[i] == '\n' {
+			lineStarts = append(lineStarts, i+1)
+		}
+	}
+	start := func(stmt ast.Statement) (lexer.Token, int) {
+		tok, _ := ast.NodeToken(ast.StartNode(stmt))
+		return tok, lineStarts[tok.Line-1] + tok.Column - 1
+	}
+
+	var defs []string
+	for i, stmt := range program.Statements {
+		tok, from := start(stmt)
+		switch stmt.(type) {
+		case *ast.ExpressionStatement, *ast.AssignmentStatement:
+			continue
+		}
+		switch tok.Type {
+		case lexer.VAR, lexer.CONST, lexer.FUNC, lexer.TYPE, lexer.STRUCT, lexer.ENUM:
+		default:
+			continue
+		}
+		to := len(source)
+		if i+1 < len(program.Statements) {
+			_, to = start(program.Statements[i+1])
+		}
+		defs = append(defs, strings.TrimSpace(source[from:to]))
+	}
+	return defs
+}
+
+// runREPL runs a REPL on in over stdin and stdout, keeping its history in
+// historyFile and appending the session to transcriptFile, either of which
+// may be empty for none
+func runREPL(in *interp.Interpreter, historyFile, transcriptFile string) error {
+	var transcript io.Writer
+	if transcriptFile != "" {
+		f, err := os.OpenFile(transcriptFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		transcript = f
+	}
+	r, err := newREPL(in, os.Stdout, transcript, historyFile)
+	if err != nil {
+		return err
+	}
+	return r.run(os.Stdin)
+}
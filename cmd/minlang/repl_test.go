@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"minlang/interp"
+	"minlang/vm"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestREPL(t *testing.T) {
+	dir := t.TempDir()
+	historyFile := filepath.Join(dir, "history")
+	if err := os.WriteFile(historyFile, []byte("print(\"earlier\")\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(dir, "session.min")
+
+	input := strings.Join([]string{
+		`var x = 6 * 7`,
+		`x + 1`,
+		`func double(n: int): int {`,
+		`    return n * 2`,
+		`}`,
+		`print(double(x)); x = 1`,
+		`"s"; const LIMIT = 3; "t"`,
+		`var y = 1 +`,
+		`var z = [1][5]`,
+		`:history`,
+		`:save ` + script,
+		`:quit`,
+		`print("not run")`,
+	}, "\n")
+
+	var out, transcript bytes.Buffer
+	r, err := newREPL(interp.New(), &out, &transcript, historyFile)
+	if err != nil {
+		t.Fatalf("REPL error: %s", err)
+	}
+	if err := r.run(strings.NewReader(input)); err != nil {
+		t.Fatalf("REPL error: %s", err)
+	}
+
+	want := `> > 43
+> ... ... > 84
+> t
+> parse error: no prefix parse function for EOF found at line 2, column 1
+> error: 1:12: array index out of bounds: 5
+>     1  print("earlier")
+    2  var x = 6 * 7
+    3  x + 1
+    4  func double(n: int): int {
+    5      return n * 2
+    6  }
+    7  print(double(x)); x = 1
+    8  "s"; const LIMIT = 3; "t"
+    9  var y = 1 +
+   10  var z = [1][5]
+   11  :history
+> saved 3 definitions to ` + script + `
+> `
+	if out.String() != want {
+		t.Errorf("expected output:\n%s\ngot:\n%s", want, out.String())
+	}
+	if !strings.HasPrefix(transcript.String(), "> var x = 6 * 7\n> x + 1\n43\n") {
+		t.Errorf("expected the transcript to show input and output, got:\n%s", transcript.String())
+	}
+
+	history, err := os.ReadFile(historyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(history), "var z = [1][5]\n:history\n:save "+script+"\n:quit\n") {
+		t.Errorf("expected the lines entered in the history file, got:\n%s", history)
+	}
+
+	saved, err := os.ReadFile(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantScript := "var x = 6 * 7\nfunc double(n: int): int {\n    return n * 2\n}\nconst LIMIT = 3;\n"
+	if string(saved) != wantScript {
+		t.Errorf("expected the script:\n%s\ngot:\n%s", wantScript, saved)
+	}
+
+	// The script runs on its own
+	var rerun bytes.Buffer
+	r, err = newREPL(interp.New(), &rerun, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.run(strings.NewReader(string(saved) + "print(double(x), LIMIT)\n")); err != nil {
+		t.Fatalf("REPL error: %s", err)
+	}
+	if !strings.Contains(rerun.String(), "84 3\n") {
+		t.Errorf("expected the saved script to define x, double and LIMIT, got:\n%s", rerun.String())
+	}
+}
+
+func TestREPLExit(t *testing.T) {
+	input := "func bye() { print(\"bye\") }\nonExit(bye)\nexit(2)\nprint(\"not run\")\n"
+
+	var out bytes.Buffer
+	r, err := newREPL(interp.New(), &out, nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.run(strings.NewReader(input))
+	var exit *vm.ExitError
+	if !errors.As(err, &exit) || exit.Code != 2 {
+		t.Errorf("expected exit status 2, got %v", err)
+	}
+	if out.String() != "> > > bye\n" {
+		t.Errorf("expected the session to end after the hooks, got:\n%s", out.String())
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"minlang/ast"
+	"minlang/compiler"
+	"minlang/interp"
+	"minlang/lexer"
+	"minlang/parser"
+	"minlang/vm"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Test runner
+//
+// "minlang test [paths...]" runs the tests in the test_*.min files under
+// each path, a file or a directory searched recursively, or under the
+// current directory if no path is given. A test is a top-level function
+// whose name starts with test_ and which takes no arguments. It passes if it
+// returns and fails with the runtime error that stops it, typically from
+// assert(cond, msg):
+//
+//	PASS test_add (math/test_math.min)
+//	FAIL test_max (math/test_math.min)
+//	    math/test_math.min:9:5: assertion failed: max(2, 3) should be 3
+//	1 passed, 1 failed
+//
+// Each test runs as a program of its own, the file's top-level statements
+// followed by a call to the test, so no test sees what another changed. A
+// file that doesn't compile fails as a whole. The runner exits with status 1
+// if anything failed.
+
+// testOptions are the command-line settings tests run with
+type testOptions struct {
+	backend       string
+	translate     bool
+	promoteIntDiv bool
+	runtimeChecks bool
+	int32Mode     bool
+	checkOverflow bool
+	optimize      bool
+}
+
+// findTestFiles returns the test_*.min files under paths, in lexical order
+// within each
+func findTestFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			name := d.Name()
+			if !d.IsDir() && strings.HasPrefix(name, "test_") && strings.HasSuffix(name, ".min") {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// testFunctions returns the names of the tests program defines, in order
+func testFunctions(program *ast.Program) []string {
+	var names []string
+	for _, s := range program.Statements {
+		fn, ok := s.(*ast.FunctionStatement)
+		if ok && strings.HasPrefix(fn.Name.Value, "test_") {
+			names = append(names, fn.Name.Value)
+		}
+	}
+	return names
+}
+
+// parseSource parses a whole program
+func parseSource(source string) (*ast.Program, error) {
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return nil, fmt.Errorf("parse error: %s", strings.Join(p.Errors(), "; "))
+	}
+	return program, nil
+}
+
+// runTests runs the tests in files, reporting them on out, and returns how
+// many passed and failed
+func runTests(files []string, opts testOptions, out io.Writer) (passed, failed int) {
+	for _, file := range files {
+		p, f := runTestFile(file, opts, out)
+		passed += p
+		failed += f
+	}
+	fmt.Fprintf(out, "%d passed, %d failed\n", passed, failed)
+	return passed, failed
+}
+
+// runTestFile runs the tests in file, reporting them on out, and returns how
+// many passed and failed
+func runTestFile(file string, opts testOptions, out io.Writer) (passed, failed int) {
+	source, err := os.ReadFile(file)
+	if err != nil {
+		fmt.Fprintf(out, "FAIL %s\n    %v\n", file, err)
+		return 0, 1
+	}
+	program, err := parseSource(string(source))
+	if err != nil {
+		fmt.Fprintf(out, "FAIL %s\n    %v\n", file, err)
+		return 0, 1
+	}
+
+	for _, name := range testFunctions(program) {
+		if err := runTest(string(source), name, opts); err != nil {
+			fmt.Fprintf(out, "FAIL %s (%s)\n    %v\n", name, file, vm.WithSourceFile(err, file))
+			failed++
+			continue
+		}
+		fmt.Fprintf(out, "PASS %s (%s)\n", name, file)
+		passed++
+	}
+	return passed, failed
+}
+
+// runTest runs the test name in source, returning the error that failed it
+func runTest(source, name string, opts testOptions) error {
+	// A fresh AST for each run, since compiling may annotate it
+	program, err := parseSource(source)
+	if err != nil {
+		return err
+	}
+	for _, s := range program.Statements {
+		if fn, ok := s.(*ast.FunctionStatement); ok && fn.Name.Value == name && len(fn.Parameters) > 0 {
+			return fmt.Errorf("test %s must take no arguments", name)
+		}
+	}
+
+	call := &ast.CallExpression{
+		Token:    lexer.Token{Type: lexer.LPAREN, Literal: "("},
+		Function: &ast.Identifier{Token: lexer.Token{Type: lexer.IDENT, Literal: name}, Value: name},
+	}
+	program.Statements = append(program.Statements, &ast.ExpressionStatement{Token: call.Token, Expression: call})
+	return runTestProgram(program, opts)
+}
+
+// runTestProgram compiles and runs program on the backend opts choose,
+// returning the error that stopped it
+func runTestProgram(program *ast.Program, opts testOptions) error {
+	if opts.backend == "interp" {
+		in := interp.New()
+		in.SetPromoteIntDiv(opts.promoteIntDiv)
+		in.SetRuntimeChecks(opts.runtimeChecks)
+		in.SetInt32(opts.int32Mode)
+		in.SetCheckOverflow(opts.checkOverflow)
+		return in.Run(program)
+	}
+
+	if opts.backend == "register" && !opts.translate {
+		rc := compiler.NewRegisterCompiler()
+		rc.SetPromoteIntDiv(opts.promoteIntDiv)
+		rc.SetRuntimeChecks(opts.runtimeChecks)
+		rc.SetInt32(opts.int32Mode)
+		rc.SetCheckOverflow(opts.checkOverflow)
+		// Programs the register compiler can't handle yet are translated below
+		if _, err := rc.CompileToRegister(program); err == nil {
+			return vm.NewRegisterVM(rc.RegisterBytecode()).Run()
+		}
+	}
+
+	c := compiler.New()
+	c.SetPromoteIntDiv(opts.promoteIntDiv)
+	c.SetRuntimeChecks(opts.runtimeChecks)
+	c.SetInt32(opts.int32Mode)
+	c.SetCheckOverflow(opts.checkOverflow)
+	if err := c.Compile(program); err != nil {
+		return fmt.Errorf("compilation error: %w", err)
+	}
+	bytecode := c.Bytecode()
+	if opts.optimize {
+		bytecode = vm.Optimize(bytecode)
+	}
+	if opts.backend != "register" {
+		return vm.New(bytecode).Run()
+	}
+	registerBytecode, err := vm.TranslateToRegister(bytecode)
+	if err != nil {
+		return fmt.Errorf("register translation error: %w", err)
+	}
+	return vm.NewRegisterVM(registerBytecode).Run()
+}
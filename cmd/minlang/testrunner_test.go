@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunTests(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"test_math.min": `var calls = 0
+func add(a: int, b: int): int {
+    calls = calls + 1
+    return a + b
+}
+func test_add() {
+    assert(add(1, 2) == 3, "1 + 2 should be 3")
+}
+func test_fails() {
+    assert(add(1, 1) == 3, "1 + 1 should be 3")
+}
+func test_fresh_globals() {
+    assert(calls == 0)
+}
+func helper() {}
+`,
+		"sub/test_args.min":  "func test_needs_args(n: int) {}\n",
+		"sub/test_parse.min": "func test_broken( {\n",
+		"sub/other.min":      "func test_not_run() { assert(false) }\n",
+	}
+	for name, source := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	found, err := findTestFiles([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join(dir, "sub/test_args.min"),
+		filepath.Join(dir, "sub/test_parse.min"),
+		filepath.Join(dir, "test_math.min"),
+	}
+	if len(found) != len(want) {
+		t.Fatalf("found %q, want %q", found, want)
+	}
+	for i := range want {
+		if found[i] != want[i] {
+			t.Fatalf("found %q, want %q", found, want)
+		}
+	}
+
+	for _, backend := range []string{"stack", "register", "interp"} {
+		var out bytes.Buffer
+		passed, failed := runTests(found, testOptions{backend: backend, optimize: true}, &out)
+		if passed != 2 || failed != 3 {
+			t.Errorf("%s: %d passed, %d failed, want 2 and 3:\n%s", backend, passed, failed, out.String())
+		}
+		for _, line := range []string{
+			"PASS test_add (" + want[2] + ")",
+			"FAIL test_fails (" + want[2] + ")",
+			"assertion failed: 1 + 1 should be 3",
+			"PASS test_fresh_globals (" + want[2] + ")",
+			"test test_needs_args must take no arguments",
+			"FAIL " + want[1] + "\n    parse error",
+			"2 passed, 3 failed",
+		} {
+			if !bytes.Contains(out.Bytes(), []byte(line)) {
+				t.Errorf("%s: output lacks %q:\n%s", backend, line, out.String())
+			}
+		}
+	}
+}
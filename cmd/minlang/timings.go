@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// phaseTimings records how long each compilation phase took, for -timings
+type phaseTimings struct {
+	phases []phaseTiming
+}
+
+type phaseTiming struct {
+	name     string
+	duration time.Duration
+}
+
+// measure runs fn and records its duration under name
+func (t *phaseTimings) measure(name string, fn func()) {
+	start := time.Now()
+	fn()
+	t.phases = append(t.phases, phaseTiming{name, time.Since(start)})
+}
+
+// report writes the recorded phases and their total in milliseconds
+func (t *phaseTimings) report(w io.Writer) {
+	var total time.Duration
+	fmt.Fprintln(w, "Compilation timings (ms):")
+	for _, phase := range t.phases {
+		fmt.Fprintf(w, "  %-20s %9.3f\n", phase.name, milliseconds(phase.duration))
+		total += phase.duration
+	}
+	fmt.Fprintf(w, "  %-20s %9.3f\n", "total", milliseconds(total))
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
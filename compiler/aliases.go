@@ -0,0 +1,84 @@
+package compiler
+
+import (
+	"minlang/ast"
+	"minlang/vm"
+)
+
+// Aliases of const containers
+//
+// Arrays, maps and structs are references: after var ys = xs, ys and xs are
+// the same array, and ys[0] = 1 changes xs too. const only fixes what a name
+// refers to, not the contents, so when xs is const a change through ys is
+// likely a surprise. The compiler warns about element and field assignments
+// through such an alias and about builtins that change it in place, pointing
+// at deepCopy for a copy that can change on its own.
+
+// mutatingBuiltins are the builtins that change their first argument
+var mutatingBuiltins = map[string]bool{
+	"delete": true, "pop": true, "insertAt": true, "removeAt": true,
+	"heapPush": true, "heapPop": true,
+}
+
+// trackAlias records whether the variable name, given value by a declaration
+// or assignment, refers to the container of a const, and which
+func (c *Compiler) trackAlias(name string, value ast.Expression) {
+	if ident, ok := value.(*ast.Identifier); ok && ident.Value != name {
+		if constName, ok := c.constAliases[ident.Value]; ok {
+			c.constAliases[name] = constName
+			return
+		}
+		if c.isConstContainer(ident.Value) {
+			c.constAliases[name] = ident.Value
+			return
+		}
+	}
+	if _, ok := c.constAliases[name]; ok {
+		delete(c.constAliases, name)
+	}
+}
+
+// isConstContainer reports whether name is a const holding an array, map or
+// struct
+func (c *Compiler) isConstContainer(name string) bool {
+	symbol, ok := c.symbolTable.Resolve(name)
+	if !ok || symbol.IsMutable || symbol.Scope == BuiltinScope {
+		return false
+	}
+	t, _ := c.lookupVarType(name)
+	return t == vm.ArrayType || t == vm.MapType || t == vm.StructType
+}
+
+// warnAliasChange warns about node, which changes the container target
+// evaluates to, when target is reached through an alias of a const
+func (c *Compiler) warnAliasChange(node ast.Node, target ast.Expression) {
+	for {
+		switch t := target.(type) {
+		case *ast.IndexExpression:
+			target = t.Left
+			continue
+		case *ast.FieldAccessExpression:
+			target = t.Left
+			continue
+		case *ast.Identifier:
+			if constName, ok := c.constAliases[t.Value]; ok {
+				c.warnf(node, "%s refers to the same container as const %s, so this changes %s too; use deepCopy(%s) for a separate copy",
+					t.Value, constName, constName, constName)
+			}
+		}
+		return
+	}
+}
+
+// warnAliasCall warns about a call to a builtin that changes its first
+// argument when that is reached through an alias of a const
+func (c *Compiler) warnAliasCall(node *ast.CallExpression) {
+	ident, ok := node.Function.(*ast.Identifier)
+	if !ok || !mutatingBuiltins[ident.Value] || len(node.Arguments) == 0 {
+		return
+	}
+	if symbol, ok := c.symbolTable.Resolve(ident.Value); !ok || symbol.Scope != BuiltinScope {
+		return
+	}
+	c.warnAliasChange(node, node.Arguments[0])
+}
@@ -0,0 +1,52 @@
+package compiler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConstContainerAliasWarnings(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		warnings []string
+	}{
+		{
+			"element assignment through an alias",
+			"const xs = [1, 2]\nvar ys = xs\nys[0] = 5",
+			[]string{"3:1: ys refers to the same container as const xs, so this changes xs too; use deepCopy(xs) for a separate copy"},
+		},
+		{
+			"builtin through an alias of an alias",
+			"const m = map[string]int{\"a\": 1}\nvar n = m\nvar o = n\ndelete(o, \"a\")",
+			[]string{"4:1: o refers to the same container as const m, so this changes m too; use deepCopy(m) for a separate copy"},
+		},
+		{
+			"deep copy",
+			"const xs = [1, 2]\nvar ys = deepCopy(xs)\nys[0] = 5",
+			nil,
+		},
+		{
+			"alias reassigned",
+			"const xs = [1, 2]\nvar ys = xs\nys = [3]\nys[0] = 5",
+			nil,
+		},
+		{
+			"parameter with the name of a const",
+			"const xs = [1, 2]\nfunc g(xs: []int) { xs[0] = 1 }\ng([0])",
+			nil,
+		},
+		{
+			"mutable container",
+			"var xs = [1, 2]\nvar ys = xs\nys[0] = 5",
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		c := compileSource(t, tt.input)
+		if !reflect.DeepEqual(c.Warnings(), tt.warnings) {
+			t.Errorf("%s: expected warnings %q, got %q", tt.name, tt.warnings, c.Warnings())
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package compiler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"minlang/ast"
+	"sort"
+	"strings"
+)
+
+// Call graph
+//
+// With SetCallGraph the stack compiler records, as it compiles each call,
+// which function makes it and which function it reaches. A function is named
+// as declared, a nested one after the functions holding it ("outer.inner"),
+// and the top level of the program is "main". A call reaches the function its
+// name resolves to where it is made; calls through a variable or any other
+// expression aren't known until the program runs and are left out. Naming a
+// function without calling it, to pass it to onExit for example, is recorded
+// as a reference, since whatever receives it may call it.
+
+// CallGraph holds a program's functions and the calls between them
+type CallGraph struct {
+	Functions []GraphFunction `json:"functions"` // In declaration order, main first
+	Calls     []GraphCall     `json:"calls"`     // In source order
+}
+
+// GraphFunction is a function of a CallGraph
+type GraphFunction struct {
+	Name      string `json:"name"`
+	Signature string `json:"signature,omitempty"`
+	Line      int    `json:"line,omitempty"`
+	Reachable bool   `json:"reachable"` // Main calls or references it, directly or not
+}
+
+// GraphCall is a call from one function to another or to a builtin, or a
+// reference to a function
+type GraphCall struct {
+	Caller    string `json:"caller"`
+	Callee    string `json:"callee"`
+	Builtin   bool   `json:"builtin,omitempty"`
+	Reference bool   `json:"reference,omitempty"` // Named without being called
+	Line      int    `json:"line"`
+	Column    int    `json:"column"`
+}
+
+// callGraphBuilder records the call graph while the compiler runs
+type callGraphBuilder struct {
+	graph    CallGraph
+	declared []map[string]string // Functions declared in each function being compiled, by name
+	current  []string            // Functions being compiled, innermost last
+	callee   *ast.Identifier     // Name of the function being called, not referenced
+}
+
+// SetCallGraph makes the compiler record the program's call graph, for
+// CallGraph
+func (c *Compiler) SetCallGraph(enabled bool) {
+	c.graph = nil
+	if enabled {
+		c.graph = &callGraphBuilder{
+			graph:    CallGraph{Functions: []GraphFunction{{Name: "main"}}},
+			declared: []map[string]string{{}},
+			current:  []string{"main"},
+		}
+	}
+}
+
+// CallGraph returns the call graph recorded while compiling, or nil if
+// SetCallGraph wasn't enabled
+func (c *Compiler) CallGraph() *CallGraph {
+	if c.graph == nil {
+		return nil
+	}
+	graph := c.graph.graph
+	graph.Functions = append([]GraphFunction(nil), graph.Functions...)
+	graph.Calls = append([]GraphCall(nil), graph.Calls...)
+	graph.markReachable()
+	return &graph
+}
+
+// enterFunction records the declaration of node, whose body is compiled next
+func (g *callGraphBuilder) enterFunction(node *ast.FunctionStatement, sig *FunctionType) {
+	if g == nil {
+		return
+	}
+	name := node.Name.Value
+	if outer := g.current[len(g.current)-1]; outer != "main" {
+		name = outer + "." + name
+	}
+	g.declared[len(g.declared)-1][node.Name.Value] = name
+	g.graph.Functions = append(g.graph.Functions, GraphFunction{
+		Name:      name,
+		Signature: sig.String(),
+		Line:      node.Token.Line,
+	})
+	g.declared = append(g.declared, map[string]string{})
+	g.current = append(g.current, name)
+}
+
+// leaveFunction records that the body of the innermost function is done
+func (g *callGraphBuilder) leaveFunction() {
+	if g == nil {
+		return
+	}
+	g.declared = g.declared[:len(g.declared)-1]
+	g.current = g.current[:len(g.current)-1]
+}
+
+// call records node, a call about to be compiled, if it calls a function or
+// builtin by name
+func (g *callGraphBuilder) call(node *ast.CallExpression, symbols *SymbolTable) {
+	if g == nil {
+		return
+	}
+	ident, ok := node.Function.(*ast.Identifier)
+	if !ok {
+		return
+	}
+	g.callee = ident
+	if name, ok := g.lookup(ident.Value); ok {
+		g.add(ident, name, false, false)
+	} else if symbol, ok := symbols.Resolve(ident.Value); ok && symbol.Scope == BuiltinScope {
+		g.add(ident, ident.Value, true, false)
+	}
+}
+
+// reference records node, a name about to be compiled, if it names a
+// function other than the one being called
+func (g *callGraphBuilder) reference(node *ast.Identifier) {
+	if g == nil || node == g.callee {
+		return
+	}
+	if name, ok := g.lookup(node.Value); ok {
+		g.add(node, name, false, true)
+	}
+}
+
+// lookup returns the graph name of the function name refers to in the
+// function being compiled
+func (g *callGraphBuilder) lookup(name string) (string, bool) {
+	for i := len(g.declared) - 1; i >= 0; i-- {
+		if fn, ok := g.declared[i][name]; ok {
+			return fn, true
+		}
+	}
+	return "", false
+}
+
+func (g *callGraphBuilder) add(at *ast.Identifier, callee string, builtin, reference bool) {
+	g.graph.Calls = append(g.graph.Calls, GraphCall{
+		Caller:    g.current[len(g.current)-1],
+		Callee:    callee,
+		Builtin:   builtin,
+		Reference: reference,
+		Line:      at.Token.Line,
+		Column:    at.Token.Column,
+	})
+}
+
+// markReachable sets Reachable on the functions main reaches
+func (graph *CallGraph) markReachable() {
+	callees := make(map[string][]string)
+	for _, call := range graph.Calls {
+		if !call.Builtin {
+			callees[call.Caller] = append(callees[call.Caller], call.Callee)
+		}
+	}
+
+	reached := map[string]bool{"main": true}
+	work := []string{"main"}
+	for len(work) > 0 {
+		fn := work[len(work)-1]
+		work = work[:len(work)-1]
+		for _, callee := range callees[fn] {
+			if !reached[callee] {
+				reached[callee] = true
+				work = append(work, callee)
+			}
+		}
+	}
+
+	for i := range graph.Functions {
+		graph.Functions[i].Reachable = reached[graph.Functions[i].Name]
+	}
+}
+
+// WriteJSON writes the graph to w as JSON
+func (graph *CallGraph) WriteJSON(w io.Writer) error {
+	out, err := json.MarshalIndent(graph, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
+
+// WriteDOT writes the graph to w in Graphviz's DOT language. Functions main
+// can't reach are dashed, builtins are boxes and references are dashed
+// edges; calls made more than once from the same function are labeled with
+// their count.
+func (graph *CallGraph) WriteDOT(w io.Writer) error {
+	type edge struct {
+		caller, callee string
+		reference      bool
+	}
+	counts := make(map[edge]int)
+	var edges []edge
+	builtins := make(map[string]bool)
+	for _, call := range graph.Calls {
+		e := edge{call.Caller, call.Callee, call.Reference}
+		if counts[e] == 0 {
+			edges = append(edges, e)
+		}
+		counts[e]++
+		if call.Builtin {
+			builtins[call.Callee] = true
+		}
+	}
+
+	ew := &errWriter{w: w}
+	ew.printf("digraph calls {\n")
+	for _, fn := range graph.Functions {
+		attrs := fmt.Sprintf("label=%q", fn.Name)
+		if fn.Signature != "" {
+			attrs = fmt.Sprintf("label=%q", fn.Name+"\n"+fn.Signature)
+		}
+		if !fn.Reachable {
+			attrs += ", style=dashed"
+		}
+		ew.printf("\t%q [%s];\n", fn.Name, attrs)
+	}
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ew.printf("\t%q [shape=box];\n", name)
+	}
+	for _, e := range edges {
+		var attrs []string
+		if n := counts[e]; n > 1 {
+			attrs = append(attrs, fmt.Sprintf("label=%q", fmt.Sprint(n)))
+		}
+		if e.reference {
+			attrs = append(attrs, "style=dashed")
+		}
+		ew.printf("\t%q -> %q", e.caller, e.callee)
+		if len(attrs) > 0 {
+			ew.printf(" [%s]", strings.Join(attrs, ", "))
+		}
+		ew.printf(";\n")
+	}
+	ew.printf("}\n")
+	return ew.err
+}
+
+// errWriter writes formatted output, remembering the first error
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) printf(format string, args ...interface{}) {
+	if ew.err == nil {
+		_, ew.err = fmt.Fprintf(ew.w, format, args...)
+	}
+}
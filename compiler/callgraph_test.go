@@ -0,0 +1,99 @@
+package compiler
+
+import (
+	"bytes"
+	"minlang/lexer"
+	"minlang/parser"
+	"strings"
+	"testing"
+)
+
+func TestCallGraph(t *testing.T) {
+	input := `
+func square(x: int): int { return x * x }
+func sumSquares(n: int): int {
+    func add(a: int, b: int): int { return a + b }
+    var total = 0
+    for var i = 1; i <= n; i = i + 1 {
+        total = add(total, square(i))
+    }
+    return total
+}
+func unused() { square(1) }
+func cleanup() { print("bye") }
+onExit(cleanup)
+print(sumSquares(3))`
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	c := New()
+	c.SetCallGraph(true)
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("compilation error: %s", err)
+	}
+	graph := c.CallGraph()
+
+	reachable := map[string]bool{}
+	var names []string
+	for _, fn := range graph.Functions {
+		names = append(names, fn.Name)
+		reachable[fn.Name] = fn.Reachable
+	}
+	if got := strings.Join(names, " "); got != "main square sumSquares sumSquares.add unused cleanup" {
+		t.Errorf("wrong functions: %s", got)
+	}
+	for name, want := range map[string]bool{"main": true, "sumSquares.add": true, "unused": false, "cleanup": true} {
+		if reachable[name] != want {
+			t.Errorf("%s: expected reachable=%t", name, want)
+		}
+	}
+	if sig := graph.Functions[3].Signature; sig != "func(int, int) int" {
+		t.Errorf("wrong signature for add: %s", sig)
+	}
+
+	var calls []string
+	for _, call := range graph.Calls {
+		desc := call.Caller + "->" + call.Callee
+		if call.Builtin {
+			desc += " builtin"
+		}
+		if call.Reference {
+			desc += " reference"
+		}
+		calls = append(calls, desc)
+	}
+	want := []string{
+		"sumSquares->sumSquares.add",
+		"sumSquares->square",
+		"unused->square",
+		"cleanup->print builtin",
+		"main->onExit builtin",
+		"main->cleanup reference",
+		"main->print builtin",
+		"main->sumSquares",
+	}
+	if strings.Join(calls, ", ") != strings.Join(want, ", ") {
+		t.Errorf("wrong calls.\nwant=%v\ngot=%v", want, calls)
+	}
+	if call := graph.Calls[1]; call.Line != 7 || call.Column != 28 {
+		t.Errorf("expected the call of square at 7:28, got %d:%d", call.Line, call.Column)
+	}
+
+	var dot bytes.Buffer
+	if err := graph.WriteDOT(&dot); err != nil {
+		t.Fatalf("WriteDOT failed: %s", err)
+	}
+	for _, line := range []string{
+		`"unused" [label="unused\nfunc() any", style=dashed];`,
+		`"print" [shape=box];`,
+		`"main" -> "cleanup" [style=dashed];`,
+	} {
+		if !strings.Contains(dot.String(), line) {
+			t.Errorf("expected %s in\n%s", line, dot.String())
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package compiler
+
+import (
+	"fmt"
+	"minlang/ast"
+	"minlang/vm"
+)
+
+// Casts
+//
+// v as int checks that v is an int as the program runs, stopping it with an
+// error if it isn't, and otherwise is v, which the compilers then take to be
+// an int. It converts nothing: 3 as float fails, where float(3) is 3.0. The
+// check is the one -runtime-checks makes of annotated parameters, so it looks
+// at the value's own type, not inside arrays and maps.
+
+// CastDescription names the value cast by node in runtime check messages:
+// the variable it reads, if it is one
+func CastDescription(node *ast.CastExpression) string {
+	if ident, ok := node.Value.(*ast.Identifier); ok {
+		return ident.Value
+	}
+	return "value"
+}
+
+// castCheck returns the type node checks its value for and the message for
+// a value that doesn't have it
+func (c *Compiler) castCheck(node *ast.CastExpression) (vm.ValueType, string, error) {
+	want, message, ok := AnnotationCheck(c.convertType(node.Type), CastDescription(node))
+	if !ok {
+		return 0, "", fmt.Errorf("cannot check for %s with as: only int, float, bool, string, error, arrays, maps, functions and tuples can be checked",
+			node.Type.String())
+	}
+	return want, message, nil
+}
+
+// compileCast compiles node, leaving the checked value on the stack
+func (c *Compiler) compileCast(node *ast.CastExpression) error {
+	want, message, err := c.castCheck(node)
+	if err != nil {
+		return err
+	}
+	if err := c.Compile(node.Value); err != nil {
+		return err
+	}
+	c.emit(vm.OpCheckType, c.addConstant(vm.StringValue(message)), int(want))
+	return nil
+}
+
+// compileCast compiles node, checking the value in the register it is
+// compiled to
+func (rc *RegisterCompiler) compileCast(node *ast.CastExpression) (int, error) {
+	if _, _, err := rc.castCheck(node); err != nil {
+		return -1, err
+	}
+	reg, err := rc.CompileToRegister(node.Value)
+	if err != nil {
+		return -1, err
+	}
+	return reg, rc.emitCheckType(reg, rc.convertType(node.Type), CastDescription(node))
+}
@@ -0,0 +1,44 @@
+package compiler
+
+import (
+	"minlang/lexer"
+	"minlang/parser"
+	"minlang/vm"
+	"testing"
+)
+
+func TestCasts(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"basic type", "var xs = [1, \"a\"]\nvar n: int = xs[0] as int", ""},
+		{"collection", "var xs = [[1], \"a\"]\nvar ys: []int = xs[0] as []int", ""},
+		{"cast value is typed", "var m = map[string]int{\"a\": 1}\nvar s: string = m[\"a\"] as int", "2:1: cannot assign value of type int to type string"},
+		{"struct", "type Point = struct { x: int }\nvar v = 1\nvar p = v as Point", "cannot check for Point with as: only int, float, bool, string, error, arrays, maps, functions and tuples can be checked"},
+		{"any", "var v = 1\nvar w = v as any", "cannot check for any with as: only int, float, bool, string, error, arrays, maps, functions and tuples can be checked"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("%s: parser errors: %v", tt.name, p.Errors())
+		}
+
+		c := New()
+		err := c.Compile(program)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s: compiler error: %s", tt.name, err)
+			} else if n := countOp(c.Bytecode().Instructions, vm.OpCheckType); n != 1 {
+				t.Errorf("%s: expected 1 %s, got %d", tt.name, vm.OpCheckType, n)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%s: expected error %q, got %v", tt.name, tt.err, err)
+		}
+	}
+}
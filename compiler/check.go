@@ -0,0 +1,461 @@
+package compiler
+
+import (
+	"fmt"
+	"maps"
+	"minlang/ast"
+	"slices"
+	"strings"
+)
+
+// Semantic analysis
+//
+// Before generating any code for a program, the compilers run the
+// TypeChecker over all of it, as the interpreter does before running it. It infers what types it can and reports every
+// declaration, assignment, return and call whose types don't fit, each with
+// the line and column it starts at, rather than stopping at the first.
+// Values of types it doesn't know, such as structs and the results of
+// builtins, fit anything. Code generation still checks what only it knows,
+// such as struct fields and the instances of generic functions, with the
+// same rules, below, and reports what it finds the same way.
+
+// TypeErrors is the error for a program the type checker rejects, holding a
+// message for each error, prefixed with its position
+type TypeErrors []string
+
+func (e TypeErrors) Error() string {
+	return strings.Join(e, "\n")
+}
+
+// typeCheck runs the type checker over program, returning the errors it
+// finds, if any
+func (c *Compiler) typeCheck(program *ast.Program) error {
+	tc := NewTypeChecker()
+	tc.PromoteIntDiv = c.promoteIntDiv
+	for name := range c.structTypes {
+		tc.structs[name] = true
+	}
+	return tc.CheckProgram(program)
+}
+
+// typeError reports err, a type error code generation found in node, as the
+// type checker reports its own
+func typeError(node ast.Node, err error) error {
+	return TypeErrors{atNode(node, err.Error())}
+}
+
+// checkValue checks node, the value of something of type expectedType,
+// looking into array and map literals element by element
+func checkValue(infer func(ast.Expression) Type, node ast.Expression, expectedType Type) error {
+	// Check array literals
+	if arrLit, ok := node.(*ast.ArrayLiteral); ok {
+		if arrType, ok := expectedType.(*ArrayType); ok {
+			// Check each element recursively
+			for i, elem := range arrLit.Elements {
+				// Recursively check if element itself is an array or map
+				if err := checkValue(infer, elem, arrType.ElementType); err != nil {
+					return fmt.Errorf("array element %d: %v", i, err)
+				}
+			}
+			return nil
+		}
+	}
+
+	// Check map literals
+	if mapLit, ok := node.(*ast.MapLiteral); ok {
+		if mapType, ok := expectedType.(*MapType); ok {
+			// Check each key-value pair
+			for _, key := range mapLit.Keys {
+				value := mapLit.Pairs[key]
+				keyType := infer(key)
+				if !IsAssignableTo(keyType, mapType.KeyType) {
+					return fmt.Errorf("map key has type %s, expected %s",
+						keyType.String(), mapType.KeyType.String())
+				}
+
+				valueType := infer(value)
+				if !IsAssignableTo(valueType, mapType.ValueType) {
+					return fmt.Errorf("map value has type %s, expected %s",
+						valueType.String(), mapType.ValueType.String())
+				}
+			}
+			return nil
+		}
+	}
+
+	// For other expressions, check basic type compatibility
+	valueType := infer(node)
+	if !IsAssignableTo(valueType, expectedType) {
+		return fmt.Errorf("cannot assign value of type %s to type %s",
+			valueType.String(), expectedType.String())
+	}
+
+	return nil
+}
+
+// checkElementAssignment checks container[index] = value for an array or map
+// whose element types are known
+func checkElementAssignment(infer func(ast.Expression) Type, left *ast.IndexExpression, value ast.Expression) error {
+	containerType := infer(left.Left)
+	indexType := infer(left.Index)
+	valueType := infer(value)
+
+	if arrayType, ok := containerType.(*ArrayType); ok {
+		// Array assignment: check element type
+		if !IsAssignableTo(valueType, arrayType.ElementType) {
+			return fmt.Errorf("cannot assign value of type %s to array element of type %s",
+				valueType.String(), arrayType.ElementType.String())
+		}
+	} else if mapType, ok := containerType.(*MapType); ok {
+		// Map assignment: check key and value types
+		if !IsAssignableTo(indexType, mapType.KeyType) {
+			return fmt.Errorf("cannot use key of type %s for map with key type %s",
+				indexType.String(), mapType.KeyType.String())
+		}
+		if !IsAssignableTo(valueType, mapType.ValueType) {
+			return fmt.Errorf("cannot assign value of type %s to map value of type %s",
+				valueType.String(), mapType.ValueType.String())
+		}
+	}
+	return nil
+}
+
+// checkReturn checks returning value, or nil if value is nil, from a
+// function of return type returnType, which is nil outside functions
+func checkReturn(infer func(ast.Expression) Type, value ast.Expression, returnType Type) error {
+	if returnType == nil {
+		return nil
+	}
+	if value == nil {
+		if !returnType.Equals(NilType) && !returnType.Equals(AnyTypeVal) {
+			return fmt.Errorf("cannot return nil from function expecting %s", returnType.String())
+		}
+		return nil
+	}
+	if valueType := infer(value); !IsAssignableTo(valueType, returnType) {
+		return fmt.Errorf("cannot return %s from function expecting %s",
+			valueType.String(), returnType.String())
+	}
+	return nil
+}
+
+// checkArguments checks the number and types of the arguments of a call to
+// the function name, whose signature is funcType
+func checkArguments(infer func(ast.Expression) Type, name string, funcType *FunctionType, args []ast.Expression) error {
+	if len(args) != len(funcType.ParamTypes) {
+		return fmt.Errorf("function %s expects %d arguments, got %d",
+			name, len(funcType.ParamTypes), len(args))
+	}
+	for i, arg := range args {
+		argType := infer(arg)
+		expectedType := funcType.ParamTypes[i]
+		if !IsAssignableTo(argType, expectedType) {
+			return fmt.Errorf("function %s argument %d: expected %s, got %s",
+				name, i+1, expectedType.String(), argType.String())
+		}
+	}
+	return nil
+}
+
+// Check checks every statement of program, recording an error for each
+// problem it finds
+func (tc *TypeChecker) Check(program *ast.Program) {
+	for _, stmt := range program.Statements {
+		tc.checkStatement(stmt)
+	}
+}
+
+// CheckProgram checks program as more of the programs checked before, whose
+// declarations it sees, returning TypeErrors for the problems in program, if
+// any
+func (tc *TypeChecker) CheckProgram(program *ast.Program) error {
+	tc.errors = []string{}
+	tc.Check(program)
+	if len(tc.errors) > 0 {
+		return TypeErrors(tc.errors)
+	}
+	return nil
+}
+
+// errorf records an error about node, prefixed with where it starts
+func (tc *TypeChecker) errorf(node ast.Node, format string, args ...interface{}) {
+	tc.AddError(atNode(node, fmt.Sprintf(format, args...)))
+}
+
+// bind records that name holds a value of type t, which assignments may
+// change
+func (tc *TypeChecker) bind(name string, t Type) {
+	tc.typeMap[name] = t
+	delete(tc.declared, name)
+}
+
+// declare records that name was declared with type t, which assignments
+// must keep to
+func (tc *TypeChecker) declare(name string, t Type) {
+	tc.typeMap[name] = t
+	tc.declared[name] = t
+}
+
+// scoped runs check, then forgets the types it gave names, so that what a
+// block declares doesn't outlive it
+func (tc *TypeChecker) scoped(check func()) {
+	savedTypes, savedDeclared := maps.Clone(tc.typeMap), maps.Clone(tc.declared)
+	check()
+	tc.typeMap, tc.declared = savedTypes, savedDeclared
+}
+
+func (tc *TypeChecker) checkStatement(node ast.Statement) {
+	switch node := node.(type) {
+	case *ast.VarStatement:
+		tc.CheckVarStatement(node)
+
+	case *ast.LookupStatement:
+		tc.checkExpression(node.Index)
+		tc.bind(node.Value.Value, tc.InferType(node.Index))
+		tc.bind(node.Found.Value, BoolType)
+
+	case *ast.AssignmentStatement:
+		tc.CheckAssignment(node)
+
+	case *ast.ExpressionStatement:
+		tc.checkExpression(node.Expression)
+
+	case *ast.BlockStatement:
+		// Blocks, such as the branches of an if, keep their declarations
+		tc.scoped(func() {
+			for _, stmt := range node.Statements {
+				tc.checkStatement(stmt)
+			}
+		})
+
+	case *ast.IfStatement:
+		tc.checkExpression(node.Condition)
+		tc.checkStatement(node.Consequence)
+		if node.Alternative != nil {
+			tc.checkStatement(node.Alternative)
+		}
+
+	case *ast.ForStatement:
+		// The loop variables are the loop's own
+		tc.scoped(func() {
+			if node.Init != nil {
+				tc.checkStatement(node.Init)
+			}
+			tc.checkExpression(node.Condition)
+			if node.Post != nil {
+				tc.checkStatement(node.Post)
+			}
+			tc.checkStatement(node.Body)
+		})
+
+	case *ast.ForInStatement:
+		tc.checkExpression(node.Iterable)
+		element := Type(AnyTypeVal)
+		if arrayType, ok := tc.InferType(node.Iterable).(*ArrayType); ok {
+			element = arrayType.ElementType
+		}
+		tc.scoped(func() {
+			tc.bind(node.Variable.Value, element)
+			tc.checkStatement(node.Body)
+		})
+
+	case *ast.SwitchStatement:
+		tc.checkSwitch(node)
+
+	case *ast.TryStatement:
+		tc.checkStatement(node.Body)
+		tc.scoped(func() {
+			if node.Variable != nil {
+				tc.bind(node.Variable.Value, AnyTypeVal)
+			}
+			tc.checkStatement(node.Catch)
+		})
+
+	case *ast.ReturnStatement:
+		tc.checkExpression(node.ReturnValue)
+		if err := checkReturn(tc.InferType, node.ReturnValue, tc.returnType); err != nil {
+			tc.errorf(node, "%v", err)
+		}
+
+	case *ast.FunctionStatement:
+		tc.checkFunction(node)
+
+	case *ast.TypeStatement:
+		if def, ok := node.Definition.(*ast.StructStatement); ok {
+			tc.structs[node.Name.Value] = true
+			for _, field := range def.Fields {
+				if field.Default == nil {
+					continue
+				}
+				tc.checkExpression(field.Default)
+				if err := checkValue(tc.InferType, field.Default, ConvertASTType(field.Type)); err != nil {
+					tc.errorf(field.Default, "default of field %s in struct %s: %v", field.Name.Value, node.Name.Value, err)
+				}
+			}
+		}
+	}
+}
+
+// checkFunction checks the body of node, a function declaration, with its
+// parameters and return type. A generic function's body is checked for each
+// of its instances as it is compiled, see generics.go.
+func (tc *TypeChecker) checkFunction(node *ast.FunctionStatement) {
+	if len(node.TypeParams) > 0 {
+		tc.bind(node.Name.Value, AnyTypeVal)
+		return
+	}
+
+	sig := &FunctionType{ParamTypes: make([]Type, len(node.Parameters)), ReturnType: ConvertASTType(node.ReturnType)}
+	for i, param := range node.Parameters {
+		sig.ParamTypes[i] = ConvertASTType(param.Type)
+	}
+	// Declared before its body, which may call it
+	tc.bind(node.Name.Value, sig)
+
+	// The body's types don't outlive it
+	savedReturn := tc.returnType
+	tc.returnType = sig.ReturnType
+	tc.scoped(func() {
+		for i, param := range node.Parameters {
+			tc.declare(param.Name.Value, sig.ParamTypes[i])
+		}
+		tc.checkStatement(node.Body)
+	})
+	tc.returnType = savedReturn
+}
+
+// checkSwitch checks a switch statement or expression. The names a case's
+// pattern binds hold parts of the switch value, of types left unknown.
+func (tc *TypeChecker) checkSwitch(node *ast.SwitchStatement) {
+	tc.checkExpression(node.Value)
+	for _, caseClause := range node.Cases {
+		// The names a pattern binds are the case's own
+		tc.scoped(func() {
+			switch value := caseClause.Value.(type) {
+			case *ast.StructPattern, *ast.ArrayPattern:
+				tc.bindPattern(value)
+			default:
+				if _, bindings, ok, _ := CasePattern(value); ok {
+					for _, name := range bindings {
+						tc.bind(name.Value, AnyTypeVal)
+					}
+				} else {
+					tc.checkExpression(value)
+				}
+			}
+			tc.checkStatement(caseClause.Body)
+		})
+	}
+	if node.Default != nil {
+		tc.checkStatement(node.Default)
+	}
+}
+
+// bindPattern binds the names in a struct or array pattern
+func (tc *TypeChecker) bindPattern(pattern ast.Expression) {
+	switch p := pattern.(type) {
+	case *ast.Identifier:
+		tc.bind(p.Value, AnyTypeVal)
+	case *ast.StructPattern:
+		for _, field := range p.Fields {
+			tc.bindPattern(field.Pattern)
+		}
+	case *ast.ArrayPattern:
+		for _, element := range p.Elements {
+			tc.bindPattern(element)
+		}
+	}
+}
+
+// checkExpression checks the calls by name in node, which may be nil,
+// against the signatures of the functions they call
+func (tc *TypeChecker) checkExpression(node ast.Expression) {
+	switch n := node.(type) {
+	case *ast.CallExpression:
+		tc.checkExpression(n.Function)
+		for _, arg := range n.Arguments {
+			tc.checkExpression(arg)
+		}
+		// Like code generation, only calls by name; others are checked as
+		// the program runs
+		if _, isName := n.Function.(*ast.Identifier); !isName {
+			break
+		}
+		if sig, ok := tc.InferType(n.Function).(*FunctionType); ok {
+			if err := checkArguments(tc.InferType, n.Function.String(), sig, n.Arguments); err != nil {
+				tc.errorf(n, "%v", err)
+			}
+		}
+	case *ast.InfixExpression:
+		tc.checkExpression(n.Left)
+		tc.checkExpression(n.Right)
+		switch n.Operator {
+		case "+", "-", "*", "/":
+			if tc.InferType(n.Left).Equals(FloatType) || tc.InferType(n.Right).Equals(FloatType) {
+				tc.floatMath[n] = true
+			}
+		}
+	case *ast.PrefixExpression:
+		tc.checkExpression(n.Right)
+	case *ast.IndexExpression:
+		tc.checkExpression(n.Left)
+		tc.checkExpression(n.Index)
+	case *ast.SliceExpression:
+		tc.checkExpression(n.Left)
+		tc.checkExpression(n.Low)
+		tc.checkExpression(n.High)
+	case *ast.FieldAccessExpression:
+		tc.checkExpression(n.Left)
+	case *ast.TupleElementExpression:
+		tc.checkExpression(n.Left)
+	case *ast.CastExpression:
+		tc.checkExpression(n.Value)
+	case *ast.ArrayLiteral:
+		for _, el := range n.Elements {
+			tc.checkExpression(el)
+		}
+	case *ast.TupleLiteral:
+		for _, el := range n.Elements {
+			tc.checkExpression(el)
+		}
+	case *ast.MapLiteral:
+		for _, key := range n.Keys {
+			tc.checkExpression(key)
+			tc.checkExpression(n.Pairs[key])
+		}
+	case *ast.StructLiteral:
+		if !tc.structs[n.Name.Value] {
+			tc.errorf(n, "undefined struct type %s", n.Name.Value)
+		}
+		for _, name := range slices.Sorted(maps.Keys(n.Fields)) {
+			tc.checkExpression(n.Fields[name])
+		}
+	case *ast.NewExpression:
+		for _, arg := range n.Arguments {
+			tc.checkExpression(arg)
+		}
+	case *ast.IfExpression:
+		tc.branchTypes[n] = tc.InferType(n)
+		tc.checkStatement(n.IfStatement)
+	case *ast.SwitchExpression:
+		tc.branchTypes[n] = tc.InferType(n)
+		tc.checkSwitch(n.SwitchStatement)
+	}
+}
+
+// FloatArithmetic reports whether node, an arithmetic expression Check has
+// checked, has an operand of type float. The compilers then do it in floats,
+// even when the other operand, or an int passed for the float, is an int.
+func (tc *TypeChecker) FloatArithmetic(node *ast.InfixExpression) bool {
+	return tc.floatMath[node]
+}
+
+// BranchType returns the type inferred for node, an if or switch expression
+// Check has checked, or any for one it hasn't. When it is float, the
+// compilers make the value of an int branch a float.
+func (tc *TypeChecker) BranchType(node ast.Expression) Type {
+	if t, ok := tc.branchTypes[node]; ok {
+		return t
+	}
+	return AnyTypeVal
+}
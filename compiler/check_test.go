@@ -0,0 +1,92 @@
+package compiler
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+// TestTypeCheckReportsEveryError checks that the type checker reports all
+// the type errors of a program, with their positions, before any code is
+// generated for it
+func TestTypeCheckReportsEveryError(t *testing.T) {
+	input := `func add(a: int, b: int): int { return a + b }
+var n: int = "one"
+var total: int = add(1)
+func name(): string {
+    return 42
+}
+var xs: []int = [1, 2]
+xs[0] = "two"
+n = 2.5
+var s = "a"
+s++`
+	expected := TypeErrors{
+		"2:1: cannot assign value of type string to type int",
+		"3:18: function add expects 2 arguments, got 1",
+		"5:5: cannot return int from function expecting string",
+		"8:1: cannot assign value of type string to array element of type int",
+		"9:1: cannot assign value of type float to variable n of type int",
+		"11:1: cannot use ++ on s: it is a string, not a number",
+	}
+
+	c := New()
+	err := c.Compile(parse(input))
+	var got TypeErrors
+	if !errors.As(err, &got) || !slices.Equal(got, expected) {
+		t.Fatalf("expected errors %q, got %v", expected, err)
+	}
+	if n := len(c.Bytecode().Instructions); n != 0 {
+		t.Errorf("expected no instructions, got %d", n)
+	}
+
+	rc := NewRegisterCompiler()
+	_, err = rc.CompileToRegister(parse(input))
+	if !errors.As(err, &got) || !slices.Equal(got, expected) {
+		t.Errorf("register: expected errors %q, got %v", expected, err)
+	}
+}
+
+func TestTypeCheck(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"unknown types fit", "var xs = split(\"a b\", \" \")\nvar n: int = xs[0]", ""},
+		{"untyped variables change type", "var x = 1\nx = \"a\"", ""},
+		{"parameters are typed", "func f(n: int) { n = \"a\" }", "1:18: cannot assign value of type string to variable n of type int"},
+		{"function scope", "func f() { var n: string = \"a\" }\nvar n: int = 1\nn = 2", ""},
+		{"block scope", "var x: int = 1\nif x > 0 { var x: string = \"a\" } else { var x: bool = true }\nx = 2", ""},
+		{"loop scope", "func f() {\nvar y: int = 1\nfor var i = 0; i < 1; i++ { var y: string = \"b\" }\ny = 3 }", ""},
+		{"blocks are checked", "if true { var n: int = \"a\" }", "1:11: cannot assign value of type string to type int"},
+		{"function values", "func f(n: int): string { return \"a\" }\nvar g = f\nvar n: int = g(1)", "3:1: cannot assign value of type string to type int"},
+		{"calls through other values run", "func f(n: int): int { return n }\nvar fs = [f]\nvar n: int = fs[0](1, 2)", ""},
+		{"generic bodies wait for instances", "func id<T>(x: T): T { var y: int = x\nreturn x }", ""},
+		{"pattern bindings", "var n: int = 1\nswitch [\"a\", \"b\"] {\ncase [n, _] { var s: string = n }\ndefault {}\n}", ""},
+		{"lookup", "var m = map[string]int{\"a\": 1}\nvar v, ok = m[\"a\"]\nvar s: string = v", "3:1: cannot assign value of type int to type string"},
+		{"for-in", "for x in [\"a\"] { var n: int = x }", "1:18: cannot assign value of type string to type int"},
+		{"promoted division", "var n: int = 1\nn = n / 2", ""},
+		{"struct types", "type P = struct { x: int }\nfunc f(): P { return P{x: 1} }", ""},
+		{"undefined struct types", "func f() { var p = Point{x: 1} }", "1:20: undefined struct type Point"},
+	}
+
+	for _, tt := range tests {
+		err := New().Compile(parse(tt.input))
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s: compiler error: %s", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%s: expected error %q, got %v", tt.name, tt.err, err)
+		}
+	}
+
+	c := New()
+	c.SetPromoteIntDiv(true)
+	if err := c.Compile(parse("var n: int = 1\nn = n / 2")); err == nil || err.Error() != "2:1: cannot assign value of type float to variable n of type int" {
+		t.Errorf("promoted division: expected an error assigning a float, got %v", err)
+	}
+}
@@ -2,6 +2,7 @@ package compiler
 
 import (
 	"fmt"
+	"maps"
 	"minlang/ast"
 	"minlang/vm"
 )
@@ -10,13 +11,15 @@ import (
 type LoopContext struct {
 	breakJumps    []int // Positions of break jumps to patch
 	continueJumps []int // Positions of continue jumps to patch
+	tries         int   // Try bodies the loop is inside, see compileTry
 }
 
 // EnumType tracks enum type information
 type EnumType struct {
 	Name         string
-	Variants     map[string]int // variant name -> integer value
-	VariantNames []string       // ordered variant names
+	Variants     map[string]vm.Value // variant name -> int or string value, see EnumValues
+	VariantNames []string            // ordered variant names
+	Payloads     map[string][]Type   // variant name -> types of the values it carries, nil unless some variant carries any
 }
 
 // StructType tracks struct type information
@@ -24,6 +27,7 @@ type StructType struct {
 	Name       string
 	Fields     map[string]string // field name -> field type
 	FieldOrder []string          // ordered field names (Phase 3: for offset-based access)
+	Defaults   map[string]ast.Expression // field name -> value of the field in literals that leave it out
 }
 
 // GetFieldOffset returns the offset (index) of a field, or -1 if not found
@@ -38,8 +42,9 @@ func (st *StructType) GetFieldOffset(fieldName string) int {
 
 // Compiler represents the compiler
 type Compiler struct {
-	constants   []vm.Value
-	symbolTable *SymbolTable
+	constants     []vm.Value
+	constantIndex map[constantKey]int // Scalar constants already in the pool, see addConstant
+	symbolTable   *SymbolTable
 
 	scopes     []CompilationScope
 	scopeIndex int
@@ -49,11 +54,31 @@ type Compiler struct {
 
 	loopStack         []LoopContext          // Stack of loop contexts
 	enumTypes         map[string]*EnumType   // Tracks enum type definitions
+	bareVariants      map[string]*bareVariant  // Variant names enums define as globals
 	structTypes       map[string]*StructType // Tracks struct type definitions
 	varTypes          map[string]vm.ValueType // Tracks variable types for type inference (Phase 1 optimization)
 	typeInfo          map[string]Type         // Tracks detailed type information for type checking
 	functionSigs      map[string]*FunctionType // Tracks function signatures for compile-time checking
 	currentFunctionRT Type                    // Current function's return type (for return statement checking)
+	currentFunction   string                  // Current function's name, for runtime check messages
+	pos               vm.Position             // Source position of the node being compiled
+	promoteIntDiv     bool                    // "/" always produces a float, even between ints
+	runtimeChecks     bool                    // Check annotated arguments and return values as the program runs
+	int32Mode         bool                    // Ints are 32 bits wide and wrap around, see SetInt32
+	checkOverflow     bool                    // Int arithmetic that overflows is a runtime error, see SetCheckOverflow
+	wrapping          ast.Node                // Expression being compiled inside its int32 wrap, see compileWrapped
+	warnings          []string                // Non-fatal diagnostics, see Warnings
+	graph             *callGraphBuilder       // Calls recorded for CallGraph, if enabled
+	outerTypes        *Compiler               // Types recorded outside the function body being compiled, see lookupTypeInfo
+	forIns            int                     // For-in loops lowered so far, see lowerForIn
+	lookups           int                     // Map lookups lowered so far, see lowerLookup
+	inLookup          bool                    // Compiling the statements of a lowered map lookup, see constValue
+	matches           int                     // Switches with struct or array patterns so far, see casePatterns
+	structVars        map[string]string       // Struct type of the variables known to hold one, see structTypeOf
+	constAliases      map[string]string       // Variables referring to the container of a const, by the const, see aliases.go
+	generics          map[string]*genericFunction // Generic function declarations, see generics.go
+	typeArgs          map[string]Type             // Types of the type parameters of the generic instance being compiled
+	tries             int                     // Try bodies being compiled in the current function, see compileTry
 }
 
 // CompilationScope represents a compilation scope
@@ -61,6 +86,7 @@ type CompilationScope struct {
 	instructions vm.Instruction
 	lastInstruction EmittedInstruction
 	previousInstruction EmittedInstruction
+	lines vm.LineTable // Source positions for instructions
 }
 
 // EmittedInstruction tracks the last emitted instruction
@@ -86,18 +112,38 @@ func New() *Compiler {
 		scopeIndex:   0,
 		loopStack:    []LoopContext{},
 		enumTypes:    make(map[string]*EnumType),
+		bareVariants: make(map[string]*bareVariant),
 		structTypes:  make(map[string]*StructType),
 		varTypes:     make(map[string]vm.ValueType),
+		structVars:   make(map[string]string),
+		constAliases: make(map[string]string),
 		typeInfo:     make(map[string]Type),
 		functionSigs: make(map[string]*FunctionType),
+		generics:     make(map[string]*genericFunction),
 	}
 }
 
+// SetPromoteIntDiv makes "/" produce a float for any numeric operands,
+// including two ints, instead of truncating integer division
+func (c *Compiler) SetPromoteIntDiv(enabled bool) {
+	c.promoteIntDiv = enabled
+}
+
+// SetRuntimeChecks makes compiled functions check, as they run, that their
+// arguments and return values have the types their annotations declare.
+// Type inference doesn't see every value, and one of the wrong type reaching
+// an opcode specialized for another gives wrong results silently; with the
+// checks it stops the program where it enters or leaves the function.
+func (c *Compiler) SetRuntimeChecks(enabled bool) {
+	c.runtimeChecks = enabled
+}
+
 // enterLoop pushes a new loop context
 func (c *Compiler) enterLoop() {
 	c.loopStack = append(c.loopStack, LoopContext{
 		breakJumps:    []int{},
 		continueJumps: []int{},
+		tries:         c.tries,
 	})
 }
 
@@ -119,7 +165,26 @@ func (c *Compiler) Bytecode() *vm.Bytecode {
 	return &vm.Bytecode{
 		Instructions: c.currentInstructions(),
 		Constants:    c.constants,
+		Lines:        c.scopes[c.scopeIndex].lines,
+		Enums:        c.runtimeEnums(),
+	}
+}
+
+// runtimeEnums returns the variant names of the program's enums, which the
+// enumName and enumValue builtins look up at runtime
+func (c *Compiler) runtimeEnums() vm.Enums {
+	if len(c.enumTypes) == 0 {
+		return nil
+	}
+	enums := make(vm.Enums, len(c.enumTypes))
+	for name, enumType := range c.enumTypes {
+		variants := make([]vm.EnumVariant, len(enumType.VariantNames))
+		for i, variant := range enumType.VariantNames {
+			variants[i] = vm.EnumVariant{Name: variant, Value: enumType.Variants[variant]}
+		}
+		enums[name] = variants
 	}
+	return enums
 }
 
 func (c *Compiler) currentInstructions() vm.Instruction {
@@ -127,6 +192,10 @@ func (c *Compiler) currentInstructions() vm.Instruction {
 }
 
 func (c *Compiler) emit(op vm.OpCode, operands ...int) int {
+	// A constant index past 2 bytes needs the wide form of op
+	if len(operands) > 0 && operands[0] > 0xFFFF {
+		op, _ = vm.WideOpCode(op)
+	}
 	ins := vm.Make(op, operands...)
 	pos := c.addInstruction(ins)
 
@@ -140,6 +209,9 @@ func (c *Compiler) addInstruction(ins []byte) int {
 	updatedInstructions := append(c.currentInstructions(), ins...)
 
 	c.scopes[c.scopeIndex].instructions = updatedInstructions
+	if c.pos.Line > 0 {
+		c.scopes[c.scopeIndex].lines = c.scopes[c.scopeIndex].lines.Add(posNewInstruction, c.pos)
+	}
 
 	return posNewInstruction
 }
@@ -186,11 +258,51 @@ func (c *Compiler) changeOperand(opPos int, operand int) {
 	c.replaceInstruction(opPos, newInstruction)
 }
 
+// addConstant adds obj to the pool and returns its index. An int, float,
+// bool, string or nil equal to one already in the pool reuses its index, so a
+// literal repeated across a program takes one entry.
 func (c *Compiler) addConstant(obj vm.Value) int {
+	key, scalar := scalarConstantKey(obj)
+	if index, ok := c.constantIndex[key]; scalar && ok {
+		return index
+	}
+
 	c.constants = append(c.constants, obj)
+	index := len(c.constants) - 1
+	if scalar {
+		if c.constantIndex == nil {
+			c.constantIndex = make(map[constantKey]int)
+		}
+		c.constantIndex[key] = index
+	}
+	return index
+}
+
+// reserveConstant adds a slot to the pool for a constant filled in later
+func (c *Compiler) reserveConstant() int {
+	c.constants = append(c.constants, vm.NilValue())
 	return len(c.constants) - 1
 }
 
+// constantKey identifies a scalar constant: its type and its bits, or its
+// text for a string
+type constantKey struct {
+	typ  vm.ValueType
+	data uint64
+	str  string
+}
+
+// scalarConstantKey returns the key of v if it is a scalar constant
+func scalarConstantKey(v vm.Value) (constantKey, bool) {
+	switch v.Type {
+	case vm.IntType, vm.FloatType, vm.BoolType, vm.NilType:
+		return constantKey{typ: v.Type, data: v.Data}, true
+	case vm.StringType:
+		return constantKey{typ: v.Type, str: v.AsString()}, true
+	}
+	return constantKey{}, false
+}
+
 // tryEmitDirectLocalOp attempts to optimize binary operations with local variables
 // If the last instruction was OpLoadLocal, it replaces it with a direct local operation
 func (c *Compiler) tryEmitDirectLocalOp(normalOp, directLocalOp vm.OpCode) {
@@ -242,9 +354,33 @@ func (c *Compiler) leaveScope() vm.Instruction {
 
 // Compile compiles an AST node
 func (c *Compiler) Compile(node ast.Node) error {
+	// Instructions emitted for this node are attributed to its position;
+	// the parent's position is restored for anything emitted afterwards
+	if tok, ok := ast.NodeToken(node); ok && tok.Line > 0 {
+		saved := c.pos
+		c.pos = vm.Position{Line: tok.Line, Column: tok.Column}
+		defer func() { c.pos = saved }()
+	}
+
+	if lit, ok := NegatedLiteral(node); ok && c.int32Mode {
+		node = lit
+	}
+	if err := c.checkIntLiteral(node); err != nil {
+		return err
+	}
+	if c.wrapsInt32(node) {
+		return c.compileWrapped(node)
+	}
+	if c.checksOverflow(node) {
+		return c.compileChecked(node)
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
-		for _, s := range node.Statements {
+		if err := c.typeCheck(node); err != nil {
+			return err
+		}
+		for _, s := range c.liveStatements(node.Statements) {
 			err := c.Compile(s)
 			if err != nil {
 				return err
@@ -256,9 +392,34 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if err != nil {
 			return err
 		}
-		c.emit(vm.OpPop)
+		// Outside functions, the value is the program's result
+		if c.scopeIndex == 0 {
+			c.emit(vm.OpPopResult)
+		} else {
+			c.emit(vm.OpPop)
+		}
 
 	case *ast.InfixExpression:
+		c.warnMaybeNil(node)
+
+		if isLogical(node.Operator) {
+			return c.compileLogical(node)
+		}
+
+		if node.Operator == "in" {
+			if err := c.checkIn(node); err != nil {
+				return err
+			}
+			if err := c.Compile(node.Left); err != nil {
+				return err
+			}
+			if err := c.Compile(node.Right); err != nil {
+				return err
+			}
+			c.emit(vm.OpIn)
+			return nil
+		}
+
 		// Handle comparison operators with special ordering
 		if node.Operator == "<" {
 			err := c.Compile(node.Right)
@@ -319,14 +480,28 @@ func (c *Compiler) Compile(node ast.Node) error {
 		var isConstInt, isConstFloat bool
 
 		if intLit, ok := node.Right.(*ast.IntegerLiteral); ok {
+			if err := c.checkIntLiteral(intLit); err != nil {
+				return err
+			}
 			constIndex = c.addConstant(vm.IntValue(intLit.Value))
 			isConstInt = true
 		} else if floatLit, ok := node.Right.(*ast.FloatLiteral); ok {
 			constIndex = c.addConstant(vm.FloatValue(floatLit.Value))
 			isConstFloat = true
+		} else if v, ok := c.knownConst(node.Right); ok && (v.Type == vm.IntType || v.Type == vm.FloatType) {
+			constIndex = c.addConstant(v)
+			isConstInt, isConstFloat = v.Type == vm.IntType, v.Type == vm.FloatType
 		}
 
-		if isConstInt || isConstFloat {
+		// An int constant with a float on the left is a float operand
+		if isConstInt && node.Operator != "%" && c.inferExpressionType(node.Left) == vm.FloatType {
+			constIndex = c.addConstant(vm.FloatValue(c.constants[constIndex].AsFloat()))
+			isConstInt, isConstFloat = false, true
+		}
+
+		// Promoted division has no constant form; it goes through emitTypedDiv.
+		// Neither does an index past 2 bytes, which needs OpPushWide.
+		if (isConstInt || isConstFloat) && constIndex <= 0xFFFF && !(c.promoteIntDiv && node.Operator == "/") {
 			// Compile left operand only
 			err := c.Compile(node.Left)
 			if err != nil {
@@ -452,10 +627,6 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emitTypedLe(leftType, rightType)
 		case ">=":
 			c.emitTypedGe(leftType, rightType)
-		case "&&":
-			c.emit(vm.OpAnd)
-		case "||":
-			c.emit(vm.OpOr)
 		default:
 			return fmt.Errorf("unknown operator %s", node.Operator)
 		}
@@ -498,37 +669,13 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(vm.OpPush, c.addConstant(vm.NilValue()))
 
 	case *ast.IfStatement:
-		err := c.Compile(node.Condition)
-		if err != nil {
-			return err
-		}
-
-		// Emit jump instruction with placeholder
-		jumpNotTruthyPos := c.emit(vm.OpJumpIfFalse, 9999)
-
-		err = c.Compile(node.Consequence)
-		if err != nil {
-			return err
-		}
-
-		// Emit jump to skip alternative
-		jumpPos := c.emit(vm.OpJump, 9999)
-
-		afterConsequencePos := len(c.currentInstructions())
-		c.changeOperand(jumpNotTruthyPos, afterConsequencePos)
-
-		if node.Alternative != nil {
-			err := c.Compile(node.Alternative)
-			if err != nil {
-				return err
-			}
-		}
+		return c.compileIf(node, false)
 
-		afterAlternativePos := len(c.currentInstructions())
-		c.changeOperand(jumpPos, afterAlternativePos)
+	case *ast.IfExpression:
+		return c.compileIf(node.IfStatement, true)
 
 	case *ast.BlockStatement:
-		for _, s := range node.Statements {
+		for _, s := range c.liveStatements(node.Statements) {
 			err := c.Compile(s)
 			if err != nil {
 				return err
@@ -537,37 +684,60 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 	case *ast.VarStatement:
 		symbol := c.symbolTable.DefineWithMutability(node.Name.Value, node.IsMutable)
+		constant, isConst, err := c.constValue(node)
+		if err != nil {
+			return err
+		}
 
 		// Track variable type for type inference (Phase 1 optimization)
 		if node.Type != nil {
 			c.varTypes[node.Name.Value] = typeAnnotationToValueType(node.Type)
 			// Also track the full type information for type checking
-			c.typeInfo[node.Name.Value] = ConvertASTType(node.Type)
+			c.typeInfo[node.Name.Value] = c.convertType(node.Type)
+			if c.typeArgs != nil {
+				// The annotation may name a type parameter, as in var best: T
+				c.varTypes[node.Name.Value] = convertToValueType(c.typeInfo[node.Name.Value])
+			}
 		} else if node.Value != nil {
 			// Infer type from value
 			c.varTypes[node.Name.Value] = c.inferExpressionType(node.Value)
 			c.typeInfo[node.Name.Value] = c.inferDetailedType(node.Value)
 		}
 
+		// Phase 3: Track the struct type for offset-based field access
+		structName := c.declaredStruct(node.Type)
+		if node.Type == nil && node.Value != nil {
+			structName = c.structTypeOf(node.Value)
+		}
+
 		if node.Value != nil {
 			// Type check the value if we have a declared type
 			if node.Type != nil {
-				declaredType := ConvertASTType(node.Type)
+				declaredType := c.convertType(node.Type)
 
 				// For arrays and maps, do deep type checking
 				if err := c.checkValueType(node.Value, declaredType); err != nil {
-					return err
+					return typeError(node, err)
+				}
+				if err := c.checkStructValue("variable "+node.Name.Value, structName, node.Value); err != nil {
+					return typeError(node, err)
 				}
 			}
 
-			err := c.Compile(node.Value)
-			if err != nil {
+			if isConst {
+				c.emit(vm.OpPush, c.addConstant(constant))
+			} else if err := c.Compile(node.Value); err != nil {
 				return err
 			}
 		} else {
 			// Default to nil if no value provided
 			c.emit(vm.OpPush, c.addConstant(vm.NilValue()))
 		}
+		c.setStructVar(node.Name.Value, structName)
+		c.trackAlias(node.Name.Value, node.Value)
+		if isConst {
+			c.symbolTable.SetValue(node.Name.Value, constant)
+		}
 
 		if symbol.Scope == GlobalScope {
 			c.emit(vm.OpStoreGlobal, symbol.Index)
@@ -578,12 +748,26 @@ func (c *Compiler) Compile(node ast.Node) error {
 	case *ast.Identifier:
 		symbol, ok := c.symbolTable.Resolve(node.Value)
 		if !ok {
+			if _, generic := c.generics[node.Value]; generic {
+				return fmt.Errorf("generic function %s can only be called", node.Value)
+			}
 			return fmt.Errorf("undefined variable %s", node.Value)
 		}
+		if err := c.checkBareVariant(node, symbol); err != nil {
+			return err
+		}
 
+		c.graph.reference(node)
+		if symbol.Value != nil {
+			c.emit(vm.OpPush, c.addConstant(*symbol.Value))
+			return nil
+		}
 		c.loadSymbol(symbol)
 
 	case *ast.AssignmentStatement:
+		if err := c.checkIncrement(node); err != nil {
+			return err
+		}
 		// Handle different types of left-hand sides
 		switch left := node.Left.(type) {
 		case *ast.Identifier:
@@ -596,55 +780,14 @@ func (c *Compiler) Compile(node ast.Node) error {
 			if !symbol.IsMutable {
 				return fmt.Errorf("cannot assign to const variable %s", left.Value)
 			}
+			if err := c.checkStructValue("variable "+left.Value, c.structVars[left.Value], node.Value); err != nil {
+				return typeError(node, err)
+			}
+			c.trackAlias(left.Value, node.Value)
 
-			// Phase 4B optimization: Detect increment/decrement pattern (i = i + const)
-			if infix, ok := node.Value.(*ast.InfixExpression); ok {
-				if leftIdent, ok := infix.Left.(*ast.Identifier); ok {
-					if leftIdent.Value == left.Value && (infix.Operator == "+" || infix.Operator == "-") {
-						// Check if right side is an integer literal
-						if intLit, ok := infix.Right.(*ast.IntegerLiteral); ok {
-							// Pattern matched: i = i +/- constant
-							amount := int(intLit.Value)
-							if amount >= 0 && amount <= 65535 { // Fits in 2-byte operand
-								if infix.Operator == "+" {
-									if symbol.Scope == GlobalScope {
-										c.emit(vm.OpIncGlobal, symbol.Index, amount)
-									} else {
-										c.emit(vm.OpIncLocal, symbol.Index, amount)
-									}
-								} else { // "-"
-									if symbol.Scope == GlobalScope {
-										c.emit(vm.OpDecGlobal, symbol.Index, amount)
-									} else {
-										c.emit(vm.OpDecLocal, symbol.Index, amount)
-									}
-								}
-								return nil
-							}
-						}
-						// Also handle float literals for float variables
-						if floatLit, ok := infix.Right.(*ast.FloatLiteral); ok {
-							// For floats, we can still use inc/dec if it's a whole number
-							amount := int(floatLit.Value)
-							if float64(amount) == floatLit.Value && amount >= 0 && amount <= 65535 {
-								if infix.Operator == "+" {
-									if symbol.Scope == GlobalScope {
-										c.emit(vm.OpIncGlobal, symbol.Index, amount)
-									} else {
-										c.emit(vm.OpIncLocal, symbol.Index, amount)
-									}
-								} else { // "-"
-									if symbol.Scope == GlobalScope {
-										c.emit(vm.OpDecGlobal, symbol.Index, amount)
-									} else {
-										c.emit(vm.OpDecLocal, symbol.Index, amount)
-									}
-								}
-								return nil
-							}
-						}
-					}
-				}
+			// Phase 4B optimization: i = i + const increments in place
+			if c.compileIncrement(left, symbol, node.Value) {
+				return nil
 			}
 
 			// Compile the value
@@ -658,28 +801,11 @@ func (c *Compiler) Compile(node ast.Node) error {
 		case *ast.IndexExpression:
 			// For array[index] = value or map[key] = value
 			// Stack layout: array/map, index/key, value
+			c.warnAliasChange(node, left.Left)
 
 			// Type checking for array/map assignments
-			containerType := c.inferDetailedType(left.Left)
-			indexType := c.inferDetailedType(left.Index)
-			valueType := c.inferDetailedType(node.Value)
-
-			if arrayType, ok := containerType.(*ArrayType); ok {
-				// Array assignment: check element type
-				if !IsAssignableTo(valueType, arrayType.ElementType) {
-					return fmt.Errorf("cannot assign value of type %s to array element of type %s",
-						valueType.String(), arrayType.ElementType.String())
-				}
-			} else if mapType, ok := containerType.(*MapType); ok {
-				// Map assignment: check key and value types
-				if !IsAssignableTo(indexType, mapType.KeyType) {
-					return fmt.Errorf("cannot use key of type %s for map with key type %s",
-						indexType.String(), mapType.KeyType.String())
-				}
-				if !IsAssignableTo(valueType, mapType.ValueType) {
-					return fmt.Errorf("cannot assign value of type %s to map value of type %s",
-						valueType.String(), mapType.ValueType.String())
-				}
+			if err := checkElementAssignment(c.inferDetailedType, left, node.Value); err != nil {
+				return typeError(node, err)
 			}
 
 			// Compile the array/map
@@ -702,16 +828,20 @@ func (c *Compiler) Compile(node ast.Node) error {
 
 			// Emit specialized opcode based on container type
 			// The compiler knows the type, so we can avoid runtime dispatch
-			if _, ok := containerType.(*MapType); ok {
+			if _, ok := c.inferDetailedType(left.Left).(*MapType); ok {
 				c.emit(vm.OpMapSet)
 			} else {
 				// Array assignment
 				c.emit(vm.OpArraySet)
 			}
 
+		case *ast.TupleElementExpression:
+			return tupleAssignmentError(left)
+
 		case *ast.FieldAccessExpression:
 			// For struct.field = value
 			// Stack layout: struct, [fieldName], value (or struct, value with offset)
+			c.warnAliasChange(node, left.Left)
 
 			// Compile the struct
 			err := c.Compile(left.Left)
@@ -719,23 +849,13 @@ func (c *Compiler) Compile(node ast.Node) error {
 				return err
 			}
 
-			// Phase 3 optimization: Use offset-based field access if possible
-			var structTypeName string
-			if structLit, ok := left.Left.(*ast.StructLiteral); ok {
-				structTypeName = structLit.Name.Value
+			if err := c.checkStructField(c.structTypeOf(left.Left), left.Field.Value, node.Value); err != nil {
+				return typeError(node, err)
 			}
 
-			// Try offset-based access
-			useOffset := false
-			var offset int
-			if structTypeName != "" {
-				if structType, ok := c.structTypes[structTypeName]; ok {
-					offset = structType.GetFieldOffset(left.Field.Value)
-					if offset >= 0 {
-						useOffset = true
-					}
-				}
-			}
+			// Phase 3 optimization: Use offset-based field access if possible
+			offset := c.fieldOffset(left.Left, left.Field.Value)
+			useOffset := offset >= 0
 
 			if !useOffset {
 				// Push field name for name-based access
@@ -775,12 +895,20 @@ func (c *Compiler) Compile(node ast.Node) error {
 				Name:       node.Name.Value,
 				Fields:     make(map[string]string),
 				FieldOrder: make([]string, 0, len(def.Fields)),
+				Defaults:   make(map[string]ast.Expression),
 			}
 
 			// Store field types and order (Phase 3: for offset-based access)
 			for _, field := range def.Fields {
 				structType.Fields[field.Name.Value] = field.Type.String()
 				structType.FieldOrder = append(structType.FieldOrder, field.Name.Value)
+
+				if field.Default != nil {
+					if err := c.checkValueType(field.Default, c.convertType(field.Type)); err != nil {
+						return typeError(field.Default, fmt.Errorf("default of field %s in struct %s: %v", field.Name.Value, node.Name.Value, err))
+					}
+					structType.Defaults[field.Name.Value] = field.Default
+				}
 			}
 
 			c.structTypes[node.Name.Value] = structType
@@ -790,103 +918,63 @@ func (c *Compiler) Compile(node ast.Node) error {
 		}
 
 	case *ast.EnumStatement:
+		values, err := EnumValues(node)
+		if err != nil {
+			return err
+		}
+
 		// Register enum type
 		enumType := &EnumType{
 			Name:         node.Name.Value,
-			Variants:     make(map[string]int),
+			Variants:     make(map[string]vm.Value),
 			VariantNames: make([]string, len(node.Variants)),
 		}
 
-		// Assign integer values to variants (0, 1, 2, ...)
+		if HasPayloads(node) {
+			enumType.Payloads = make(map[string][]Type)
+		}
+
 		for i, variant := range node.Variants {
-			enumType.Variants[variant.Value] = i
+			enumType.Variants[variant.Value] = values[i]
 			enumType.VariantNames[i] = variant.Value
 
-			// Define variant as a constant in the symbol table
-			symbol := c.symbolTable.DefineWithMutability(variant.Value, false)
-
-			// Push the integer value
-			c.emit(vm.OpPush, c.addConstant(vm.IntValue(int64(i))))
-
-			// Store it
-			if symbol.Scope == GlobalScope {
-				c.emit(vm.OpStoreGlobal, symbol.Index)
-			} else {
-				c.emit(vm.OpStoreLocal, symbol.Index)
+			// An enum with payloads is named only qualified, see enum_payloads.go
+			if enumType.Payloads != nil {
+				for _, t := range node.Payloads[i] {
+					enumType.Payloads[variant.Value] = append(enumType.Payloads[variant.Value], ConvertASTType(t))
+				}
+				continue
 			}
+
+			// Define the bare name as a constant too, see enum_access.go
+			c.defineBareVariant(node.Name.Value, variant.Value, values[i])
 		}
 
 		// Store enum type info
 		c.enumTypes[node.Name.Value] = enumType
 
-		// Register in VM runtime registry
-		vm.EnumRegistry[node.Name.Value] = make(map[int]string)
-		for value, name := range enumType.VariantNames {
-			vm.EnumRegistry[node.Name.Value][value] = name
-		}
-
 	case *ast.FunctionStatement:
-		// Build function signature for type checking
-		paramTypes := make([]Type, len(node.Parameters))
-		for i, param := range node.Parameters {
-			paramTypes[i] = ConvertASTType(param.Type)
+		// Generic functions are compiled for each call's types, see generics.go
+		if len(node.TypeParams) > 0 {
+			return c.declareGeneric(node)
 		}
-		returnType := ConvertASTType(node.ReturnType)
 
-		funcType := &FunctionType{
-			ParamTypes: paramTypes,
-			ReturnType: returnType,
-		}
+		// Build function signature for type checking
+		funcType := c.functionSignature(node)
 		c.functionSigs[node.Name.Value] = funcType
 		c.typeInfo[node.Name.Value] = funcType
 
 		// Define the function name in the current scope BEFORE compiling the body
 		// This allows recursive calls
 		symbol := c.symbolTable.Define(node.Name.Value)
+		c.setStructVar(node.Name.Value, "")
+		c.graph.enterFunction(node, funcType)
 
-		c.enterScope()
-
-		// Store the previous return type and set current one
-		prevReturnType := c.currentFunctionRT
-		c.currentFunctionRT = returnType
-
-		// Define parameters in the new scope
-		for i, param := range node.Parameters {
-			c.symbolTable.Define(param.Name.Value)
-			// Track parameter types
-			c.typeInfo[param.Name.Value] = paramTypes[i]
-		}
-
-		err := c.Compile(node.Body)
+		compiledFn, freeSymbols, err := c.compileFunction(node, funcType, node.Name.Value)
 		if err != nil {
 			return err
 		}
-
-		// If the last instruction is not a return, add an implicit return nil
-		if !c.lastInstructionIs(vm.OpReturn) {
-			// Check if function expects a specific non-nil return value
-			if returnType != nil && !returnType.Equals(NilType) && !returnType.Equals(AnyTypeVal) {
-				return fmt.Errorf("function %s must return %s", node.Name.Value, returnType.String())
-			}
-			c.emit(vm.OpPush, c.addConstant(vm.NilValue()))
-			c.emit(vm.OpReturn)
-		}
-
-		// Restore previous return type
-		c.currentFunctionRT = prevReturnType
-
-		// Get the compiled instructions
-		freeSymbols := c.symbolTable.FreeSymbols
-		numLocals := c.symbolTable.numDefinitions
-		instructions := c.leaveScope()
-
-		// Create the function object
-		compiledFn := &vm.Function{
-			Name:         node.Name.Value,
-			NumParams:    len(node.Parameters),
-			NumLocals:    numLocals,
-			Instructions: instructions,
-		}
+		c.graph.leaveFunction()
 
 		// If there are free variables, create a closure
 		if len(freeSymbols) > 0 {
@@ -904,35 +992,32 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.storeSymbol(symbol)
 
 	case *ast.ReturnStatement:
+		if err := checkReturn(c.inferDetailedType, node.ReturnValue, c.currentFunctionRT); err != nil {
+			return typeError(node, err)
+		}
 		if node.ReturnValue != nil {
-			// Type check return value
-			if c.currentFunctionRT != nil {
-				returnValueType := c.inferDetailedType(node.ReturnValue)
-				if !IsAssignableTo(returnValueType, c.currentFunctionRT) {
-					return fmt.Errorf("cannot return %s from function expecting %s",
-						returnValueType.String(), c.currentFunctionRT.String())
-				}
-			}
-
 			err := c.Compile(node.ReturnValue)
 			if err != nil {
 				return err
 			}
+			c.emitReturnCheck()
 		} else {
 			// Returning nil
-			if c.currentFunctionRT != nil && !c.currentFunctionRT.Equals(NilType) && !c.currentFunctionRT.Equals(AnyTypeVal) {
-				return fmt.Errorf("cannot return nil from function expecting %s", c.currentFunctionRT.String())
-			}
 			c.emit(vm.OpPush, c.addConstant(vm.NilValue()))
 		}
 
+		c.emitEndTries(0)
 		c.emit(vm.OpReturn)
 
+	case *ast.TryStatement:
+		return c.compileTry(node)
+
 	case *ast.BreakStatement:
 		loop := c.currentLoop()
 		if loop == nil {
 			return fmt.Errorf("break statement outside of loop")
 		}
+		c.emitEndTries(loop.tries)
 		// Emit a jump with placeholder address
 		pos := c.emit(vm.OpJump, 9999)
 		// Record this position so we can patch it later
@@ -943,30 +1028,51 @@ func (c *Compiler) Compile(node ast.Node) error {
 		if loop == nil {
 			return fmt.Errorf("continue statement outside of loop")
 		}
+		c.emitEndTries(loop.tries)
 		// Emit a jump with placeholder address
 		pos := c.emit(vm.OpJump, 9999)
 		// Record this position so we can patch it later
 		loop.continueJumps = append(loop.continueJumps, pos)
 
 	case *ast.CallExpression:
+		// Shape.Circle(r) makes a variant
+		if enumType, variant := c.payloadVariant(node); enumType != nil {
+			return c.compileVariant(node, enumType, variant)
+		}
+
+		// first(xs) calls the instance of a generic function for xs's type
+		if generic := c.genericCallee(node); generic != nil {
+			return c.compileGenericCall(node, generic)
+		}
+		c.warnAliasCall(node)
+
+		c.graph.call(node, c.symbolTable)
+
 		// Type check function call if we know the function signature
 		if ident, ok := node.Function.(*ast.Identifier); ok {
 			if funcType, exists := c.functionSigs[ident.Value]; exists {
-				// Check argument count
-				if len(node.Arguments) != len(funcType.ParamTypes) {
-					return fmt.Errorf("function %s expects %d arguments, got %d",
-						ident.Value, len(funcType.ParamTypes), len(node.Arguments))
+				if err := c.checkCallArguments(ident.Value, funcType, node.Arguments); err != nil {
+					return typeError(node, err)
 				}
+			}
+		}
 
-				// Check argument types
-				for i, arg := range node.Arguments {
-					argType := c.inferDetailedType(arg)
-					expectedType := funcType.ParamTypes[i]
-					if !IsAssignableTo(argType, expectedType) {
-						return fmt.Errorf("function %s argument %d: expected %s, got %s",
-							ident.Value, i+1, expectedType.String(), argType.String())
+		// Calls to a builtin known at compile time skip loading the builtin
+		// as a value and go straight to it
+		if ident, ok := node.Function.(*ast.Identifier); ok {
+			if symbol, ok := c.symbolTable.Resolve(ident.Value); ok && symbol.Scope == BuiltinScope {
+				for _, arg := range node.Arguments {
+					err := c.Compile(arg)
+					if err != nil {
+						return err
 					}
 				}
+				if op, ok := c.intrinsicOp(ident.Value, node.Arguments); ok {
+					c.emit(op)
+					break
+				}
+				c.emit(vm.OpCallBuiltin, symbol.Index, len(node.Arguments))
+				break
 			}
 		}
 
@@ -985,6 +1091,12 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(vm.OpCall, len(node.Arguments))
 
 	case *ast.ArrayLiteral:
+		// Literal-only arrays are hoisted into the constant pool
+		if constant, ok := c.constantCollection(node); ok {
+			c.emit(vm.OpCopyConst, c.addCollectionConstant(constant))
+			break
+		}
+
 		// Compile each element
 		for _, el := range node.Elements {
 			err := c.Compile(el)
@@ -997,13 +1109,19 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(vm.OpArray, len(node.Elements))
 
 	case *ast.MapLiteral:
-		// Compile each key-value pair
-		for key, value := range node.Pairs {
+		// Literal-only maps are hoisted into the constant pool
+		if constant, ok := c.constantCollection(node); ok {
+			c.emit(vm.OpCopyConst, c.addCollectionConstant(constant))
+			break
+		}
+
+		// Compile each key-value pair, in the order written
+		for _, key := range node.Keys {
 			err := c.Compile(key)
 			if err != nil {
 				return err
 			}
-			err = c.Compile(value)
+			err = c.Compile(node.Pairs[key])
 			if err != nil {
 				return err
 			}
@@ -1016,9 +1134,22 @@ func (c *Compiler) Compile(node ast.Node) error {
 		// Phase 3 optimization: Use offset-based struct creation if type is known
 		if structType, ok := c.structTypes[node.Name.Value]; ok {
 			// We know the struct type - use ordered creation
-			// Compile fields in the correct order, with field names
+			for fieldName, value := range node.Fields {
+				if _, ok := structType.Fields[fieldName]; !ok {
+					return fmt.Errorf("struct %s has no field %s", node.Name.Value, fieldName)
+				}
+				if err := c.checkStructField(node.Name.Value, fieldName, value); err != nil {
+					return typeError(value, err)
+				}
+			}
+
+			// Compile fields in the correct order, with field names. Fields
+			// the literal leaves out take their defaults.
 			for _, fieldName := range structType.FieldOrder {
 				value, exists := node.Fields[fieldName]
+				if !exists {
+					value, exists = structType.Defaults[fieldName]
+				}
 				if !exists {
 					return fmt.Errorf("missing required field %s in struct %s", fieldName, node.Name.Value)
 				}
@@ -1035,26 +1166,21 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(vm.OpPush, c.addConstant(vm.StringValue(node.Name.Value)))
 
 			// Emit OpStructOrdered with number of fields
-			c.emit(vm.OpStructOrdered, len(node.Fields))
+			c.emit(vm.OpStructOrdered, len(structType.FieldOrder))
 		} else {
-			// Fallback to name-based struct creation (for unknown types)
-			// Compile each field first (they'll be popped in reverse order)
-			for fieldName, value := range node.Fields {
-				// Push field name
-				c.emit(vm.OpPush, c.addConstant(vm.StringValue(fieldName)))
-				// Push field value
-				err := c.Compile(value)
-				if err != nil {
-					return err
-				}
-			}
-
-			// Push the type name as a string (last, will be popped first)
-			c.emit(vm.OpPush, c.addConstant(vm.StringValue(node.Name.Value)))
+			return typeError(node, fmt.Errorf("undefined struct type %s", node.Name.Value))
+		}
 
-			// Emit OpStruct with number of fields
-			c.emit(vm.OpStruct, len(node.Fields))
+	case *ast.NewExpression:
+		structType, ok := c.structTypes[node.Type.Value]
+		if !ok {
+			return fmt.Errorf("new: unknown struct %s", node.Type.Value)
+		}
+		literal, err := NewLiteral(node, structType.FieldOrder)
+		if err != nil {
+			return err
 		}
+		return c.Compile(literal)
 
 	case *ast.IndexExpression:
 		// Type checking for map key access
@@ -1089,38 +1215,39 @@ func (c *Compiler) Compile(node ast.Node) error {
 			c.emit(vm.OpArrayGet)
 		}
 
+	case *ast.SliceExpression:
+		return c.compileSlice(node)
+
+	case *ast.CastExpression:
+		return c.compileCast(node)
+
+	case *ast.TupleLiteral:
+		return c.compileTupleLiteral(node)
+
+	case *ast.TupleElementExpression:
+		return c.compileTupleElement(node)
+
 	case *ast.FieldAccessExpression:
+		// Color.Red is a constant
+		if value, ok, err := c.qualifiedVariant(node); ok {
+			if err != nil {
+				return err
+			}
+			c.emit(vm.OpPush, c.addConstant(value))
+			return nil
+		}
+
 		// Compile the struct expression
 		err := c.Compile(node.Left)
 		if err != nil {
 			return err
 		}
 
-		// Phase 3 optimization: Use offset-based field access if possible
-		// Try to determine the struct type from the left expression
-		var structTypeName string
-		if ident, ok := node.Left.(*ast.Identifier); ok {
-			// Check if this is a known variable with struct type
-			if varType, exists := c.varTypes[ident.Value]; exists && varType == vm.StructType {
-				// We'd need more detailed type tracking to know which struct type
-				// For now, fall through to name-based access
-			}
-		}
-		// Check if left is a struct literal - we know the type directly
-		if structLit, ok := node.Left.(*ast.StructLiteral); ok {
-			structTypeName = structLit.Name.Value
-		}
-
-		// If we know the struct type, use offset-based access
-		if structTypeName != "" {
-			if structType, ok := c.structTypes[structTypeName]; ok {
-				offset := structType.GetFieldOffset(node.Field.Value)
-				if offset >= 0 {
-					// Use offset-based access - much faster!
-					c.emit(vm.OpGetFieldOffset, offset)
-					return nil
-				}
-			}
+		// Phase 3 optimization: Use offset-based field access if the
+		// struct type of the left expression is known
+		if offset := c.fieldOffset(node.Left, node.Field.Value); offset >= 0 {
+			c.emit(vm.OpGetFieldOffset, offset)
+			return nil
 		}
 
 		// Fallback to name-based access
@@ -1128,101 +1255,35 @@ func (c *Compiler) Compile(node ast.Node) error {
 		c.emit(vm.OpGetField)
 
 	case *ast.SwitchStatement:
-		// Compile the switch value
-		err := c.Compile(node.Value)
+		return c.compileSwitch(node, false)
+
+	case *ast.SwitchExpression:
+		return c.compileSwitch(node.SwitchStatement, true)
+
+	case *ast.LookupStatement:
+		stmts, err := c.lowerLookup(node)
 		if err != nil {
 			return err
 		}
-
-		// We'll compile switch as a series of comparisons and jumps
-		// For each case:
-		//   1. Duplicate switch value on stack
-		//   2. Push case value
-		//   3. Compare (OpEq)
-		//   4. Jump to case body if true
-		//   5. Otherwise continue to next case
-
-		jumpToEnd := []int{}        // Collect jumps to end of switch
-		jumpToCaseBody := []int{}  // Jumps to case bodies
-
-		for _, caseClause := range node.Cases {
-			// Duplicate switch value for comparison
-			c.emit(vm.OpDup)
-
-			// Compile case value
-			err := c.Compile(caseClause.Value)
-			if err != nil {
+		c.inLookup = true
+		for _, stmt := range stmts {
+			if err := c.Compile(stmt); err != nil {
 				return err
 			}
-
-			// Compare
-			c.emit(vm.OpEq)
-
-			// Jump to case body if equal (placeholder)
-			// OpJumpIfTrue will pop the comparison result
-			jumpIfTrue := c.emit(vm.OpJumpIfTrue, 9999)
-			jumpToCaseBody = append(jumpToCaseBody, jumpIfTrue)
-
-			// Note: OpJumpIfTrue already popped the comparison result
 		}
+		c.inLookup = false
 
-		// If no cases matched, jump to default or end
-		jumpToDefaultOrEnd := c.emit(vm.OpJump, 9999)
-
-		// Compile case bodies
-		caseBodyPositions := []int{}
-		for i, caseClause := range node.Cases {
-			// Record position of this case body
-			caseBodyPos := len(c.currentInstructions())
-			caseBodyPositions = append(caseBodyPositions, caseBodyPos)
-
-			// Patch the jump for this case
-			c.changeOperand(jumpToCaseBody[i], caseBodyPos)
-
-			// Pop the switch value (OpJumpIfTrue already popped the comparison result)
-			c.emit(vm.OpPop)
-
-			// Compile case body
-			err := c.Compile(caseClause.Body)
-			if err != nil {
-				return err
-			}
-
-			// Jump to end after case body
-			jumpToEnd = append(jumpToEnd, c.emit(vm.OpJump, 9999))
+	case *ast.ForInStatement:
+		stmts, err := c.lowerForIn(node)
+		if err != nil {
+			return err
 		}
-
-		// Check exhaustiveness for enum switches
-		if node.Default == nil {
-			err := c.checkSwitchExhaustiveness(node)
-			if err != nil {
+		for _, stmt := range stmts {
+			if err := c.Compile(stmt); err != nil {
 				return err
 			}
 		}
 
-		// Default case
-		defaultPos := len(c.currentInstructions())
-		c.changeOperand(jumpToDefaultOrEnd, defaultPos)
-
-		if node.Default != nil {
-			// Pop the switch value
-			c.emit(vm.OpPop)
-
-			err := c.Compile(node.Default)
-			if err != nil {
-				return err
-			}
-		} else {
-			// No default, just pop the switch value
-			c.emit(vm.OpPop)
-		}
-
-		// Patch all jumps to end
-		endPos := len(c.currentInstructions())
-		for _, jumpPos := range jumpToEnd {
-			c.changeOperand(jumpPos, endPos)
-		}
-
 	case *ast.ForStatement:
 		// Enter loop context for break/continue
 		c.enterLoop()
@@ -1313,27 +1374,308 @@ func (c *Compiler) storeSymbol(s Symbol) {
 	}
 }
 
+// functionSignature returns the signature node's annotations declare
+func (c *Compiler) functionSignature(node *ast.FunctionStatement) *FunctionType {
+	paramTypes := make([]Type, len(node.Parameters))
+	paramStructs := make([]string, len(node.Parameters))
+	for i, param := range node.Parameters {
+		paramTypes[i] = c.convertType(param.Type)
+		paramStructs[i] = c.declaredStruct(param.Type)
+	}
+	return &FunctionType{
+		ParamTypes:   paramTypes,
+		ReturnType:   c.convertType(node.ReturnType),
+		ParamStructs: paramStructs,
+	}
+}
+
+// compileFunction compiles the body of node, whose signature is funcType, in
+// a scope of its own, into a function called name. It returns the function
+// and the variables of enclosing functions the body uses, which a closure
+// has to capture. Types recorded in the body don't outlive it.
+func (c *Compiler) compileFunction(node *ast.FunctionStatement, funcType *FunctionType, name string) (*vm.Function, []Symbol, error) {
+	c.enterScope()
+
+	// Store the previous return type and set current one
+	prevReturnType, prevFunction := c.currentFunctionRT, c.currentFunction
+	c.currentFunctionRT, c.currentFunction = funcType.ReturnType, name
+
+	// Tries around the declaration don't cover the body's returns
+	prevTries := c.tries
+	c.tries = 0
+
+	prevVarTypes, prevTypeInfo := maps.Clone(c.varTypes), maps.Clone(c.typeInfo)
+	prevStructVars, prevAliases := maps.Clone(c.structVars), maps.Clone(c.constAliases)
+
+	// Define parameters in the new scope
+	for i, param := range node.Parameters {
+		c.symbolTable.Define(param.Name.Value)
+		c.setStructVar(param.Name.Value, c.declaredStruct(param.Type))
+		c.trackAlias(param.Name.Value, nil)
+		// Track parameter types
+		c.typeInfo[param.Name.Value] = funcType.ParamTypes[i]
+		if _, unknown := funcType.ParamTypes[i].(*AnyType); !unknown {
+			c.varTypes[param.Name.Value] = convertToValueType(funcType.ParamTypes[i])
+		} else {
+			delete(c.varTypes, param.Name.Value)
+		}
+	}
+	c.emitParameterChecks(node, funcType.ParamTypes)
+
+	err := c.Compile(node.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// If the last instruction is not a return, add an implicit return nil
+	if !c.lastInstructionIs(vm.OpReturn) {
+		// Check if function expects a specific non-nil return value
+		returnType := funcType.ReturnType
+		if returnType != nil && !returnType.Equals(NilType) && !returnType.Equals(AnyTypeVal) {
+			return nil, nil, fmt.Errorf("function %s must return %s", node.Name.Value, returnType.String())
+		}
+		c.emit(vm.OpPush, c.addConstant(vm.NilValue()))
+		c.emit(vm.OpReturn)
+	}
+
+	// Restore previous return type
+	c.currentFunctionRT, c.currentFunction = prevReturnType, prevFunction
+	c.tries = prevTries
+	c.varTypes, c.typeInfo = prevVarTypes, prevTypeInfo
+	c.structVars, c.constAliases = prevStructVars, prevAliases
+
+	// Get the compiled instructions
+	freeSymbols := c.symbolTable.FreeSymbols
+	numLocals := c.symbolTable.numDefinitions
+	lines := c.scopes[c.scopeIndex].lines
+	instructions := c.leaveScope()
+
+	return &vm.Function{
+		Name:         name,
+		NumParams:    len(node.Parameters),
+		NumLocals:    numLocals,
+		Instructions: instructions,
+		Lines:        lines,
+	}, freeSymbols, nil
+}
+
+// checkCallArguments checks the number and types of the arguments of a call
+// to the function name, whose signature is funcType
+func (c *Compiler) checkCallArguments(name string, funcType *FunctionType, args []ast.Expression) error {
+	if err := checkArguments(c.inferDetailedType, name, funcType, args); err != nil {
+		return err
+	}
+
+	// Struct arguments must have the struct type of their parameter
+	for i, arg := range args {
+		if i < len(funcType.ParamStructs) {
+			what := fmt.Sprintf("function %s argument %d", name, i+1)
+			if err := c.checkStructValue(what, funcType.ParamStructs[i], arg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// compileIf compiles an if statement, or with asValue an if expression
+// whose branches each leave their value on the stack
+func (c *Compiler) compileIf(node *ast.IfStatement, asValue bool) error {
+	compileBody := c.bodyCompiler(node, asValue)
+
+	err := c.Compile(node.Condition)
+	if err != nil {
+		return err
+	}
+
+	// Emit jump instruction with placeholder
+	jumpNotTruthyPos := c.emit(vm.OpJumpIfFalse, 9999)
+
+	err = compileBody(node.Consequence)
+	if err != nil {
+		return err
+	}
+
+	// Emit jump to skip alternative
+	jumpPos := c.emit(vm.OpJump, 9999)
+
+	afterConsequencePos := len(c.currentInstructions())
+	c.changeOperand(jumpNotTruthyPos, afterConsequencePos)
+
+	if asValue {
+		// An if expression always has an else block
+		err := compileBody(node.Alternative.(*ast.BlockStatement))
+		if err != nil {
+			return err
+		}
+	} else if node.Alternative != nil {
+		err := c.Compile(node.Alternative)
+		if err != nil {
+			return err
+		}
+	}
+
+	afterAlternativePos := len(c.currentInstructions())
+	c.changeOperand(jumpPos, afterAlternativePos)
+
+	return nil
+}
+
+// compileSwitch compiles a switch statement, or with asValue a switch
+// expression, whose branches each leave their value on the stack
+func (c *Compiler) compileSwitch(node *ast.SwitchStatement, asValue bool) error {
+	compileBody := c.bodyCompiler(node, asValue)
+
+	// Cases that are patterns test the value instead of comparing it, see
+	// patterns.go and enum_payloads.go
+	patterns, err := c.casePatterns(node)
+	if err != nil {
+		return err
+	}
+
+	// Compile the switch value
+	err = c.Compile(node.Value)
+	if err != nil {
+		return err
+	}
+
+	// We'll compile switch as a series of comparisons and jumps
+	// For each case:
+	//   1. Duplicate switch value on stack
+	//   2. Push case value
+	//   3. Compare (OpEq)
+	//   4. Jump to case body if true
+	//   5. Otherwise continue to next case
+
+	jumpToEnd := []int{}        // Collect jumps to end of switch
+	jumpToCaseBody := []int{}  // Jumps to case bodies
+
+	for i, caseClause := range node.Cases {
+		// Duplicate switch value for comparison
+		c.emit(vm.OpDup)
+
+		if patterns[i] != nil {
+			patterns[i].test(c)
+		} else {
+			// Compile case value
+			err := c.Compile(caseClause.Value)
+			if err != nil {
+				return err
+			}
+
+			// Compare
+			c.emit(vm.OpEq)
+		}
+
+		// Jump to case body if equal (placeholder)
+		// OpJumpIfTrue will pop the comparison result
+		jumpIfTrue := c.emit(vm.OpJumpIfTrue, 9999)
+		jumpToCaseBody = append(jumpToCaseBody, jumpIfTrue)
+
+		// Note: OpJumpIfTrue already popped the comparison result
+	}
+
+	// If no cases matched, jump to default or end
+	jumpToDefaultOrEnd := c.emit(vm.OpJump, 9999)
+
+	// Compile case bodies
+	caseBodyPositions := []int{}
+	for i, caseClause := range node.Cases {
+		// Record position of this case body
+		caseBodyPos := len(c.currentInstructions())
+		caseBodyPositions = append(caseBodyPositions, caseBodyPos)
+
+		// Patch the jump for this case
+		c.changeOperand(jumpToCaseBody[i], caseBodyPos)
+
+		// Pop the switch value (OpJumpIfTrue already popped the comparison
+		// result), binding the names a pattern gives its parts
+		if patterns[i] != nil {
+			patterns[i].bind(c)
+		} else {
+			c.emit(vm.OpPop)
+		}
+
+		// Compile case body
+		err := compileBody(caseClause.Body)
+		if err != nil {
+			return err
+		}
+
+		// Jump to end after case body
+		jumpToEnd = append(jumpToEnd, c.emit(vm.OpJump, 9999))
+	}
+
+	// Check exhaustiveness for enum switches
+	if node.Default == nil {
+		err := c.checkSwitchExhaustiveness(node)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Default case
+	defaultPos := len(c.currentInstructions())
+	c.changeOperand(jumpToDefaultOrEnd, defaultPos)
+
+	if node.Default != nil {
+		// Pop the switch value
+		c.emit(vm.OpPop)
+
+		err := compileBody(node.Default)
+		if err != nil {
+			return err
+		}
+	} else {
+		// No default, just pop the switch value
+		c.emit(vm.OpPop)
+		if asValue {
+			// Unreachable for an exhaustive enum switch, but an expression
+			// leaves a value on every path
+			c.emit(vm.OpPush, c.addConstant(vm.NilValue()))
+		}
+	}
+
+	// Patch all jumps to end
+	endPos := len(c.currentInstructions())
+	for _, jumpPos := range jumpToEnd {
+		c.changeOperand(jumpPos, endPos)
+	}
+
+	return nil
+}
+
+// bodyCompiler returns the function compiling the bodies of an if or switch
+// statement. With asValue the statement is an expression, and each body
+// leaves the value of its final expression on the stack.
+func (c *Compiler) bodyCompiler(node ast.Statement, asValue bool) func(*ast.BlockStatement) error {
+	if !asValue {
+		return func(block *ast.BlockStatement) error {
+			return c.Compile(block)
+		}
+	}
+
+	resultType := c.inferBranchValueType(node)
+	return func(block *ast.BlockStatement) error {
+		last := len(block.Statements) - 1
+		for _, s := range c.liveStatements(block.Statements[:last]) {
+			err := c.Compile(s)
+			if err != nil {
+				return err
+			}
+		}
+		return c.Compile(c.branchValue(block, resultType))
+	}
+}
+
 // checkSwitchExhaustiveness checks if a switch statement on an enum is exhaustive
 func (c *Compiler) checkSwitchExhaustiveness(node *ast.SwitchStatement) error {
 	// Try to determine the enum type of the switch value
 	var enumType *EnumType
 
-	// Check if the switch value is an identifier that's an enum variant
-	if ident, ok := node.Value.(*ast.Identifier); ok {
-		// Check if this identifier is a known enum variant
-		for _, et := range c.enumTypes {
-			for _, variantName := range et.VariantNames {
-				if ident.Value == variantName {
-					// This is an enum variant, but we're switching on the variant itself
-					// which is just an int, not useful for exhaustiveness checking
-					return nil
-				}
-			}
-		}
-
-		// Check if this is a variable that holds an enum value
-		// We need to track which enum type a variable belongs to
-		// For now, we'll use a heuristic: check if all case values are from the same enum
+	// Switching on a variant itself tells nothing about exhaustiveness
+	if enumType, _ := c.enumVariant(node.Value); enumType != nil {
+		return nil
 	}
 
 	// Try to infer enum type from case values
@@ -1342,21 +1684,21 @@ func (c *Compiler) checkSwitchExhaustiveness(node *ast.SwitchStatement) error {
 	var detectedEnumType *EnumType
 
 	for _, caseClause := range node.Cases {
-		if caseIdent, ok := caseClause.Value.(*ast.Identifier); ok {
-			// Check which enum this variant belongs to
-			for _, et := range c.enumTypes {
-				if _, exists := et.Variants[caseIdent.Value]; exists {
-					if detectedEnumType == nil {
-						detectedEnumType = et
-					} else if detectedEnumType.Name != et.Name {
-						// Mixed enums in switch - can't check exhaustiveness
-						return nil
-					}
-					caseVariants[caseIdent.Value] = true
-					break
-				}
-			}
+		value := caseClause.Value
+		if access, _, ok, _ := CasePattern(value); ok {
+			value = access
+		}
+		et, variant := c.enumVariant(value)
+		if et == nil {
+			continue
+		}
+		if detectedEnumType == nil {
+			detectedEnumType = et
+		} else if detectedEnumType.Name != et.Name {
+			// Mixed enums in switch - can't check exhaustiveness
+			return nil
 		}
+		caseVariants[variant] = true
 	}
 
 	// If we detected an enum type, check exhaustiveness
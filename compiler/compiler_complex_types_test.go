@@ -227,6 +227,7 @@ len(m);
 
 func TestStructLiteral(t *testing.T) {
 	input := `
+type Person = struct { name: string, age: int }
 var p = Person{name: "Alice", age: 30};
 p.name;
 `
@@ -263,6 +264,7 @@ p.name;
 
 func TestStructFieldAssignment(t *testing.T) {
 	input := `
+type Person = struct { name: string, age: int }
 var p = Person{name: "Alice", age: 30};
 p.age = 31;
 p.age;
@@ -0,0 +1,100 @@
+package compiler
+
+import (
+	"bytes"
+	"minlang/lexer"
+	"minlang/parser"
+	"minlang/vm"
+	"testing"
+)
+
+func compileSource(t *testing.T, input string) *Compiler {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	c := New()
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return c
+}
+
+// countOp counts occurrences of op in a stack instruction stream
+func countOp(ins []byte, op vm.OpCode) int {
+	return bytes.Count([]byte(vm.Disassemble(ins)), []byte(" "+op.String()+" "))
+}
+
+func TestConstantLiteralsAreHoisted(t *testing.T) {
+	c := compileSource(t, `
+var total: int = 0;
+for var i: int = 0; i < 3; i = i + 1 {
+    var xs: []string = ["a", "b", "c"];
+    var ys: []string = ["a", "b", "c"];
+    var m: map[string]int = map[string]int{"a": 1, "b": -2};
+    total = total + len(xs) + len(ys) + m["b"];
+}
+`)
+	bytecode := c.Bytecode()
+
+	if n := countOp(bytecode.Instructions, vm.OpArray); n != 0 {
+		t.Errorf("expected no OpArray for literal-only arrays, got %d", n)
+	}
+	if n := countOp(bytecode.Instructions, vm.OpMap); n != 0 {
+		t.Errorf("expected no OpMap for literal-only maps, got %d", n)
+	}
+	if n := countOp(bytecode.Instructions, vm.OpCopyConst); n != 3 {
+		t.Errorf("expected 3 OpCopyConst, got %d", n)
+	}
+
+	// The two equal arrays share one constant
+	arrays := 0
+	for _, constant := range bytecode.Constants {
+		if constant.Type == vm.ArrayType {
+			arrays++
+		}
+	}
+	if arrays != 1 {
+		t.Errorf("expected 1 array constant, got %d", arrays)
+	}
+}
+
+func TestNonConstantLiteralsAreBuilt(t *testing.T) {
+	c := compileSource(t, `
+var x: int = 1;
+var xs: []int = [x, 2];
+var nested: [][]int = [[1], [2]];
+`)
+	bytecode := c.Bytecode()
+
+	// [x, 2], [1], [2] and the outer array: only the inner ones are constant
+	if n := countOp(bytecode.Instructions, vm.OpArray); n != 2 {
+		t.Errorf("expected 2 OpArray, got %d", n)
+	}
+	if n := countOp(bytecode.Instructions, vm.OpCopyConst); n != 2 {
+		t.Errorf("expected 2 OpCopyConst, got %d", n)
+	}
+}
+
+func TestScalarConstantsAreShared(t *testing.T) {
+	c := compileSource(t, `
+var a: int = 1;
+var b: int = a + 1;
+var f: float = 1.0;
+var yes: bool = true;
+var s: string = "1";
+var s2: string = "1";
+func one(): int { return 1 }
+print(a, b, f * 1.0, yes, s + s2, one() == 1);
+`)
+
+	// 1, 1.0, true and "1" have equal bits or text but different types, and
+	// each is stored once; the function is the only other constant
+	if n := len(c.Bytecode().Constants); n != 5 {
+		t.Errorf("expected 5 constants, got %d: %v", n, c.Bytecode().Constants)
+	}
+}
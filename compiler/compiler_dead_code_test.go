@@ -0,0 +1,55 @@
+package compiler
+
+import (
+	"minlang/vm"
+	"reflect"
+	"testing"
+)
+
+func TestDeadCodeElimination(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		live     string // Same program without the unreachable code
+		warnings []string
+	}{
+		{
+			"after return",
+			"func f(): int {\n    return 1\n    print(2)\n    return 3\n}\nf()",
+			"func f(): int {\n    return 1\n}\nf()",
+			[]string{"3:5: unreachable code"},
+		},
+		{
+			"after break and continue",
+			"var i: int = 0;\nfor i < 3 {\n    i = i + 1\n    continue\n    i = 10\n}\nfor true {\n    break\n    print(i)\n}",
+			"var i: int = 0;\nfor i < 3 {\n    i = i + 1\n    continue\n}\nfor true {\n    break\n}",
+			[]string{"5:5: unreachable code", "9:5: unreachable code"},
+		},
+		{
+			"after an if where both branches return",
+			"func f(b: bool): int {\n    if b { return 1 } else { return 2 }\n    return 3\n}\nf(true)",
+			"func f(b: bool): int {\n    if b { return 1 } else { return 2 }\n}\nf(true)",
+			[]string{"3:5: unreachable code"},
+		},
+		{
+			"if without else",
+			"func f(b: bool): int {\n    if b { return 1 }\n    return 3\n}\nf(true)",
+			"func f(b: bool): int {\n    if b { return 1 }\n    return 3\n}\nf(true)",
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		c := compileSource(t, tt.input)
+		live := compileSource(t, tt.live)
+
+		if !reflect.DeepEqual(c.Warnings(), tt.warnings) {
+			t.Errorf("%s: expected warnings %q, got %q", tt.name, tt.warnings, c.Warnings())
+		}
+		if got, want := c.Bytecode(), live.Bytecode(); !reflect.DeepEqual(got.Instructions, want.Instructions) ||
+			len(got.Constants) != len(want.Constants) {
+			t.Errorf("%s: expected the unreachable code to be dropped\ngot:\n%s\nwant:\n%s",
+				tt.name, vm.Disassemble(got.Instructions), vm.Disassemble(want.Instructions))
+		}
+	}
+}
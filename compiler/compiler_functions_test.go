@@ -1,7 +1,10 @@
 package compiler
 
 import (
+	"bytes"
+	"minlang/ast"
 	"minlang/vm"
+	"strings"
 	"testing"
 )
 
@@ -160,3 +163,123 @@ func TestRecursiveFunctions(t *testing.T) {
 		testExpectedValue(t, tt.expected, stackElem)
 	}
 }
+
+func TestBuiltinCallsAreDirect(t *testing.T) {
+	c := compileSource(t, `
+var xs: []int = [1, 2, 3];
+var n: int = len(xs);
+var f = len;
+var m = f(xs);
+`)
+	ins := c.Bytecode().Instructions
+
+	// len(xs) calls the builtin directly; f(xs) goes through the value
+	if n := countOp(ins, vm.OpCallBuiltin); n != 1 {
+		t.Errorf("expected 1 OpCallBuiltin, got %d\n%s", n, vm.Disassemble(ins))
+	}
+	if n := countOp(ins, vm.OpGetBuiltin); n != 1 {
+		t.Errorf("expected 1 OpGetBuiltin, got %d\n%s", n, vm.Disassemble(ins))
+	}
+
+	machine := vm.New(c.Bytecode())
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+}
+
+func TestNumericBuiltinsAreIntrinsics(t *testing.T) {
+	input := `
+var x: int = -3;
+var y: float = 6.25;
+print(abs(x), min(x, 4), max(x, 4));
+print(abs(-y), sqrt(y), min(y, 1.5), max(y, 1.5), sqrt(16));
+print(min(x, y), max([1, 2]));
+`
+	c := compileSource(t, input)
+	listing := vm.Disassemble(c.Bytecode().Instructions)
+
+	// Mixed and array arguments still call the builtin
+	for _, op := range []vm.OpCode{vm.OpAbsInt, vm.OpMinInt, vm.OpMaxInt, vm.OpAbsFloat,
+		vm.OpSqrtFloat, vm.OpMinFloat, vm.OpMaxFloat, vm.OpSqrtInt} {
+		if !strings.Contains(listing, " "+op.String()+"\n") {
+			t.Errorf("expected %s\n%s", op, listing)
+		}
+	}
+	if n := countOp(c.Bytecode().Instructions, vm.OpCallBuiltin); n != 5 {
+		t.Errorf("expected 5 OpCallBuiltin (3 prints, min and max), got %d\n%s", n, listing)
+	}
+
+	rc := NewRegisterCompiler()
+	if _, err := rc.CompileToRegister(parse(input)); err != nil {
+		t.Fatalf("compilation error: %s", err)
+	}
+	registerListing := vm.RegisterDisassemble(rc.RegisterBytecode().Instructions)
+	for _, op := range []vm.RegisterOpCode{vm.OpRAbsInt, vm.OpRMinInt, vm.OpRMaxInt, vm.OpRAbsFloat,
+		vm.OpRSqrtFloat, vm.OpRMinFloat, vm.OpRMaxFloat, vm.OpRSqrtInt} {
+		if !strings.Contains(registerListing, " "+op.String()+" ") {
+			t.Errorf("expected %s\n%s", op, registerListing)
+		}
+	}
+
+	expected := "3 -3 4\n6.250000 2.500000 1.500000 6.250000 4.000000\n-3.000000 2\n"
+	var stackOut, registerOut bytes.Buffer
+	if err := vm.New(c.Bytecode(), vm.WithStdout(&stackOut)).Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if err := vm.NewRegisterVM(rc.RegisterBytecode(), vm.WithStdout(&registerOut)).Run(); err != nil {
+		t.Fatalf("register vm error: %s", err)
+	}
+	if stackOut.String() != expected || registerOut.String() != expected {
+		t.Errorf("expected %q, got %q (stack) and %q (register)", expected, stackOut.String(), registerOut.String())
+	}
+}
+
+// TestCallReturnTypes checks that a call of a function with a declared return
+// type has that type, for type checking and for picking typed instructions
+func TestCallReturnTypes(t *testing.T) {
+	c := compileSource(t, `
+func count(): int { return 3 }
+func label(n: int): string { return "n" + string(n) }
+func pair(): (int, string) { return (1, "a") }
+func untyped() { print("x") }
+var f = label
+`)
+	tests := []struct {
+		call     string
+		detailed string
+		value    vm.ValueType
+	}{
+		{"count()", "int", vm.IntType},
+		{"label(1)", "string", vm.StringType},
+		{"f(2)", "string", vm.StringType},
+		{"pair()", "(int, string)", vm.TupleType},
+		{"pair().1", "string", vm.StringType},
+		{"count() * 2", "int", vm.IntType},
+		{"label(count()) + \"!\"", "string", vm.StringType},
+		{"untyped()", "any", vm.IntType},
+	}
+	for _, tt := range tests {
+		expr := parse(tt.call).Statements[0].(*ast.ExpressionStatement).Expression
+		if got := c.inferDetailedType(expr).String(); got != tt.detailed {
+			t.Errorf("%s: expected type %s, got %s", tt.call, tt.detailed, got)
+		}
+		if got := c.inferExpressionType(expr); got != tt.value {
+			t.Errorf("%s: expected value type %s, got %s", tt.call, tt.value, got)
+		}
+	}
+
+	errors := []struct {
+		input string
+		err   string
+	}{
+		{"func name(): string { return \"a\" }\nvar n: int = name()", "2:1: cannot assign value of type string to type int"},
+		{"func half(x: int): float { return x / 2.0 }\nvar n: int = 1 + half(3)", "2:1: cannot assign value of type float to type int"},
+		{"func name(): string { return \"a\" }\nfunc size(): int { return name() }", "2:20: cannot return string from function expecting int"},
+	}
+	for _, tt := range errors {
+		err := New().Compile(parse(tt.input))
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%q: expected error %q, got %v", tt.input, tt.err, err)
+		}
+	}
+}
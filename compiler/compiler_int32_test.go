@@ -0,0 +1,78 @@
+package compiler
+
+import (
+	"minlang/lexer"
+	"minlang/parser"
+	"minlang/vm"
+	"strings"
+	"testing"
+)
+
+func compileInt32(t *testing.T, input string) (*Compiler, error) {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	c := New()
+	c.SetInt32(true)
+	return c, c.Compile(program)
+}
+
+func TestInt32Wraps(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"2147483647 + 1", -2147483648},
+		{"var n = -2147483648; n - 1", 2147483647},
+		{"var n = -2147483648; -n", -2147483648},
+		{"var n = 100000; n * n", 1410065408},
+		{"var n = 2147483647; n = n + 1; n", -2147483648},
+		{"floor(4294967296.5)", 0},
+		{"var xs = [2147483647, 1]; sum(xs)", -2147483648},
+	}
+
+	for _, tt := range tests {
+		c, err := compileInt32(t, tt.input)
+		if err != nil {
+			t.Fatalf("input %q: compilation error: %s", tt.input, err)
+		}
+
+		machine := vm.New(c.Bytecode())
+		if err := machine.Run(); err != nil {
+			t.Fatalf("input %q: vm error: %s", tt.input, err)
+		}
+
+		result := machine.LastPoppedStackElem()
+		if result.Type != vm.IntType || result.AsInt() != tt.expected {
+			t.Errorf("input %q: expected %d, got %s", tt.input, tt.expected, result.String())
+		}
+	}
+}
+
+func TestInt32LeavesOtherValues(t *testing.T) {
+	c, err := compileInt32(t, `var s = "a" + "b"; var f = 1.5 * 4.0; var b = 1 < 2`)
+	if err != nil {
+		t.Fatalf("compilation error: %s", err)
+	}
+	if strings.Contains(vm.Disassemble(c.Bytecode().Instructions), vm.OpWrapInt32.String()) {
+		t.Errorf("expected no %s for string, float and bool expressions", vm.OpWrapInt32)
+	}
+}
+
+func TestInt32LiteralRange(t *testing.T) {
+	for _, input := range []string{"var x = 2147483648", "var x = 1 + 2147483648", "var xs = [1, -2147483649]"} {
+		_, err := compileInt32(t, input)
+		if err == nil || !strings.Contains(err.Error(), "overflows int32") {
+			t.Errorf("input %q: expected an overflow error, got %v", input, err)
+		}
+	}
+
+	if _, err := compileInt32(t, "var x = -2147483648; var xs = [2147483647]"); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+}
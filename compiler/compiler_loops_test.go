@@ -44,6 +44,26 @@ func TestForLoops(t *testing.T) {
 			result;`,
 			10,
 		},
+		{
+			// Loop over a range, counting down
+			`var sum: int = 0;
+			for i in range(10, 0, -3) {
+				sum = sum * 100 + i;
+			}
+			sum;`,
+			10070401, // 10, 7, 4, 1
+		},
+		{
+			// Loop over an array; assigning the variable doesn't change it
+			`var xs = [3, 4, 5];
+			var sum: int = 0;
+			for x in xs {
+				sum = sum + x;
+				x = 100;
+			}
+			sum + xs[0];`,
+			15,
+		},
 	}
 
 	for _, tt := range tests {
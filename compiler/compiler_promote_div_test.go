@@ -0,0 +1,66 @@
+package compiler
+
+import (
+	"minlang/lexer"
+	"minlang/parser"
+	"minlang/vm"
+	"strings"
+	"testing"
+)
+
+func compilePromoted(t *testing.T, input string) (*Compiler, error) {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	c := New()
+	c.SetPromoteIntDiv(true)
+	return c, c.Compile(program)
+}
+
+func TestPromoteIntDivResult(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"7 / 2", 3.5},
+		{"var a: int = 9; a / 2", 4.5},
+		{"var a: int = 1; var b: int = 4; a / b", 0.25},
+		{"var f: float = 3.0; f / 2.0", 1.5},
+	}
+
+	for _, tt := range tests {
+		c, err := compilePromoted(t, tt.input)
+		if err != nil {
+			t.Fatalf("input %q: compilation error: %s", tt.input, err)
+		}
+
+		machine := vm.New(c.Bytecode())
+		if err := machine.Run(); err != nil {
+			t.Fatalf("input %q: vm error: %s", tt.input, err)
+		}
+
+		result := machine.LastPoppedStackElem()
+		if result.Type != vm.FloatType || result.AsFloat() != tt.expected {
+			t.Errorf("input %q: expected %f, got %s", tt.input, tt.expected, result.String())
+		}
+	}
+}
+
+func TestPromoteIntDivTypeChecking(t *testing.T) {
+	_, err := compilePromoted(t, "var x: int = 7 / 2;")
+	if err == nil {
+		t.Fatal("expected type error assigning promoted division to int")
+	}
+	if !strings.Contains(err.Error(), "cannot assign value of type float to type int") {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if _, err := compilePromoted(t, "var y: float = 7 / 2;"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
@@ -131,6 +131,76 @@ func TestConditionals(t *testing.T) {
 	}
 }
 
+func TestConditionalExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"var x: int = if 1 < 2 { 10 } else { 20 }; x;", 10},
+		{"var x: int = 3; var y = if x > 5 { 1 } else if x > 2 { 2 } else { 3 }; y;", 2},
+		{"var x: int = 2; var y = switch x { case 1 { 10 } case 2 { var z = 5; z * 4 } default { 30 } }; y;", 20},
+		{"var t: int = 0; for var i = 0; i < 4; i = i + 1 { t = t + if i > 1 { i } else { 0 }; } t;", 5},
+		{"(if false { 1 } else { 2 }) == 2;", true},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		compiler := New()
+		err := compiler.Compile(program)
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := vm.New(compiler.Bytecode())
+		err = vm.Run()
+		if err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		stackElem := vm.LastPoppedStackElem()
+
+		testExpectedValue(t, tt.expected, stackElem)
+	}
+}
+
+// TestConditionalExpressionTypes checks that the branches of an if or switch
+// expression unify, and that an int branch of a float expression is converted
+func TestConditionalExpressionTypes(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected Type
+	}{
+		{`if a { 1 } else { 2 }`, IntType},
+		{`if a { 1 } else { 2.5 }`, FloatType},
+		{`if a { "x" } else if b { "y" } else { "z" }`, StringType},
+		{`if a { 1 } else { "x" }`, AnyTypeVal},
+		{`switch a { case 1 { [1] } default { [2, 3] } }`, &ArrayType{ElementType: IntType}},
+		{`switch a { case 1 { 1.5 } case 2 { 2 } default { nil } }`, AnyTypeVal},
+	}
+
+	for _, tt := range tests {
+		program := parse("var x = " + tt.input)
+		expr := program.Statements[0].(*ast.VarStatement).Value
+		if got := New().inferDetailedType(expr); !got.Equals(tt.expected) {
+			t.Errorf("%s: expected %s, got %s", tt.input, tt.expected, got)
+		}
+	}
+
+	program := parse("var a: bool = true; var x = if a { 1 } else { 2.5 }; x * 2.0;")
+	compiler := New()
+	if err := compiler.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	machine := vm.New(compiler.Bytecode())
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if result := machine.LastPoppedStackElem(); result.Type != vm.FloatType || result.AsFloat() != 2.0 {
+		t.Errorf("expected 2.000000, got %s", result)
+	}
+}
+
 func TestGlobalVarStatements(t *testing.T) {
 	tests := []struct {
 		input    string
@@ -0,0 +1,146 @@
+package compiler
+
+import (
+	"minlang/ast"
+	"minlang/vm"
+	"slices"
+)
+
+// Literal hoisting
+//
+// Array and map literals made only of literals are built once at compile
+// time and stored in the constant pool, so a literal inside a loop isn't
+// rebuilt element by element on every iteration. Equal literals share a
+// single constant. Collections are mutable, so OpCopyConst still gives each
+// evaluation its own copy; with scalar elements that is one slice or map clone.
+
+// constantScalar returns the value of a literal that can be an element of a
+// constant collection. An int too wide for int32 mode isn't one, so that
+// compiling the element reports it.
+func (c *Compiler) constantScalar(expr ast.Expression) (vm.Value, bool) {
+	switch node := expr.(type) {
+	case *ast.IntegerLiteral:
+		if c.int32Mode && overflowsInt32(node.Value) {
+			return vm.Value{}, false
+		}
+		return vm.IntValue(node.Value), true
+	case *ast.FloatLiteral:
+		return vm.FloatValue(node.Value), true
+	case *ast.StringLiteral:
+		return vm.StringValue(node.Value), true
+	case *ast.BooleanLiteral:
+		return vm.BoolValue(node.Value), true
+	case *ast.PrefixExpression:
+		if node.Operator != "-" {
+			return vm.Value{}, false
+		}
+		switch right := node.Right.(type) {
+		case *ast.IntegerLiteral:
+			if c.int32Mode && overflowsInt32(-right.Value) {
+				return vm.Value{}, false
+			}
+			return vm.IntValue(-right.Value), true
+		case *ast.FloatLiteral:
+			return vm.FloatValue(-right.Value), true
+		}
+	}
+	return vm.Value{}, false
+}
+
+// constantCollection builds the value of a non-empty array or map literal
+// whose elements (and keys) are all literals
+func (c *Compiler) constantCollection(expr ast.Expression) (vm.Value, bool) {
+	switch node := expr.(type) {
+	case *ast.ArrayLiteral:
+		if len(node.Elements) == 0 {
+			return vm.Value{}, false
+		}
+		elements := make([]vm.Value, len(node.Elements))
+		for i, el := range node.Elements {
+			value, ok := c.constantScalar(el)
+			if !ok {
+				return vm.Value{}, false
+			}
+			elements[i] = value
+		}
+		return vm.NewArrayFromElements(elements), true
+
+	case *ast.MapLiteral:
+		if len(node.Pairs) == 0 {
+			return vm.Value{}, false
+		}
+		m := vm.NewMapValue()
+		for _, keyExpr := range node.Keys {
+			valueExpr := node.Pairs[keyExpr]
+			key, ok := c.constantScalar(keyExpr)
+			if !ok || (key.Type != vm.IntType && key.Type != vm.StringType) {
+				return vm.Value{}, false
+			}
+			value, ok := c.constantScalar(valueExpr)
+			if !ok {
+				return vm.Value{}, false
+			}
+			m.AsMap().Set(key.ToMapKey(), value)
+		}
+		return m, true
+	}
+	return vm.Value{}, false
+}
+
+// addCollectionConstant adds a constant array or map to the pool, reusing an
+// equal one if the program already has it
+func (c *Compiler) addCollectionConstant(collection vm.Value) int {
+	for i, constant := range c.constants {
+		if collectionsEqual(constant, collection) {
+			return i
+		}
+	}
+	return c.addConstant(collection)
+}
+
+// collectionsEqual reports whether two constant collections have the same
+// type and equal scalar elements, in the same order for maps, whose keys()
+// follow it
+func collectionsEqual(a, b vm.Value) bool {
+	if a.Type != b.Type {
+		return false
+	}
+
+	switch a.Type {
+	case vm.ArrayType:
+		left, right := a.AsArray().Elements, b.AsArray().Elements
+		if len(left) != len(right) {
+			return false
+		}
+		for i := range left {
+			if !scalarsEqual(left[i], right[i]) {
+				return false
+			}
+		}
+		return true
+
+	case vm.MapType:
+		left, right := a.AsMap(), b.AsMap()
+		if !slices.Equal(left.Keys(), right.Keys()) {
+			return false
+		}
+		for key, value := range left.Pairs {
+			if !scalarsEqual(value, right.Pairs[key]) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// scalarsEqual compares scalar constants exactly (floats by bit pattern)
+func scalarsEqual(a, b vm.Value) bool {
+	if a.Type != b.Type {
+		return false
+	}
+	if a.Type == vm.StringType {
+		return a.AsString() == b.AsString()
+	}
+	return a.Data == b.Data
+}
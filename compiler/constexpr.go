@@ -0,0 +1,274 @@
+package compiler
+
+import (
+	"fmt"
+	"minlang/ast"
+	"minlang/vm"
+)
+
+// Constant expressions
+//
+// A const of type int, float, string or bool gets its value when the program
+// is compiled, so const SIZE: int = 10 * 1024 stores 10240 rather than the
+// instructions that multiply. Its initializer may use literals, consts of
+// those types declared before it, enum variants and the arithmetic,
+// comparison and logical operators; anything needing the program to run, such
+// as a call or a var, is a compile error. Ints follow the same -int32,
+// -check-overflow and -promote-int-div rules as at run time. The value then
+// stands in for the const's name wherever it is read, as a literal would. A
+// const holding an array, map or struct is a binding that can't be assigned,
+// see aliases.go.
+
+// constValue returns the value of the const node declares, and true, if it
+// is an int, float, string or bool. The consts of const v, ok = m[k] hold
+// what the map does as the program runs, so they have none.
+func (c *Compiler) constValue(node *ast.VarStatement) (vm.Value, bool, error) {
+	if node.IsMutable || node.Value == nil || c.inLookup {
+		return vm.NilValue(), false, nil
+	}
+	var t Type
+	if node.Type != nil {
+		t = c.convertType(node.Type)
+	} else {
+		t = c.inferDetailedType(node.Value)
+	}
+	if basic, ok := t.(*BasicType); !ok || !isScalar(basic) && !basic.Equals(StringType) {
+		return vm.NilValue(), false, nil
+	}
+	value, err := c.evalConst(node.Value)
+	if err != nil {
+		return vm.NilValue(), false, fmt.Errorf("const %s: %w", node.Name.Value, err)
+	}
+	return value, true, nil
+}
+
+// compileInitializer compiles value, the initializer of a declaration, into a
+// register, or with isConst loads constant, its value worked out already
+func (rc *RegisterCompiler) compileInitializer(value ast.Expression, constant vm.Value, isConst bool) (int, error) {
+	if isConst {
+		return rc.loadConstant(constant)
+	}
+	return rc.CompileToRegister(value)
+}
+
+// knownConst returns the value of expr if it is the name of a const whose
+// value the compiler worked out
+func (c *Compiler) knownConst(expr ast.Expression) (vm.Value, bool) {
+	ident, ok := expr.(*ast.Identifier)
+	if !ok {
+		return vm.NilValue(), false
+	}
+	symbol, ok := c.symbolTable.Resolve(ident.Value)
+	if !ok || symbol.Value == nil {
+		return vm.NilValue(), false
+	}
+	return *symbol.Value, true
+}
+
+// notConstantError is the error for part of a const's initializer that only
+// running the program can give
+func notConstantError(part ast.Expression) error {
+	return fmt.Errorf("%s is not constant; use var for a value worked out as the program runs", part.String())
+}
+
+// evalConst returns the value of the constant expression expr
+func (c *Compiler) evalConst(expr ast.Expression) (vm.Value, error) {
+	if lit, ok := NegatedLiteral(expr); ok && c.int32Mode {
+		expr = lit
+	}
+
+	switch node := expr.(type) {
+	case *ast.IntegerLiteral:
+		if err := c.checkIntLiteral(node); err != nil {
+			return vm.NilValue(), err
+		}
+		return vm.IntValue(node.Value), nil
+	case *ast.FloatLiteral:
+		return vm.FloatValue(node.Value), nil
+	case *ast.StringLiteral:
+		return vm.StringValue(node.Value), nil
+	case *ast.BooleanLiteral:
+		return vm.BoolValue(node.Value), nil
+
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(node.Value)
+		if !ok {
+			return vm.NilValue(), fmt.Errorf("undefined variable %s", node.Value)
+		}
+		if err := c.checkBareVariant(node, symbol); err != nil {
+			return vm.NilValue(), err
+		}
+		if symbol.Value == nil {
+			return vm.NilValue(), notConstantError(node)
+		}
+		return *symbol.Value, nil
+
+	case *ast.FieldAccessExpression:
+		value, ok, err := c.qualifiedVariant(node)
+		if err != nil {
+			return vm.NilValue(), err
+		}
+		if !ok || value.Type != vm.IntType && value.Type != vm.StringType {
+			return vm.NilValue(), notConstantError(node)
+		}
+		return value, nil
+
+	case *ast.PrefixExpression:
+		right, err := c.evalConst(node.Right)
+		if err != nil {
+			return vm.NilValue(), err
+		}
+		return c.foldPrefix(node.Operator, right)
+
+	case *ast.InfixExpression:
+		left, err := c.evalConst(node.Left)
+		if err != nil {
+			return vm.NilValue(), err
+		}
+		// The right side of && and || counts only if the left doesn't decide
+		if decided := node.Operator == "||"; isLogical(node.Operator) && left.IsTruthy() == decided {
+			return vm.BoolValue(decided), nil
+		}
+		right, err := c.evalConst(node.Right)
+		if err != nil {
+			return vm.NilValue(), err
+		}
+		return c.foldInfix(node.Operator, left, right)
+	}
+	return vm.NilValue(), notConstantError(expr)
+}
+
+// foldPrefix returns operator applied to the constant right
+func (c *Compiler) foldPrefix(operator string, right vm.Value) (vm.Value, error) {
+	switch {
+	case operator == "!":
+		return vm.BoolValue(!right.IsTruthy()), nil
+	case operator == "-" && right.Type == vm.IntType:
+		n := -right.AsInt()
+		if c.checkOverflow {
+			var err error
+			if n, err = vm.CheckedNeg(right.AsInt()); err != nil {
+				return vm.NilValue(), err
+			}
+		}
+		return c.foldedInt(n), nil
+	case operator == "-" && right.Type == vm.FloatType:
+		return vm.FloatValue(-right.AsFloat()), nil
+	}
+	return vm.NilValue(), vm.ErrUnsupportedNegation
+}
+
+// foldInfix returns left operator right for the constants left and right
+func (c *Compiler) foldInfix(operator string, left, right vm.Value) (vm.Value, error) {
+	switch operator {
+	case "&&", "||":
+		return vm.BoolValue(right.IsTruthy()), nil
+	case "==":
+		return vm.BoolValue(vm.Equal(left, right)), nil
+	case "!=":
+		return vm.BoolValue(!vm.Equal(left, right)), nil
+	case "<", ">", "<=", ">=":
+		return foldOrdering(operator, left, right)
+	case "+", "-", "*", "/", "%":
+	default:
+		return vm.NilValue(), fmt.Errorf("unknown operator %s", operator)
+	}
+
+	if operator == "+" && (left.Type == vm.StringType || right.Type == vm.StringType) {
+		return vm.StringValue(left.String() + right.String()), nil
+	}
+	if left.Type == vm.IntType && right.Type == vm.IntType && !(operator == "/" && c.promoteIntDiv) {
+		l, r := left.AsInt(), right.AsInt()
+		switch {
+		case operator == "%" && r == 0:
+			return vm.NilValue(), vm.ErrModuloByZero
+		case operator == "%":
+			return c.foldedInt(l % r), nil
+		case c.checkOverflow:
+			n, err := vm.CheckedInt(operator, l, r)
+			if err != nil {
+				return vm.NilValue(), err
+			}
+			return c.foldedInt(n), nil
+		case operator == "/" && r == 0:
+			return vm.NilValue(), vm.ErrDivisionByZero
+		case operator == "+":
+			return c.foldedInt(l + r), nil
+		case operator == "-":
+			return c.foldedInt(l - r), nil
+		case operator == "*":
+			return c.foldedInt(l * r), nil
+		default:
+			return c.foldedInt(l / r), nil
+		}
+	}
+
+	l, lok := constFloat(left)
+	r, rok := constFloat(right)
+	if !lok || !rok {
+		return vm.NilValue(), vm.ErrUnsupportedOperands
+	}
+	switch operator {
+	case "+":
+		return vm.FloatValue(l + r), nil
+	case "-":
+		return vm.FloatValue(l - r), nil
+	case "*":
+		return vm.FloatValue(l * r), nil
+	case "/":
+		if r == 0 {
+			return vm.NilValue(), vm.ErrDivisionByZero
+		}
+		return vm.FloatValue(l / r), nil
+	}
+	return vm.NilValue(), vm.ErrUnsupportedOperands
+}
+
+// foldOrdering compares the constant numbers or strings left and right
+func foldOrdering(operator string, left, right vm.Value) (vm.Value, error) {
+	if left.Type == vm.StringType && right.Type == vm.StringType {
+		return vm.BoolValue(ordered(operator, left.AsString(), right.AsString())), nil
+	}
+	if left.Type == vm.IntType && right.Type == vm.IntType {
+		return vm.BoolValue(ordered(operator, left.AsInt(), right.AsInt())), nil
+	}
+	l, lok := constFloat(left)
+	r, rok := constFloat(right)
+	if !lok || !rok {
+		return vm.NilValue(), vm.ErrUnsupportedComparison
+	}
+	return vm.BoolValue(ordered(operator, l, r)), nil
+}
+
+// ordered returns l operator r for the operator <, >, <= or >=
+func ordered[T int64 | float64 | string](operator string, l, r T) bool {
+	switch operator {
+	case "<":
+		return l < r
+	case ">":
+		return l > r
+	case "<=":
+		return l <= r
+	default:
+		return l >= r
+	}
+}
+
+// foldedInt returns the int n, wrapped to 32 bits in int32 mode
+func (c *Compiler) foldedInt(n int64) vm.Value {
+	if c.int32Mode {
+		return vm.WrapInt32(vm.IntValue(n))
+	}
+	return vm.IntValue(n)
+}
+
+// constFloat returns the constant number v as a float
+func constFloat(v vm.Value) (float64, bool) {
+	switch v.Type {
+	case vm.IntType:
+		return float64(v.AsInt()), true
+	case vm.FloatType:
+		return v.AsFloat(), true
+	}
+	return 0, false
+}
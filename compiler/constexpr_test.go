@@ -0,0 +1,82 @@
+package compiler
+
+import (
+	"minlang/ast"
+	"minlang/lexer"
+	"minlang/parser"
+	"minlang/vm"
+	"testing"
+)
+
+func TestConstExpressions(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		setup func(c *Compiler)
+		want  vm.Value // The value of the last const
+		err   string
+	}{
+		{"product", "const SIZE: int = 10 * 1024", nil, vm.IntValue(10240), ""},
+		{"earlier const", "const KB = 1024\nconst SIZE = 10 * KB", nil, vm.IntValue(10240), ""},
+		{"float", "const RATIO: float = 3 / 2.0", nil, vm.FloatValue(1.5), ""},
+		{"string", "const NAME = \"a\" + \"b\"", nil, vm.StringValue("ab"), ""},
+		{"comparison", "const BIG = 2 > 1 && \"b\" >= \"a\"", nil, vm.BoolValue(true), ""},
+		{"short circuit", "var x = 0\nconst OK = false && x > 0", nil, vm.BoolValue(false), ""},
+		{"enum variant", "type Level = enum { Low, High }\nconst LOW = Level.High == Level.Low", nil, vm.BoolValue(false), ""},
+		{"negation", "const N = -(2 - 5)", nil, vm.IntValue(3), ""},
+		{"int32 wraps", "const N = 2147483647 + 1", func(c *Compiler) { c.SetInt32(true) }, vm.IntValue(-2147483648), ""},
+		{"promoted division", "const N = 7 / 2", func(c *Compiler) { c.SetPromoteIntDiv(true) }, vm.FloatValue(3.5), ""},
+		{"overflow", "const N = 9223372036854775807 + 1", func(c *Compiler) { c.SetCheckOverflow(true) }, vm.NilValue(), "const N: integer overflow: 9223372036854775807 + 1"},
+		{"var", "var n = 1\nconst M = n + 1", nil, vm.NilValue(), "const M: n is not constant; use var for a value worked out as the program runs"},
+		{"call", "const M: int = len(\"ab\")", nil, vm.NilValue(), "const M: len(\"ab\") is not constant; use var for a value worked out as the program runs"},
+		{"division by zero", "const M = 1 / 0", nil, vm.NilValue(), "const M: division by zero"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("%s: parser errors: %v", tt.name, p.Errors())
+		}
+
+		c := New()
+		if tt.setup != nil {
+			tt.setup(c)
+		}
+		err := c.Compile(program)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: expected error %q, got %v", tt.name, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: compiler error: %s", tt.name, err)
+			continue
+		}
+
+		last := program.Statements[len(program.Statements)-1].(*ast.VarStatement)
+		symbol, ok := c.symbolTable.Resolve(last.Name.Value)
+		if !ok || symbol.Value == nil {
+			t.Errorf("%s: %s has no constant value", tt.name, last.Name.Value)
+			continue
+		}
+		if got := *symbol.Value; got.Type != tt.want.Type || !vm.Equal(got, tt.want) {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+}
+
+// TestConstLookupIsRuntime checks that the consts of a map lookup bind what
+// the map holds as the program runs
+func TestConstLookupIsRuntime(t *testing.T) {
+	input := "var m = map[string]int{\"a\": 1}\nconst v, ok = m[\"a\"]"
+	c := compileSource(t, input)
+	if symbol, _ := c.symbolTable.Resolve("v"); symbol.Value != nil {
+		t.Errorf("expected v to have no constant value, got %s", *symbol.Value)
+	}
+	rc := NewRegisterCompiler()
+	if _, err := rc.CompileToRegister(parse(input)); err != nil {
+		t.Errorf("register compiler error: %s", err)
+	}
+}
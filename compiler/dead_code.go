@@ -0,0 +1,66 @@
+package compiler
+
+import (
+	"fmt"
+	"minlang/ast"
+)
+
+// Dead code elimination
+//
+// Statements after an unconditional return, break or continue in the same
+// block can never run. Both compilers generate code only for the statements
+// liveStatements keeps, so unreachable code takes no space in the bytecode.
+// Dropped statements are not type checked; a warning marks the first one in
+// each block.
+
+// liveStatements returns the statements of a block up to and including the
+// first one that always leaves it, recording a warning if any follow
+func (c *Compiler) liveStatements(stmts []ast.Statement) []ast.Statement {
+	for i, stmt := range stmts {
+		if terminates(stmt) && i+1 < len(stmts) {
+			c.warnf(stmts[i+1], "unreachable code")
+			return stmts[:i+1]
+		}
+	}
+	return stmts
+}
+
+// terminates reports whether control never continues past stmt: it is a
+// return, break or continue, or an if whose branches all end in one, or a try
+// whose body and catch both do
+func terminates(stmt ast.Statement) bool {
+	switch stmt := stmt.(type) {
+	case *ast.ReturnStatement, *ast.BreakStatement, *ast.ContinueStatement:
+		return true
+	case *ast.BlockStatement:
+		for _, s := range stmt.Statements {
+			if terminates(s) {
+				return true
+			}
+		}
+	case *ast.IfStatement:
+		return stmt.Alternative != nil && terminates(stmt.Consequence) && terminates(stmt.Alternative)
+	case *ast.TryStatement:
+		return terminates(stmt.Body) && terminates(stmt.Catch)
+	}
+	return false
+}
+
+// warnf records a warning about node, prefixed with where it starts
+func (c *Compiler) warnf(node ast.Node, format string, args ...interface{}) {
+	c.warnings = append(c.warnings, atNode(node, fmt.Sprintf(format, args...)))
+}
+
+// atNode prefixes msg with the line and column where node starts, if known
+func atNode(node ast.Node, msg string) string {
+	if tok, ok := ast.NodeToken(ast.StartNode(node)); ok && tok.Line > 0 {
+		return fmt.Sprintf("%d:%d: %s", tok.Line, tok.Column, msg)
+	}
+	return msg
+}
+
+// Warnings returns the warnings recorded while compiling, such as
+// unreachable code that was left out
+func (c *Compiler) Warnings() []string {
+	return c.warnings
+}
@@ -0,0 +1,121 @@
+package compiler
+
+import (
+	"fmt"
+	"minlang/ast"
+	"minlang/vm"
+	"strings"
+)
+
+// Qualified enum variants
+//
+// Color.Red is the variant Red of the enum Color, compiled to its value as a
+// constant, so two enums can each have a None. The bare name Red still works,
+// as a global constant the enum defines, but it's deprecated: -warn reports
+// each use, and a bare name two enums share is an error, since it could mean
+// either. A variable named like an enum hides its qualified variants, the way
+// a variable hides anything else of its name.
+
+// bareVariant is a variant name an enum defines as a global
+type bareVariant struct {
+	symbol Symbol   // The global holding the first enum's variant
+	enums  []string // The enums with a variant of the name, in order
+}
+
+// defineBareVariant defines name as a global holding value, the variant of
+// enumName, unless an earlier enum defined it
+func (c *Compiler) defineBareVariant(enumName, name string, value vm.Value) {
+	if bare, ok := c.bareVariants[name]; ok {
+		bare.enums = append(bare.enums, enumName)
+		return
+	}
+	c.symbolTable.DefineWithMutability(name, false)
+	symbol := c.symbolTable.SetValue(name, value)
+	c.bareVariants[name] = &bareVariant{symbol: symbol, enums: []string{enumName}}
+	c.emit(vm.OpPush, c.addConstant(value))
+	c.storeSymbol(symbol)
+}
+
+// checkBareVariant returns an error if node, resolved to symbol, is a bare
+// variant name two enums share, and warns about it if only one has it
+func (c *Compiler) checkBareVariant(node *ast.Identifier, symbol Symbol) error {
+	bare, ok := c.bareVariants[node.Value]
+	if !ok || bare.symbol != symbol {
+		return nil
+	}
+	if len(bare.enums) > 1 {
+		return AmbiguousVariantError(node.Value, bare.enums)
+	}
+	c.warnf(node, "bare enum variant %s is deprecated; write %s.%s", node.Value, bare.enums[0], node.Value)
+	return nil
+}
+
+// qualifiedVariant returns the value of node if it names an enum's variant,
+// as Color.Red does. ok is false if node is something else, such as a
+// struct field.
+func (c *Compiler) qualifiedVariant(node *ast.FieldAccessExpression) (value vm.Value, ok bool, err error) {
+	enumType, variant := c.qualifiedEnum(node)
+	if enumType == nil {
+		return vm.NilValue(), false, nil
+	}
+	value, ok = enumType.Variants[variant]
+	if !ok {
+		return vm.NilValue(), true, UnknownVariantError(enumType.Name, variant)
+	}
+	if len(enumType.Payloads[variant]) > 0 {
+		return vm.NilValue(), true, MissingPayloadError(enumType.Name, variant)
+	}
+	return value, true, nil
+}
+
+// qualifiedEnum returns the enum node's left side names and the variant it
+// asks for, or nil if the left side isn't an enum
+func (c *Compiler) qualifiedEnum(node *ast.FieldAccessExpression) (*EnumType, string) {
+	ident, ok := node.Left.(*ast.Identifier)
+	if !ok {
+		return nil, ""
+	}
+	if symbol, ok := c.symbolTable.Resolve(ident.Value); ok && symbol.Scope != BuiltinScope {
+		return nil, ""
+	}
+	return c.enumTypes[ident.Value], node.Field.Value
+}
+
+// enumVariant returns the enum and variant expr is, in either form, or nil
+// if it isn't one or is a bare name two enums share
+func (c *Compiler) enumVariant(expr ast.Expression) (*EnumType, string) {
+	switch e := expr.(type) {
+	case *ast.FieldAccessExpression:
+		enumType, variant := c.qualifiedEnum(e)
+		if enumType != nil {
+			if _, ok := enumType.Variants[variant]; ok {
+				return enumType, variant
+			}
+		}
+	case *ast.Identifier:
+		bare, ok := c.bareVariants[e.Value]
+		if !ok || len(bare.enums) > 1 {
+			return nil, ""
+		}
+		if symbol, ok := c.symbolTable.Resolve(e.Value); ok && symbol == bare.symbol {
+			return c.enumTypes[bare.enums[0]], e.Value
+		}
+	}
+	return nil, ""
+}
+
+// AmbiguousVariantError returns the error for a bare variant name that
+// enums share
+func AmbiguousVariantError(name string, enums []string) error {
+	qualified := make([]string, len(enums))
+	for i, enum := range enums {
+		qualified[i] = enum + "." + name
+	}
+	return fmt.Errorf("%s is a variant of more than one enum; write %s", name, strings.Join(qualified, " or "))
+}
+
+// UnknownVariantError returns the error for Enum.Name when the enum has no
+// such variant
+func UnknownVariantError(enum, name string) error {
+	return fmt.Errorf("enum %s has no variant %s", enum, name)
+}
@@ -0,0 +1,78 @@
+package compiler
+
+import (
+	"minlang/lexer"
+	"minlang/parser"
+	"reflect"
+	"testing"
+)
+
+func TestQualifiedEnumVariants(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		err      string
+		warnings []string
+	}{
+		{
+			"qualified",
+			"type Color = enum { Red, Green }\nvar c = Color.Green\nswitch c {\ncase Color.Red { print(1) }\ncase Color.Green { print(2) }\n}",
+			"",
+			nil,
+		},
+		{
+			"shared name, qualified",
+			"type A = enum { None, X }\ntype B = enum { None, Y }\nprint(A.None, B.None, X)",
+			"",
+			[]string{"3:23: bare enum variant X is deprecated; write A.X"},
+		},
+		{
+			"shared name, bare",
+			"type A = enum { None }\ntype B = enum { None }\nprint(None)",
+			"None is a variant of more than one enum; write A.None or B.None",
+			nil,
+		},
+		{
+			"unknown variant",
+			"type A = enum { X }\nprint(A.Y)",
+			"enum A has no variant Y",
+			nil,
+		},
+		{
+			"not exhaustive",
+			"type A = enum { X, Y }\nvar a = A.X\nswitch a {\ncase A.X { print(1) }\n}",
+			"switch on enum A is not exhaustive, missing cases: Y",
+			nil,
+		},
+		{
+			"variable hides the enum",
+			"type P = struct { X: int }\ntype A = enum { X }\nfunc f(A: P): int { return A.X }\nprint(f(P{X: 7}))",
+			"",
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("%s: parser errors: %v", tt.name, p.Errors())
+		}
+
+		c := New()
+		err := c.Compile(program)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: expected error %q, got %v", tt.name, tt.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: compiler error: %s", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(c.Warnings(), tt.warnings) {
+			t.Errorf("%s: expected warnings %q, got %q", tt.name, tt.warnings, c.Warnings())
+		}
+	}
+}
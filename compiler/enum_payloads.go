@@ -0,0 +1,211 @@
+package compiler
+
+import (
+	"fmt"
+	"minlang/ast"
+	"minlang/vm"
+)
+
+// Enum payloads
+//
+// A variant may carry values, given with their types:
+//
+//	type Shape = enum { Circle(float), Rect(float, float), Empty }
+//
+// An enum like that is a tagged union. Its variants are variant values (see
+// vm/value.go) tagged with their position in the enum, and Shape.Rect(2.0,
+// 3.0) makes one carrying its values: a single value as it is, more as an
+// array. Such an enum gives its variants no other values, and defines no
+// bare names for them.
+//
+// A switch takes them apart. A case naming a variant matches any value of
+// it, and one listing names binds them to the values it carries:
+//
+//	switch shape {
+//	case Shape.Circle(r) { print(r) }
+//	case Shape.Rect(w, h) { print(w * h) }
+//	case Shape.Empty { print("empty") }
+//	}
+//
+// The case tests the tag with TEST_VARIANT, and its body starts by storing
+// the payload, or each element of it, in the names, which are constants
+// typed as the enum declares them. Other patterns are in patterns.go.
+
+// variantPattern is a case of a switch that matches a variant of an enum
+// with payloads
+type variantPattern struct {
+	tag      int
+	bindings []*ast.Identifier // Names bound to the payload, nil if the case binds none
+	types    []Type            // Types of the payload's values
+}
+
+// HasPayloads reports whether any variant of node carries values
+func HasPayloads(node *ast.EnumStatement) bool {
+	for _, payload := range node.Payloads {
+		if payload != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// payloadTags returns the values of the variants of node, an enum with
+// payloads: each a variant value tagged with its position, carrying nothing
+func payloadTags(node *ast.EnumStatement) ([]vm.Value, error) {
+	values := make([]vm.Value, len(node.Variants))
+	for i, variant := range node.Variants {
+		if i < len(node.Values) && node.Values[i] != nil {
+			return nil, fmt.Errorf("enum %s: %s cannot have a value, since the enum's variants carry payloads",
+				node.Name.Value, variant.Value)
+		}
+		values[i] = vm.NewVariantValue(i, vm.NilValue())
+	}
+	return values, nil
+}
+
+// CasePattern returns the variant a case value like Shape.Rect(w, h) names
+// and the names it binds. ok is false if value isn't of that form.
+func CasePattern(value ast.Expression) (variant *ast.FieldAccessExpression, bindings []*ast.Identifier, ok bool, err error) {
+	call, isCall := value.(*ast.CallExpression)
+	if !isCall {
+		return nil, nil, false, nil
+	}
+	variant, ok = call.Function.(*ast.FieldAccessExpression)
+	if !ok {
+		return nil, nil, false, nil
+	}
+	enum, ok := variant.Left.(*ast.Identifier)
+	if !ok {
+		return nil, nil, false, nil
+	}
+	bindings = make([]*ast.Identifier, len(call.Arguments))
+	for i, arg := range call.Arguments {
+		name, isName := arg.(*ast.Identifier)
+		if !isName {
+			return variant, nil, true, fmt.Errorf("case %s.%s: expected a name to bind, got %s",
+				enum.Value, variant.Field.Value, arg.String())
+		}
+		bindings[i] = name
+	}
+	return variant, bindings, true, nil
+}
+
+// PayloadCountError returns the error for a variant given, or bound to, the
+// wrong number of values
+func PayloadCountError(enum, variant string, want, got int) error {
+	if want == 0 {
+		return fmt.Errorf("%s.%s carries no payload", enum, variant)
+	}
+	return fmt.Errorf("%s.%s carries %d %s, got %d", enum, variant, want, plural(want, "value", "values"), got)
+}
+
+// MissingPayloadError returns the error for a variant that carries values
+// named without them
+func MissingPayloadError(enum, variant string) error {
+	return fmt.Errorf("%s.%s carries a payload; write %s.%s(...)", enum, variant, enum, variant)
+}
+
+func plural(n int, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
+
+// payloadVariant returns the enum and variant a call like Shape.Circle(r)
+// makes, or nil if it makes none
+func (c *Compiler) payloadVariant(node *ast.CallExpression) (*EnumType, string) {
+	access, ok := node.Function.(*ast.FieldAccessExpression)
+	if !ok {
+		return nil, ""
+	}
+	return c.qualifiedEnum(access)
+}
+
+// compileVariant compiles a call like Shape.Rect(w, h) making a variant
+func (c *Compiler) compileVariant(node *ast.CallExpression, enumType *EnumType, variant string) error {
+	value, ok := enumType.Variants[variant]
+	if !ok {
+		return UnknownVariantError(enumType.Name, variant)
+	}
+	types := enumType.Payloads[variant]
+	if len(node.Arguments) != len(types) || len(types) == 0 {
+		return PayloadCountError(enumType.Name, variant, len(types), len(node.Arguments))
+	}
+
+	for i, arg := range node.Arguments {
+		if argType := c.inferDetailedType(arg); !IsAssignableTo(argType, types[i]) {
+			return fmt.Errorf("%s.%s value %d: expected %s, got %s",
+				enumType.Name, variant, i+1, types[i].String(), argType.String())
+		}
+		if err := c.Compile(arg); err != nil {
+			return err
+		}
+	}
+	if len(types) > 1 {
+		c.emit(vm.OpArray, len(types))
+	}
+	c.emit(vm.OpMakeVariant, value.VariantTag())
+	return nil
+}
+
+// enumPattern returns the pattern value is if it names a variant of an
+// enum with payloads, or nil
+func (c *Compiler) enumPattern(value ast.Expression) (*variantPattern, error) {
+	access, bindings, isCall, err := CasePattern(value)
+	if err != nil {
+		return nil, err
+	}
+	var enumType *EnumType
+	var variant string
+	if isCall {
+		enumType, variant = c.qualifiedEnum(access)
+	} else {
+		enumType, variant = c.enumVariant(value)
+	}
+	if enumType == nil || (!isCall && enumType.Payloads == nil) {
+		return nil, nil
+	}
+
+	tag, ok := enumType.Variants[variant]
+	if !ok {
+		return nil, UnknownVariantError(enumType.Name, variant)
+	}
+	types := enumType.Payloads[variant]
+	if isCall && (len(bindings) != len(types) || len(types) == 0) {
+		return nil, PayloadCountError(enumType.Name, variant, len(types), len(bindings))
+	}
+	return &variantPattern{tag: tag.VariantTag(), bindings: bindings, types: types}, nil
+}
+
+func (p *variantPattern) test(c *Compiler) {
+	c.emit(vm.OpTestVariant, p.tag)
+}
+
+// bind stores the payload in the names the case binds
+func (p *variantPattern) bind(c *Compiler) {
+	if len(p.bindings) == 0 {
+		c.emit(vm.OpPop)
+		return
+	}
+	c.emit(vm.OpVariantPayload)
+	if len(p.bindings) == 1 {
+		c.storeSymbol(c.defineBinding(p.bindings[0], p.types[0]))
+		return
+	}
+	for i, name := range p.bindings {
+		c.emit(vm.OpDup)
+		c.emit(vm.OpPush, c.addConstant(vm.IntValue(int64(i))))
+		c.emit(vm.OpArrayGet)
+		c.storeSymbol(c.defineBinding(name, p.types[i]))
+	}
+	c.emit(vm.OpPop)
+}
+
+// defineBinding defines a name a case binds to a value of type t
+func (c *Compiler) defineBinding(name *ast.Identifier, t Type) Symbol {
+	c.varTypes[name.Value] = convertToValueType(t)
+	c.typeInfo[name.Value] = t
+	c.setStructVar(name.Value, "")
+	return c.symbolTable.DefineWithMutability(name.Value, false)
+}
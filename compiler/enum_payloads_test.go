@@ -0,0 +1,96 @@
+package compiler
+
+import (
+	"minlang/lexer"
+	"minlang/parser"
+	"testing"
+)
+
+func TestEnumPayloads(t *testing.T) {
+	shape := "type Shape = enum { Circle(float), Rect(float, float), Empty }\n"
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{
+			"match",
+			"var s = Shape.Rect(2.0, 3.0)\nswitch s {\ncase Shape.Circle(r) { print(r) }\ncase Shape.Rect(w, h) { print(w * h) }\ncase Shape.Empty { print(0) }\n}",
+			"",
+		},
+		{
+			"match without binding",
+			"var s = Shape.Empty\nswitch s {\ncase Shape.Circle { print(1) }\ndefault { print(0) }\n}",
+			"",
+		},
+		{
+			"not exhaustive",
+			"var s = Shape.Empty\nswitch s {\ncase Shape.Circle(r) { print(r) }\ncase Shape.Empty { print(0) }\n}",
+			"switch on enum Shape is not exhaustive, missing cases: Rect",
+		},
+		{
+			"too few values",
+			"var s = Shape.Rect(1.0)",
+			"Shape.Rect carries 2 values, got 1",
+		},
+		{
+			"value of the wrong type",
+			`var s = Shape.Circle("big")`,
+			"Shape.Circle value 1: expected float, got string",
+		},
+		{
+			"payload left out",
+			"var s = Shape.Circle",
+			"Shape.Circle carries a payload; write Shape.Circle(...)",
+		},
+		{
+			"payload given to a variant without one",
+			"var s = Shape.Empty(1.0)",
+			"Shape.Empty carries no payload",
+		},
+		{
+			"binding the wrong number of values",
+			"var s = Shape.Empty\nswitch s {\ncase Shape.Rect(w) { print(w) }\ndefault { print(0) }\n}",
+			"Shape.Rect carries 2 values, got 1",
+		},
+		{
+			"binding something other than a name",
+			"var s = Shape.Empty\nswitch s {\ncase Shape.Circle(1.0) { print(1) }\ndefault { print(0) }\n}",
+			"case Shape.Circle: expected a name to bind, got 1.0",
+		},
+		{
+			"bindings are constants",
+			"var s = Shape.Empty\nswitch s {\ncase Shape.Circle(r) { r = 2.0 }\ndefault { print(0) }\n}",
+			"cannot assign to const variable r",
+		},
+		{
+			"no bare names",
+			"print(Empty)",
+			"undefined variable Empty",
+		},
+		{
+			"values with payloads",
+			"type T = enum { A(int) = 1, B }",
+			"enum T: A cannot have a value, since the enum's variants carry payloads",
+		},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(shape + tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("%s: parser errors: %v", tt.name, p.Errors())
+		}
+
+		err := New().Compile(program)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s: compiler error: %s", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%s: expected error %q, got %v", tt.name, tt.err, err)
+		}
+	}
+}
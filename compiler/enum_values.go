@@ -0,0 +1,83 @@
+package compiler
+
+import (
+	"fmt"
+	"minlang/ast"
+	"minlang/vm"
+)
+
+// Enum values
+//
+// A variant stands for an int, 0 for the first and one more than the
+// variant before it for the others, unless the enum gives it one:
+//
+//	type Status = enum { Ok = 200, Created, NotFound = 404 }
+//
+// makes Created 201. The value may instead be a string, in which case every
+// variant needs one, as in enum { Red = "red", Green = "green" }. Values are
+// int or string literals, and no two variants of an enum share one, so
+// enumName can name the variant a value stands for. The variants of an enum
+// that carry payloads have no values of this kind, see enum_payloads.go.
+
+// EnumValues returns the values of node's variants, in order
+func EnumValues(node *ast.EnumStatement) ([]vm.Value, error) {
+	if HasPayloads(node) {
+		return payloadTags(node)
+	}
+	values := make([]vm.Value, len(node.Variants))
+	next := int64(0)
+	for i, variant := range node.Variants {
+		var given ast.Expression
+		if i < len(node.Values) {
+			given = node.Values[i]
+		}
+
+		var value vm.Value
+		switch {
+		case given != nil:
+			v, ok := enumLiteral(given)
+			if !ok {
+				return nil, fmt.Errorf("enum %s: value of %s must be an int or string literal, got %s",
+					node.Name.Value, variant.Value, given.String())
+			}
+			value = v
+		case i > 0 && values[0].Type == vm.StringType:
+			return nil, fmt.Errorf("enum %s: %s needs a string value, like the other variants", node.Name.Value, variant.Value)
+		default:
+			value = vm.IntValue(next)
+		}
+
+		if i > 0 && value.Type != values[0].Type {
+			return nil, fmt.Errorf("enum %s: %s is %s, but %s is %s; an enum's values are all ints or all strings",
+				node.Name.Value, variant.Value, value.Type, node.Variants[0].Value, values[0].Type)
+		}
+		for j := range values[:i] {
+			if (vm.EnumVariant{Value: values[j]}).Is(value) {
+				return nil, fmt.Errorf("enum %s: %s and %s have the same value %s",
+					node.Name.Value, node.Variants[j].Value, variant.Value, value.String())
+			}
+		}
+
+		values[i] = value
+		if value.Type == vm.IntType {
+			next = value.AsInt() + 1
+		}
+	}
+	return values, nil
+}
+
+// enumLiteral returns the value of an int or string literal, negative ints
+// included
+func enumLiteral(expr ast.Expression) (vm.Value, bool) {
+	switch e := expr.(type) {
+	case *ast.IntegerLiteral:
+		return vm.IntValue(e.Value), true
+	case *ast.StringLiteral:
+		return vm.StringValue(e.Value), true
+	case *ast.PrefixExpression:
+		if lit, ok := e.Right.(*ast.IntegerLiteral); ok && e.Operator == "-" {
+			return vm.IntValue(-lit.Value), true
+		}
+	}
+	return vm.NilValue(), false
+}
@@ -0,0 +1,49 @@
+package compiler
+
+import (
+	"minlang/ast"
+	"minlang/lexer"
+	"minlang/parser"
+	"strings"
+	"testing"
+)
+
+func TestEnumValues(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // The variants' values, or the error
+	}{
+		{"enum E { A, B, C }", "0 1 2"},
+		{"enum E { Ok = 200, Created, NotFound = 404 }", "200 201 404"},
+		{"enum E { Low = -1, Mid, High }", "-1 0 1"},
+		{"enum E { B = 1, A = 0 }", "1 0"},
+		{`enum E { Red = "red", Green = "green" }`, "red green"},
+		{`enum E { Red = "red", Green }`, "enum E: Green needs a string value, like the other variants"},
+		{`enum E { A, B = "b" }`, "enum E: B is string, but A is int; an enum's values are all ints or all strings"},
+		{"enum E { A = 1, B = 0, C }", "enum E: A and C have the same value 1"},
+		{"enum E { A = 1 + 2 }", "enum E: value of A must be an int or string literal, got (1 + 2)"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("%s: parser errors: %v", tt.input, p.Errors())
+		}
+
+		var actual string
+		values, err := EnumValues(program.Statements[0].(*ast.EnumStatement))
+		if err != nil {
+			actual = err.Error()
+		} else {
+			var shown []string
+			for _, v := range values {
+				shown = append(shown, v.String())
+			}
+			actual = strings.Join(shown, " ")
+		}
+		if actual != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.input, tt.expected, actual)
+		}
+	}
+}
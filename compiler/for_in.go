@@ -0,0 +1,171 @@
+package compiler
+
+import (
+	"fmt"
+	"minlang/ast"
+	"minlang/lexer"
+)
+
+// For-in loops
+//
+// for x in xs { body } compiles as the counting loop
+//
+//	var xs' = xs
+//	var n' = len(xs')
+//	var i' = 0
+//	for i' < n'; i' = i' + 1 { var x = xs'[i']; body }
+//
+// where the primed names are hidden variables no program can name. The array
+// is evaluated and measured once; assigning to x doesn't change the array or
+// the iteration. Looping over a call to the builtin range doesn't build the
+// array at all: for i in range(start, end, step) counts i' from start to end
+// directly, as long as the step is left out or a literal, so the direction is
+// known and it can't be zero. Both compilers lower loops the same way, so they
+// share this.
+
+// lowerForIn returns the statements node compiles as
+func (c *Compiler) lowerForIn(node *ast.ForInStatement) ([]ast.Statement, error) {
+	l := &forInLowering{node: node, id: c.forIns}
+	c.forIns++
+
+	if start, end, step, ok := c.rangeBounds(node.Iterable); ok {
+		index, last := l.hidden("index"), l.hidden("end")
+		if start == nil {
+			start = l.integer(0)
+		}
+		cmp := "<"
+		if step < 0 {
+			cmp = ">"
+		}
+		return []ast.Statement{
+			l.declare(index, start),
+			l.declare(last, end),
+			l.loop(l.infix(index, cmp, last), index, step, index),
+		}, nil
+	}
+
+	switch t := c.inferDetailedType(node.Iterable); t.(type) {
+	case *ArrayType, *AnyType:
+	default:
+		return nil, fmt.Errorf("cannot loop over %s", t.String())
+	}
+	if symbol, ok := c.symbolTable.Resolve("len"); !ok || symbol.Scope != BuiltinScope {
+		return nil, fmt.Errorf("for-in loop needs the builtin len, which is shadowed here")
+	}
+
+	array, length, index := l.hidden("array"), l.hidden("length"), l.hidden("index")
+	element := &ast.IndexExpression{Token: l.token(lexer.LBRACKET, "["), Left: array, Index: index}
+	return []ast.Statement{
+		l.declare(array, node.Iterable),
+		l.declare(length, &ast.CallExpression{
+			Token:     l.token(lexer.LPAREN, "("),
+			Function:  l.ident("len"),
+			Arguments: []ast.Expression{array},
+		}),
+		l.declare(index, l.integer(0)),
+		l.loop(l.infix(index, "<", length), index, 1, element),
+	}, nil
+}
+
+// rangeBounds returns the start, end and step of iterable if it is a call to
+// the builtin range with int bounds and a step that is left out or a nonzero
+// literal. A start that is left out is nil.
+func (c *Compiler) rangeBounds(iterable ast.Expression) (start, end ast.Expression, step int64, ok bool) {
+	call, ok := iterable.(*ast.CallExpression)
+	if !ok {
+		return nil, nil, 0, false
+	}
+	ident, ok := call.Function.(*ast.Identifier)
+	if !ok || ident.Value != "range" || len(call.Arguments) < 1 || len(call.Arguments) > 3 {
+		return nil, nil, 0, false
+	}
+	if symbol, ok := c.symbolTable.Resolve("range"); !ok || symbol.Scope != BuiltinScope {
+		return nil, nil, 0, false
+	}
+	for _, arg := range call.Arguments {
+		if !c.inferDetailedType(arg).Equals(IntType) {
+			return nil, nil, 0, false
+		}
+	}
+
+	step = 1
+	switch len(call.Arguments) {
+	case 1:
+		return nil, call.Arguments[0], step, true
+	case 3:
+		if step, ok = integerLiteral(call.Arguments[2]); !ok || step == 0 {
+			return nil, nil, 0, false
+		}
+	}
+	return call.Arguments[0], call.Arguments[1], step, true
+}
+
+// integerLiteral returns the value of expr if it is an integer literal,
+// negated or not
+func integerLiteral(expr ast.Expression) (int64, bool) {
+	switch e := expr.(type) {
+	case *ast.IntegerLiteral:
+		return e.Value, true
+	case *ast.PrefixExpression:
+		if lit, ok := e.Right.(*ast.IntegerLiteral); ok && e.Operator == "-" {
+			return -lit.Value, true
+		}
+	}
+	return 0, false
+}
+
+// forInLowering builds the statements of a lowered for-in loop, giving them
+// the position of its for
+type forInLowering struct {
+	node *ast.ForInStatement
+	id   int // Distinguishes the hidden variables of each loop
+}
+
+// hidden returns a hidden variable of the loop. The names aren't identifiers,
+// so they can't clash with the program's own.
+func (l *forInLowering) hidden(name string) *ast.Identifier {
+	return l.ident(fmt.Sprintf("for%d.%s", l.id, name))
+}
+
+// loop returns for cond; index = index + step { var x = value; body }
+func (l *forInLowering) loop(cond ast.Expression, index *ast.Identifier, step int64, value ast.Expression) *ast.ForStatement {
+	body := &ast.BlockStatement{
+		Token:      l.node.Body.Token,
+		Statements: append([]ast.Statement{l.declare(l.node.Variable, value)}, l.node.Body.Statements...),
+	}
+	op := "+"
+	if step < 0 {
+		op, step = "-", -step
+	}
+	return &ast.ForStatement{
+		Token:     l.node.Token,
+		Condition: cond,
+		Post: &ast.AssignmentStatement{
+			Token: l.token(lexer.ASSIGN, "="),
+			Left:  index,
+			Value: l.infix(index, op, l.integer(step)),
+		},
+		Body: body,
+	}
+}
+
+func (l *forInLowering) declare(name *ast.Identifier, value ast.Expression) *ast.VarStatement {
+	return &ast.VarStatement{Token: l.token(lexer.VAR, "var"), Name: name, Value: value, IsMutable: true}
+}
+
+func (l *forInLowering) infix(left ast.Expression, op string, right ast.Expression) *ast.InfixExpression {
+	typ := map[string]lexer.TokenType{"<": lexer.LT, ">": lexer.GT, "+": lexer.PLUS, "-": lexer.MINUS}[op]
+	return &ast.InfixExpression{Token: l.token(typ, op), Left: left, Operator: op, Right: right}
+}
+
+func (l *forInLowering) ident(name string) *ast.Identifier {
+	return &ast.Identifier{Token: l.token(lexer.IDENT, name), Value: name}
+}
+
+func (l *forInLowering) integer(value int64) *ast.IntegerLiteral {
+	return &ast.IntegerLiteral{Token: l.token(lexer.INT, fmt.Sprint(value)), Value: value}
+}
+
+func (l *forInLowering) token(typ lexer.TokenType, literal string) lexer.Token {
+	return lexer.Token{Type: typ, Literal: literal, Line: l.node.Token.Line, Column: l.node.Token.Column}
+}
@@ -0,0 +1,237 @@
+package compiler
+
+import (
+	"errors"
+	"fmt"
+	"minlang/ast"
+	"minlang/vm"
+	"strings"
+)
+
+// A generic function, such as func first<T>(arr: []T): T, is compiled once
+// for each set of types its type parameters take at the calls to it. A call
+// infers the types from its arguments, first([1, 2]) making T int, and the
+// instance for them, first<int>, is compiled into the constant pool the
+// first time it is called, where later calls with the same types load it.
+// Within an instance the parameters have their concrete types, so it gets
+// the opcodes specialized for them. A type parameter no argument fixes, or
+// that only arguments of unknown type give, is any.
+
+// genericFunction is the declaration of a generic function and its instances
+type genericFunction struct {
+	node      *ast.FunctionStatement
+	instances map[string]int // Constant index of each instance, by name, as in first<int>
+}
+
+// declareGeneric records the generic function node, whose instances are
+// compiled by the calls to it
+func (c *Compiler) declareGeneric(node *ast.FunctionStatement) error {
+	// Instances are compiled where they are called, so they can't capture
+	// the variables of an enclosing function
+	if c.scopeIndex > 0 {
+		return errors.New(atNode(node, fmt.Sprintf("generic function %s must be declared at the top level", node.Name.Value)))
+	}
+	for _, param := range node.TypeParams {
+		switch param.Value {
+		case "int", "float", "bool", "string", "error", "any":
+			return errors.New(atNode(param, fmt.Sprintf("type parameter %s of function %s hides the type %s", param.Value, node.Name.Value, param.Value)))
+		}
+	}
+
+	c.generics[node.Name.Value] = &genericFunction{node: node, instances: make(map[string]int)}
+	c.graph.enterFunction(node, c.functionSignature(node))
+	c.graph.leaveFunction()
+	return nil
+}
+
+// genericCallee returns the generic function node calls, or nil if it calls
+// something else. Variables and functions of the same name hide it, but
+// builtins don't.
+func (c *Compiler) genericCallee(node *ast.CallExpression) *genericFunction {
+	ident, ok := node.Function.(*ast.Identifier)
+	if !ok {
+		return nil
+	}
+	generic, ok := c.generics[ident.Value]
+	if !ok {
+		return nil
+	}
+	if symbol, ok := c.symbolTable.Resolve(ident.Value); ok && symbol.Scope != BuiltinScope {
+		return nil
+	}
+	return generic
+}
+
+// compileGenericCall compiles node, a call to generic, as a call to the
+// instance for the types of its arguments
+func (c *Compiler) compileGenericCall(node *ast.CallExpression, generic *genericFunction) error {
+	name := generic.node.Name.Value
+	typeArgs, err := c.inferTypeArgs(generic.node, node.Arguments)
+	if err != nil {
+		return typeError(node, err)
+	}
+	sig := c.instanceSignature(generic.node, typeArgs)
+	if err := c.checkCallArguments(name, sig, node.Arguments); err != nil {
+		return typeError(node, err)
+	}
+
+	instance := instanceName(generic.node, typeArgs)
+	index, ok := generic.instances[instance]
+	if !ok {
+		index, err = c.compileInstance(generic, typeArgs, sig, instance)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.graph.call(node, c.symbolTable)
+	c.emit(vm.OpPush, index)
+	for _, arg := range node.Arguments {
+		if err := c.Compile(arg); err != nil {
+			return err
+		}
+	}
+	c.emit(vm.OpCall, len(node.Arguments))
+	return nil
+}
+
+// compileInstance compiles the instance of generic for typeArgs into the
+// constant pool and returns its index. The slot is reserved before the body
+// is compiled, so recursive calls load the instance being compiled.
+func (c *Compiler) compileInstance(generic *genericFunction, typeArgs map[string]Type, sig *FunctionType, name string) (int, error) {
+	index := c.reserveConstant()
+	generic.instances[name] = index
+
+	// The body sees the globals, not the variables around the call
+	global := c.symbolTable
+	for global.outer != nil {
+		global = global.outer
+	}
+	prevSymbols, prevTypeArgs, prevLoops := c.symbolTable, c.typeArgs, c.loopStack
+	c.symbolTable, c.typeArgs, c.loopStack = global, typeArgs, nil
+	// What is recorded for the caller's variables could be taken for the
+	// globals of the same name
+	prevStructVars, prevAliases := c.structVars, c.constAliases
+	c.structVars, c.constAliases = make(map[string]string), make(map[string]string)
+
+	fn, _, err := c.compileFunction(generic.node, sig, name)
+	if err != nil {
+		return 0, err
+	}
+
+	c.symbolTable, c.typeArgs, c.loopStack = prevSymbols, prevTypeArgs, prevLoops
+	c.structVars, c.constAliases = prevStructVars, prevAliases
+	c.constants[index] = vm.NewFunctionValue(fn)
+	return index, nil
+}
+
+// genericCallType returns the signature of the instance of a generic
+// function node calls, if it calls one whose types can be inferred
+func (c *Compiler) genericCallType(node *ast.CallExpression) (*FunctionType, bool) {
+	generic := c.genericCallee(node)
+	if generic == nil {
+		return nil, false
+	}
+	typeArgs, err := c.inferTypeArgs(generic.node, node.Arguments)
+	if err != nil {
+		return nil, false
+	}
+	return c.instanceSignature(generic.node, typeArgs), true
+}
+
+// inferTypeArgs returns the types the type parameters of node take for a
+// call with args, matching each parameter's annotation with its argument's
+// type. Ints and floats together give float, and parameters nothing fixes
+// are any.
+func (c *Compiler) inferTypeArgs(node *ast.FunctionStatement, args []ast.Expression) (map[string]Type, error) {
+	if len(args) != len(node.Parameters) {
+		return nil, fmt.Errorf("function %s expects %d arguments, got %d",
+			node.Name.Value, len(node.Parameters), len(args))
+	}
+
+	typeArgs := make(map[string]Type, len(node.TypeParams))
+	for _, param := range node.TypeParams {
+		typeArgs[param.Value] = nil
+	}
+	for i, param := range node.Parameters {
+		if err := bindTypeArgs(node, param.Type, c.inferDetailedType(args[i]), typeArgs); err != nil {
+			return nil, err
+		}
+	}
+	for name, t := range typeArgs {
+		if t == nil {
+			typeArgs[name] = AnyTypeVal
+		}
+	}
+	return typeArgs, nil
+}
+
+// bindTypeArgs matches the annotation ta of a parameter of the generic
+// function node with t, the type of its argument, binding the type
+// parameters in typeArgs, where unbound ones are nil
+func bindTypeArgs(node *ast.FunctionStatement, ta *ast.TypeAnnotation, t Type, typeArgs map[string]Type) error {
+	switch {
+	case ta == nil:
+		return nil
+	case ta.IsArray:
+		if arrayType, ok := t.(*ArrayType); ok {
+			return bindTypeArgs(node, ta.ElementType, arrayType.ElementType, typeArgs)
+		}
+		return nil
+	case ta.IsMap:
+		if mapType, ok := t.(*MapType); ok {
+			if err := bindTypeArgs(node, ta.KeyType, mapType.KeyType, typeArgs); err != nil {
+				return err
+			}
+			return bindTypeArgs(node, ta.ValueType, mapType.ValueType, typeArgs)
+		}
+		return nil
+	}
+
+	bound, isParam := typeArgs[ta.Name]
+	if !isParam || t.Equals(AnyTypeVal) || t.Equals(NilType) {
+		return nil
+	}
+	switch {
+	case bound == nil || bound.Equals(t):
+		typeArgs[ta.Name] = t
+	case isNumber(bound) && isNumber(t):
+		typeArgs[ta.Name] = FloatType
+	default:
+		return fmt.Errorf("function %s: type parameter %s can't be both %s and %s",
+			node.Name.Value, ta.Name, bound.String(), t.String())
+	}
+	return nil
+}
+
+// instanceSignature returns the signature of the instance of the generic
+// function node for typeArgs
+func (c *Compiler) instanceSignature(node *ast.FunctionStatement, typeArgs map[string]Type) *FunctionType {
+	prev := c.typeArgs
+	c.typeArgs = typeArgs
+	defer func() { c.typeArgs = prev }()
+	return c.functionSignature(node)
+}
+
+// instanceName names the instance of the generic function node for typeArgs
+// after the types in the order of its type parameters: first<int>
+func instanceName(node *ast.FunctionStatement, typeArgs map[string]Type) string {
+	types := make([]string, len(node.TypeParams))
+	for i, param := range node.TypeParams {
+		types[i] = typeArgs[param.Value].String()
+	}
+	return node.Name.Value + "<" + strings.Join(types, ", ") + ">"
+}
+
+// convertType converts a type annotation like ConvertASTType, with the type
+// parameters of the generic instance being compiled standing for their types
+func (c *Compiler) convertType(ta *ast.TypeAnnotation) Type {
+	return convertType(ta, c.typeArgs)
+}
+
+// errGenericFunction reports a generic function to the register compiler,
+// which doesn't compile instances yet. Programs with them are compiled for
+// the stack VM and translated instead.
+func errGenericFunction(node *ast.FunctionStatement) error {
+	return fmt.Errorf("register compilation not yet implemented for generic function %s", node.Name.Value)
+}
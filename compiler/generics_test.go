@@ -0,0 +1,80 @@
+package compiler
+
+import (
+	"minlang/lexer"
+	"minlang/parser"
+	"minlang/vm"
+	"testing"
+)
+
+func TestGenericFunctions(t *testing.T) {
+	generics := "func first<T>(arr: []T): T { return arr[0] }\n" +
+		"func pick<T>(a: T, b: T, useA: bool): T { if useA { return a } return b }\n"
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"instance per type", "print(first([1, 2]), first([\"a\"]), first([1, 2]))", ""},
+		{"ints and floats give float", "var x: float = pick(1, 2.5, true)", ""},
+		{"result is typed", "var s: string = first([1])", "3:1: cannot assign value of type int to type string"},
+		{"conflicting types", "pick(1, \"a\", true)", "3:1: function pick: type parameter T can't be both int and string"},
+		{"argument count", "first([1], [2])", "3:1: function first expects 1 arguments, got 2"},
+		{"argument types", "pick(1, 2, 3)", "3:1: function pick argument 3: expected bool, got int"},
+		{"not a value", "var f = first", "generic function first can only be called"},
+		{"nested declaration", "func outer() { func inner<T>(x: T): T { return x } }", "3:16: generic function inner must be declared at the top level"},
+		{"type parameter hides a type", "func f<int>(x: int): int { return x }", "3:8: type parameter int of function f hides the type int"},
+		{"body checked per instance", "func twice<T>(x: T): T { var y: int = x\nreturn x }\ntwice(\"a\")", "3:26: cannot assign value of type string to type int"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(generics + tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("%s: parser errors: %v", tt.name, p.Errors())
+		}
+
+		err := New().Compile(program)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s: compiler error: %s", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%s: expected error %q, got %v", tt.name, tt.err, err)
+		}
+	}
+}
+
+func TestGenericInstancesAreShared(t *testing.T) {
+	input := "func first<T>(arr: []T): T { return arr[0] }\n" +
+		"func count<T>(arr: []T): int { if len(arr) == 0 { return 0 } return 1 + count(arr[1:]) }\n" +
+		"first([1]); first([2]); first([\"a\"]); count([1, 2]); count([3])"
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	c := New()
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	var names []string
+	for _, constant := range c.Bytecode().Constants {
+		if constant.Type == vm.FunctionType {
+			names = append(names, constant.AsFunction().Name)
+		}
+	}
+	expected := []string{"first<int>", "first<string>", "count<int>"}
+	if len(names) != len(expected) {
+		t.Fatalf("expected instances %v, got %v", expected, names)
+	}
+	for i := range expected {
+		if names[i] != expected[i] {
+			t.Errorf("expected instances %v, got %v", expected, names)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package compiler
+
+import (
+	"fmt"
+	"minlang/ast"
+	"minlang/lexer"
+	"minlang/vm"
+)
+
+// Increments
+//
+// i++ and i-- are statements the parser turns into i = i + 1 and i = i - 1.
+// Assigning a variable itself plus or minus a small whole number compiles to
+// one instruction that changes the variable where it is: OpIncLocal or
+// OpIncGlobal in the stack VM, OpRInc on the variable's register in the
+// register VM. The instructions neither wrap nor check for overflow, so
+// int32 mode and overflow checks go the long way. x++ on an x known not to
+// be a number is an error.
+
+// maxIncrement is the largest amount the instructions' 16 bit operand holds
+const maxIncrement = 65535
+
+// increment returns the amount value adds to the variable ident, and
+// whether it subtracts it instead, if value is ident + k or ident - k for a
+// whole number literal k an increment instruction holds
+func (c *Compiler) increment(ident *ast.Identifier, value ast.Expression) (amount int, dec bool, ok bool) {
+	if c.int32Mode || c.checkOverflow {
+		return 0, false, false
+	}
+	infix, ok := value.(*ast.InfixExpression)
+	if !ok || (infix.Operator != "+" && infix.Operator != "-") {
+		return 0, false, false
+	}
+	if left, ok := infix.Left.(*ast.Identifier); !ok || left.Value != ident.Value {
+		return 0, false, false
+	}
+	switch k := infix.Right.(type) {
+	case *ast.IntegerLiteral:
+		amount = int(k.Value)
+		ok = k.Value >= 0 && k.Value <= maxIncrement
+	case *ast.FloatLiteral:
+		amount = int(k.Value)
+		ok = float64(amount) == k.Value && amount >= 0 && amount <= maxIncrement
+	default:
+		ok = false
+	}
+	return amount, infix.Operator == "-", ok
+}
+
+// checkIncrement reports x++ or x-- on an x known not to be a number
+func (c *Compiler) checkIncrement(node *ast.AssignmentStatement) error {
+	return checkIncrementType(c.inferDetailedType, node)
+}
+
+// checkIncrementType reports x++ or x-- on an x infer knows not to be a
+// number
+func checkIncrementType(infer func(ast.Expression) Type, node *ast.AssignmentStatement) error {
+	if !isIncrement(node) {
+		return nil
+	}
+	t := infer(node.Left)
+	if t.Equals(IntType) || t.Equals(FloatType) || t.Equals(AnyTypeVal) || t.Equals(NilType) {
+		return nil
+	}
+	return fmt.Errorf("cannot use %s on %s: it is a %s, not a number", node.Token.Literal, node.Left.String(), t)
+}
+
+// isIncrement reports whether node is x++ or x--
+func isIncrement(node *ast.AssignmentStatement) bool {
+	return node.Token.Type == lexer.INC || node.Token.Type == lexer.DEC
+}
+
+// compileIncrement emits the increment of the variable ident, whose symbol
+// is symbol, for ident = value, if value is one, and reports whether it did
+func (c *Compiler) compileIncrement(ident *ast.Identifier, symbol Symbol, value ast.Expression) bool {
+	amount, dec, ok := c.increment(ident, value)
+	if !ok {
+		return false
+	}
+	switch {
+	case symbol.Scope == GlobalScope && dec:
+		c.emit(vm.OpDecGlobal, symbol.Index, amount)
+	case symbol.Scope == GlobalScope:
+		c.emit(vm.OpIncGlobal, symbol.Index, amount)
+	case dec:
+		c.emit(vm.OpDecLocal, symbol.Index, amount)
+	default:
+		c.emit(vm.OpIncLocal, symbol.Index, amount)
+	}
+	return true
+}
+
+// compileIncrement emits the increment of the variable left for left =
+// value, if value is one, and reports whether it did
+func (rc *RegisterCompiler) compileIncrement(left *ast.Identifier, symbol Symbol, value ast.Expression) bool {
+	amount, dec, ok := rc.increment(left, value)
+	if !ok {
+		return false
+	}
+	// Only int and float registers increment in place, and ints only by an
+	// int, as an int plus 1.0 is a float
+	_, byFloat := value.(*ast.InfixExpression).Right.(*ast.FloatLiteral)
+	if t := rc.inferExpressionType(left); t != vm.FloatType && (t != vm.IntType || byFloat) {
+		return false
+	}
+	op := vm.OpRInc
+	if dec {
+		op = vm.OpRDec
+	}
+	if symbol.Scope == GlobalScope {
+		reg := rc.allocateTempRegister()
+		rc.emitRBx(vm.OpRLoadGlobal, uint8(reg), uint16(symbol.Index))
+		rc.emitRBx(op, uint8(reg), uint16(amount))
+		rc.emitRBx(vm.OpRStoreGlobal, uint8(reg), uint16(symbol.Index))
+		rc.freeTempRegister(reg)
+		return true
+	}
+	varReg, exists := rc.registers[left.Value]
+	if !exists {
+		return false
+	}
+	rc.emitRBx(op, uint8(varReg), uint16(amount))
+	return true
+}
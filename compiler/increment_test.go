@@ -0,0 +1,90 @@
+package compiler
+
+import (
+	"minlang/lexer"
+	"minlang/parser"
+	"minlang/vm"
+	"strings"
+	"testing"
+)
+
+func TestIncrements(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		stack    string // The instruction the stack compiler emits, if any
+		register string // The instruction the register compiler emits, if any
+		err      string
+	}{
+		{"global", "var i = 0\ni++", "INC_GLOBAL", "INC", ""},
+		{"local", "func f() { var i = 0\ni-- }", "DEC_LOCAL", "DEC", ""},
+		{"spelled out", "var i = 0\ni = i + 2", "INC_GLOBAL", "INC", ""},
+		{"float", "var x = 1.5\nx = x - 1.0", "DEC_GLOBAL", "DEC", ""},
+		{"int plus float", "var i = 1\ni = i + 1.0", "INC_GLOBAL", "", ""},
+		{"too large", "var i = 0\ni = i + 70000", "", "", ""},
+		{"element", "var xs = [1]\nxs[0]++", "", "", ""},
+		{"string", "var s = \"a\"\ns++", "", "", "2:1: cannot use ++ on s: it is a string, not a number"},
+		{"string element", "var xs = [\"a\"]\nxs[0]--", "", "", "2:1: cannot use -- on (xs[0]): it is a string, not a number"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("%s: parser errors: %v", tt.name, p.Errors())
+		}
+
+		c := New()
+		err := c.Compile(program)
+		rc := NewRegisterCompiler()
+		_, rerr := rc.CompileToRegister(program)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("%s: expected error %q, got %v", tt.name, tt.err, err)
+			}
+			if rerr == nil || rerr.Error() != tt.err {
+				t.Errorf("%s: register: expected error %q, got %v", tt.name, tt.err, rerr)
+			}
+			continue
+		}
+		if err != nil || rerr != nil {
+			t.Errorf("%s: compiler errors: %v, %v", tt.name, err, rerr)
+			continue
+		}
+
+		listing := disassembleAll(c.Bytecode())
+		if got := strings.Contains(listing, "INC_") || strings.Contains(listing, "DEC_"); got != (tt.stack != "") ||
+			tt.stack != "" && !strings.Contains(listing, " "+tt.stack+" ") {
+			t.Errorf("%s: expected %q\n%s", tt.name, tt.stack, listing)
+		}
+		rlisting := disassembleAllRegister(rc.RegisterBytecode())
+		if got := strings.Contains(rlisting, " INC ") || strings.Contains(rlisting, " DEC "); got != (tt.register != "") ||
+			tt.register != "" && !strings.Contains(rlisting, " "+tt.register+" ") {
+			t.Errorf("%s: register: expected %q\n%s", tt.name, tt.register, rlisting)
+		}
+	}
+}
+
+// disassembleAll lists the instructions of bytecode and of the functions in
+// its constants
+func disassembleAll(bytecode *vm.Bytecode) string {
+	listing := vm.Disassemble(bytecode.Instructions)
+	for _, c := range bytecode.Constants {
+		if c.Type == vm.FunctionType {
+			listing += vm.Disassemble(c.AsFunction().Instructions)
+		}
+	}
+	return listing
+}
+
+// disassembleAllRegister lists the instructions of bytecode and of the
+// functions in its constants
+func disassembleAllRegister(bytecode *vm.RegisterBytecode) string {
+	listing := vm.RegisterDisassemble(bytecode.Instructions)
+	for _, c := range bytecode.Constants {
+		if c.Type == vm.FunctionType {
+			listing += vm.RegisterDisassemble(c.AsFunction().RegisterInstructions)
+		}
+	}
+	return listing
+}
@@ -0,0 +1,133 @@
+package compiler
+
+import (
+	"fmt"
+	"math"
+	"minlang/ast"
+	"minlang/vm"
+)
+
+// Int32 mode
+//
+// In int32 mode the int type is 32 bits wide and arithmetic on it wraps
+// around in two's complement, so a program computes the same ints on every
+// platform, and the same ones a target with native 32-bit ints would. Values
+// are still held in 64 bits: every expression that can produce an int out of
+// the 32-bit range is followed by OpWrapInt32, which brings it back. Values
+// made only of wrapped ints (variables, comparisons, len, min and so on) stay
+// in range without it.
+
+// int32Builtins are the builtins whose int results can fall outside 32 bits
+// for arguments inside them
+var int32Builtins = map[string]bool{
+	"int": true, "parseInt": true, "floor": true, "ceil": true, "abs": true, "sum": true,
+	"round": true, "trunc": true,
+}
+
+// SetInt32 makes ints 32 bits wide: arithmetic wraps around on overflow and
+// an integer literal that doesn't fit in 32 bits is a compile error
+func (c *Compiler) SetInt32(enabled bool) {
+	c.int32Mode = enabled
+}
+
+// wrapsInt32 reports whether node is an int expression whose result needs
+// wrapping to 32 bits in int32 mode
+func (c *Compiler) wrapsInt32(node ast.Node) bool {
+	if !c.int32Mode || node == c.wrapping {
+		return false
+	}
+
+	var expr ast.Expression
+	switch node := node.(type) {
+	case *ast.InfixExpression:
+		switch node.Operator {
+		case "+", "-", "*", "/":
+		default:
+			return false
+		}
+		expr = node
+	case *ast.PrefixExpression:
+		if node.Operator != "-" {
+			return false
+		}
+		expr = node
+	case *ast.CallExpression:
+		ident, ok := node.Function.(*ast.Identifier)
+		if !ok || !int32Builtins[ident.Value] {
+			return false
+		}
+		if symbol, ok := c.symbolTable.Resolve(ident.Value); !ok || symbol.Scope != BuiltinScope {
+			return false
+		}
+		expr = node
+	default:
+		return false
+	}
+	return c.inferExpressionType(expr) == vm.IntType
+}
+
+// checkIntLiteral reports an integer literal that int32 mode can't hold
+func (c *Compiler) checkIntLiteral(node ast.Node) error {
+	lit, ok := node.(*ast.IntegerLiteral)
+	if !ok || !c.int32Mode {
+		return nil
+	}
+	return Int32LiteralError(lit.Value)
+}
+
+// Int32LiteralError returns the error for an integer literal n that doesn't
+// fit in 32 bits, or nil if it does
+func Int32LiteralError(n int64) error {
+	if overflowsInt32(n) {
+		return fmt.Errorf("integer literal %d overflows int32", n)
+	}
+	return nil
+}
+
+// overflowsInt32 reports whether n is outside the range of a 32-bit int
+func overflowsInt32(n int64) bool {
+	return n < math.MinInt32 || n > math.MaxInt32
+}
+
+// NegatedLiteral returns -lit as a literal of its own if node negates an
+// integer literal. In int32 mode -2147483648 is in range though its digits
+// alone are not, so the two are compiled as one.
+func NegatedLiteral(node ast.Node) (*ast.IntegerLiteral, bool) {
+	prefix, ok := node.(*ast.PrefixExpression)
+	if !ok || prefix.Operator != "-" {
+		return nil, false
+	}
+	lit, ok := prefix.Right.(*ast.IntegerLiteral)
+	if !ok {
+		return nil, false
+	}
+	return &ast.IntegerLiteral{Token: prefix.Token, Value: -lit.Value}, true
+}
+
+// compileWrapped compiles node, an expression wrapsInt32 accepts, and wraps
+// its result to 32 bits
+func (c *Compiler) compileWrapped(node ast.Node) error {
+	saved := c.wrapping
+	c.wrapping = node
+	err := c.Compile(node)
+	c.wrapping = saved
+	if err != nil {
+		return err
+	}
+	c.emit(vm.OpWrapInt32)
+	return nil
+}
+
+// compileWrapped compiles node, an expression wrapsInt32 accepts, and wraps
+// the register holding its result to 32 bits
+func (rc *RegisterCompiler) compileWrapped(node ast.Node) (int, error) {
+	saved := rc.wrapping
+	rc.wrapping = node
+	reg, err := rc.CompileToRegister(node)
+	rc.wrapping = saved
+	if err != nil {
+		return -1, err
+	}
+	rc.emitR(vm.OpRWrapInt32, uint8(reg), 0, 0)
+	return reg, nil
+}
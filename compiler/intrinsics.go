@@ -0,0 +1,86 @@
+package compiler
+
+import (
+	"minlang/ast"
+	"minlang/vm"
+)
+
+// Calls to abs, min, max and sqrt whose arguments are all known to be ints,
+// or all known to be floats, compile to opcodes of their own rather than
+// builtin calls. The opcodes skip the call and the builtin's argument checks,
+// which adds up in numeric loops; they compute exactly what the builtin does.
+
+// intrinsic holds the opcodes a builtin is lowered to in each backend
+type intrinsic struct {
+	numArgs          int
+	intOp, floatOp   vm.OpCode
+	intROp, floatROp vm.RegisterOpCode
+}
+
+var intrinsics = map[string]intrinsic{
+	"abs":  {1, vm.OpAbsInt, vm.OpAbsFloat, vm.OpRAbsInt, vm.OpRAbsFloat},
+	"min":  {2, vm.OpMinInt, vm.OpMinFloat, vm.OpRMinInt, vm.OpRMinFloat},
+	"max":  {2, vm.OpMaxInt, vm.OpMaxFloat, vm.OpRMaxInt, vm.OpRMaxFloat},
+	"sqrt": {1, vm.OpSqrtInt, vm.OpSqrtFloat, vm.OpRSqrtInt, vm.OpRSqrtFloat},
+}
+
+// lookupIntrinsic returns the intrinsic for a call to the builtin name with
+// args, and whether its arguments are floats. It reports false unless the
+// builtin has one taking that many arguments, all ints or all floats.
+func (c *Compiler) lookupIntrinsic(name string, args []ast.Expression) (intrinsic, bool, bool) {
+	in, ok := intrinsics[name]
+	if !ok || len(args) != in.numArgs {
+		return intrinsic{}, false, false
+	}
+
+	argType := c.inferDetailedType(args[0])
+	if !argType.Equals(IntType) && !argType.Equals(FloatType) {
+		return intrinsic{}, false, false
+	}
+	for _, arg := range args[1:] {
+		if !c.inferDetailedType(arg).Equals(argType) {
+			return intrinsic{}, false, false
+		}
+	}
+	return in, argType.Equals(FloatType), true
+}
+
+// intrinsicOp returns the stack opcode a call to the builtin name with args
+// compiles to, if it has one
+func (c *Compiler) intrinsicOp(name string, args []ast.Expression) (vm.OpCode, bool) {
+	in, isFloat, ok := c.lookupIntrinsic(name, args)
+	if isFloat {
+		return in.floatOp, ok
+	}
+	return in.intOp, ok
+}
+
+// intrinsicRegisterOp returns the register opcode a call to the builtin name
+// with args compiles to, if it has one
+func (c *Compiler) intrinsicRegisterOp(name string, args []ast.Expression) (vm.RegisterOpCode, bool) {
+	in, isFloat, ok := c.lookupIntrinsic(name, args)
+	if isFloat {
+		return in.floatROp, ok
+	}
+	return in.intROp, ok
+}
+
+// compileIntrinsic compiles args and applies op to them, returning the
+// register holding the result
+func (rc *RegisterCompiler) compileIntrinsic(op vm.RegisterOpCode, args []ast.Expression) (int, error) {
+	argRegs := make([]int, 2)
+	for i, arg := range args {
+		reg, err := rc.CompileToRegister(arg)
+		if err != nil {
+			return -1, err
+		}
+		argRegs[i] = reg
+	}
+
+	resultReg := rc.allocateTempRegister()
+	rc.emitR(op, uint8(resultReg), uint8(argRegs[0]), uint8(argRegs[1]))
+	for _, reg := range argRegs[:len(args)] {
+		rc.freeTempRegister(reg)
+	}
+	return resultReg, nil
+}
@@ -0,0 +1,39 @@
+package compiler
+
+import "minlang/vm"
+
+// Linking register bytecode
+//
+// The register compiler gives each function its own constant pool, so a
+// function's instructions number its constants from 0 and compiling one
+// function never touches another's pool. The register VM reads a single
+// pool, so linking appends every function's pool to the main program's and
+// records where it landed as the function's ConstantBase. A frame reads its
+// constants from there, so the instructions keep their own numbering and an
+// index never grows past what its operand holds.
+
+// linkConstants returns the program's pool: main followed by the pools of the
+// functions it holds, and of the functions those hold, with each function
+// replaced by a copy that knows where its pool starts
+func linkConstants(main []vm.Value) []vm.Value {
+	pool := make([]vm.Value, 0, len(main))
+
+	var appendPool func(constants []vm.Value) int
+	appendPool = func(constants []vm.Value) int {
+		base := len(pool)
+		pool = append(pool, constants...)
+		for i, constant := range constants {
+			if constant.Type != vm.FunctionType {
+				continue
+			}
+			fn := *constant.AsFunction()
+			fn.ConstantBase = appendPool(fn.Constants)
+			fn.Constants = nil // Now part of the program's pool
+			pool[base+i] = vm.NewFunctionValue(&fn)
+		}
+		return base
+	}
+	appendPool(main)
+
+	return pool
+}
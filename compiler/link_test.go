@@ -0,0 +1,60 @@
+package compiler
+
+import (
+	"bytes"
+	"minlang/lexer"
+	"minlang/parser"
+	"minlang/vm"
+	"testing"
+)
+
+func TestRegisterFunctionConstantPools(t *testing.T) {
+	input := `
+func greet(): string {
+    return "hello"
+}
+func outer(): int {
+    func inner(): int {
+        return 40
+    }
+    return inner() + 2
+}
+print(greet(), outer(), "main")
+`
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	rc := NewRegisterCompiler()
+	if _, err := rc.CompileToRegister(program); err != nil {
+		t.Fatalf("compilation error: %s", err)
+	}
+
+	// Before linking, each function holds only its own constants
+	for _, constant := range rc.constants {
+		if constant.Type != vm.FunctionType {
+			continue
+		}
+		fn := constant.AsFunction()
+		if fn.Name == "greet" && (len(fn.Constants) != 1 || fn.Constants[0].AsString() != "hello") {
+			t.Errorf("expected greet's pool to hold only \"hello\", got %v", fn.Constants)
+		}
+	}
+
+	bytecode := rc.RegisterBytecode()
+	for _, constant := range bytecode.Constants {
+		if constant.Type == vm.FunctionType && constant.AsFunction().Constants != nil {
+			t.Errorf("expected %s's pool to be merged into the program's", constant.AsFunction().Name)
+		}
+	}
+
+	var stdout bytes.Buffer
+	if err := vm.NewRegisterVM(bytecode, vm.WithStdout(&stdout)).Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if stdout.String() != "hello 42 main\n" {
+		t.Errorf("expected the linked program to print \"hello 42 main\", got %q", stdout.String())
+	}
+}
@@ -0,0 +1,78 @@
+package compiler
+
+import (
+	"minlang/ast"
+	"minlang/vm"
+)
+
+// Logical operators
+//
+// a && b and a || b evaluate b only when a doesn't decide the result, so
+// x != 0 && 10/x > 1 never divides by zero. Both compile to conditional
+// jumps rather than an instruction taking two evaluated operands, and give
+// true or false whatever the truthy values they test.
+
+// isLogical reports whether operator is && or ||
+func isLogical(operator string) bool {
+	return operator == "&&" || operator == "||"
+}
+
+// compileLogical compiles a && b as if a { b is truthy } else { false } and
+// a || b as if a { true } else { b is truthy }
+func (c *Compiler) compileLogical(node *ast.InfixExpression) error {
+	decided := node.Operator == "||" // The result when a decides it
+	jumpOp := vm.OpJumpIfFalse
+	if decided {
+		jumpOp = vm.OpJumpIfTrue
+	}
+
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+	leftJump := c.emit(jumpOp, 9999)
+	if err := c.Compile(node.Right); err != nil {
+		return err
+	}
+	rightJump := c.emit(jumpOp, 9999)
+	c.emit(vm.OpPush, c.addConstant(vm.BoolValue(!decided)))
+	endJump := c.emit(vm.OpJump, 9999)
+
+	decidedPos := len(c.currentInstructions())
+	c.changeOperand(leftJump, decidedPos)
+	c.changeOperand(rightJump, decidedPos)
+	c.emit(vm.OpPush, c.addConstant(vm.BoolValue(decided)))
+	c.changeOperand(endJump, len(c.currentInstructions()))
+	return nil
+}
+
+// compileLogical compiles a && b or a || b into a register holding the
+// result a would decide, which the jumps on a and b skip changing when they
+// do decide it. Comparisons branch on themselves, as in an if.
+func (rc *RegisterCompiler) compileLogical(node *ast.InfixExpression) (int, error) {
+	decided := node.Operator == "||"
+	resultReg := rc.allocateTempRegister()
+	decidedOp, otherOp := vm.OpRLoadFalse, vm.OpRLoadTrue
+	if decided {
+		decidedOp, otherOp = otherOp, decidedOp
+	}
+
+	rc.emitR(decidedOp, uint8(resultReg), 0, 0)
+	leftJump, err := rc.compileJumpIf(node.Left, decided)
+	if err != nil {
+		return -1, err
+	}
+	rightJump, err := rc.compileJumpIf(node.Right, decided)
+	if err != nil {
+		return -1, err
+	}
+	rc.emitR(otherOp, uint8(resultReg), 0, 0)
+
+	end := len(rc.instructions)
+	if err := rc.patchJump(leftJump, end); err != nil {
+		return -1, err
+	}
+	if err := rc.patchJump(rightJump, end); err != nil {
+		return -1, err
+	}
+	return resultReg, nil
+}
@@ -0,0 +1,52 @@
+package compiler
+
+import (
+	"bytes"
+	"minlang/vm"
+	"strings"
+	"testing"
+)
+
+// TestLogicalShortCircuit checks that && and || compile to jumps and leave
+// their right side unevaluated when the left decides the result
+func TestLogicalShortCircuit(t *testing.T) {
+	input := `
+var x = 0
+var calls = 0
+func touch(v: bool): bool {
+    calls = calls + 1
+    return v
+}
+print(x != 0 && 10 / x > 1, x == 0 || 10 / x > 1)
+print(touch(false) && touch(true), touch(true) || touch(true), calls)
+print(touch(true) && touch(false), touch(false) || touch(true), calls)
+print(1 && "a", nil || 0)
+`
+	c := compileSource(t, input)
+	if n := countOp(c.Bytecode().Instructions, vm.OpAnd) + countOp(c.Bytecode().Instructions, vm.OpOr); n != 0 {
+		t.Errorf("expected no AND or OR\n%s", vm.Disassemble(c.Bytecode().Instructions))
+	}
+
+	rc := NewRegisterCompiler()
+	if _, err := rc.CompileToRegister(parse(input)); err != nil {
+		t.Fatalf("compilation error: %s", err)
+	}
+	registerListing := vm.RegisterDisassemble(rc.RegisterBytecode().Instructions)
+	for _, op := range []vm.RegisterOpCode{vm.OpRAnd, vm.OpROr} {
+		if strings.Contains(registerListing, " "+op.String()+" ") {
+			t.Errorf("unexpected %s\n%s", op, registerListing)
+		}
+	}
+
+	expected := "false true\nfalse true 2\nfalse true 6\ntrue false\n"
+	var stackOut, registerOut bytes.Buffer
+	if err := vm.New(c.Bytecode(), vm.WithStdout(&stackOut)).Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if err := vm.NewRegisterVM(rc.RegisterBytecode(), vm.WithStdout(&registerOut)).Run(); err != nil {
+		t.Fatalf("register vm error: %s", err)
+	}
+	if stackOut.String() != expected || registerOut.String() != expected {
+		t.Errorf("expected %q, got %q (stack) and %q (register)", expected, stackOut.String(), registerOut.String())
+	}
+}
@@ -0,0 +1,97 @@
+package compiler
+
+import (
+	"fmt"
+	"minlang/ast"
+	"minlang/lexer"
+)
+
+// Map lookups
+//
+// m[k] is nil when k isn't in m, and nil in typed arithmetic passes for 0, or
+// "nil" in a string, so a missing key goes unnoticed. var v, ok = m[k] tells
+// the two apart; it compiles as
+//
+//	var m' = m
+//	var k' = k
+//	var ok = hasKey(m', k')
+//	var v = m'[k']
+//
+// with the primed names hidden like those of a for-in loop, so the map and
+// key are evaluated once. With -warn, a map lookup used directly as an
+// operand of arithmetic or an ordering, where a missing key would go
+// unnoticed that way, gets a warning suggesting the form.
+
+// lowerLookup returns the statements node compiles as
+func (c *Compiler) lowerLookup(node *ast.LookupStatement) ([]ast.Statement, error) {
+	switch t := c.inferDetailedType(node.Index.Left); t.(type) {
+	case *MapType, *AnyType:
+	default:
+		return nil, fmt.Errorf("cannot look up a key in %s", t.String())
+	}
+	if symbol, ok := c.symbolTable.Resolve("hasKey"); !ok || symbol.Scope != BuiltinScope {
+		return nil, fmt.Errorf("map lookup needs the builtin hasKey, which is shadowed here")
+	}
+
+	l := &lookupLowering{node: node, id: c.lookups}
+	c.lookups++
+	m, k := l.hidden("map"), l.hidden("key")
+	return []ast.Statement{
+		l.declare(m, node.Index.Left, true),
+		l.declare(k, node.Index.Index, true),
+		l.declare(node.Found, &ast.CallExpression{
+			Token:     l.token(lexer.LPAREN, "("),
+			Function:  l.ident("hasKey"),
+			Arguments: []ast.Expression{m, k},
+		}, node.IsMutable),
+		l.declare(node.Value, &ast.IndexExpression{Token: node.Index.Token, Left: m, Index: k}, node.IsMutable),
+	}, nil
+}
+
+// warnMaybeNil warns about the operands of node that are map lookups, if
+// node is arithmetic or an ordering compiled for known types
+func (c *Compiler) warnMaybeNil(node *ast.InfixExpression) {
+	switch node.Operator {
+	case "+", "-", "*", "/", "%", "<", ">", "<=", ">=":
+	default:
+		return
+	}
+	for _, operand := range []ast.Expression{node.Left, node.Right} {
+		index, ok := operand.(*ast.IndexExpression)
+		if !ok {
+			continue
+		}
+		mapType, ok := c.inferDetailedType(index.Left).(*MapType)
+		if !ok {
+			continue
+		}
+		if t := mapType.ValueType; t.Equals(IntType) || t.Equals(FloatType) || t.Equals(StringType) {
+			lookup := index.Left.String() + "[" + index.Index.String() + "]"
+			c.warnf(operand, "%s may be nil if the key is missing; check with var v, ok = %s", lookup, lookup)
+		}
+	}
+}
+
+// lookupLowering builds the statements of a lowered map lookup, giving them
+// the position of its var
+type lookupLowering struct {
+	node *ast.LookupStatement
+	id   int // Distinguishes the hidden variables of each lookup
+}
+
+// hidden returns a hidden variable of the lookup
+func (l *lookupLowering) hidden(name string) *ast.Identifier {
+	return l.ident(fmt.Sprintf("lookup%d.%s", l.id, name))
+}
+
+func (l *lookupLowering) declare(name *ast.Identifier, value ast.Expression, isMutable bool) *ast.VarStatement {
+	return &ast.VarStatement{Token: l.node.Token, Name: name, Value: value, IsMutable: isMutable}
+}
+
+func (l *lookupLowering) ident(name string) *ast.Identifier {
+	return &ast.Identifier{Token: l.token(lexer.IDENT, name), Value: name}
+}
+
+func (l *lookupLowering) token(typ lexer.TokenType, literal string) lexer.Token {
+	return lexer.Token{Type: typ, Literal: literal, Line: l.node.Token.Line, Column: l.node.Token.Column}
+}
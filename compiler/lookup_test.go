@@ -0,0 +1,42 @@
+package compiler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMaybeNilWarnings(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		warnings []string
+	}{
+		{
+			"map lookup in arithmetic",
+			"var m = map[string]int{\"a\": 1}\nvar n = m[\"a\"] + 1",
+			[]string{`2:9: m["a"] may be nil if the key is missing; check with var v, ok = m["a"]`},
+		},
+		{
+			"map lookup in an ordering",
+			"var m = map[string]float{\"a\": 1.5}\nif 2.0 < m[\"a\"] {\n    print(1)\n}",
+			[]string{`2:10: m["a"] may be nil if the key is missing; check with var v, ok = m["a"]`},
+		},
+		{
+			"checked lookup",
+			"var m = map[string]int{\"a\": 1}\nvar n, ok = m[\"a\"]\nif ok {\n    print(n + 1)\n}",
+			nil,
+		},
+		{
+			"array index and equality",
+			"var xs = [1, 2]\nvar m = map[string]int{\"a\": 1}\nprint(xs[0] + 1, m[\"a\"] == nil)",
+			nil,
+		},
+	}
+
+	for _, tt := range tests {
+		c := compileSource(t, tt.input)
+		if !reflect.DeepEqual(c.Warnings(), tt.warnings) {
+			t.Errorf("%s: expected warnings %q, got %q", tt.name, tt.warnings, c.Warnings())
+		}
+	}
+}
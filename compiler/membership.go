@@ -0,0 +1,39 @@
+package compiler
+
+import (
+	"fmt"
+	"minlang/ast"
+)
+
+// The in operator
+//
+// k in m is whether the map m has the key k, even one holding nil, x in xs
+// whether an element of the array xs equals x, and sub in s whether the
+// string s contains sub. It compiles to OpIn, with its operands evaluated
+// left to right as for any other operator.
+
+// checkIn reports an in whose right side is known not to be a map, array or
+// string, or whose left side can't be what it looks for
+func (c *Compiler) checkIn(node *ast.InfixExpression) error {
+	switch container := c.inferDetailedType(node.Right).(type) {
+	case *MapType:
+		keyType := c.inferDetailedType(node.Left)
+		if !IsAssignableTo(keyType, container.KeyType) {
+			return fmt.Errorf("cannot use key of type %s for map with key type %s",
+				keyType.String(), container.KeyType.String())
+		}
+	case *BasicType:
+		if isScalar(container) {
+			return fmt.Errorf("in: expected a map, array or string, got %s", container.String())
+		}
+		if t, ok := c.inferDetailedType(node.Left).(*BasicType); ok && container.Equals(StringType) && isScalar(t) {
+			return fmt.Errorf("in: looking for %s in a string, want a string", t.String())
+		}
+	}
+	return nil
+}
+
+// isScalar reports whether t is int, float or bool
+func isScalar(t *BasicType) bool {
+	return t.Equals(IntType) || t.Equals(FloatType) || t.Equals(BoolType)
+}
@@ -0,0 +1,46 @@
+package compiler
+
+import (
+	"minlang/lexer"
+	"minlang/parser"
+	"minlang/vm"
+	"strings"
+	"testing"
+)
+
+func TestIn(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"map", "var m = map[string]int{\"a\": 1}\nvar b: bool = \"a\" in m", ""},
+		{"array", "var xs = [1, 2]\nvar b: bool = 2 in xs", ""},
+		{"string", "var b: bool = \"ell\" in \"hello\"", ""},
+		{"key type", "var m = map[string]int{\"a\": 1}\nvar b = 1 in m", "cannot use key of type int for map with key type string"},
+		{"not a container", "var n = 3\nvar b = 1 in n", "in: expected a map, array or string, got int"},
+		{"not a substring", "var b = 1 in \"hello\"", "in: looking for int in a string, want a string"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("%s: parser errors: %v", tt.name, p.Errors())
+		}
+
+		c := New()
+		err := c.Compile(program)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s: compiler error: %s", tt.name, err)
+			} else if !strings.Contains(vm.Disassemble(c.Bytecode().Instructions), " IN\n") {
+				t.Errorf("%s: expected an IN instruction", tt.name)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%s: expected error %q, got %v", tt.name, tt.err, err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package compiler
+
+import (
+	"minlang/ast"
+	"minlang/vm"
+)
+
+// Overflow checks
+//
+// Ints are 64 bits and wrap around on overflow. With overflow checks on,
+// +, -, * and / on ints, and negating one, stop the program with an error
+// where the result doesn't fit instead: such an expression compiles to its
+// operands and a checked instruction, in place of the opcodes specialized
+// for its types. Expressions known to be floats or strings keep theirs.
+
+// checkedOps are the checked instructions for each operator
+var checkedOps = map[string]struct {
+	stack    vm.OpCode
+	register vm.RegisterOpCode
+}{
+	"+": {vm.OpAddChecked, vm.OpRAddChecked},
+	"-": {vm.OpSubChecked, vm.OpRSubChecked},
+	"*": {vm.OpMulChecked, vm.OpRMulChecked},
+	"/": {vm.OpDivChecked, vm.OpRDivChecked},
+}
+
+// SetCheckOverflow makes int arithmetic that overflows a runtime error
+// instead of wrapping around. It doesn't combine with int32 mode, whose
+// ints wrap by design.
+func (c *Compiler) SetCheckOverflow(enabled bool) {
+	c.checkOverflow = enabled
+}
+
+// checksOverflow reports whether node is int arithmetic that overflow
+// checks apply to
+func (c *Compiler) checksOverflow(node ast.Node) bool {
+	if !c.checkOverflow {
+		return false
+	}
+	switch node := node.(type) {
+	case *ast.InfixExpression:
+		if _, ok := checkedOps[node.Operator]; !ok || node.Operator == "/" && c.promoteIntDiv {
+			return false
+		}
+	case *ast.PrefixExpression:
+		// A negated literal is in range
+		if _, ok := NegatedLiteral(node); ok || node.Operator != "-" {
+			return false
+		}
+	default:
+		return false
+	}
+	return c.inferExpressionType(node.(ast.Expression)) == vm.IntType
+}
+
+// compileChecked compiles node, an expression checksOverflow accepts, with
+// a checked instruction
+func (c *Compiler) compileChecked(node ast.Node) error {
+	switch node := node.(type) {
+	case *ast.InfixExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.emit(checkedOps[node.Operator].stack)
+	case *ast.PrefixExpression:
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		c.emit(vm.OpNegChecked)
+	}
+	return nil
+}
+
+// compileChecked compiles node, an expression checksOverflow accepts, with
+// a checked instruction into a new register
+func (rc *RegisterCompiler) compileChecked(node ast.Node) (int, error) {
+	resultReg := -1
+	switch node := node.(type) {
+	case *ast.InfixExpression:
+		leftReg, err := rc.CompileToRegister(node.Left)
+		if err != nil {
+			return -1, err
+		}
+		rightReg, err := rc.CompileToRegister(node.Right)
+		if err != nil {
+			return -1, err
+		}
+		resultReg = rc.allocateTempRegister()
+		rc.emitR(checkedOps[node.Operator].register, uint8(resultReg), uint8(leftReg), uint8(rightReg))
+		rc.freeTempRegister(leftReg)
+		rc.freeTempRegister(rightReg)
+	case *ast.PrefixExpression:
+		operandReg, err := rc.CompileToRegister(node.Right)
+		if err != nil {
+			return -1, err
+		}
+		resultReg = rc.allocateTempRegister()
+		rc.emitR(vm.OpRNegChecked, uint8(resultReg), uint8(operandReg), 0)
+		rc.freeTempRegister(operandReg)
+	}
+	return resultReg, nil
+}
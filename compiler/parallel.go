@@ -0,0 +1,194 @@
+package compiler
+
+import (
+	"maps"
+	"minlang/ast"
+	"minlang/vm"
+	"runtime"
+	"slices"
+	"sync"
+)
+
+// Parallel compilation of top-level functions
+//
+// A top-level function captures nothing, and with its own constant pool its
+// body depends only on the globals, types and signatures declared before it.
+// The register compiler declares each top-level function where it appears,
+// storing it from a constant reserved for it, and queues the body for a pool
+// of goroutines that compile bodies while the rest of the program is
+// compiled. The finished function fills in the constant.
+//
+// A run of function declarations shares one read-only copy of what has been
+// declared, taken when the run ends. Each body sees the globals only up to its
+// own function, as it would compiling in order. A function whose name is already defined
+// ends the run and is compiled in order, so a body never sees a global that
+// was redefined after it.
+
+// functionJob is a top-level function compiled by its own goroutine
+type functionJob struct {
+	node       *ast.FunctionStatement
+	funcType   *FunctionType
+	fnIndex    int // Constant reserved for the function
+	globals    int // Globals defined up to and including the function
+	warningsAt int // Warnings recorded before the function was reached
+
+	compiler *RegisterCompiler // Forked for the body once the run ends
+
+	fn       *vm.Function
+	err      error
+	warnings []string
+	panic    interface{} // Raised again once every job is done
+}
+
+// compileProgram compiles the statements of a program, compiling its
+// top-level function bodies concurrently with the rest
+func (rc *RegisterCompiler) compileProgram(stmts []ast.Statement) error {
+	// On one thread nothing runs alongside, and forking only adds work
+	workers := runtime.GOMAXPROCS(0)
+	if workers == 1 {
+		for _, s := range stmts {
+			if _, err := rc.CompileToRegister(s); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var jobs, run []*functionJob
+
+	// Each worker keeps its goroutine's stack, grown deep by compiling, from
+	// one body to the next
+	queue := make(chan *functionJob, len(stmts))
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				job.compile()
+			}
+		}()
+	}
+
+	var err error
+	for _, s := range stmts {
+		if fn, ok := s.(*ast.FunctionStatement); ok {
+			if _, defined := rc.symbolTable.Resolve(fn.Name.Value); !defined {
+				var job *functionJob
+				if job, err = rc.declareTopLevelFunction(fn); err != nil {
+					break
+				}
+				jobs = append(jobs, job)
+				run = append(run, job)
+				continue
+			}
+		}
+
+		rc.startFunctions(run, queue)
+		run = nil
+		if _, err = rc.CompileToRegister(s); err != nil {
+			break
+		}
+	}
+	rc.startFunctions(run, queue)
+	close(queue)
+	wg.Wait()
+
+	// A function comes before any statement that failed after it, so its
+	// errors are reported first as they would be compiling in order
+	for _, job := range jobs {
+		if job.panic != nil {
+			panic(job.panic)
+		}
+		if job.err != nil {
+			return job.err
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// Later insertions first, so earlier positions still hold
+	for i := len(jobs) - 1; i >= 0; i-- {
+		job := jobs[i]
+		rc.constants[job.fnIndex] = vm.NewFunctionValue(job.fn)
+		rc.warnings = slices.Insert(rc.warnings, job.warningsAt, job.warnings...)
+	}
+	return nil
+}
+
+// declareTopLevelFunction defines a top-level function and emits its store,
+// leaving the body to be compiled by startFunctions
+func (rc *RegisterCompiler) declareTopLevelFunction(node *ast.FunctionStatement) (*functionJob, error) {
+	if tok, ok := ast.NodeToken(node); ok && tok.Line > 0 {
+		saved := rc.pos
+		rc.pos = vm.Position{Line: tok.Line, Column: tok.Column}
+		defer func() { rc.pos = saved }()
+	}
+
+	if len(node.TypeParams) > 0 {
+		return nil, errGenericFunction(node)
+	}
+	funcType := rc.declareFunction(node)
+	symbol := rc.symbolTable.Define(node.Name.Value)
+	job := &functionJob{
+		node:       node,
+		funcType:   funcType,
+		fnIndex:    rc.reserveConstant(),
+		globals:    symbol.Index + 1,
+		warningsAt: len(rc.warnings),
+	}
+	return job, rc.storeFunction(node, symbol, job.fnIndex, nil)
+}
+
+// startFunctions queues the bodies of a run of top-level functions
+func (rc *RegisterCompiler) startFunctions(run []*functionJob, queue chan<- *functionJob) {
+	if len(run) == 0 {
+		return
+	}
+
+	declared := rc.snapshot()
+	for _, job := range run {
+		job.compiler = declared.fork(job)
+		queue <- job
+	}
+}
+
+// compile compiles the body of job's function with its forked compiler
+func (job *functionJob) compile() {
+	defer func() { job.panic = recover() }()
+
+	job.fn, _, job.err = job.compiler.compileFunction(job.node, job.funcType)
+	job.warnings = job.compiler.warnings
+	job.compiler = nil
+}
+
+// snapshot returns a copy of what rc has declared that rc's later
+// declarations don't change
+func (rc *RegisterCompiler) snapshot() *Compiler {
+	c := *rc.Compiler
+	c.symbolTable = rc.symbolTable.Snapshot()
+	c.enumTypes = maps.Clone(c.enumTypes)
+	c.bareVariants = maps.Clone(c.bareVariants)
+	c.structTypes = maps.Clone(c.structTypes)
+	c.varTypes = maps.Clone(c.varTypes)
+	c.typeInfo = maps.Clone(c.typeInfo)
+	c.functionSigs = maps.Clone(c.functionSigs)
+	c.constAliases = maps.Clone(c.constAliases)
+	return &c
+}
+
+// fork returns a compiler for the body of job's function that reads the
+// declarations in declared. compileFunction records the body's types in maps
+// of its own, so declared is only read and can be shared.
+func (declared *Compiler) fork(job *functionJob) *RegisterCompiler {
+	c := *declared
+	c.constants = nil
+	c.constantIndex = nil
+	c.symbolTable = declared.symbolTable.Prefix(job.globals)
+	c.scopes = slices.Clone(c.scopes)
+	c.loopStack = nil
+	c.warnings = nil
+	c.constAliases = maps.Clone(c.constAliases)
+	return newRegisterCompiler(&c)
+}
@@ -0,0 +1,127 @@
+package compiler
+
+import (
+	"bytes"
+	"fmt"
+	"minlang/lexer"
+	"minlang/parser"
+	"minlang/vm"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// compileRegisterWith compiles input with the register compiler using procs
+// threads, and runs it if it compiles
+func compileRegisterWith(t *testing.T, input string, procs int) (output string, warnings []string, err error) {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(procs))
+	rc := NewRegisterCompiler()
+	if _, err := rc.CompileToRegister(program); err != nil {
+		return "", rc.Warnings(), err
+	}
+
+	var stdout bytes.Buffer
+	if err := vm.NewRegisterVM(rc.RegisterBytecode(), vm.WithStdout(&stdout)).Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	return stdout.String(), rc.Warnings(), nil
+}
+
+func TestParallelFunctionCompilation(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+	}{
+		{
+			"functions calling earlier functions",
+			`
+var base = 10
+func double(n: int): int {
+    return n * 2
+}
+func fib(n: int): int {
+    if n < 2 { return n }
+    return fib(n - 1) + fib(n - 2)
+}
+func combine(n: int): int {
+    return double(fib(n)) + base
+}
+print(combine(10), double(base))`,
+		},
+		{
+			"redefined names",
+			`
+var x = 1
+func f(): int { return x + 1 }
+func g(): int { return f() * 10 }
+var x = 5
+func f(): int { return 100 }
+print(f(), g(), x)`,
+		},
+		{
+			"locals of a function body",
+			`
+var s = 1
+func f(): float {
+    var s = 2.5
+    return s
+}
+print(s + 1, f())`,
+		},
+		{
+			"call to a later function",
+			`
+func a(): int { return b() }
+func b(): int { return 1 }
+print(a())`,
+		},
+		{
+			"function error before a later error",
+			`
+func a(): int { return "s" }
+print(missing)`,
+		},
+		{
+			"warnings in program order",
+			`
+func a(): int {
+    return 1
+    print("a")
+}
+for true {
+    break
+    print("loop")
+}
+func b(): int {
+    return 2
+    print("b")
+}
+print(a() + b())`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, warnings, err := compileRegisterWith(t, tt.input, 1)
+			parallelOutput, parallelWarnings, parallelErr := compileRegisterWith(t, tt.input, 4)
+
+			if fmt.Sprint(err) != fmt.Sprint(parallelErr) {
+				t.Fatalf("expected error %v, got %v", err, parallelErr)
+			}
+			if parallelOutput != output {
+				t.Errorf("expected output %q, got %q", output, parallelOutput)
+			}
+			if !reflect.DeepEqual(parallelWarnings, warnings) {
+				t.Errorf("expected warnings %v, got %v", warnings, parallelWarnings)
+			}
+		})
+	}
+}
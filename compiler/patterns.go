@@ -0,0 +1,242 @@
+package compiler
+
+import (
+	"fmt"
+	"minlang/ast"
+	"minlang/vm"
+)
+
+// Struct and array patterns
+//
+// A case value may take the switch value apart instead of being compared
+// with it. Besides the variants of enum_payloads.go, it may be a struct
+// pattern, matching a struct of the type it names whose listed fields match
+// their patterns, or an array pattern, matching an array with as many
+// elements as it lists, each matching its pattern:
+//
+//	switch p {
+//	case Point{x: 0, y: y} { print("on the y axis at", y) }
+//	case [first, _] { print("a pair starting with", first) }
+//	default { print("elsewhere") }
+//	}
+//
+// Inside a pattern, a literal matches a value equal to it, a name matches
+// anything and is bound to it as a constant for the case's body, and _
+// matches anything. A name on its own as the case value is compared, as it
+// always was.
+//
+// The switch stores the value in a hidden variable, and each such case
+// tests it a part at a time, with TEST_STRUCT, TEST_ARRAY and EQ, reaching
+// the parts with GET_FIELD and ARRAY_GET. The case's body starts by loading
+// the parts it binds the same way.
+
+// casePattern is a case value that takes the switch value apart
+type casePattern interface {
+	// test replaces the switch value on top of the stack with whether it
+	// matches
+	test(c *Compiler)
+	// bind pops the switch value at the start of the case's body, defining
+	// the names the pattern binds
+	bind(c *Compiler)
+}
+
+// casePatterns returns the pattern each case of node is, or nil for a case
+// compared with ==
+func (c *Compiler) casePatterns(node *ast.SwitchStatement) ([]casePattern, error) {
+	patterns := make([]casePattern, len(node.Cases))
+	var subject *Symbol // Hidden variable the switch's struct and array patterns test
+	for i, caseClause := range node.Cases {
+		switch value := caseClause.Value.(type) {
+		case *ast.StructPattern, *ast.ArrayPattern:
+			if subject == nil {
+				symbol := c.symbolTable.DefineWithMutability(fmt.Sprintf("match%d.value", c.matches), true)
+				c.matches++
+				subject = &symbol
+			}
+			pattern := &shapePattern{subject: *subject}
+			if err := pattern.walk(c, value, nil, c.inferDetailedType(node.Value), map[string]bool{}); err != nil {
+				return nil, fmt.Errorf("case %s: %w", value.String(), err)
+			}
+			patterns[i] = pattern
+		default:
+			pattern, err := c.enumPattern(value)
+			if err != nil {
+				return nil, err
+			}
+			if pattern != nil {
+				patterns[i] = pattern
+			}
+		}
+	}
+	return patterns, nil
+}
+
+// shapePattern is a struct or array pattern
+type shapePattern struct {
+	subject  Symbol         // Hidden variable holding the switch value
+	checks   []patternCheck // What the parts of the value must be, outermost first
+	bindings []patternBinding
+}
+
+// patternStep leads from a value to one of its parts: a field, or an array
+// element if field is ""
+type patternStep struct {
+	field string
+	index int
+}
+
+// patternCheck is a test of the part of the switch value at path
+type patternCheck struct {
+	path []patternStep
+	emit func(c *Compiler) // Replaces the part on top of the stack with whether it passes
+}
+
+// patternBinding is a name bound to the part of the switch value at path
+type patternBinding struct {
+	name *ast.Identifier
+	path []patternStep
+	t    Type
+}
+
+// walk records the checks and bindings of pattern, matching the part of the
+// switch value at path, of type t as far as the compiler knows
+func (p *shapePattern) walk(c *Compiler, pattern ast.Expression, path []patternStep, t Type, bound map[string]bool) error {
+	switch pat := pattern.(type) {
+	case *ast.StructPattern:
+		structType, ok := c.structTypes[pat.Name.Value]
+		if !ok {
+			return fmt.Errorf("unknown struct %s", pat.Name.Value)
+		}
+		name := c.addConstant(vm.StringValue(structType.Name))
+		p.checks = append(p.checks, patternCheck{path: path, emit: func(c *Compiler) {
+			c.emit(vm.OpPush, name)
+			c.emit(vm.OpTestStruct)
+		}})
+		seen := make(map[string]bool)
+		for _, field := range pat.Fields {
+			fieldType, ok := structType.Fields[field.Name.Value]
+			if !ok {
+				return fmt.Errorf("struct %s has no field %s", structType.Name, field.Name.Value)
+			}
+			if seen[field.Name.Value] {
+				return fmt.Errorf("field %s appears twice", field.Name.Value)
+			}
+			seen[field.Name.Value] = true
+			t := ConvertASTType(&ast.TypeAnnotation{Name: fieldType})
+			if err := p.walk(c, field.Pattern, extend(path, patternStep{field: field.Name.Value}), t, bound); err != nil {
+				return err
+			}
+		}
+
+	case *ast.ArrayPattern:
+		n := len(pat.Elements)
+		p.checks = append(p.checks, patternCheck{path: path, emit: func(c *Compiler) {
+			c.emit(vm.OpTestArray, n)
+		}})
+		var elementType Type = AnyTypeVal
+		if arrayType, ok := t.(*ArrayType); ok {
+			elementType = arrayType.ElementType
+		}
+		for i, element := range pat.Elements {
+			if err := p.walk(c, element, extend(path, patternStep{index: i}), elementType, bound); err != nil {
+				return err
+			}
+		}
+
+	case *ast.Identifier:
+		if pat.Value == "_" {
+			return nil
+		}
+		if bound[pat.Value] {
+			return fmt.Errorf("%s is bound twice", pat.Value)
+		}
+		bound[pat.Value] = true
+		p.bindings = append(p.bindings, patternBinding{name: pat, path: path, t: t})
+
+	default:
+		value, ok := patternLiteral(pat)
+		if !ok {
+			return fmt.Errorf("expected a name, _, a literal or a pattern, got %s", pat.String())
+		}
+		if literalType := c.inferDetailedType(pat); !IsAssignableTo(literalType, t) {
+			return fmt.Errorf("%s can never match a %s", pat.String(), t.String())
+		}
+		if value.Type == vm.IntType && t.Equals(FloatType) {
+			value = vm.FloatValue(float64(value.AsInt()))
+		}
+		literal := c.addConstant(value)
+		p.checks = append(p.checks, patternCheck{path: path, emit: func(c *Compiler) {
+			c.emit(vm.OpPush, literal)
+			c.emit(vm.OpEq)
+		}})
+	}
+	return nil
+}
+
+// extend returns path followed by step, leaving path as it is
+func extend(path []patternStep, step patternStep) []patternStep {
+	return append(path[:len(path):len(path)], step)
+}
+
+// test stores the switch value and runs the checks in order, the first to
+// fail deciding the case doesn't match
+func (p *shapePattern) test(c *Compiler) {
+	c.storeSymbol(p.subject)
+	fails := make([]int, len(p.checks))
+	for i, check := range p.checks {
+		p.load(c, check.path)
+		check.emit(c)
+		fails[i] = c.emit(vm.OpJumpIfFalse, 9999)
+	}
+	c.emit(vm.OpPush, c.addConstant(vm.BoolValue(true)))
+	done := c.emit(vm.OpJump, 9999)
+
+	failPos := len(c.currentInstructions())
+	for _, pos := range fails {
+		c.changeOperand(pos, failPos)
+	}
+	c.emit(vm.OpPush, c.addConstant(vm.BoolValue(false)))
+	c.changeOperand(done, len(c.currentInstructions()))
+}
+
+// bind defines each name the pattern binds as the part of the switch value
+// it matched
+func (p *shapePattern) bind(c *Compiler) {
+	c.emit(vm.OpPop)
+	for _, binding := range p.bindings {
+		p.load(c, binding.path)
+		c.storeSymbol(c.defineBinding(binding.name, binding.t))
+	}
+}
+
+// load pushes the part of the switch value at path
+func (p *shapePattern) load(c *Compiler, path []patternStep) {
+	c.loadSymbol(p.subject)
+	for _, step := range path {
+		if step.field != "" {
+			c.emit(vm.OpPush, c.addConstant(vm.StringValue(step.field)))
+			c.emit(vm.OpGetField)
+		} else {
+			c.emit(vm.OpPush, c.addConstant(vm.IntValue(int64(step.index))))
+			c.emit(vm.OpArrayGet)
+		}
+	}
+}
+
+// patternLiteral returns the value of a literal in a pattern, negative
+// numbers included
+func patternLiteral(expr ast.Expression) (vm.Value, bool) {
+	switch e := expr.(type) {
+	case *ast.FloatLiteral:
+		return vm.FloatValue(e.Value), true
+	case *ast.BooleanLiteral:
+		return vm.BoolValue(e.Value), true
+	case *ast.NilLiteral:
+		return vm.NilValue(), true
+	case *ast.PrefixExpression:
+		if lit, ok := e.Right.(*ast.FloatLiteral); ok && e.Operator == "-" {
+			return vm.FloatValue(-lit.Value), true
+		}
+	}
+	return enumLiteral(expr)
+}
@@ -0,0 +1,76 @@
+package compiler
+
+import (
+	"minlang/lexer"
+	"minlang/parser"
+	"testing"
+)
+
+func TestStructAndArrayPatterns(t *testing.T) {
+	point := "type Point = struct { x: int; y: int }\nvar p = Point{x: 0, y: 2}\n"
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{
+			"match",
+			"switch p {\ncase Point{x: 0, y: y} { print(y * 2) }\ncase Point{x: x, y: _} { print(x) }\ndefault { print(0) }\n}",
+			"",
+		},
+		{
+			"nested",
+			"switch [p, 1] {\ncase [Point{x: 0, y: y}, n] { print(y + n) }\ndefault { print(0) }\n}",
+			"",
+		},
+		{
+			"unknown struct",
+			"switch p {\ncase Pt{x: 0} { print(0) }\ndefault { print(1) }\n}",
+			"case Pt{x: 0}: unknown struct Pt",
+		},
+		{
+			"unknown field",
+			"switch p {\ncase Point{z: 0} { print(0) }\ndefault { print(1) }\n}",
+			"case Point{z: 0}: struct Point has no field z",
+		},
+		{
+			"literal of the wrong type",
+			"switch p {\ncase Point{x: \"0\"} { print(0) }\ndefault { print(1) }\n}",
+			"case Point{x: \"0\"}: \"0\" can never match a int",
+		},
+		{
+			"name bound twice",
+			"switch p {\ncase Point{x: a, y: a} { print(a) }\ndefault { print(1) }\n}",
+			"case Point{x: a, y: a}: a is bound twice",
+		},
+		{
+			"bindings are constants",
+			"switch p {\ncase Point{x: x} { x = 1 }\ndefault { print(1) }\n}",
+			"cannot assign to const variable x",
+		},
+		{
+			"bindings are typed",
+			"switch p {\ncase Point{y: y} { var s: string = y }\ndefault { print(1) }\n}",
+			"4:20: cannot assign value of type int to type string",
+		},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(point + tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("%s: parser errors: %v", tt.name, p.Errors())
+		}
+
+		err := New().Compile(program)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s: compiler error: %s", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%s: expected error %q, got %v", tt.name, tt.err, err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package compiler
+
+import (
+	"bytes"
+	"minlang/lexer"
+	"minlang/parser"
+	"minlang/vm"
+	"strings"
+	"testing"
+)
+
+// TestRegisterCompareAndBranch checks that conditions comparing ints or
+// floats branch on the comparison, with no bool in a register, and take the
+// same paths as a materialized comparison would
+func TestRegisterCompareAndBranch(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		ops      []vm.RegisterOpCode // Expected in the listing
+	}{
+		{
+			name: "int comparisons",
+			input: `
+var a = 3
+var b = 5
+var out = ""
+if a < b { out = out + "lt " }
+if a > b { out = out + "gt " }
+if a <= 3 { out = out + "le " }
+if b >= 6 { out = out + "ge " }
+if a == 3 { out = out + "eq " }
+if a != 3 { out = out + "ne " }
+print(out)`,
+			expected: "lt le eq \n",
+			ops:      []vm.RegisterOpCode{vm.OpRJumpLtInt, vm.OpRJumpLeInt, vm.OpRJumpEqInt},
+		},
+		{
+			name: "float comparisons and not",
+			input: `
+var x = 1.5
+var hits = 0
+if x > 1.0 { hits = hits + 1 }
+if !(x >= 2.0) { hits = hits + 10 }
+if !(x != 1.5) { hits = hits + 100 }
+print(hits)`,
+			expected: "111\n",
+			ops:      []vm.RegisterOpCode{vm.OpRJumpLtFloat, vm.OpRJumpLeFloat, vm.OpRJumpEqFloat},
+		},
+		{
+			name: "loop condition and if expression",
+			input: `
+var total = 0
+for var i = 0; i < 10; i = i + 1 {
+    total = total + if i >= 5 { i } else { 0 }
+}
+print(total)`,
+			expected: "35\n",
+			ops:      []vm.RegisterOpCode{vm.OpRJumpLtInt, vm.OpRJumpLeInt},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := parser.New(lexer.New(tt.input))
+			program := p.ParseProgram()
+			if len(p.Errors()) > 0 {
+				t.Fatalf("parser errors: %v", p.Errors())
+			}
+
+			rc := NewRegisterCompiler()
+			if _, err := rc.CompileToRegister(program); err != nil {
+				t.Fatalf("compilation error: %s", err)
+			}
+
+			listing := vm.RegisterDisassemble(rc.RegisterBytecode().Instructions)
+			for _, op := range tt.ops {
+				if !strings.Contains(listing, " "+op.String()+" ") {
+					t.Errorf("expected %s\n%s", op, listing)
+				}
+			}
+			if strings.Contains(listing, vm.OpRJumpF.String()) {
+				t.Errorf("expected no %s\n%s", vm.OpRJumpF, listing)
+			}
+
+			var stdout bytes.Buffer
+			if err := vm.NewRegisterVM(rc.RegisterBytecode(), vm.WithStdout(&stdout)).Run(); err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+			if stdout.String() != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, stdout.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package compiler
+
+import (
+	"bytes"
+	"minlang/lexer"
+	"minlang/parser"
+	"minlang/vm"
+	"strings"
+	"testing"
+)
+
+func TestRegisterCalls(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+		err      string // Expected runtime error, if any
+	}{
+		{
+			name: "nested calls as arguments",
+			input: `
+func add3(a: int, b: int, c: int): int {
+    return a + b + c
+}
+func sq(x: int): int { return x * x }
+print(add3(sq(2), sq(3), add3(1, 2, 3)))
+print(sq(2) + sq(3) * sq(4))`,
+			expected: "19\n148\n",
+		},
+		{
+			name: "function stored in a global",
+			input: `
+func sq(x: int): int { return x * x }
+var f = sq
+func useGlobal(n: int): int {
+    var a = 1
+    return f(n) + f(f(a + 1))
+}
+print(f(f(3)), useGlobal(3))`,
+			expected: "81 25\n",
+		},
+		{
+			name: "recursion",
+			input: `
+func fact(n: int): int {
+    if n <= 1 { return 1 }
+    return n * fact(n - 1)
+}
+func ack(m: int, n: int): int {
+    if m == 0 { return n + 1 }
+    if n == 0 { return ack(m - 1, 1) }
+    return ack(m - 1, ack(m, n - 1))
+}
+print(fact(10), ack(2, 3))`,
+			expected: "3628800 9\n",
+		},
+		{
+			name: "function from an array element",
+			input: `
+func sq(x: int): int { return x * x }
+func inc(x: int): int { return x + 1 }
+var fs = [sq, inc]
+print(fs[0](fs[1](fs[0](2))))`,
+			expected: "25\n",
+		},
+		{
+			name: "builtins as values",
+			input: `
+var size = len
+var out = print
+out("size", size("abc"), 2)`,
+			expected: "size 3 2\n",
+		},
+		{
+			name: "builtin past index 15",
+			input: `
+var n = int("42")
+print(string(n + 1) + "!")`,
+			expected: "43!\n",
+		},
+		{
+			name: "wrong number of arguments",
+			input: `
+func sq(x: int): int { return x * x }
+var fs = [sq]
+print(fs[0](3, 4))`,
+			err: "function sq expects 1 arguments, got 2",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := parser.New(lexer.New(tt.input))
+			program := p.ParseProgram()
+			if len(p.Errors()) > 0 {
+				t.Fatalf("parser errors: %v", p.Errors())
+			}
+
+			rc := NewRegisterCompiler()
+			if _, err := rc.CompileToRegister(program); err != nil {
+				t.Fatalf("compilation error: %s", err)
+			}
+
+			var stdout bytes.Buffer
+			err := vm.NewRegisterVM(rc.RegisterBytecode(), vm.WithStdout(&stdout)).Run()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+			if stdout.String() != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, stdout.String())
+			}
+		})
+	}
+}
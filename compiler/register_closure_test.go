@@ -0,0 +1,83 @@
+package compiler
+
+import (
+	"bytes"
+	"minlang/lexer"
+	"minlang/parser"
+	"minlang/vm"
+	"testing"
+)
+
+func TestRegisterClosures(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name: "parameter",
+			input: `
+func outer(x: int): int {
+    func inner(y: int): int {
+        return x + y
+    }
+    return inner(10)
+}
+print(outer(5))`,
+			expected: "15\n",
+		},
+		{
+			name: "through two levels",
+			input: `
+func outer(a: int): int {
+    var b = 10
+    func middle(c: int): int {
+        func inner(d: int): int {
+            return a + b + c + d
+        }
+        return inner(1)
+    }
+    return middle(100)
+}
+print(outer(5))`,
+			expected: "116\n",
+		},
+		{
+			name: "captured by value",
+			input: `
+func counter(): int {
+    var n = 3
+    func get(): int {
+        return n * 2
+    }
+    n = 4
+    return get()
+}
+print(counter())`,
+			expected: "6\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := parser.New(lexer.New(tt.input))
+			program := p.ParseProgram()
+			if len(p.Errors()) > 0 {
+				t.Fatalf("parser errors: %v", p.Errors())
+			}
+
+			rc := NewRegisterCompiler()
+			if _, err := rc.CompileToRegister(program); err != nil {
+				t.Fatalf("compilation error: %s", err)
+			}
+
+			var stdout bytes.Buffer
+			if err := vm.NewRegisterVM(rc.RegisterBytecode(), vm.WithStdout(&stdout)).Run(); err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+			if stdout.String() != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, stdout.String())
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package compiler
 
 import (
 	"fmt"
+	"maps"
 	"minlang/ast"
 	"minlang/vm"
 )
@@ -17,6 +18,8 @@ type RegisterCompiler struct {
 	tempRegs       []int                 // Available temporary registers
 	liveRanges     map[string]*LiveRange // Variable live ranges
 	instructions   []vm.RegisterInstruction
+	lines          vm.LineTable // Source positions for instructions
+	farJumps       map[int]int  // Conditional jumps out of range, by position, with their targets
 
 	// Register scope stack
 	regScopes      []map[string]int
@@ -24,6 +27,10 @@ type RegisterCompiler struct {
 
 	// Loop context stack
 	loopStack      []LoopContext
+
+	// Main's register holding the value of the last top-level expression
+	// statement, -1 until there is one
+	resultReg int
 }
 
 // LiveRange tracks when a variable is live
@@ -34,8 +41,13 @@ type LiveRange struct {
 
 // NewRegisterCompiler creates a new register compiler
 func NewRegisterCompiler() *RegisterCompiler {
+	return newRegisterCompiler(New())
+}
+
+// newRegisterCompiler creates a register compiler around c
+func newRegisterCompiler(c *Compiler) *RegisterCompiler {
 	return &RegisterCompiler{
-		Compiler:      New(),
+		Compiler:      c,
 		registers:     make(map[string]int),
 		nextReg:       0,
 		MaxRegs:       0,
@@ -45,6 +57,7 @@ func NewRegisterCompiler() *RegisterCompiler {
 		regScopes:     []map[string]int{},
 		regScopeIndex: 0,
 		loopStack:     []LoopContext{},
+		resultReg:     -1,
 	}
 }
 
@@ -53,6 +66,7 @@ func (rc *RegisterCompiler) enterRegisterLoop() {
 	rc.loopStack = append(rc.loopStack, LoopContext{
 		breakJumps:    []int{},
 		continueJumps: []int{},
+		tries:         rc.tries,
 	})
 }
 
@@ -108,8 +122,72 @@ func (rc *RegisterCompiler) allocateTempRegister() int {
 	return reg
 }
 
+// reserveRegisters allocates n consecutive temporary registers and returns
+// the first. Freed temporaries are set aside so the run can't have gaps.
+func (rc *RegisterCompiler) reserveRegisters(n int) int {
+	savedTempRegs := rc.tempRegs
+	rc.tempRegs = nil
+	base := rc.nextReg
+	for range n {
+		rc.allocateTempRegister()
+	}
+	rc.tempRegs = savedTempRegs
+	return base
+}
+
+// freeRegisters frees n consecutive temporary registers starting at base
+func (rc *RegisterCompiler) freeRegisters(base, n int) {
+	for reg := base; reg < base+n; reg++ {
+		rc.freeTempRegister(reg)
+	}
+}
+
+// compileInto compiles expr and moves its value into reg
+func (rc *RegisterCompiler) compileInto(expr ast.Expression, reg int) error {
+	valueReg, err := rc.CompileToRegister(expr)
+	if err != nil {
+		return err
+	}
+	if valueReg != reg {
+		rc.emitR(vm.OpRMove, uint8(reg), uint8(valueReg), 0)
+		rc.freeTempRegister(valueReg)
+	}
+	return nil
+}
+
+// compileBranchInto compiles a branch of an if or switch expression of type
+// resultType, moving its value into reg
+func (rc *RegisterCompiler) compileBranchInto(block *ast.BlockStatement, resultType vm.ValueType, reg int) error {
+	last := len(block.Statements) - 1
+	for _, stmt := range rc.liveStatements(block.Statements[:last]) {
+		if _, err := rc.CompileToRegister(stmt); err != nil {
+			return err
+		}
+	}
+	return rc.compileInto(rc.branchValue(block, resultType), reg)
+}
+
+// loadConstant loads v into a temp register, with a constant only if it
+// has no opcode of its own
+func (rc *RegisterCompiler) loadConstant(v vm.Value) (int, error) {
+	tempReg := rc.allocateTempRegister()
+	if op, ok := vm.ImmediateLoad(v); ok {
+		rc.emitR(op, uint8(tempReg), 0, 0)
+		return tempReg, nil
+	}
+	return tempReg, rc.emitRK(vm.OpRLoadK, uint8(tempReg), rc.addConstant(v))
+}
+
 // freeTempRegister marks a temporary register as available
 func (rc *RegisterCompiler) freeTempRegister(reg int) {
+	// Variables keep their registers; freeing one would let a temporary
+	// overwrite the variable
+	for _, varReg := range rc.registers {
+		if varReg == reg {
+			return
+		}
+	}
+
 	// Check if already in pool to prevent double-free
 	for _, r := range rc.tempRegs {
 		if r == reg {
@@ -122,6 +200,7 @@ func (rc *RegisterCompiler) freeTempRegister(reg int) {
 // emitR emits a register instruction
 func (rc *RegisterCompiler) emitR(op vm.RegisterOpCode, a, b, c uint8) int {
 	ins := vm.EncodeRegisterInstruction(op, a, b, c)
+	rc.addLine()
 	rc.instructions = append(rc.instructions, ins)
 	return len(rc.instructions) - 1
 }
@@ -129,36 +208,191 @@ func (rc *RegisterCompiler) emitR(op vm.RegisterOpCode, a, b, c uint8) int {
 // emitRBx emits a register instruction with large immediate
 func (rc *RegisterCompiler) emitRBx(op vm.RegisterOpCode, a uint8, bx uint16) int {
 	ins := vm.EncodeRegisterInstructionBx(op, a, bx)
+	rc.addLine()
 	rc.instructions = append(rc.instructions, ins)
 	return len(rc.instructions) - 1
 }
 
-// RegisterBytecode returns the compiled register bytecode
+// emitRK emits op, which reads constant index into register a, followed by
+// an EXTRAARG when index is too large for Bx
+func (rc *RegisterCompiler) emitRK(op vm.RegisterOpCode, a uint8, index int) error {
+	k, err := vm.EncodeRegisterConstant(op, a, index)
+	if err != nil {
+		return err
+	}
+	for _, ins := range k {
+		rc.addLine()
+		rc.instructions = append(rc.instructions, ins)
+	}
+	return nil
+}
+
+// patchJump points the jump at pos, emitted with a zero offset, at target.
+// A conditional jump too far from target is left to finishJumps.
+func (rc *RegisterCompiler) patchJump(pos, target int) error {
+	op, a, _ := rc.instructions[pos].DecodeBx()
+	if vm.FarJump(op, pos, target) {
+		if rc.farJumps == nil {
+			rc.farJumps = make(map[int]int)
+		}
+		rc.farJumps[pos] = target
+		return nil
+	}
+	ins, err := vm.EncodeRegisterJump(op, a, pos, target)
+	if err != nil {
+		return err
+	}
+	rc.instructions[pos] = ins
+	return nil
+}
+
+// finishJumps makes the conditional jumps patchJump found too far from their
+// targets reach them, once the code holding them is complete
+func (rc *RegisterCompiler) finishJumps() error {
+	if len(rc.farJumps) == 0 {
+		return nil
+	}
+	code, lines, err := vm.LongJumps(rc.instructions, rc.lines, rc.farJumps)
+	if err != nil {
+		return err
+	}
+	rc.instructions, rc.lines, rc.farJumps = code, lines, nil
+	return nil
+}
+
+// compareJumps maps each comparison to its compare and branch opcodes, for
+// ints and floats, whether its operands are swapped, and whether it takes the
+// jump when the opcode's comparison is false rather than true
+var compareJumps = map[string]struct {
+	intOp, floatOp vm.RegisterOpCode
+	swap, negate   bool
+}{
+	"<":  {vm.OpRJumpLtInt, vm.OpRJumpLtFloat, false, false},
+	">":  {vm.OpRJumpLtInt, vm.OpRJumpLtFloat, true, false},
+	"<=": {vm.OpRJumpLeInt, vm.OpRJumpLeFloat, false, false},
+	">=": {vm.OpRJumpLeInt, vm.OpRJumpLeFloat, true, false},
+	"==": {vm.OpRJumpEqInt, vm.OpRJumpEqFloat, false, false},
+	"!=": {vm.OpRJumpEqInt, vm.OpRJumpEqFloat, false, true},
+}
+
+// compileJumpIf compiles cond and a jump, to be patched, taken when cond is
+// truthy or, with when false, falsy. It returns the jump's position. A
+// comparison of ints or floats branches on the comparison itself rather than
+// putting a bool in a register and testing it.
+func (rc *RegisterCompiler) compileJumpIf(cond ast.Expression, when bool) (int, error) {
+	if prefix, ok := cond.(*ast.PrefixExpression); ok && prefix.Operator == "!" {
+		return rc.compileJumpIf(prefix.Right, !when)
+	}
+
+	if infix, ok := cond.(*ast.InfixExpression); ok {
+		cmp, isCompare := compareJumps[infix.Operator]
+		op := cmp.intOp
+		switch rc.inferExpressionType(infix.Left) {
+		case vm.IntType:
+		case vm.FloatType:
+			op = cmp.floatOp
+		default:
+			isCompare = false
+		}
+		if rc.inferExpressionType(infix.Right) == vm.NilType {
+			isCompare = false
+		}
+
+		if isCompare {
+			rc.warnMaybeNil(infix)
+			leftReg, err := rc.CompileToRegister(infix.Left)
+			if err != nil {
+				return -1, err
+			}
+			rightReg, err := rc.CompileToRegister(infix.Right)
+			if err != nil {
+				return -1, err
+			}
+
+			a, b := leftReg, rightReg
+			if cmp.swap {
+				a, b = b, a
+			}
+			var taken uint8
+			if when != cmp.negate {
+				taken = 1
+			}
+			rc.emitR(op, uint8(a), uint8(b), taken)
+			rc.freeTempRegister(leftReg)
+			rc.freeTempRegister(rightReg)
+			return rc.emitRBx(vm.OpRJump, 0, 0), nil
+		}
+	}
+
+	condReg, err := rc.CompileToRegister(cond)
+	if err != nil {
+		return -1, err
+	}
+	op := vm.OpRJumpF
+	if when {
+		op = vm.OpRJumpT
+	}
+	pos := rc.emitRBx(op, uint8(condReg), 0)
+	rc.freeTempRegister(condReg)
+	return pos, nil
+}
+
+// addLine attributes the next instruction to the node being compiled
+func (rc *RegisterCompiler) addLine() {
+	if rc.pos.Line > 0 {
+		rc.lines = rc.lines.Add(len(rc.instructions), rc.pos)
+	}
+}
+
+// RegisterBytecode links the compiled program, compacts its globals and
+// returns its register bytecode
 func (rc *RegisterCompiler) RegisterBytecode() *vm.RegisterBytecode {
-	return &vm.RegisterBytecode{
+	return vm.CompactRegisterGlobals(&vm.RegisterBytecode{
 		Instructions: rc.instructions,
-		Constants:    rc.constants,
+		Constants:    linkConstants(rc.constants),
 		MainFunction: &vm.Function{
-			Name:         "main",
-			NumParams:    0,
-			NumLocals:    rc.MaxRegs,
-			Instructions: nil, // Register bytecode is stored separately
+			Name:          "main",
+			NumParams:     0,
+			NumLocals:     rc.MaxRegs,
+			Instructions:  nil, // Register bytecode is stored separately
+			RegisterLines: rc.lines,
 		},
-	}
+		Enums:     rc.runtimeEnums(),
+		ResultReg: rc.resultReg,
+	})
 }
 
 // CompileToRegister compiles an AST node to register bytecode
 // Returns the register number containing the result (or -1 for statements)
 func (rc *RegisterCompiler) CompileToRegister(node ast.Node) (int, error) {
+	if tok, ok := ast.NodeToken(node); ok && tok.Line > 0 {
+		saved := rc.pos
+		rc.pos = vm.Position{Line: tok.Line, Column: tok.Column}
+		defer func() { rc.pos = saved }()
+	}
+
+	if lit, ok := NegatedLiteral(node); ok && rc.int32Mode {
+		node = lit
+	}
+	if err := rc.checkIntLiteral(node); err != nil {
+		return -1, err
+	}
+	if rc.wrapsInt32(node) {
+		return rc.compileWrapped(node)
+	}
+	if rc.checksOverflow(node) {
+		return rc.compileChecked(node)
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
-		for _, s := range node.Statements {
-			_, err := rc.CompileToRegister(s)
-			if err != nil {
-				return -1, err
-			}
+		if err := rc.typeCheck(node); err != nil {
+			return -1, err
 		}
-		return -1, nil
+		if err := rc.compileProgram(rc.liveStatements(node.Statements)); err != nil {
+			return -1, err
+		}
+		return -1, rc.finishJumps()
 
 	case *ast.ExpressionStatement:
 		// Compile expression and discard result
@@ -166,6 +400,21 @@ func (rc *RegisterCompiler) CompileToRegister(node ast.Node) (int, error) {
 		if err != nil {
 			return -1, err
 		}
+
+		// Outside functions, keep the value as the program's result
+		if rc.scopeIndex == 0 {
+			if rc.resultReg < 0 {
+				// Never freed, and not a freed temporary, which code already
+				// compiled in an enclosing loop may still use
+				rc.resultReg = rc.reserveRegisters(1)
+			}
+			if resultReg < 0 {
+				rc.emitR(vm.OpRLoadNil, uint8(rc.resultReg), 0, 0)
+			} else if resultReg != rc.resultReg {
+				rc.emitR(vm.OpRMove, uint8(rc.resultReg), uint8(resultReg), 0)
+			}
+		}
+
 		// Free the result register if it's a temp
 		if resultReg >= 0 {
 			rc.freeTempRegister(resultReg)
@@ -173,35 +422,19 @@ func (rc *RegisterCompiler) CompileToRegister(node ast.Node) (int, error) {
 		return -1, nil
 
 	case *ast.IntegerLiteral:
-		// Load constant into temp register
-		constIndex := rc.addConstant(vm.IntValue(node.Value))
-		tempReg := rc.allocateTempRegister()
-		rc.emitRBx(vm.OpRLoadK, uint8(tempReg), uint16(constIndex))
-		return tempReg, nil
+		return rc.loadConstant(vm.IntValue(node.Value))
 
 	case *ast.FloatLiteral:
-		constIndex := rc.addConstant(vm.FloatValue(node.Value))
-		tempReg := rc.allocateTempRegister()
-		rc.emitRBx(vm.OpRLoadK, uint8(tempReg), uint16(constIndex))
-		return tempReg, nil
+		return rc.loadConstant(vm.FloatValue(node.Value))
 
 	case *ast.BooleanLiteral:
-		constIndex := rc.addConstant(vm.BoolValue(node.Value))
-		tempReg := rc.allocateTempRegister()
-		rc.emitRBx(vm.OpRLoadK, uint8(tempReg), uint16(constIndex))
-		return tempReg, nil
+		return rc.loadConstant(vm.BoolValue(node.Value))
 
 	case *ast.StringLiteral:
-		constIndex := rc.addConstant(vm.StringValue(node.Value))
-		tempReg := rc.allocateTempRegister()
-		rc.emitRBx(vm.OpRLoadK, uint8(tempReg), uint16(constIndex))
-		return tempReg, nil
+		return rc.loadConstant(vm.StringValue(node.Value))
 
 	case *ast.NilLiteral:
-		constIndex := rc.addConstant(vm.NilValue())
-		tempReg := rc.allocateTempRegister()
-		rc.emitRBx(vm.OpRLoadK, uint8(tempReg), uint16(constIndex))
-		return tempReg, nil
+		return rc.loadConstant(vm.NilValue())
 
 	case *ast.Identifier:
 		// Check symbol table first (for builtins and scope tracking)
@@ -210,13 +443,17 @@ func (rc *RegisterCompiler) CompileToRegister(node ast.Node) (int, error) {
 			return -1, fmt.Errorf("undefined variable: %s", node.Value)
 		}
 
-		// For builtins, we'll handle them in CallExpression
-		// Just return a marker value for now
+		// Builtins used as values, rather than called by name
 		if symbol.Scope == BuiltinScope {
-			// We can't really "load" a builtin - it's handled at call time
-			// Return negative value encoding the builtin index
-			// This is a bit of a hack, but it works
-			return -(symbol.Index + 100), nil
+			tempReg := rc.allocateTempRegister()
+			rc.emitRBx(vm.OpRLoadBuiltin, uint8(tempReg), uint16(symbol.Index))
+			return tempReg, nil
+		}
+
+		// A const's value is loaded as a literal's is; a local one already
+		// has it in its register
+		if symbol.Value != nil && symbol.Scope != LocalScope {
+			return rc.loadConstant(*symbol.Value)
 		}
 
 		// Check if it's a global variable
@@ -227,6 +464,13 @@ func (rc *RegisterCompiler) CompileToRegister(node ast.Node) (int, error) {
 			return tempReg, nil
 		}
 
+		// Variable captured from an enclosing function - load from the closure
+		if symbol.Scope == FreeScope {
+			tempReg := rc.allocateTempRegister()
+			rc.emitRBx(vm.OpRLoadFree, uint8(tempReg), uint16(symbol.Index))
+			return tempReg, nil
+		}
+
 		// Local variable reference - should be in a register
 		if reg, exists := rc.registers[node.Value]; exists {
 			return reg, nil
@@ -236,6 +480,10 @@ func (rc *RegisterCompiler) CompileToRegister(node ast.Node) (int, error) {
 	case *ast.VarStatement:
 		// Define in symbol table
 		symbol := rc.symbolTable.DefineWithMutability(node.Name.Value, node.IsMutable)
+		constant, isConst, err := rc.constValue(node)
+		if err != nil {
+			return -1, err
+		}
 
 		// Track variable type
 		if node.Type != nil {
@@ -245,12 +493,13 @@ func (rc *RegisterCompiler) CompileToRegister(node ast.Node) (int, error) {
 			rc.varTypes[node.Name.Value] = rc.inferExpressionType(node.Value)
 			rc.typeInfo[node.Name.Value] = rc.inferDetailedType(node.Value)
 		}
+		rc.trackAlias(node.Name.Value, node.Value)
 
 		// Check if this is a global or local variable
 		if symbol.Scope == GlobalScope {
 			// Global variable - use OpRStoreGlobal
 			if node.Value != nil {
-				valueReg, err := rc.CompileToRegister(node.Value)
+				valueReg, err := rc.compileInitializer(node.Value, constant, isConst)
 				if err != nil {
 					return -1, err
 				}
@@ -263,7 +512,7 @@ func (rc *RegisterCompiler) CompileToRegister(node ast.Node) (int, error) {
 
 			// Compile initializer value if present
 			if node.Value != nil {
-				valueReg, err := rc.CompileToRegister(node.Value)
+				valueReg, err := rc.compileInitializer(node.Value, constant, isConst)
 				if err != nil {
 					return -1, err
 				}
@@ -275,22 +524,36 @@ func (rc *RegisterCompiler) CompileToRegister(node ast.Node) (int, error) {
 			}
 		}
 
+		if isConst {
+			rc.symbolTable.SetValue(node.Name.Value, constant)
+		}
+
 		return -1, nil
 
 	case *ast.AssignmentStatement:
+		if err := rc.checkIncrement(node); err != nil {
+			return -1, err
+		}
 		switch left := node.Left.(type) {
 		case *ast.Identifier:
-			// Variable assignment
-			valueReg, err := rc.CompileToRegister(node.Value)
-			if err != nil {
-				return -1, err
-			}
-
 			// Check if this is a global variable
 			symbol, ok := rc.symbolTable.Resolve(left.Value)
 			if !ok {
 				return -1, fmt.Errorf("undefined variable: %s", left.Value)
 			}
+			if !symbol.IsMutable {
+				return -1, fmt.Errorf("cannot assign to const variable %s", left.Value)
+			}
+			if rc.compileIncrement(left, symbol, node.Value) {
+				break
+			}
+
+			// Variable assignment
+			valueReg, err := rc.CompileToRegister(node.Value)
+			if err != nil {
+				return -1, err
+			}
+			rc.trackAlias(left.Value, node.Value)
 
 			if symbol.Scope == GlobalScope {
 				// Global variable assignment
@@ -312,6 +575,7 @@ func (rc *RegisterCompiler) CompileToRegister(node ast.Node) (int, error) {
 
 		case *ast.IndexExpression:
 			// Array/map assignment: arr[i] = value
+			rc.warnAliasChange(node, left.Left)
 			containerReg, err := rc.CompileToRegister(left.Left)
 			if err != nil {
 				return -1, err
@@ -327,36 +591,36 @@ func (rc *RegisterCompiler) CompileToRegister(node ast.Node) (int, error) {
 				return -1, err
 			}
 
-			rc.emitR(vm.OpRSetIdx, uint8(containerReg), uint8(indexReg), uint8(valueReg))
+			// Maps known at compile time skip the container type dispatch
+			if rc.inferExpressionType(left.Left) == vm.MapType {
+				rc.emitR(vm.OpRMapSet, uint8(containerReg), uint8(indexReg), uint8(valueReg))
+			} else {
+				rc.emitR(vm.OpRSetIdx, uint8(containerReg), uint8(indexReg), uint8(valueReg))
+			}
 
 			rc.freeTempRegister(containerReg)
 			rc.freeTempRegister(indexReg)
 			rc.freeTempRegister(valueReg)
 
+		case *ast.TupleElementExpression:
+			return -1, tupleAssignmentError(left)
+
 		case *ast.FieldAccessExpression:
-			// Struct field assignment: obj.field = value
-			objReg, err := rc.CompileToRegister(left.Left)
-			if err != nil {
-				return -1, err
-			}
+			return -1, fmt.Errorf("register compilation not yet implemented for struct fields")
+		}
+		return -1, nil
 
-			valueReg, err := rc.CompileToRegister(node.Value)
-			if err != nil {
+	case *ast.InfixExpression:
+		rc.warnMaybeNil(node)
+		if isLogical(node.Operator) {
+			return rc.compileLogical(node)
+		}
+		if node.Operator == "in" {
+			if err := rc.checkIn(node); err != nil {
 				return -1, err
 			}
-
-			// Get field name constant
-			fieldIdx := rc.addConstant(vm.StringValue(left.Field.Value))
-
-			rc.emitRBx(vm.OpRSetField, uint8(objReg), uint16(fieldIdx))
-			rc.emitR(vm.OpRMove, uint8(objReg), uint8(valueReg), 0)
-
-			rc.freeTempRegister(objReg)
-			rc.freeTempRegister(valueReg)
 		}
-		return -1, nil
 
-	case *ast.InfixExpression:
 		// Compile left and right operands
 		leftReg, err := rc.CompileToRegister(node.Left)
 		if err != nil {
@@ -376,7 +640,12 @@ func (rc *RegisterCompiler) CompileToRegister(node ast.Node) (int, error) {
 
 		switch node.Operator {
 		case "+":
-			if leftType == vm.IntType && rightType == vm.IntType {
+			if leftType == vm.StringType && rightType == vm.StringType {
+				rc.emitR(vm.OpRConcat, uint8(resultReg), uint8(leftReg), uint8(rightReg))
+			} else if leftType == vm.StringType || rightType == vm.StringType {
+				// The other operand is converted to a string at runtime, as on the stack VM
+				rc.emitR(vm.OpRAdd, uint8(resultReg), uint8(leftReg), uint8(rightReg))
+			} else if leftType == vm.IntType && rightType == vm.IntType {
 				rc.emitR(vm.OpRAddInt, uint8(resultReg), uint8(leftReg), uint8(rightReg))
 			} else {
 				rc.emitR(vm.OpRAddFloat, uint8(resultReg), uint8(leftReg), uint8(rightReg))
@@ -406,7 +675,9 @@ func (rc *RegisterCompiler) CompileToRegister(node ast.Node) (int, error) {
 				}
 			}
 		case "/":
-			if leftType == vm.IntType && rightType == vm.IntType {
+			if rc.promoteIntDiv && !(leftType == vm.FloatType && rightType == vm.FloatType) {
+				rc.emitR(vm.OpRDivPromote, uint8(resultReg), uint8(leftReg), uint8(rightReg))
+			} else if leftType == vm.IntType && rightType == vm.IntType {
 				rc.emitR(vm.OpRDivInt, uint8(resultReg), uint8(leftReg), uint8(rightReg))
 			} else {
 				rc.emitR(vm.OpRDivFloat, uint8(resultReg), uint8(leftReg), uint8(rightReg))
@@ -416,82 +687,68 @@ func (rc *RegisterCompiler) CompileToRegister(node ast.Node) (int, error) {
 
 		// Comparisons
 		case "==":
-			if leftType == vm.IntType {
+			if leftType == vm.NilType || rightType == vm.NilType {
+				rc.emitR(vm.OpREq, uint8(resultReg), uint8(leftReg), uint8(rightReg))
+			} else if leftType == vm.IntType && rightType != vm.FloatType {
 				rc.emitR(vm.OpREqInt, uint8(resultReg), uint8(leftReg), uint8(rightReg))
 			} else if leftType == vm.FloatType {
 				rc.emitR(vm.OpREqFloat, uint8(resultReg), uint8(leftReg), uint8(rightReg))
 			} else if leftType == vm.StringType {
 				rc.emitR(vm.OpREqString, uint8(resultReg), uint8(leftReg), uint8(rightReg))
-			} else {
+			} else if leftType == vm.BoolType {
 				rc.emitR(vm.OpREqBool, uint8(resultReg), uint8(leftReg), uint8(rightReg))
+			} else {
+				rc.emitR(vm.OpREq, uint8(resultReg), uint8(leftReg), uint8(rightReg))
 			}
 		case "!=":
-			if leftType == vm.IntType {
+			if leftType == vm.NilType || rightType == vm.NilType {
+				rc.emitR(vm.OpRNe, uint8(resultReg), uint8(leftReg), uint8(rightReg))
+			} else if leftType == vm.IntType && rightType != vm.FloatType {
 				rc.emitR(vm.OpRNeInt, uint8(resultReg), uint8(leftReg), uint8(rightReg))
 			} else if leftType == vm.FloatType {
 				rc.emitR(vm.OpRNeFloat, uint8(resultReg), uint8(leftReg), uint8(rightReg))
 			} else if leftType == vm.StringType {
 				rc.emitR(vm.OpRNeString, uint8(resultReg), uint8(leftReg), uint8(rightReg))
-			} else {
+			} else if leftType == vm.BoolType {
 				rc.emitR(vm.OpRNeBool, uint8(resultReg), uint8(leftReg), uint8(rightReg))
+			} else {
+				rc.emitR(vm.OpRNe, uint8(resultReg), uint8(leftReg), uint8(rightReg))
 			}
 		case "<":
-			if leftType == vm.IntType {
+			if leftType == vm.IntType && rightType != vm.FloatType {
 				rc.emitR(vm.OpRLtInt, uint8(resultReg), uint8(leftReg), uint8(rightReg))
 			} else {
 				rc.emitR(vm.OpRLtFloat, uint8(resultReg), uint8(leftReg), uint8(rightReg))
 			}
 		case ">":
-			if leftType == vm.IntType {
+			if leftType == vm.IntType && rightType != vm.FloatType {
 				rc.emitR(vm.OpRGtInt, uint8(resultReg), uint8(leftReg), uint8(rightReg))
 			} else {
 				rc.emitR(vm.OpRGtFloat, uint8(resultReg), uint8(leftReg), uint8(rightReg))
 			}
 		case "<=":
-			if leftType == vm.IntType {
+			if leftType == vm.IntType && rightType != vm.FloatType {
 				rc.emitR(vm.OpRLeInt, uint8(resultReg), uint8(leftReg), uint8(rightReg))
 			} else {
 				rc.emitR(vm.OpRLeFloat, uint8(resultReg), uint8(leftReg), uint8(rightReg))
 			}
 		case ">=":
-			if leftType == vm.IntType {
+			if leftType == vm.IntType && rightType != vm.FloatType {
 				rc.emitR(vm.OpRGeInt, uint8(resultReg), uint8(leftReg), uint8(rightReg))
 			} else {
 				rc.emitR(vm.OpRGeFloat, uint8(resultReg), uint8(leftReg), uint8(rightReg))
 			}
 
-		// Logical
-		case "&&":
-			rc.emitR(vm.OpRAnd, uint8(resultReg), uint8(leftReg), uint8(rightReg))
-		case "||":
-			rc.emitR(vm.OpROr, uint8(resultReg), uint8(leftReg), uint8(rightReg))
+		case "in":
+			rc.emitR(vm.OpRIn, uint8(resultReg), uint8(leftReg), uint8(rightReg))
 
 		default:
 			return -1, fmt.Errorf("unknown operator: %s", node.Operator)
 		}
 
-		// Free input registers only if they're temps (not permanent variable registers)
-		isLeftPermanent := false
-		for _, permReg := range rc.registers {
-			if permReg == leftReg {
-				isLeftPermanent = true
-				break
-			}
-		}
-		if !isLeftPermanent {
-			rc.freeTempRegister(leftReg)
-		}
-
-		isRightPermanent := false
-		for _, permReg := range rc.registers {
-			if permReg == rightReg {
-				isRightPermanent = true
-				break
-			}
-		}
-		if !isRightPermanent {
-			rc.freeTempRegister(rightReg)
-		}
+		// Free input registers (variable registers are left alone)
+		rc.freeTempRegister(leftReg)
+		rc.freeTempRegister(rightReg)
 
 		return resultReg, nil
 
@@ -529,16 +786,12 @@ func (rc *RegisterCompiler) CompileToRegister(node ast.Node) (int, error) {
 		return resultReg, nil
 
 	case *ast.IfStatement:
-		// Compile condition
-		condReg, err := rc.CompileToRegister(node.Condition)
+		// Compile condition and a jump if false (placeholder)
+		jumpIfFalse, err := rc.compileJumpIf(node.Condition, false)
 		if err != nil {
 			return -1, err
 		}
 
-		// Jump if false (placeholder)
-		jumpIfFalse := rc.emitRBx(vm.OpRJumpF, uint8(condReg), 9999)
-		rc.freeTempRegister(condReg)
-
 		// Compile consequence
 		_, err = rc.CompileToRegister(node.Consequence)
 		if err != nil {
@@ -546,12 +799,12 @@ func (rc *RegisterCompiler) CompileToRegister(node ast.Node) (int, error) {
 		}
 
 		// Jump over alternative
-		jumpOverAlt := rc.emitRBx(vm.OpRJump, 0, 9999)
+		jumpOverAlt := rc.emitRBx(vm.OpRJump, 0, 0)
 
 		// Patch first jump
-		afterConsequence := len(rc.instructions)
-		rc.instructions[jumpIfFalse] = vm.EncodeRegisterInstructionBx(
-			vm.OpRJumpF, uint8(condReg), uint16(afterConsequence))
+		if err := rc.patchJump(jumpIfFalse, len(rc.instructions)); err != nil {
+			return -1, err
+		}
 
 		// Compile alternative if present
 		if node.Alternative != nil {
@@ -562,12 +815,65 @@ func (rc *RegisterCompiler) CompileToRegister(node ast.Node) (int, error) {
 		}
 
 		// Patch second jump
-		afterAlternative := len(rc.instructions)
-		rc.instructions[jumpOverAlt] = vm.EncodeRegisterInstructionBx(
-			vm.OpRJump, 0, uint16(afterAlternative))
+		if err := rc.patchJump(jumpOverAlt, len(rc.instructions)); err != nil {
+			return -1, err
+		}
 
 		return -1, nil
 
+	case *ast.IfExpression:
+		jumpIfFalse, err := rc.compileJumpIf(node.Condition, false)
+		if err != nil {
+			return -1, err
+		}
+
+		// Each branch leaves its value in the result register
+		resultReg := rc.allocateTempRegister()
+		resultType := rc.inferBranchValueType(node.IfStatement)
+		if err := rc.compileBranchInto(node.Consequence, resultType, resultReg); err != nil {
+			return -1, err
+		}
+
+		jumpOverAlt := rc.emitRBx(vm.OpRJump, 0, 0)
+		if err := rc.patchJump(jumpIfFalse, len(rc.instructions)); err != nil {
+			return -1, err
+		}
+
+		if err := rc.compileBranchInto(node.Alternative.(*ast.BlockStatement), resultType, resultReg); err != nil {
+			return -1, err
+		}
+		if err := rc.patchJump(jumpOverAlt, len(rc.instructions)); err != nil {
+			return -1, err
+		}
+
+		return resultReg, nil
+
+	case *ast.LookupStatement:
+		stmts, err := rc.lowerLookup(node)
+		if err != nil {
+			return -1, err
+		}
+		rc.inLookup = true
+		for _, stmt := range stmts {
+			if _, err := rc.CompileToRegister(stmt); err != nil {
+				return -1, err
+			}
+		}
+		rc.inLookup = false
+		return -1, nil
+
+	case *ast.ForInStatement:
+		stmts, err := rc.lowerForIn(node)
+		if err != nil {
+			return -1, err
+		}
+		for _, stmt := range stmts {
+			if _, err := rc.CompileToRegister(stmt); err != nil {
+				return -1, err
+			}
+		}
+		return -1, nil
+
 	case *ast.ForStatement:
 		// Enter loop context for break/continue
 		rc.enterRegisterLoop()
@@ -584,16 +890,12 @@ func (rc *RegisterCompiler) CompileToRegister(node ast.Node) (int, error) {
 		// Loop start
 		loopStart := len(rc.instructions)
 
-		// Compile condition
-		condReg, err := rc.CompileToRegister(node.Condition)
+		// Compile condition and a jump if false (placeholder)
+		jumpToEnd, err := rc.compileJumpIf(node.Condition, false)
 		if err != nil {
 			return -1, err
 		}
 
-		// Jump if false (placeholder)
-		jumpToEnd := rc.emitRBx(vm.OpRJumpF, uint8(condReg), 9999)
-		rc.freeTempRegister(condReg)
-
 		// Compile body
 		_, err = rc.CompileToRegister(node.Body)
 		if err != nil {
@@ -612,32 +914,45 @@ func (rc *RegisterCompiler) CompileToRegister(node ast.Node) (int, error) {
 		}
 
 		// Jump back to start
-		rc.emitRBx(vm.OpRJump, 0, uint16(loopStart))
+		jumpBack := rc.emitRBx(vm.OpRJump, 0, 0)
+		if err := rc.patchJump(jumpBack, loopStart); err != nil {
+			return -1, err
+		}
 
 		// Patch jump to end
 		loopEnd := len(rc.instructions)
-		rc.instructions[jumpToEnd] = vm.EncodeRegisterInstructionBx(vm.OpRJumpF, uint8(condReg), uint16(loopEnd))
+		if err := rc.patchJump(jumpToEnd, loopEnd); err != nil {
+			return -1, err
+		}
 
 		// Patch all break jumps to jump to loopEnd
 		loop := rc.currentRegisterLoop()
 		for _, breakPos := range loop.breakJumps {
-			rc.instructions[breakPos] = vm.EncodeRegisterInstructionBx(vm.OpRJump, 0, uint16(loopEnd))
+			if err := rc.patchJump(breakPos, loopEnd); err != nil {
+				return -1, err
+			}
 		}
 
 		// Patch all continue jumps to jump to continuePos
 		for _, contPos := range loop.continueJumps {
-			rc.instructions[contPos] = vm.EncodeRegisterInstructionBx(vm.OpRJump, 0, uint16(continuePos))
+			if err := rc.patchJump(contPos, continuePos); err != nil {
+				return -1, err
+			}
 		}
 
 		return -1, nil
 
+	case *ast.TryStatement:
+		return -1, rc.compileTry(node)
+
 	case *ast.BreakStatement:
 		loop := rc.currentRegisterLoop()
 		if loop == nil {
 			return -1, fmt.Errorf("break statement outside of loop")
 		}
-		// Emit a jump with placeholder address
-		pos := rc.emitRBx(vm.OpRJump, 0, 9999)
+		rc.emitEndTries(loop.tries)
+		// Emit a jump to be patched at the end of the loop
+		pos := rc.emitRBx(vm.OpRJump, 0, 0)
 		// Record this position so we can patch it later
 		loop.breakJumps = append(loop.breakJumps, pos)
 		return -1, nil
@@ -647,14 +962,15 @@ func (rc *RegisterCompiler) CompileToRegister(node ast.Node) (int, error) {
 		if loop == nil {
 			return -1, fmt.Errorf("continue statement outside of loop")
 		}
-		// Emit a jump with placeholder address
-		pos := rc.emitRBx(vm.OpRJump, 0, 9999)
+		rc.emitEndTries(loop.tries)
+		// Emit a jump to be patched at the end of the loop
+		pos := rc.emitRBx(vm.OpRJump, 0, 0)
 		// Record this position so we can patch it later
 		loop.continueJumps = append(loop.continueJumps, pos)
 		return -1, nil
 
 	case *ast.BlockStatement:
-		for _, stmt := range node.Statements {
+		for _, stmt := range rc.liveStatements(node.Statements) {
 			_, err := rc.CompileToRegister(stmt)
 			if err != nil {
 				return -1, err
@@ -668,141 +984,80 @@ func (rc *RegisterCompiler) CompileToRegister(node ast.Node) (int, error) {
 			if err != nil {
 				return -1, err
 			}
+			if err := rc.emitReturnCheck(valueReg); err != nil {
+				return -1, err
+			}
+			rc.emitEndTries(0)
 			// Return value in register
 			rc.emitR(vm.OpRReturn, uint8(valueReg), 0, 0)
 			rc.freeTempRegister(valueReg)
 		} else {
+			rc.emitEndTries(0)
 			rc.emitR(vm.OpRReturnN, 0, 0, 0)
 		}
 		return -1, nil
 
 	case *ast.CallExpression:
-		// Check if this is a builtin call
-		isBuiltin := false
-		builtinIndex := 0
+		numArgs := len(node.Arguments)
+		rc.warnAliasCall(node)
+
+		// Numeric builtins with opcodes of their own
 		if ident, ok := node.Function.(*ast.Identifier); ok {
 			if symbol, ok := rc.symbolTable.Resolve(ident.Value); ok && symbol.Scope == BuiltinScope {
-				isBuiltin = true
-				builtinIndex = symbol.Index
+				if op, ok := rc.intrinsicRegisterOp(ident.Value, node.Arguments); ok {
+					return rc.compileIntrinsic(op, node.Arguments)
+				}
 			}
 		}
 
-		if isBuiltin {
-			// Allocate consecutive registers for arguments
-			numArgs := len(node.Arguments)
-
-			// IMPORTANT: Clear temp pool to ensure we get consecutive registers
-			// Save and restore it after allocation
-			savedTempRegs := rc.tempRegs
-			rc.tempRegs = []int{}
-
-			// Reserve consecutive registers for arguments (will be consecutive now)
-			argRegs := make([]int, numArgs)
-			argBaseReg := rc.nextReg // Save base before allocation
-			for i := 0; i < numArgs; i++ {
-				argRegs[i] = rc.allocateTempRegister()
-			}
-
-			// Restore temp pool
-			rc.tempRegs = savedTempRegs
-
-			// Compile each argument and move to its designated register
-			for i, arg := range node.Arguments {
-				argReg, err := rc.CompileToRegister(arg)
-				if err != nil {
-					return -1, err
-				}
-				// Move to designated consecutive register if different
-				if argReg != argRegs[i] {
-					rc.emitR(vm.OpRMove, uint8(argRegs[i]), uint8(argReg), 0)
-					// Only free if it's not a permanent variable register
-					isPermanent := false
-					for _, permReg := range rc.registers {
-						if permReg == argReg {
-							isPermanent = true
-							break
-						}
-					}
-					if !isPermanent {
-						rc.freeTempRegister(argReg)
+		// Builtins called by name use OpRBuiltin, which packs the builtin
+		// index and argument count into 4 bits each
+		if ident, ok := node.Function.(*ast.Identifier); ok {
+			if symbol, ok := rc.symbolTable.Resolve(ident.Value); ok && symbol.Scope == BuiltinScope &&
+				symbol.Index <= 15 && numArgs <= 15 {
+				argBaseReg := rc.reserveRegisters(numArgs)
+				for i, arg := range node.Arguments {
+					if err := rc.compileInto(arg, argBaseReg+i); err != nil {
+						return -1, err
 					}
 				}
-			}
-
-			// Allocate result register
-			resultReg := rc.allocateTempRegister()
 
-			// Emit builtin call instruction
-			// B field: low 4 bits = builtinIndex, high 4 bits = numArgs
-			// C field: argBaseReg
-			rc.emitR(vm.OpRBuiltin, uint8(resultReg), uint8(builtinIndex)|(uint8(numArgs)<<4), uint8(argBaseReg))
-
-			// Don't free argument registers - they're temps that will be reused anyway
-			// Freeing them seems to cause issues with register allocation
-
-			return resultReg, nil
+				resultReg := rc.allocateTempRegister()
+				rc.emitR(vm.OpRBuiltin, uint8(resultReg), uint8(symbol.Index)|(uint8(numArgs)<<4), uint8(argBaseReg))
+				rc.freeRegisters(argBaseReg, numArgs)
+				return resultReg, nil
+			}
 		}
 
-		// Regular function call
-		// Compile function expression to get function register
-		fnReg, err := rc.CompileToRegister(node.Function)
-		if err != nil {
-			return -1, err
+		// Everything else is invoked as a value: the callee goes in R(A) and
+		// its arguments in R(A+1)...R(A+n), and the result replaces the callee.
+		// Functions and closures copy the arguments into a register window of
+		// their own, so recursion never overwrites a caller's registers;
+		// builtins take them in place, however many there are.
+		if numArgs > 255 {
+			return -1, fmt.Errorf("too many arguments in call to %s: %d", node.Function.String(), numArgs)
 		}
-
-		// Allocate consecutive registers for arguments (same as builtins)
-		numArgs := len(node.Arguments)
-
-		// IMPORTANT: Clear temp pool to ensure we get consecutive registers
-		savedTempRegs := rc.tempRegs
-		rc.tempRegs = []int{}
-
-		// Reserve consecutive registers for arguments
-		argRegs := make([]int, numArgs)
-		argBaseReg := rc.nextReg // Save base before allocation
-		for i := 0; i < numArgs; i++ {
-			argRegs[i] = rc.allocateTempRegister()
+		calleeReg := rc.reserveRegisters(numArgs + 1)
+		if err := rc.compileInto(node.Function, calleeReg); err != nil {
+			return -1, err
 		}
-
-		// Restore temp pool
-		rc.tempRegs = savedTempRegs
-
-		// Compile each argument and move to its designated register
 		for i, arg := range node.Arguments {
-			argReg, err := rc.CompileToRegister(arg)
-			if err != nil {
+			if err := rc.compileInto(arg, calleeReg+1+i); err != nil {
 				return -1, err
 			}
-			// Move to designated consecutive register if different
-			if argReg != argRegs[i] {
-				rc.emitR(vm.OpRMove, uint8(argRegs[i]), uint8(argReg), 0)
-				// Only free if it's not a permanent variable register
-				isPermanent := false
-				for _, permReg := range rc.registers {
-					if permReg == argReg {
-						isPermanent = true
-						break
-					}
-				}
-				if !isPermanent {
-					rc.freeTempRegister(argReg)
-				}
-			}
 		}
 
-		// Allocate result register
-		resultReg := rc.allocateTempRegister()
-
-		// Emit call instruction
-		// OpRCall: R(A) = call R(B)(args starting at R(C))
-		rc.emitR(vm.OpRCall, uint8(resultReg), uint8(fnReg), uint8(argBaseReg))
-
-		// Free function register
-		rc.freeTempRegister(fnReg)
-
-		return resultReg, nil
+		rc.emitR(vm.OpRInvoke, uint8(calleeReg), uint8(numArgs), 0)
+		rc.freeRegisters(calleeReg+1, numArgs)
+		return calleeReg, nil
 
 	case *ast.ArrayLiteral:
+		// Literal-only arrays are hoisted into the constant pool
+		if constant, ok := rc.constantCollection(node); ok {
+			arrayReg := rc.allocateTempRegister()
+			return arrayReg, rc.emitRK(vm.OpRLoadKCopy, uint8(arrayReg), rc.addCollectionConstant(constant))
+		}
+
 		// Create array
 		arrayReg := rc.allocateTempRegister()
 		rc.emitRBx(vm.OpRNewArray, uint8(arrayReg), uint16(len(node.Elements)))
@@ -815,9 +1070,10 @@ func (rc *RegisterCompiler) CompileToRegister(node ast.Node) (int, error) {
 			}
 
 			// Store element at index i
-			idxReg := rc.allocateTempRegister()
-			constIdx := rc.addConstant(vm.IntValue(int64(i)))
-			rc.emitRBx(vm.OpRLoadK, uint8(idxReg), uint16(constIdx))
+			idxReg, err := rc.loadConstant(vm.IntValue(int64(i)))
+			if err != nil {
+				return -1, err
+			}
 
 			rc.emitR(vm.OpRSetIdx, uint8(arrayReg), uint8(idxReg), uint8(elemReg))
 
@@ -840,31 +1096,54 @@ func (rc *RegisterCompiler) CompileToRegister(node ast.Node) (int, error) {
 		}
 
 		resultReg := rc.allocateTempRegister()
-		rc.emitR(vm.OpRGetIdx, uint8(resultReg), uint8(containerReg), uint8(indexReg))
+		// Maps known at compile time skip the container type dispatch
+		if rc.inferExpressionType(node.Left) == vm.MapType {
+			rc.emitR(vm.OpRMapGet, uint8(resultReg), uint8(containerReg), uint8(indexReg))
+		} else {
+			rc.emitR(vm.OpRGetIdx, uint8(resultReg), uint8(containerReg), uint8(indexReg))
+		}
 
 		rc.freeTempRegister(containerReg)
 		rc.freeTempRegister(indexReg)
 
 		return resultReg, nil
 
+	case *ast.SliceExpression:
+		return rc.compileSlice(node)
+
+	case *ast.CastExpression:
+		return rc.compileCast(node)
+
+	case *ast.TupleLiteral:
+		return rc.compileTupleLiteral(node)
+
+	case *ast.TupleElementExpression:
+		return rc.compileTupleElement(node)
+
 	case *ast.MapLiteral:
+		// Literal-only maps are hoisted into the constant pool
+		if constant, ok := rc.constantCollection(node); ok {
+			mapReg := rc.allocateTempRegister()
+			return mapReg, rc.emitRK(vm.OpRLoadKCopy, uint8(mapReg), rc.addCollectionConstant(constant))
+		}
+
 		// Create map
 		mapReg := rc.allocateTempRegister()
 		rc.emitR(vm.OpRNewMap, uint8(mapReg), 0, 0)
 
-		// Compile and store key-value pairs
-		for key, value := range node.Pairs {
+		// Compile and store key-value pairs, in the order written
+		for _, key := range node.Keys {
 			keyReg, err := rc.CompileToRegister(key)
 			if err != nil {
 				return -1, err
 			}
 
-			valueReg, err := rc.CompileToRegister(value)
+			valueReg, err := rc.CompileToRegister(node.Pairs[key])
 			if err != nil {
 				return -1, err
 			}
 
-			rc.emitR(vm.OpRSetIdx, uint8(mapReg), uint8(keyReg), uint8(valueReg))
+			rc.emitR(vm.OpRMapSet, uint8(mapReg), uint8(keyReg), uint8(valueReg))
 
 			rc.freeTempRegister(keyReg)
 			rc.freeTempRegister(valueReg)
@@ -873,163 +1152,259 @@ func (rc *RegisterCompiler) CompileToRegister(node ast.Node) (int, error) {
 		return mapReg, nil
 
 	case *ast.StructLiteral:
-		// Create struct instance
-		structReg := rc.allocateTempRegister()
-
-		// Get struct type name constant
-		typeIdx := rc.addConstant(vm.StringValue(node.Name.Value))
-		rc.emitRBx(vm.OpRNewStruct, uint8(structReg), uint16(typeIdx))
+		// Struct types are declared by type statements, which only the stack
+		// compiler handles, so programs with structs are translated from its
+		// bytecode instead
+		return -1, fmt.Errorf("register compilation not yet implemented for struct literals")
 
-		// Set field values
-		for fieldName, fieldValue := range node.Fields {
-			valueReg, err := rc.CompileToRegister(fieldValue)
-			if err != nil {
-				return -1, err
-			}
-
-			// Get field name constant
-			fieldIdx := rc.addConstant(vm.StringValue(fieldName))
-			rc.emitRBx(vm.OpRSetField, uint8(structReg), uint16(fieldIdx))
-			rc.emitR(vm.OpRMove, uint8(structReg), uint8(valueReg), 0)
+	case *ast.FieldAccessExpression:
+		return -1, fmt.Errorf("register compilation not yet implemented for struct fields")
 
-			rc.freeTempRegister(valueReg)
+	case *ast.FunctionStatement:
+		if len(node.TypeParams) > 0 {
+			return -1, errGenericFunction(node)
 		}
+		funcType := rc.declareFunction(node)
 
-		return structReg, nil
+		// Define the function name in the current scope BEFORE compiling the body
+		// This allows recursive calls
+		symbol := rc.symbolTable.Define(node.Name.Value)
 
-	case *ast.FieldAccessExpression:
-		// Struct field access: obj.field
-		objReg, err := rc.CompileToRegister(node.Left)
+		compiledFn, freeSymbols, err := rc.compileFunction(node, funcType)
 		if err != nil {
 			return -1, err
 		}
 
-		// Get field name constant
-		fieldIdx := rc.addConstant(vm.StringValue(node.Field.Value))
-
-		resultReg := rc.allocateTempRegister()
-		rc.emitRBx(vm.OpRGetField, uint8(resultReg), uint16(fieldIdx))
-
-		rc.freeTempRegister(objReg)
-
-		return resultReg, nil
-
-	case *ast.FunctionStatement:
-		// Build function signature for type checking
-		paramTypes := make([]Type, len(node.Parameters))
-		for i, param := range node.Parameters {
-			paramTypes[i] = ConvertASTType(param.Type)
+		// Add function to constant pool
+		fnIndex := rc.addConstant(vm.NewFunctionValue(compiledFn))
+		if err := rc.storeFunction(node, symbol, fnIndex, freeSymbols); err != nil {
+			return -1, err
 		}
-		returnType := ConvertASTType(node.ReturnType)
+		return -1, nil
 
-		funcType := &FunctionType{
-			ParamTypes: paramTypes,
-			ReturnType: returnType,
-		}
-		rc.functionSigs[node.Name.Value] = funcType
-		rc.typeInfo[node.Name.Value] = funcType
+	default:
+		return -1, fmt.Errorf("register compilation not yet implemented for node type: %T", node)
+	}
+}
 
-		// Define the function name in the current scope BEFORE compiling the body
-		// This allows recursive calls
-		symbol := rc.symbolTable.Define(node.Name.Value)
+// declareFunction records the signature of a function statement for type
+// checking of its calls and returns it
+func (rc *RegisterCompiler) declareFunction(node *ast.FunctionStatement) *FunctionType {
+	// Build function signature for type checking
+	paramTypes := make([]Type, len(node.Parameters))
+	for i, param := range node.Parameters {
+		paramTypes[i] = ConvertASTType(param.Type)
+	}
+	returnType := ConvertASTType(node.ReturnType)
 
-		// Save current compiler state
-		savedInstructions := rc.instructions
-		savedRegisters := rc.registers
-		savedNextReg := rc.nextReg
-		savedMaxRegs := rc.MaxRegs
-		savedTempRegs := rc.tempRegs
+	funcType := &FunctionType{
+		ParamTypes: paramTypes,
+		ReturnType: returnType,
+	}
+	rc.functionSigs[node.Name.Value] = funcType
+	rc.typeInfo[node.Name.Value] = funcType
+	return funcType
+}
 
-		// Create new state for function body
-		rc.instructions = []vm.RegisterInstruction{}
-		rc.registers = make(map[string]int)
-		rc.nextReg = 0
-		rc.MaxRegs = 0
-		rc.tempRegs = []int{}
+// compileFunction compiles the body of a function statement into a function
+// with its own constant pool, and reports the variables it captures from
+// enclosing functions
+func (rc *RegisterCompiler) compileFunction(node *ast.FunctionStatement, funcType *FunctionType) (*vm.Function, []Symbol, error) {
+	paramTypes := funcType.ParamTypes
+	returnType := funcType.ReturnType
+
+	// Save current compiler state
+	savedInstructions := rc.instructions
+	savedLines := rc.lines
+	savedFarJumps := rc.farJumps
+	savedRegisters := rc.registers
+	savedNextReg := rc.nextReg
+	savedMaxRegs := rc.MaxRegs
+	savedTempRegs := rc.tempRegs
+	savedConstants := rc.constants
+	savedConstantIndex := rc.constantIndex
+
+	// Create new state for function body, with its own constant pool
+	rc.instructions = []vm.RegisterInstruction{}
+	rc.constants = []vm.Value{}
+	rc.constantIndex = nil
+	rc.lines = nil
+	rc.farJumps = nil
+	rc.registers = make(map[string]int)
+	rc.nextReg = 0
+	rc.MaxRegs = 0
+	rc.tempRegs = []int{}
+
+	// Enter scope for symbol table (uses embedded Compiler's method)
+	rc.Compiler.enterScope()
+
+	// Record the body's types apart from those outside it
+	outerTypes := &Compiler{
+		varTypes:     rc.varTypes,
+		typeInfo:     rc.typeInfo,
+		functionSigs: rc.functionSigs,
+		outerTypes:   rc.outerTypes,
+	}
+	rc.varTypes = make(map[string]vm.ValueType)
+	rc.typeInfo = make(map[string]Type)
+	rc.functionSigs = make(map[string]*FunctionType)
+	rc.outerTypes = outerTypes
 
-		// Enter scope for symbol table (uses embedded Compiler's method)
-		rc.Compiler.enterScope()
+	// Store the previous return type and set current one
+	prevReturnType, prevFunction := rc.currentFunctionRT, rc.currentFunction
+	rc.currentFunctionRT, rc.currentFunction = returnType, node.Name.Value
 
-		// Store the previous return type and set current one
-		prevReturnType := rc.currentFunctionRT
-		rc.currentFunctionRT = returnType
+	// Tries around the declaration don't cover the body's returns
+	prevTries := rc.tries
+	rc.tries = 0
 
-		// Define parameters in the new scope - parameters occupy first registers
-		for i, param := range node.Parameters {
-			// Define in symbol table
-			rc.symbolTable.Define(param.Name.Value)
-			// Allocate register
-			rc.allocateRegister(param.Name.Value)
-			// Track parameter types
-			rc.typeInfo[param.Name.Value] = paramTypes[i]
-		}
+	prevAliases := maps.Clone(rc.constAliases)
 
-		// Compile function body
-		_, err := rc.CompileToRegister(node.Body)
-		if err != nil {
-			return -1, err
+	// Define parameters in the new scope - parameters occupy first registers
+	for i, param := range node.Parameters {
+		// Define in symbol table
+		rc.symbolTable.Define(param.Name.Value)
+		rc.trackAlias(param.Name.Value, nil)
+		// Allocate register
+		rc.allocateRegister(param.Name.Value)
+		// Track parameter types
+		rc.typeInfo[param.Name.Value] = paramTypes[i]
+		if _, unknown := paramTypes[i].(*AnyType); !unknown {
+			rc.varTypes[param.Name.Value] = convertToValueType(paramTypes[i])
 		}
+	}
+	if err := rc.emitParameterChecks(node, paramTypes); err != nil {
+		return nil, nil, err
+	}
 
-		// If the last instruction is not a return, add an implicit return nil
-		needsReturn := len(rc.instructions) == 0
-		if !needsReturn {
-			lastOp, _, _, _ := rc.instructions[len(rc.instructions)-1].Decode()
-			needsReturn = (lastOp != vm.OpRReturn && lastOp != vm.OpRReturnN)
-		}
-		if needsReturn {
-			// Check if function expects a specific non-nil return value
-			if returnType != nil && !returnType.Equals(NilType) && !returnType.Equals(AnyTypeVal) {
-				return -1, fmt.Errorf("function %s must return %s", node.Name.Value, returnType.String())
-			}
-			rc.emitR(vm.OpRReturnN, 0, 0, 0)
-		}
+	// Compile function body
+	_, err := rc.CompileToRegister(node.Body)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		// Restore previous return type
-		rc.currentFunctionRT = prevReturnType
+	// If the last instruction is not a return, add an implicit return nil
+	needsReturn := len(rc.instructions) == 0
+	if !needsReturn {
+		lastOp, _, _, _ := rc.instructions[len(rc.instructions)-1].Decode()
+		needsReturn = (lastOp != vm.OpRReturn && lastOp != vm.OpRReturnN)
+	}
+	if needsReturn {
+		// Check if function expects a specific non-nil return value
+		if returnType != nil && !returnType.Equals(NilType) && !returnType.Equals(AnyTypeVal) {
+			return nil, nil, fmt.Errorf("function %s must return %s", node.Name.Value, returnType.String())
+		}
+		rc.emitR(vm.OpRReturnN, 0, 0, 0)
+	}
+	if err := rc.finishJumps(); err != nil {
+		return nil, nil, err
+	}
 
-		// Get the compiled instructions
-		numLocals := rc.MaxRegs
-		functionInstructions := rc.instructions
+	// Restore previous return type
+	rc.currentFunctionRT, rc.currentFunction = prevReturnType, prevFunction
+	rc.tries = prevTries
+
+	// Get the compiled instructions
+	numLocals := rc.MaxRegs
+	functionInstructions := rc.instructions
+	functionLines := rc.lines
+	functionConstants := rc.constants
+	freeSymbols := rc.symbolTable.FreeSymbols
+
+	// Leave scope for symbol table (uses embedded Compiler's method)
+	rc.Compiler.leaveScope()
+	rc.varTypes = outerTypes.varTypes
+	rc.typeInfo = outerTypes.typeInfo
+	rc.functionSigs = outerTypes.functionSigs
+	rc.outerTypes = outerTypes.outerTypes
+	rc.constAliases = prevAliases
+
+	// Restore compiler state
+	rc.instructions = savedInstructions
+	rc.lines = savedLines
+	rc.farJumps = savedFarJumps
+	rc.registers = savedRegisters
+	rc.nextReg = savedNextReg
+	rc.MaxRegs = savedMaxRegs
+	rc.tempRegs = savedTempRegs
+	rc.constants = savedConstants
+	rc.constantIndex = savedConstantIndex
+
+	// Create the function object with register bytecode
+	compiledFn := &vm.Function{
+		Name:                 node.Name.Value,
+		NumParams:            len(node.Parameters),
+		NumLocals:            numLocals,
+		NumFree:              len(freeSymbols),
+		RegisterInstructions: functionInstructions,
+		RegisterLines:        functionLines,
+		Instructions:         nil, // No stack bytecode
+		Constants:            functionConstants, // Linked into the program's pool by RegisterBytecode
+	}
+	return compiledFn, freeSymbols, nil
+}
 
-		// Leave scope for symbol table (uses embedded Compiler's method)
-		rc.Compiler.leaveScope()
+// storeFunction emits the code that stores the function in constant fnIndex
+// into the variable for symbol
+func (rc *RegisterCompiler) storeFunction(node *ast.FunctionStatement, symbol Symbol, fnIndex int, freeSymbols []Symbol) error {
+	// A function that captures variables becomes a closure over their
+	// current values, the same as on the stack VM
+	if len(freeSymbols) > 0 {
+		// A local function's register exists before the capture, so a
+		// function that refers to itself captures nil as it does there
+		varReg := -1
+		if symbol.Scope != GlobalScope {
+			varReg = rc.allocateRegister(node.Name.Value)
+		}
 
-		// Restore compiler state
-		rc.instructions = savedInstructions
-		rc.registers = savedRegisters
-		rc.nextReg = savedNextReg
-		rc.MaxRegs = savedMaxRegs
+		// Reserve consecutive registers for the captured values
+		savedTempRegs := rc.tempRegs
+		rc.tempRegs = []int{}
+		freeRegs := make([]int, len(freeSymbols))
+		for i := range freeSymbols {
+			freeRegs[i] = rc.allocateTempRegister()
+		}
 		rc.tempRegs = savedTempRegs
 
-		// Create the function object with register bytecode
-		compiledFn := &vm.Function{
-			Name:                 node.Name.Value,
-			NumParams:            len(node.Parameters),
-			NumLocals:            numLocals,
-			RegisterInstructions: functionInstructions,
-			Instructions:         nil, // No stack bytecode
-			Constants:            rc.constants, // Share constants with parent
+		for i, free := range freeSymbols {
+			if free.Scope == FreeScope {
+				rc.emitRBx(vm.OpRLoadFree, uint8(freeRegs[i]), uint16(free.Index))
+				continue
+			}
+			reg, exists := rc.registers[free.Name]
+			if !exists {
+				return fmt.Errorf("variable %s not in register (symbol scope: %v)", free.Name, free.Scope)
+			}
+			rc.emitR(vm.OpRMove, uint8(freeRegs[i]), uint8(reg), 0)
+		}
+		if err := rc.emitRK(vm.OpRMakeClosure, uint8(freeRegs[0]), fnIndex); err != nil {
+			return err
 		}
 
-		// Add function to constant pool
-		fnIndex := rc.addConstant(vm.NewFunctionValue(compiledFn))
-
-		// Load function constant into a register
 		if symbol.Scope == GlobalScope {
-			// Global function - load into temp then store to global
-			tempReg := rc.allocateTempRegister()
-			rc.emitRBx(vm.OpRLoadK, uint8(tempReg), uint16(fnIndex))
-			rc.emitRBx(vm.OpRStoreGlobal, uint8(tempReg), uint16(symbol.Index))
-			rc.freeTempRegister(tempReg)
+			rc.emitRBx(vm.OpRStoreGlobal, uint8(freeRegs[0]), uint16(symbol.Index))
 		} else {
-			// Local function - load into variable register
-			varReg := rc.allocateRegister(node.Name.Value)
-			rc.emitRBx(vm.OpRLoadK, uint8(varReg), uint16(fnIndex))
+			rc.emitR(vm.OpRMove, uint8(varReg), uint8(freeRegs[0]), 0)
 		}
+		for _, reg := range freeRegs {
+			rc.freeTempRegister(reg)
+		}
+		return nil
+	}
 
-		return -1, nil
-
-	default:
-		return -1, fmt.Errorf("register compilation not yet implemented for node type: %T", node)
+	// Load function constant into a register
+	if symbol.Scope == GlobalScope {
+		// Global function - load into temp then store to global
+		tempReg := rc.allocateTempRegister()
+		if err := rc.emitRK(vm.OpRLoadK, uint8(tempReg), fnIndex); err != nil {
+			return err
+		}
+		rc.emitRBx(vm.OpRStoreGlobal, uint8(tempReg), uint16(symbol.Index))
+		rc.freeTempRegister(tempReg)
+		return nil
 	}
+
+	// Local function - load into variable register
+	varReg := rc.allocateRegister(node.Name.Value)
+	return rc.emitRK(vm.OpRLoadK, uint8(varReg), fnIndex)
 }
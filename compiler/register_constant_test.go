@@ -0,0 +1,55 @@
+package compiler
+
+import (
+	"bytes"
+	"minlang/lexer"
+	"minlang/parser"
+	"minlang/vm"
+	"strings"
+	"testing"
+)
+
+// TestRegisterImmediateLoads checks that true, false, nil and 0 are loaded by
+// opcodes of their own and never reach the constant pool
+func TestRegisterImmediateLoads(t *testing.T) {
+	input := `
+var done = false
+var found = true
+var missing = nil
+var count = 0
+var xs = [7, 8]
+if !done && found && missing == nil { count = count + xs[0] }
+print(count)`
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	rc := NewRegisterCompiler()
+	if _, err := rc.CompileToRegister(program); err != nil {
+		t.Fatalf("compilation error: %s", err)
+	}
+
+	bytecode := rc.RegisterBytecode()
+	listing := vm.RegisterDisassemble(bytecode.Instructions)
+	for _, op := range []vm.RegisterOpCode{vm.OpRLoadTrue, vm.OpRLoadFalse, vm.OpRLoadNil, vm.OpRLoadZero} {
+		if !strings.Contains(listing, " "+op.String()+" ") {
+			t.Errorf("expected %s\n%s", op, listing)
+		}
+	}
+	for _, c := range bytecode.Constants {
+		if _, ok := vm.ImmediateLoad(c); ok {
+			t.Errorf("unexpected constant %s", c.String())
+		}
+	}
+
+	var stdout bytes.Buffer
+	if err := vm.NewRegisterVM(bytecode, vm.WithStdout(&stdout)).Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if stdout.String() != "7\n" {
+		t.Errorf("expected %q, got %q", "7\n", stdout.String())
+	}
+}
@@ -0,0 +1,125 @@
+package compiler
+
+import (
+	"fmt"
+	"minlang/ast"
+	"minlang/vm"
+)
+
+// AnnotationCheck returns the runtime type that a value declared with type t
+// must have, and the error message for one that doesn't, which names the
+// value as what. ok is false if t has nothing to check at run time, like
+// any, nil or an unknown type name.
+func AnnotationCheck(t Type, what string) (want vm.ValueType, message string, ok bool) {
+	switch typ := t.(type) {
+	case *BasicType:
+		switch typ.Name {
+		case "int":
+			want = vm.IntType
+		case "float":
+			want = vm.FloatType
+		case "bool":
+			want = vm.BoolType
+		case "string":
+			want = vm.StringType
+		case "error":
+			want = vm.ErrorType
+		default:
+			return 0, "", false
+		}
+	case *ArrayType:
+		want = vm.ArrayType
+	case *MapType:
+		want = vm.MapType
+	case *FunctionType:
+		want = vm.FunctionType
+	case *TupleType:
+		want = vm.TupleType
+	default:
+		return 0, "", false
+	}
+	return want, fmt.Sprintf("%s must be %s", what, t), true
+}
+
+// ParameterDescription names parameter param of function fn in runtime check
+// messages
+func ParameterDescription(param, fn string) string {
+	return fmt.Sprintf("parameter %s of %s", param, fn)
+}
+
+// ReturnDescription names the return value of function fn in runtime check
+// messages
+func ReturnDescription(fn string) string {
+	return "return value of " + fn
+}
+
+// emitParameterChecks checks the arguments of the function being compiled
+// against its parameter types when runtime checks are on. Parameters are the
+// function's first locals.
+func (c *Compiler) emitParameterChecks(node *ast.FunctionStatement, paramTypes []Type) {
+	if !c.runtimeChecks {
+		return
+	}
+	for i, param := range node.Parameters {
+		want, message, ok := AnnotationCheck(paramTypes[i], ParameterDescription(param.Name.Value, node.Name.Value))
+		if !ok {
+			continue
+		}
+		c.emit(vm.OpLoadLocal, i)
+		c.emit(vm.OpCheckType, c.addConstant(vm.StringValue(message)), int(want))
+		c.emit(vm.OpPop)
+	}
+}
+
+// emitReturnCheck checks the value on top of the stack against the return
+// type of the function being compiled when runtime checks are on
+func (c *Compiler) emitReturnCheck() {
+	if !c.runtimeChecks || c.currentFunctionRT == nil {
+		return
+	}
+	if want, message, ok := AnnotationCheck(c.currentFunctionRT, ReturnDescription(c.currentFunction)); ok {
+		c.emit(vm.OpCheckType, c.addConstant(vm.StringValue(message)), int(want))
+	}
+}
+
+// emitParameterChecks checks the arguments of the function being compiled,
+// in its first registers, against its parameter types when runtime checks
+// are on
+func (rc *RegisterCompiler) emitParameterChecks(node *ast.FunctionStatement, paramTypes []Type) error {
+	if !rc.runtimeChecks {
+		return nil
+	}
+	for i, param := range node.Parameters {
+		if err := rc.emitCheckType(i, paramTypes[i], ParameterDescription(param.Name.Value, node.Name.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emitReturnCheck checks the value in reg against the return type of the
+// function being compiled when runtime checks are on
+func (rc *RegisterCompiler) emitReturnCheck(reg int) error {
+	if !rc.runtimeChecks || rc.currentFunctionRT == nil {
+		return nil
+	}
+	return rc.emitCheckType(reg, rc.currentFunctionRT, ReturnDescription(rc.currentFunction))
+}
+
+// emitCheckType emits a check that reg holds a value of type t, described
+// as what, if t can be checked
+func (rc *RegisterCompiler) emitCheckType(reg int, t Type, what string) error {
+	want, message, ok := AnnotationCheck(t, what)
+	if !ok {
+		return nil
+	}
+	k, err := vm.EncodeRegisterCheckType(uint8(reg), want, rc.addConstant(vm.StringValue(message)))
+	if err != nil {
+		return err
+	}
+	for _, ins := range k {
+		rc.addLine()
+		rc.instructions = append(rc.instructions, ins)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package compiler
+
+import (
+	"fmt"
+	"minlang/ast"
+	"minlang/vm"
+)
+
+// Slices
+//
+// a[low:high] compiles to the container and both bounds followed by a slice
+// instruction, with nil standing in for a bound that was left out; the VMs
+// fill in the defaults and check the bounds when it runs. See vm.Slice.
+
+// checkSlice reports a slice of a map or with a bound that isn't an int
+func (c *Compiler) checkSlice(node *ast.SliceExpression) error {
+	if _, ok := c.inferDetailedType(node.Left).(*MapType); ok {
+		return fmt.Errorf("cannot slice a map")
+	}
+	for _, bound := range []ast.Expression{node.Low, node.High} {
+		if bound == nil {
+			continue
+		}
+		if t := c.inferDetailedType(bound); !IsAssignableTo(t, IntType) {
+			return fmt.Errorf("slice bound must be int, got %s", t.String())
+		}
+	}
+	return nil
+}
+
+// compileSlice compiles node, leaving the slice on the stack
+func (c *Compiler) compileSlice(node *ast.SliceExpression) error {
+	if err := c.checkSlice(node); err != nil {
+		return err
+	}
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+	for _, bound := range []ast.Expression{node.Low, node.High} {
+		if bound == nil {
+			c.emit(vm.OpPush, c.addConstant(vm.NilValue()))
+			continue
+		}
+		if err := c.Compile(bound); err != nil {
+			return err
+		}
+	}
+	c.emit(vm.OpSlice)
+	return nil
+}
+
+// compileSlice compiles node into a new register. OpRSlice takes its bounds
+// from a pair of consecutive registers.
+func (rc *RegisterCompiler) compileSlice(node *ast.SliceExpression) (int, error) {
+	if err := rc.checkSlice(node); err != nil {
+		return -1, err
+	}
+	containerReg, err := rc.CompileToRegister(node.Left)
+	if err != nil {
+		return -1, err
+	}
+
+	bounds := rc.reserveRegisters(2)
+	for i, bound := range []ast.Expression{node.Low, node.High} {
+		if bound == nil {
+			rc.emitR(vm.OpRLoadNil, uint8(bounds+i), 0, 0)
+			continue
+		}
+		if err := rc.compileInto(bound, bounds+i); err != nil {
+			return -1, err
+		}
+	}
+
+	resultReg := rc.allocateTempRegister()
+	rc.emitR(vm.OpRSlice, uint8(resultReg), uint8(containerReg), uint8(bounds))
+	rc.freeTempRegister(containerReg)
+	rc.freeRegisters(bounds, 2)
+	return resultReg, nil
+}
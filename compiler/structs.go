@@ -0,0 +1,127 @@
+package compiler
+
+import (
+	"fmt"
+	"minlang/ast"
+)
+
+// Struct constructors
+//
+// new Point(3, 4) is shorthand for the literal Point{x: 3, y: 4}: the
+// arguments go to the fields in the order the struct declares them, and the
+// fields after the last argument take their defaults, as fields a literal
+// leaves out do.
+
+// NewLiteral returns the struct literal node stands for, for a struct with
+// fields in declaration order
+func NewLiteral(node *ast.NewExpression, fields []string) (*ast.StructLiteral, error) {
+	if len(node.Arguments) > len(fields) {
+		return nil, fmt.Errorf("new %s: %d arguments for %d fields",
+			node.Type.Value, len(node.Arguments), len(fields))
+	}
+	literal := &ast.StructLiteral{
+		Token:  node.Type.Token,
+		Name:   node.Type,
+		Fields: make(map[string]ast.Expression, len(node.Arguments)),
+	}
+	for i, arg := range node.Arguments {
+		literal.Fields[fields[i]] = arg
+	}
+	return literal, nil
+}
+
+// structTypeOf returns the name of the struct type node is known to have, or
+// "" if the compiler doesn't know it. Field accesses follow the declared
+// types of the fields, so Line{...}.a is a Point when Line declares a: Point,
+// and variables the types they are declared or initialized with.
+func (c *Compiler) structTypeOf(node ast.Expression) string {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		return c.structVars[node.Value]
+	case *ast.StructLiteral:
+		return node.Name.Value
+	case *ast.NewExpression:
+		return node.Type.Value
+	case *ast.CallExpression:
+		// A copy is a struct of the same type
+		ident, ok := node.Function.(*ast.Identifier)
+		if !ok || (ident.Value != "clone" && ident.Value != "deepCopy") || len(node.Arguments) != 1 {
+			return ""
+		}
+		if symbol, ok := c.symbolTable.Resolve(ident.Value); ok && symbol.Scope == BuiltinScope {
+			return c.structTypeOf(node.Arguments[0])
+		}
+	case *ast.FieldAccessExpression:
+		owner, ok := c.structTypes[c.structTypeOf(node.Left)]
+		if !ok {
+			return ""
+		}
+		if _, ok := c.structTypes[owner.Fields[node.Field.Value]]; ok {
+			return owner.Fields[node.Field.Value]
+		}
+	}
+	return ""
+}
+
+// fieldOffset returns the offset of field in the struct left evaluates to,
+// or -1 if its struct type isn't known and the field must be found by name
+func (c *Compiler) fieldOffset(left ast.Expression, field string) int {
+	structType, ok := c.structTypes[c.structTypeOf(left)]
+	if !ok {
+		return -1
+	}
+	return structType.GetFieldOffset(field)
+}
+
+// checkStructField reports a value for a field of structName declared as a
+// struct type that is known to be something else, as field accesses through
+// the field take it to be a struct of the declared type
+func (c *Compiler) checkStructField(structName, field string, value ast.Expression) error {
+	owner, ok := c.structTypes[structName]
+	if !ok {
+		return nil
+	}
+	what := fmt.Sprintf("field %s of struct %s", field, structName)
+	return c.checkStructValue(what, owner.Fields[field], value)
+}
+
+// checkStructValue reports value, given to what, if declared is a struct
+// type and value is known to be something else
+func (c *Compiler) checkStructValue(what, declared string, value ast.Expression) error {
+	if _, ok := c.structTypes[declared]; !ok {
+		return nil
+	}
+	got := c.structTypeOf(value)
+	if got == "" {
+		if t := c.inferDetailedType(value); !t.Equals(AnyTypeVal) && !t.Equals(NilType) {
+			got = t.String()
+		}
+	}
+	if got != "" && got != declared {
+		return fmt.Errorf("%s: expected %s, got %s", what, declared, got)
+	}
+	return nil
+}
+
+// declaredStruct returns the struct ta names, or "" if it isn't one
+func (c *Compiler) declaredStruct(ta *ast.TypeAnnotation) string {
+	if ta == nil || ta.IsArray || ta.IsMap || ta.IsFunction {
+		return ""
+	}
+	if _, ok := c.structTypes[ta.Name]; !ok {
+		return ""
+	}
+	return ta.Name
+}
+
+// setStructVar records that the variable name holds a struct of type
+// structName, or with "" that its struct type isn't known. The register
+// compiler, whose forks share the map, only ever forgets names it doesn't
+// have, so it never writes to it.
+func (c *Compiler) setStructVar(name, structName string) {
+	if structName != "" {
+		c.structVars[name] = structName
+	} else if _, ok := c.structVars[name]; ok {
+		delete(c.structVars, name)
+	}
+}
@@ -0,0 +1,143 @@
+package compiler
+
+import (
+	"minlang/lexer"
+	"minlang/parser"
+	"minlang/vm"
+	"testing"
+)
+
+func TestStructDefaults(t *testing.T) {
+	point := "type Point = struct { x: int = 0, y: int, label: string = \"p\" }\n"
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"defaults fill in", "var p = Point{y: 1}", ""},
+		{"new", "var p = new Point(1, 2, \"q\")\nvar q = new Point(1, 2)", ""},
+		{"missing field", "var p = Point{x: 1}", "missing required field y in struct Point"},
+		{"new leaves out a field", "var p = new Point(1)", "missing required field y in struct Point"},
+		{"unknown field", "var p = Point{y: 1, z: 2}", "struct Point has no field z"},
+		{"too many arguments", "var p = new Point(1, 2, \"q\", 4)", "new Point: 4 arguments for 3 fields"},
+		{"unknown struct", "var p = new Line(1)", "new: unknown struct Line"},
+		{"default type", "type Bad = struct { n: int = \"a\" }", "2:30: default of field n in struct Bad: cannot assign value of type string to type int"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(point + tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("%s: parser errors: %v", tt.name, p.Errors())
+		}
+
+		err := New().Compile(program)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s: compiler error: %s", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%s: expected error %q, got %v", tt.name, tt.err, err)
+		}
+	}
+}
+
+func TestNestedStructFieldOffsets(t *testing.T) {
+	input := "type Point = struct { x: int, y: int }\n" +
+		"type Line = struct { a: Point, b: Point }\n" +
+		"var y = Line{a: Point{x: 1, y: 2}, b: new Point(3, 4)}.b.y\n" +
+		"var l = Line{a: Point{x: 1, y: 2}, b: Point{x: 3, y: 4}}\n" +
+		"var x = l.a.x"
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+
+	c := New()
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	if n := countOp(c.Bytecode().Instructions, vm.OpGetFieldOffset); n != 4 {
+		t.Errorf("expected 4 OpGetFieldOffset, got %d", n)
+	}
+}
+
+func TestNestedStructFieldTypes(t *testing.T) {
+	types := "type Point = struct { x: int, y: int }\n" +
+		"type Vec = struct { y: int, x: int }\n" +
+		"type Line = struct { a: Point, b: Point }\n"
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"nested literal", "var l = Line{a: Point{x: 1, y: 2}, b: new Point(3, 4)}", ""},
+		{"other struct", "var l = Line{a: Point{x: 1, y: 2}, b: Vec{x: 3, y: 4}}", "4:39: field b of struct Line: expected Point, got Vec"},
+		{"not a struct", "var l = Line{a: Point{x: 1, y: 2}, b: 5}", "4:39: field b of struct Line: expected Point, got int"},
+		{"unknown type", "var p: any = 1\nvar l = Line{a: p, b: nil}", ""},
+		{"variable", "var p = Point{x: 1, y: 2}\np = Vec{x: 1, y: 2}", "5:1: variable p: expected Point, got Vec"},
+		{"annotated variable", "var p: Point = 3", "4:1: variable p: expected Point, got int"},
+		{"argument", "func f(p: Point): int { return p.x }\nf(Vec{x: 1, y: 2})", "5:1: function f argument 1: expected Point, got Vec"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(types + tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("%s: parser errors: %v", tt.name, p.Errors())
+		}
+
+		err := New().Compile(program)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s: compiler error: %s", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%s: expected error %q, got %v", tt.name, tt.err, err)
+		}
+	}
+}
+
+func TestStructVariableFieldOffsets(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		offsets int
+	}{
+		{"initialized variable", "var p = Point{x: 1, y: 2}\np.x = p.y", 2},
+		{"annotated variable", "func origin(): any { return Point{x: 0, y: 0} }\nvar p: Point = origin()\nprint(p.y)", 1},
+		{"parameter", "func norm(p: Point): int { return p.x * p.x + p.y * p.y }", 4},
+		{"redeclared", "var p = Point{x: 1, y: 2}\nvar p = origin\nprint(p.x)", 0},
+		{"unknown", "func f(p: any): any { return p.x }", 0},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New("type Point = struct { x: int, y: int }\nvar origin: any = nil\n" + tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("%s: parser errors: %v", tt.name, p.Errors())
+		}
+
+		c := New()
+		if err := c.Compile(program); err != nil {
+			t.Fatalf("%s: compiler error: %s", tt.name, err)
+		}
+
+		ins := c.Bytecode().Instructions
+		for _, constant := range c.Bytecode().Constants {
+			if constant.Type == vm.FunctionType {
+				ins = append(ins, constant.AsFunction().Instructions...)
+			}
+		}
+		offsets := countOp(ins, vm.OpGetFieldOffset) + countOp(ins, vm.OpSetFieldOffset)
+		if offsets != tt.offsets {
+			t.Errorf("%s: expected %d offset accesses, got %d", tt.name, tt.offsets, offsets)
+		}
+	}
+}
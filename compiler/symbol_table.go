@@ -1,5 +1,10 @@
 package compiler
 
+import (
+	"maps"
+	"minlang/vm"
+)
+
 // SymbolScope represents the scope of a symbol
 type SymbolScope string
 
@@ -16,6 +21,7 @@ type Symbol struct {
 	Scope     SymbolScope
 	Index     int
 	IsMutable bool
+	Value     *vm.Value // A const's value, if the compiler worked it out, see constexpr.go
 }
 
 // SymbolTable represents a symbol table
@@ -34,37 +40,19 @@ func NewSymbolTable() *SymbolTable {
 	free := []Symbol{}
 	st := &SymbolTable{store: s, FreeSymbols: free}
 
-	// Define built-in functions (must match order in vm/builtins.go)
-	st.DefineBuiltin(0, "print")
-	st.DefineBuiltin(1, "len")
-	st.DefineBuiltin(2, "delete")
-	st.DefineBuiltin(3, "append")
-	st.DefineBuiltin(4, "keys")
-	st.DefineBuiltin(5, "values")
-	st.DefineBuiltin(6, "copy")
-	st.DefineBuiltin(7, "enumName")
-	st.DefineBuiltin(8, "enumValue")
-	st.DefineBuiltin(9, "abs")
-	st.DefineBuiltin(10, "min")
-	st.DefineBuiltin(11, "max")
-	st.DefineBuiltin(12, "sqrt")
-	st.DefineBuiltin(13, "pow")
-	st.DefineBuiltin(14, "floor")
-	st.DefineBuiltin(15, "ceil")
-	st.DefineBuiltin(16, "split")
-	st.DefineBuiltin(17, "substring")
-	st.DefineBuiltin(18, "int")
-	st.DefineBuiltin(19, "float")
-	st.DefineBuiltin(20, "string")
+	// Define built-in functions, including any registered by the host
+	for index, name := range vm.BuiltinNames() {
+		st.DefineBuiltin(index, name)
+	}
 
 	return st
 }
 
-// NewEnclosedSymbolTable creates a new enclosed symbol table
+// NewEnclosedSymbolTable creates a new enclosed symbol table. Builtins
+// resolve through the outermost table, so a global of the same name shadows
+// them inside functions too.
 func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
-	s := NewSymbolTable()
-	s.outer = outer
-	return s
+	return &SymbolTable{outer: outer, store: make(map[string]Symbol), FreeSymbols: []Symbol{}}
 }
 
 // Define defines a new symbol
@@ -91,16 +79,50 @@ func (st *SymbolTable) DefineWithMutability(name string, isMutable bool) Symbol
 	return symbol
 }
 
+// SetValue records value as the value of the const name, defined in st, and
+// returns its symbol
+func (st *SymbolTable) SetValue(name string, value vm.Value) Symbol {
+	symbol := st.store[name]
+	symbol.Value = &value
+	st.store[name] = symbol
+	return symbol
+}
+
+// Snapshot returns a copy of st that later definitions in st don't change,
+// so it can be read while st is still being defined into
+func (st *SymbolTable) Snapshot() *SymbolTable {
+	return &SymbolTable{
+		outer:          st.outer,
+		store:          maps.Clone(st.store),
+		numDefinitions: st.numDefinitions,
+		FreeSymbols:    append([]Symbol{}, st.FreeSymbols...),
+	}
+}
+
+// Prefix returns a view of the global table st holding only its first n
+// definitions and the builtins. The view shares st's symbols, so st must not
+// change while it is in use.
+func (st *SymbolTable) Prefix(n int) *SymbolTable {
+	return &SymbolTable{
+		store:          st.store,
+		numDefinitions: n,
+		FreeSymbols:    []Symbol{},
+	}
+}
+
 // Resolve resolves a symbol
 func (st *SymbolTable) Resolve(name string) (Symbol, bool) {
 	obj, ok := st.store[name]
+	if ok && obj.Scope == GlobalScope && obj.Index >= st.numDefinitions {
+		ok = false // Defined after the Prefix was taken
+	}
 	if !ok && st.outer != nil {
 		obj, ok = st.outer.Resolve(name)
 		if !ok {
 			return obj, ok
 		}
 
-		if obj.Scope == GlobalScope {
+		if obj.Scope == GlobalScope || obj.Scope == BuiltinScope {
 			return obj, ok
 		}
 
@@ -120,6 +142,7 @@ func (st *SymbolTable) defineFree(original Symbol) Symbol {
 		Index:     len(st.FreeSymbols) - 1,
 		Scope:     FreeScope,
 		IsMutable: original.IsMutable,
+		Value:     original.Value,
 	}
 
 	st.store[original.Name] = symbol
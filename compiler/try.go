@@ -0,0 +1,98 @@
+package compiler
+
+import (
+	"minlang/ast"
+	"minlang/vm"
+)
+
+// Try statements
+//
+// try { body } catch e { handler } compiles to the TRY, END_TRY and jumps
+// described in vm/try.go. A try stays active until its END_TRY runs, so a
+// return, break or continue that leaves a try body ends the try on its way
+// out: tries counts the try bodies being compiled in the current function,
+// and each loop records how many there were when it began, so a break ends
+// the ones inside the loop and a return all of them.
+
+// compileTry compiles a try statement for the stack VM
+func (c *Compiler) compileTry(node *ast.TryStatement) error {
+	tryPos := c.emit(vm.OpTry, 9999)
+	c.tries++
+	err := c.Compile(node.Body)
+	c.tries--
+	if err != nil {
+		return err
+	}
+	c.emit(vm.OpEndTry)
+	jumpPos := c.emit(vm.OpJump, 9999)
+
+	// The catch starts with the error on the stack
+	c.changeOperand(tryPos, len(c.currentInstructions()))
+	if node.Variable != nil {
+		c.storeSymbol(c.defineCaught(node.Variable))
+	} else {
+		c.emit(vm.OpPop)
+	}
+	if err := c.Compile(node.Catch); err != nil {
+		return err
+	}
+
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+// compileTry compiles a try statement for the register VM
+func (rc *RegisterCompiler) compileTry(node *ast.TryStatement) error {
+	errReg := rc.allocateTempRegister()
+	tryPos := rc.emitRBx(vm.OpRTry, uint8(errReg), 0)
+	rc.tries++
+	_, err := rc.CompileToRegister(node.Body)
+	rc.tries--
+	if err != nil {
+		return err
+	}
+	rc.emitR(vm.OpREndTry, 0, 0, 0)
+	jumpPos := rc.emitRBx(vm.OpRJump, 0, 0)
+
+	// The catch starts with the error in errReg
+	if err := rc.patchJump(tryPos, len(rc.instructions)); err != nil {
+		return err
+	}
+	if node.Variable != nil {
+		symbol := rc.defineCaught(node.Variable)
+		if symbol.Scope == GlobalScope {
+			rc.emitRBx(vm.OpRStoreGlobal, uint8(errReg), uint16(symbol.Index))
+		} else {
+			rc.emitR(vm.OpRMove, uint8(rc.allocateRegister(node.Variable.Value)), uint8(errReg), 0)
+		}
+	}
+	rc.freeTempRegister(errReg)
+	if _, err := rc.CompileToRegister(node.Catch); err != nil {
+		return err
+	}
+
+	return rc.patchJump(jumpPos, len(rc.instructions))
+}
+
+// defineCaught defines the variable a catch block receives its error in
+func (c *Compiler) defineCaught(name *ast.Identifier) Symbol {
+	c.varTypes[name.Value] = vm.ErrorType
+	c.typeInfo[name.Value] = ErrorType
+	c.setStructVar(name.Value, "")
+	return c.symbolTable.DefineWithMutability(name.Value, true)
+}
+
+// emitEndTries emits an end for each try a jump leaves, the innermost of
+// those begun since the loop or function it jumps out of began
+func (c *Compiler) emitEndTries(since int) {
+	for i := since; i < c.tries; i++ {
+		c.emit(vm.OpEndTry)
+	}
+}
+
+// emitEndTries is Compiler.emitEndTries for the register VM
+func (rc *RegisterCompiler) emitEndTries(since int) {
+	for i := since; i < rc.tries; i++ {
+		rc.emitR(vm.OpREndTry, 0, 0, 0)
+	}
+}
@@ -0,0 +1,106 @@
+package compiler
+
+import (
+	"fmt"
+	"minlang/ast"
+	"minlang/vm"
+)
+
+// Tuples
+//
+// (a, b) builds a tuple and t.0 reads its first element. The compiler knows
+// the element types of a tuple from its literal or from an annotation such
+// as (int, string), so t.0 has the type of the element it reads and a
+// position past the end of a tuple is a compile error. Tuples can't be
+// changed: assigning to t.0 is a compile error too.
+
+// maxTupleElements is the most elements a tuple can have, as the register
+// VM counts them, and their positions, in 8 bits
+const maxTupleElements = 255
+
+// checkTupleLiteral reports a tuple literal with too many elements
+func checkTupleLiteral(node *ast.TupleLiteral) error {
+	if len(node.Elements) > maxTupleElements {
+		return fmt.Errorf("tuple of %d elements: tuples have at most %d", len(node.Elements), maxTupleElements)
+	}
+	return nil
+}
+
+// checkTupleElement reports t.i where t is known not to be a tuple, or not to
+// have an element i
+func (c *Compiler) checkTupleElement(node *ast.TupleElementExpression) error {
+	if node.Index >= maxTupleElements {
+		return fmt.Errorf("tuple has no element %d: tuples have at most %d", node.Index, maxTupleElements)
+	}
+	switch t := c.inferDetailedType(node.Left).(type) {
+	case *TupleType:
+		if node.Index >= len(t.Elements) {
+			return fmt.Errorf("tuple %s has no element %d", t, node.Index)
+		}
+	case *AnyType:
+	default:
+		if !t.Equals(NilType) {
+			return fmt.Errorf("cannot take element %d of %s: not a tuple", node.Index, t)
+		}
+	}
+	return nil
+}
+
+// tupleAssignmentError is the error for assigning to the tuple element left
+func tupleAssignmentError(left *ast.TupleElementExpression) error {
+	return fmt.Errorf("cannot assign to %s.%d: tuples can't be changed", left.Left.String(), left.Index)
+}
+
+func (c *Compiler) compileTupleLiteral(node *ast.TupleLiteral) error {
+	if err := checkTupleLiteral(node); err != nil {
+		return err
+	}
+	for _, el := range node.Elements {
+		if err := c.Compile(el); err != nil {
+			return err
+		}
+	}
+	c.emit(vm.OpTuple, len(node.Elements))
+	return nil
+}
+
+func (c *Compiler) compileTupleElement(node *ast.TupleElementExpression) error {
+	if err := c.checkTupleElement(node); err != nil {
+		return err
+	}
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+	c.emit(vm.OpTupleGet, node.Index)
+	return nil
+}
+
+func (rc *RegisterCompiler) compileTupleLiteral(node *ast.TupleLiteral) (int, error) {
+	if err := checkTupleLiteral(node); err != nil {
+		return -1, err
+	}
+	n := len(node.Elements)
+	base := rc.reserveRegisters(n)
+	for i, el := range node.Elements {
+		if err := rc.compileInto(el, base+i); err != nil {
+			return -1, err
+		}
+	}
+	rc.emitR(vm.OpRTupleFrom, uint8(base), uint8(n), 0)
+	rc.freeRegisters(base+1, n-1)
+	return base, nil
+}
+
+func (rc *RegisterCompiler) compileTupleElement(node *ast.TupleElementExpression) (int, error) {
+	if err := rc.checkTupleElement(node); err != nil {
+		return -1, err
+	}
+	tupleReg, err := rc.CompileToRegister(node.Left)
+	if err != nil {
+		return -1, err
+	}
+	resultReg := rc.allocateTempRegister()
+	rc.emitR(vm.OpRTupleGet, uint8(resultReg), uint8(tupleReg), uint8(node.Index))
+	rc.freeTempRegister(tupleReg)
+	return resultReg, nil
+}
@@ -0,0 +1,51 @@
+package compiler
+
+import (
+	"minlang/lexer"
+	"minlang/parser"
+	"testing"
+)
+
+func TestTuples(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		err   string
+	}{
+		{"element types", "var p = (1, \"a\")\nvar s: string = p.1", ""},
+		{"annotation", "var p: (int, float) = (1, 2.5)\nvar f: float = p.1", ""},
+		{"nested", "var p = ((1, 2), 3)\nvar n: int = p.0.1", ""},
+		{"entries", "var m = map[string]int{\"a\": 1}\nfor e in entries(m) {\n var k: string = e.0\n}", ""},
+		{"element type", "var p = (1, \"a\")\nvar n: int = p.1", "2:1: cannot assign value of type string to type int"},
+		{"tuple type", "var p: (int, string) = (1, 2)", "1:1: cannot assign value of type (int, int) to type (int, string)"},
+		{"no element", "var p = (1, \"a\")\nprint(p.2)", "tuple (int, string) has no element 2"},
+		{"not a tuple", "var n = 3\nprint(n.0)", "cannot take element 0 of int: not a tuple"},
+		{"assignment", "var p = (1, 2)\np.0 = 3", "cannot assign to p.0: tuples can't be changed"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("%s: parser errors: %v", tt.name, p.Errors())
+		}
+
+		err := New().Compile(program)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s: compiler error: %s", tt.name, err)
+			}
+		} else if err == nil || err.Error() != tt.err {
+			t.Errorf("%s: expected error %q, got %v", tt.name, tt.err, err)
+		}
+
+		_, err = NewRegisterCompiler().CompileToRegister(program)
+		if tt.err == "" {
+			if err != nil {
+				t.Errorf("%s: register compiler error: %s", tt.name, err)
+			}
+		} else if err == nil || err.Error() != tt.err {
+			t.Errorf("%s: register compiler: expected error %q, got %v", tt.name, tt.err, err)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package compiler
 
 import (
-	"fmt"
 	"minlang/ast"
 	"minlang/vm"
 )
@@ -20,6 +19,8 @@ func convertToValueType(t Type) vm.ValueType {
 			return vm.BoolType
 		case "string":
 			return vm.StringType
+		case "error":
+			return vm.ErrorType
 		default:
 			// For struct types defined as BasicType with custom names
 			// we don't know the exact type, so default to IntType
@@ -31,6 +32,8 @@ func convertToValueType(t Type) vm.ValueType {
 		return vm.MapType
 	case *FunctionType:
 		return vm.FunctionType
+	case *TupleType:
+		return vm.TupleType
 	}
 	// Default to IntType for unknown types
 	return vm.IntType
@@ -51,6 +54,8 @@ func typeAnnotationToValueType(ta *ast.TypeAnnotation) vm.ValueType {
 		return vm.BoolType
 	case "string":
 		return vm.StringType
+	case "error":
+		return vm.ErrorType
 	}
 
 	// Check if it's an array type
@@ -83,17 +88,21 @@ func (c *Compiler) inferExpressionType(node ast.Expression) vm.ValueType {
 	case *ast.StringLiteral:
 		return vm.StringType
 
+	// Comparisons with nil, and with values that may be nil, can't be
+	// specialized: the opcodes for ints or strings would compare nil's
+	// payload, which looks like 0 or ""
+	case *ast.NilLiteral:
+		return vm.NilType
+
 	case *ast.Identifier:
 		// Check if we have type information from our type tracking
-		if t, ok := c.varTypes[n.Value]; ok {
+		if t, ok := c.lookupVarType(n.Value); ok {
 			return t
 		}
 
-		// Check for enum values (they're integers)
-		for _, enumType := range c.enumTypes {
-			if _, ok := enumType.Variants[n.Value]; ok {
-				return vm.IntType
-			}
+		// Check for enum values (ints, or strings in a string enum)
+		if enumType, variant := c.enumVariant(n); enumType != nil {
+			return enumType.Variants[variant].Type
 		}
 
 		// Default: we don't know, return IntType as a safe default
@@ -111,11 +120,31 @@ func (c *Compiler) inferExpressionType(node ast.Expression) vm.ValueType {
 		return c.inferExpressionType(n.Right)
 
 	case *ast.CallExpression:
-		// Check if it's a known builtin function with a specific return type
+		// Shape.Circle(r) makes a variant
+		if enumType, _ := c.payloadVariant(n); enumType != nil {
+			return vm.VariantType
+		}
+		// A generic function's instance returns the type its types give
+		if sig, ok := c.genericCallType(n); ok {
+			return convertToValueType(sig.ReturnType)
+		}
+		// User-defined functions shadow builtins of the same name
+		if sig, ok := c.calleeSignature(n); ok {
+			return convertToValueType(sig.ReturnType)
+		}
 		if ident, ok := n.Function.(*ast.Identifier); ok {
+			// Check if it's a known builtin function with a specific return type
 			switch ident.Value {
+			// Reductions over an array have its element type; avg is a mean
+			case "sum":
+				return c.arrayElementType(n.Arguments)
+			case "avg":
+				return vm.FloatType
 			// Math functions that return float
 			case "sqrt", "pow", "abs", "min", "max":
+				if len(n.Arguments) == 1 && c.inferExpressionType(n.Arguments[0]) == vm.ArrayType {
+					return c.arrayElementType(n.Arguments)
+				}
 				// If any argument is float, result is float
 				for _, arg := range n.Arguments {
 					if c.inferExpressionType(arg) == vm.FloatType {
@@ -128,43 +157,166 @@ func (c *Compiler) inferExpressionType(node ast.Expression) vm.ValueType {
 				}
 				// sqrt and pow always return float
 				return vm.FloatType
-			case "floor", "ceil":
+			case "floor", "ceil", "round", "trunc", "sign":
 				return vm.IntType
-			case "float":
+			case "sin", "cos", "tan", "atan2", "exp", "log", "clock":
 				return vm.FloatType
-			case "int":
+			case "now":
 				return vm.IntType
-			case "string":
+			case "float", "parseFloat":
+				return vm.FloatType
+			case "int", "parseInt", "ord":
+				return vm.IntType
+			case "string", "build", "formatInt", "formatNumber", "typeof", "chr":
 				return vm.StringType
-			case "split", "keys", "values", "append", "copy":
+			case "replace", "toUpper", "toLower", "trim", "repeat", "join", "format", "formatTime":
+				return vm.StringType
+			case "args":
+				return vm.ArrayType
+			// A string, or nil when there is none
+			case "readLine", "input", "getenv":
+				return vm.NilType
+			// The value panicked with, of any type, or nil
+			case "recover":
+				return vm.NilType
+			case "startsWith", "endsWith":
+				return vm.BoolType
+			case "builder", "add":
+				return vm.BuilderType
+			case "split", "keys", "values", "entries", "append", "copy", "enumerate", "zip", "runes", "bytes":
+				return vm.ArrayType
+			case "bsearch":
+				return vm.IntType
+			case "insertSorted", "makeArray", "makeMatrix", "reverse", "map", "filter", "range":
 				return vm.ArrayType
+			case "indexOf":
+				return vm.IntType
+			case "contains", "hasKey", "has":
+				return vm.BoolType
+			// The map's values or the default, so either when they differ
+			case "getOr":
+				if t := c.inferDetailedType(n); !t.Equals(AnyTypeVal) {
+					return convertToValueType(t)
+				}
+				return vm.NilType
+			case "heapPop", "heapPeek", "pop", "removeAt":
+				if len(n.Arguments) >= 1 {
+					if arrayType, ok := c.inferDetailedType(n.Arguments[0]).(*ArrayType); ok {
+						return convertToValueType(arrayType.ElementType)
+					}
+				}
+			case "reduce":
+				if len(n.Arguments) == 3 {
+					return c.inferExpressionType(n.Arguments[2])
+				}
+			case "copyMap":
+				return vm.MapType
+			case "clone", "deepCopy":
+				if len(n.Arguments) == 1 {
+					return c.inferExpressionType(n.Arguments[0])
+				}
 			case "len":
 				return vm.IntType
-			// User-defined functions - check function signature
-			default:
-				if funcType, ok := c.functionSigs[ident.Value]; ok {
-					return convertToValueType(funcType.ReturnType)
+			case "enumName":
+				return vm.StringType
+			case "enumValue":
+				// The type of the enum's values, if it's named literally
+				if len(n.Arguments) == 2 {
+					if name, ok := n.Arguments[0].(*ast.StringLiteral); ok {
+						if enumType, ok := c.enumTypes[name.Value]; ok && len(enumType.VariantNames) > 0 {
+							return enumType.Variants[enumType.VariantNames[0]].Type
+						}
+					}
 				}
+				return vm.IntType
+			case "validUTF8":
+				return vm.BoolType
 			}
 		}
 		// Default to int for unknown functions
 		return vm.IntType
 
+	case *ast.IndexExpression:
+		// Indexing a string gives a one-byte string, and indexing an array
+		// or map whose elements are known gives one of them
+		switch container := c.inferDetailedType(n.Left).(type) {
+		case *ArrayType:
+			return convertToValueType(container.ElementType)
+		case *MapType:
+			return convertToValueType(container.ValueType)
+		default:
+			if container.Equals(StringType) {
+				return vm.StringType
+			}
+		}
+		return vm.IntType
+
+	case *ast.SliceExpression:
+		// A slice has the type of what it slices
+		return c.inferExpressionType(n.Left)
+
+	case *ast.CastExpression:
+		return convertToValueType(c.convertType(n.Type))
+
+	case *ast.TupleLiteral:
+		return vm.TupleType
+
+	case *ast.TupleElementExpression:
+		if t := c.inferDetailedType(n); !t.Equals(AnyTypeVal) {
+			return convertToValueType(t)
+		}
+		return vm.NilType
+
+	case *ast.FieldAccessExpression:
+		// Color.Red has the type of the enum's values
+		if enumType, variant := c.enumVariant(n); enumType != nil {
+			return enumType.Variants[variant].Type
+		}
+		// A field of a struct whose type is known has its declared type
+		if structType, ok := c.structTypes[c.structTypeOf(n.Left)]; ok {
+			switch structType.Fields[n.Field.Value] {
+			case "float":
+				return vm.FloatType
+			case "string":
+				return vm.StringType
+			case "bool":
+				return vm.BoolType
+			}
+		}
+		return vm.IntType
+
 	case *ast.ArrayLiteral:
 		return vm.ArrayType
 
 	case *ast.MapLiteral:
 		return vm.MapType
 
-	case *ast.StructLiteral:
+	case *ast.StructLiteral, *ast.NewExpression:
 		return vm.StructType
 
+	case *ast.IfExpression:
+		return c.inferBranchValueType(n.IfStatement)
+
+	case *ast.SwitchExpression:
+		return c.inferBranchValueType(n.SwitchStatement)
+
 	default:
 		// Unknown type - default to int
 		return vm.IntType
 	}
 }
 
+// arrayElementType returns float for a reduction over args holding an array
+// of floats, and int otherwise
+func (c *Compiler) arrayElementType(args []ast.Expression) vm.ValueType {
+	if len(args) == 1 {
+		if arrayType, ok := c.inferDetailedType(args[0]).(*ArrayType); ok && arrayType.ElementType.Equals(FloatType) {
+			return vm.FloatType
+		}
+	}
+	return vm.IntType
+}
+
 // inferInfixType determines the result type of an infix expression
 func (c *Compiler) inferInfixType(node *ast.InfixExpression) vm.ValueType {
 	leftType := c.inferExpressionType(node.Left)
@@ -187,6 +339,9 @@ func (c *Compiler) inferInfixType(node *ast.InfixExpression) vm.ValueType {
 		if leftType == vm.FloatType || rightType == vm.FloatType {
 			return vm.FloatType
 		}
+		if node.Operator == "/" && c.promoteIntDiv {
+			return vm.FloatType
+		}
 		return vm.IntType
 
 	case "%":
@@ -197,8 +352,8 @@ func (c *Compiler) inferInfixType(node *ast.InfixExpression) vm.ValueType {
 		// Comparisons always return bool
 		return vm.BoolType
 
-	case "&&", "||":
-		// Logical operations return bool
+	case "&&", "||", "in":
+		// Logical operations and membership return bool
 		return vm.BoolType
 
 	default:
@@ -235,7 +390,7 @@ func (c *Compiler) inferDetailedType(node ast.Expression) Type {
 
 	case *ast.Identifier:
 		// Check if we have detailed type information
-		if t, ok := c.typeInfo[n.Value]; ok {
+		if t, ok := c.lookupTypeInfo(n.Value); ok {
 			return t
 		}
 		return AnyTypeVal
@@ -253,15 +408,9 @@ func (c *Compiler) inferDetailedType(node ast.Expression) Type {
 			return &MapType{KeyType: AnyTypeVal, ValueType: AnyTypeVal}
 		}
 		// Infer key and value types from first pair
-		var firstKey ast.Expression
-		var firstValue ast.Expression
-		for k, v := range n.Pairs {
-			firstKey = k
-			firstValue = v
-			break
-		}
+		firstKey := n.Keys[0]
 		keyType := c.inferDetailedType(firstKey)
-		valueType := c.inferDetailedType(firstValue)
+		valueType := c.inferDetailedType(n.Pairs[firstKey])
 		return &MapType{KeyType: keyType, ValueType: valueType}
 
 	case *ast.InfixExpression:
@@ -278,18 +427,28 @@ func (c *Compiler) inferDetailedType(node ast.Expression) Type {
 			if leftType.Equals(FloatType) || rightType.Equals(FloatType) {
 				return FloatType
 			}
+			if leftType.Equals(AnyTypeVal) || rightType.Equals(AnyTypeVal) {
+				return AnyTypeVal
+			}
 			return IntType
 
 		case "-", "*", "/", "%":
 			if leftType.Equals(FloatType) || rightType.Equals(FloatType) {
 				return FloatType
 			}
+			if n.Operator == "/" && c.promoteIntDiv {
+				return FloatType
+			}
+			// An operand of unknown type may be a float
+			if leftType.Equals(AnyTypeVal) || rightType.Equals(AnyTypeVal) {
+				return AnyTypeVal
+			}
 			return IntType
 
 		case "==", "!=", "<", ">", "<=", ">=":
 			return BoolType
 
-		case "&&", "||":
+		case "&&", "||", "in":
 			return BoolType
 
 		default:
@@ -318,58 +477,246 @@ func (c *Compiler) inferDetailedType(node ast.Expression) Type {
 		}
 		return AnyTypeVal
 
+	case *ast.SliceExpression:
+		return c.inferDetailedType(n.Left)
+
+	case *ast.CastExpression:
+		return c.convertType(n.Type)
+
+	case *ast.TupleLiteral:
+		elements := make([]Type, len(n.Elements))
+		for i, el := range n.Elements {
+			elements[i] = c.inferDetailedType(el)
+		}
+		return &TupleType{Elements: elements}
+
+	case *ast.TupleElementExpression:
+		if tuple, ok := c.inferDetailedType(n.Left).(*TupleType); ok && n.Index < len(tuple.Elements) {
+			return tuple.Elements[n.Index]
+		}
+		return AnyTypeVal
+
 	case *ast.CallExpression:
-		// For now, return AnyTypeVal for function calls
-		// Would need to track function return types
+		if sig, ok := c.genericCallType(n); ok {
+			return sig.ReturnType
+		}
+		// A call of a function whose signature is known has its return type
+		if sig, ok := c.calleeSignature(n); ok {
+			return sig.ReturnType
+		}
+		if ident, ok := n.Function.(*ast.Identifier); ok {
+			switch {
+			// Copies, reversed or not, have the type of what they copy
+			case (ident.Value == "copyMap" || ident.Value == "clone" || ident.Value == "deepCopy" || ident.Value == "reverse") && len(n.Arguments) == 1,
+				ident.Value == "filter" && len(n.Arguments) == 2:
+				return c.inferDetailedType(n.Arguments[0])
+			// map's elements are what its function returns, and reduce's
+			// result has the type of its initial value
+			case ident.Value == "map" && len(n.Arguments) == 2:
+				if fn, ok := n.Arguments[1].(*ast.Identifier); ok {
+					if sig, ok := c.lookupFunctionSig(fn.Value); ok && sig.ReturnType != nil {
+						return &ArrayType{ElementType: sig.ReturnType}
+					}
+				}
+				return &ArrayType{ElementType: AnyTypeVal}
+			case ident.Value == "reduce" && len(n.Arguments) == 3:
+				return c.inferDetailedType(n.Arguments[2])
+			// getOr gives the map's value or the default, so has their
+			// type when they agree
+			case ident.Value == "getOr" && len(n.Arguments) == 3:
+				if mapType, ok := c.inferDetailedType(n.Arguments[0]).(*MapType); ok {
+					if fallback := c.inferDetailedType(n.Arguments[2]); fallback.Equals(mapType.ValueType) {
+						return fallback
+					}
+				}
+			// Heap entries and removed elements have the array's element type
+			case (ident.Value == "heapPop" || ident.Value == "heapPeek" || ident.Value == "pop" || ident.Value == "removeAt") &&
+				len(n.Arguments) >= 1:
+				if arrayType, ok := c.inferDetailedType(n.Arguments[0]).(*ArrayType); ok {
+					return arrayType.ElementType
+				}
+			// Filled arrays hold their fill value
+			case ident.Value == "makeArray" && len(n.Arguments) == 2:
+				return &ArrayType{ElementType: c.inferDetailedType(n.Arguments[1])}
+			case ident.Value == "makeMatrix" && len(n.Arguments) == 3:
+				row := &ArrayType{ElementType: c.inferDetailedType(n.Arguments[2])}
+				return &ArrayType{ElementType: row}
+			// A string's code points are strings, as are the program's
+			// arguments, and its bytes ints, as are the values of a range
+			case ident.Value == "runes", ident.Value == "args":
+				return &ArrayType{ElementType: StringType}
+			case ident.Value == "bytes", ident.Value == "range":
+				return &ArrayType{ElementType: IntType}
+			// A map's keys and values have its key and value types
+			case (ident.Value == "keys" || ident.Value == "values") && len(n.Arguments) >= 1:
+				if mapType, ok := c.inferDetailedType(n.Arguments[0]).(*MapType); ok {
+					if ident.Value == "keys" {
+						return &ArrayType{ElementType: mapType.KeyType}
+					}
+					return &ArrayType{ElementType: mapType.ValueType}
+				}
+			// and its entries are (key, value) tuples
+			case ident.Value == "entries" && len(n.Arguments) == 1:
+				if mapType, ok := c.inferDetailedType(n.Arguments[0]).(*MapType); ok {
+					entry := &TupleType{Elements: []Type{mapType.KeyType, mapType.ValueType}}
+					return &ArrayType{ElementType: entry}
+				}
+			}
+		}
+		// Otherwise the function, or what it returns, is unknown
 		return AnyTypeVal
+
+	case *ast.IfExpression:
+		return c.inferBranchType(n.IfStatement)
+
+	case *ast.SwitchExpression:
+		return c.inferBranchType(n.SwitchStatement)
 	}
 
 	return AnyTypeVal
 }
 
-// checkValueType performs deep type checking for a value against an expected type
-func (c *Compiler) checkValueType(node ast.Expression, expectedType Type) error {
-	// Check array literals
-	if arrLit, ok := node.(*ast.ArrayLiteral); ok {
-		if arrType, ok := expectedType.(*ArrayType); ok {
-			// Check each element recursively
-			for i, elem := range arrLit.Elements {
-				// Recursively check if element itself is an array or map
-				if err := c.checkValueType(elem, arrType.ElementType); err != nil {
-					return fmt.Errorf("array element %d: %v", i, err)
-				}
-			}
-			return nil
+// branchValues returns the expressions giving the values of the branches of
+// an if or switch expression. A switch without a default covers every variant
+// of an enum, so it has no other value.
+func branchValues(node ast.Statement) []ast.Expression {
+	var blocks []*ast.BlockStatement
+	switch n := node.(type) {
+	case *ast.IfStatement:
+		blocks = []*ast.BlockStatement{n.Consequence, n.Alternative.(*ast.BlockStatement)}
+	case *ast.SwitchStatement:
+		for _, caseClause := range n.Cases {
+			blocks = append(blocks, caseClause.Body)
+		}
+		if n.Default != nil {
+			blocks = append(blocks, n.Default)
 		}
 	}
 
-	// Check map literals
-	if mapLit, ok := node.(*ast.MapLiteral); ok {
-		if mapType, ok := expectedType.(*MapType); ok {
-			// Check each key-value pair
-			for key, value := range mapLit.Pairs {
-				keyType := c.inferDetailedType(key)
-				if !IsAssignableTo(keyType, mapType.KeyType) {
-					return fmt.Errorf("map key has type %s, expected %s",
-						keyType.String(), mapType.KeyType.String())
-				}
+	values := make([]ast.Expression, len(blocks))
+	for i, block := range blocks {
+		values[i] = blockValue(block)
+	}
+	return values
+}
 
-				valueType := c.inferDetailedType(value)
-				if !IsAssignableTo(valueType, mapType.ValueType) {
-					return fmt.Errorf("map value has type %s, expected %s",
-						valueType.String(), mapType.ValueType.String())
-				}
-			}
-			return nil
+// blockValue returns the final expression of a branch of an if or switch
+// expression, which gives its value
+func blockValue(block *ast.BlockStatement) ast.Expression {
+	return block.Statements[len(block.Statements)-1].(*ast.ExpressionStatement).Expression
+}
+
+// inferBranchType unifies the types of the branches of an if or switch
+// expression: branches of one type give that type, a mix of ints and floats
+// gives float, and anything else gives any
+func (c *Compiler) inferBranchType(node ast.Statement) Type {
+	return branchType(c.inferDetailedType, node)
+}
+
+// branchType is inferBranchType with the types of the branches given by
+// infer, which the type checker shares
+func branchType(infer func(ast.Expression) Type, node ast.Statement) Type {
+	var result Type
+	for _, value := range branchValues(node) {
+		t := infer(value)
+		switch {
+		case result == nil || t.Equals(result):
+			result = t
+		case isNumber(t) && isNumber(result):
+			result = FloatType
+		default:
+			return AnyTypeVal
+		}
+	}
+	return result
+}
+
+// inferBranchValueType is inferBranchType for value types, where branches
+// that don't unify give the usual default of int
+func (c *Compiler) inferBranchValueType(node ast.Statement) vm.ValueType {
+	var result vm.ValueType
+	for i, value := range branchValues(node) {
+		t := c.inferExpressionType(value)
+		switch {
+		case i == 0 || t == result:
+			result = t
+		case (t == vm.IntType || t == vm.FloatType) && (result == vm.IntType || result == vm.FloatType):
+			result = vm.FloatType
+		default:
+			return vm.IntType
 		}
 	}
+	return result
+}
+
+// isNumber reports whether t is int or float
+func isNumber(t Type) bool {
+	return t.Equals(IntType) || t.Equals(FloatType)
+}
+
+// branchValue returns the expression to compile for the value of a branch of
+// an if or switch expression of type resultType. An int branch of a float
+// expression is converted with float, as the float opcodes expect one.
+func (c *Compiler) branchValue(block *ast.BlockStatement, resultType vm.ValueType) ast.Expression {
+	value := blockValue(block)
+	if resultType != vm.FloatType || !c.inferDetailedType(value).Equals(IntType) {
+		return value
+	}
+	if symbol, ok := c.symbolTable.Resolve("float"); !ok || symbol.Scope != BuiltinScope {
+		return value
+	}
+	return &ast.CallExpression{
+		Function:  &ast.Identifier{Value: "float"},
+		Arguments: []ast.Expression{value},
+	}
+}
+
+// checkValueType performs deep type checking for a value against an expected type
+func (c *Compiler) checkValueType(node ast.Expression, expectedType Type) error {
+	return checkValue(c.inferDetailedType, node, expectedType)
+}
+
+// The register compiler keeps the types recorded in a function body, for its
+// parameters, locals and nested functions, in maps of the body's own and
+// drops them when the body is done, so they don't leak into the code after
+// it. Lookups fall back to the types recorded outside.
+
+// lookupVarType returns the value type recorded for name, in the
+// innermost function body that has one
+func (c *Compiler) lookupVarType(name string) (vm.ValueType, bool) {
+	if t, ok := c.varTypes[name]; ok || c.outerTypes == nil {
+		return t, ok
+	}
+	return c.outerTypes.lookupVarType(name)
+}
+
+// lookupTypeInfo returns the type recorded for name, in the innermost
+// function body that has one
+func (c *Compiler) lookupTypeInfo(name string) (Type, bool) {
+	if t, ok := c.typeInfo[name]; ok || c.outerTypes == nil {
+		return t, ok
+	}
+	return c.outerTypes.lookupTypeInfo(name)
+}
 
-	// For other expressions, check basic type compatibility
-	valueType := c.inferDetailedType(node)
-	if !IsAssignableTo(valueType, expectedType) {
-		return fmt.Errorf("cannot assign value of type %s to type %s",
-			valueType.String(), expectedType.String())
+// lookupFunctionSig returns the signature recorded for the function name, in
+// the innermost function body that has one
+func (c *Compiler) lookupFunctionSig(name string) (*FunctionType, bool) {
+	if sig, ok := c.functionSigs[name]; ok || c.outerTypes == nil {
+		return sig, ok
 	}
+	return c.outerTypes.lookupFunctionSig(name)
+}
 
-	return nil
+// calleeSignature returns the signature of the function node calls, if it is
+// a function declared by name or a value known to be one, such as a variable
+// assigned a function
+func (c *Compiler) calleeSignature(node *ast.CallExpression) (*FunctionType, bool) {
+	if ident, ok := node.Function.(*ast.Identifier); ok {
+		if sig, ok := c.lookupFunctionSig(ident.Value); ok {
+			return sig, true
+		}
+	}
+	sig, ok := c.inferDetailedType(node.Function).(*FunctionType)
+	return sig, ok
 }
@@ -46,6 +46,16 @@ func (c *Compiler) emitTypedMul(leftType, rightType vm.ValueType) {
 
 // emitTypedDiv emits type-specialized division opcode
 func (c *Compiler) emitTypedDiv(leftType, rightType vm.ValueType) {
+	// Promoted division converts int operands at runtime
+	if c.promoteIntDiv {
+		if leftType == vm.FloatType && rightType == vm.FloatType {
+			c.emit(vm.OpDivFloat)
+		} else {
+			c.emit(vm.OpDivPromote)
+		}
+		return
+	}
+
 	// Float division (with promotion)
 	if leftType == vm.FloatType || rightType == vm.FloatType {
 		c.emit(vm.OpDivFloat)
@@ -64,6 +74,15 @@ func (c *Compiler) emitTypedMod(leftType, rightType vm.ValueType) {
 
 // emitTypedEq emits type-specialized equality opcode (Phase 2)
 func (c *Compiler) emitTypedEq(leftType, rightType vm.ValueType) {
+	if rightType == vm.NilType {
+		c.emit(vm.OpEq)
+		return
+	}
+	// An int compared with a float is promoted
+	if leftType == vm.IntType && rightType == vm.FloatType {
+		c.emit(vm.OpEqFloat)
+		return
+	}
 	// For equality, both operands should be the same type
 	// (type checker should ensure this)
 	switch leftType {
@@ -83,6 +102,15 @@ func (c *Compiler) emitTypedEq(leftType, rightType vm.ValueType) {
 
 // emitTypedNe emits type-specialized inequality opcode (Phase 2)
 func (c *Compiler) emitTypedNe(leftType, rightType vm.ValueType) {
+	if rightType == vm.NilType {
+		c.emit(vm.OpNe)
+		return
+	}
+	// An int compared with a float is promoted
+	if leftType == vm.IntType && rightType == vm.FloatType {
+		c.emit(vm.OpNeFloat)
+		return
+	}
 	switch leftType {
 	case vm.IntType:
 		c.emit(vm.OpNeInt)
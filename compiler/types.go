@@ -1,8 +1,8 @@
 package compiler
 
 import (
-	"fmt"
 	"minlang/ast"
+	"strings"
 )
 
 // Type represents a type in the type system
@@ -64,6 +64,8 @@ func (t *MapType) Equals(other Type) bool {
 type FunctionType struct {
 	ParamTypes []Type
 	ReturnType Type
+
+	ParamStructs []string // Struct type each parameter is declared as, or "", if known
 }
 
 func (t *FunctionType) String() string {
@@ -102,6 +104,31 @@ func (t *FunctionType) Equals(other Type) bool {
 	return false
 }
 
+// TupleType represents tuple types
+type TupleType struct {
+	Elements []Type
+}
+
+func (t *TupleType) String() string {
+	elements := make([]string, len(t.Elements))
+	for i, el := range t.Elements {
+		elements[i] = el.String()
+	}
+	return "(" + strings.Join(elements, ", ") + ")"
+}
+
+func (t *TupleType) Equals(other Type) bool {
+	if ot, ok := other.(*TupleType); ok && len(t.Elements) == len(ot.Elements) {
+		for i := range t.Elements {
+			if !t.Elements[i].Equals(ot.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
 // AnyType represents unknown/any type
 type AnyType struct{}
 
@@ -121,37 +148,56 @@ var (
 	BoolType   = &BasicType{Name: "bool"}
 	StringType = &BasicType{Name: "string"}
 	NilType    = &BasicType{Name: "nil"}
+	ErrorType  = &BasicType{Name: "error"}
 	AnyTypeVal = &AnyType{}
 )
 
 // ConvertASTType converts an AST type annotation to a compiler type
 func ConvertASTType(astType *ast.TypeAnnotation) Type {
+	return convertType(astType, nil)
+}
+
+// convertType is ConvertASTType where the names in typeArgs, the type
+// parameters of a generic function, stand for the types they map to
+func convertType(astType *ast.TypeAnnotation, typeArgs map[string]Type) Type {
 	if astType == nil {
 		return AnyTypeVal
 	}
 
 	if astType.IsArray {
-		return &ArrayType{ElementType: ConvertASTType(astType.ElementType)}
+		return &ArrayType{ElementType: convertType(astType.ElementType, typeArgs)}
 	}
 
 	if astType.IsMap {
 		return &MapType{
-			KeyType:   ConvertASTType(astType.KeyType),
-			ValueType: ConvertASTType(astType.ValueType),
+			KeyType:   convertType(astType.KeyType, typeArgs),
+			ValueType: convertType(astType.ValueType, typeArgs),
 		}
 	}
 
 	if astType.IsFunction {
 		params := make([]Type, len(astType.ParamTypes))
 		for i, p := range astType.ParamTypes {
-			params[i] = ConvertASTType(p)
+			params[i] = convertType(p, typeArgs)
 		}
 		return &FunctionType{
 			ParamTypes: params,
-			ReturnType: ConvertASTType(astType.ValueType),
+			ReturnType: convertType(astType.ValueType, typeArgs),
 		}
 	}
 
+	if astType.IsTuple {
+		elements := make([]Type, len(astType.Elements))
+		for i, el := range astType.Elements {
+			elements[i] = convertType(el, typeArgs)
+		}
+		return &TupleType{Elements: elements}
+	}
+
+	if t, ok := typeArgs[astType.Name]; ok {
+		return t
+	}
+
 	// Basic type
 	switch astType.Name {
 	case "int":
@@ -162,6 +208,8 @@ func ConvertASTType(astType *ast.TypeAnnotation) Type {
 		return BoolType
 	case "string":
 		return StringType
+	case "error":
+		return ErrorType
 	default:
 		// Unknown type, treat as any
 		return AnyTypeVal
@@ -186,6 +234,22 @@ func IsAssignableTo(from, to Type) bool {
 		}
 	}
 
+	// Tuples are assignable element by element, so (n, nil) is an
+	// (int, error). Their ints aren't promoted, as t.0 reads what the tuple
+	// holds.
+	if fromTuple, ok := from.(*TupleType); ok {
+		toTuple, ok := to.(*TupleType)
+		if !ok || len(fromTuple.Elements) != len(toTuple.Elements) {
+			return false
+		}
+		for i, el := range fromTuple.Elements {
+			if !IsAssignableTo(el, toTuple.Elements[i]) || el.Equals(IntType) && toTuple.Elements[i].Equals(FloatType) {
+				return false
+			}
+		}
+		return true
+	}
+
 	// Int can be promoted to float
 	if fromBasic, ok := from.(*BasicType); ok {
 		if toBasic, ok2 := to.(*BasicType); ok2 {
@@ -202,7 +266,14 @@ func IsAssignableTo(from, to Type) bool {
 type TypeChecker struct {
 	symbolTable *SymbolTable
 	errors      []string
-	typeMap     map[string]Type // Maps variable names to their types
+	typeMap     map[string]Type         // Maps variable names to their types
+	declared    map[string]Type         // Types variables were annotated with, which assignments must keep to
+	returnType  Type                    // Return type of the function being checked, nil outside functions
+	branchTypes map[ast.Expression]Type // Types of the if and switch expressions checked, see BranchType
+	structs     map[string]bool         // Names of the struct types declared
+	floatMath   map[ast.Expression]bool // Arithmetic checked with a float operand, see FloatArithmetic
+
+	PromoteIntDiv bool // "/" between ints yields float (see Compiler.SetPromoteIntDiv)
 }
 
 // NewTypeChecker creates a new type checker
@@ -211,6 +282,10 @@ func NewTypeChecker() *TypeChecker {
 		symbolTable: NewSymbolTable(),
 		errors:      []string{},
 		typeMap:     make(map[string]Type),
+		declared:    make(map[string]Type),
+		branchTypes: make(map[ast.Expression]Type),
+		structs:     make(map[string]bool),
+		floatMath:   make(map[ast.Expression]bool),
 	}
 }
 
@@ -256,6 +331,14 @@ func (tc *TypeChecker) InferType(node ast.Expression) Type {
 		elemType := tc.InferType(node.Elements[0])
 		return &ArrayType{ElementType: elemType}
 
+	case *ast.MapLiteral:
+		if len(node.Pairs) == 0 {
+			return &MapType{KeyType: AnyTypeVal, ValueType: AnyTypeVal}
+		}
+		// Infer from first pair
+		firstKey := node.Keys[0]
+		return &MapType{KeyType: tc.InferType(firstKey), ValueType: tc.InferType(node.Pairs[firstKey])}
+
 	case *ast.InfixExpression:
 		left := tc.InferType(node.Left)
 		right := tc.InferType(node.Right)
@@ -270,18 +353,28 @@ func (tc *TypeChecker) InferType(node ast.Expression) Type {
 			if left.Equals(FloatType) || right.Equals(FloatType) {
 				return FloatType
 			}
+			if left.Equals(AnyTypeVal) || right.Equals(AnyTypeVal) {
+				return AnyTypeVal
+			}
 			return IntType
 
 		case "-", "*", "/", "%":
 			if left.Equals(FloatType) || right.Equals(FloatType) {
 				return FloatType
 			}
+			if node.Operator == "/" && tc.PromoteIntDiv {
+				return FloatType
+			}
+			// An operand of unknown type may be a float
+			if left.Equals(AnyTypeVal) || right.Equals(AnyTypeVal) {
+				return AnyTypeVal
+			}
 			return IntType
 
 		case "==", "!=", "<", ">", "<=", ">=":
 			return BoolType
 
-		case "&&", "||":
+		case "&&", "||", "in":
 			return BoolType
 
 		default:
@@ -310,10 +403,37 @@ func (tc *TypeChecker) InferType(node ast.Expression) Type {
 		}
 		return AnyTypeVal
 
+	case *ast.SliceExpression:
+		return tc.InferType(node.Left)
+
+	case *ast.CastExpression:
+		return ConvertASTType(node.Type)
+
+	case *ast.TupleLiteral:
+		elements := make([]Type, len(node.Elements))
+		for i, el := range node.Elements {
+			elements[i] = tc.InferType(el)
+		}
+		return &TupleType{Elements: elements}
+
+	case *ast.TupleElementExpression:
+		if tuple, ok := tc.InferType(node.Left).(*TupleType); ok && node.Index < len(tuple.Elements) {
+			return tuple.Elements[node.Index]
+		}
+		return AnyTypeVal
+
 	case *ast.CallExpression:
-		// For now, assume functions return any type
-		// Would need to track function signatures
+		// A function whose signature is known returns its return type
+		if sig, ok := tc.InferType(node.Function).(*FunctionType); ok {
+			return sig.ReturnType
+		}
 		return AnyTypeVal
+
+	case *ast.IfExpression:
+		return branchType(tc.InferType, node.IfStatement)
+
+	case *ast.SwitchExpression:
+		return branchType(tc.InferType, node.SwitchStatement)
 	}
 
 	return AnyTypeVal
@@ -321,43 +441,49 @@ func (tc *TypeChecker) InferType(node ast.Expression) Type {
 
 // CheckVarStatement checks a variable statement
 func (tc *TypeChecker) CheckVarStatement(stmt *ast.VarStatement) {
-	var declaredType Type
-	if stmt.Type != nil {
-		declaredType = ConvertASTType(stmt.Type)
-	} else {
-		declaredType = AnyTypeVal
+	tc.checkExpression(stmt.Value)
+	if stmt.Type == nil {
+		valueType := Type(AnyTypeVal)
+		if stmt.Value != nil {
+			valueType = tc.InferType(stmt.Value)
+		}
+		tc.bind(stmt.Name.Value, valueType)
+		return
 	}
 
+	declaredType := ConvertASTType(stmt.Type)
 	if stmt.Value != nil {
-		valueType := tc.InferType(stmt.Value)
-
-		// Check if value type is assignable to declared type
-		if !IsAssignableTo(valueType, declaredType) && !declaredType.Equals(AnyTypeVal) {
-			tc.AddError(fmt.Sprintf("cannot assign value of type %s to variable %s of type %s",
-				valueType.String(), stmt.Name.Value, declaredType.String()))
+		if err := checkValue(tc.InferType, stmt.Value, declaredType); err != nil {
+			tc.errorf(stmt, "%v", err)
 		}
-
-		// Store the actual type (or declared if more specific)
-		if declaredType.Equals(AnyTypeVal) {
-			tc.typeMap[stmt.Name.Value] = valueType
-		} else {
-			tc.typeMap[stmt.Name.Value] = declaredType
-		}
-	} else {
-		tc.typeMap[stmt.Name.Value] = declaredType
 	}
+	tc.declare(stmt.Name.Value, declaredType)
 }
 
 // CheckAssignment checks an assignment
 func (tc *TypeChecker) CheckAssignment(stmt *ast.AssignmentStatement) {
-	valueType := tc.InferType(stmt.Value)
+	tc.checkExpression(stmt.Left)
+	tc.checkExpression(stmt.Value)
+
+	// x++ on a non-number gets the error for that, not for x + 1
+	if isIncrement(stmt) {
+		if err := checkIncrementType(tc.InferType, stmt); err != nil {
+			tc.errorf(stmt, "%v", err)
+			return
+		}
+	}
 
-	if ident, ok := stmt.Left.(*ast.Identifier); ok {
-		if varType, exists := tc.typeMap[ident.Value]; exists {
-			if !IsAssignableTo(valueType, varType) {
-				tc.AddError(fmt.Sprintf("cannot assign value of type %s to variable %s of type %s",
-					valueType.String(), ident.Value, varType.String()))
+	switch left := stmt.Left.(type) {
+	case *ast.Identifier:
+		if varType, exists := tc.declared[left.Value]; exists {
+			if valueType := tc.InferType(stmt.Value); !IsAssignableTo(valueType, varType) {
+				tc.errorf(stmt, "cannot assign value of type %s to variable %s of type %s",
+					valueType.String(), left.Value, varType.String())
 			}
 		}
+	case *ast.IndexExpression:
+		if err := checkElementAssignment(tc.InferType, left, stmt.Value); err != nil {
+			tc.errorf(stmt, "%v", err)
+		}
 	}
 }
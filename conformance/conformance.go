@@ -0,0 +1,272 @@
+// Package conformance runs the programs in this directory against every
+// execution backend and reports which language rules each backend honours.
+//
+// Each rule is a pair of files sharing a base name:
+//
+//	name.min  a small program exercising exactly one language rule
+//	name.out  the exact text the program must print
+//	name.err  (instead of .out) a substring of the error it must stop with, when
+//	          compiled or as it runs
+package conformance
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"minlang/ast"
+	"minlang/compiler"
+	"minlang/interp"
+	"minlang/lexer"
+	"minlang/parser"
+	"minlang/vm"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Backend executes a parsed program, printing to stdout
+type Backend struct {
+	Name string
+	Run  func(program *ast.Program) error
+}
+
+// Backends lists every execution backend, stack VM (the reference) first
+var Backends = []Backend{
+	{Name: "stack", Run: runStack},
+	{Name: "register", Run: runRegister},
+	{Name: "translated", Run: runTranslated},
+	{Name: "interp", Run: runInterp},
+}
+
+func runStack(program *ast.Program) error {
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		return fmt.Errorf("compile: %w", err)
+	}
+	return vm.New(c.Bytecode()).Run()
+}
+
+// runRegister uses the native register compiler only, without falling back
+// to translation, so the matrix shows what it supports on its own
+func runRegister(program *ast.Program) error {
+	rc := compiler.NewRegisterCompiler()
+	if _, err := rc.CompileToRegister(program); err != nil {
+		return fmt.Errorf("compile: %w", err)
+	}
+	return vm.NewRegisterVM(rc.RegisterBytecode()).Run()
+}
+
+func runTranslated(program *ast.Program) error {
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		return fmt.Errorf("compile: %w", err)
+	}
+	registerBytecode, err := vm.TranslateToRegister(c.Bytecode())
+	if err != nil {
+		return fmt.Errorf("translate: %w", err)
+	}
+	return vm.NewRegisterVM(registerBytecode).Run()
+}
+
+func runInterp(program *ast.Program) error {
+	return interp.New().Run(program)
+}
+
+// Case is one conformance program with its expectation
+type Case struct {
+	Name        string
+	Source      string
+	Expected    string // Expected stdout when ExpectError is empty
+	ExpectError string // Substring of the expected runtime error
+}
+
+// LoadCases reads every .min file in dir together with its .out or .err file
+func LoadCases(dir string) ([]Case, error) {
+	cases, err := LoadCasesFS(os.DirFS(dir))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", dir, err)
+	}
+	return cases, nil
+}
+
+// LoadCasesFS reads every .min file at the root of fsys together with its
+// .out or .err file
+func LoadCasesFS(fsys fs.FS) ([]Case, error) {
+	files, err := fs.Glob(fsys, "*.min")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	cases := make([]Case, 0, len(files))
+	for _, file := range files {
+		source, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		base := strings.TrimSuffix(file, ".min")
+		c := Case{Name: base, Source: string(source)}
+
+		if expected, err := fs.ReadFile(fsys, base+".out"); err == nil {
+			c.Expected = string(expected)
+		} else if expectErr, err := fs.ReadFile(fsys, base+".err"); err == nil {
+			c.ExpectError = strings.TrimSpace(string(expectErr))
+		} else {
+			return nil, fmt.Errorf("%s: missing .out or .err file", file)
+		}
+
+		cases = append(cases, c)
+	}
+	return cases, nil
+}
+
+// Status is the outcome of running one case on one backend
+type Status int
+
+const (
+	Pass  Status = iota
+	Fail         // Ran, but printed the wrong output or raised the wrong error
+	Error        // Could not compile or failed unexpectedly
+)
+
+func (s Status) String() string {
+	switch s {
+	case Pass:
+		return "pass"
+	case Fail:
+		return "FAIL"
+	default:
+		return "error"
+	}
+}
+
+// Result is the outcome of a case on a backend, with detail on mismatch
+type Result struct {
+	Status Status
+	Detail string
+}
+
+// RunCase parses and executes a case on one backend
+func RunCase(c Case, backend Backend) Result {
+	p := parser.New(lexer.New(c.Source))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return Result{Status: Error, Detail: "parse: " + strings.Join(p.Errors(), "; ")}
+	}
+
+	output, err := captureOutput(func() error { return backend.Run(program) })
+
+	if c.ExpectError != "" {
+		if err == nil {
+			return Result{Status: Fail, Detail: fmt.Sprintf("expected error containing %q", c.ExpectError)}
+		}
+		if !strings.Contains(err.Error(), c.ExpectError) {
+			return Result{Status: Fail, Detail: err.Error()}
+		}
+		return Result{Status: Pass}
+	}
+
+	if err != nil {
+		return Result{Status: Error, Detail: err.Error()}
+	}
+	if output != c.Expected {
+		return Result{Status: Fail, Detail: fmt.Sprintf("got %q, want %q", output, c.Expected)}
+	}
+	return Result{Status: Pass}
+}
+
+// captureOutput runs fn with stdout redirected to a pipe
+func captureOutput(fn func() error) (output string, err error) {
+	oldStdout := os.Stdout
+	r, w, pipeErr := os.Pipe()
+	if pipeErr != nil {
+		return "", pipeErr
+	}
+	os.Stdout = w
+
+	// Drain concurrently so large outputs can't fill the pipe and block
+	done := make(chan string)
+	go func() {
+		var buf bytes.Buffer
+		io.Copy(&buf, r)
+		done <- buf.String()
+	}()
+
+	defer func() {
+		// Backends may panic on unsupported input; report it as an error
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic: %v", p)
+		}
+		w.Close()
+		os.Stdout = oldStdout
+		output = <-done
+		r.Close()
+	}()
+
+	return "", fn()
+}
+
+// Matrix holds results indexed by case, then backend
+type Matrix struct {
+	Cases    []Case
+	Backends []Backend
+	Results  [][]Result
+}
+
+// RunAll runs every case against every backend
+func RunAll(cases []Case, backends []Backend) *Matrix {
+	m := &Matrix{Cases: cases, Backends: backends}
+	m.Results = make([][]Result, len(cases))
+	for i, c := range cases {
+		m.Results[i] = make([]Result, len(backends))
+		for j, b := range backends {
+			m.Results[i][j] = RunCase(c, b)
+		}
+	}
+	return m
+}
+
+// Passed returns how many cases the backend at index j passes
+func (m *Matrix) Passed(j int) int {
+	passed := 0
+	for i := range m.Cases {
+		if m.Results[i][j].Status == Pass {
+			passed++
+		}
+	}
+	return passed
+}
+
+// WriteMarkdown writes the compatibility matrix as a Markdown table
+func (m *Matrix) WriteMarkdown(w io.Writer) error {
+	var out strings.Builder
+
+	out.WriteString("| Rule |")
+	for _, b := range m.Backends {
+		out.WriteString(" " + b.Name + " |")
+	}
+	out.WriteString("\n|------|")
+	for range m.Backends {
+		out.WriteString("------|")
+	}
+	out.WriteString("\n")
+
+	for i, c := range m.Cases {
+		out.WriteString("| " + c.Name + " |")
+		for j := range m.Backends {
+			out.WriteString(" " + m.Results[i][j].Status.String() + " |")
+		}
+		out.WriteString("\n")
+	}
+
+	out.WriteString("| **total** |")
+	for j := range m.Backends {
+		out.WriteString(fmt.Sprintf(" %d/%d |", m.Passed(j), len(m.Cases)))
+	}
+	out.WriteString("\n")
+
+	_, err := io.WriteString(w, out.String())
+	return err
+}
@@ -0,0 +1,89 @@
+package conformance
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+)
+
+// The native register compiler is still catching up, so its gaps are
+// reported by the matrix rather than failing the build
+var requiredBackends = map[string]bool{
+	"stack":      true,
+	"translated": true,
+	"interp":     true,
+}
+
+func TestConformance(t *testing.T) {
+	cases, err := LoadCases(".")
+	if err != nil {
+		t.Fatalf("loading cases: %v", err)
+	}
+	if len(cases) == 0 {
+		t.Fatal("no conformance cases found")
+	}
+
+	for _, c := range cases {
+		t.Run(c.Name, func(t *testing.T) {
+			for _, backend := range Backends {
+				result := RunCase(c, backend)
+				if result.Status == Pass {
+					continue
+				}
+				if requiredBackends[backend.Name] {
+					t.Errorf("[%s] %s: %s", backend.Name, result.Status, result.Detail)
+				} else {
+					t.Logf("[%s] %s: %s", backend.Name, result.Status, result.Detail)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadCasesRequiresExpectation(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "lonely.min"), []byte("print(1);"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadCases(dir); err == nil {
+		t.Fatal("expected error for case without .out or .err file")
+	}
+}
+
+func TestLoadCasesFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"b_prints.min":    {Data: []byte(`print("hi");`)},
+		"b_prints.out":    {Data: []byte("hi\n")},
+		"a_divides.min":   {Data: []byte(`print(1 / 0);`)},
+		"a_divides.err":   {Data: []byte("division by zero\n")},
+		"sub/ignored.min": {Data: []byte(`print(2);`)},
+	}
+
+	cases, err := LoadCasesFS(fsys)
+	if err != nil {
+		t.Fatalf("LoadCasesFS: %v", err)
+	}
+	if len(cases) != 2 {
+		t.Fatalf("expected 2 cases from the root, got %d", len(cases))
+	}
+	if c := cases[0]; c.Name != "a_divides" || c.ExpectError != "division by zero" {
+		t.Errorf("expected a_divides expecting an error first, got %+v", c)
+	}
+	if c := cases[1]; c.Name != "b_prints" || c.Source != `print("hi");` || c.Expected != "hi\n" {
+		t.Errorf("expected b_prints expecting output second, got %+v", c)
+	}
+}
+
+func TestMatrixTotals(t *testing.T) {
+	cases := []Case{
+		{Name: "prints", Source: `print("hi");`, Expected: "hi\n"},
+		{Name: "fails", Source: `var zero: int = 0; print(1 / zero);`, ExpectError: "division by zero"},
+	}
+
+	matrix := RunAll(cases, Backends[:1])
+	if got := matrix.Passed(0); got != 2 {
+		t.Errorf("expected 2 passing cases, got %d", got)
+	}
+}
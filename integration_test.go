@@ -2,12 +2,16 @@ package minlang_test
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"minlang/compiler"
+	"minlang/interp"
 	"minlang/lexer"
 	"minlang/parser"
 	"minlang/vm"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -16,11 +20,6 @@ import (
 func runProgram(t *testing.T, source string) (string, error) {
 	t.Helper()
 
-	// Capture stdout
-	oldStdout := os.Stdout
-	r, w, _ := os.Pipe()
-	os.Stdout = w
-
 	// Lex
 	l := lexer.New(source)
 
@@ -29,7 +28,6 @@ func runProgram(t *testing.T, source string) (string, error) {
 	program := p.ParseProgram()
 
 	if len(p.Errors()) > 0 {
-		os.Stdout = oldStdout
 		return "", nil
 	}
 
@@ -37,27 +35,20 @@ func runProgram(t *testing.T, source string) (string, error) {
 	c := compiler.New()
 	err := c.Compile(program)
 	if err != nil {
-		os.Stdout = oldStdout
 		return "", err
 	}
 
-	// Run
-	machine := vm.New(c.Bytecode())
-	err = machine.Run()
-
-	// Restore stdout and capture output
-	w.Close()
-	os.Stdout = oldStdout
-
+	// Run, capturing output
 	var buf bytes.Buffer
-	io.Copy(&buf, r)
+	machine := vm.New(c.Bytecode(), vm.WithStdout(&buf))
+	err = machine.Run()
 
 	if err != nil {
 		return buf.String(), err
 	}
 
 	// Get result
-	result := machine.LastPoppedStackElem()
+	result := machine.LastValue()
 	output := buf.String()
 
 	// Append result if not nil
@@ -133,6 +124,747 @@ func TestExamplePrograms(t *testing.T) {
 	}
 }
 
+// TestTranslatedExamples checks that stack bytecode translated to register
+// bytecode prints the same output as the stack VM
+func TestTranslatedExamples(t *testing.T) {
+	files := []string{
+		"examples/arithmetic.min",
+		"examples/factorial.min",
+		"examples/fibonacci.min",
+		"examples/array_demo.min",
+		"examples/struct_demo.min",
+		"examples/enum_simple.min",
+		"examples/nested_functions.min",
+		"examples/switch_simple.min",
+		"examples/break_continue_demo.min",
+		"examples/string_ops.min",
+		"examples/prime_check.min",
+	}
+
+	for _, file := range files {
+		t.Run(file, func(t *testing.T) {
+			source, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatalf("Failed to read %s: %v", file, err)
+			}
+
+			p := parser.New(lexer.New(string(source)))
+			program := p.ParseProgram()
+			if len(p.Errors()) > 0 {
+				t.Fatalf("Parse errors: %v", p.Errors())
+			}
+
+			c := compiler.New()
+			if err := c.Compile(program); err != nil {
+				t.Fatalf("Compile error: %v", err)
+			}
+
+			registerBytecode, err := vm.TranslateToRegister(c.Bytecode())
+			if err != nil {
+				t.Fatalf("Translation error: %v", err)
+			}
+
+			var expected, output bytes.Buffer
+			if err := vm.New(c.Bytecode(), vm.WithStdout(&expected)).Run(); err != nil {
+				t.Fatalf("Stack VM error: %v", err)
+			}
+			if err := vm.NewRegisterVM(registerBytecode, vm.WithStdout(&output)).Run(); err != nil {
+				t.Fatalf("Register VM error: %v", err)
+			}
+
+			if output.String() != expected.String() {
+				t.Errorf("Output mismatch\nstack:\n%s\nregister:\n%s", expected.String(), output.String())
+			}
+		})
+	}
+}
+
+// TestLastValue checks that every backend gives a program's result, the
+// value of the last expression statement it ran outside functions, or nil
+func TestLastValue(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{"2 + 2", "4"},
+		{`var x = 3
+x * 2
+var y = x + 1`, "6"},
+		{`func double(n: int): int {
+    n * 10
+    return n * 2
+}
+double(21)`, "42"},
+		{`func f(): int {
+    99
+    return 1
+}
+1 + 2
+var y = f()`, "3"},
+		{`var x = 1
+print(x)`, "nil"},
+		{"var x = 1", "nil"},
+		{"1 + 2; var x = 5;", "3"},
+		{"var x = 5; x = 7;", "nil"},
+		{"var x = 5; x++", "nil"},
+		{"1 + 2\nprint(3)", "nil"},
+		{`for i in range(3) {
+    i * 10
+}`, "20"},
+		{`var total = 0
+if total == 0 {
+    "empty"
+}
+switch total {
+case 0 { total = 1 }
+default { total = 2 }
+}`, "empty"},
+		{`func bye() {
+    "hook"
+}
+onExit(bye)
+"main"`, "main"},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.source))
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("Parse errors: %v", p.Errors())
+		}
+
+		c := compiler.New()
+		if err := c.Compile(program); err != nil {
+			t.Fatalf("Compile error: %v", err)
+		}
+		rc := compiler.NewRegisterCompiler()
+		_, registerErr := rc.CompileToRegister(program)
+		translated, err := vm.TranslateToRegister(c.Bytecode())
+		if err != nil {
+			t.Fatalf("Translation error: %v", err)
+		}
+
+		backends := map[string]func() (vm.Value, error){
+			"stack": func() (vm.Value, error) {
+				machine := vm.New(c.Bytecode(), vm.WithStdout(io.Discard))
+				err := machine.Run()
+				return machine.LastValue(), err
+			},
+			"optimized stack": func() (vm.Value, error) {
+				machine := vm.New(vm.Optimize(c.Bytecode()), vm.WithStdout(io.Discard))
+				err := machine.Run()
+				return machine.LastValue(), err
+			},
+			"register": func() (vm.Value, error) {
+				machine := vm.NewRegisterVM(rc.RegisterBytecode(), vm.WithStdout(io.Discard))
+				err := machine.Run()
+				return machine.LastValue(), err
+			},
+			"translated": func() (vm.Value, error) {
+				machine := vm.NewRegisterVM(translated, vm.WithStdout(io.Discard))
+				err := machine.Run()
+				return machine.LastValue(), err
+			},
+			"interp": func() (vm.Value, error) {
+				in := interp.New()
+				in.SetStdout(io.Discard)
+				err := in.Run(program)
+				return in.LastValue(), err
+			},
+		}
+		// Programs the register compiler can't compile yet run translated
+		if registerErr != nil {
+			delete(backends, "register")
+		}
+		for name, run := range backends {
+			result, err := run()
+			if err != nil {
+				t.Fatalf("%s: %q: %v", name, tt.source, err)
+			}
+			if got := result.String(); got != tt.expected {
+				t.Errorf("%s: %q: expected %s, got %s", name, tt.source, tt.expected, got)
+			}
+		}
+	}
+}
+
+// TestOptimizedExamples checks that the peephole optimizer preserves the
+// output, errors and result of every example program on both VMs
+func TestOptimizedExamples(t *testing.T) {
+	files, err := filepath.Glob("examples/*.min")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Skip the long-running benchmarks
+	skip := map[string]bool{
+		"examples/fibonacci_heavy.min":         true,
+		"examples/mandelbrot_benchmark.min":    true,
+		"examples/mandelbrot_heavy.min":        true,
+		"examples/mandelbrot_heavy_modern.min": true,
+		"examples/simple_bench.min":            true,
+	}
+
+	for _, file := range files {
+		if skip[file] {
+			continue
+		}
+		t.Run(file, func(t *testing.T) {
+			source, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatalf("Failed to read %s: %v", file, err)
+			}
+
+			p := parser.New(lexer.New(string(source)))
+			program := p.ParseProgram()
+			c := compiler.New()
+			if len(p.Errors()) > 0 || c.Compile(program) != nil {
+				t.Skip("example does not compile")
+			}
+			bytecode := c.Bytecode()
+			optimized := vm.Optimize(bytecode)
+
+			run := func(b *vm.Bytecode) (string, string, vm.Value) {
+				var out bytes.Buffer
+				machine := vm.New(b, vm.WithStdout(&out))
+				errText := ""
+				if err := machine.Run(); err != nil {
+					errText = err.Error()
+				}
+				return out.String(), errText, machine.LastValue()
+			}
+			wantOut, wantErr, wantResult := run(bytecode)
+			gotOut, gotErr, gotResult := run(optimized)
+			if gotOut != wantOut || gotErr != wantErr {
+				t.Errorf("optimized stack VM differs\nwant %q (%s)\ngot %q (%s)", wantOut, wantErr, gotOut, gotErr)
+			}
+			if gotResult.String() != wantResult.String() {
+				t.Errorf("optimized result differs: want %s, got %s", wantResult.String(), gotResult.String())
+			}
+
+			if wantErr != "" {
+				return
+			}
+			translated, err := vm.TranslateToRegister(optimized)
+			if err != nil {
+				t.Fatalf("Translation error: %v", err)
+			}
+			var out bytes.Buffer
+			if err := vm.NewRegisterVM(translated, vm.WithStdout(&out)).Run(); err != nil {
+				t.Fatalf("Register VM error: %v", err)
+			}
+			if out.String() != wantOut {
+				t.Errorf("optimized register VM differs\nwant %q\ngot %q", wantOut, out.String())
+			}
+		})
+	}
+}
+
+// TestRuntimeErrorPositions checks that runtime errors report the source
+// position of the failing operation and the call stack on every backend
+func TestRuntimeErrorPositions(t *testing.T) {
+	source := `func get(xs: []int, i: int): int {
+    return xs[i];
+}
+var xs: []int = [1, 2, 3];
+print(get(xs, 7));`
+
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("Parse errors: %v", p.Errors())
+	}
+
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+	translated, err := vm.TranslateToRegister(c.Bytecode())
+	if err != nil {
+		t.Fatalf("Translation error: %v", err)
+	}
+	rc := compiler.NewRegisterCompiler()
+	if _, err := rc.CompileToRegister(program); err != nil {
+		t.Fatalf("Register compile error: %v", err)
+	}
+
+	backends := map[string]func() error{
+		"stack":      vm.New(c.Bytecode()).Run,
+		"register":   vm.NewRegisterVM(rc.RegisterBytecode()).Run,
+		"translated": vm.NewRegisterVM(translated).Run,
+		"interp":     func() error { return interp.New().Run(program) },
+	}
+
+	for name, run := range backends {
+		t.Run(name, func(t *testing.T) {
+			err := run()
+			if err == nil {
+				t.Fatal("Expected error but got none")
+			}
+			want := "prog.min:2:14: array index out of bounds: 7"
+			if got := vm.WithSourceFile(err, "prog.min").Error(); got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+
+			var runtimeErr *vm.RuntimeError
+			if !errors.As(err, &runtimeErr) {
+				t.Fatalf("expected RuntimeError, got %T", err)
+			}
+			wantTrace := "  at get (prog.min:2:14)\n  at main (prog.min:5:10)\n"
+			if got := runtimeErr.StackTrace(); got != wantTrace {
+				t.Errorf("trace got %q, want %q", got, wantTrace)
+			}
+		})
+	}
+}
+
+// TestExitHookFailures checks that an exit hook that fails doesn't stop the
+// hooks after it, and that the program ends with the errors of all that
+// failed, each with its position, on every backend
+func TestExitHookFailures(t *testing.T) {
+	source := `func second() {
+    print("second")
+    panic("second failed")
+}
+func first() {
+    print("first")
+    var xs = [1]
+    print(xs[5])
+}
+func last() {
+    print("last")
+}
+onExit(last)
+onExit(second)
+onExit(first)
+print("main")`
+
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("Parse errors: %v", p.Errors())
+	}
+
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+	translated, err := vm.TranslateToRegister(c.Bytecode())
+	if err != nil {
+		t.Fatalf("Translation error: %v", err)
+	}
+	rc := compiler.NewRegisterCompiler()
+	if _, err := rc.CompileToRegister(program); err != nil {
+		t.Fatalf("Register compile error: %v", err)
+	}
+
+	backends := map[string]func(out io.Writer) error{
+		"stack": func(out io.Writer) error {
+			return vm.New(c.Bytecode(), vm.WithStdout(out)).Run()
+		},
+		"register": func(out io.Writer) error {
+			return vm.NewRegisterVM(rc.RegisterBytecode(), vm.WithStdout(out)).Run()
+		},
+		"translated": func(out io.Writer) error {
+			return vm.NewRegisterVM(translated, vm.WithStdout(out)).Run()
+		},
+		"interp": func(out io.Writer) error {
+			in := interp.New()
+			in.SetStdout(out)
+			return in.Run(program)
+		},
+	}
+
+	for name, run := range backends {
+		t.Run(name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := run(&out)
+			if want := "main\nfirst\nsecond\nlast\n"; out.String() != want {
+				t.Errorf("output got %q, want %q", out.String(), want)
+			}
+			want := "8:13: array index out of bounds: 5\n3:10: panic: second failed"
+			if err == nil || err.Error() != want {
+				t.Fatalf("error got %v, want %q", err, want)
+			}
+			var runtimeErr *vm.RuntimeError
+			if !errors.As(err, &runtimeErr) || runtimeErr.Pos.Line != 8 {
+				t.Errorf("expected the first hook's RuntimeError first, got %#v", err)
+			}
+		})
+	}
+}
+
+// TestRuntimeChecks checks that with runtime checks on, a value that type
+// inference lets through with the wrong type stops the program where it
+// enters or leaves a function, on every bytecode backend
+func TestRuntimeChecks(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		want   string // Expected error, or "" for a program that passes its checks
+	}{
+		{
+			name: "argument",
+			source: `func double(n: int): int {
+    return n * 2;
+}
+var words = split("3 4", " ");
+print(double(words[0]));`,
+			want: "prog.min:1:1: parameter n of double must be int, got string",
+		},
+		{
+			name: "return value",
+			source: `func first(line: string): float {
+    var fields = split(line, ",");
+    return fields[0];
+}
+print(first("1.5,2") + 1.0);`,
+			want: "prog.min:3:5: return value of first must be float, got string",
+		},
+		{
+			name: "matching types",
+			source: `func scale(xs: []float, k: float): []float {
+    var out: []float = [];
+    for var i = 0; i < len(xs); i = i + 1 {
+        out = append(out, xs[i] * k);
+    }
+    return out;
+}
+func name(id: int, names: map[int]string): string {
+    return names[id];
+}
+print(scale([1.0, 2.5], 2.0)[1], name(1, map[int]string{1: "one"}));`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := parser.New(lexer.New(tt.source))
+			program := p.ParseProgram()
+			if len(p.Errors()) > 0 {
+				t.Fatalf("Parse errors: %v", p.Errors())
+			}
+
+			c := compiler.New()
+			c.SetRuntimeChecks(true)
+			if err := c.Compile(program); err != nil {
+				t.Fatalf("Compile error: %v", err)
+			}
+			translated, err := vm.TranslateToRegister(vm.Optimize(c.Bytecode()))
+			if err != nil {
+				t.Fatalf("Translation error: %v", err)
+			}
+			rc := compiler.NewRegisterCompiler()
+			rc.SetRuntimeChecks(true)
+			if _, err := rc.CompileToRegister(program); err != nil {
+				t.Fatalf("Register compile error: %v", err)
+			}
+
+			var out bytes.Buffer
+			backends := map[string]func() error{
+				"stack":      vm.New(c.Bytecode(), vm.WithStdout(&out)).Run,
+				"register":   vm.NewRegisterVM(rc.RegisterBytecode(), vm.WithStdout(&out)).Run,
+				"translated": vm.NewRegisterVM(translated, vm.WithStdout(&out)).Run,
+			}
+			for name, run := range backends {
+				err := run()
+				if tt.want == "" {
+					if err != nil {
+						t.Errorf("%s: VM error: %v", name, err)
+					}
+					continue
+				}
+				if err == nil {
+					t.Errorf("%s: expected error %q, got none", name, tt.want)
+				} else if got := vm.WithSourceFile(err, "prog.min").Error(); got != tt.want {
+					t.Errorf("%s: got %q, want %q", name, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// TestInt32 checks that in int32 mode int arithmetic wraps around at 32
+// bits, the same on every bytecode backend
+func TestInt32(t *testing.T) {
+	source := `var big = 2147483647
+var small = -2147483648
+var h = 0
+for var i = 0; i < 10; i = i + 1 {
+    h = h * 31 + 1000003
+}
+func half(n: int): int {
+    return n / 2
+}
+print(big + 1, small - 1, -small, small / -1)
+print(65536 * 65537, h, half(big * 2))
+print(int(3000000000.0), abs(small), sum([big, big]))
+print(1.5 * 2.0, float(big) + 0.5)`
+	want := "-2147483648 2147483647 -2147483648 -2147483648\n" +
+		"65536 -228623904 -1\n" +
+		"-1294967296 -2147483648 -2\n" +
+		"3.000000 2147483647.500000\n"
+
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("Parse errors: %v", p.Errors())
+	}
+
+	c := compiler.New()
+	c.SetInt32(true)
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+	translated, err := vm.TranslateToRegister(vm.Optimize(c.Bytecode()))
+	if err != nil {
+		t.Fatalf("Translation error: %v", err)
+	}
+	rc := compiler.NewRegisterCompiler()
+	rc.SetInt32(true)
+	if _, err := rc.CompileToRegister(program); err != nil {
+		t.Fatalf("Register compile error: %v", err)
+	}
+
+	for name, run := range map[string]func(io.Writer) error{
+		"stack":      func(w io.Writer) error { return vm.New(c.Bytecode(), vm.WithStdout(w)).Run() },
+		"register":   func(w io.Writer) error { return vm.NewRegisterVM(rc.RegisterBytecode(), vm.WithStdout(w)).Run() },
+		"translated": func(w io.Writer) error { return vm.NewRegisterVM(translated, vm.WithStdout(w)).Run() },
+	} {
+		var out bytes.Buffer
+		if err := run(&out); err != nil {
+			t.Errorf("%s: VM error: %v", name, err)
+		} else if out.String() != want {
+			t.Errorf("%s: got %q, want %q", name, out.String(), want)
+		}
+	}
+}
+
+// TestCheckOverflow checks that with overflow checks on, int arithmetic that
+// overflows stops the program on every bytecode backend, while arithmetic in
+// range and on floats and strings runs as usual
+func TestCheckOverflow(t *testing.T) {
+	source := `func factorial(n: int): int {
+    if n <= 1 { return 1 }
+    return n * factorial(n - 1)
+}
+var big = 9223372036854775807
+print(factorial(20), big - 1, -big, 1.5 * 2.0, "a" + "b")
+try {
+    print(factorial(25))
+} catch e {
+    print("caught", e)
+}
+print(big + 1)`
+	want := "2432902008176640000 9223372036854775806 -9223372036854775807 3.000000 ab\n" +
+		"caught integer overflow: 21 * 2432902008176640000\n"
+
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("Parse errors: %v", p.Errors())
+	}
+
+	c := compiler.New()
+	c.SetCheckOverflow(true)
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+	translated, err := vm.TranslateToRegister(vm.Optimize(c.Bytecode()))
+	if err != nil {
+		t.Fatalf("Translation error: %v", err)
+	}
+	rc := compiler.NewRegisterCompiler()
+	rc.SetCheckOverflow(true)
+	if _, err := rc.CompileToRegister(program); err != nil {
+		t.Fatalf("Register compile error: %v", err)
+	}
+
+	for name, run := range map[string]func(io.Writer) error{
+		"stack":      func(w io.Writer) error { return vm.New(c.Bytecode(), vm.WithStdout(w)).Run() },
+		"register":   func(w io.Writer) error { return vm.NewRegisterVM(rc.RegisterBytecode(), vm.WithStdout(w)).Run() },
+		"translated": func(w io.Writer) error { return vm.NewRegisterVM(translated, vm.WithStdout(w)).Run() },
+	} {
+		var out bytes.Buffer
+		err := run(&out)
+		if err == nil || !strings.Contains(err.Error(), "integer overflow: 9223372036854775807 + 1") {
+			t.Errorf("%s: expected an overflow error, got %v", name, err)
+		}
+		if out.String() != want {
+			t.Errorf("%s: got %q, want %q", name, out.String(), want)
+		}
+	}
+}
+
+// TestLongPrograms checks that control flow placed after more than 64K of
+// code still reaches its targets on every bytecode backend, and that the
+// register backends reject a conditional jump too long for its offset
+func TestLongPrograms(t *testing.T) {
+	compile := func(t *testing.T, source string) (*vm.Bytecode, *compiler.RegisterCompiler, error) {
+		t.Helper()
+		p := parser.New(lexer.New(source))
+		program := p.ParseProgram()
+		if len(p.Errors()) > 0 {
+			t.Fatalf("Parse errors: %v", p.Errors())
+		}
+		c := compiler.New()
+		if err := c.Compile(program); err != nil {
+			t.Fatalf("Compile error: %v", err)
+		}
+		rc := compiler.NewRegisterCompiler()
+		_, err := rc.CompileToRegister(program)
+		return c.Bytecode(), rc, err
+	}
+
+	t.Run("code past 64K", func(t *testing.T) {
+		source := "var x = 0\n" + strings.Repeat("x = x + 1\n", 40000) + `
+var i = 0
+var sum = 0
+for i < 10 {
+    i = i + 1
+    if i == 5 { continue }
+    sum = sum + i
+}
+if sum == 50 { print("long", x, sum) } else { print("wrong", x, sum) }`
+
+		bytecode, rc, err := compile(t, source)
+		if err != nil {
+			t.Fatalf("Register compile error: %v", err)
+		}
+		if n := len(rc.RegisterBytecode().Instructions); n <= 0xFFFF {
+			t.Fatalf("expected more than 65535 register instructions, got %d", n)
+		}
+		translated, err := vm.TranslateToRegister(bytecode)
+		if err != nil {
+			t.Fatalf("Translation error: %v", err)
+		}
+
+		var stack, register, translatedOut bytes.Buffer
+		backends := map[string]func() error{
+			"stack":      vm.New(bytecode, vm.WithStdout(&stack)).Run,
+			"register":   vm.NewRegisterVM(rc.RegisterBytecode(), vm.WithStdout(&register)).Run,
+			"translated": vm.NewRegisterVM(translated, vm.WithStdout(&translatedOut)).Run,
+		}
+		outputs := map[string]*bytes.Buffer{"stack": &stack, "register": &register, "translated": &translatedOut}
+		for name, run := range backends {
+			if err := run(); err != nil {
+				t.Fatalf("%s: VM error: %v", name, err)
+			}
+			if got := outputs[name].String(); got != "long 40000 50\n" {
+				t.Errorf("%s: got %q", name, got)
+			}
+		}
+	})
+
+	t.Run("conditional jump out of range", func(t *testing.T) {
+		// Conditional jumps past their range become the opposite jump over
+		// a plain one, in main and in functions, for both jump opcodes
+		body := strings.Repeat("    n = n + 1\n", 40000)
+		source := "var n = 0\nvar ok = true\nif ok {\n" + body + "} else { print(\"wrong\") }\n" +
+			"func count(more: bool, done: bool): int {\n    var n = 0\n" +
+			"    for more {\n" + body + "        more = false\n    }\n" +
+			"    if !done {\n" + body + "    }\n    return n\n}\n" +
+			"print(n, count(true, false), count(false, true))"
+
+		bytecode, rc, err := compile(t, source)
+		if err != nil {
+			t.Fatalf("register: compilation error: %v", err)
+		}
+		translated, err := vm.TranslateToRegister(bytecode)
+		if err != nil {
+			t.Fatalf("translated: translation error: %v", err)
+		}
+
+		var stack, register, translatedOut bytes.Buffer
+		backends := map[string]func() error{
+			"stack":      vm.New(bytecode, vm.WithStdout(&stack)).Run,
+			"register":   vm.NewRegisterVM(rc.RegisterBytecode(), vm.WithStdout(&register)).Run,
+			"translated": vm.NewRegisterVM(translated, vm.WithStdout(&translatedOut)).Run,
+		}
+		outputs := map[string]*bytes.Buffer{"stack": &stack, "register": &register, "translated": &translatedOut}
+		for name, run := range backends {
+			if err := run(); err != nil {
+				t.Fatalf("%s: VM error: %v", name, err)
+			}
+			if got := outputs[name].String(); got != "40000 80000 0\n" {
+				t.Errorf("%s: got %q", name, got)
+			}
+		}
+	})
+
+	t.Run("compare and branch past conditional jump range", func(t *testing.T) {
+		// A comparison branches with a plain jump, whose range is far larger
+		source := "var x = 0\nif x == 0 {\n" + strings.Repeat("x = x + 1\n", 40000) + "}\nprint(x)"
+
+		_, rc, err := compile(t, source)
+		if err != nil {
+			t.Fatalf("register: compilation error: %v", err)
+		}
+		var out bytes.Buffer
+		if err := vm.NewRegisterVM(rc.RegisterBytecode(), vm.WithStdout(&out)).Run(); err != nil {
+			t.Fatalf("register: VM error: %v", err)
+		}
+		if out.String() != "40000\n" {
+			t.Errorf("register: got %q", out.String())
+		}
+	})
+}
+
+// TestLargeConstantPools checks that constants past index 65535 load
+// correctly on every bytecode backend, through the wide stack opcodes and the
+// register VM's EXTRAARG forms
+func TestLargeConstantPools(t *testing.T) {
+	var body strings.Builder
+	for i := 1; i <= 70000; i++ {
+		fmt.Fprintf(&body, "    x = %d + x\n", i)
+	}
+	source := `func big(): int {
+    var x = 0
+` + body.String() + `    var k = 7
+    func inner(): int { return k + 700001 }
+    var xs = [1, 2, 3]
+    return x + inner() + xs[2]
+}
+func late(n: float): float { return n * 0.25 }
+print(big(), late(2.0), "done")`
+
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		t.Fatalf("Parse errors: %v", p.Errors())
+	}
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("Compile error: %v", err)
+	}
+	if n := len(c.Bytecode().Constants); n <= 0xFFFF {
+		t.Fatalf("expected more than 65535 constants, got %d", n)
+	}
+	translated, err := vm.TranslateToRegister(c.Bytecode())
+	if err != nil {
+		t.Fatalf("Translation error: %v", err)
+	}
+	rc := compiler.NewRegisterCompiler()
+	if _, err := rc.CompileToRegister(program); err != nil {
+		t.Fatalf("Register compile error: %v", err)
+	}
+
+	var stack, register, translatedOut bytes.Buffer
+	backends := map[string]func() error{
+		"stack":      vm.New(c.Bytecode(), vm.WithStdout(&stack)).Run,
+		"register":   vm.NewRegisterVM(rc.RegisterBytecode(), vm.WithStdout(&register)).Run,
+		"translated": vm.NewRegisterVM(translated, vm.WithStdout(&translatedOut)).Run,
+	}
+	outputs := map[string]*bytes.Buffer{"stack": &stack, "register": &register, "translated": &translatedOut}
+	for name, run := range backends {
+		if err := run(); err != nil {
+			t.Fatalf("%s: VM error: %v", name, err)
+		}
+		if got := outputs[name].String(); got != "2450735011 0.500000 done\n" {
+			t.Errorf("%s: got %q", name, got)
+		}
+	}
+}
+
 // TestLanguageFeatures tests individual language features
 func TestLanguageFeatures(t *testing.T) {
 	tests := []struct {
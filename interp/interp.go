@@ -0,0 +1,1499 @@
+package interp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"minlang/ast"
+	"minlang/compiler"
+	"minlang/vm"
+	"slices"
+)
+
+// MaxCallDepth mirrors the stack VM's frame limit
+const MaxCallDepth = vm.MaxFrames
+
+// control signals how a statement finished executing
+type control int
+
+const (
+	ctrlNone control = iota
+	ctrlBreak
+	ctrlContinue
+	ctrlReturn
+)
+
+// binding is a named value in an environment
+type binding struct {
+	value     vm.Value
+	isMutable bool
+}
+
+// Environment holds the variables of one function activation (or the globals).
+// Like the compiler, blocks do not introduce a new scope - only functions do.
+type Environment struct {
+	store map[string]*binding
+	outer *Environment
+}
+
+// NewEnvironment creates a new environment enclosed by outer (may be nil)
+func NewEnvironment(outer *Environment) *Environment {
+	return &Environment{store: make(map[string]*binding), outer: outer}
+}
+
+func (e *Environment) get(name string) (*binding, bool) {
+	for env := e; env != nil; env = env.outer {
+		if b, ok := env.store[name]; ok {
+			return b, true
+		}
+	}
+	return nil, false
+}
+
+func (e *Environment) define(name string, value vm.Value, isMutable bool) {
+	e.store[name] = &binding{value: value, isMutable: isMutable}
+}
+
+// userFunction is an interpreted function together with its defining environment
+type userFunction struct {
+	decl *ast.FunctionStatement
+	env  *Environment
+}
+
+// Interpreter evaluates an AST directly, sharing the VM's Value types and
+// builtins. It serves as a semantics reference for the bytecode backends.
+type Interpreter struct {
+	globals       *Environment
+	builtins      *compiler.SymbolTable
+	builtinValues []vm.Value  // Indexed like builtins, bound to enums
+	builtinOpts   []vm.Option // Where builtins read and write, see bindBuiltins
+	enums         vm.Enums    // Enums defined so far, for enumName/enumValue
+	functions     map[*vm.Function]*userFunction
+	structTypes   map[string][]*ast.StructField // struct name -> fields in declaration order
+
+	returnValue   vm.Value
+	returnedBy    *ast.ReturnStatement // The statement that set returnValue, for runtime check errors
+	lastValue     vm.Value
+	frames        []callFrame // The interpreted functions being run, innermost last
+	callSite      vm.Position // The call expression being evaluated, where a function called now is called from
+	promoteIntDiv bool
+	runtimeChecks bool
+	int32Mode     bool
+	checkOverflow bool
+	exitHooks     []vm.Value                // Functions registered with onExit, run last first
+	recovered     *vm.PanicError            // The panic a try caught, until recover returns its value
+	bareVariants  map[string]*bareVariant   // Variant names enums define as globals
+	payloads      map[string]map[string]int // Values each variant of an enum with payloads carries
+	checker       *compiler.TypeChecker     // Records the types of if and switch expressions, see promoteBranch
+	compiled      []ast.Statement           // The statements run so far, which later programs are compiled after
+	warnings      []string                  // The compiler's warnings about the statements run so far
+}
+
+// CompileError is an error the compilers report for a program, which the
+// interpreter rejects before running any of it
+type CompileError struct {
+	Err error
+}
+
+func (e *CompileError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *CompileError) Unwrap() error {
+	return e.Err
+}
+
+// callFrame is a function being run, for the stack traces of runtime errors
+type callFrame struct {
+	name string
+	site vm.Position // Where it was called from, line 0 outside any call expression
+}
+
+// bareVariant is a variant name enums define as a global, as in the
+// compiler's enum_access.go
+type bareVariant struct {
+	binding *binding // The global holding the first enum's variant
+	enums   []string // The enums with a variant of the name, in order
+}
+
+// New creates a new interpreter
+func New() *Interpreter {
+	enums := make(vm.Enums)
+	in := &Interpreter{
+		globals:      NewEnvironment(nil),
+		builtins:     compiler.NewSymbolTable(),
+		enums:        enums,
+		functions:    make(map[*vm.Function]*userFunction),
+		structTypes:  make(map[string][]*ast.StructField),
+		bareVariants: make(map[string]*bareVariant),
+		payloads:     make(map[string]map[string]int),
+		returnValue:  vm.NilValue(),
+		lastValue:    vm.NilValue(),
+		checker:      compiler.NewTypeChecker(),
+	}
+
+	in.bindBuiltins()
+	return in
+}
+
+// bindBuiltins sets up the builtins with opts added to those set before,
+// using os.Stdout and os.Stdin unless they say otherwise
+func (in *Interpreter) bindBuiltins(opts ...vm.Option) {
+	in.builtinOpts = append(in.builtinOpts, opts...)
+
+	// map, filter and reduce call back into the interpreter
+	call := func(fn vm.Value, args ...vm.Value) (vm.Value, error) {
+		return in.call(fn, args)
+	}
+	in.builtinValues = vm.BuiltinValues(in.enums, append(in.builtinOpts, vm.WithCall(call))...)
+
+	// The interpreter keeps the exit hooks itself, to run them after Run
+	if symbol, ok := in.builtins.Resolve("onExit"); ok {
+		in.builtinValues[symbol.Index] = vm.NewBuiltinFunctionValue(in.onExitBuiltin)
+	}
+	// and the panic its catch recovers
+	if symbol, ok := in.builtins.Resolve("recover"); ok {
+		in.builtinValues[symbol.Index] = vm.NewBuiltinFunctionValue(in.recoverBuiltin)
+	}
+}
+
+// SetStdout sends program output (print) to w instead of os.Stdout
+func (in *Interpreter) SetStdout(w io.Writer) {
+	in.bindBuiltins(vm.WithStdout(w))
+}
+
+// SetStdin makes input and readLine read from r instead of os.Stdin
+func (in *Interpreter) SetStdin(r io.Reader) {
+	in.bindBuiltins(vm.WithStdin(r))
+}
+
+// SetArgs sets the command-line arguments args() returns to the program
+func (in *Interpreter) SetArgs(args []string) {
+	in.bindBuiltins(vm.WithArgs(args))
+}
+
+// SetPromoteIntDiv makes "/" produce a float for any numeric operands,
+// matching compiler.Compiler.SetPromoteIntDiv
+func (in *Interpreter) SetPromoteIntDiv(enabled bool) {
+	in.promoteIntDiv = enabled
+}
+
+// SetRuntimeChecks makes functions check their arguments and return values
+// against their type annotations, matching compiler.Compiler.SetRuntimeChecks
+func (in *Interpreter) SetRuntimeChecks(enabled bool) {
+	in.runtimeChecks = enabled
+}
+
+// SetInt32 makes ints 32 bits wide, wrapping around on overflow, matching
+// compiler.Compiler.SetInt32
+func (in *Interpreter) SetInt32(enabled bool) {
+	in.int32Mode = enabled
+}
+
+// SetCheckOverflow makes int arithmetic that overflows an error, matching
+// compiler.Compiler.SetCheckOverflow
+func (in *Interpreter) SetCheckOverflow(enabled bool) {
+	in.checkOverflow = enabled
+}
+
+// wrap truncates an int result to 32 bits in int32 mode
+func (in *Interpreter) wrap(v vm.Value, err error) (vm.Value, error) {
+	if in.int32Mode && err == nil {
+		v = vm.WrapInt32(v)
+	}
+	return v, err
+}
+
+// LastValue returns the value of the most recently evaluated expression statement
+func (in *Interpreter) LastValue() vm.Value {
+	return in.lastValue
+}
+
+// Run executes a program, then the hooks it registered with onExit. A
+// program that calls exit runs them too, and Run returns as the VMs do, see
+// vm.FinishExit.
+func (in *Interpreter) Run(program *ast.Program) error {
+	if err := in.typeCheck(program); err != nil {
+		return err
+	}
+	if err := in.runMain(program); err != nil {
+		return vm.FinishExit(err, func() error {
+			in.lastValue = vm.NilValue()
+			return in.RunExitHooks()
+		})
+	}
+	return in.RunExitHooks()
+}
+
+// Continue executes program as more of the program run so far: its
+// statements see the globals, functions and types the earlier ones defined,
+// and LastValue reports its own last expression statement. Hooks registered
+// with onExit wait for RunExitHooks. The REPL and the notebook kernel run
+// code this way.
+func (in *Interpreter) Continue(program *ast.Program) error {
+	in.lastValue = vm.NilValue()
+	if err := in.typeCheck(program); err != nil {
+		return err
+	}
+	return in.runMain(program)
+}
+
+// typeCheck rejects the programs the compilers reject before running them,
+// returning a *CompileError. The stack compiler compiles program after the
+// statements run so far, with the interpreter's options, and its bytecode is
+// thrown away.
+func (in *Interpreter) typeCheck(program *ast.Program) error {
+	c := compiler.New()
+	c.SetPromoteIntDiv(in.promoteIntDiv)
+	c.SetRuntimeChecks(in.runtimeChecks)
+	c.SetInt32(in.int32Mode)
+	c.SetCheckOverflow(in.checkOverflow)
+	statements := append(slices.Clip(in.compiled), program.Statements...)
+	if err := c.Compile(&ast.Program{Statements: statements}); err != nil {
+		return &CompileError{Err: err}
+	}
+	in.compiled = statements
+	in.warnings = c.Warnings()
+
+	in.checker.PromoteIntDiv = in.promoteIntDiv
+	if err := in.checker.CheckProgram(program); err != nil {
+		return &CompileError{Err: err}
+	}
+	return nil
+}
+
+// Warnings returns the compiler's warnings, such as for unreachable code,
+// about the programs run so far
+func (in *Interpreter) Warnings() []string {
+	return in.warnings
+}
+
+// RunExitHooks runs the hooks registered with onExit, last registered first.
+// A hook that fails doesn't stop the others; the errors of those that do are
+// joined, as on the VMs.
+func (in *Interpreter) RunExitHooks() error {
+	// Hooks don't change the program's result
+	result := in.lastValue
+	var errs []error
+	for len(in.exitHooks) > 0 {
+		hook := in.exitHooks[len(in.exitHooks)-1]
+		in.exitHooks = in.exitHooks[:len(in.exitHooks)-1]
+		if _, err := in.call(hook, nil); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	in.lastValue = result
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return errors.Join(errs...)
+}
+
+// onExitBuiltin implements onExit(fn), matching the VMs' builtin
+func (in *Interpreter) onExitBuiltin(args ...vm.Value) (vm.Value, error) {
+	hook, err := vm.ExitHook(args...)
+	if err != nil {
+		return vm.NilValue(), err
+	}
+	in.exitHooks = append(in.exitHooks, hook)
+	return vm.NilValue(), nil
+}
+
+// recoverBuiltin implements recover(), matching the VMs' builtin
+func (in *Interpreter) recoverBuiltin(args ...vm.Value) (vm.Value, error) {
+	if len(args) != 0 {
+		return vm.NilValue(), fmt.Errorf("recover: wrong number of arguments. got=%d, want=0", len(args))
+	}
+	if in.recovered == nil {
+		return vm.NilValue(), nil
+	}
+	recovered := in.recovered.Value
+	in.recovered = nil
+	return recovered, nil
+}
+
+// runMain executes the top-level statements of a program
+func (in *Interpreter) runMain(program *ast.Program) error {
+	for _, s := range program.Statements {
+		ctrl, err := in.execStatement(s, in.globals)
+		if err != nil {
+			return err
+		}
+		switch ctrl {
+		case ctrlBreak:
+			return fmt.Errorf("break statement outside of loop")
+		case ctrlContinue:
+			return fmt.Errorf("continue statement outside of loop")
+		case ctrlReturn:
+			return nil
+		}
+	}
+	return nil
+}
+
+// execBlock executes statements in order, stopping at the first control transfer
+func (in *Interpreter) execBlock(block *ast.BlockStatement, env *Environment) (control, error) {
+	for _, s := range block.Statements {
+		ctrl, err := in.execStatement(s, env)
+		if err != nil || ctrl != ctrlNone {
+			return ctrl, err
+		}
+	}
+	return ctrlNone, nil
+}
+
+// execStatement executes a statement, annotating the errors it raises with
+// their position
+func (in *Interpreter) execStatement(stmt ast.Statement, env *Environment) (control, error) {
+	ctrl, err := in.exec(stmt, env)
+	if err != nil {
+		err = in.positioned(err, stmt)
+	}
+	return ctrl, err
+}
+
+func (in *Interpreter) exec(stmt ast.Statement, env *Environment) (control, error) {
+	switch node := stmt.(type) {
+	case *ast.ExpressionStatement:
+		val, err := in.eval(node.Expression, env)
+		if err != nil {
+			return ctrlNone, err
+		}
+		// Outside functions, the value is the program's result
+		if len(in.frames) == 0 {
+			in.lastValue = val
+		}
+
+	case *ast.BlockStatement:
+		return in.execBlock(node, env)
+
+	case *ast.VarStatement:
+		val := vm.NilValue()
+		if node.Value != nil {
+			var err error
+			val, err = in.eval(node.Value, env)
+			if err != nil {
+				return ctrlNone, err
+			}
+		}
+		env.define(node.Name.Value, val, node.IsMutable)
+
+	case *ast.LookupStatement:
+		return ctrlNone, in.execLookup(node, env)
+
+	case *ast.AssignmentStatement:
+		return ctrlNone, in.execAssignment(node, env)
+
+	case *ast.IfStatement:
+		cond, err := in.eval(node.Condition, env)
+		if err != nil {
+			return ctrlNone, err
+		}
+		if cond.IsTruthy() {
+			return in.execBlock(node.Consequence, env)
+		}
+		if node.Alternative != nil {
+			return in.execStatement(node.Alternative, env)
+		}
+
+	case *ast.ForStatement:
+		return in.execFor(node, env)
+
+	case *ast.ForInStatement:
+		return in.execForIn(node, env)
+
+	case *ast.TryStatement:
+		return in.execTry(node, env)
+
+	case *ast.SwitchStatement:
+		return in.execSwitch(node, env)
+
+	case *ast.BreakStatement:
+		return ctrlBreak, nil
+
+	case *ast.ContinueStatement:
+		return ctrlContinue, nil
+
+	case *ast.ReturnStatement:
+		in.returnValue = vm.NilValue()
+		in.returnedBy = node
+		if node.ReturnValue != nil {
+			val, err := in.eval(node.ReturnValue, env)
+			if err != nil {
+				return ctrlNone, err
+			}
+			in.returnValue = val
+		}
+		return ctrlReturn, nil
+
+	case *ast.FunctionStatement:
+		fn := &vm.Function{Name: node.Name.Value, NumParams: len(node.Parameters)}
+		in.functions[fn] = &userFunction{decl: node, env: env}
+		env.define(node.Name.Value, vm.NewFunctionValue(fn), true)
+
+	case *ast.TypeStatement:
+		switch def := node.Definition.(type) {
+		case *ast.EnumStatement:
+			def.Name = node.Name
+			return ctrlNone, in.defineEnum(def, env)
+		case *ast.StructStatement:
+			in.structTypes[node.Name.Value] = def.Fields
+		}
+
+	case *ast.EnumStatement:
+		return ctrlNone, in.defineEnum(node, env)
+
+	default:
+		return ctrlNone, fmt.Errorf("interpreter: unsupported statement %T", stmt)
+	}
+
+	return ctrlNone, nil
+}
+
+// defineEnum binds each variant to its value and registers the enum for enumName/enumValue
+func (in *Interpreter) defineEnum(node *ast.EnumStatement, env *Environment) error {
+	values, err := compiler.EnumValues(node)
+	if err != nil {
+		return err
+	}
+	var payloads map[string]int
+	if compiler.HasPayloads(node) {
+		payloads = make(map[string]int)
+	}
+	in.payloads[node.Name.Value] = payloads
+
+	variants := make([]vm.EnumVariant, len(node.Variants))
+	for i, variant := range node.Variants {
+		variants[i] = vm.EnumVariant{Name: variant.Value, Value: values[i]}
+		if payloads != nil {
+			// Named only qualified, as in the compiler's enum_payloads.go
+			payloads[variant.Value] = len(node.Payloads[i])
+			continue
+		}
+		if bare, ok := in.bareVariants[variant.Value]; ok {
+			bare.enums = append(bare.enums, node.Name.Value)
+			continue
+		}
+		env.define(variant.Value, values[i], false)
+		in.bareVariants[variant.Value] = &bareVariant{binding: env.store[variant.Value], enums: []string{node.Name.Value}}
+	}
+	in.enums[node.Name.Value] = variants
+	return nil
+}
+
+func (in *Interpreter) execFor(node *ast.ForStatement, env *Environment) (control, error) {
+	if node.Init != nil {
+		if _, err := in.execStatement(node.Init, env); err != nil {
+			return ctrlNone, err
+		}
+	}
+
+	if node.Condition == nil {
+		return ctrlNone, fmt.Errorf("for loop must have a condition")
+	}
+
+	for {
+		cond, err := in.eval(node.Condition, env)
+		if err != nil {
+			return ctrlNone, err
+		}
+		if !cond.IsTruthy() {
+			return ctrlNone, nil
+		}
+
+		ctrl, err := in.execBlock(node.Body, env)
+		if err != nil {
+			return ctrlNone, err
+		}
+		if ctrl == ctrlBreak {
+			return ctrlNone, nil
+		}
+		if ctrl == ctrlReturn {
+			return ctrlReturn, nil
+		}
+
+		if node.Post != nil {
+			if _, err := in.execStatement(node.Post, env); err != nil {
+				return ctrlNone, err
+			}
+		}
+	}
+}
+
+// execForIn runs node over the elements the array had when the loop started,
+// reading each as it is reached, like the compiled loop does
+func (in *Interpreter) execForIn(node *ast.ForInStatement, env *Environment) (control, error) {
+	iterable, err := in.eval(node.Iterable, env)
+	if err != nil {
+		return ctrlNone, err
+	}
+	if iterable.Type != vm.ArrayType {
+		return ctrlNone, fmt.Errorf("cannot loop over %s", iterable.Type)
+	}
+
+	n := len(iterable.AsArray().Elements)
+	for i := 0; i < n; i++ {
+		elem, err := evalIndex(iterable, vm.IntValue(int64(i)))
+		if err != nil {
+			return ctrlNone, err
+		}
+		env.define(node.Variable.Value, elem, true)
+
+		ctrl, err := in.execBlock(node.Body, env)
+		if err != nil {
+			return ctrlNone, err
+		}
+		if ctrl == ctrlBreak {
+			return ctrlNone, nil
+		}
+		if ctrl == ctrlReturn {
+			return ctrlReturn, nil
+		}
+	}
+	return ctrlNone, nil
+}
+
+// execLookup declares the value a map holds for a key and whether it holds
+// one, like the compiled hasKey and index do
+func (in *Interpreter) execLookup(node *ast.LookupStatement, env *Environment) error {
+	container, err := in.eval(node.Index.Left, env)
+	if err != nil {
+		return err
+	}
+	key, err := in.eval(node.Index.Index, env)
+	if err != nil {
+		return err
+	}
+	if container.Type != vm.MapType {
+		return fmt.Errorf("hasKey: first argument must be a map")
+	}
+	val, found := container.AsMap().Pairs[key.ToMapKey()]
+	if !found {
+		val = vm.NilValue()
+	}
+	env.define(node.Found.Value, vm.BoolValue(found), node.IsMutable)
+	env.define(node.Value.Value, val, node.IsMutable)
+	return nil
+}
+
+// execTry runs node's body, and its catch if a runtime error stops the body.
+// An exit isn't caught.
+func (in *Interpreter) execTry(node *ast.TryStatement, env *Environment) (control, error) {
+	ctrl, err := in.execBlock(node.Body, env)
+	var exit *vm.ExitError
+	if err == nil || errors.As(err, &exit) {
+		return ctrl, err
+	}
+	in.recovered = nil
+	errors.As(err, &in.recovered)
+	if node.Variable != nil {
+		// The VMs catch errors before annotating them with a position
+		var runtimeErr *vm.RuntimeError
+		if errors.As(err, &runtimeErr) {
+			err = runtimeErr.Err
+		}
+		env.define(node.Variable.Value, vm.ErrorValue(err.Error()), true)
+	}
+	return in.execBlock(node.Catch, env)
+}
+
+func (in *Interpreter) execSwitch(node *ast.SwitchStatement, env *Environment) (control, error) {
+	subject, err := in.eval(node.Value, env)
+	if err != nil {
+		return ctrlNone, err
+	}
+
+	for _, caseClause := range node.Cases {
+		matched, err := in.matchCase(caseClause.Value, subject, env)
+		if err != nil {
+			return ctrlNone, err
+		}
+		if matched {
+			return in.execBlock(caseClause.Body, env)
+		}
+	}
+
+	if node.Default != nil {
+		return in.execBlock(node.Default, env)
+	}
+	return ctrlNone, nil
+}
+
+func (in *Interpreter) execAssignment(node *ast.AssignmentStatement, env *Environment) error {
+	switch left := node.Left.(type) {
+	case *ast.Identifier:
+		b, ok := env.get(left.Value)
+		if !ok {
+			return fmt.Errorf("undefined variable %s", left.Value)
+		}
+		if !b.isMutable {
+			return fmt.Errorf("cannot assign to const variable %s", left.Value)
+		}
+		val, err := in.eval(node.Value, env)
+		if err != nil {
+			return err
+		}
+		b.value = val
+
+	case *ast.IndexExpression:
+		container, err := in.eval(left.Left, env)
+		if err != nil {
+			return err
+		}
+		index, err := in.eval(left.Index, env)
+		if err != nil {
+			return err
+		}
+		val, err := in.eval(node.Value, env)
+		if err != nil {
+			return err
+		}
+
+		switch container.Type {
+		case vm.ArrayType:
+			if index.Type != vm.IntType {
+				return fmt.Errorf("array index must be integer, got %d", index.Type)
+			}
+			arr := container.AsArray()
+			idx := int(index.AsInt())
+			if idx < 0 || idx >= len(arr.Elements) {
+				return fmt.Errorf("array index out of bounds: %d", idx)
+			}
+			arr.Set(idx, val)
+		case vm.MapType:
+			container.AsMap().Set(index.ToMapKey(), val)
+		default:
+			return fmt.Errorf("index assignment not supported for type %d", container.Type)
+		}
+
+	case *ast.FieldAccessExpression:
+		target, err := in.eval(left.Left, env)
+		if err != nil {
+			return err
+		}
+		if target.Type != vm.StructType {
+			return fmt.Errorf("field access not supported for type %d", target.Type)
+		}
+		val, err := in.eval(node.Value, env)
+		if err != nil {
+			return err
+		}
+
+		target.AsStruct().SetField(left.Field.Value, val)
+
+	case *ast.TupleElementExpression:
+		return fmt.Errorf("cannot assign to %s.%d: tuples can't be changed", left.Left.String(), left.Index)
+
+	default:
+		return fmt.Errorf("unsupported assignment target")
+	}
+
+	return nil
+}
+
+// eval evaluates an expression, annotating the errors it raises with their
+// position
+func (in *Interpreter) eval(expr ast.Expression, env *Environment) (vm.Value, error) {
+	value, err := in.evaluate(expr, env)
+	if err != nil {
+		err = in.positioned(err, expr)
+	}
+	return value, err
+}
+
+// positioned annotates err with the position of node, the innermost node it
+// reached, as the VMs annotate it with that of the failing instruction. Exits
+// and errors already annotated pass through.
+func (in *Interpreter) positioned(err error, node ast.Node) error {
+	var runtimeErr *vm.RuntimeError
+	var exit *vm.ExitError
+	if errors.As(err, &runtimeErr) || errors.As(err, &exit) {
+		return err
+	}
+	tok, ok := ast.NodeToken(node)
+	if !ok || tok.Line == 0 {
+		return err
+	}
+	pos := vm.Position{Line: tok.Line, Column: tok.Column}
+	return &vm.RuntimeError{Err: err, Pos: pos, Trace: in.stackTrace(pos)}
+}
+
+// stackTrace describes the active calls when an error occurs at pos,
+// innermost first: each function at the call it was running, ending with main
+func (in *Interpreter) stackTrace(pos vm.Position) []vm.TraceEntry {
+	trace := make([]vm.TraceEntry, 0, len(in.frames)+1)
+	for i := len(in.frames) - 1; i >= 0; i-- {
+		trace = append(trace, vm.TraceEntry{Function: in.frames[i].name, Pos: pos})
+		pos = in.frames[i].site
+	}
+	return append(trace, vm.TraceEntry{Function: "main", Pos: pos})
+}
+
+func (in *Interpreter) evaluate(expr ast.Expression, env *Environment) (vm.Value, error) {
+	switch node := expr.(type) {
+	case *ast.IntegerLiteral:
+		if in.int32Mode {
+			if err := compiler.Int32LiteralError(node.Value); err != nil {
+				return vm.NilValue(), err
+			}
+		}
+		return vm.IntValue(node.Value), nil
+
+	case *ast.FloatLiteral:
+		return vm.FloatValue(node.Value), nil
+
+	case *ast.StringLiteral:
+		return vm.StringValue(node.Value), nil
+
+	case *ast.BooleanLiteral:
+		return vm.BoolValue(node.Value), nil
+
+	case *ast.NilLiteral:
+		return vm.NilValue(), nil
+
+	case *ast.Identifier:
+		if b, ok := env.get(node.Value); ok {
+			if bare, ok := in.bareVariants[node.Value]; ok && bare.binding == b && len(bare.enums) > 1 {
+				return vm.NilValue(), compiler.AmbiguousVariantError(node.Value, bare.enums)
+			}
+			return b.value, nil
+		}
+		if symbol, ok := in.builtins.Resolve(node.Value); ok && symbol.Scope == compiler.BuiltinScope {
+			return in.builtinValues[symbol.Index], nil
+		}
+		return vm.NilValue(), fmt.Errorf("undefined variable %s", node.Value)
+
+	case *ast.PrefixExpression:
+		if lit, ok := compiler.NegatedLiteral(node); ok && in.int32Mode {
+			return in.eval(lit, env)
+		}
+		right, err := in.eval(node.Right, env)
+		if err != nil {
+			return vm.NilValue(), err
+		}
+		if in.checkOverflow && node.Operator == "-" && right.Type == vm.IntType {
+			n, err := vm.CheckedNeg(right.AsInt())
+			if err != nil {
+				return vm.NilValue(), err
+			}
+			return vm.IntValue(n), nil
+		}
+		return in.wrap(evalPrefix(node.Operator, right))
+
+	case *ast.InfixExpression:
+		left, err := in.eval(node.Left, env)
+		if err != nil {
+			return vm.NilValue(), err
+		}
+		// && and || only evaluate the right side if the left doesn't decide
+		if decided := node.Operator == "||"; (node.Operator == "&&" || decided) && left.IsTruthy() == decided {
+			return vm.BoolValue(decided), nil
+		}
+		right, err := in.eval(node.Right, env)
+		if err != nil {
+			return vm.NilValue(), err
+		}
+		promote := node.Operator == "/" && in.promoteIntDiv || in.checker.FloatArithmetic(node)
+		if promote && left.Type == vm.IntType {
+			// A float operand makes evalArithmetic work in floats
+			left = vm.FloatValue(float64(left.AsInt()))
+		}
+		if in.checkOverflow && left.Type == vm.IntType && right.Type == vm.IntType {
+			switch node.Operator {
+			case "+", "-", "*", "/":
+				n, err := vm.CheckedInt(node.Operator, left.AsInt(), right.AsInt())
+				if err != nil {
+					return vm.NilValue(), err
+				}
+				return vm.IntValue(n), nil
+			}
+		}
+		return in.wrap(evalInfix(node.Operator, left, right))
+
+	case *ast.CallExpression:
+		if value, ok, err := in.makeVariant(node, env); ok {
+			return value, err
+		}
+		callee, err := in.eval(node.Function, env)
+		if err != nil {
+			return vm.NilValue(), err
+		}
+		args := make([]vm.Value, len(node.Arguments))
+		for i, arg := range node.Arguments {
+			args[i], err = in.eval(arg, env)
+			if err != nil {
+				return vm.NilValue(), err
+			}
+		}
+		// Closures map and filter call back count as called from here too
+		outerSite := in.callSite
+		if tok, ok := ast.NodeToken(node); ok {
+			in.callSite = vm.Position{Line: tok.Line, Column: tok.Column}
+		}
+		value, err := in.call(callee, args)
+		in.callSite = outerSite
+		return value, err
+
+	case *ast.ArrayLiteral:
+		array := vm.NewArrayValue(len(node.Elements))
+		elements := array.AsArray().Elements
+		for i, el := range node.Elements {
+			val, err := in.eval(el, env)
+			if err != nil {
+				return vm.NilValue(), err
+			}
+			elements[i] = val
+		}
+		return array, nil
+
+	case *ast.MapLiteral:
+		m := vm.NewMapValue()
+		for _, keyExpr := range node.Keys {
+			key, err := in.eval(keyExpr, env)
+			if err != nil {
+				return vm.NilValue(), err
+			}
+			val, err := in.eval(node.Pairs[keyExpr], env)
+			if err != nil {
+				return vm.NilValue(), err
+			}
+			m.AsMap().Set(key.ToMapKey(), val)
+		}
+		return m, nil
+
+	case *ast.StructLiteral:
+		return in.evalStructLiteral(node, env)
+
+	case *ast.NewExpression:
+		fields, known := in.structTypes[node.Type.Value]
+		if !known {
+			return vm.NilValue(), fmt.Errorf("new: unknown struct %s", node.Type.Value)
+		}
+		names := make([]string, len(fields))
+		for i, field := range fields {
+			names[i] = field.Name.Value
+		}
+		literal, err := compiler.NewLiteral(node, names)
+		if err != nil {
+			return vm.NilValue(), err
+		}
+		return in.evalStructLiteral(literal, env)
+
+	case *ast.IndexExpression:
+		container, err := in.eval(node.Left, env)
+		if err != nil {
+			return vm.NilValue(), err
+		}
+		index, err := in.eval(node.Index, env)
+		if err != nil {
+			return vm.NilValue(), err
+		}
+		return evalIndex(container, index)
+
+	case *ast.SliceExpression:
+		container, err := in.eval(node.Left, env)
+		if err != nil {
+			return vm.NilValue(), err
+		}
+		low, high := vm.NilValue(), vm.NilValue()
+		if node.Low != nil {
+			if low, err = in.eval(node.Low, env); err != nil {
+				return vm.NilValue(), err
+			}
+		}
+		if node.High != nil {
+			if high, err = in.eval(node.High, env); err != nil {
+				return vm.NilValue(), err
+			}
+		}
+		return vm.Slice(container, low, high)
+
+	case *ast.TupleLiteral:
+		elements := make([]vm.Value, len(node.Elements))
+		for i, el := range node.Elements {
+			val, err := in.eval(el, env)
+			if err != nil {
+				return vm.NilValue(), err
+			}
+			elements[i] = val
+		}
+		return vm.NewTupleValue(elements), nil
+
+	case *ast.TupleElementExpression:
+		tuple, err := in.eval(node.Left, env)
+		if err != nil {
+			return vm.NilValue(), err
+		}
+		return vm.TupleElement(tuple, node.Index)
+
+	case *ast.CastExpression:
+		value, err := in.eval(node.Value, env)
+		if err != nil {
+			return vm.NilValue(), err
+		}
+		if err := checkAnnotation(value, node.Type, compiler.CastDescription(node)); err != nil {
+			return vm.NilValue(), err
+		}
+		return value, nil
+
+	case *ast.IfExpression:
+		cond, err := in.eval(node.Condition, env)
+		if err != nil {
+			return vm.NilValue(), err
+		}
+		branch := node.Consequence
+		if !cond.IsTruthy() {
+			branch = node.Alternative.(*ast.BlockStatement)
+		}
+		value, err := in.evalBranch(branch, env)
+		return in.promoteBranch(node, value), err
+
+	case *ast.SwitchExpression:
+		value, err := in.evalSwitch(node, env)
+		return in.promoteBranch(node, value), err
+
+	case *ast.FieldAccessExpression:
+		if value, ok, err := in.qualifiedVariant(node, env); ok {
+			return value, err
+		}
+		target, err := in.eval(node.Left, env)
+		if err != nil {
+			return vm.NilValue(), err
+		}
+		if target.Type != vm.StructType {
+			return vm.NilValue(), fmt.Errorf("field access not supported for type %d", target.Type)
+		}
+		s := target.AsStruct()
+		val, ok := s.Fields[node.Field.Value]
+		if !ok {
+			return vm.NilValue(), fmt.Errorf("field %s not found in struct %s", node.Field.Value, s.TypeName)
+		}
+		return val, nil
+
+	default:
+		return vm.NilValue(), fmt.Errorf("interpreter: unsupported expression %T", expr)
+	}
+}
+
+// qualifiedVariant returns the value of node if it names an enum's variant,
+// as Color.Red does, unless a variable named like the enum hides it
+func (in *Interpreter) qualifiedVariant(node *ast.FieldAccessExpression, env *Environment) (value vm.Value, ok bool, err error) {
+	enum, ok := in.qualifiedEnum(node, env)
+	if !ok {
+		return vm.NilValue(), false, nil
+	}
+	variant, err := in.variant(enum, node.Field.Value)
+	if err != nil {
+		return vm.NilValue(), true, err
+	}
+	if in.payloads[enum][variant.Name] > 0 {
+		return vm.NilValue(), true, compiler.MissingPayloadError(enum, variant.Name)
+	}
+	return variant.Value, true, nil
+}
+
+// qualifiedEnum returns the enum node's left side names, unless a variable
+// named like the enum hides it
+func (in *Interpreter) qualifiedEnum(node *ast.FieldAccessExpression, env *Environment) (string, bool) {
+	ident, isIdent := node.Left.(*ast.Identifier)
+	if !isIdent {
+		return "", false
+	}
+	_, isEnum := in.enums[ident.Value]
+	if _, hidden := env.get(ident.Value); !isEnum || hidden {
+		return "", false
+	}
+	return ident.Value, true
+}
+
+// variant returns the variant of enum named name
+func (in *Interpreter) variant(enum, name string) (vm.EnumVariant, error) {
+	for _, variant := range in.enums[enum] {
+		if variant.Name == name {
+			return variant, nil
+		}
+	}
+	return vm.EnumVariant{}, compiler.UnknownVariantError(enum, name)
+}
+
+// makeVariant evaluates a call like Shape.Circle(r) making a variant. ok is
+// false if node makes none.
+func (in *Interpreter) makeVariant(node *ast.CallExpression, env *Environment) (value vm.Value, ok bool, err error) {
+	access, isAccess := node.Function.(*ast.FieldAccessExpression)
+	if !isAccess {
+		return vm.NilValue(), false, nil
+	}
+	enum, ok := in.qualifiedEnum(access, env)
+	if !ok {
+		return vm.NilValue(), false, nil
+	}
+	variant, err := in.variant(enum, access.Field.Value)
+	if err != nil {
+		return vm.NilValue(), true, err
+	}
+	count := in.payloads[enum][variant.Name]
+	if len(node.Arguments) != count || count == 0 {
+		return vm.NilValue(), true, compiler.PayloadCountError(enum, variant.Name, count, len(node.Arguments))
+	}
+
+	values := make([]vm.Value, count)
+	for i, arg := range node.Arguments {
+		values[i], err = in.eval(arg, env)
+		if err != nil {
+			return vm.NilValue(), true, err
+		}
+	}
+	payload := values[0]
+	if count > 1 {
+		payload = vm.NewArrayFromElements(values)
+	}
+	return vm.NewVariantValue(variant.Value.VariantTag(), payload), true, nil
+}
+
+// matchCase reports whether subject matches the value of a case, binding the
+// names a case like Shape.Rect(w, h) lists to the variant's payload, and
+// those a struct or array pattern lists to the parts they match
+func (in *Interpreter) matchCase(value ast.Expression, subject vm.Value, env *Environment) (bool, error) {
+	switch value.(type) {
+	case *ast.StructPattern, *ast.ArrayPattern:
+		bound := make(map[string]vm.Value)
+		matched, err := in.matchPattern(value, subject, bound, env)
+		if matched {
+			for name, v := range bound {
+				env.define(name, v, false)
+			}
+		}
+		return matched, err
+	}
+
+	access, bindings, isCall, err := compiler.CasePattern(value)
+	if err != nil {
+		return false, err
+	}
+	if !isCall {
+		access, _ = value.(*ast.FieldAccessExpression)
+	}
+	if access != nil {
+		if enum, ok := in.qualifiedEnum(access, env); ok && (isCall || in.payloads[enum] != nil) {
+			return in.matchVariant(enum, access.Field.Value, bindings, isCall, subject, env)
+		}
+	}
+
+	caseVal, err := in.eval(value, env)
+	if err != nil {
+		return false, err
+	}
+	return vm.Equal(subject, caseVal), nil
+}
+
+// matchVariant reports whether subject is the variant name of enum, binding
+// its payload to bindings if it is
+func (in *Interpreter) matchVariant(enum, name string, bindings []*ast.Identifier, isCall bool, subject vm.Value, env *Environment) (bool, error) {
+	variant, err := in.variant(enum, name)
+	if err != nil {
+		return false, err
+	}
+	count := in.payloads[enum][name]
+	if isCall && (len(bindings) != count || count == 0) {
+		return false, compiler.PayloadCountError(enum, name, count, len(bindings))
+	}
+	if subject.Type != vm.VariantType || subject.VariantTag() != variant.Value.VariantTag() {
+		return false, nil
+	}
+
+	payload := subject.VariantPayload()
+	for i, binding := range bindings {
+		value := payload
+		if count > 1 {
+			value = payload.AsArray().Elements[i]
+		}
+		env.define(binding.Value, value, false)
+	}
+	return true, nil
+}
+
+// matchPattern reports whether subject matches pattern, a part of a struct
+// or array pattern, collecting the names it binds in bound
+func (in *Interpreter) matchPattern(pattern ast.Expression, subject vm.Value, bound map[string]vm.Value, env *Environment) (bool, error) {
+	switch pat := pattern.(type) {
+	case *ast.StructPattern:
+		if !subject.IsStructNamed(pat.Name.Value) {
+			return false, nil
+		}
+		fields := subject.AsStruct().Fields
+		for _, field := range pat.Fields {
+			value, ok := fields[field.Name.Value]
+			if !ok {
+				return false, fmt.Errorf("struct %s has no field %s", pat.Name.Value, field.Name.Value)
+			}
+			if matched, err := in.matchPattern(field.Pattern, value, bound, env); !matched || err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+
+	case *ast.ArrayPattern:
+		if subject.Type != vm.ArrayType || len(subject.AsArray().Elements) != len(pat.Elements) {
+			return false, nil
+		}
+		for i, element := range pat.Elements {
+			if matched, err := in.matchPattern(element, subject.AsArray().Elements[i], bound, env); !matched || err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+
+	case *ast.Identifier:
+		if pat.Value != "_" {
+			bound[pat.Value] = subject
+		}
+		return true, nil
+	}
+
+	literal, err := in.eval(pattern, env)
+	if err != nil {
+		return false, err
+	}
+	return vm.Equal(subject, literal), nil
+}
+
+// evalBranch runs a branch of an if or switch expression, whose final
+// expression gives its value
+func (in *Interpreter) evalBranch(block *ast.BlockStatement, env *Environment) (vm.Value, error) {
+	last := len(block.Statements) - 1
+	for _, s := range block.Statements[:last] {
+		// The parser rejects jumps out of the branch
+		if _, err := in.execStatement(s, env); err != nil {
+			return vm.NilValue(), err
+		}
+	}
+	return in.eval(block.Statements[last].(*ast.ExpressionStatement).Expression, env)
+}
+
+// promoteBranch returns value, the value of a branch of node, an if or
+// switch expression, as a float if it is an int and the type checker found
+// the expression's branches to be ints and floats, as the compilers do
+func (in *Interpreter) promoteBranch(node ast.Expression, value vm.Value) vm.Value {
+	if value.Type == vm.IntType && in.checker.BranchType(node).Equals(compiler.FloatType) {
+		return vm.FloatValue(float64(value.AsInt()))
+	}
+	return value
+}
+
+func (in *Interpreter) evalSwitch(node *ast.SwitchExpression, env *Environment) (vm.Value, error) {
+	subject, err := in.eval(node.Value, env)
+	if err != nil {
+		return vm.NilValue(), err
+	}
+
+	for _, caseClause := range node.Cases {
+		matched, err := in.matchCase(caseClause.Value, subject, env)
+		if err != nil {
+			return vm.NilValue(), err
+		}
+		if matched {
+			return in.evalBranch(caseClause.Body, env)
+		}
+	}
+
+	if node.Default != nil {
+		return in.evalBranch(node.Default, env)
+	}
+	return vm.NilValue(), nil
+}
+
+func (in *Interpreter) evalStructLiteral(node *ast.StructLiteral, env *Environment) (vm.Value, error) {
+	fields, known := in.structTypes[node.Name.Value]
+	if !known {
+		fields := make(map[string]vm.Value, len(node.Fields))
+		for name, valueExpr := range node.Fields {
+			val, err := in.eval(valueExpr, env)
+			if err != nil {
+				return vm.NilValue(), err
+			}
+			fields[name] = val
+		}
+		return vm.NewStructValue(node.Name.Value, fields), nil
+	}
+
+	for name := range node.Fields {
+		if !slices.ContainsFunc(fields, func(f *ast.StructField) bool { return f.Name.Value == name }) {
+			return vm.NilValue(), fmt.Errorf("struct %s has no field %s", node.Name.Value, name)
+		}
+	}
+
+	names := make([]string, len(fields))
+	values := make([]vm.Value, len(fields))
+	for i, field := range fields {
+		name := field.Name.Value
+		valueExpr, exists := node.Fields[name]
+		if !exists {
+			valueExpr = field.Default
+		}
+		if valueExpr == nil {
+			return vm.NilValue(), fmt.Errorf("missing required field %s in struct %s", name, node.Name.Value)
+		}
+		val, err := in.eval(valueExpr, env)
+		if err != nil {
+			return vm.NilValue(), err
+		}
+		names[i] = name
+		values[i] = val
+	}
+	return vm.NewStructValueOrdered(node.Name.Value, names, values), nil
+}
+
+// call invokes a builtin or interpreted function
+func (in *Interpreter) call(callee vm.Value, args []vm.Value) (vm.Value, error) {
+	switch callee.Type {
+	case vm.BuiltinFunctionType:
+		return in.wrap(callee.AsBuiltinFunction()(args...))
+
+	case vm.FunctionType:
+		fn, ok := in.functions[callee.AsFunction()]
+		if !ok {
+			return vm.NilValue(), vm.ErrCallingNonFunction
+		}
+		if len(args) != len(fn.decl.Parameters) {
+			return vm.NilValue(), fmt.Errorf("function %s expects %d arguments, got %d",
+				fn.decl.Name.Value, len(fn.decl.Parameters), len(args))
+		}
+
+		if len(in.frames) >= MaxCallDepth {
+			return vm.NilValue(), vm.ErrStackOverflow
+		}
+		in.frames = append(in.frames, callFrame{name: fn.decl.Name.Value, site: in.callSite})
+		defer func() { in.frames = in.frames[:len(in.frames)-1] }()
+
+		callEnv := NewEnvironment(fn.env)
+		for i, param := range fn.decl.Parameters {
+			if in.runtimeChecks {
+				what := compiler.ParameterDescription(param.Name.Value, fn.decl.Name.Value)
+				if err := checkAnnotation(args[i], param.Type, what); err != nil {
+					return vm.NilValue(), in.positioned(err, fn.decl)
+				}
+			}
+			callEnv.define(param.Name.Value, args[i], true)
+		}
+
+		ctrl, err := in.execBlock(fn.decl.Body, callEnv)
+		if err != nil {
+			return vm.NilValue(), err
+		}
+		if ctrl == ctrlReturn {
+			result := in.returnValue
+			in.returnValue = vm.NilValue()
+			if in.runtimeChecks {
+				if err := checkAnnotation(result, fn.decl.ReturnType, compiler.ReturnDescription(fn.decl.Name.Value)); err != nil {
+					return vm.NilValue(), in.positioned(err, in.returnedBy)
+				}
+			}
+			return result, nil
+		}
+		return vm.NilValue(), nil
+
+	default:
+		return vm.NilValue(), vm.ErrCallingNonFunction
+	}
+}
+
+// checkAnnotation checks v, described as what, against the type annotation
+// declared for it, as compiled code does with runtime checks on
+func checkAnnotation(v vm.Value, annotation *ast.TypeAnnotation, what string) error {
+	want, message, ok := compiler.AnnotationCheck(compiler.ConvertASTType(annotation), what)
+	if !ok {
+		return nil
+	}
+	return vm.CheckType(v, want, message)
+}
+
+func evalPrefix(operator string, right vm.Value) (vm.Value, error) {
+	switch operator {
+	case "!":
+		return vm.BoolValue(!right.IsTruthy()), nil
+	case "-":
+		switch right.Type {
+		case vm.IntType:
+			return vm.IntValue(-right.AsInt()), nil
+		case vm.FloatType:
+			return vm.FloatValue(-right.AsFloat()), nil
+		default:
+			return vm.NilValue(), fmt.Errorf("unsupported operand type for negation: %d", right.Type)
+		}
+	default:
+		return vm.NilValue(), fmt.Errorf("unknown operator %s", operator)
+	}
+}
+
+func evalInfix(operator string, left, right vm.Value) (vm.Value, error) {
+	switch operator {
+	case "&&":
+		return vm.BoolValue(left.IsTruthy() && right.IsTruthy()), nil
+	case "||":
+		return vm.BoolValue(left.IsTruthy() || right.IsTruthy()), nil
+	case "==":
+		return vm.BoolValue(vm.Equal(left, right)), nil
+	case "!=":
+		return vm.BoolValue(!vm.Equal(left, right)), nil
+	case "<", ">", "<=", ">=":
+		return evalOrdering(operator, left, right)
+	case "+", "-", "*", "/", "%":
+		return evalArithmetic(operator, left, right)
+	case "in":
+		return vm.In(left, right)
+	default:
+		return vm.NilValue(), fmt.Errorf("unknown operator %s", operator)
+	}
+}
+
+// nilAsZero returns v, or if v is nil, the zero of other's numeric type,
+// int unless other is a float
+func nilAsZero(v, other vm.Value) vm.Value {
+	if v.Type != vm.NilType {
+		return v
+	}
+	if other.Type == vm.FloatType {
+		return vm.FloatValue(0)
+	}
+	return vm.IntValue(0)
+}
+
+func evalArithmetic(operator string, left, right vm.Value) (vm.Value, error) {
+	if operator == "+" && (left.Type == vm.StringType || right.Type == vm.StringType) {
+		return vm.StringValue(left.String() + right.String()), nil
+	}
+
+	// nil, as a map gives for a missing key, counts as 0, as in the VMs'
+	// int and float opcodes: m[k] = m[k] + 1 starts a count
+	left, right = nilAsZero(left, right), nilAsZero(right, left)
+
+	if left.Type == vm.IntType && right.Type == vm.IntType {
+		l, r := left.AsInt(), right.AsInt()
+		switch operator {
+		case "+":
+			return vm.IntValue(l + r), nil
+		case "-":
+			return vm.IntValue(l - r), nil
+		case "*":
+			return vm.IntValue(l * r), nil
+		case "/":
+			if r == 0 {
+				return vm.NilValue(), vm.ErrDivisionByZero
+			}
+			return vm.IntValue(l / r), nil
+		case "%":
+			if r == 0 {
+				return vm.NilValue(), vm.ErrModuloByZero
+			}
+			return vm.IntValue(l % r), nil
+		}
+	}
+
+	l, lok := toFloat(left)
+	r, rok := toFloat(right)
+	if !lok || !rok {
+		return vm.NilValue(), vm.ErrUnsupportedOperands
+	}
+
+	switch operator {
+	case "+":
+		return vm.FloatValue(l + r), nil
+	case "-":
+		return vm.FloatValue(l - r), nil
+	case "*":
+		return vm.FloatValue(l * r), nil
+	case "/":
+		if r == 0 {
+			return vm.NilValue(), vm.ErrDivisionByZero
+		}
+		return vm.FloatValue(l / r), nil
+	default:
+		return vm.NilValue(), vm.ErrUnsupportedOperands
+	}
+}
+
+func evalOrdering(operator string, left, right vm.Value) (vm.Value, error) {
+	var cmp int
+	if left.Type == vm.IntType && right.Type == vm.IntType {
+		l, r := left.AsInt(), right.AsInt()
+		cmp = compareOrdered(l, r)
+	} else if l, lok := toFloat(left); lok {
+		r, rok := toFloat(right)
+		if !rok {
+			return vm.NilValue(), vm.ErrUnsupportedComparison
+		}
+		cmp = compareOrdered(l, r)
+	} else if left.Type == vm.StringType && right.Type == vm.StringType {
+		cmp = compareOrdered(left.AsString(), right.AsString())
+	} else {
+		return vm.NilValue(), vm.ErrUnsupportedComparison
+	}
+
+	switch operator {
+	case "<":
+		return vm.BoolValue(cmp < 0), nil
+	case ">":
+		return vm.BoolValue(cmp > 0), nil
+	case "<=":
+		return vm.BoolValue(cmp <= 0), nil
+	default:
+		return vm.BoolValue(cmp >= 0), nil
+	}
+}
+
+func compareOrdered[T int64 | float64 | string](l, r T) int {
+	switch {
+	case l < r:
+		return -1
+	case l > r:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func toFloat(v vm.Value) (float64, bool) {
+	switch v.Type {
+	case vm.IntType:
+		return float64(v.AsInt()), true
+	case vm.FloatType:
+		return v.AsFloat(), true
+	default:
+		return 0, false
+	}
+}
+
+func evalIndex(container, index vm.Value) (vm.Value, error) {
+	switch container.Type {
+	case vm.ArrayType:
+		if index.Type != vm.IntType {
+			return vm.NilValue(), fmt.Errorf("array index must be integer, got %d", index.Type)
+		}
+		elements := container.AsArray().Elements
+		idx := int(index.AsInt())
+		if idx < 0 || idx >= len(elements) {
+			return vm.NilValue(), fmt.Errorf("array index out of bounds: %d", idx)
+		}
+		return elements[idx], nil
+
+	case vm.StringType:
+		if index.Type != vm.IntType {
+			return vm.NilValue(), fmt.Errorf("string index must be integer, got %d", index.Type)
+		}
+		str := container.AsString()
+		idx := int(index.AsInt())
+		if idx < 0 || idx >= len(str) {
+			return vm.NilValue(), fmt.Errorf("string index out of bounds: %d", idx)
+		}
+		return vm.StringValue(str[idx : idx+1]), nil
+
+	case vm.MapType:
+		if val, ok := container.AsMap().Pairs[index.ToMapKey()]; ok {
+			return val, nil
+		}
+		return vm.NilValue(), nil
+
+	default:
+		return vm.NilValue(), fmt.Errorf("index operator not supported for type %d", container.Type)
+	}
+}
@@ -0,0 +1,314 @@
+package interp
+
+import (
+	"errors"
+	"minlang/ast"
+	"minlang/lexer"
+	"minlang/parser"
+	"minlang/vm"
+	"strings"
+	"testing"
+)
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func run(t *testing.T, input string) (vm.Value, error) {
+	t.Helper()
+
+	program := parse(input)
+	in := New()
+	err := in.Run(program)
+	return in.LastValue(), err
+}
+
+func TestExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 + 2 * 3", "7"},
+		{"(5 + 2) * 3", "21"},
+		{"7 / 2", "3"},
+		{"7 % 3", "1"},
+		{"-5", "-5"},
+		{"2.5 * 2", "5.000000"},
+		{"1 + 0.5", "1.500000"},
+		{`"a" + "b" + 1`, "ab1"},
+		{"1 < 2", "true"},
+		{"2 <= 1", "false"},
+		{`"abc" == "abc"`, "true"},
+		{"true && false", "false"},
+		{"!0", "true"},
+		{"[1, 2, 3][1]", "2"},
+		{`"hello"[1]`, "e"},
+		{"len([1, 2, 3])", "3"},
+	}
+
+	for _, tt := range tests {
+		result, err := run(t, tt.input)
+		if err != nil {
+			t.Fatalf("input %q: interpreter error: %s", tt.input, err)
+		}
+		if result.String() != tt.expected {
+			t.Errorf("input %q: expected %s, got %s", tt.input, tt.expected, result.String())
+		}
+	}
+}
+
+func TestStatements(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			"ForLoopWithBreakContinue",
+			`var sum: int = 0
+for var i: int = 0; i < 10; i = i + 1 {
+    if i == 3 { continue }
+    if i == 6 { break }
+    sum = sum + i
+}
+sum`,
+			"12",
+		},
+		{
+			"RecursiveFunction",
+			`func fib(n: int): int {
+    if n < 2 { return n }
+    return fib(n - 1) + fib(n - 2)
+}
+fib(15)`,
+			"610",
+		},
+		{
+			"ClosureSeesOuterVariable",
+			`func outer(x: int): int {
+    func inner(y: int): int { return x + y }
+    return inner(10)
+}
+outer(5)`,
+			"15",
+		},
+		{
+			"StructFieldAssignment",
+			`type Point = struct { x: int, y: int }
+var p = Point{x: 1, y: 2}
+p.y = 40
+p.x + p.y`,
+			"41",
+		},
+		{
+			"MapIndexing",
+			`var m = map[string]int{"a": 1}
+m["b"] = 2
+m["a"] + m["b"]`,
+			"3",
+		},
+		{
+			"EnumSwitch",
+			`type Color = enum { Red, Green, Blue }
+var c: int = Green
+var out: string = ""
+switch c {
+case Red { out = "red" }
+case Green { out = "green" }
+case Blue { out = "blue" }
+}
+out`,
+			"green",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := run(t, tt.input)
+			if err != nil {
+				t.Fatalf("interpreter error: %s", err)
+			}
+			if result.String() != tt.expected {
+				t.Errorf("expected %s, got %s", tt.expected, result.String())
+			}
+		})
+	}
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 / 0", "1:3: division by zero"},
+		{"[1, 2][5]", "1:7: array index out of bounds: 5"},
+		{"func f(): int { return f() }\nf()", "1:25: stack overflow"},
+	}
+
+	for _, tt := range tests {
+		_, err := run(t, tt.input)
+		if err == nil {
+			t.Fatalf("input %q: expected error %q, got none", tt.input, tt.expected)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("input %q: expected error %q, got %q", tt.input, tt.expected, err.Error())
+		}
+	}
+}
+
+func TestStackTraces(t *testing.T) {
+	input := `func outer(n: int): int {
+    func inner(m: int): int {
+        var xs = [1]
+        return xs[m]
+    }
+    return inner(n) + 1
+}
+func twice(x: int): int { return outer(x) * 2 }
+print(map([0, 1], twice))`
+
+	_, err := run(t, input)
+	var runtimeErr *vm.RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected a RuntimeError, got %v", err)
+	}
+	// Closures map calls back are called from map's call, as on the VMs
+	expected := "  at inner (4:18)\n  at outer (6:17)\n  at twice (8:39)\n  at main (9:10)\n"
+	if got := runtimeErr.StackTrace(); got != expected {
+		t.Errorf("expected trace %q, got %q", expected, got)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"if false { x + 1 }", "undefined variable x"},
+		{"const a: int = 1\na = 2", "cannot assign to const variable a"},
+		{"print(1)\nbreak", "break statement outside of loop"},
+		{"switch 2 {\ncase 1 { print(1) }\n}", "switch statement must have a default case (or switch on an enum with all variants covered)"},
+		{"const z = 1 / 0", "const z: division by zero"},
+	}
+
+	for _, tt := range tests {
+		in := New()
+		var out strings.Builder
+		in.SetStdout(&out)
+		err := in.Run(parse(tt.input))
+		var compileErr *CompileError
+		if !errors.As(err, &compileErr) || err.Error() != tt.expected {
+			t.Errorf("input %q: expected compile error %q, got %v", tt.input, tt.expected, err)
+		}
+		if out.Len() != 0 {
+			t.Errorf("input %q: expected nothing to run, got output %q", tt.input, out.String())
+		}
+	}
+}
+
+func TestTypeErrors(t *testing.T) {
+	in := New()
+	var out strings.Builder
+	in.SetStdout(&out)
+	err := in.Run(parse("print(1)\nvar s: int = \"a\""))
+	if err == nil || err.Error() != "2:1: cannot assign value of type string to type int" {
+		t.Errorf("expected a type error, got %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("expected nothing to run, got output %q", out.String())
+	}
+
+	// Later parts of a program are checked with what earlier ones declared
+	in = New()
+	if err := in.Continue(parse("var n: int = 1")); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	err = in.Continue(parse("n = \"a\""))
+	if err == nil || err.Error() != "1:1: cannot assign value of type string to variable n of type int" {
+		t.Errorf("expected a type error, got %v", err)
+	}
+	if err := in.Continue(parse("n = 2")); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestRuntimeChecks(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"func f(n: int): int { return n }\nf(split(\"a\", \" \")[0])", "1:1: parameter n of f must be int, got string"},
+		{"func f(s: string): []int { return split(s, \",\") }\nf(\"a\")", ""},
+		{"func f(s: string): int { return split(s, \",\")[0] }\nf(\"a\")", "1:26: return value of f must be int, got string"},
+	}
+
+	for _, tt := range tests {
+		in := New()
+		in.SetRuntimeChecks(true)
+		err := in.Run(parse(tt.input))
+		if tt.expected == "" {
+			if err != nil {
+				t.Errorf("input %q: unexpected error %q", tt.input, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("input %q: expected error %q, got %v", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestInt32(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"2147483647 + 1", "-2147483648"},
+		{"-2147483648 - 1", "2147483647"},
+		{"65536 * 65537", "65536"},
+		{"int(3000000000.0)", "-1294967296"},
+		{"2147483648", "integer literal 2147483648 overflows int32"},
+	}
+
+	for _, tt := range tests {
+		in := New()
+		in.SetInt32(true)
+		err := in.Run(parse(tt.input))
+		got := in.LastValue().String()
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tt.expected {
+			t.Errorf("input %q: expected %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestCheckOverflow(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"9223372036854775806 + 1", "9223372036854775807"},
+		{"9223372036854775807 + 1", "1:21: integer overflow: 9223372036854775807 + 1"},
+		{"var n = -9223372036854775807; n - 2", "1:33: integer overflow: -9223372036854775807 - 2"},
+		{"4294967296 * 4294967296", "1:12: integer overflow: 4294967296 * 4294967296"},
+		{"var n = -9223372036854775807 - 1; -n", "1:35: integer overflow: -(-9223372036854775808)"},
+		{"1.5 * 2.0", "3.000000"},
+	}
+
+	for _, tt := range tests {
+		in := New()
+		in.SetCheckOverflow(true)
+		err := in.Run(parse(tt.input))
+		got := in.LastValue().String()
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tt.expected {
+			t.Errorf("input %q: expected %q, got %q", tt.input, tt.expected, got)
+		}
+	}
+}
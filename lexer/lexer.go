@@ -1,6 +1,7 @@
 package lexer
 
 import (
+	"strings"
 	"unicode"
 )
 
@@ -12,6 +13,40 @@ type Lexer struct {
 	ch           byte // current char under examination
 	line         int  // current line number
 	column       int  // current column number
+	mode         Mode // construct left open by the end of the input
+	depth        int  // unclosed (, [ and { so far
+
+	comments      int // unclosed /* */ comments, nested in one another
+	commentLine   int // position of the outermost unclosed /*
+	commentColumn int
+}
+
+// Mode says which construct, if any, the input ended inside
+type Mode int
+
+const (
+	ModeNormal       Mode = iota
+	ModeString            // inside a string literal
+	ModeBlockComment      // inside a /* */ comment
+)
+
+// State is where a Lexer stopped at the end of its input. Resume continues
+// lexing from it when more input arrives, so a REPL can read continuation
+// lines and an editor can save the State at each line start and relex from
+// an edited line until the State matches the saved one again.
+type State struct {
+	Mode   Mode
+	Line   int // Position of the next input
+	Column int
+	Depth  int // Unclosed (, [ and { so far
+
+	Comments int // Unclosed /* */ comments, nested in one another
+}
+
+// Incomplete reports whether the input so far ends inside a string, a block
+// comment or brackets, so more input is needed to finish it
+func (s State) Incomplete() bool {
+	return s.Mode != ModeNormal || s.Depth > 0
 }
 
 // New creates a new Lexer
@@ -25,6 +60,43 @@ func New(input string) *Lexer {
 	return l
 }
 
+// Resume creates a Lexer for input that continues an earlier input ending in
+// state. Inputs should break at line ends: lexing lines one after another then
+// gives the same tokens as lexing them joined, except that a string literal
+// spanning lines is returned in pieces, one per Lexer.
+func Resume(input string, state State) *Lexer {
+	l := &Lexer{
+		input:  input,
+		line:   state.Line,
+		column: state.Column - 1,
+		mode:   state.Mode,
+		depth:  state.Depth,
+	}
+	if l.mode == ModeBlockComment {
+		// The comment opened in earlier input; report it from here
+		l.comments = max(state.Comments, 1)
+		l.commentLine, l.commentColumn = state.Line, state.Column
+	}
+	l.readChar()
+	return l
+}
+
+// State returns where the lexer stopped. It describes the whole input once
+// NextToken has returned EOF.
+func (l *Lexer) State() State {
+	return State{Mode: l.mode, Line: l.line, Column: l.column, Depth: l.depth, Comments: l.comments}
+}
+
+// OpenComment returns the position of the /* opening the block comment the
+// input ended inside, if it did, once NextToken has returned EOF. A comment
+// begun in an earlier input is reported at the start of this one.
+func (l *Lexer) OpenComment() (line, column int, ok bool) {
+	if l.mode != ModeBlockComment {
+		return 0, 0, false
+	}
+	return l.commentLine, l.commentColumn, true
+}
+
 // readChar reads the next character and advances the position
 func (l *Lexer) readChar() {
 	if l.readPosition >= len(l.input) {
@@ -49,6 +121,19 @@ func (l *Lexer) peekChar() byte {
 func (l *Lexer) NextToken() Token {
 	var tok Token
 
+	// Resumed inside a string or comment left open by the previous input
+	if l.mode == ModeString && l.ch != 0 {
+		tok = Token{Type: STRING, Line: l.line, Column: l.column}
+		tok.Literal = l.readStringBody()
+		if l.mode == ModeNormal {
+			l.readChar() // skip closing quote
+		}
+		return tok
+	}
+	if l.mode == ModeBlockComment && l.ch != 0 {
+		l.skipBlockCommentBody()
+	}
+
 	l.skipWhitespace()
 
 	tok.Line = l.line
@@ -64,9 +149,19 @@ func (l *Lexer) NextToken() Token {
 			tok = newToken(ASSIGN, l.ch, l.line, l.column)
 		}
 	case '+':
-		tok = newToken(PLUS, l.ch, l.line, l.column)
+		if l.peekChar() == '+' {
+			l.readChar()
+			tok = Token{Type: INC, Literal: "++", Line: l.line, Column: l.column - 1}
+		} else {
+			tok = newToken(PLUS, l.ch, l.line, l.column)
+		}
 	case '-':
-		tok = newToken(MINUS, l.ch, l.line, l.column)
+		if l.peekChar() == '-' {
+			l.readChar()
+			tok = Token{Type: DEC, Literal: "--", Line: l.line, Column: l.column - 1}
+		} else {
+			tok = newToken(MINUS, l.ch, l.line, l.column)
+		}
 	case '*':
 		tok = newToken(ASTERISK, l.ch, l.line, l.column)
 	case '/':
@@ -121,6 +216,8 @@ func (l *Lexer) NextToken() Token {
 		} else {
 			tok = newToken(ILLEGAL, l.ch, l.line, l.column)
 		}
+	case '?':
+		tok = newToken(QUESTION, l.ch, l.line, l.column)
 	case ':':
 		tok = newToken(COLON, l.ch, l.line, l.column)
 	case ';':
@@ -131,22 +228,37 @@ func (l *Lexer) NextToken() Token {
 		tok = newToken(DOT, l.ch, l.line, l.column)
 	case '(':
 		tok = newToken(LPAREN, l.ch, l.line, l.column)
+		l.depth++
 	case ')':
 		tok = newToken(RPAREN, l.ch, l.line, l.column)
+		l.closeBracket()
 	case '{':
 		tok = newToken(LBRACE, l.ch, l.line, l.column)
+		l.depth++
 	case '}':
 		tok = newToken(RBRACE, l.ch, l.line, l.column)
+		l.closeBracket()
 	case '[':
 		tok = newToken(LBRACKET, l.ch, l.line, l.column)
+		l.depth++
 	case ']':
 		tok = newToken(RBRACKET, l.ch, l.line, l.column)
+		l.closeBracket()
 	case '"':
 		tok.Type = STRING
 		tok.Literal = l.readString()
+		if l.mode == ModeString {
+			return tok // Input ended inside the string
+		}
+	case '\'':
+		tok.Type = CHAR
+		tok.Literal = l.readCharLiteral()
+		return tok
 	case 0:
+		// Stay put so State reports where the input ended
 		tok.Literal = ""
 		tok.Type = EOF
+		return tok
 	default:
 		if isLetter(l.ch) {
 			tok.Literal = l.readIdentifier()
@@ -177,43 +289,115 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
-// readNumber reads a number (integer or float)
+// readNumber reads a number (integer or float). Digits may be separated by
+// underscores, and an integer may have a 0x, 0b or 0o base prefix; the
+// parser checks that the digits suit the base and the underscores sit
+// between them.
 func (l *Lexer) readNumber() Token {
 	line := l.line
 	column := l.column
 	position := l.position
 
-	for isDigit(l.ch) {
+	// Everything alphanumeric after a base prefix is part of the literal, so
+	// a digit outside the base is reported rather than split off
+	if l.ch == '0' && strings.IndexByte("xXbBoO", l.peekChar()) >= 0 {
+		l.readChar()
+		l.readChar()
+		for isLetter(l.ch) || isDigit(l.ch) {
+			l.readChar()
+		}
+		return Token{Type: INT, Literal: l.input[position:l.position], Line: line, Column: column}
+	}
+
+	for isDigit(l.ch) || l.ch == '_' {
 		l.readChar()
 	}
 
+	tokenType := INT
+
 	// Check if it's a float
 	if l.ch == '.' && isDigit(l.peekChar()) {
 		l.readChar() // consume '.'
-		for isDigit(l.ch) {
+		for isDigit(l.ch) || l.ch == '_' {
 			l.readChar()
 		}
-		return Token{Type: FLOAT, Literal: l.input[position:l.position], Line: line, Column: column}
+		tokenType = FLOAT
 	}
 
-	return Token{Type: INT, Literal: l.input[position:l.position], Line: line, Column: column}
+	// An exponent (1e9, 2.5E-3) also makes a float. One without digits (1e,
+	// 2e+) is kept in the literal for the parser to report.
+	if l.ch == 'e' || l.ch == 'E' {
+		digit := l.readPosition // First exponent digit, after an optional sign
+		if digit < len(l.input) && (l.input[digit] == '+' || l.input[digit] == '-') {
+			digit++
+		}
+		for l.readPosition < digit {
+			l.readChar() // consume 'e' and the sign
+		}
+		l.readChar()
+		for isDigit(l.ch) || l.ch == '_' {
+			l.readChar()
+		}
+		tokenType = FLOAT
+	}
+
+	return Token{Type: tokenType, Literal: l.input[position:l.position], Line: line, Column: column}
 }
 
 // readString reads a string literal
 func (l *Lexer) readString() string {
-	position := l.position + 1 // skip opening quote
-	for {
-		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
-			break
-		}
+	l.readChar() // skip opening quote
+	return l.readStringBody()
+}
+
+// readStringBody reads string contents up to the closing quote, which is
+// left as the current char. Running out of input leaves the string open.
+func (l *Lexer) readStringBody() string {
+	position := l.position
+	for l.ch != '"' && l.ch != 0 {
 		if l.ch == '\\' {
 			l.readChar() // skip escaped character
 		}
+		if l.ch == '\n' {
+			l.line++
+			l.column = 0
+		}
+		l.readChar()
+	}
+
+	if l.ch == 0 {
+		l.mode = ModeString
+	} else {
+		l.mode = ModeNormal
 	}
 	return l.input[position:l.position]
 }
 
+// readCharLiteral reads a character literal, quotes included, leaving the
+// char after it current. It stops at the end of the line if the literal isn't
+// closed, leaving the parser to report what it holds.
+func (l *Lexer) readCharLiteral() string {
+	position := l.position
+	l.readChar() // skip opening quote
+	for l.ch != '\'' && l.ch != '\n' && l.ch != 0 {
+		if l.ch == '\\' && l.peekChar() != '\n' && l.peekChar() != 0 {
+			l.readChar() // skip escaped character
+		}
+		l.readChar()
+	}
+	if l.ch == '\'' {
+		l.readChar() // skip closing quote
+	}
+	return l.input[position:l.position]
+}
+
+// closeBracket records a closing bracket; unmatched ones are left to the parser
+func (l *Lexer) closeBracket() {
+	if l.depth > 0 {
+		l.depth--
+	}
+}
+
 // skipWhitespace skips whitespace characters
 func (l *Lexer) skipWhitespace() {
 	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
@@ -234,17 +418,37 @@ func (l *Lexer) skipLineComment() {
 
 // skipBlockComment skips a block comment
 func (l *Lexer) skipBlockComment() {
+	l.commentLine, l.commentColumn = l.line, l.column
 	l.readChar() // skip '/'
 	l.readChar() // skip '*'
+	l.comments = 1
+	l.skipBlockCommentBody()
+}
 
+// skipBlockCommentBody skips to the end of a block comment. Comments nest,
+// so one can comment out code that holds another. Running out of input
+// leaves the comment open.
+func (l *Lexer) skipBlockCommentBody() {
 	for {
 		if l.ch == 0 {
+			l.mode = ModeBlockComment
 			break
 		}
+		if l.ch == '/' && l.peekChar() == '*' {
+			l.readChar() // skip '/'
+			l.readChar() // skip '*'
+			l.comments++
+			continue
+		}
 		if l.ch == '*' && l.peekChar() == '/' {
 			l.readChar() // skip '*'
 			l.readChar() // skip '/'
-			break
+			l.comments--
+			if l.comments == 0 {
+				l.mode = ModeNormal
+				break
+			}
+			continue
 		}
 		if l.ch == '\n' {
 			l.line++
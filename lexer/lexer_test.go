@@ -1,6 +1,7 @@
 package lexer
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -260,3 +261,249 @@ var y = 10;`
 		}
 	}
 }
+
+// lexAll returns the tokens of input up to EOF and the final state
+func lexAll(l *Lexer) ([]Token, State) {
+	var tokens []Token
+	for tok := l.NextToken(); tok.Type != EOF; tok = l.NextToken() {
+		tokens = append(tokens, tok)
+	}
+	return tokens, l.State()
+}
+
+func TestResumeByLine(t *testing.T) {
+	input := `func add(a: int,
+         b: int): int {
+	/* sums
+	   a and b */
+	return a + b;
+}
+print(add(1, 2));
+`
+	want, _ := lexAll(New(input))
+
+	var got []Token
+	var state State
+	for i, line := range strings.SplitAfter(input, "\n") {
+		var l *Lexer
+		if i == 0 {
+			l = New(line)
+		} else {
+			l = Resume(line, state)
+		}
+		var tokens []Token
+		tokens, state = lexAll(l)
+		got = append(got, tokens...)
+	}
+
+	if len(got) != len(want) {
+		t.Fatalf("expected %d tokens, got %d", len(want), len(got))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("token %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+	if state.Incomplete() {
+		t.Errorf("expected a complete state, got %+v", state)
+	}
+}
+
+func TestResumeState(t *testing.T) {
+	tests := []struct {
+		input    string
+		wantMode Mode
+		depth    int
+	}{
+		{"var x = 1;\n", ModeNormal, 0},
+		{"func f() {\n", ModeNormal, 1},
+		{"print(foo([1,\n", ModeNormal, 3},
+		{"var s = \"abc\n", ModeString, 0},
+		{"/* note\n", ModeBlockComment, 0},
+		{"}\n", ModeNormal, 0},
+	}
+
+	for _, tt := range tests {
+		_, state := lexAll(New(tt.input))
+		if state.Mode != tt.wantMode || state.Depth != tt.depth {
+			t.Errorf("%q: expected mode %d depth %d, got %+v", tt.input, tt.wantMode, tt.depth, state)
+		}
+		if state.Incomplete() != (tt.wantMode != ModeNormal || tt.depth > 0) {
+			t.Errorf("%q: wrong Incomplete for %+v", tt.input, state)
+		}
+	}
+}
+
+func TestNestedBlockComments(t *testing.T) {
+	tokens, state := lexAll(New("a /* one /* two */ still one */ b"))
+	if len(tokens) != 2 || tokens[0].Literal != "a" || tokens[1].Literal != "b" {
+		t.Errorf("expected a and b, got %+v", tokens)
+	}
+	if state.Incomplete() {
+		t.Errorf("expected a complete state, got %+v", state)
+	}
+
+	l := New("x\n  /* one /* two */\n")
+	_, state = lexAll(l)
+	if state.Mode != ModeBlockComment || state.Comments != 1 {
+		t.Fatalf("expected one open comment, got %+v", state)
+	}
+	if line, column, ok := l.OpenComment(); !ok || line != 2 || column != 3 {
+		t.Errorf("expected the comment open at 2:3, got %d:%d %t", line, column, ok)
+	}
+
+	rest, state := lexAll(Resume("*/ y\n", state))
+	if len(rest) != 1 || rest[0].Literal != "y" || rest[0].Line != 3 || rest[0].Column != 4 {
+		t.Errorf("expected y at 3:4, got %+v", rest)
+	}
+	if state.Incomplete() {
+		t.Errorf("expected a complete state, got %+v", state)
+	}
+}
+
+func TestResumeInsideString(t *testing.T) {
+	first, state := lexAll(New("print(\"one\n"))
+	rest, state := lexAll(Resume("two\");\n", state))
+
+	if last := first[len(first)-1]; last.Type != STRING || last.Literal != "one\n" {
+		t.Errorf("expected the first string piece, got %+v", last)
+	}
+	if rest[0].Type != STRING || rest[0].Literal != "two" || rest[0].Line != 2 || rest[0].Column != 1 {
+		t.Errorf("expected the rest of the string at 2:1, got %+v", rest[0])
+	}
+	if rest[1].Type != RPAREN || rest[1].Column != 5 {
+		t.Errorf("expected ) at column 5, got %+v", rest[1])
+	}
+	if state.Incomplete() {
+		t.Errorf("expected a complete state, got %+v", state)
+	}
+}
+
+func TestTokenStream(t *testing.T) {
+	s := NewTokenStream(New("a = b + 1;"))
+
+	if tok := s.Peek(2); tok.Type != IDENT || tok.Literal != "b" {
+		t.Fatalf("expected b two tokens ahead, got %+v", tok)
+	}
+	if tok := s.Next(); tok.Literal != "a" {
+		t.Fatalf("expected a, got %+v", tok)
+	}
+
+	mark := s.Mark()
+	var first []TokenType
+	for tok := s.Next(); tok.Type != EOF; tok = s.Next() {
+		first = append(first, tok.Type)
+	}
+	if s.Peek(5).Type != EOF || s.Next().Type != EOF {
+		t.Error("expected the stream to stay at EOF")
+	}
+
+	s.Reset(mark)
+	for i, want := range first {
+		if tok := s.Next(); tok.Type != want {
+			t.Errorf("token %d after Reset: expected %s, got %s", i, want, tok.Type)
+		}
+	}
+}
+
+func TestTokenStreamReadAll(t *testing.T) {
+	l := New("a = b + 1;")
+	s := NewTokenStream(l)
+	if tok := s.Next(); tok.Literal != "a" {
+		t.Fatalf("expected a, got %+v", tok)
+	}
+
+	s.ReadAll()
+	if tok := l.NextToken(); tok.Type != EOF {
+		t.Fatalf("expected ReadAll to lex the whole input, lexer still had %+v", tok)
+	}
+
+	expected := []TokenType{ASSIGN, IDENT, PLUS, INT, SEMICOLON, EOF}
+	for i, want := range expected {
+		if tok := s.Next(); tok.Type != want {
+			t.Errorf("token %d after ReadAll: expected %s, got %s", i, want, tok.Type)
+		}
+	}
+}
+
+func TestExponentLiterals(t *testing.T) {
+	tokens, _ := lexAll(New("1e9 2.5E-3 4e+2 7e 8e- x2e3"))
+
+	expected := []struct {
+		typ     TokenType
+		literal string
+	}{
+		{FLOAT, "1e9"},
+		{FLOAT, "2.5E-3"},
+		{FLOAT, "4e+2"},
+		{FLOAT, "7e"}, // Malformed, but one token for the parser to report
+		{FLOAT, "8e-"},
+		{IDENT, "x2e3"},
+	}
+
+	for i, want := range expected {
+		if tokens[i].Type != want.typ || tokens[i].Literal != want.literal {
+			t.Errorf("token %d: expected %s %q, got %s %q", i, want.typ, want.literal, tokens[i].Type, tokens[i].Literal)
+		}
+	}
+}
+
+func TestBasePrefixAndUnderscoreLiterals(t *testing.T) {
+	tokens, _ := lexAll(New("0xFF 0b1010 0o755 1_000 2_5.0_1 0x1G2 1__0 x_1"))
+
+	expected := []struct {
+		typ     TokenType
+		literal string
+	}{
+		{INT, "0xFF"},
+		{INT, "0b1010"},
+		{INT, "0o755"},
+		{INT, "1_000"},
+		{FLOAT, "2_5.0_1"},
+		{INT, "0x1G2"}, // Malformed, but one token for the parser to report
+		{INT, "1__0"},
+		{IDENT, "x_1"},
+	}
+
+	for i, want := range expected {
+		if tokens[i].Type != want.typ || tokens[i].Literal != want.literal {
+			t.Errorf("token %d: expected %s %q, got %s %q", i, want.typ, want.literal, tokens[i].Type, tokens[i].Literal)
+		}
+	}
+}
+
+func TestCharLiterals(t *testing.T) {
+	tokens, _ := lexAll(New("'a' '\\'' 'ab' x 'c\ny"))
+
+	expected := []struct {
+		typ     TokenType
+		literal string
+	}{
+		{CHAR, "'a'"},
+		{CHAR, "'\\''"},
+		{CHAR, "'ab'"}, // Too long, but one token for the parser to report
+		{IDENT, "x"},
+		{CHAR, "'c"}, // Unterminated, so it ends with the line
+		{IDENT, "y"},
+	}
+
+	for i, want := range expected {
+		if tokens[i].Type != want.typ || tokens[i].Literal != want.literal {
+			t.Errorf("token %d: expected %s %q, got %s %q", i, want.typ, want.literal, tokens[i].Type, tokens[i].Literal)
+		}
+	}
+}
+
+func TestIncrementTokens(t *testing.T) {
+	tokens, _ := lexAll(New("i++ j-- a+ +b c - -d"))
+
+	expected := []TokenType{IDENT, INC, IDENT, DEC, IDENT, PLUS, PLUS, IDENT, IDENT, MINUS, MINUS, IDENT}
+	for i, want := range expected {
+		if tokens[i].Type != want {
+			t.Errorf("token %d: expected %s, got %s %q", i, want, tokens[i].Type, tokens[i].Literal)
+		}
+	}
+	if tokens[1].Column != 2 {
+		t.Errorf("expected ++ at column 2, got %d", tokens[1].Column)
+	}
+}
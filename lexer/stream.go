@@ -0,0 +1,68 @@
+package lexer
+
+// TokenStream reads tokens from a Lexer with any amount of lookahead. Mark
+// and Reset let a parser try one reading of the input and back up to try
+// another.
+//
+// The stream keeps every token it has read, so a Mark stays valid for the
+// life of the stream. Programs are small enough that this costs little.
+type TokenStream struct {
+	l      *Lexer
+	tokens []Token // Tokens read from l so far
+	pos    int     // Index of the token Next returns
+}
+
+// Mark is a position in a TokenStream
+type Mark int
+
+// NewTokenStream creates a TokenStream reading from l
+func NewTokenStream(l *Lexer) *TokenStream {
+	return &TokenStream{l: l}
+}
+
+// Next returns the next token and moves past it. At the end of the input it
+// keeps returning EOF.
+func (s *TokenStream) Next() Token {
+	tok := s.Peek(0)
+	if tok.Type != EOF {
+		s.pos++
+	}
+	return tok
+}
+
+// Peek returns the token n places after the next one without consuming
+// anything; Peek(0) is the token Next would return
+func (s *TokenStream) Peek(n int) Token {
+	for len(s.tokens) <= s.pos+n {
+		if len(s.tokens) > 0 && s.tokens[len(s.tokens)-1].Type == EOF {
+			return s.tokens[len(s.tokens)-1]
+		}
+		s.tokens = append(s.tokens, s.l.NextToken())
+	}
+	return s.tokens[s.pos+n]
+}
+
+// ReadAll lexes the rest of the input now, so reading from the stream
+// afterwards does no lexing
+func (s *TokenStream) ReadAll() {
+	for len(s.tokens) == 0 || s.tokens[len(s.tokens)-1].Type != EOF {
+		s.tokens = append(s.tokens, s.l.NextToken())
+	}
+}
+
+// OpenComment is the lexer's OpenComment: the position of the block comment
+// left open at the end of the input, if any, once the stream has reached EOF
+func (s *TokenStream) OpenComment() (line, column int, ok bool) {
+	return s.l.OpenComment()
+}
+
+// Mark returns the current position for a later Reset
+func (s *TokenStream) Mark() Mark {
+	return Mark(s.pos)
+}
+
+// Reset moves back (or forward) to m, so Next returns the same tokens it
+// returned after m was taken
+func (s *TokenStream) Reset(m Mark) {
+	s.pos = int(m)
+}
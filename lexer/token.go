@@ -15,6 +15,7 @@ const (
 	INT    // 123
 	FLOAT  // 123.456
 	STRING // "hello"
+	CHAR   // 'a'
 
 	// Keywords
 	VAR
@@ -43,6 +44,8 @@ const (
 	ASTERISK // *
 	SLASH    // /
 	PERCENT  // %
+	INC      // ++
+	DEC      // --
 
 	EQ     // ==
 	NE     // !=
@@ -60,6 +63,7 @@ const (
 	SEMICOLON // ;
 	COMMA     // ,
 	DOT       // .
+	QUESTION  // ?
 
 	LPAREN   // (
 	RPAREN   // )
@@ -114,6 +118,8 @@ func (t TokenType) String() string {
 		return "FLOAT"
 	case STRING:
 		return "STRING"
+	case CHAR:
+		return "CHAR"
 	case VAR:
 		return "VAR"
 	case CONST:
@@ -162,6 +168,10 @@ func (t TokenType) String() string {
 		return "/"
 	case PERCENT:
 		return "%"
+	case INC:
+		return "++"
+	case DEC:
+		return "--"
 	case EQ:
 		return "=="
 	case NE:
@@ -190,6 +200,8 @@ func (t TokenType) String() string {
 		return ","
 	case DOT:
 		return "."
+	case QUESTION:
+		return "?"
 	case LPAREN:
 		return "("
 	case RPAREN:
@@ -0,0 +1,161 @@
+// Package minlang runs MinLang programs from Go.
+//
+// Run wires the lexer, parser, compiler and stack VM together so an embedding
+// program doesn't have to repeat the plumbing in cmd/minlang:
+//
+//	result, err := minlang.Run(`1 + 2`, minlang.WithStdout(&buf))
+//
+// RunReader and RunFS take the source from an io.Reader or a file in an
+// fs.FS, so programs can come from embedded files or in-memory file systems
+// as well as the disk.
+package minlang
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"minlang/compiler"
+	"minlang/lexer"
+	"minlang/parser"
+	"minlang/vm"
+	"strings"
+)
+
+// Value is a MinLang runtime value
+type Value = vm.Value
+
+// ParseError reports every syntax error found in a program
+type ParseError struct {
+	Messages []string
+}
+
+func (e *ParseError) Error() string {
+	return "parser errors: " + strings.Join(e.Messages, "; ")
+}
+
+// Option configures Run
+type Option func(*config)
+
+// config collects the settings applied by Options
+type config struct {
+	vmOptions     []vm.Option
+	promoteIntDiv bool
+	runtimeChecks bool
+	int32Mode     bool
+	checkOverflow bool
+	sourceName    string
+}
+
+// WithStdout sends program output (print) to w instead of os.Stdout
+func WithStdout(w io.Writer) Option {
+	return func(c *config) {
+		c.vmOptions = append(c.vmOptions, vm.WithStdout(w))
+	}
+}
+
+// WithStdin makes input and readLine read from r instead of os.Stdin
+func WithStdin(r io.Reader) Option {
+	return func(c *config) {
+		c.vmOptions = append(c.vmOptions, vm.WithStdin(r))
+	}
+}
+
+// WithArgs sets the command-line arguments args() returns to the program
+func WithArgs(args []string) Option {
+	return func(c *config) {
+		c.vmOptions = append(c.vmOptions, vm.WithArgs(args))
+	}
+}
+
+// WithPromoteIntDiv makes / between ints produce a float
+func WithPromoteIntDiv(enabled bool) Option {
+	return func(c *config) {
+		c.promoteIntDiv = enabled
+	}
+}
+
+// WithRuntimeChecks makes functions check their arguments and return values
+// against their type annotations as the program runs
+func WithRuntimeChecks(enabled bool) Option {
+	return func(c *config) {
+		c.runtimeChecks = enabled
+	}
+}
+
+// WithInt32 makes ints 32 bits wide, wrapping around on overflow
+func WithInt32(enabled bool) Option {
+	return func(c *config) {
+		c.int32Mode = enabled
+	}
+}
+
+// WithCheckOverflow makes int arithmetic that overflows a runtime error
+// instead of wrapping around. Run rejects it together with WithInt32, whose
+// ints wrap by design.
+func WithCheckOverflow(enabled bool) Option {
+	return func(c *config) {
+		c.checkOverflow = enabled
+	}
+}
+
+// WithSourceName names the program in runtime error positions and traces
+func WithSourceName(name string) Option {
+	return func(c *config) {
+		c.sourceName = name
+	}
+}
+
+// Run compiles source, optimizes the bytecode and executes it on the stack
+// VM. It returns the value of the last expression statement the program ran
+// outside functions, or nil if there is none. Syntax errors are returned as a
+// *ParseError and runtime errors as a *vm.RuntimeError.
+func Run(source string, opts ...Option) (Value, error) {
+	var cfg config
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	if cfg.checkOverflow && cfg.int32Mode {
+		return vm.NilValue(), errors.New("WithCheckOverflow can't be combined with WithInt32, whose ints wrap around")
+	}
+
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+	if len(p.Errors()) > 0 {
+		return vm.NilValue(), &ParseError{Messages: p.Errors()}
+	}
+
+	c := compiler.New()
+	c.SetPromoteIntDiv(cfg.promoteIntDiv)
+	c.SetRuntimeChecks(cfg.runtimeChecks)
+	c.SetInt32(cfg.int32Mode)
+	c.SetCheckOverflow(cfg.checkOverflow)
+	if err := c.Compile(program); err != nil {
+		return vm.NilValue(), err
+	}
+
+	machine := vm.New(vm.Optimize(c.Bytecode()), cfg.vmOptions...)
+	if err := machine.Run(); err != nil {
+		return vm.NilValue(), vm.WithSourceFile(err, cfg.sourceName)
+	}
+	return machine.LastValue(), nil
+}
+
+// RunReader reads a program from r and runs it like Run
+func RunReader(r io.Reader, opts ...Option) (Value, error) {
+	source, err := io.ReadAll(r)
+	if err != nil {
+		return vm.NilValue(), err
+	}
+	return Run(string(source), opts...)
+}
+
+// RunFS reads the program in the file name of fsys and runs it like Run.
+// Runtime errors name the file unless WithSourceName gives another name.
+func RunFS(fsys fs.FS, name string, opts ...Option) (Value, error) {
+	source, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return vm.NilValue(), err
+	}
+	return Run(string(source), append([]Option{WithSourceName(name)}, opts...)...)
+}
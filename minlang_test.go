@@ -0,0 +1,309 @@
+package minlang_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"minlang"
+	"minlang/compiler"
+	"minlang/interp"
+	"minlang/lexer"
+	"minlang/parser"
+	"minlang/vm"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+)
+
+func TestRunReturnsLastValue(t *testing.T) {
+	var stdout bytes.Buffer
+	result, err := minlang.Run(`
+func double(n: int): int {
+    return n * 2
+}
+print("hello")
+double(21)
+`, minlang.WithStdout(&stdout))
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if result.Type != vm.IntType || result.AsInt() != 42 {
+		t.Errorf("expected 42, got %s", result.String())
+	}
+	if stdout.String() != "hello\n" {
+		t.Errorf("stdout got %q", stdout.String())
+	}
+}
+
+// TestRunResultSkipsDeclarations checks that declarations and assignments
+// after the last expression statement don't change the result
+func TestRunResultSkipsDeclarations(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{"1 + 2; var x = 5;", "3"},
+		{"var x = 5; x = 7;", "nil"},
+		{"var x = 5; x = 7; x", "7"},
+	}
+	for _, tt := range tests {
+		result, err := minlang.Run(tt.source)
+		if err != nil {
+			t.Fatalf("%q: run failed: %v", tt.source, err)
+		}
+		if result.String() != tt.expected {
+			t.Errorf("%q: expected %s, got %s", tt.source, tt.expected, result.String())
+		}
+	}
+}
+
+// TestExitHooksKeepResult checks that exit hooks run after the program
+// without changing its result
+func TestExitHooksKeepResult(t *testing.T) {
+	var stdout bytes.Buffer
+	result, err := minlang.Run(`
+func cleanup() {
+    var scratch = [1, 2, 3]
+    print("cleanup", len(scratch))
+}
+onExit(cleanup)
+40 + 2
+`, minlang.WithStdout(&stdout))
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if result.Type != vm.IntType || result.AsInt() != 42 {
+		t.Errorf("expected 42, got %s", result.String())
+	}
+	if stdout.String() != "cleanup 3\n" {
+		t.Errorf("stdout got %q", stdout.String())
+	}
+}
+
+// TestExitRunsHooks checks that exit stops the program from inside a call,
+// runs the exit hooks, and reports its status
+func TestExitRunsHooks(t *testing.T) {
+	var stdout bytes.Buffer
+	_, err := minlang.Run(`
+func cleanup() {
+    print("cleanup")
+}
+func fail(n: int) {
+    if n > 1 {
+        exit(n)
+    }
+    print("ok")
+}
+onExit(cleanup)
+fail(1)
+fail(2)
+print("unreachable")
+`, minlang.WithStdout(&stdout))
+	var exit *vm.ExitError
+	if !errors.As(err, &exit) || exit.Code != 2 {
+		t.Fatalf("expected exit status 2, got %v", err)
+	}
+	if stdout.String() != "ok\ncleanup\n" {
+		t.Errorf("stdout got %q", stdout.String())
+	}
+
+	if _, err := minlang.Run(`exit(0)`); err != nil {
+		t.Errorf("exit(0) returned %v, want nil", err)
+	}
+}
+
+func TestRunOptions(t *testing.T) {
+	result, err := minlang.Run(`7 / 2`, minlang.WithPromoteIntDiv(true))
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if result.Type != vm.FloatType || result.AsFloat() != 3.5 {
+		t.Errorf("expected 3.5, got %s", result.String())
+	}
+
+	result, err = minlang.Run(`var n = 2147483647; n + 1`, minlang.WithInt32(true))
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if result.Type != vm.IntType || result.AsInt() != -2147483648 {
+		t.Errorf("expected -2147483648, got %s", result.String())
+	}
+
+	_, err = minlang.Run(`var n = 9223372036854775807; n + 1`, minlang.WithCheckOverflow(true))
+	if err == nil || err.Error() != "1:32: integer overflow: 9223372036854775807 + 1" {
+		t.Errorf("expected an overflow error, got %v", err)
+	}
+
+	// int32 ints wrap by design, so they can't also stop on overflow
+	_, err = minlang.Run(`1`, minlang.WithCheckOverflow(true), minlang.WithInt32(true))
+	if err == nil || !strings.Contains(err.Error(), "can't be combined") {
+		t.Errorf("expected the options to be rejected together, got %v", err)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	_, err := minlang.Run(`var x: int = ;`)
+	var parseErr *minlang.ParseError
+	if !errors.As(err, &parseErr) || len(parseErr.Messages) == 0 {
+		t.Errorf("expected a ParseError, got %v", err)
+	}
+
+	_, err = minlang.Run("var xs: []int = [1];\nxs[3]", minlang.WithSourceName("prog.min"))
+	var runtimeErr *vm.RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected a RuntimeError, got %v", err)
+	}
+	if !strings.HasPrefix(err.Error(), "prog.min:2:") {
+		t.Errorf("expected the source name in the error, got %q", err.Error())
+	}
+
+	// Builtin errors abort the program at the call
+	_, err = minlang.Run("var x: float = sqrt(-1.0);\nprint(x)")
+	if err == nil || err.Error() != "1:20: sqrt: argument must be non-negative" {
+		t.Errorf("expected the sqrt error, got %v", err)
+	}
+}
+
+func TestRunFromReaderAndFS(t *testing.T) {
+	var stdout bytes.Buffer
+	result, err := minlang.RunReader(strings.NewReader(`print("hi"); 6 * 7`), minlang.WithStdout(&stdout))
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if result.AsInt() != 42 || stdout.String() != "hi\n" {
+		t.Errorf("expected 42 and \"hi\\n\", got %s and %q", result.String(), stdout.String())
+	}
+
+	fsys := fstest.MapFS{
+		"progs/answer.min": {Data: []byte("40 + 2")},
+		"progs/broken.min": {Data: []byte("var xs: []int = [1];\nxs[3]")},
+	}
+	result, err = minlang.RunFS(fsys, "progs/answer.min")
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if result.AsInt() != 42 {
+		t.Errorf("expected 42, got %s", result.String())
+	}
+
+	// Runtime errors name the file, unless another name is given
+	_, err = minlang.RunFS(fsys, "progs/broken.min")
+	if err == nil || !strings.HasPrefix(err.Error(), "progs/broken.min:2:") {
+		t.Errorf("expected the file name in the error, got %v", err)
+	}
+	_, err = minlang.RunFS(fsys, "progs/broken.min", minlang.WithSourceName("renamed.min"))
+	if err == nil || !strings.HasPrefix(err.Error(), "renamed.min:2:") {
+		t.Errorf("expected the given name in the error, got %v", err)
+	}
+
+	if _, err := minlang.RunFS(fsys, "progs/missing.min"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("expected fs.ErrNotExist, got %v", err)
+	}
+}
+
+// hostRepeat is registered once per test binary; registering a name twice panics
+var _ = vm.RegisterBuiltin("hostRepeat", func(args ...vm.Value) (vm.Value, error) {
+	if len(args) != 2 || args[0].Type != vm.StringType || args[1].Type != vm.IntType {
+		return vm.NilValue(), errors.New("hostRepeat: want a string and an int")
+	}
+	return vm.StringValue(strings.Repeat(args[0].AsString(), int(args[1].AsInt()))), nil
+})
+
+func TestRegisteredBuiltin(t *testing.T) {
+	source := `
+var s: string = hostRepeat("ab", 3);
+var f = hostRepeat;
+s + f("x", 2)
+`
+	result, err := minlang.Run(source)
+	if err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if got := result.AsString(); got != "abababxx" {
+		t.Errorf("stack VM got %q", got)
+	}
+
+	p := parser.New(lexer.New(source))
+	program := p.ParseProgram()
+
+	c := compiler.New()
+	if err := c.Compile(program); err != nil {
+		t.Fatalf("compile failed: %v", err)
+	}
+	translated, err := vm.TranslateToRegister(c.Bytecode())
+	if err != nil {
+		t.Fatalf("translation failed: %v", err)
+	}
+	if err := vm.NewRegisterVM(translated).Run(); err != nil {
+		t.Errorf("translated run failed: %v", err)
+	}
+
+	// The native register compiler invokes builtins past index 15 as values
+	rc := compiler.NewRegisterCompiler()
+	if _, err := rc.CompileToRegister(program); err != nil {
+		t.Fatalf("register compilation failed: %v", err)
+	}
+	if err := vm.NewRegisterVM(rc.RegisterBytecode()).Run(); err != nil {
+		t.Errorf("register run failed: %v", err)
+	}
+
+	in := interp.New()
+	if err := in.Run(program); err != nil {
+		t.Fatalf("interp failed: %v", err)
+	}
+	if got := in.LastValue().AsString(); got != "abababxx" {
+		t.Errorf("interp got %q", got)
+	}
+}
+
+// TestConcurrentPrograms runs programs with different enums and output
+// streams side by side on every backend; run it with -race
+func TestConcurrentPrograms(t *testing.T) {
+	program := func(i int) string {
+		return fmt.Sprintf(`
+enum Color { Red, Green, Blue }
+enum Size { S%d, M%d }
+var xs: []int = [1, 2, 3];
+print(enumName("Size", 1), enumValue("Color", "Blue"), len(xs) + %d);
+`, i, i, i)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			source := program(i)
+			want := fmt.Sprintf("M%d 2 %d\n", i, 3+i)
+
+			var stdout bytes.Buffer
+			if _, err := minlang.Run(source, minlang.WithStdout(&stdout)); err != nil {
+				t.Errorf("stack %d: %v", i, err)
+			} else if stdout.String() != want {
+				t.Errorf("stack %d: got %q, want %q", i, stdout.String(), want)
+			}
+
+			p := parser.New(lexer.New(source))
+			program := p.ParseProgram()
+			c := compiler.New()
+			if err := c.Compile(program); err != nil {
+				t.Errorf("compile %d: %v", i, err)
+				return
+			}
+			translated, err := vm.TranslateToRegister(c.Bytecode())
+			if err != nil {
+				t.Errorf("translate %d: %v", i, err)
+				return
+			}
+			stdout.Reset()
+			if err := vm.NewRegisterVM(translated, vm.WithStdout(&stdout)).Run(); err != nil {
+				t.Errorf("register %d: %v", i, err)
+			} else if stdout.String() != want {
+				t.Errorf("register %d: got %q, want %q", i, stdout.String(), want)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
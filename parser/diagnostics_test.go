@@ -0,0 +1,302 @@
+package parser
+
+import (
+	"math"
+	"minlang/ast"
+	"minlang/lexer"
+	"strings"
+	"testing"
+)
+
+// TestNumericLiteralDiagnostics pins down how the edges of the int and float
+// ranges parse: in range literals keep their exact value, out of range ones
+// are errors rather than saturating or wrapping
+func TestNumericLiteralDiagnostics(t *testing.T) {
+	tests := []struct {
+		input string
+		want  interface{} // int64 or float64 value, or an error substring
+	}{
+		{"9223372036854775807", int64(math.MaxInt64)},
+		{"-9223372036854775808", int64(math.MinInt64)},
+		{"9223372036854775808", "integer literal 9223372036854775808 overflows int at line 1, column 1"},
+		{"-9223372036854775809", "integer literal 9223372036854775809 overflows int at line 1, column 2"},
+		{"99999999999999999999999", "overflows int"},
+		{"1.5e3", 1500.0},
+		{"2E+2", 200.0},
+		{"2.5e-3", 0.0025},
+		{"1.7976931348623157e308", math.MaxFloat64},
+		{"5e-324", math.SmallestNonzeroFloat64},
+		{"0.0e-999", 0.0},
+		{"1e400", "float literal 1e400 overflows float at line 1, column 1"},
+		{"1" + strings.Repeat("0", 309) + ".0", "overflows float"},
+		{"1e-400", "float literal 1e-400 underflows to 0 at line 1, column 1"},
+		{"1e", "malformed exponent in float literal 1e at line 1, column 1"},
+		{"2.5E+", "malformed exponent in float literal 2.5E+"},
+		{"0xFF", int64(255)},
+		{"0B1010", int64(10)},
+		{"0o755", int64(493)},
+		{"0755", int64(755)},
+		{"1_000_000", int64(1000000)},
+		{"0xdead_BEEF", int64(0xdeadbeef)},
+		{"1_000.000_5", 1000.0005},
+		{"1_5e1_0", 1.5e11},
+		{"0x7fffffffffffffff", int64(math.MaxInt64)},
+		{"-0x8000000000000000", int64(math.MinInt64)},
+		{"0x8000000000000000", "integer literal 0x8000000000000000 overflows int at line 1, column 1"},
+		{"0x", "hexadecimal literal 0x has no digits at line 1, column 1"},
+		{"0b102", "invalid digit '2' in binary literal 0b102 at line 1, column 1"},
+		{"0o8", "invalid digit '8' in octal literal 0o8"},
+		{"0xFG", "invalid digit 'G' in hexadecimal literal 0xFG"},
+		{"1__000", "'_' must separate successive digits in 1__000 at line 1, column 1"},
+		{"1_000_", "'_' must separate successive digits in 1_000_"},
+		{"0x_FF", "'_' must separate successive digits in 0x_FF"},
+		{"1_.5", "'_' must separate successive digits in 1_.5"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+
+		if want, ok := tt.want.(string); ok {
+			if len(p.Errors()) == 0 || !strings.Contains(p.Errors()[0], want) {
+				t.Errorf("%s: expected error containing %q, got %q", tt.input, want, p.Errors())
+			}
+			continue
+		}
+
+		if len(p.Errors()) > 0 {
+			t.Errorf("%s: unexpected errors %q", tt.input, p.Errors())
+			continue
+		}
+		if len(program.Statements) != 1 {
+			t.Errorf("%s: expected 1 statement, got %d", tt.input, len(program.Statements))
+			continue
+		}
+		exp := program.Statements[0].(*ast.ExpressionStatement).Expression
+
+		switch want := tt.want.(type) {
+		case int64:
+			lit, ok := exp.(*ast.IntegerLiteral)
+			if !ok || lit.Value != want {
+				t.Errorf("%s: expected integer %d, got %s", tt.input, want, exp.String())
+			}
+		case float64:
+			lit, ok := exp.(*ast.FloatLiteral)
+			if !ok || lit.Value != want {
+				t.Errorf("%s: expected float %g, got %s", tt.input, want, exp.String())
+			}
+		}
+	}
+}
+
+// TestCharLiteralDiagnostics checks that a character literal is the int code
+// point of its one character or escape
+func TestCharLiteralDiagnostics(t *testing.T) {
+	tests := []struct {
+		input string
+		want  interface{} // int64 value or an error substring
+	}{
+		{"'a'", int64('a')},
+		{"'é'", int64(0xe9)},
+		{"'\\n'", int64('\n')},
+		{"'\\''", int64('\'')},
+		{"'\\\\'", int64('\\')},
+		{"'\\0'", int64(0)},
+		{"'ab'", "character literal 'ab' holds more than one character at line 1, column 1"},
+		{"''", "empty character literal ''"},
+		{"'\\q'", "unknown escape in character literal '\\q'"},
+		{"'a", "unterminated character literal 'a at line 1, column 1"},
+		{"'\\'", "unterminated character literal '\\'"},
+		{"'\xff'", "character literal '\xff' is not valid UTF-8"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+
+		if want, ok := tt.want.(string); ok {
+			if len(p.Errors()) == 0 || !strings.Contains(p.Errors()[0], want) {
+				t.Errorf("%s: expected error containing %q, got %q", tt.input, want, p.Errors())
+			}
+			continue
+		}
+
+		if len(p.Errors()) > 0 {
+			t.Errorf("%s: unexpected errors %q", tt.input, p.Errors())
+			continue
+		}
+		exp := program.Statements[0].(*ast.ExpressionStatement).Expression
+		lit, ok := exp.(*ast.IntegerLiteral)
+		if !ok || lit.Value != tt.want.(int64) || lit.String() != tt.input {
+			t.Errorf("%s: expected integer %d, got %s", tt.input, tt.want, exp.String())
+		}
+	}
+}
+
+// TestStringLiteralDiagnostics checks that a string literal's escapes are
+// those of character literals, plus \"
+func TestStringLiteralDiagnostics(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string // value, or an error substring when err is set
+		err   bool
+	}{
+		{`"plain"`, "plain", false},
+		{`"a\tb\n"`, "a\tb\n", false},
+		{`"say \"hi\""`, `say "hi"`, false},
+		{`"\\ \' \r \0"`, "\\ ' \r \x00", false},
+		{`"é\n"`, "é\n", false},
+		{`"\q"`, `unknown escape in string literal "\q" at line 1, column 1`, true},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+
+		if tt.err {
+			if len(p.Errors()) == 0 || !strings.Contains(p.Errors()[0], tt.want) {
+				t.Errorf("%s: expected error containing %q, got %q", tt.input, tt.want, p.Errors())
+			}
+			continue
+		}
+
+		if len(p.Errors()) > 0 {
+			t.Errorf("%s: unexpected errors %q", tt.input, p.Errors())
+			continue
+		}
+		exp := program.Statements[0].(*ast.ExpressionStatement).Expression
+		lit, ok := exp.(*ast.StringLiteral)
+		if !ok || lit.Value != tt.want || lit.String() != tt.input {
+			t.Errorf("%s: expected string %q, got %s", tt.input, tt.want, exp.String())
+		}
+	}
+}
+
+// TestBranchExpressionDiagnostics checks that an if or switch used as a value
+// has a value on every path and can't jump out of the expression
+func TestBranchExpressionDiagnostics(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"var x = if a { 1 }", "if expression needs an else branch at line 1, column 9"},
+		{"var x = if a { 1 } else if b { 2 }", "if expression needs an else branch at line 1, column 25"},
+		{"var x = if a { } else { 2 }", "branch of an if or switch expression needs a value at line 1, column 14"},
+		{"var x = if a { var y = 1 } else { 2 }", "must end with a value"},
+		{"var x = switch a { case 1 { 1 } default { print(1); var z = 2 } }", "must end with a value at line 1, column 41"},
+		{"for a { var x = if a { break; 1 } else { 2 } }", "break can't leave an if or switch expression at line 1, column 24"},
+		{"var x = if a { return 1; 2 } else { 2 }", "return can't leave an if or switch expression"},
+		{"var x = if a { for b { if c { break } }\n 1 } else { 2 }", ""},
+		{"var x = if a { func f() { return 1 }\n f() } else { 2 }", ""},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		if tt.want == "" {
+			if len(p.Errors()) > 0 {
+				t.Errorf("%s: unexpected errors %q", tt.input, p.Errors())
+			}
+			continue
+		}
+		if len(p.Errors()) == 0 || !strings.Contains(p.Errors()[0], tt.want) {
+			t.Errorf("%s: expected error containing %q, got %q", tt.input, tt.want, p.Errors())
+		}
+	}
+}
+
+// TestIncrementDiagnostics checks that ++ and -- only apply to what can be
+// evaluated twice
+func TestIncrementDiagnostics(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"f()++", "cannot use ++ on f(): it must be a variable, or an element or field found without calls at line 1, column 4"},
+		{"xs[f()]--", "cannot use -- on (xs[f()])"},
+		{"next().n++", "cannot use ++ on (next().n)"},
+		{"5++", "cannot use ++ on 5"},
+		{"xs[i - 1]++", ""},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		if tt.want == "" {
+			if len(p.Errors()) > 0 {
+				t.Errorf("%s: unexpected errors %q", tt.input, p.Errors())
+			}
+			continue
+		}
+		if len(p.Errors()) == 0 || !strings.Contains(p.Errors()[0], tt.want) {
+			t.Errorf("%s: expected error containing %q, got %q", tt.input, tt.want, p.Errors())
+		}
+	}
+}
+
+// TestAssignmentDiagnostics checks what can be assigned, in a chain of
+// assignments, and in the post statement of a for loop
+func TestAssignmentDiagnostics(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"f() = 1", "cannot assign to f(): it must be a variable, element or field at line 1, column 5"},
+		{"a + 1 = 2", "cannot assign to (a + 1)"},
+		{"a = 1 = 2", "cannot assign to 1"},
+		{"a = g().x = 1", "cannot chain assignment to (g().x): it must be a variable, or an element or field found without calls at line 1, column 11"},
+		{"i = xs[i] = 0", "cannot chain assignments to i and (xs[i]): assigning i moves (xs[i])"},
+		{"p.xs[i] = i = 0", "cannot chain assignments to i and ((p.xs)[i])"},
+		{"for var i = 0; i < 2; i + 1 { }", "for loop post must be an assignment, ++, -- or call, got (i + 1) at line 1, column 23"},
+		{"for var i = 0; i < 2; i++; { }", "unexpected ; after for loop post at line 1, column 26"},
+		{"a = b = xs[i] = p.x = 0", ""},
+		{"xs[0] = xs[1] = 0", ""},
+		{"for var i = 0; i < 2; tick() { }", ""},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		if tt.want == "" {
+			if len(p.Errors()) > 0 {
+				t.Errorf("%s: unexpected errors %q", tt.input, p.Errors())
+			}
+			continue
+		}
+		if len(p.Errors()) == 0 || !strings.Contains(p.Errors()[0], tt.want) {
+			t.Errorf("%s: expected error containing %q, got %q", tt.input, tt.want, p.Errors())
+		}
+	}
+}
+
+// TestBlockCommentDiagnostics checks that block comments nest and that one
+// left open is reported where it began
+func TestBlockCommentDiagnostics(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"/* a /* b */ c */ var x = 1", ""},
+		{"var x = 1 /* /* */", "unterminated block comment starting at line 1, column 11"},
+		{"var x = 1\n/* a\n/* b */\nvar y = 2", "unterminated block comment starting at line 2, column 1"},
+		{"var x = 1 */", "no prefix parse function for / found"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+
+		if tt.want == "" {
+			if len(p.Errors()) > 0 {
+				t.Errorf("%q: unexpected errors %q", tt.input, p.Errors())
+			}
+			continue
+		}
+		if len(p.Errors()) == 0 || !strings.Contains(strings.Join(p.Errors(), "\n"), tt.want) {
+			t.Errorf("%q: expected error containing %q, got %q", tt.input, tt.want, p.Errors())
+		}
+	}
+}
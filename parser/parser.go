@@ -1,27 +1,34 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"math"
 	"minlang/ast"
 	"minlang/lexer"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 // Precedence levels for operators
 const (
 	_ int = iota
 	LOWEST
+	TERNARY     // c ? a : b
 	OR          // ||
 	AND         // &&
 	EQUALS      // ==, !=
-	LESSGREATER // <, >, <=, >=
+	LESSGREATER // <, >, <=, >=, x in xs
 	SUM         // +, -
 	PRODUCT     // *, /, %
+	CAST        // x as int
 	PREFIX      // -x, !x
 	CALL        // func(x), x[y], x.y
 )
 
 var precedences = map[lexer.TokenType]int{
+	lexer.QUESTION: TERNARY,
 	lexer.OR:       OR,
 	lexer.AND:      AND,
 	lexer.EQ:       EQUALS,
@@ -40,6 +47,26 @@ var precedences = map[lexer.TokenType]int{
 	lexer.DOT:      CALL,
 }
 
+// namedOperators are the infix operators spelled as names, with their
+// precedences. They are only operators after an expression on the same line,
+// so a statement starting with a variable named as or in still does.
+var namedOperators = map[string]int{
+	"as": CAST,
+	"in": LESSGREATER,
+}
+
+// softKeywords only start their construct when the next tokens fit it, such
+// as `map[string]int{` or `type Name =`. Anywhere else they are ordinary
+// names, so `map`, `type` or `default` can name a variable or field.
+var softKeywords = map[lexer.TokenType]bool{
+	lexer.MAP:     true,
+	lexer.TYPE:    true,
+	lexer.STRUCT:  true,
+	lexer.ENUM:    true,
+	lexer.CASE:    true,
+	lexer.DEFAULT: true,
+}
+
 type (
 	prefixParseFn func() ast.Expression
 	infixParseFn  func(ast.Expression) ast.Expression
@@ -47,21 +74,36 @@ type (
 
 // Parser represents the parser
 type Parser struct {
-	l      *lexer.Lexer
+	tokens *lexer.TokenStream
 	errors []string
 
 	curToken  lexer.Token
 	peekToken lexer.Token
 
+	// noStructLiteral is set while parsing the condition of an if or for,
+	// where `x {` opens the body rather than a struct literal
+	noStructLiteral bool
+
+	// structNames holds the structs declared so far, whose literals need no
+	// parentheses even in a condition
+	structNames map[string]bool
+
 	prefixParseFns map[lexer.TokenType]prefixParseFn
 	infixParseFns  map[lexer.TokenType]infixParseFn
 }
 
 // New creates a new parser
 func New(l *lexer.Lexer) *Parser {
+	return NewFromTokens(lexer.NewTokenStream(l))
+}
+
+// NewFromTokens creates a new parser reading from tokens, which may already
+// have been read ahead
+func NewFromTokens(tokens *lexer.TokenStream) *Parser {
 	p := &Parser{
-		l:      l,
-		errors: []string{},
+		tokens:      tokens,
+		errors:      []string{},
+		structNames: make(map[string]bool),
 	}
 
 	// Initialize prefix parse functions
@@ -70,6 +112,7 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(lexer.INT, p.parseIntegerLiteral)
 	p.registerPrefix(lexer.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(lexer.STRING, p.parseStringLiteral)
+	p.registerPrefix(lexer.CHAR, p.parseCharLiteral)
 	p.registerPrefix(lexer.TRUE, p.parseBooleanLiteral)
 	p.registerPrefix(lexer.FALSE, p.parseBooleanLiteral)
 	p.registerPrefix(lexer.NIL, p.parseNilLiteral)
@@ -77,7 +120,14 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(lexer.NOT, p.parsePrefixExpression)
 	p.registerPrefix(lexer.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(lexer.LBRACKET, p.parseArrayLiteral)
-	p.registerPrefix(lexer.MAP, p.parseMapLiteral)
+	p.registerPrefix(lexer.MAP, p.parseMapLiteralOrName)
+	p.registerPrefix(lexer.TYPE, p.parseIdentifier)
+	p.registerPrefix(lexer.STRUCT, p.parseIdentifier)
+	p.registerPrefix(lexer.ENUM, p.parseIdentifier)
+	p.registerPrefix(lexer.CASE, p.parseIdentifier)
+	p.registerPrefix(lexer.DEFAULT, p.parseIdentifier)
+	p.registerPrefix(lexer.IF, p.parseIfExpression)
+	p.registerPrefix(lexer.SWITCH, p.parseSwitchExpression)
 
 	// Initialize infix parse functions
 	p.infixParseFns = make(map[lexer.TokenType]infixParseFn)
@@ -94,12 +144,13 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(lexer.GE, p.parseInfixExpression)
 	p.registerInfix(lexer.AND, p.parseInfixExpression)
 	p.registerInfix(lexer.OR, p.parseInfixExpression)
+	p.registerInfix(lexer.QUESTION, p.parseConditionalExpression)
 	p.registerInfix(lexer.LPAREN, p.parseCallExpression)
 	p.registerInfix(lexer.LBRACKET, p.parseIndexExpression)
 	p.registerInfix(lexer.DOT, p.parseFieldAccessExpression)
+	p.registerInfix(lexer.IDENT, p.parseNamedOperator)
 
-	// Read two tokens to initialize curToken and peekToken
-	p.nextToken()
+	// Read the first token into curToken, with peekToken after it
 	p.nextToken()
 
 	return p
@@ -117,8 +168,14 @@ func (p *Parser) peekError(t lexer.TokenType) {
 }
 
 func (p *Parser) nextToken() {
-	p.curToken = p.peekToken
-	p.peekToken = p.l.NextToken()
+	p.curToken = p.tokens.Next()
+	p.peekToken = p.tokens.Peek(0)
+}
+
+// peekAhead returns the token n places after curToken; peekAhead(1) is
+// peekToken
+func (p *Parser) peekAhead(n int) lexer.Token {
+	return p.tokens.Peek(n - 1)
 }
 
 func (p *Parser) curTokenIs(t lexer.TokenType) bool {
@@ -138,13 +195,44 @@ func (p *Parser) expectPeek(t lexer.TokenType) bool {
 	return false
 }
 
+// isName reports whether tok can be used as a name: an identifier or a soft
+// keyword
+func isName(tok lexer.Token) bool {
+	return tok.Type == lexer.IDENT || softKeywords[tok.Type]
+}
+
+// expectPeekName is expectPeek(lexer.IDENT) that also accepts a soft keyword,
+// turning it into an identifier
+func (p *Parser) expectPeekName() bool {
+	if !isName(p.peekToken) {
+		p.peekError(lexer.IDENT)
+		return false
+	}
+	p.nextToken()
+	p.curToken.Type = lexer.IDENT
+	return true
+}
+
 func (p *Parser) peekPrecedence() int {
+	if precedence, ok := p.peekNamedOperator(); ok {
+		return precedence
+	}
 	if p, ok := precedences[p.peekToken.Type]; ok {
 		return p
 	}
 	return LOWEST
 }
 
+// peekNamedOperator returns the precedence of the next token if it is one
+// of the namedOperators applied to the expression before it
+func (p *Parser) peekNamedOperator() (int, bool) {
+	if !p.peekTokenIs(lexer.IDENT) || p.peekToken.Line != p.curToken.Line {
+		return 0, false
+	}
+	precedence, ok := namedOperators[p.peekToken.Literal]
+	return precedence, ok
+}
+
 func (p *Parser) curPrecedence() int {
 	if p, ok := precedences[p.curToken.Type]; ok {
 		return p
@@ -173,23 +261,35 @@ func (p *Parser) ParseProgram() *ast.Program {
 		p.nextToken()
 	}
 
+	if line, column, ok := p.tokens.OpenComment(); ok {
+		p.errors = append(p.errors, fmt.Sprintf("unterminated block comment starting at line %d, column %d", line, column))
+	}
 	return program
 }
 
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
-	case lexer.VAR:
-		return p.parseVarStatement(true)
-	case lexer.CONST:
-		return p.parseVarStatement(false)
+	case lexer.VAR, lexer.CONST:
+		isMutable := p.curTokenIs(lexer.VAR)
+		if p.peekAhead(2).Type == lexer.COMMA {
+			return p.parseLookupStatement(isMutable)
+		}
+		return p.parseVarStatement(isMutable)
 	case lexer.FUNC:
 		return p.parseFunctionStatement()
-	case lexer.TYPE:
-		return p.parseTypeStatement()
-	case lexer.STRUCT:
-		return p.parseStructStatement()
-	case lexer.ENUM:
-		return p.parseEnumStatement()
+	case lexer.TYPE, lexer.STRUCT, lexer.ENUM:
+		// A declaration names its type next; otherwise the keyword is a name
+		if !p.peekTokenIs(lexer.IDENT) {
+			return p.parseExpressionOrAssignmentStatement()
+		}
+		switch p.curToken.Type {
+		case lexer.TYPE:
+			return p.parseTypeStatement()
+		case lexer.STRUCT:
+			return p.parseStructStatement()
+		default:
+			return p.parseEnumStatement()
+		}
 	case lexer.RETURN:
 		return p.parseReturnStatement()
 	case lexer.BREAK:
@@ -205,6 +305,10 @@ func (p *Parser) parseStatement() ast.Statement {
 	case lexer.LBRACE:
 		return p.parseBlockStatement()
 	default:
+		// try { ... } catch e { ... }. "try" and "catch" are only keywords here.
+		if p.curToken.Literal == "try" && p.curTokenIs(lexer.IDENT) && p.peekTokenIs(lexer.LBRACE) {
+			return p.parseTryStatement()
+		}
 		// Try to parse as assignment or expression statement
 		return p.parseExpressionOrAssignmentStatement()
 	}
@@ -213,7 +317,7 @@ func (p *Parser) parseStatement() ast.Statement {
 func (p *Parser) parseVarStatement(isMutable bool) *ast.VarStatement {
 	stmt := &ast.VarStatement{Token: p.curToken, IsMutable: isMutable}
 
-	if !p.expectPeek(lexer.IDENT) {
+	if !p.expectPeekName() {
 		return nil
 	}
 
@@ -230,7 +334,7 @@ func (p *Parser) parseVarStatement(isMutable bool) *ast.VarStatement {
 	if p.peekTokenIs(lexer.ASSIGN) {
 		p.nextToken() // consume '='
 		p.nextToken() // move to value
-		stmt.Value = p.parseAssignmentValue()
+		stmt.Value = p.parseExpression(LOWEST)
 	}
 
 	if p.peekTokenIs(lexer.SEMICOLON) {
@@ -240,6 +344,38 @@ func (p *Parser) parseVarStatement(isMutable bool) *ast.VarStatement {
 	return stmt
 }
 
+// parseLookupStatement parses var v, ok = m[k]
+func (p *Parser) parseLookupStatement(isMutable bool) ast.Statement {
+	stmt := &ast.LookupStatement{Token: p.curToken, IsMutable: isMutable}
+
+	if !p.expectPeekName() {
+		return nil
+	}
+	stmt.Value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	p.nextToken() // move to ','
+	if !p.expectPeekName() {
+		return nil
+	}
+	stmt.Found = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(lexer.ASSIGN) {
+		return nil
+	}
+	p.nextToken() // move to the index expression
+	index, ok := p.parseExpression(LOWEST).(*ast.IndexExpression)
+	if !ok {
+		p.branchError(stmt.Token, fmt.Sprintf("%s with two names needs a map index, as in %s v, ok = m[k]",
+			stmt.Token.Literal, stmt.Token.Literal))
+		return nil
+	}
+	stmt.Index = index
+
+	if p.peekTokenIs(lexer.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
 func (p *Parser) parseTypeAnnotation() *ast.TypeAnnotation {
 	ta := &ast.TypeAnnotation{Token: p.curToken}
 
@@ -270,6 +406,31 @@ func (p *Parser) parseTypeAnnotation() *ast.TypeAnnotation {
 		return ta
 	}
 
+	// Check for tuple type: (int, string)
+	if p.curTokenIs(lexer.LPAREN) {
+		ta.IsTuple = true
+		for {
+			p.nextToken() // move to element type
+			element := p.parseTypeAnnotation()
+			if element == nil {
+				return nil
+			}
+			ta.Elements = append(ta.Elements, element)
+			if !p.peekTokenIs(lexer.COMMA) {
+				break
+			}
+			p.nextToken() // consume ','
+		}
+		if !p.expectPeek(lexer.RPAREN) {
+			return nil
+		}
+		if len(ta.Elements) < 2 {
+			p.branchError(ta.Token, "a tuple type needs two or more elements")
+			return nil
+		}
+		return ta
+	}
+
 	// Simple type (identifier)
 	if p.curTokenIs(lexer.IDENT) {
 		ta.Name = p.curToken.Literal
@@ -282,12 +443,21 @@ func (p *Parser) parseTypeAnnotation() *ast.TypeAnnotation {
 func (p *Parser) parseFunctionStatement() *ast.FunctionStatement {
 	stmt := &ast.FunctionStatement{Token: p.curToken}
 
-	if !p.expectPeek(lexer.IDENT) {
+	if !p.expectPeekName() {
 		return nil
 	}
 
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 
+	// Optional type parameters: func first<T>(arr: []T): T
+	if p.peekTokenIs(lexer.LT) {
+		p.nextToken() // consume '<'
+		stmt.TypeParams = p.parseTypeParameters()
+		if stmt.TypeParams == nil {
+			return nil
+		}
+	}
+
 	if !p.expectPeek(lexer.LPAREN) {
 		return nil
 	}
@@ -310,6 +480,33 @@ func (p *Parser) parseFunctionStatement() *ast.FunctionStatement {
 	return stmt
 }
 
+// parseTypeParameters parses the type parameters of a generic function, from
+// the '<' through the '>'. A generic function has at least one, and each is
+// named once.
+func (p *Parser) parseTypeParameters() []*ast.Identifier {
+	params := []*ast.Identifier{}
+	seen := make(map[string]bool)
+	for {
+		if !p.expectPeek(lexer.IDENT) {
+			return nil
+		}
+		if seen[p.curToken.Literal] {
+			p.branchError(p.curToken, fmt.Sprintf("type parameter %s is declared twice", p.curToken.Literal))
+			return nil
+		}
+		seen[p.curToken.Literal] = true
+		params = append(params, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+		if !p.peekTokenIs(lexer.COMMA) {
+			break
+		}
+		p.nextToken() // consume ','
+	}
+	if !p.expectPeek(lexer.GT) {
+		return nil
+	}
+	return params
+}
+
 func (p *Parser) parseFunctionParameters() []*ast.FunctionParameter {
 	params := []*ast.FunctionParameter{}
 
@@ -371,6 +568,7 @@ func (p *Parser) parseTypeStatement() *ast.TypeStatement {
 
 	switch p.curToken.Type {
 	case lexer.STRUCT:
+		p.structNames[stmt.Name.Value] = true
 		stmt.Definition = p.parseStructDefinition()
 	case lexer.ENUM:
 		stmt.Definition = p.parseEnumDefinition()
@@ -403,6 +601,7 @@ func (p *Parser) parseStructStatement() *ast.StructStatement {
 	}
 
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	p.structNames[stmt.Name.Value] = true
 
 	if !p.expectPeek(lexer.LBRACE) {
 		return nil
@@ -422,15 +621,10 @@ func (p *Parser) parseStructFields() []*ast.StructField {
 		return fields
 	}
 
-	field := &ast.StructField{}
-	field.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-
-	if !p.expectPeek(lexer.COLON) {
+	field := p.parseStructField()
+	if field == nil {
 		return nil
 	}
-
-	p.nextToken() // move to type
-	field.Type = p.parseTypeAnnotation()
 	fields = append(fields, field)
 
 	// Support both semicolon and comma as field separators
@@ -441,15 +635,10 @@ func (p *Parser) parseStructFields() []*ast.StructField {
 	for !p.peekTokenIs(lexer.RBRACE) {
 		p.nextToken() // move to next field
 
-		field := &ast.StructField{}
-		field.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-
-		if !p.expectPeek(lexer.COLON) {
+		field := p.parseStructField()
+		if field == nil {
 			return nil
 		}
-
-		p.nextToken() // move to type
-		field.Type = p.parseTypeAnnotation()
 		fields = append(fields, field)
 
 		// Support both semicolon and comma as field separators
@@ -465,6 +654,30 @@ func (p *Parser) parseStructFields() []*ast.StructField {
 	return fields
 }
 
+// parseStructField parses a field of a struct declaration, its name, type
+// and optionally = and the value literals leaving it out give it
+func (p *Parser) parseStructField() *ast.StructField {
+	field := &ast.StructField{}
+	field.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	if !p.expectPeek(lexer.COLON) {
+		return nil
+	}
+
+	p.nextToken() // move to type
+	field.Type = p.parseTypeAnnotation()
+
+	if p.peekTokenIs(lexer.ASSIGN) {
+		p.nextToken() // consume '='
+		p.nextToken() // move to the default
+		field.Default = p.parseExpression(LOWEST)
+		if field.Default == nil {
+			return nil
+		}
+	}
+	return field
+}
+
 func (p *Parser) parseEnumDefinition() *ast.EnumStatement {
 	stmt := &ast.EnumStatement{Token: p.curToken}
 
@@ -472,7 +685,7 @@ func (p *Parser) parseEnumDefinition() *ast.EnumStatement {
 		return nil
 	}
 
-	stmt.Variants = p.parseEnumVariants()
+	stmt.Variants, stmt.Values, stmt.Payloads = p.parseEnumVariants()
 
 	return stmt
 }
@@ -490,38 +703,78 @@ func (p *Parser) parseEnumStatement() *ast.EnumStatement {
 		return nil
 	}
 
-	stmt.Variants = p.parseEnumVariants()
+	stmt.Variants, stmt.Values, stmt.Payloads = p.parseEnumVariants()
 
 	return stmt
 }
 
-func (p *Parser) parseEnumVariants() []*ast.Identifier {
+// parseEnumVariants parses the variants of an enum, each a name optionally
+// followed by the types of its payload in parentheses, or by = and its value
+func (p *Parser) parseEnumVariants() ([]*ast.Identifier, []ast.Expression, [][]*ast.TypeAnnotation) {
 	variants := []*ast.Identifier{}
+	values := []ast.Expression{}
+	payloads := [][]*ast.TypeAnnotation{}
 
 	p.nextToken() // move to first variant or '}'
 
 	if p.curTokenIs(lexer.RBRACE) {
-		return variants
-	}
-
-	// First variant
-	variant := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	variants = append(variants, variant)
+		return variants, values, payloads
+	}
+
+	for {
+		variants = append(variants, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+		var payload []*ast.TypeAnnotation
+		if p.peekTokenIs(lexer.LPAREN) {
+			p.nextToken() // consume '('
+			payload = p.parsePayloadTypes()
+			if payload == nil {
+				return nil, nil, nil
+			}
+		}
+		payloads = append(payloads, payload)
+		var value ast.Expression
+		if p.peekTokenIs(lexer.ASSIGN) {
+			p.nextToken() // consume '='
+			p.nextToken() // move to the value
+			value = p.parseExpression(LOWEST)
+		}
+		values = append(values, value)
 
-	// Additional variants
-	for p.peekTokenIs(lexer.COMMA) {
+		if !p.peekTokenIs(lexer.COMMA) {
+			break
+		}
 		p.nextToken() // consume ','
 		p.nextToken() // move to next variant
-
-		variant := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		variants = append(variants, variant)
 	}
 
 	if !p.expectPeek(lexer.RBRACE) {
-		return nil
+		return nil, nil, nil
 	}
 
-	return variants
+	return variants, values, payloads
+}
+
+// parsePayloadTypes parses the types of a variant's payload, from the '('
+// through the ')'. A variant carries at least one value.
+func (p *Parser) parsePayloadTypes() []*ast.TypeAnnotation {
+	types := []*ast.TypeAnnotation{}
+	for {
+		p.nextToken() // move to the type
+		t := p.parseTypeAnnotation()
+		if t == nil {
+			p.errors = append(p.errors, fmt.Sprintf("expected a payload type, got %s instead", p.curToken.Literal))
+			return nil
+		}
+		types = append(types, t)
+		if !p.peekTokenIs(lexer.COMMA) {
+			break
+		}
+		p.nextToken() // consume ','
+	}
+	if !p.expectPeek(lexer.RPAREN) {
+		return nil
+	}
+	return types
 }
 
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
@@ -564,7 +817,7 @@ func (p *Parser) parseIfStatement() *ast.IfStatement {
 	stmt := &ast.IfStatement{Token: p.curToken}
 
 	p.nextToken() // move to condition
-	stmt.Condition = p.parseExpression(LOWEST)
+	stmt.Condition = p.parseCondition()
 
 	if !p.expectPeek(lexer.LBRACE) {
 		return nil
@@ -592,17 +845,10 @@ func (p *Parser) parseSwitchStatement() *ast.SwitchStatement {
 
 	p.nextToken() // move to switch value
 
-	// Parse switch value - can be identifier, integer, or enum value
-	// Don't use full parseExpression to avoid struct literal ambiguity
-	switch p.curToken.Type {
-	case lexer.IDENT:
-		stmt.Value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	case lexer.INT:
-		value, _ := strconv.ParseInt(p.curToken.Literal, 10, 64)
-		stmt.Value = &ast.IntegerLiteral{Token: p.curToken, Value: value}
-	default:
-		msg := fmt.Sprintf("expected identifier or integer in switch, got %s", p.curToken.Type.String())
-		p.errors = append(p.errors, msg)
+	// Parse switch value - any expression, parsed like a condition so that
+	// "switch x {" doesn't read as a struct literal
+	stmt.Value = p.parseCondition()
+	if stmt.Value == nil {
 		return nil
 	}
 
@@ -617,17 +863,8 @@ func (p *Parser) parseSwitchStatement() *ast.SwitchStatement {
 		caseClause := &ast.CaseClause{Token: p.curToken}
 
 		p.nextToken() // move to case value
-		// Parse case value - can be identifier or integer
-		// Don't use full parseExpression to avoid struct literal ambiguity
-		switch p.curToken.Type {
-		case lexer.IDENT:
-			caseClause.Value = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-		case lexer.INT:
-			value, _ := strconv.ParseInt(p.curToken.Literal, 10, 64)
-			caseClause.Value = &ast.IntegerLiteral{Token: p.curToken, Value: value}
-		default:
-			msg := fmt.Sprintf("expected identifier or integer in case, got %s", p.curToken.Type.String())
-			p.errors = append(p.errors, msg)
+		caseClause.Value = p.parseCaseValue()
+		if caseClause.Value == nil {
 			return nil
 		}
 
@@ -661,14 +898,318 @@ func (p *Parser) parseSwitchStatement() *ast.SwitchStatement {
 	return stmt
 }
 
-func (p *Parser) parseForStatement() *ast.ForStatement {
+// parseIfExpression parses an if used as a value. Unlike the statement it
+// needs an else, and each branch ends with the expression giving its value.
+func (p *Parser) parseIfExpression() ast.Expression {
+	stmt := p.parseIfStatement()
+	if stmt == nil {
+		return nil
+	}
+	expr := p.toIfExpression(stmt)
+	if expr == nil {
+		return nil
+	}
+	return expr
+}
+
+// parseCaseValue parses the value of a case: a struct or array pattern, or
+// any expression, such as an enum variant, an integer or a string
+func (p *Parser) parseCaseValue() ast.Expression {
+	switch {
+	case p.curTokenIs(lexer.LBRACKET):
+		return p.parseArrayPattern()
+	case isName(p.curToken) && p.peekTokenIs(lexer.LBRACE) && p.startsStructPattern():
+		return p.parseStructPattern()
+	}
+	return p.parseCondition()
+}
+
+// startsStructPattern reports whether the `{` after the current identifier
+// in a case or condition opens a struct pattern or literal rather than the
+// body: `Name{} {` or `Name{field: ...`
+func (p *Parser) startsStructPattern() bool {
+	if p.peekAhead(2).Type == lexer.RBRACE {
+		return p.peekAhead(3).Type == lexer.LBRACE
+	}
+	return p.startsStructLiteral()
+}
+
+// parsePattern parses a pattern inside a struct or array pattern: a name, _,
+// a literal, or another struct or array pattern
+func (p *Parser) parsePattern() ast.Expression {
+	switch {
+	case p.curTokenIs(lexer.LBRACKET):
+		return p.parseArrayPattern()
+	case isName(p.curToken) && p.peekTokenIs(lexer.LBRACE) && p.startsStructLiteral():
+		return p.parseStructPattern()
+	}
+
+	tok := p.curToken
+	pattern := p.parseExpression(LOWEST)
+	switch e := pattern.(type) {
+	case nil:
+		return nil
+	case *ast.Identifier, *ast.IntegerLiteral, *ast.FloatLiteral, *ast.StringLiteral, *ast.BooleanLiteral, *ast.NilLiteral:
+		return pattern
+	case *ast.PrefixExpression:
+		switch e.Right.(type) {
+		case *ast.IntegerLiteral, *ast.FloatLiteral:
+			if e.Operator == "-" {
+				return pattern
+			}
+		}
+	}
+	p.branchError(tok, fmt.Sprintf("expected a name, _, a literal or a pattern, got %s", pattern.String()))
+	return nil
+}
+
+// parseStructPattern parses a struct pattern, from the struct's name
+// through the '}'
+func (p *Parser) parseStructPattern() ast.Expression {
+	pattern := &ast.StructPattern{
+		Token: p.curToken,
+		Name:  &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal},
+	}
+	p.nextToken() // move to '{'
+
+	for !p.peekTokenIs(lexer.RBRACE) {
+		if !p.expectPeekName() {
+			return nil
+		}
+		field := &ast.FieldPattern{Name: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}}
+		if !p.expectPeek(lexer.COLON) {
+			return nil
+		}
+		p.nextToken() // move to the field's pattern
+		field.Pattern = p.parsePattern()
+		if field.Pattern == nil {
+			return nil
+		}
+		pattern.Fields = append(pattern.Fields, field)
+
+		if !p.peekTokenIs(lexer.COMMA) {
+			break
+		}
+		p.nextToken() // consume ','
+	}
+
+	if !p.expectPeek(lexer.RBRACE) {
+		return nil
+	}
+	return pattern
+}
+
+// parseArrayPattern parses an array pattern, from the '[' through the ']'
+func (p *Parser) parseArrayPattern() ast.Expression {
+	pattern := &ast.ArrayPattern{Token: p.curToken}
+
+	for !p.peekTokenIs(lexer.RBRACKET) {
+		p.nextToken() // move to the element's pattern
+		element := p.parsePattern()
+		if element == nil {
+			return nil
+		}
+		pattern.Elements = append(pattern.Elements, element)
+
+		if !p.peekTokenIs(lexer.COMMA) {
+			break
+		}
+		p.nextToken() // consume ','
+	}
+
+	if !p.expectPeek(lexer.RBRACKET) {
+		return nil
+	}
+	return pattern
+}
+
+// parseSwitchExpression parses a switch used as a value, where each case ends
+// with the expression giving its value
+func (p *Parser) parseSwitchExpression() ast.Expression {
+	stmt := p.parseSwitchStatement()
+	if stmt == nil {
+		return nil
+	}
+	expr := p.toSwitchExpression(stmt)
+	if expr == nil {
+		return nil
+	}
+	return expr
+}
+
+func (p *Parser) toIfExpression(stmt *ast.IfStatement) *ast.IfExpression {
+	if stmt.Alternative == nil {
+		p.branchError(stmt.Token, "if expression needs an else branch")
+		return nil
+	}
+	if !p.checkValueBlock(stmt.Consequence) {
+		return nil
+	}
+
+	switch alt := stmt.Alternative.(type) {
+	case *ast.IfStatement:
+		nested := p.toIfExpression(alt)
+		if nested == nil {
+			return nil
+		}
+		stmt.Alternative = &ast.BlockStatement{
+			Token:      alt.Token,
+			Statements: []ast.Statement{&ast.ExpressionStatement{Token: alt.Token, Expression: nested}},
+		}
+	case *ast.BlockStatement:
+		if !p.checkValueBlock(alt) {
+			return nil
+		}
+	}
+
+	return &ast.IfExpression{IfStatement: stmt}
+}
+
+// parseConditionalExpression parses c ? a : b, shorthand for the if
+// expression if c { a } else { b }. It groups to the right, so a ? b : c ? d : e
+// is a ? b : (c ? d : e).
+func (p *Parser) parseConditionalExpression(condition ast.Expression) ast.Expression {
+	tok := p.curToken
+	p.nextToken()
+	consequence := p.parseExpression(LOWEST)
+	if consequence == nil || !p.expectPeek(lexer.COLON) {
+		return nil
+	}
+	colon := p.curToken
+	p.nextToken()
+	alternative := p.parseExpression(LOWEST)
+	if alternative == nil {
+		return nil
+	}
+	return &ast.IfExpression{IfStatement: &ast.IfStatement{
+		Token:       tok,
+		Condition:   condition,
+		Consequence: valueBlock(tok, consequence),
+		Alternative: valueBlock(colon, alternative),
+	}}
+}
+
+// valueBlock returns the block { value } of an if expression branch
+func valueBlock(tok lexer.Token, value ast.Expression) *ast.BlockStatement {
+	return &ast.BlockStatement{
+		Token:      tok,
+		Statements: []ast.Statement{&ast.ExpressionStatement{Token: tok, Expression: value}},
+	}
+}
+
+func (p *Parser) toSwitchExpression(stmt *ast.SwitchStatement) *ast.SwitchExpression {
+	for _, c := range stmt.Cases {
+		if !p.checkValueBlock(c.Body) {
+			return nil
+		}
+	}
+	if stmt.Default != nil && !p.checkValueBlock(stmt.Default) {
+		return nil
+	}
+	return &ast.SwitchExpression{SwitchStatement: stmt}
+}
+
+// checkValueBlock checks that a branch of an if or switch expression ends
+// with its value, turning a final if or switch into an expression, and that
+// nothing in it jumps out of the expression.
+func (p *Parser) checkValueBlock(block *ast.BlockStatement) bool {
+	if len(block.Statements) == 0 {
+		p.branchError(block.Token, "branch of an if or switch expression needs a value")
+		return false
+	}
+
+	last := len(block.Statements) - 1
+	switch stmt := block.Statements[last].(type) {
+	case *ast.ExpressionStatement:
+	case *ast.IfStatement:
+		expr := p.toIfExpression(stmt)
+		if expr == nil {
+			return false
+		}
+		block.Statements[last] = &ast.ExpressionStatement{Token: stmt.Token, Expression: expr}
+	case *ast.SwitchStatement:
+		expr := p.toSwitchExpression(stmt)
+		if expr == nil {
+			return false
+		}
+		block.Statements[last] = &ast.ExpressionStatement{Token: stmt.Token, Expression: expr}
+	default:
+		p.branchError(block.Token, "branch of an if or switch expression must end with a value")
+		return false
+	}
+
+	for _, stmt := range block.Statements[:last] {
+		if jump := escapingJump(stmt, false); jump != nil {
+			tok, _ := ast.NodeToken(jump)
+			p.branchError(tok, fmt.Sprintf("%s can't leave an if or switch expression", jump.TokenLiteral()))
+			return false
+		}
+	}
+	return true
+}
+
+// escapingJump returns a return, or a break or continue outside any loop
+// within stmt, if there is one. Nested functions are skipped.
+func escapingJump(stmt ast.Statement, inLoop bool) ast.Statement {
+	switch s := stmt.(type) {
+	case *ast.ReturnStatement:
+		return s
+	case *ast.BreakStatement, *ast.ContinueStatement:
+		if !inLoop {
+			return s
+		}
+	case *ast.BlockStatement:
+		for _, inner := range s.Statements {
+			if jump := escapingJump(inner, inLoop); jump != nil {
+				return jump
+			}
+		}
+	case *ast.IfStatement:
+		if jump := escapingJump(s.Consequence, inLoop); jump != nil {
+			return jump
+		}
+		if s.Alternative != nil {
+			return escapingJump(s.Alternative, inLoop)
+		}
+	case *ast.SwitchStatement:
+		for _, c := range s.Cases {
+			if jump := escapingJump(c.Body, inLoop); jump != nil {
+				return jump
+			}
+		}
+		if s.Default != nil {
+			return escapingJump(s.Default, inLoop)
+		}
+	case *ast.ForStatement:
+		return escapingJump(s.Body, true)
+	case *ast.ForInStatement:
+		return escapingJump(s.Body, true)
+	case *ast.TryStatement:
+		if jump := escapingJump(s.Body, inLoop); jump != nil {
+			return jump
+		}
+		return escapingJump(s.Catch, inLoop)
+	}
+	return nil
+}
+
+func (p *Parser) branchError(tok lexer.Token, msg string) {
+	p.errors = append(p.errors, fmt.Sprintf("%s at line %d, column %d", msg, tok.Line, tok.Column))
+}
+
+func (p *Parser) parseForStatement() ast.Statement {
 	stmt := &ast.ForStatement{Token: p.curToken}
 
 	p.nextToken() // move past 'for'
 
+	// Loop over elements: for x in xs { ... }. This "in" is the loop's, not the operator.
+	if p.curTokenIs(lexer.IDENT) && p.peekTokenIs(lexer.IDENT) && p.peekToken.Literal == "in" {
+		return p.parseForInStatement(stmt.Token)
+	}
+
 	// Simple for loop: for condition { ... }
 	if !p.curTokenIs(lexer.VAR) && !p.curTokenIs(lexer.CONST) {
-		stmt.Condition = p.parseExpression(LOWEST)
+		stmt.Condition = p.parseCondition()
 		if !p.expectPeek(lexer.LBRACE) {
 			return nil
 		}
@@ -682,11 +1223,11 @@ func (p *Parser) parseForStatement() *ast.ForStatement {
 	// The var statement should have consumed the semicolon
 	// Now parse the condition
 	p.nextToken() // move to condition
-	stmt.Condition = p.parseExpression(LOWEST)
+	stmt.Condition = p.parseCondition()
 
 	if p.expectPeek(lexer.SEMICOLON) {
 		p.nextToken() // move to post statement
-		stmt.Post = p.parseExpressionOrAssignmentStatement()
+		stmt.Post = p.parseForPost()
 	}
 
 	if !p.expectPeek(lexer.LBRACE) {
@@ -698,6 +1239,72 @@ func (p *Parser) parseForStatement() *ast.ForStatement {
 	return stmt
 }
 
+// parseForPost parses the post statement of a C-style for loop, which must
+// be an assignment, ++, -- or call, with no ; before the body
+func (p *Parser) parseForPost() ast.Statement {
+	tok := p.curToken
+	p.noStructLiteral = true
+	post := p.parseExpressionOrAssignmentStatement()
+	p.noStructLiteral = false
+
+	switch post := post.(type) {
+	case nil:
+		return nil
+	case *ast.ExpressionStatement:
+		if _, ok := post.Expression.(*ast.CallExpression); !ok && post.Expression != nil {
+			p.branchError(tok, fmt.Sprintf("for loop post must be an assignment, ++, -- or call, got %s", post.Expression))
+			return nil
+		}
+	}
+	if p.curTokenIs(lexer.SEMICOLON) {
+		p.branchError(p.curToken, "unexpected ; after for loop post")
+	}
+	return post
+}
+
+func (p *Parser) parseForInStatement(forToken lexer.Token) ast.Statement {
+	stmt := &ast.ForInStatement{Token: forToken}
+	stmt.Variable = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+
+	p.nextToken() // move to 'in'
+	p.nextToken() // move to the iterable
+	stmt.Iterable = p.parseCondition()
+
+	if !p.expectPeek(lexer.LBRACE) {
+		return nil
+	}
+	stmt.Body = p.parseBlockStatement()
+	return stmt
+}
+
+func (p *Parser) parseTryStatement() ast.Statement {
+	stmt := &ast.TryStatement{Token: p.curToken}
+
+	p.nextToken() // move to '{'
+	stmt.Body = p.parseBlockStatement()
+
+	if !p.peekTokenIs(lexer.IDENT) || p.peekToken.Literal != "catch" {
+		p.errors = append(p.errors, fmt.Sprintf("expected catch after try block, got %s instead at line %d, column %d",
+			p.peekToken.Type, p.peekToken.Line, p.peekToken.Column))
+		return nil
+	}
+	p.nextToken() // move to 'catch'
+
+	// The error is named unless the catch block follows directly
+	if !p.peekTokenIs(lexer.LBRACE) {
+		if !p.expectPeekName() {
+			return nil
+		}
+		stmt.Variable = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	}
+
+	if !p.expectPeek(lexer.LBRACE) {
+		return nil
+	}
+	stmt.Catch = p.parseBlockStatement()
+	return stmt
+}
+
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken}
 	block.Statements = []ast.Statement{}
@@ -721,13 +1328,18 @@ func (p *Parser) parseExpressionOrAssignmentStatement() ast.Statement {
 
 	// Check if this is an assignment
 	if p.peekTokenIs(lexer.ASSIGN) {
-		p.nextToken() // consume '='
-		stmt := &ast.AssignmentStatement{
-			Token: p.curToken,
-			Left:  expr,
+		stmt := p.parseAssignment(expr)
+
+		if p.peekTokenIs(lexer.SEMICOLON) {
+			p.nextToken()
 		}
-		p.nextToken() // move to value
-		stmt.Value = p.parseAssignmentValue()
+
+		return stmt
+	}
+
+	if p.peekTokenIs(lexer.INC) || p.peekTokenIs(lexer.DEC) {
+		p.nextToken()
+		stmt := p.incrementStatement(expr)
 
 		if p.peekTokenIs(lexer.SEMICOLON) {
 			p.nextToken()
@@ -749,13 +1361,169 @@ func (p *Parser) parseExpressionOrAssignmentStatement() ast.Statement {
 	return stmt
 }
 
-// parseAssignmentValue parses a value in assignment context, allowing struct literals
-func (p *Parser) parseAssignmentValue() ast.Expression {
-	// Check if this is a struct literal: Identifier {
-	if p.curTokenIs(lexer.IDENT) && p.peekTokenIs(lexer.LBRACE) {
-		return p.parseStructLiteral()
+// parseAssignment parses the rest of the assignment left = value, or of a
+// chain a = b = value, which stands for b = value; a = b: each target gets
+// the value of the one after it, so targets are evaluated again and must be
+// places
+func (p *Parser) parseAssignment(left ast.Expression) ast.Statement {
+	targets := []ast.Expression{left}
+	var assigns []lexer.Token
+	var value ast.Expression
+	for {
+		p.nextToken() // consume '='
+		assigns = append(assigns, p.curToken)
+		p.nextToken() // move to value
+		value = p.parseExpression(LOWEST)
+		if !p.peekTokenIs(lexer.ASSIGN) {
+			break
+		}
+		targets = append(targets, value)
+	}
+
+	for i, target := range targets {
+		if !p.checkAssignmentTarget(assigns[i], target, targets) {
+			return nil
+		}
+	}
+	if len(targets) == 1 {
+		return &ast.AssignmentStatement{Token: assigns[0], Left: left, Value: value}
+	}
+
+	chain := &ast.BlockStatement{Token: assigns[0]}
+	for i := len(targets) - 1; i >= 0; i-- {
+		chain.Statements = append(chain.Statements, &ast.AssignmentStatement{Token: assigns[i], Left: targets[i], Value: value})
+		value = targets[i]
+	}
+	return chain
+}
+
+// checkAssignmentTarget reports whether target, assigned by the = assign, can
+// be: a variable, element, field or tuple element, the last for the compiler
+// to reject, and in a chain of targets a place no other target of the chain
+// moves
+func (p *Parser) checkAssignmentTarget(assign lexer.Token, target ast.Expression, targets []ast.Expression) bool {
+	switch target.(type) {
+	case nil:
+		return false // Already reported
+	case *ast.Identifier, *ast.IndexExpression, *ast.FieldAccessExpression, *ast.TupleElementExpression:
+	default:
+		p.branchError(assign, fmt.Sprintf("cannot assign to %s: it must be a variable, element or field", target))
+		return false
+	}
+	if len(targets) == 1 {
+		return true
 	}
-	// Otherwise parse as normal expression
+	if !isPlace(target) {
+		p.branchError(assign, fmt.Sprintf("cannot chain assignment to %s: it must be a variable, or an element or field found without calls", target))
+		return false
+	}
+	for _, other := range targets {
+		if ident, ok := other.(*ast.Identifier); ok && other != target && usesName(target, ident.Value) {
+			p.branchError(assign, fmt.Sprintf("cannot chain assignments to %s and %s: assigning %s moves %s", ident, target, ident, target))
+			return false
+		}
+	}
+	return true
+}
+
+// incrementStatement returns the statement x++ or x--, for the current ++ or
+// --, stands for: x = x + 1 or x = x - 1. x is evaluated twice, so it must be
+// a variable, or an element or field found without calls.
+func (p *Parser) incrementStatement(x ast.Expression) ast.Statement {
+	tok := p.curToken
+	if !isPlace(x) {
+		p.branchError(tok, fmt.Sprintf("cannot use %s on %s: it must be a variable, or an element or field found without calls", tok.Literal, x))
+		return nil
+	}
+	op := lexer.Token{Type: lexer.PLUS, Literal: "+", Line: tok.Line, Column: tok.Column}
+	if tok.Type == lexer.DEC {
+		op.Type, op.Literal = lexer.MINUS, "-"
+	}
+	one := &ast.IntegerLiteral{Token: lexer.Token{Type: lexer.INT, Literal: "1", Line: tok.Line, Column: tok.Column}, Value: 1}
+	return &ast.AssignmentStatement{
+		Token: tok,
+		Left:  x,
+		Value: &ast.InfixExpression{Token: op, Operator: op.Literal, Left: x, Right: one},
+	}
+}
+
+// isPlace reports whether x is a variable, element or field that names,
+// literals and operators are all it takes to find, so evaluating it again
+// finds it again
+func isPlace(x ast.Expression) bool {
+	switch x := x.(type) {
+	case *ast.Identifier:
+		return true
+	case *ast.IndexExpression:
+		return isPure(x.Left) && isPure(x.Index)
+	case *ast.FieldAccessExpression:
+		return isPure(x.Left)
+	}
+	return false
+}
+
+// isPure reports whether x evaluates only names, literals and operators
+func isPure(x ast.Expression) bool {
+	switch x := x.(type) {
+	case *ast.Identifier, *ast.IntegerLiteral, *ast.FloatLiteral, *ast.StringLiteral, *ast.BooleanLiteral:
+		return true
+	case *ast.IndexExpression:
+		return isPure(x.Left) && isPure(x.Index)
+	case *ast.FieldAccessExpression:
+		return isPure(x.Left)
+	case *ast.PrefixExpression:
+		return isPure(x.Right)
+	case *ast.InfixExpression:
+		return isPure(x.Left) && isPure(x.Right)
+	}
+	return false
+}
+
+// usesName reports whether the place x uses the variable name to find it,
+// beyond being that variable itself
+func usesName(x ast.Expression, name string) bool {
+	switch x := x.(type) {
+	case *ast.IndexExpression:
+		return mentions(x.Left, name) || mentions(x.Index, name)
+	case *ast.FieldAccessExpression:
+		return mentions(x.Left, name)
+	}
+	return false
+}
+
+// mentions reports whether the pure expression x reads the variable name
+func mentions(x ast.Expression, name string) bool {
+	switch x := x.(type) {
+	case *ast.Identifier:
+		return x.Value == name
+	case *ast.IndexExpression:
+		return mentions(x.Left, name) || mentions(x.Index, name)
+	case *ast.FieldAccessExpression:
+		return mentions(x.Left, name)
+	case *ast.PrefixExpression:
+		return mentions(x.Right, name)
+	case *ast.InfixExpression:
+		return mentions(x.Left, name) || mentions(x.Right, name)
+	}
+	return false
+}
+
+// parseCondition parses the condition of an if or for, where a struct
+// literal needs parentheses, `if p == (Point{x: 1}) {`, unless the struct
+// is declared above it
+func (p *Parser) parseCondition() ast.Expression {
+	saved := p.noStructLiteral
+	p.noStructLiteral = true
+	defer func() { p.noStructLiteral = saved }()
+	return p.parseExpression(LOWEST)
+}
+
+// parseNested parses an expression inside brackets, where struct literals are
+// allowed even within a condition
+func (p *Parser) parseNested() ast.Expression {
+	saved := p.noStructLiteral
+	p.noStructLiteral = false
+	defer func() { p.noStructLiteral = saved }()
 	return p.parseExpression(LOWEST)
 }
 
@@ -781,6 +1549,29 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	return leftExp
 }
 
+// parseCastExpression parses the type after `as`
+// parseNamedOperator parses the operator of namedOperators at curToken
+func (p *Parser) parseNamedOperator(left ast.Expression) ast.Expression {
+	if p.curToken.Literal == "as" {
+		return p.parseCastExpression(left)
+	}
+	expression := &ast.InfixExpression{Token: p.curToken, Operator: p.curToken.Literal, Left: left}
+	p.nextToken()
+	expression.Right = p.parseExpression(namedOperators[expression.Operator])
+	return expression
+}
+
+func (p *Parser) parseCastExpression(value ast.Expression) ast.Expression {
+	cast := &ast.CastExpression{Token: p.curToken, Value: value}
+	p.nextToken() // move past 'as'
+	cast.Type = p.parseTypeAnnotation()
+	if cast.Type == nil {
+		p.branchError(p.curToken, fmt.Sprintf("expected a type after as, got %s", p.curToken.Literal))
+		return nil
+	}
+	return cast
+}
+
 func (p *Parser) noPrefixParseFnError(t lexer.TokenType) {
 	msg := fmt.Sprintf("no prefix parse function for %s found at line %d, column %d",
 		t, p.curToken.Line, p.curToken.Column)
@@ -790,18 +1581,27 @@ func (p *Parser) noPrefixParseFnError(t lexer.TokenType) {
 // Expression parsing functions
 
 func (p *Parser) parseIdentifier() ast.Expression {
-	// Don't try to parse struct literals here to avoid ambiguity
-	// Struct literals will be handled in specific contexts (assignments)
+	// new Point(1, 2). "new" is only a keyword here.
+	if p.curToken.Literal == "new" && p.curTokenIs(lexer.IDENT) && p.peekTokenIs(lexer.IDENT) &&
+		p.peekAhead(2).Type == lexer.LPAREN {
+		return p.parseNewExpression()
+	}
+	p.curToken.Type = lexer.IDENT // May be a soft keyword used as a name
+	if p.peekTokenIs(lexer.LBRACE) {
+		if p.noStructLiteral && p.structNames[p.curToken.Literal] && p.startsStructPattern() ||
+			!p.noStructLiteral && p.startsStructLiteral() {
+			return p.parseStructLiteral()
+		}
+	}
 	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 }
 
 func (p *Parser) parseIntegerLiteral() ast.Expression {
 	lit := &ast.IntegerLiteral{Token: p.curToken}
 
-	value, err := strconv.ParseInt(p.curToken.Literal, 10, 64)
+	value, err := parseIntLiteral(p.curToken.Literal, false)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+		p.literalError(err, "integer literal %s overflows int", p.curToken.Literal)
 		return nil
 	}
 
@@ -812,9 +1612,21 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 func (p *Parser) parseFloatLiteral() ast.Expression {
 	lit := &ast.FloatLiteral{Token: p.curToken}
 
-	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	digits, _, err := numberDigits(p.curToken.Literal)
+	var value float64
+	if err == nil {
+		value, err = strconv.ParseFloat(digits, 64)
+	}
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as float", p.curToken.Literal)
+		p.literalError(err, "float literal %s overflows float", p.curToken.Literal)
+		return nil
+	}
+
+	// ParseFloat rounds values too small for a float64 to zero without an
+	// error; only a literal of zeros may be zero
+	if value == 0 && strings.ContainsAny(mantissa(digits), "123456789") {
+		msg := fmt.Sprintf("float literal %s underflows to 0 at line %d, column %d",
+			p.curToken.Literal, p.curToken.Line, p.curToken.Column)
 		p.errors = append(p.errors, msg)
 		return nil
 	}
@@ -823,8 +1635,180 @@ func (p *Parser) parseFloatLiteral() ast.Expression {
 	return lit
 }
 
+// literalError reports a numeric literal that didn't parse: rangeFormat if it
+// is out of range, otherwise that it is malformed
+func (p *Parser) literalError(err error, rangeFormat string, literal string) {
+	msg := fmt.Sprintf("could not parse %q as a number", literal)
+	var numErr *strconv.NumError
+	switch {
+	case errors.Is(err, strconv.ErrRange):
+		msg = fmt.Sprintf(rangeFormat, literal)
+	case !errors.As(err, &numErr):
+		msg = err.Error() // Malformed, as numberDigits describes it
+	}
+	p.errors = append(p.errors, fmt.Sprintf("%s at line %d, column %d", msg, p.curToken.Line, p.curToken.Column))
+}
+
+// parseIntLiteral returns the value of an integer literal, negated if neg
+// is set
+func parseIntLiteral(literal string, neg bool) (int64, error) {
+	digits, base, err := numberDigits(literal)
+	if err != nil {
+		return 0, err
+	}
+	if neg {
+		digits = "-" + digits
+	}
+	return strconv.ParseInt(digits, base, 64)
+}
+
+// numberDigits returns the digits of a number literal, without its base
+// prefix and underscores, and their base. It reports a prefix with no
+// digits, a digit outside the base and an underscore that doesn't separate
+// two digits.
+func numberDigits(literal string) (string, int, error) {
+	base, name, digits := 10, "decimal", literal
+	if len(literal) >= 2 && literal[0] == '0' {
+		switch literal[1] {
+		case 'x', 'X':
+			base, name = 16, "hexadecimal"
+		case 'b', 'B':
+			base, name = 2, "binary"
+		case 'o', 'O':
+			base, name = 8, "octal"
+		}
+		if base != 10 {
+			digits = literal[2:]
+			if digits == "" {
+				return "", 0, fmt.Errorf("%s literal %s has no digits", name, literal)
+			}
+		}
+	}
+
+	if i := strings.IndexAny(digits, "eE"); base == 10 && i >= 0 && strings.TrimLeft(digits[i+1:], "+-") == "" {
+		return "", 0, fmt.Errorf("malformed exponent in float literal %s", literal)
+	}
+
+	for i := 0; i < len(digits); i++ {
+		ch := digits[i]
+		if ch == '_' {
+			if i == 0 || i == len(digits)-1 || !isDigitIn(digits[i-1], base) || !isDigitIn(digits[i+1], base) {
+				return "", 0, fmt.Errorf("'_' must separate successive digits in %s", literal)
+			}
+			continue
+		}
+		// Decimal literals also hold a point and exponent, which the lexer
+		// has already checked
+		if base != 10 && !isDigitIn(ch, base) {
+			return "", 0, fmt.Errorf("invalid digit %q in %s literal %s", ch, name, literal)
+		}
+	}
+	return strings.ReplaceAll(digits, "_", ""), base, nil
+}
+
+// isDigitIn reports whether ch is a digit in base, which is at most 16
+func isDigitIn(ch byte, base int) bool {
+	var value int
+	switch {
+	case '0' <= ch && ch <= '9':
+		value = int(ch - '0')
+	case 'a' <= ch && ch <= 'f':
+		value = int(ch-'a') + 10
+	case 'A' <= ch && ch <= 'F':
+		value = int(ch-'A') + 10
+	default:
+		return false
+	}
+	return value < base
+}
+
+// mantissa returns a float literal without its exponent
+func mantissa(literal string) string {
+	if i := strings.IndexAny(literal, "eE"); i >= 0 {
+		return literal[:i]
+	}
+	return literal
+}
+
 func (p *Parser) parseStringLiteral() ast.Expression {
-	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+	value, err := stringValue(p.curToken.Literal)
+	if err != nil {
+		msg := fmt.Sprintf("%s at line %d, column %d", err, p.curToken.Line, p.curToken.Column)
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+	return &ast.StringLiteral{Token: p.curToken, Value: value}
+}
+
+// stringValue returns the string body, a string literal's contents, stands
+// for: body with each of charEscapes, or \", replaced by its character
+func stringValue(body string) (string, error) {
+	if !strings.Contains(body, `\`) {
+		return body, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(body); i++ {
+		if body[i] != '\\' {
+			b.WriteByte(body[i])
+			continue
+		}
+		if i+1 == len(body) {
+			return "", fmt.Errorf("unterminated string literal \"%s", body)
+		}
+		escape, _ := utf8.DecodeRuneInString(body[i+1:])
+		if escape == '"' {
+			b.WriteByte('"')
+		} else if r, ok := charEscapes[`\`+string(escape)]; ok {
+			b.WriteRune(r)
+		} else {
+			return "", fmt.Errorf("unknown escape in string literal \"%s\"", body)
+		}
+		i += utf8.RuneLen(escape)
+	}
+	return b.String(), nil
+}
+
+// parseCharLiteral parses a character literal such as 'a', which is the int
+// code point of its character, as in Go
+func (p *Parser) parseCharLiteral() ast.Expression {
+	value, err := charValue(p.curToken.Literal)
+	if err != nil {
+		msg := fmt.Sprintf("%s at line %d, column %d", err, p.curToken.Line, p.curToken.Column)
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+	return &ast.IntegerLiteral{Token: p.curToken, Value: value}
+}
+
+// charEscapes are the characters a backslash escapes in character and string
+// literals
+var charEscapes = map[string]rune{`\n`: '\n', `\t`: '\t', `\r`: '\r', `\0`: 0, `\\`: '\\', `\'`: '\''}
+
+// charValue returns the code point of the character literal literal, quotes
+// included: one UTF-8 character or one of charEscapes
+func charValue(literal string) (int64, error) {
+	body := strings.TrimPrefix(literal, "'")
+	body, closed := strings.CutSuffix(body, "'")
+	if !closed || body == `\` {
+		return 0, fmt.Errorf("unterminated character literal %s", literal)
+	}
+	if strings.HasPrefix(body, `\`) {
+		r, ok := charEscapes[body]
+		if !ok {
+			return 0, fmt.Errorf("unknown escape in character literal %s", literal)
+		}
+		return int64(r), nil
+	}
+	r, size := utf8.DecodeRuneInString(body)
+	switch {
+	case body == "":
+		return 0, fmt.Errorf("empty character literal %s", literal)
+	case r == utf8.RuneError && size == 1:
+		return 0, fmt.Errorf("character literal %s is not valid UTF-8", literal)
+	case size != len(body):
+		return 0, fmt.Errorf("character literal %s holds more than one character", literal)
+	}
+	return int64(r), nil
 }
 
 func (p *Parser) parseBooleanLiteral() ast.Expression {
@@ -843,6 +1827,16 @@ func (p *Parser) parsePrefixExpression() ast.Expression {
 
 	p.nextToken()
 
+	// -9223372036854775808 is in range even though its digits alone are not,
+	// so the minus sign becomes part of the literal
+	if expression.Operator == "-" && p.curTokenIs(lexer.INT) {
+		if value, err := parseIntLiteral(p.curToken.Literal, true); err == nil && value == math.MinInt64 {
+			tok := expression.Token
+			tok.Type, tok.Literal = lexer.INT, "-"+p.curToken.Literal
+			return &ast.IntegerLiteral{Token: tok, Value: value}
+		}
+	}
+
 	expression.Right = p.parseExpression(PREFIX)
 
 	return expression
@@ -863,9 +1857,13 @@ func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
 }
 
 func (p *Parser) parseGroupedExpression() ast.Expression {
+	tok := p.curToken
 	p.nextToken()
 
-	exp := p.parseExpression(LOWEST)
+	exp := p.parseNested()
+	if p.peekTokenIs(lexer.COMMA) {
+		return p.parseTupleLiteral(tok, exp)
+	}
 
 	if !p.expectPeek(lexer.RPAREN) {
 		return nil
@@ -874,17 +1872,53 @@ func (p *Parser) parseGroupedExpression() ast.Expression {
 	return exp
 }
 
+// parseTupleLiteral parses the rest of the tuple literal opened by tok, whose
+// first element is first
+func (p *Parser) parseTupleLiteral(tok lexer.Token, first ast.Expression) ast.Expression {
+	tuple := &ast.TupleLiteral{Token: tok, Elements: []ast.Expression{first}}
+	for p.peekTokenIs(lexer.COMMA) {
+		p.nextToken() // consume ','
+		p.nextToken() // move to the next element
+		tuple.Elements = append(tuple.Elements, p.parseNested())
+	}
+
+	if !p.expectPeek(lexer.RPAREN) {
+		return nil
+	}
+
+	return tuple
+}
+
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
 	exp.Arguments = p.parseExpressionList(lexer.RPAREN)
 	return exp
 }
 
+// parseIndexExpression parses left[index], or a slice of left with either
+// bound left out: left[low:high], left[low:], left[:high] or left[:]
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
-	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
+	token := p.curToken
+
+	var index ast.Expression
+	if !p.peekTokenIs(lexer.COLON) {
+		p.nextToken()
+		index = p.parseExpression(LOWEST)
+	}
+
+	if !p.peekTokenIs(lexer.COLON) {
+		if !p.expectPeek(lexer.RBRACKET) {
+			return nil
+		}
+		return &ast.IndexExpression{Token: token, Left: left, Index: index}
+	}
 
 	p.nextToken()
-	exp.Index = p.parseExpression(LOWEST)
+	exp := &ast.SliceExpression{Token: token, Left: left, Low: index}
+	if !p.peekTokenIs(lexer.RBRACKET) {
+		p.nextToken()
+		exp.High = p.parseExpression(LOWEST)
+	}
 
 	if !p.expectPeek(lexer.RBRACKET) {
 		return nil
@@ -896,7 +1930,22 @@ func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 func (p *Parser) parseFieldAccessExpression(left ast.Expression) ast.Expression {
 	exp := &ast.FieldAccessExpression{Token: p.curToken, Left: left}
 
-	if !p.expectPeek(lexer.IDENT) {
+	// Tuple elements: t.0, and t.0.1, which lexes as t . 0.1
+	switch p.peekToken.Type {
+	case lexer.INT:
+		p.nextToken()
+		return p.tupleElement(exp.Token, left, p.curToken.Literal)
+	case lexer.FLOAT:
+		p.nextToken()
+		outer, inner, ok := strings.Cut(p.curToken.Literal, ".")
+		if !ok || !isDigits(outer) || !isDigits(inner) {
+			p.branchError(p.curToken, fmt.Sprintf("expected a field name or tuple position after ., got %s", p.curToken.Literal))
+			return nil
+		}
+		return p.tupleElement(exp.Token, p.tupleElement(exp.Token, left, outer), inner)
+	}
+
+	if !p.expectPeekName() {
 		return nil
 	}
 
@@ -905,12 +1954,72 @@ func (p *Parser) parseFieldAccessExpression(left ast.Expression) ast.Expression
 	return exp
 }
 
+// tupleElement returns the access to the element of left at position, for
+// the '.' token tok
+func (p *Parser) tupleElement(tok lexer.Token, left ast.Expression, position string) ast.Expression {
+	index, err := strconv.Atoi(position)
+	if err != nil {
+		p.branchError(tok, fmt.Sprintf("tuple position %s is too large", position))
+		return nil
+	}
+	return &ast.TupleElementExpression{Token: tok, Left: left, Index: index}
+}
+
+// isDigits reports whether s is a nonempty run of decimal digits
+func isDigits(s string) bool {
+	return s != "" && strings.Trim(s, "0123456789") == ""
+}
+
 func (p *Parser) parseArrayLiteral() ast.Expression {
 	array := &ast.ArrayLiteral{Token: p.curToken}
 	array.Elements = p.parseExpressionList(lexer.RBRACKET)
 	return array
 }
 
+// parseMapLiteralOrName parses `map` as a map literal if a map type follows
+// it, and as a name otherwise, so `map[k]` indexes a variable called map
+func (p *Parser) parseMapLiteralOrName() ast.Expression {
+	if p.startsMapLiteral() {
+		return p.parseMapLiteral()
+	}
+	return p.parseIdentifier()
+}
+
+// startsMapLiteral reports whether the current `map` is followed by a map
+// type: `[key]` and then, on the same line, the start of the value type. An
+// index expression `map[k]` is followed by an operator, `[index]` or the
+// next statement instead.
+func (p *Parser) startsMapLiteral() bool {
+	if !p.peekTokenIs(lexer.LBRACKET) {
+		return false
+	}
+
+	// Find the ] closing the key
+	n, depth := 2, 1
+	for ; depth > 0; n++ {
+		switch p.peekAhead(n).Type {
+		case lexer.LBRACKET:
+			depth++
+		case lexer.RBRACKET:
+			depth--
+		case lexer.EOF:
+			return false
+		}
+	}
+
+	closing, value := p.peekAhead(n-1), p.peekAhead(n)
+	if value.Line != closing.Line {
+		return false
+	}
+	switch value.Type {
+	case lexer.IDENT, lexer.MAP:
+		return true
+	case lexer.LBRACKET:
+		return p.peekAhead(n+1).Type == lexer.RBRACKET // []T, not an index
+	}
+	return false
+}
+
 func (p *Parser) parseMapLiteral() ast.Expression {
 	mapLit := &ast.MapLiteral{Token: p.curToken}
 
@@ -932,29 +2041,31 @@ func (p *Parser) parseMapLiteral() ast.Expression {
 		return nil
 	}
 
-	mapLit.Pairs = p.parseMapPairs()
+	mapLit.Keys, mapLit.Pairs = p.parseMapPairs()
 
 	return mapLit
 }
 
-func (p *Parser) parseMapPairs() map[ast.Expression]ast.Expression {
+func (p *Parser) parseMapPairs() ([]ast.Expression, map[ast.Expression]ast.Expression) {
+	var keys []ast.Expression
 	pairs := make(map[ast.Expression]ast.Expression)
 
 	if p.peekTokenIs(lexer.RBRACE) {
 		p.nextToken()
-		return pairs
+		return keys, pairs
 	}
 
 	p.nextToken() // move to first key
 	key := p.parseExpression(LOWEST)
 
 	if !p.expectPeek(lexer.COLON) {
-		return nil
+		return nil, nil
 	}
 
 	p.nextToken() // move to value
 	value := p.parseExpression(LOWEST)
 
+	keys = append(keys, key)
 	pairs[key] = value
 
 	for p.peekTokenIs(lexer.COMMA) {
@@ -964,20 +2075,44 @@ func (p *Parser) parseMapPairs() map[ast.Expression]ast.Expression {
 		key := p.parseExpression(LOWEST)
 
 		if !p.expectPeek(lexer.COLON) {
-			return nil
+			return nil, nil
 		}
 
 		p.nextToken() // move to value
 		value := p.parseExpression(LOWEST)
 
+		keys = append(keys, key)
 		pairs[key] = value
 	}
 
 	if !p.expectPeek(lexer.RBRACE) {
-		return nil
+		return nil, nil
+	}
+
+	return keys, pairs
+}
+
+// startsStructLiteral reports whether the `{` after the current identifier
+// opens struct fields: `Name {}` or `Name { field: ...`
+func (p *Parser) startsStructLiteral() bool {
+	if p.peekAhead(2).Type == lexer.RBRACE {
+		return true
 	}
+	return isName(p.peekAhead(2)) && p.peekAhead(3).Type == lexer.COLON
+}
 
-	return pairs
+// parseNewExpression parses new, the struct's name and its arguments in
+// parentheses
+func (p *Parser) parseNewExpression() ast.Expression {
+	exp := &ast.NewExpression{Token: p.curToken}
+	p.nextToken() // move to the struct's name
+	exp.Type = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	p.nextToken() // move to '('
+	exp.Arguments = p.parseExpressionList(lexer.RPAREN)
+	if exp.Arguments == nil {
+		return nil
+	}
+	return exp
 }
 
 func (p *Parser) parseStructLiteral() ast.Expression {
@@ -1046,12 +2181,12 @@ func (p *Parser) parseExpressionList(end lexer.TokenType) []ast.Expression {
 	}
 
 	p.nextToken()
-	list = append(list, p.parseExpression(LOWEST))
+	list = append(list, p.parseNested())
 
 	for p.peekTokenIs(lexer.COMMA) {
 		p.nextToken() // consume ','
 		p.nextToken() // move to next expression
-		list = append(list, p.parseExpression(LOWEST))
+		list = append(list, p.parseNested())
 	}
 
 	if !p.expectPeek(end) {
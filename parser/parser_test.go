@@ -3,6 +3,7 @@ package parser
 import (
 	"minlang/ast"
 	"minlang/lexer"
+	"strings"
 	"testing"
 )
 
@@ -77,6 +78,34 @@ func add(a: int, b: int): int {
 	}
 }
 
+func TestGenericFunctionParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"func first<T>(arr: []T): T { return arr[0] }", "func first<T>(arr: []T): T {\n  return (arr[0]);\n}"},
+		{"func get<K, V>(m: map[K]V, k: K): V { return m[k] }", "func get<K, V>(m: map[K]V, k: K): V {\n  return (m[k]);\n}"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+
+	for _, input := range []string{"func f<>(x: int) {}", "func f<T, T>(x: T) {}", "func f<T(x: T) {}"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected a parser error", input)
+		}
+	}
+}
+
 func TestIntegerLiteralExpression(t *testing.T) {
 	input := "5;"
 
@@ -209,6 +238,253 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 		{"(5 + 5) * 2;", "((5 + 5) * 2);"},
 		{"2 / (5 + 5);", "(2 / (5 + 5));"},
 		{"-(5 + 5);", "(-(5 + 5));"},
+		{"a + b as int * c;", "(a + ((b as int) * c));"},
+		{"-a as float;", "((-a) as float);"},
+		{"m[k] as []int;", "((m[k]) as []int);"},
+		{"a as int\nas = 1;", "(a as int);as = 1;"},
+		{"k in m && ok;", "((k in m) && ok);"},
+		{"a + 1 in xs == b;", "(((a + 1) in xs) == b);"},
+		{"x in xs\nin = 2;", "(x in xs);in = 2;"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		actual := program.String()
+		if actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+}
+
+func TestSliceExpressionParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"a[1:4];", "(a[1:4]);"},
+		{"a[2:];", "(a[2:]);"},
+		{"a[:n - 1];", "(a[:(n - 1)]);"},
+		{"a[:];", "(a[:]);"},
+		{"a[i][1:][:2];", "(((a[i])[1:])[:2]);"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		actual := program.String()
+		if actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+}
+
+func TestConditionalExpressionParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // The if expression input stands for
+	}{
+		{"v = a == b ? x + 1 : y;", "v = if a == b { x + 1 } else { y };"},
+		{"v = a || b ? x : y;", "v = if a || b { x } else { y };"},
+		{"v = a ? b : c ? d : e;", "v = if a { b } else { if c { d } else { e } };"},
+		{"v = a ? b ? c : d : e;", "v = if a { if b { c } else { d } } else { e };"},
+		{"f(a ? 1 : 2, m[k] ? [1] : []);", "f(if a { 1 } else { 2 }, if m[k] { [1] } else { [] });"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		want := New(lexer.New(tt.expected))
+		expected := want.ParseProgram()
+		checkParserErrors(t, want)
+
+		if program.String() != expected.String() {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, expected.String(), program.String())
+		}
+	}
+
+	p := New(lexer.New("a ? b;"))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 || !strings.Contains(p.Errors()[0], "expected next token to be :") {
+		t.Errorf("a ? b: expected a missing : error, got %q", p.Errors())
+	}
+}
+
+func TestChainedAssignmentParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string // The assignments input stands for, in order
+	}{
+		{"a = 1;", []string{"a = 1;"}},
+		{"a = b = 0;", []string{"b = 0;", "a = b;"}},
+		{"a = xs[i] = p.x = f(y);", []string{"(p.x) = f(y);", "(xs[i]) = (p.x);", "a = (xs[i]);"}},
+		{"for var i = 0; i < n; i = j = i + 1 { }", []string{"j = (i + 1);", "i = j;"}},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0]
+		if loop, ok := stmt.(*ast.ForStatement); ok {
+			stmt = loop.Post
+		}
+		var got []string
+		if chain, ok := stmt.(*ast.BlockStatement); ok {
+			for _, s := range chain.Statements {
+				got = append(got, s.String())
+			}
+		} else {
+			got = []string{stmt.String()}
+		}
+		if strings.Join(got, " ") != strings.Join(tt.expected, " ") {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestIncrementParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // The assignment input stands for
+	}{
+		{"i++;", "i = i + 1;"},
+		{"i--", "i = i - 1;"},
+		{"xs[i + 1]++;", "xs[i + 1] = xs[i + 1] + 1;"},
+		{"p.pos.x--;", "p.pos.x = p.pos.x - 1;"},
+		{"for var i = 0; i < n; i++ { s-- }", "for var i = 0; i < n; i = i + 1 { s = s - 1 }"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		want := New(lexer.New(tt.expected))
+		expected := want.ParseProgram()
+		checkParserErrors(t, want)
+
+		if program.String() != expected.String() {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, expected.String(), program.String())
+		}
+	}
+}
+
+func TestTupleParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"(1, a + b);", "(1, (a + b));"},
+		{"(1);", "1;"},
+		{"t.0 + t.1;", "((t.0) + (t.1));"},
+		{"t.0.1;", "((t.0).1);"},
+		{"f().1.x;", "((f().1).x);"},
+		{"var p: (int, []string) = (1, []);", "var p: (int, []string) = (1, []);"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		actual := program.String()
+		if actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+
+	p := New(lexer.New("var p: (int) = 1;"))
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Error("expected an error for a one-element tuple type")
+	}
+}
+
+func TestForInParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"for x in xs { print(x); }", "for x in xs {\n  print(x);\n}"},
+		{"for i in range(1, n + 1) { }", "for i in range(1, (n + 1)) {\n}"},
+		{"for in in ins { }", "for in in ins {\n}"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if _, ok := program.Statements[0].(*ast.ForInStatement); !ok {
+			t.Fatalf("statement is not ast.ForInStatement. got=%T", program.Statements[0])
+		}
+		actual := program.String()
+		if actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+}
+
+func TestTryParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"try { f(); } catch e { print(e); }", "try {\n  f();\n} catch e {\n  print(e);\n}"},
+		{"try { } catch { }", "try {\n} catch {\n}"},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if _, ok := program.Statements[0].(*ast.TryStatement); !ok {
+			t.Fatalf("statement is not ast.TryStatement. got=%T", program.Statements[0])
+		}
+		actual := program.String()
+		if actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+
+	// Elsewhere try and catch are names
+	l := lexer.New("var try = 1; catch(try);")
+	p := New(l)
+	p.ParseProgram()
+	checkParserErrors(t, p)
+
+	l = lexer.New("try { f(); } print(1);")
+	p = New(l)
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Errorf("expected an error for a try without catch")
+	}
+}
+
+func TestEnumParsing(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"enum Color { Red, Green }", "enum Color { Red, Green }"},
+		{"enum Status { Ok = 200, Created, NotFound = 404 }", "enum Status { Ok = 200, Created, NotFound = 404 }"},
+		{`enum Color { Red = "red", Green = "green" }`, `enum Color { Red = "red", Green = "green" }`},
+		{"enum Shape { Circle(float), Rect(float, float), Empty }", "enum Shape { Circle(float), Rect(float, float), Empty }"},
+		{"enum Tree { Leaf(int), Node([]int, map[string]int) }", "enum Tree { Leaf(int), Node([]int, map[string]int) }"},
 	}
 
 	for _, tt := range tests {
@@ -224,6 +500,43 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 	}
 }
 
+func TestLookupParsing(t *testing.T) {
+	tests := []struct {
+		input     string
+		expected  string
+		isMutable bool
+	}{
+		{"var v, ok = m[k];", "var v, ok = (m[k]);", true},
+		{"const n, found = counts[\"a\"]", "const n, found = (counts[\"a\"]);", false},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.LookupStatement)
+		if !ok {
+			t.Fatalf("statement is not ast.LookupStatement. got=%T", program.Statements[0])
+		}
+		if stmt.IsMutable != tt.isMutable {
+			t.Errorf("IsMutable expected=%t, got=%t", tt.isMutable, stmt.IsMutable)
+		}
+		actual := program.String()
+		if actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+
+	l := lexer.New("var a, b = 5;")
+	p := New(l)
+	p.ParseProgram()
+	if len(p.Errors()) == 0 {
+		t.Errorf("expected an error for two names without a map index")
+	}
+}
+
 func TestIfStatement(t *testing.T) {
 	input := "if 1 < 2 { var x: int = 10; }"
 
@@ -253,6 +566,103 @@ func TestIfStatement(t *testing.T) {
 	}
 }
 
+func TestSwitchStatement(t *testing.T) {
+	tests := []struct {
+		input         string
+		expectedValue string
+		expectedCases []string
+	}{
+		{"switch x { case 1 { } default { } }", "x", []string{"1"}},
+		{"switch x % 3 { case 0 { } case 1 + 1 { } default { } }", "(x % 3)", []string{"0", "(1 + 1)"}},
+		{"switch person.age { case -1 { } default { } }", "(person.age)", []string{"(-1)"}},
+		{`switch name { case "ann" { } case "bob" { } }`, "name", []string{`"ann"`, `"bob"`}},
+		{"switch items[0] { case Red { } }", "(items[0])", []string{"Red"}},
+		{"switch p { case Point{x: 0, y: y} { } case Point{} { } }", "p", []string{"Point{x: 0, y: y}", "Point{}"}},
+		{"switch xs { case [] { } case [a, _, -1] { } case [[a], Point{x: \"x\"}] { } }", "xs",
+			[]string{"[]", "[a, _, (-1)]", `[[a], Point{x: "x"}]`}},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("input %q: program.Statements does not contain 1 statement. got=%d",
+				tt.input, len(program.Statements))
+		}
+
+		stmt, ok := program.Statements[0].(*ast.SwitchStatement)
+		if !ok {
+			t.Fatalf("input %q: program.Statements[0] is not ast.SwitchStatement. got=%T",
+				tt.input, program.Statements[0])
+		}
+
+		if stmt.Value.String() != tt.expectedValue {
+			t.Errorf("input %q: switch value wrong. expected=%q, got=%q",
+				tt.input, tt.expectedValue, stmt.Value.String())
+		}
+
+		if len(stmt.Cases) != len(tt.expectedCases) {
+			t.Fatalf("input %q: expected %d cases, got=%d",
+				tt.input, len(tt.expectedCases), len(stmt.Cases))
+		}
+		for i, expected := range tt.expectedCases {
+			if got := stmt.Cases[i].Value.String(); got != expected {
+				t.Errorf("input %q: case %d value wrong. expected=%q, got=%q",
+					tt.input, i, expected, got)
+			}
+		}
+	}
+}
+
+func TestIfAndSwitchExpressions(t *testing.T) {
+	input := `
+var x = if a { 1 } else if b { 2 } else { 3 }
+var y = switch x { case 1 { "one" } default { var s = "other"; s } }
+return if a { if b { 1 } else { 2 } } else { 3 }
+`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 3 {
+		t.Fatalf("program.Statements does not contain 3 statements. got=%d",
+			len(program.Statements))
+	}
+
+	ifExp, ok := program.Statements[0].(*ast.VarStatement).Value.(*ast.IfExpression)
+	if !ok {
+		t.Fatalf("value is not *ast.IfExpression. got=%T", program.Statements[0].(*ast.VarStatement).Value)
+	}
+	// The else if becomes a block whose value is the nested if
+	alt, ok := ifExp.Alternative.(*ast.BlockStatement)
+	if !ok || len(alt.Statements) != 1 {
+		t.Fatalf("alternative is not a block of 1 statement. got=%s", ifExp.Alternative.String())
+	}
+	if _, ok := alt.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.IfExpression); !ok {
+		t.Errorf("alternative value is not *ast.IfExpression. got=%s", alt.Statements[0].String())
+	}
+
+	switchExp, ok := program.Statements[1].(*ast.VarStatement).Value.(*ast.SwitchExpression)
+	if !ok {
+		t.Fatalf("value is not *ast.SwitchExpression. got=%T", program.Statements[1].(*ast.VarStatement).Value)
+	}
+	if len(switchExp.Cases) != 1 || len(switchExp.Default.Statements) != 2 {
+		t.Errorf("switch expression has wrong shape: %s", switchExp.String())
+	}
+
+	// A final if in a branch is the branch's value
+	ret := program.Statements[2].(*ast.ReturnStatement).ReturnValue.(*ast.IfExpression)
+	last := ret.Consequence.Statements[0].(*ast.ExpressionStatement)
+	if _, ok := last.Expression.(*ast.IfExpression); !ok {
+		t.Errorf("nested if is not *ast.IfExpression. got=%T", last.Expression)
+	}
+}
+
 func TestCallExpression(t *testing.T) {
 	input := "add(1, 2 * 3, 4 + 5);"
 
@@ -321,6 +731,180 @@ func TestArrayLiteral(t *testing.T) {
 
 // Helper functions
 
+func TestStructLiteralPositions(t *testing.T) {
+	input := `
+f(Point{x: 1}, [Point{}]);
+return Point{x: 2};
+if p == (Point{x: 3}) {}
+if ready {}
+for var i = 0; i < n; i = next {}
+`
+
+	l := lexer.New(input)
+	p := New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 5 {
+		t.Fatalf("program.Statements does not contain 5 statements. got=%d",
+			len(program.Statements))
+	}
+
+	call := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+	if _, ok := call.Arguments[0].(*ast.StructLiteral); !ok {
+		t.Errorf("argument 0 is not *ast.StructLiteral. got=%T", call.Arguments[0])
+	}
+	array := call.Arguments[1].(*ast.ArrayLiteral)
+	if _, ok := array.Elements[0].(*ast.StructLiteral); !ok {
+		t.Errorf("array element is not *ast.StructLiteral. got=%T", array.Elements[0])
+	}
+
+	ret := program.Statements[1].(*ast.ReturnStatement)
+	if _, ok := ret.ReturnValue.(*ast.StructLiteral); !ok {
+		t.Errorf("return value is not *ast.StructLiteral. got=%T", ret.ReturnValue)
+	}
+
+	// In a condition, `name {` opens the body
+	ifStmt := program.Statements[3].(*ast.IfStatement)
+	if !testIdentifier(t, ifStmt.Condition, "ready") {
+		return
+	}
+	forStmt := program.Statements[4].(*ast.ForStatement)
+	post := forStmt.Post.(*ast.AssignmentStatement)
+	if !testIdentifier(t, post.Value, "next") {
+		return
+	}
+}
+
+func TestDeclaredStructLiteralInCondition(t *testing.T) {
+	input := `
+type Point = struct { x: int }
+if p == Point{x: 1} {}
+if p == Point{} {}
+if Line{x: 1} {}
+`
+
+	l := lexer.New(input)
+	p := New(l)
+
+	program := p.ParseProgram()
+
+	// Line isn't declared, so its literal opens the body
+	if len(p.Errors()) == 0 {
+		t.Fatalf("expected errors for the undeclared struct in a condition")
+	}
+
+	for _, i := range []int{1, 2} {
+		ifStmt := program.Statements[i].(*ast.IfStatement)
+		cond, ok := ifStmt.Condition.(*ast.InfixExpression)
+		if !ok {
+			t.Fatalf("statement %d: condition is not *ast.InfixExpression. got=%T", i, ifStmt.Condition)
+		}
+		if _, ok := cond.Right.(*ast.StructLiteral); !ok {
+			t.Errorf("statement %d: right operand is not *ast.StructLiteral. got=%T", i, cond.Right)
+		}
+	}
+}
+
+func TestSoftKeywordNames(t *testing.T) {
+	input := `
+var map: map[string]int = map[string]int{};
+map["a"] = 1
+var type = t.type + map["a"];
+default
+struct Point { x: int }
+`
+
+	l := lexer.New(input)
+	p := New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 5 {
+		t.Fatalf("program.Statements does not contain 5 statements. got=%d",
+			len(program.Statements))
+	}
+
+	if !testVarStatement(t, program.Statements[0], "map", true) {
+		return
+	}
+	if _, ok := program.Statements[0].(*ast.VarStatement).Value.(*ast.MapLiteral); !ok {
+		t.Errorf("value is not *ast.MapLiteral. got=%T", program.Statements[0].(*ast.VarStatement).Value)
+	}
+
+	assign := program.Statements[1].(*ast.AssignmentStatement)
+	index, ok := assign.Left.(*ast.IndexExpression)
+	if !ok {
+		t.Fatalf("assignment target is not *ast.IndexExpression. got=%T", assign.Left)
+	}
+	if !testIdentifier(t, index.Left, "map") {
+		return
+	}
+
+	if !testVarStatement(t, program.Statements[2], "type", true) {
+		return
+	}
+	value := program.Statements[2].(*ast.VarStatement).Value.(*ast.InfixExpression)
+	if field := value.Left.(*ast.FieldAccessExpression).Field.Value; field != "type" {
+		t.Errorf("expected field type, got %s", field)
+	}
+
+	stmt := program.Statements[3].(*ast.ExpressionStatement)
+	if !testIdentifier(t, stmt.Expression, "default") {
+		return
+	}
+
+	if _, ok := program.Statements[4].(*ast.StructStatement); !ok {
+		t.Errorf("statement 4 is not *ast.StructStatement. got=%T", program.Statements[4])
+	}
+}
+
+func TestStructDefaultsAndNew(t *testing.T) {
+	input := `
+type Point = struct { x: int = 0, y: int, label: string = "p" }
+var p = new Point(1, 2)
+var new = 3
+`
+
+	l := lexer.New(input)
+	p := New(l)
+
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 3 {
+		t.Fatalf("program.Statements does not contain 3 statements. got=%d",
+			len(program.Statements))
+	}
+
+	def := program.Statements[0].(*ast.TypeStatement).Definition.(*ast.StructStatement)
+	defaults := []string{"0", "", "\"p\""}
+	for i, field := range def.Fields {
+		got := ""
+		if field.Default != nil {
+			got = field.Default.String()
+		}
+		if got != defaults[i] {
+			t.Errorf("field %s: expected default %q, got %q", field.Name.Value, defaults[i], got)
+		}
+	}
+
+	newExp, ok := program.Statements[1].(*ast.VarStatement).Value.(*ast.NewExpression)
+	if !ok {
+		t.Fatalf("value is not *ast.NewExpression. got=%T", program.Statements[1].(*ast.VarStatement).Value)
+	}
+	if newExp.String() != "new Point(1, 2)" {
+		t.Errorf("expected new Point(1, 2), got %s", newExp.String())
+	}
+
+	// new is only a keyword before a struct name and its arguments
+	if !testVarStatement(t, program.Statements[2], "new", true) {
+		return
+	}
+}
+
 func checkParserErrors(t *testing.T, p *Parser) {
 	errors := p.Errors()
 	if len(errors) == 0 {
@@ -57,6 +57,18 @@ else
     FAILED=1
 fi
 
+echo ""
+echo -e "${BLUE}Running Race Detector...${NC}"
+echo ""
+
+# VMs, compilers and the interpreter must be safe to run side by side
+if go test -race ./compiler ./vm ./interp ./conformance . > /dev/null 2>&1; then
+    echo -e "${GREEN}✓ No data races detected${NC}"
+else
+    echo -e "${RED}✗ Race detector failed (go test -race ./compiler ./vm ./interp ./conformance .)${NC}"
+    FAILED=1
+fi
+
 echo ""
 echo -e "${BLUE}Running Coverage Analysis...${NC}"
 echo ""
@@ -0,0 +1,226 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Growable arrays
+//
+// append returns a new array and leaves the one it was given as it was, but
+// copying every element on each call would make building an array one append
+// at a time quadratic. Instead, the array append returns keeps spare capacity
+// past its elements, and appending to that array again fills the capacity in
+// place, handing it to the new array. The arrays involved share the elements
+// they have in common until one of them sets an element: that array takes a
+// copy of its own first, so neither sees the other change.
+//
+// An arrayStore records, for the backing slice the arrays share, which one
+// may still use its spare capacity and how many elements the others can see.
+// Arrays made any other way have no store and share nothing.
+
+// arrayStore is shared by the arrays whose elements are in the same backing
+// slice
+type arrayStore struct {
+	owner  *ArrayValue // The array that may grow into the spare capacity
+	shared int         // Elements before this index may be seen by other arrays
+}
+
+// Set sets element i of a, after giving a its own copy of the elements if it
+// shares them with another array. i must be in range.
+func (a *ArrayValue) Set(i int, v Value) {
+	if a.store != nil && (a.store.owner != a || i < a.store.shared) {
+		a.own()
+	}
+	a.Elements[i] = v
+}
+
+// own gives a a copy of its elements that no other array can see
+func (a *ArrayValue) own() {
+	if a.store == nil || (a.store.owner == a && a.store.shared == 0) {
+		return
+	}
+	elements := make([]Value, len(a.Elements), cap(a.Elements))
+	copy(elements, a.Elements)
+	a.Elements = elements
+	a.store = &arrayStore{owner: a}
+}
+
+// grow returns a's elements followed by values, in the spare capacity a owns
+// if it can hold them and in a new backing slice otherwise, along with the
+// store for the result
+func (a *ArrayValue) grow(values []Value) ([]Value, *arrayStore) {
+	n := len(a.Elements)
+	if s := a.store; s != nil && s.owner == a && n >= s.shared && n+len(values) <= cap(a.Elements) {
+		return append(a.Elements, values...), s
+	}
+	// Appending past the length of a full slice copies it, with room to grow
+	return append(a.Elements[:n:n], values...), &arrayStore{}
+}
+
+// appended returns a new array of a's elements followed by values. a keeps
+// its elements, which the result may share.
+func (a *ArrayValue) appended(values ...Value) Value {
+	elements, s := a.grow(values)
+	result := &ArrayValue{Elements: elements, store: s}
+	if s == a.store {
+		s.shared = len(a.Elements)
+	}
+	s.owner = result
+	return arrayValue(result)
+}
+
+// push adds values to the end of a
+func (a *ArrayValue) push(values ...Value) {
+	a.Elements, a.store = a.grow(values)
+	a.store.owner = a
+}
+
+// truncate shortens a to its first n elements, clearing the ones dropped
+// where no other array can see them so they can be collected
+func (a *ArrayValue) truncate(n int) {
+	dropped := a.Elements[n:]
+	if s := a.store; s == nil || (s.owner == a && n >= s.shared) {
+		clear(dropped)
+	}
+	a.Elements = a.Elements[:n]
+}
+
+// arrayArg returns args[0] as an array for the builtin name, which takes
+// want arguments
+func arrayArg(name string, want int, args []Value) (*ArrayValue, error) {
+	if len(args) != want {
+		return nil, fmt.Errorf("%s: wrong number of arguments. got=%d, want=%d", name, len(args), want)
+	}
+	if args[0].Type != ArrayType {
+		return nil, fmt.Errorf("%s: first argument must be an array", name)
+	}
+	return args[0].AsArray(), nil
+}
+
+// arrayIndex returns index as a position in arr for the builtin name, where
+// end is one past the last position allowed
+func arrayIndex(name string, index Value, end int) (int, error) {
+	if index.Type != IntType {
+		return 0, fmt.Errorf("%s: index must be an integer", name)
+	}
+	i := int(index.AsInt())
+	if i < 0 || i >= end {
+		return 0, fmt.Errorf("%s: index out of bounds: %d", name, i)
+	}
+	return i, nil
+}
+
+// popBuiltin implements pop(arr), removing and returning the last element
+func (env *builtinEnv) popBuiltin(args ...Value) (Value, error) {
+	arr, err := arrayArg("pop", 1, args)
+	if err != nil {
+		return NilValue(), err
+	}
+	n := len(arr.Elements)
+	if n == 0 {
+		return NilValue(), fmt.Errorf("pop: array is empty")
+	}
+	last := arr.Elements[n-1]
+	arr.truncate(n - 1)
+	return last, nil
+}
+
+// insertAtBuiltin implements insertAt(arr, i, x), inserting x before element
+// i, or at the end if i is the length
+func (env *builtinEnv) insertAtBuiltin(args ...Value) (Value, error) {
+	arr, err := arrayArg("insertAt", 3, args)
+	if err != nil {
+		return NilValue(), err
+	}
+	n := len(arr.Elements)
+	i, err := arrayIndex("insertAt", args[1], n+1)
+	if err != nil {
+		return NilValue(), err
+	}
+	arr.own()
+	arr.push(args[2])
+	copy(arr.Elements[i+1:], arr.Elements[i:n])
+	arr.Elements[i] = args[2]
+	return NilValue(), nil
+}
+
+// removeAtBuiltin implements removeAt(arr, i), removing and returning
+// element i
+func (env *builtinEnv) removeAtBuiltin(args ...Value) (Value, error) {
+	arr, err := arrayArg("removeAt", 2, args)
+	if err != nil {
+		return NilValue(), err
+	}
+	n := len(arr.Elements)
+	i, err := arrayIndex("removeAt", args[1], n)
+	if err != nil {
+		return NilValue(), err
+	}
+	arr.own()
+	removed := arr.Elements[i]
+	copy(arr.Elements[i:], arr.Elements[i+1:])
+	arr.truncate(n - 1)
+	return removed, nil
+}
+
+// indexOfBuiltin implements indexOf(arr, x), the index of the first element
+// equal to x, or -1 if there is none. indexOf(s, sub) is the byte offset of
+// the first sub in the string s, or -1.
+func (env *builtinEnv) indexOfBuiltin(args ...Value) (Value, error) {
+	if len(args) == 2 && args[0].Type == StringType {
+		strs, err := stringArgs("indexOf", 2, args)
+		if err != nil {
+			return NilValue(), err
+		}
+		return IntValue(int64(strings.Index(strs[0], strs[1]))), nil
+	}
+	arr, err := arrayArg("indexOf", 2, args)
+	if err != nil {
+		return NilValue(), err
+	}
+	return IntValue(int64(indexOf(arr.Elements, args[1]))), nil
+}
+
+// containsBuiltin implements contains(arr, x), whether an element equals x,
+// and contains(s, sub), whether sub is in the string s
+func (env *builtinEnv) containsBuiltin(args ...Value) (Value, error) {
+	if len(args) == 2 && args[0].Type == StringType {
+		strs, err := stringArgs("contains", 2, args)
+		if err != nil {
+			return NilValue(), err
+		}
+		return BoolValue(strings.Contains(strs[0], strs[1])), nil
+	}
+	arr, err := arrayArg("contains", 2, args)
+	if err != nil {
+		return NilValue(), err
+	}
+	return BoolValue(indexOf(arr.Elements, args[1]) >= 0), nil
+}
+
+// indexOf returns the index of the first of elements that == would find
+// equal to x, or -1. Elements == can't compare with x don't match.
+func indexOf(elements []Value, x Value) int {
+	for i, elem := range elements {
+		if eq, err := genericComparison(OpREq, elem, x); err == nil && eq.AsBool() {
+			return i
+		}
+	}
+	return -1
+}
+
+// reverseBuiltin implements reverse(arr), a new array of the elements of arr
+// in reverse order
+func (env *builtinEnv) reverseBuiltin(args ...Value) (Value, error) {
+	arr, err := arrayArg("reverse", 1, args)
+	if err != nil {
+		return NilValue(), err
+	}
+	n := len(arr.Elements)
+	reversed := make([]Value, n)
+	for i, elem := range arr.Elements {
+		reversed[n-1-i] = elem
+	}
+	return NewArrayFromElements(reversed), nil
+}
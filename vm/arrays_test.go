@@ -0,0 +1,57 @@
+package vm
+
+import "testing"
+
+func TestAppendGrowsInPlace(t *testing.T) {
+	arr := NewArrayFromElements(nil)
+	reused := 0
+	for i := range 1000 {
+		next := arr.AsArray().appended(IntValue(int64(i)))
+		if elems := arr.AsArray().Elements; len(elems) > 0 && &elems[0] == &next.AsArray().Elements[0] {
+			reused++
+		}
+		arr = next
+	}
+	if reused < 980 {
+		t.Errorf("append reused the spare capacity %d times in 1000, want nearly all", reused)
+	}
+	for i, elem := range arr.AsArray().Elements {
+		if elem.AsInt() != int64(i) {
+			t.Fatalf("element %d = %s", i, elem)
+		}
+	}
+}
+
+func TestAppendedArraysAreIndependent(t *testing.T) {
+	base := NewArrayFromElements([]Value{IntValue(1)}).AsArray().appended(IntValue(2)).AsArray()
+	a := base.appended(IntValue(3)).AsArray()
+	b := base.appended(IntValue(4)).AsArray()
+
+	a.Set(0, IntValue(10))
+	base.Set(1, IntValue(20))
+	base.push(IntValue(5))
+	a.truncate(1)
+	c := a.appended(IntValue(6)).AsArray()
+
+	tests := []struct {
+		name string
+		arr  *ArrayValue
+		want []int64
+	}{
+		{"base", base, []int64{1, 20, 5}},
+		{"a", a, []int64{10}},
+		{"b", b, []int64{1, 2, 4}},
+		{"c", c, []int64{10, 6}},
+	}
+	for _, tt := range tests {
+		if len(tt.arr.Elements) != len(tt.want) {
+			t.Errorf("%s has %d elements, want %d", tt.name, len(tt.arr.Elements), len(tt.want))
+			continue
+		}
+		for i, want := range tt.want {
+			if got := tt.arr.Elements[i].AsInt(); got != want {
+				t.Errorf("%s[%d] = %d, want %d", tt.name, i, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package vm
+
+import "fmt"
+
+// assertBuiltin implements assert(cond) and assert(cond, msg), which stop
+// the program with a runtime error, and so the position of the call, if cond
+// is false. minlang test reports the error as the failure of the test
+// calling it.
+func (env *builtinEnv) assertBuiltin(args ...Value) (Value, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return NilValue(), fmt.Errorf("assert: wrong number of arguments. got=%d, want=1 or 2", len(args))
+	}
+	if args[0].Type != BoolType {
+		return NilValue(), fmt.Errorf("assert: condition must be bool, got %s", args[0].Type)
+	}
+	if args[0].AsBool() {
+		return NilValue(), nil
+	}
+	if len(args) == 1 {
+		return NilValue(), fmt.Errorf("assertion failed")
+	}
+	return NilValue(), fmt.Errorf("assertion failed: %s", args[1].String())
+}
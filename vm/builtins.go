@@ -1,570 +1,1117 @@
 package vm
 
 import (
+	"bufio"
+	"cmp"
+	"errors"
 	"fmt"
-	"unsafe"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
 )
 
 // BuiltinFunction represents a built-in function
-type BuiltinFunction func(args ...Value) Value
-
-// Builtins is a list of built-in functions
-var Builtins = []BuiltinFunction{
-	printBuiltin,
-	lenBuiltin,
-	deleteBuiltin,
-	appendBuiltin,
-	keysBuiltin,
-	valuesBuiltin,
-	copyBuiltin,
-	enumNameBuiltin,
-	enumValueBuiltin,
-	absBuiltin,
-	minBuiltin,
-	maxBuiltin,
-	sqrtBuiltin,
-	powBuiltin,
-	floorBuiltin,
-	ceilBuiltin,
-	splitBuiltin,
-	substringBuiltin,
-	intBuiltin,
-	floatBuiltin,
-	stringBuiltin,
-}
-
-// EnumRegistry stores enum type information at runtime
-var EnumRegistry = make(map[string]map[int]string) // enumTypeName -> (value -> name)
+// args may be a view of VM stack or registers, so it must not be retained
+// after the call returns. A non-nil error aborts the program as a runtime
+// error at the call site.
+type BuiltinFunction func(args ...Value) (Value, error)
+
+// builtinEnv holds the per-program state builtins use: the stream print
+// writes to, so each VM can send output where its embedder wants, and the
+// program's enums. A nil writer means os.Stdout.
+type builtinEnv struct {
+	stdout     io.Writer     // print output
+	buf        *bufio.Writer // Buffers print output for a VM; nil writes straight through
+	flushLines bool          // Flush buf after every print, for output to a terminal
+	enums      Enums         // For enumName and enumValue
+	exitHooks  []Value       // Functions registered with onExit, run last first
+	call       CallFunc      // Calls the functions passed to map, filter and reduce
+	stdin      io.Reader     // input and readLine input; nil reads os.Stdin
+	lines      *bufio.Reader // Buffers stdin, created on the first read
+	args       []string      // Command-line arguments for args
+	recovered  *PanicError   // The panic a try caught, until recover returns its value
+}
+
+func (env *builtinEnv) out() io.Writer {
+	if env.stdout != nil {
+		return env.stdout
+	}
+	return os.Stdout
+}
+
+// newVMBuiltinEnv creates the builtinEnv for one VM. print output is buffered,
+// which makes printing in a loop many times faster, and the VM flushes it
+// when it stops. Output to a terminal is flushed after every print instead, so
+// an interactive program shows its output as it goes.
+func newVMBuiltinEnv(stdout io.Writer, enums Enums) *builtinEnv {
+	env := &builtinEnv{stdout: stdout, enums: enums}
+	env.buf = bufio.NewWriter(env.out())
+	env.flushLines = stdout == nil && stdoutIsTerminal()
+	return env
+}
+
+// stdoutIsTerminal reports whether os.Stdout is a terminal rather than a file
+// or pipe
+var stdoutIsTerminal = sync.OnceValue(func() bool {
+	info, err := os.Stdout.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+})
+
+// flush writes out any buffered print output
+func (env *builtinEnv) flush() error {
+	if env.buf == nil {
+		return nil
+	}
+	return env.buf.Flush()
+}
+
+// coreBuiltinNames lists the language's builtins in index order, matching
+// functions
+var coreBuiltinNames = []string{
+	"print", "len", "delete", "append", "keys", "values", "copy",
+	"enumName", "enumValue", "abs", "min", "max", "sqrt", "pow",
+	"floor", "ceil", "split", "substring", "int", "float", "string",
+	"builder", "add", "build", "parseInt", "formatInt", "parseFloat",
+	"sum", "avg", "enumerate", "zip", "copyMap", "clone",
+	"bsearch", "insertSorted", "heapPush", "heapPop", "heapPeek",
+	"makeArray", "makeMatrix", "formatNumber", "onExit",
+	"runes", "bytes", "validUTF8",
+	"pop", "insertAt", "removeAt", "indexOf", "contains", "reverse",
+	"map", "filter", "reduce",
+	"range",
+	"startsWith", "endsWith", "replace", "toUpper", "toLower", "trim",
+	"repeat", "join", "format", "printf",
+	"sin", "cos", "tan", "atan2", "exp", "log", "round", "trunc", "sign",
+	"now", "clock", "sleep", "formatTime",
+	"readLine", "input", "args",
+	"getenv", "setenv", "exit",
+	"hasKey",
+	"panic", "recover",
+	"assert",
+	"deepCopy",
+	"typeof",
+	"has", "getOr",
+	"entries",
+	"ord", "chr",
+}
+
+// hostBuiltins are the Go functions added with RegisterBuiltin. They are
+// numbered after the core builtins, in registration order.
+var (
+	hostBuiltinNames []string
+	hostBuiltins     []BuiltinFunction
+)
+
+// RegisterBuiltin makes fn callable from MinLang programs as name and returns
+// its builtin index. Host functions must be registered before compiling or
+// running the programs that use them, typically from an init function;
+// registration is not safe to run concurrently with compilation or execution.
+// It panics if name is empty or already a builtin, or if fn is nil.
+func RegisterBuiltin(name string, fn BuiltinFunction) int {
+	if name == "" || fn == nil {
+		panic("vm: RegisterBuiltin needs a name and a function")
+	}
+	for _, existing := range BuiltinNames() {
+		if existing == name {
+			panic("vm: RegisterBuiltin called twice for builtin " + name)
+		}
+	}
+
+	hostBuiltinNames = append(hostBuiltinNames, name)
+	hostBuiltins = append(hostBuiltins, fn)
+	Builtins = append(Builtins, fn)
+	builtinValueCache = append(builtinValueCache, NewBuiltinFunctionValue(fn))
+	return len(Builtins) - 1
+}
+
+// BuiltinNames returns the names of all builtins, core and registered, in
+// index order
+func BuiltinNames() []string {
+	names := make([]string, 0, len(coreBuiltinNames)+len(hostBuiltinNames))
+	names = append(names, coreBuiltinNames...)
+	return append(names, hostBuiltinNames...)
+}
+
+// functions returns the builtins bound to env, in builtin index order.
+// Registered host functions don't write through env and are shared as is.
+func (env *builtinEnv) functions() []BuiltinFunction {
+	core := []BuiltinFunction{
+		env.printBuiltin,
+		env.lenBuiltin,
+		env.deleteBuiltin,
+		env.appendBuiltin,
+		env.keysBuiltin,
+		env.valuesBuiltin,
+		env.copyBuiltin,
+		env.enumNameBuiltin,
+		env.enumValueBuiltin,
+		env.absBuiltin,
+		env.minBuiltin,
+		env.maxBuiltin,
+		env.sqrtBuiltin,
+		env.powBuiltin,
+		env.floorBuiltin,
+		env.ceilBuiltin,
+		env.splitBuiltin,
+		env.substringBuiltin,
+		env.intBuiltin,
+		env.floatBuiltin,
+		env.stringBuiltin,
+		env.builderBuiltin,
+		env.addBuiltin,
+		env.buildBuiltin,
+		env.parseIntBuiltin,
+		env.formatIntBuiltin,
+		env.parseFloatBuiltin,
+		env.sumBuiltin,
+		env.avgBuiltin,
+		env.enumerateBuiltin,
+		env.zipBuiltin,
+		env.copyMapBuiltin,
+		env.cloneBuiltin,
+		env.bsearchBuiltin,
+		env.insertSortedBuiltin,
+		env.heapPushBuiltin,
+		env.heapPopBuiltin,
+		env.heapPeekBuiltin,
+		env.makeArrayBuiltin,
+		env.makeMatrixBuiltin,
+		env.formatNumberBuiltin,
+		env.onExitBuiltin,
+		env.runesBuiltin,
+		env.bytesBuiltin,
+		env.validUTF8Builtin,
+		env.popBuiltin,
+		env.insertAtBuiltin,
+		env.removeAtBuiltin,
+		env.indexOfBuiltin,
+		env.containsBuiltin,
+		env.reverseBuiltin,
+		env.mapBuiltin,
+		env.filterBuiltin,
+		env.reduceBuiltin,
+		env.rangeBuiltin,
+		env.startsWithBuiltin,
+		env.endsWithBuiltin,
+		env.replaceBuiltin,
+		env.toUpperBuiltin,
+		env.toLowerBuiltin,
+		env.trimBuiltin,
+		env.repeatBuiltin,
+		env.joinBuiltin,
+		env.formatBuiltin,
+		env.printfBuiltin,
+		env.sinBuiltin,
+		env.cosBuiltin,
+		env.tanBuiltin,
+		env.atan2Builtin,
+		env.expBuiltin,
+		env.logBuiltin,
+		env.roundBuiltin,
+		env.truncBuiltin,
+		env.signBuiltin,
+		env.nowBuiltin,
+		env.clockBuiltin,
+		env.sleepBuiltin,
+		env.formatTimeBuiltin,
+		env.readLineBuiltin,
+		env.inputBuiltin,
+		env.argsBuiltin,
+		env.getenvBuiltin,
+		env.setenvBuiltin,
+		env.exitBuiltin,
+		env.hasKeyBuiltin,
+		env.panicBuiltin,
+		env.recoverBuiltin,
+		env.assertBuiltin,
+		env.deepCopyBuiltin,
+		env.typeofBuiltin,
+		env.hasBuiltin,
+		env.getOrBuiltin,
+		env.entriesBuiltin,
+		env.ordBuiltin,
+		env.chrBuiltin,
+	}
+	return append(core, hostBuiltins...)
+}
+
+// defaultBuiltinEnv writes to os.Stdout and knows no enums
+var defaultBuiltinEnv = &builtinEnv{}
+
+// Builtins is a list of built-in functions writing to os.Stdout, for programs
+// without enums
+var Builtins = defaultBuiltinEnv.functions()
+
+// Enums maps each enum type name to its variants, in declaration order.
+// Compilers record a program's enums in its bytecode and each VM reads its
+// own.
+type Enums map[string][]EnumVariant
+
+// EnumVariant is a variant of an enum and the value it stands for, an int,
+// or a string in a string enum. In an enum whose variants carry payloads, it
+// is a variant value tagged like every value of the variant.
+type EnumVariant struct {
+	Name  string
+	Value Value
+}
+
+// Is reports whether the variant stands for value
+func (v EnumVariant) Is(value Value) bool {
+	if v.Value.Type != value.Type {
+		return false
+	}
+	switch value.Type {
+	case StringType:
+		return v.Value.AsString() == value.AsString()
+	case VariantType:
+		return v.Value.VariantTag() == value.VariantTag()
+	}
+	return v.Value.AsInt() == value.AsInt()
+}
 
 // printBuiltin implements the print function
-func printBuiltin(args ...Value) Value {
+func (env *builtinEnv) printBuiltin(args ...Value) (Value, error) {
+	var out io.Writer = env.buf
+	if env.buf == nil {
+		out = env.out()
+	}
 	for i, arg := range args {
 		if i > 0 {
-			fmt.Print(" ")
+			io.WriteString(out, " ")
 		}
-		fmt.Print(arg.String())
+		io.WriteString(out, arg.String())
 	}
-	fmt.Println()
-	return NilValue()
+	io.WriteString(out, "\n")
+	if env.flushLines {
+		env.buf.Flush()
+	}
+	return NilValue(), nil
 }
 
 // lenBuiltin implements the len function
-func lenBuiltin(args ...Value) Value {
+func (env *builtinEnv) lenBuiltin(args ...Value) (Value, error) {
 	if len(args) != 1 {
-		fmt.Printf("len: wrong number of arguments. got=%d, want=1\n", len(args))
-		return NilValue()
+		return NilValue(), fmt.Errorf("len: wrong number of arguments. got=%d, want=1", len(args))
 	}
 
 	arg := args[0]
 	switch arg.Type {
 	case ArrayType:
-		return IntValue(int64(len(arg.AsArray().Elements)))
+		return IntValue(int64(len(arg.AsArray().Elements))), nil
 	case MapType:
-		return IntValue(int64(len(arg.AsMap().Pairs)))
+		return IntValue(int64(len(arg.AsMap().Pairs))), nil
 	case StringType:
-		return IntValue(int64(len(arg.AsString())))
+		return IntValue(int64(len(arg.AsString()))), nil
 	default:
-		fmt.Printf("len: argument not supported for type %d\n", arg.Type)
-		return NilValue()
+		return NilValue(), fmt.Errorf("len: argument not supported for type %d", arg.Type)
 	}
 }
 
 // deleteBuiltin implements the delete function for maps
-func deleteBuiltin(args ...Value) Value {
+func (env *builtinEnv) deleteBuiltin(args ...Value) (Value, error) {
 	if len(args) != 2 {
-		fmt.Printf("delete: wrong number of arguments. got=%d, want=2\n", len(args))
-		return NilValue()
+		return NilValue(), fmt.Errorf("delete: wrong number of arguments. got=%d, want=2", len(args))
 	}
 
 	mapVal := args[0]
 	key := args[1]
 
 	if mapVal.Type != MapType {
-		fmt.Printf("delete: first argument must be a map\n")
-		return NilValue()
+		return NilValue(), fmt.Errorf("delete: first argument must be a map")
 	}
 
-	mapKey := key.ToMapKey()
-	mapData := mapVal.AsMap()
-	delete(mapData.Pairs, mapKey)
+	mapVal.AsMap().Delete(key.ToMapKey())
+
+	return NilValue(), nil
+}
 
-	return NilValue()
+// hasKeyBuiltin implements hasKey(m, k), whether the map m has the key k
+func (env *builtinEnv) hasKeyBuiltin(args ...Value) (Value, error) {
+	if len(args) != 2 {
+		return NilValue(), fmt.Errorf("hasKey: wrong number of arguments. got=%d, want=2", len(args))
+	}
+	if args[0].Type != MapType {
+		return NilValue(), fmt.Errorf("hasKey: first argument must be a map")
+	}
+	_, ok := args[0].AsMap().Pairs[args[1].ToMapKey()]
+	return BoolValue(ok), nil
 }
 
 // appendBuiltin implements the append function for arrays
-func appendBuiltin(args ...Value) Value {
+func (env *builtinEnv) appendBuiltin(args ...Value) (Value, error) {
 	if len(args) < 2 {
-		fmt.Printf("append: wrong number of arguments. got=%d, want=2+\n", len(args))
-		return NilValue()
+		return NilValue(), fmt.Errorf("append: wrong number of arguments. got=%d, want=2+", len(args))
 	}
 
 	arrayVal := args[0]
 	if arrayVal.Type != ArrayType {
-		fmt.Printf("append: first argument must be an array\n")
-		return NilValue()
+		return NilValue(), fmt.Errorf("append: first argument must be an array")
 	}
 
-	oldArray := arrayVal.AsArray()
-	newElements := make([]Value, len(oldArray.Elements)+len(args)-1)
+	return arrayVal.AsArray().appended(args[1:]...), nil
+}
 
-	// Copy existing elements
-	copy(newElements, oldArray.Elements)
+// compareOrdered compares two ints, floats or strings for the builtin name,
+// returning -1, 0 or 1. An int and a float compare as floats.
+func compareOrdered(name string, a, b Value) (int, error) {
+	if a.Type == IntType && b.Type == IntType {
+		return cmp.Compare(a.AsInt(), b.AsInt()), nil
+	}
+	if a.Type == StringType && b.Type == StringType {
+		return strings.Compare(a.AsString(), b.AsString()), nil
+	}
+	if af, ok := numericAsFloat(a); ok {
+		if bf, ok := numericAsFloat(b); ok {
+			return cmp.Compare(af, bf), nil
+		}
+	}
+	return 0, fmt.Errorf("%s: can only order ints and floats, or strings", name)
+}
+
+// searchSorted returns the index of the first element of a sorted array not
+// less than x, and whether that element equals x
+func searchSorted(name string, elements []Value, x Value) (int, bool, error) {
+	var err error
+	i, found := slices.BinarySearchFunc(elements, x, func(elem, target Value) int {
+		c, cmpErr := compareOrdered(name, elem, target)
+		if cmpErr != nil && err == nil {
+			err = cmpErr
+		}
+		return c
+	})
+	return i, found, err
+}
 
-	// Append new elements
-	for i := 1; i < len(args); i++ {
-		newElements[len(oldArray.Elements)+i-1] = args[i]
+// bsearchBuiltin implements bsearch(arr, x), the index of x in a sorted array,
+// or -1 if it isn't there
+func (env *builtinEnv) bsearchBuiltin(args ...Value) (Value, error) {
+	if len(args) != 2 {
+		return NilValue(), fmt.Errorf("bsearch: wrong number of arguments. got=%d, want=2", len(args))
+	}
+
+	if args[0].Type != ArrayType {
+		return NilValue(), fmt.Errorf("bsearch: first argument must be an array")
+	}
+
+	i, found, err := searchSorted("bsearch", args[0].AsArray().Elements, args[1])
+	if err != nil {
+		return NilValue(), err
+	}
+	if !found {
+		return IntValue(-1), nil
+	}
+	return IntValue(int64(i)), nil
+}
+
+// insertSortedBuiltin implements insertSorted(arr, x), returning a new array
+// with x inserted into a sorted array where it keeps it sorted, before any
+// equal elements
+func (env *builtinEnv) insertSortedBuiltin(args ...Value) (Value, error) {
+	if len(args) != 2 {
+		return NilValue(), fmt.Errorf("insertSorted: wrong number of arguments. got=%d, want=2", len(args))
+	}
+
+	if args[0].Type != ArrayType {
+		return NilValue(), fmt.Errorf("insertSorted: first argument must be an array")
+	}
+
+	elements := args[0].AsArray().Elements
+	i, _, err := searchSorted("insertSorted", elements, args[1])
+	if err != nil {
+		return NilValue(), err
+	}
+
+	newElements := make([]Value, 0, len(elements)+1)
+	newElements = append(newElements, elements[:i]...)
+	newElements = append(newElements, args[1])
+	newElements = append(newElements, elements[i:]...)
+	return NewArrayFromElements(newElements), nil
+}
+
+// compareHeapEntries orders two heap entries. Entries that are arrays, like
+// the [priority, item] pairs heapPush makes, order by their first element.
+func compareHeapEntries(name string, a, b Value) (int, error) {
+	if a.Type == ArrayType && b.Type == ArrayType {
+		aElems, bElems := a.AsArray().Elements, b.AsArray().Elements
+		if len(aElems) > 0 && len(bElems) > 0 {
+			return compareOrdered(name, aElems[0], bElems[0])
+		}
+	}
+	return compareOrdered(name, a, b)
+}
+
+// siftUp moves the entry at i towards the root of a min-heap until its parent
+// is no greater
+func siftUp(name string, heap []Value, i int) error {
+	for i > 0 {
+		parent := (i - 1) / 2
+		c, err := compareHeapEntries(name, heap[i], heap[parent])
+		if err != nil {
+			return err
+		}
+		if c >= 0 {
+			break
+		}
+		heap[i], heap[parent] = heap[parent], heap[i]
+		i = parent
+	}
+	return nil
+}
+
+// siftDown moves the entry at i away from the root of a min-heap until no
+// child is smaller
+func siftDown(name string, heap []Value, i int) error {
+	for {
+		smallest := i
+		for _, child := range []int{2*i + 1, 2*i + 2} {
+			if child >= len(heap) {
+				break
+			}
+			c, err := compareHeapEntries(name, heap[child], heap[smallest])
+			if err != nil {
+				return err
+			}
+			if c < 0 {
+				smallest = child
+			}
+		}
+		if smallest == i {
+			return nil
+		}
+		heap[i], heap[smallest] = heap[smallest], heap[i]
+		i = smallest
+	}
+}
+
+// heapPushBuiltin implements heapPush(heap, x), adding x to an array kept as
+// a min-heap, and heapPush(heap, item, priority), adding the pair
+// [priority, item]. The heap is changed in place.
+func (env *builtinEnv) heapPushBuiltin(args ...Value) (Value, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return NilValue(), fmt.Errorf("heapPush: wrong number of arguments. got=%d, want=2 or 3", len(args))
+	}
+
+	if args[0].Type != ArrayType {
+		return NilValue(), fmt.Errorf("heapPush: first argument must be an array")
 	}
 
-	arr := &ArrayValue{Elements: newElements}
-	// Add to pool to keep it alive for GC (critical - without this the pointer becomes dangling!)
-	arrayPool = append(arrayPool, arr)
-	return Value{
-		Type: ArrayType,
-		Data: uint64(uintptr(unsafe.Pointer(arr))),
+	entry := args[1]
+	if len(args) == 3 {
+		entry = NewArrayFromElements([]Value{args[2], args[1]})
 	}
+
+	arr := args[0].AsArray()
+	arr.own()
+	arr.push(entry)
+	if err := siftUp("heapPush", arr.Elements, len(arr.Elements)-1); err != nil {
+		arr.truncate(len(arr.Elements) - 1)
+		return NilValue(), err
+	}
+	return NilValue(), nil
 }
 
-// keysBuiltin implements the keys function for maps
-func keysBuiltin(args ...Value) Value {
+// heapPopBuiltin implements heapPop(heap), removing and returning the
+// smallest entry of a min-heap
+func (env *builtinEnv) heapPopBuiltin(args ...Value) (Value, error) {
 	if len(args) != 1 {
-		fmt.Printf("keys: wrong number of arguments. got=%d, want=1\n", len(args))
-		return NilValue()
+		return NilValue(), fmt.Errorf("heapPop: wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	if args[0].Type != ArrayType {
+		return NilValue(), fmt.Errorf("heapPop: argument must be an array")
+	}
+
+	arr := args[0].AsArray()
+	if len(arr.Elements) == 0 {
+		return NilValue(), fmt.Errorf("heapPop: heap is empty")
+	}
+
+	arr.own()
+	top := arr.Elements[0]
+	last := len(arr.Elements) - 1
+	arr.Elements[0] = arr.Elements[last]
+	arr.truncate(last)
+	if err := siftDown("heapPop", arr.Elements, 0); err != nil {
+		return NilValue(), err
+	}
+	return top, nil
+}
+
+// heapPeekBuiltin implements heapPeek(heap), the smallest entry of a min-heap
+func (env *builtinEnv) heapPeekBuiltin(args ...Value) (Value, error) {
+	if len(args) != 1 {
+		return NilValue(), fmt.Errorf("heapPeek: wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	if args[0].Type != ArrayType {
+		return NilValue(), fmt.Errorf("heapPeek: argument must be an array")
+	}
+
+	elements := args[0].AsArray().Elements
+	if len(elements) == 0 {
+		return NilValue(), fmt.Errorf("heapPeek: heap is empty")
+	}
+	return elements[0], nil
+}
+
+// arraySize checks that size, argument which of the builtin name, is an int
+// that can be an array's length
+func arraySize(name, which string, size Value) (int, error) {
+	if size.Type != IntType {
+		return 0, fmt.Errorf("%s: %s must be int", name, which)
+	}
+	if size.AsInt() < 0 {
+		return 0, fmt.Errorf("%s: %s must be non-negative", name, which)
+	}
+	return int(size.AsInt()), nil
+}
+
+// filledArray returns an array of n copies of fill. A fill that is a
+// collection is deep copied for each element, so no two elements share it.
+func filledArray(n int, fill Value) Value {
+	elements := make([]Value, n)
+	for i := range elements {
+		elements[i] = deepCopy(fill)
+	}
+	return NewArrayFromElements(elements)
+}
+
+// makeArrayBuiltin implements makeArray(n, fill), an array of n elements
+// that each start as fill
+func (env *builtinEnv) makeArrayBuiltin(args ...Value) (Value, error) {
+	if len(args) != 2 {
+		return NilValue(), fmt.Errorf("makeArray: wrong number of arguments. got=%d, want=2", len(args))
+	}
+
+	n, err := arraySize("makeArray", "size", args[0])
+	if err != nil {
+		return NilValue(), err
+	}
+	return filledArray(n, args[1]), nil
+}
+
+// makeMatrixBuiltin implements makeMatrix(rows, cols, fill), an array of rows
+// arrays of cols elements that each start as fill. Every row is a separate
+// array.
+func (env *builtinEnv) makeMatrixBuiltin(args ...Value) (Value, error) {
+	if len(args) != 3 {
+		return NilValue(), fmt.Errorf("makeMatrix: wrong number of arguments. got=%d, want=3", len(args))
+	}
+
+	rows, err := arraySize("makeMatrix", "rows", args[0])
+	if err != nil {
+		return NilValue(), err
+	}
+	cols, err := arraySize("makeMatrix", "cols", args[1])
+	if err != nil {
+		return NilValue(), err
+	}
+
+	matrix := make([]Value, rows)
+	for i := range matrix {
+		matrix[i] = filledArray(cols, args[2])
+	}
+	return NewArrayFromElements(matrix), nil
+}
+
+// copyMapBuiltin implements the copyMap function, a shallow copy of a map
+func (env *builtinEnv) copyMapBuiltin(args ...Value) (Value, error) {
+	if len(args) != 1 {
+		return NilValue(), fmt.Errorf("copyMap: wrong number of arguments. got=%d, want=1", len(args))
 	}
 
 	mapVal := args[0]
 	if mapVal.Type != MapType {
-		fmt.Printf("keys: argument must be a map\n")
-		return NilValue()
+		return NilValue(), fmt.Errorf("copyMap: argument must be a map")
 	}
 
-	mapData := mapVal.AsMap()
-	keys := make([]Value, 0, len(mapData.Pairs))
+	return copyCollection(mapVal), nil
+}
+
+// cloneBuiltin implements the clone function, a deep copy of any value. The
+// copy shares nothing mutable with the original, even through cycles.
+func (env *builtinEnv) cloneBuiltin(args ...Value) (Value, error) {
+	if len(args) != 1 {
+		return NilValue(), fmt.Errorf("clone: wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	return deepCopy(args[0]), nil
+}
+
+// deepCopyBuiltin implements deepCopy(v), clone under the name that says
+// what it does to arrays, maps and structs nested in v
+func (env *builtinEnv) deepCopyBuiltin(args ...Value) (Value, error) {
+	if len(args) != 1 {
+		return NilValue(), fmt.Errorf("deepCopy: wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	return deepCopy(args[0]), nil
+}
+
+// typeofBuiltin implements typeof(v), the name of v's type: int, float,
+// string, bool, nil, array, map, function, error, variant or builder, or for
+// a struct the name of its struct type
+func (env *builtinEnv) typeofBuiltin(args ...Value) (Value, error) {
+	if len(args) != 1 {
+		return NilValue(), fmt.Errorf("typeof: wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	switch v := args[0]; v.Type {
+	case StructType:
+		return StringValue(v.AsStruct().TypeName), nil
+	case ClosureType, BuiltinFunctionType:
+		return StringValue("function"), nil
+	default:
+		return StringValue(v.Type.String()), nil
+	}
+}
+
+// enumerateBuiltin implements enumerate(arr), returning an [index, element]
+// pair for each element of an array
+func (env *builtinEnv) enumerateBuiltin(args ...Value) (Value, error) {
+	if len(args) != 1 {
+		return NilValue(), fmt.Errorf("enumerate: wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	arrayVal := args[0]
+	if arrayVal.Type != ArrayType {
+		return NilValue(), fmt.Errorf("enumerate: argument must be an array")
+	}
+
+	elements := arrayVal.AsArray().Elements
+	pairs := make([]Value, len(elements))
+	for i, elem := range elements {
+		pairs[i] = NewArrayFromElements([]Value{IntValue(int64(i)), elem})
+	}
+
+	return NewArrayFromElements(pairs), nil
+}
+
+// zipBuiltin implements zip(a, b), returning an [a[i], b[i]] pair for each
+// index of two arrays, stopping at the end of the shorter one
+func (env *builtinEnv) zipBuiltin(args ...Value) (Value, error) {
+	if len(args) != 2 {
+		return NilValue(), fmt.Errorf("zip: wrong number of arguments. got=%d, want=2", len(args))
+	}
+
+	if args[0].Type != ArrayType || args[1].Type != ArrayType {
+		return NilValue(), fmt.Errorf("zip: arguments must be arrays")
+	}
 
-	for mapKey := range mapData.Pairs {
-		if mapKey.IsInt {
-			keys = append(keys, IntValue(mapKey.IntVal))
-		} else {
-			keys = append(keys, StringValue(mapKey.StrVal))
+	first := args[0].AsArray().Elements
+	second := args[1].AsArray().Elements
+	pairs := make([]Value, min(len(first), len(second)))
+	for i := range pairs {
+		pairs[i] = NewArrayFromElements([]Value{first[i], second[i]})
+	}
+
+	return NewArrayFromElements(pairs), nil
+}
+
+// keysBuiltin implements keys(m), the keys of the map m in the order they
+// were first set, and keys(m, true), its keys sorted the way maps print:
+// ints, in order, then strings
+func (env *builtinEnv) keysBuiltin(args ...Value) (Value, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return NilValue(), fmt.Errorf("keys: wrong number of arguments. got=%d, want=1 or 2", len(args))
+	}
+
+	mapVal := args[0]
+	if mapVal.Type != MapType {
+		return NilValue(), fmt.Errorf("keys: argument must be a map")
+	}
+	mapKeys := mapVal.AsMap().Keys()
+	if len(args) == 2 {
+		if args[1].Type != BoolType {
+			return NilValue(), fmt.Errorf("keys: second argument must be a bool, got %s", args[1].Type)
+		}
+		if args[1].AsBool() {
+			mapKeys = slices.SortedFunc(slices.Values(mapKeys), compareMapKeys)
 		}
 	}
 
-	arr := &ArrayValue{Elements: keys}
-	// Add to pool to keep it alive for GC
-	arrayPool = append(arrayPool, arr)
-	return Value{
-		Type: ArrayType,
-		Data: uint64(uintptr(unsafe.Pointer(arr))),
+	keys := make([]Value, 0, len(mapKeys))
+	for _, mapKey := range mapKeys {
+		keys = append(keys, mapKey.Value())
 	}
+
+	return NewArrayFromElements(keys), nil
 }
 
-// valuesBuiltin implements the values function for maps
-func valuesBuiltin(args ...Value) Value {
+// valuesBuiltin implements values(m), the values of the map m in the order
+// of its keys
+func (env *builtinEnv) valuesBuiltin(args ...Value) (Value, error) {
 	if len(args) != 1 {
-		fmt.Printf("values: wrong number of arguments. got=%d, want=1\n", len(args))
-		return NilValue()
+		return NilValue(), fmt.Errorf("values: wrong number of arguments. got=%d, want=1", len(args))
 	}
 
 	mapVal := args[0]
 	if mapVal.Type != MapType {
-		fmt.Printf("values: argument must be a map\n")
-		return NilValue()
+		return NilValue(), fmt.Errorf("values: argument must be a map")
 	}
 
 	mapData := mapVal.AsMap()
 	values := make([]Value, 0, len(mapData.Pairs))
 
-	for _, value := range mapData.Pairs {
-		values = append(values, value)
+	for _, key := range mapData.Keys() {
+		values = append(values, mapData.Pairs[key])
 	}
 
-	arr := &ArrayValue{Elements: values}
-	// Add to pool to keep it alive for GC
-	arrayPool = append(arrayPool, arr)
-	return Value{
-		Type: ArrayType,
-		Data: uint64(uintptr(unsafe.Pointer(arr))),
-	}
+	return NewArrayFromElements(values), nil
 }
 
 // copyBuiltin implements the copy function for arrays
-func copyBuiltin(args ...Value) Value {
+func (env *builtinEnv) copyBuiltin(args ...Value) (Value, error) {
 	if len(args) != 1 {
-		fmt.Printf("copy: wrong number of arguments. got=%d, want=1\n", len(args))
-		return NilValue()
+		return NilValue(), fmt.Errorf("copy: wrong number of arguments. got=%d, want=1", len(args))
 	}
 
 	arrayVal := args[0]
 	if arrayVal.Type != ArrayType {
-		fmt.Printf("copy: argument must be an array\n")
-		return NilValue()
+		return NilValue(), fmt.Errorf("copy: argument must be an array")
 	}
 
 	oldArray := arrayVal.AsArray()
 	newElements := make([]Value, len(oldArray.Elements))
 	copy(newElements, oldArray.Elements)
 
-	arr := &ArrayValue{Elements: newElements}
-	// Add to pool to keep it alive for GC
-	arrayPool = append(arrayPool, arr)
-	return Value{
-		Type: ArrayType,
-		Data: uint64(uintptr(unsafe.Pointer(arr))),
-	}
+	return NewArrayFromElements(newElements), nil
 }
 
 // enumNameBuiltin implements enumName(enumType, value) -> string
-func enumNameBuiltin(args ...Value) Value {
+func (env *builtinEnv) enumNameBuiltin(args ...Value) (Value, error) {
 	if len(args) != 2 {
-		fmt.Printf("enumName: wrong number of arguments. got=%d, want=2\n", len(args))
-		return NilValue()
+		return NilValue(), fmt.Errorf("enumName: wrong number of arguments. got=%d, want=2", len(args))
 	}
 
 	enumTypeName := args[0]
 	enumValue := args[1]
 
 	if enumTypeName.Type != StringType {
-		fmt.Printf("enumName: first argument must be string (enum type name)\n")
-		return NilValue()
+		return NilValue(), fmt.Errorf("enumName: first argument must be string (enum type name)")
 	}
 
-	if enumValue.Type != IntType {
-		fmt.Printf("enumName: second argument must be int (enum value)\n")
-		return NilValue()
+	if enumValue.Type != IntType && enumValue.Type != StringType && enumValue.Type != VariantType {
+		return NilValue(), fmt.Errorf("enumName: second argument must be int or string (enum value), or a variant")
 	}
 
 	typeName := enumTypeName.AsString()
-	value := int(enumValue.AsInt())
 
 	// Look up enum type in registry
-	enumType, ok := EnumRegistry[typeName]
+	variants, ok := env.enums[typeName]
 	if !ok {
-		fmt.Printf("enumName: unknown enum type '%s'\n", typeName)
-		return NilValue()
+		return NilValue(), fmt.Errorf("enumName: unknown enum type '%s'", typeName)
 	}
 
 	// Look up variant name
-	name, ok := enumType[value]
-	if !ok {
-		fmt.Printf("enumName: invalid value %d for enum type '%s'\n", value, typeName)
-		return NilValue()
+	for _, variant := range variants {
+		if variant.Is(enumValue) {
+			return StringValue(variant.Name), nil
+		}
 	}
 
-	return StringValue(name)
+	return NilValue(), fmt.Errorf("enumName: invalid value %s for enum type '%s'", enumValue.String(), typeName)
 }
 
-// enumValueBuiltin implements enumValue(enumType, name) -> int or error
-func enumValueBuiltin(args ...Value) Value {
+// enumValueBuiltin implements enumValue(enumType, name) -> int, string or error
+func (env *builtinEnv) enumValueBuiltin(args ...Value) (Value, error) {
 	if len(args) != 2 {
-		fmt.Printf("enumValue: wrong number of arguments. got=%d, want=2\n", len(args))
-		return NilValue()
+		return NilValue(), fmt.Errorf("enumValue: wrong number of arguments. got=%d, want=2", len(args))
 	}
 
 	enumTypeName := args[0]
 	variantName := args[1]
 
 	if enumTypeName.Type != StringType {
-		fmt.Printf("enumValue: first argument must be string (enum type name)\n")
-		return NilValue()
+		return NilValue(), fmt.Errorf("enumValue: first argument must be string (enum type name)")
 	}
 
 	if variantName.Type != StringType {
-		fmt.Printf("enumValue: second argument must be string (variant name)\n")
-		return NilValue()
+		return NilValue(), fmt.Errorf("enumValue: second argument must be string (variant name)")
 	}
 
 	typeName := enumTypeName.AsString()
 	name := variantName.AsString()
 
 	// Look up enum type in registry
-	enumType, ok := EnumRegistry[typeName]
+	variants, ok := env.enums[typeName]
 	if !ok {
-		fmt.Printf("enumValue: unknown enum type '%s'\n", typeName)
-		return NilValue()
+		return NilValue(), fmt.Errorf("enumValue: unknown enum type '%s'", typeName)
 	}
 
 	// Find variant value by name
-	for value, varName := range enumType {
-		if varName == name {
-			return IntValue(int64(value))
+	for _, variant := range variants {
+		if variant.Name == name {
+			return variant.Value, nil
 		}
 	}
 
-	fmt.Printf("enumValue: unknown variant '%s' for enum type '%s'\n", name, typeName)
-	return NilValue()
+	return NilValue(), fmt.Errorf("enumValue: unknown variant '%s' for enum type '%s'", name, typeName)
 }
 
 // absBuiltin implements abs(n) - absolute value
-func absBuiltin(args ...Value) Value {
+func (env *builtinEnv) absBuiltin(args ...Value) (Value, error) {
 	if len(args) != 1 {
-		fmt.Printf("abs: wrong number of arguments. got=%d, want=1\n", len(args))
-		return NilValue()
+		return NilValue(), fmt.Errorf("abs: wrong number of arguments. got=%d, want=1", len(args))
 	}
 
-	arg := args[0]
-	switch arg.Type {
-	case IntType:
-		val := arg.AsInt()
-		if val < 0 {
-			return IntValue(-val)
-		}
-		return arg
-	case FloatType:
-		val := arg.AsFloat()
-		if val < 0 {
-			return FloatValue(-val)
-		}
-		return arg
-	default:
-		fmt.Printf("abs: argument must be int or float\n")
-		return NilValue()
+	if !isNumber(args[0]) {
+		return NilValue(), fmt.Errorf("abs: argument must be int or float")
 	}
+	return absNumber(args[0]), nil
 }
 
-// minBuiltin implements min(a, b) - minimum of two numbers
-func minBuiltin(args ...Value) Value {
+// minBuiltin implements min(a, b) - minimum of two numbers - and min(arr), the
+// minimum of an array's elements
+func (env *builtinEnv) minBuiltin(args ...Value) (Value, error) {
+	if len(args) == 1 && args[0].Type == ArrayType {
+		return reduceArray("min", args[0].AsArray(), env.minBuiltin)
+	}
 	if len(args) != 2 {
-		fmt.Printf("min: wrong number of arguments. got=%d, want=2\n", len(args))
-		return NilValue()
+		return NilValue(), fmt.Errorf("min: wrong number of arguments. got=%d, want=1 or 2", len(args))
 	}
 
-	a, b := args[0], args[1]
-
-	// Handle int, int
-	if a.Type == IntType && b.Type == IntType {
-		if a.AsInt() < b.AsInt() {
-			return a
-		}
-		return b
+	if !isNumber(args[0]) || !isNumber(args[1]) {
+		return NilValue(), fmt.Errorf("min: arguments must be int or float")
 	}
+	return minNumbers(args[0], args[1]), nil
+}
 
-	// Handle float cases
-	if (a.Type == FloatType || a.Type == IntType) && (b.Type == FloatType || b.Type == IntType) {
-		var aFloat, bFloat float64
-		if a.Type == IntType {
-			aFloat = float64(a.AsInt())
-		} else {
-			aFloat = a.AsFloat()
-		}
-		if b.Type == IntType {
-			bFloat = float64(b.AsInt())
-		} else {
-			bFloat = b.AsFloat()
-		}
+// maxBuiltin implements max(a, b) - maximum of two numbers - and max(arr), the
+// maximum of an array's elements
+func (env *builtinEnv) maxBuiltin(args ...Value) (Value, error) {
+	if len(args) == 1 && args[0].Type == ArrayType {
+		return reduceArray("max", args[0].AsArray(), env.maxBuiltin)
+	}
+	if len(args) != 2 {
+		return NilValue(), fmt.Errorf("max: wrong number of arguments. got=%d, want=1 or 2", len(args))
+	}
 
-		if aFloat < bFloat {
-			return FloatValue(aFloat)
-		}
-		return FloatValue(bFloat)
+	if !isNumber(args[0]) || !isNumber(args[1]) {
+		return NilValue(), fmt.Errorf("max: arguments must be int or float")
 	}
+	return maxNumbers(args[0], args[1]), nil
+}
+
+// The helpers below compute abs, min and max for the builtins and for the
+// opcodes calls to them are lowered to. A float-typed variable may hold an
+// int, so they take ints and floats alike.
 
-	fmt.Printf("min: arguments must be int or float\n")
-	return NilValue()
+func isNumber(v Value) bool {
+	return v.Type == IntType || v.Type == FloatType
 }
 
-// maxBuiltin implements max(a, b) - maximum of two numbers
-func maxBuiltin(args ...Value) Value {
-	if len(args) != 2 {
-		fmt.Printf("max: wrong number of arguments. got=%d, want=2\n", len(args))
-		return NilValue()
+// absNumber returns the absolute value of an int or float
+func absNumber(v Value) Value {
+	if v.Type == IntType {
+		if val := v.AsInt(); val < 0 {
+			return IntValue(-val)
+		}
+		return v
 	}
+	if val := v.AsFloat(); val < 0 {
+		return FloatValue(-val)
+	}
+	return v
+}
 
-	a, b := args[0], args[1]
-
-	// Handle int, int
+// minNumbers returns the smaller of two ints or floats, as a float unless
+// both are ints
+func minNumbers(a, b Value) Value {
 	if a.Type == IntType && b.Type == IntType {
-		if a.AsInt() > b.AsInt() {
+		if a.AsInt() < b.AsInt() {
 			return a
 		}
 		return b
 	}
 
-	// Handle float cases
-	if (a.Type == FloatType || a.Type == IntType) && (b.Type == FloatType || b.Type == IntType) {
-		var aFloat, bFloat float64
-		if a.Type == IntType {
-			aFloat = float64(a.AsInt())
-		} else {
-			aFloat = a.AsFloat()
-		}
-		if b.Type == IntType {
-			bFloat = float64(b.AsInt())
-		} else {
-			bFloat = b.AsFloat()
-		}
+	aFloat, _ := numericAsFloat(a)
+	bFloat, _ := numericAsFloat(b)
+	if aFloat < bFloat {
+		return FloatValue(aFloat)
+	}
+	return FloatValue(bFloat)
+}
 
-		if aFloat > bFloat {
-			return FloatValue(aFloat)
+// maxNumbers returns the larger of two ints or floats, as a float unless
+// both are ints
+func maxNumbers(a, b Value) Value {
+	if a.Type == IntType && b.Type == IntType {
+		if a.AsInt() > b.AsInt() {
+			return a
 		}
-		return FloatValue(bFloat)
+		return b
 	}
 
-	fmt.Printf("max: arguments must be int or float\n")
-	return NilValue()
+	aFloat, _ := numericAsFloat(a)
+	bFloat, _ := numericAsFloat(b)
+	if aFloat > bFloat {
+		return FloatValue(aFloat)
+	}
+	return FloatValue(bFloat)
 }
 
-// sqrtBuiltin implements sqrt(n) - square root
-func sqrtBuiltin(args ...Value) Value {
-	if len(args) != 1 {
-		fmt.Printf("sqrt: wrong number of arguments. got=%d, want=1\n", len(args))
-		return NilValue()
+// reduceArray combines the elements of a non-empty numeric array, in order,
+// with the two-argument form of the builtin name
+func reduceArray(name string, arr *ArrayValue, combine BuiltinFunction) (Value, error) {
+	if len(arr.Elements) == 0 {
+		return NilValue(), fmt.Errorf("%s: empty array", name)
 	}
-
-	arg := args[0]
-	var val float64
-
-	switch arg.Type {
-	case IntType:
-		val = float64(arg.AsInt())
-	case FloatType:
-		val = arg.AsFloat()
-	default:
-		fmt.Printf("sqrt: argument must be int or float\n")
-		return NilValue()
+	for _, elem := range arr.Elements {
+		if elem.Type != IntType && elem.Type != FloatType {
+			return NilValue(), fmt.Errorf("%s: array elements must be int or float", name)
+		}
 	}
 
-	if val < 0 {
-		fmt.Printf("sqrt: argument must be non-negative\n")
-		return NilValue()
+	result := arr.Elements[0]
+	for _, elem := range arr.Elements[1:] {
+		var err error
+		if result, err = combine(result, elem); err != nil {
+			return NilValue(), err
+		}
 	}
+	return result, nil
+}
 
-	// Simple Newton-Raphson implementation
-	if val == 0 {
-		return FloatValue(0)
+// sumArray adds up the elements of a numeric array. The sum stays an int
+// until it meets a float.
+func sumArray(name string, arr *ArrayValue) (Value, error) {
+	var intSum int64
+	var floatSum float64
+	isFloat := false
+	for _, elem := range arr.Elements {
+		switch elem.Type {
+		case IntType:
+			if isFloat {
+				floatSum += float64(elem.AsInt())
+			} else {
+				intSum += elem.AsInt()
+			}
+		case FloatType:
+			if !isFloat {
+				floatSum = float64(intSum)
+				isFloat = true
+			}
+			floatSum += elem.AsFloat()
+		default:
+			return NilValue(), fmt.Errorf("%s: array elements must be int or float", name)
+		}
 	}
 
-	x := val
-	for i := 0; i < 20; i++ {
-		x = (x + val/x) / 2
+	if isFloat {
+		return FloatValue(floatSum), nil
 	}
-
-	return FloatValue(x)
+	return IntValue(intSum), nil
 }
 
-// powBuiltin implements pow(base, exp) - power
-func powBuiltin(args ...Value) Value {
-	if len(args) != 2 {
-		fmt.Printf("pow: wrong number of arguments. got=%d, want=2\n", len(args))
-		return NilValue()
+// sumBuiltin implements sum(arr) - the sum of an array's elements, 0 for an
+// empty array
+func (env *builtinEnv) sumBuiltin(args ...Value) (Value, error) {
+	if len(args) != 1 {
+		return NilValue(), fmt.Errorf("sum: wrong number of arguments. got=%d, want=1", len(args))
+	}
+	if args[0].Type != ArrayType {
+		return NilValue(), fmt.Errorf("sum: argument must be an array")
 	}
 
-	base, exp := args[0], args[1]
-
-	var baseFloat, expFloat float64
+	return sumArray("sum", args[0].AsArray())
+}
 
-	// Convert base
-	switch base.Type {
-	case IntType:
-		baseFloat = float64(base.AsInt())
-	case FloatType:
-		baseFloat = base.AsFloat()
-	default:
-		fmt.Printf("pow: base must be int or float\n")
-		return NilValue()
+// avgBuiltin implements avg(arr) - the mean of a non-empty array's elements,
+// always a float
+func (env *builtinEnv) avgBuiltin(args ...Value) (Value, error) {
+	if len(args) != 1 {
+		return NilValue(), fmt.Errorf("avg: wrong number of arguments. got=%d, want=1", len(args))
 	}
-
-	// Convert exponent
-	switch exp.Type {
-	case IntType:
-		expFloat = float64(exp.AsInt())
-	case FloatType:
-		expFloat = exp.AsFloat()
-	default:
-		fmt.Printf("pow: exponent must be int or float\n")
-		return NilValue()
+	if args[0].Type != ArrayType {
+		return NilValue(), fmt.Errorf("avg: argument must be an array")
 	}
 
-	// Simple power implementation for integer exponents
-	if expFloat == float64(int64(expFloat)) && expFloat >= 0 {
-		result := 1.0
-		expInt := int64(expFloat)
-		for i := int64(0); i < expInt; i++ {
-			result *= baseFloat
-		}
-		return FloatValue(result)
+	arr := args[0].AsArray()
+	if len(arr.Elements) == 0 {
+		return NilValue(), fmt.Errorf("avg: empty array")
+	}
+	total, err := sumArray("avg", arr)
+	if err != nil {
+		return NilValue(), err
 	}
 
-	// For non-integer or negative exponents, we'd need a full math library
-	// For now, just handle simple cases
-	fmt.Printf("pow: only non-negative integer exponents are supported\n")
-	return NilValue()
+	count := float64(len(arr.Elements))
+	if total.Type == IntType {
+		return FloatValue(float64(total.AsInt()) / count), nil
+	}
+	return FloatValue(total.AsFloat() / count), nil
 }
 
-// floorBuiltin implements floor(n) - round down
-func floorBuiltin(args ...Value) Value {
+// sqrtBuiltin implements sqrt(n) - square root
+func (env *builtinEnv) sqrtBuiltin(args ...Value) (Value, error) {
 	if len(args) != 1 {
-		fmt.Printf("floor: wrong number of arguments. got=%d, want=1\n", len(args))
-		return NilValue()
+		return NilValue(), fmt.Errorf("sqrt: wrong number of arguments. got=%d, want=1", len(args))
 	}
 
-	arg := args[0]
-	var val float64
-
-	switch arg.Type {
-	case IntType:
-		return arg // Already an integer
-	case FloatType:
-		val = arg.AsFloat()
-	default:
-		fmt.Printf("floor: argument must be int or float\n")
-		return NilValue()
+	val, ok := numericAsFloat(args[0])
+	if !ok {
+		return NilValue(), fmt.Errorf("sqrt: argument must be int or float")
 	}
+	return squareRoot(val)
+}
 
-	// Manual floor implementation
-	intVal := int64(val)
-	if val < 0 && val != float64(intVal) {
-		intVal--
+// squareRoot computes sqrt for the builtin and the opcodes calls to it are
+// lowered to
+func squareRoot(val float64) (Value, error) {
+	if val < 0 {
+		return NilValue(), fmt.Errorf("sqrt: argument must be non-negative")
 	}
-
-	return IntValue(intVal)
+	return FloatValue(math.Sqrt(val)), nil
 }
 
-// ceilBuiltin implements ceil(n) - round up
-func ceilBuiltin(args ...Value) Value {
-	if len(args) != 1 {
-		fmt.Printf("ceil: wrong number of arguments. got=%d, want=1\n", len(args))
-		return NilValue()
+// powBuiltin implements pow(base, exp) - power
+func (env *builtinEnv) powBuiltin(args ...Value) (Value, error) {
+	if len(args) != 2 {
+		return NilValue(), fmt.Errorf("pow: wrong number of arguments. got=%d, want=2", len(args))
 	}
 
-	arg := args[0]
-	var val float64
-
-	switch arg.Type {
-	case IntType:
-		return arg // Already an integer
-	case FloatType:
-		val = arg.AsFloat()
-	default:
-		fmt.Printf("ceil: argument must be int or float\n")
-		return NilValue()
+	base, ok := numericAsFloat(args[0])
+	if !ok {
+		return NilValue(), fmt.Errorf("pow: base must be int or float")
 	}
-
-	// Manual ceil implementation
-	intVal := int64(val)
-	if val > 0 && val != float64(intVal) {
-		intVal++
+	exp, ok := numericAsFloat(args[1])
+	if !ok {
+		return NilValue(), fmt.Errorf("pow: exponent must be int or float")
 	}
+	return FloatValue(math.Pow(base, exp)), nil
+}
+
+// floorBuiltin implements floor(n) - round down
+func (env *builtinEnv) floorBuiltin(args ...Value) (Value, error) {
+	return roundToInt("floor", math.Floor, args)
+}
 
-	return IntValue(intVal)
+// ceilBuiltin implements ceil(n) - round up
+func (env *builtinEnv) ceilBuiltin(args ...Value) (Value, error) {
+	return roundToInt("ceil", math.Ceil, args)
 }
 
 // splitBuiltin implements split(str, separator) - split string into array
-func splitBuiltin(args ...Value) Value {
+func (env *builtinEnv) splitBuiltin(args ...Value) (Value, error) {
 	if len(args) != 2 {
-		fmt.Printf("split: wrong number of arguments. got=%d, want=2\n", len(args))
-		return NilValue()
+		return NilValue(), fmt.Errorf("split: wrong number of arguments. got=%d, want=2", len(args))
 	}
 
 	str := args[0]
 	sep := args[1]
 
 	if str.Type != StringType {
-		fmt.Printf("split: first argument must be string\n")
-		return NilValue()
+		return NilValue(), fmt.Errorf("split: first argument must be string")
 	}
 
 	if sep.Type != StringType {
-		fmt.Printf("split: second argument must be string\n")
-		return NilValue()
+		return NilValue(), fmt.Errorf("split: second argument must be string")
 	}
 
 	strVal := str.AsString()
@@ -572,17 +1119,7 @@ func splitBuiltin(args ...Value) Value {
 
 	if sepVal == "" {
 		// Split into individual characters
-		elements := make([]Value, len(strVal))
-		for i, ch := range strVal {
-			elements[i] = StringValue(string(ch))
-		}
-
-		arr := &ArrayValue{Elements: elements}
-		arrayPool = append(arrayPool, arr)
-		return Value{
-			Type: ArrayType,
-			Data: uint64(uintptr(unsafe.Pointer(arr))),
-		}
+		return runeArray(strVal), nil
 	}
 
 	// Split by separator
@@ -599,19 +1136,13 @@ func splitBuiltin(args ...Value) Value {
 	}
 	elements = append(elements, StringValue(strVal[start:]))
 
-	arr := &ArrayValue{Elements: elements}
-	arrayPool = append(arrayPool, arr)
-	return Value{
-		Type: ArrayType,
-		Data: uint64(uintptr(unsafe.Pointer(arr))),
-	}
+	return NewArrayFromElements(elements), nil
 }
 
 // substringBuiltin implements substring(str, start, end) - get substring
-func substringBuiltin(args ...Value) Value {
+func (env *builtinEnv) substringBuiltin(args ...Value) (Value, error) {
 	if len(args) != 3 {
-		fmt.Printf("substring: wrong number of arguments. got=%d, want=3\n", len(args))
-		return NilValue()
+		return NilValue(), fmt.Errorf("substring: wrong number of arguments. got=%d, want=3", len(args))
 	}
 
 	str := args[0]
@@ -619,18 +1150,15 @@ func substringBuiltin(args ...Value) Value {
 	end := args[2]
 
 	if str.Type != StringType {
-		fmt.Printf("substring: first argument must be string\n")
-		return NilValue()
+		return NilValue(), fmt.Errorf("substring: first argument must be string")
 	}
 
 	if start.Type != IntType {
-		fmt.Printf("substring: second argument must be int\n")
-		return NilValue()
+		return NilValue(), fmt.Errorf("substring: second argument must be int")
 	}
 
 	if end.Type != IntType {
-		fmt.Printf("substring: third argument must be int\n")
-		return NilValue()
+		return NilValue(), fmt.Errorf("substring: third argument must be int")
 	}
 
 	strVal := str.AsString()
@@ -648,188 +1176,407 @@ func substringBuiltin(args ...Value) Value {
 		startIdx = endIdx
 	}
 
-	return StringValue(strVal[startIdx:endIdx])
+	return StringValue(strVal[startIdx:endIdx]), nil
+}
+
+// Strings are sequences of bytes: len counts bytes and s[i] is the byte at
+// i, which is a whole character only in ASCII text. runes and bytes split a
+// string into its code points or its bytes, so a program can count and walk
+// the characters of any UTF-8 text.
+
+// runesBuiltin implements runes(str) - the code points of str, each as a
+// string. A byte that isn't part of valid UTF-8 becomes U+FFFD.
+func (env *builtinEnv) runesBuiltin(args ...Value) (Value, error) {
+	if len(args) != 1 {
+		return NilValue(), fmt.Errorf("runes: wrong number of arguments. got=%d, want=1", len(args))
+	}
+	if args[0].Type != StringType {
+		return NilValue(), fmt.Errorf("runes: argument must be string")
+	}
+	return runeArray(args[0].AsString()), nil
+}
+
+// runeArray returns the code points of str, each as a string
+func runeArray(str string) Value {
+	elements := make([]Value, 0, utf8.RuneCountInString(str))
+	for _, ch := range str {
+		elements = append(elements, StringValue(string(ch)))
+	}
+	return NewArrayFromElements(elements)
+}
+
+// bytesBuiltin implements bytes(str) - the bytes of str as ints from 0 to 255
+func (env *builtinEnv) bytesBuiltin(args ...Value) (Value, error) {
+	if len(args) != 1 {
+		return NilValue(), fmt.Errorf("bytes: wrong number of arguments. got=%d, want=1", len(args))
+	}
+	if args[0].Type != StringType {
+		return NilValue(), fmt.Errorf("bytes: argument must be string")
+	}
+
+	str := args[0].AsString()
+	elements := make([]Value, len(str))
+	for i := 0; i < len(str); i++ {
+		elements[i] = IntValue(int64(str[i]))
+	}
+	return NewArrayFromElements(elements), nil
+}
+
+// validUTF8Builtin implements validUTF8(str) - whether str is valid UTF-8,
+// as it is unless built from parts of characters, like s[i] of non-ASCII text
+func (env *builtinEnv) validUTF8Builtin(args ...Value) (Value, error) {
+	if len(args) != 1 {
+		return NilValue(), fmt.Errorf("validUTF8: wrong number of arguments. got=%d, want=1", len(args))
+	}
+	if args[0].Type != StringType {
+		return NilValue(), fmt.Errorf("validUTF8: argument must be string")
+	}
+	return BoolValue(utf8.ValidString(args[0].AsString())), nil
+}
+
+// ordBuiltin implements ord(str) - the code point of the one character str,
+// or the value of its byte if it is one byte, as s[i] is
+func (env *builtinEnv) ordBuiltin(args ...Value) (Value, error) {
+	if len(args) != 1 {
+		return NilValue(), fmt.Errorf("ord: wrong number of arguments. got=%d, want=1", len(args))
+	}
+	if args[0].Type != StringType {
+		return NilValue(), fmt.Errorf("ord: argument must be string")
+	}
+	str := args[0].AsString()
+	if len(str) == 1 {
+		return IntValue(int64(str[0])), nil
+	}
+	r, size := utf8.DecodeRuneInString(str)
+	if r == utf8.RuneError || size != len(str) {
+		return NilValue(), fmt.Errorf("ord: expected one character, got %q", str)
+	}
+	return IntValue(int64(r)), nil
+}
+
+// chrBuiltin implements chr(n) - the one character string of the code point n
+func (env *builtinEnv) chrBuiltin(args ...Value) (Value, error) {
+	if len(args) != 1 {
+		return NilValue(), fmt.Errorf("chr: wrong number of arguments. got=%d, want=1", len(args))
+	}
+	if args[0].Type != IntType {
+		return NilValue(), fmt.Errorf("chr: argument must be int")
+	}
+	n := args[0].AsInt()
+	if n < 0 || n > utf8.MaxRune || !utf8.ValidRune(rune(n)) {
+		return NilValue(), fmt.Errorf("chr: %d is not a code point", n)
+	}
+	return StringValue(string(rune(n))), nil
 }
 
 // intBuiltin implements int(x) - convert to int
-func intBuiltin(args ...Value) Value {
+func (env *builtinEnv) intBuiltin(args ...Value) (Value, error) {
 	if len(args) != 1 {
-		fmt.Printf("int: wrong number of arguments. got=%d, want=1\n", len(args))
-		return NilValue()
+		return NilValue(), fmt.Errorf("int: wrong number of arguments. got=%d, want=1", len(args))
 	}
 
 	arg := args[0]
 
 	switch arg.Type {
 	case IntType:
-		return arg
+		return arg, nil
 	case FloatType:
-		return IntValue(int64(arg.AsFloat()))
+		return IntValue(int64(arg.AsFloat())), nil
 	case BoolType:
 		if arg.AsBool() {
-			return IntValue(1)
+			return IntValue(1), nil
 		}
-		return IntValue(0)
+		return IntValue(0), nil
 	case StringType:
-		// Simple integer parsing
-		str := arg.AsString()
-		if str == "" {
-			return IntValue(0)
-		}
-
-		var result int64
-		var negative bool
-		start := 0
-
-		if str[0] == '-' {
-			negative = true
-			start = 1
-		}
-
-		for i := start; i < len(str); i++ {
-			if str[i] < '0' || str[i] > '9' {
-				fmt.Printf("int: invalid integer string '%s'\n", str)
-				return NilValue()
-			}
-			result = result*10 + int64(str[i]-'0')
-		}
-
-		if negative {
-			result = -result
-		}
-
-		return IntValue(result)
+		return parseIntString("int", arg.AsString(), 10)
 	default:
-		fmt.Printf("int: cannot convert type to int\n")
-		return NilValue()
+		return NilValue(), fmt.Errorf("int: cannot convert type to int")
 	}
 }
 
 // floatBuiltin implements float(x) - convert to float
-func floatBuiltin(args ...Value) Value {
+func (env *builtinEnv) floatBuiltin(args ...Value) (Value, error) {
 	if len(args) != 1 {
-		fmt.Printf("float: wrong number of arguments. got=%d, want=1\n", len(args))
-		return NilValue()
+		return NilValue(), fmt.Errorf("float: wrong number of arguments. got=%d, want=1", len(args))
 	}
 
 	arg := args[0]
 
 	switch arg.Type {
 	case FloatType:
-		return arg
+		return arg, nil
 	case IntType:
-		return FloatValue(float64(arg.AsInt()))
+		return FloatValue(float64(arg.AsInt())), nil
 	case BoolType:
 		if arg.AsBool() {
-			return FloatValue(1.0)
+			return FloatValue(1.0), nil
 		}
-		return FloatValue(0.0)
+		return FloatValue(0.0), nil
 	case StringType:
-		// Simple float parsing
-		str := arg.AsString()
-		if str == "" {
-			return FloatValue(0.0)
-		}
+		return parseFloatString("float", arg.AsString())
+	default:
+		return NilValue(), fmt.Errorf("float: cannot convert type to float")
+	}
+}
 
-		var result float64
-		var negative bool
-		var afterDecimal bool
-		var decimalPlaces float64 = 1
-		start := 0
+// parseIntString parses str, ignoring surrounding whitespace, as an integer
+// in base for the builtin name. An empty string is 0.
+func parseIntString(name, str string, base int) (Value, error) {
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return IntValue(0), nil
+	}
 
-		if str[0] == '-' {
-			negative = true
-			start = 1
-		}
+	result, err := strconv.ParseInt(str, base, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		return NilValue(), fmt.Errorf("%s: integer string '%s' out of range", name, str)
+	}
+	if err != nil {
+		return NilValue(), fmt.Errorf("%s: invalid integer string '%s'", name, str)
+	}
+	return IntValue(result), nil
+}
 
-		for i := start; i < len(str); i++ {
-			if str[i] == '.' {
-				if afterDecimal {
-					fmt.Printf("float: invalid float string '%s'\n", str)
-					return NilValue()
-				}
-				afterDecimal = true
-				continue
-			}
+// parseFloatString parses str, ignoring surrounding whitespace, as a float for
+// the builtin name. An empty string is 0.
+func parseFloatString(name, str string) (Value, error) {
+	str = strings.TrimSpace(str)
+	if str == "" {
+		return FloatValue(0.0), nil
+	}
 
-			if str[i] < '0' || str[i] > '9' {
-				fmt.Printf("float: invalid float string '%s'\n", str)
-				return NilValue()
-			}
+	result, err := strconv.ParseFloat(str, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		return NilValue(), fmt.Errorf("%s: float string '%s' out of range", name, str)
+	}
+	if err != nil {
+		return NilValue(), fmt.Errorf("%s: invalid float string '%s'", name, str)
+	}
+	return FloatValue(result), nil
+}
 
-			if afterDecimal {
-				decimalPlaces *= 10
-				result += float64(str[i]-'0') / decimalPlaces
-			} else {
-				result = result*10 + float64(str[i]-'0')
-			}
+// parseIntBuiltin implements parseInt(s, base). Base 0 takes the base from a
+// 0b, 0o or 0x prefix and is otherwise decimal.
+func (env *builtinEnv) parseIntBuiltin(args ...Value) (Value, error) {
+	if len(args) != 2 {
+		return NilValue(), fmt.Errorf("parseInt: wrong number of arguments. got=%d, want=2", len(args))
+	}
+
+	if args[0].Type != StringType {
+		return NilValue(), fmt.Errorf("parseInt: first argument must be string")
+	}
+	if args[1].Type != IntType {
+		return NilValue(), fmt.Errorf("parseInt: second argument must be int")
+	}
+
+	base := args[1].AsInt()
+	if base != 0 && (base < 2 || base > 36) {
+		return NilValue(), fmt.Errorf("parseInt: invalid base %d", base)
+	}
+	return parseIntString("parseInt", args[0].AsString(), int(base))
+}
+
+// formatIntBuiltin implements formatInt(n, base), with lowercase letters for
+// digits above 9
+func (env *builtinEnv) formatIntBuiltin(args ...Value) (Value, error) {
+	if len(args) != 2 {
+		return NilValue(), fmt.Errorf("formatInt: wrong number of arguments. got=%d, want=2", len(args))
+	}
+
+	if args[0].Type != IntType {
+		return NilValue(), fmt.Errorf("formatInt: first argument must be int")
+	}
+	if args[1].Type != IntType {
+		return NilValue(), fmt.Errorf("formatInt: second argument must be int")
+	}
+
+	base := args[1].AsInt()
+	if base < 2 || base > 36 {
+		return NilValue(), fmt.Errorf("formatInt: invalid base %d", base)
+	}
+	return StringValue(strconv.FormatInt(args[0].AsInt(), int(base))), nil
+}
+
+// formatNumberBuiltin implements formatNumber(x, decimals, thousandsSep). It
+// writes x with exactly decimals digits after a "." and thousandsSep between
+// each group of three digits before it, whatever the host's locale. Ints are
+// formatted exactly; floats are rounded to the nearest value with that many
+// decimals.
+func (env *builtinEnv) formatNumberBuiltin(args ...Value) (Value, error) {
+	if len(args) != 3 {
+		return NilValue(), fmt.Errorf("formatNumber: wrong number of arguments. got=%d, want=3", len(args))
+	}
+
+	if args[1].Type != IntType {
+		return NilValue(), fmt.Errorf("formatNumber: second argument must be int")
+	}
+	if args[2].Type != StringType {
+		return NilValue(), fmt.Errorf("formatNumber: third argument must be string")
+	}
+	decimals := args[1].AsInt()
+	if decimals < 0 {
+		return NilValue(), fmt.Errorf("formatNumber: decimals must be non-negative, got %d", decimals)
+	}
+
+	var digits string
+	switch args[0].Type {
+	case IntType:
+		digits = strconv.FormatInt(args[0].AsInt(), 10)
+		if decimals > 0 {
+			digits += "." + strings.Repeat("0", int(decimals))
+		}
+	case FloatType:
+		val := args[0].AsFloat()
+		if math.IsInf(val, 0) || math.IsNaN(val) {
+			return StringValue(args[0].String()), nil
 		}
+		digits = strconv.FormatFloat(val, 'f', int(decimals), 64)
+	default:
+		return NilValue(), fmt.Errorf("formatNumber: first argument must be int or float")
+	}
+	return StringValue(groupThousands(digits, args[2].AsString())), nil
+}
 
-		if negative {
-			result = -result
+// groupThousands puts sep between each group of three digits in the integer
+// part of number, a decimal number as strconv writes it
+func groupThousands(number, sep string) string {
+	if sep == "" {
+		return number
+	}
+
+	sign := ""
+	if strings.HasPrefix(number, "-") {
+		sign, number = "-", number[1:]
+	}
+	whole, fraction := number, ""
+	if dot := strings.IndexByte(number, '.'); dot >= 0 {
+		whole, fraction = number[:dot], number[dot:]
+	}
+
+	var out strings.Builder
+	out.WriteString(sign)
+	for i, digit := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			out.WriteString(sep)
 		}
+		out.WriteRune(digit)
+	}
+	out.WriteString(fraction)
+	return out.String()
+}
 
-		return FloatValue(result)
-	default:
-		fmt.Printf("float: cannot convert type to float\n")
-		return NilValue()
+// parseFloatBuiltin implements parseFloat(s)
+func (env *builtinEnv) parseFloatBuiltin(args ...Value) (Value, error) {
+	if len(args) != 1 {
+		return NilValue(), fmt.Errorf("parseFloat: wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	if args[0].Type != StringType {
+		return NilValue(), fmt.Errorf("parseFloat: argument must be string")
 	}
+	return parseFloatString("parseFloat", args[0].AsString())
 }
 
 // stringBuiltin implements string(x) - convert to string
-func stringBuiltin(args ...Value) Value {
+func (env *builtinEnv) stringBuiltin(args ...Value) (Value, error) {
 	if len(args) != 1 {
-		fmt.Printf("string: wrong number of arguments. got=%d, want=1\n", len(args))
-		return NilValue()
+		return NilValue(), fmt.Errorf("string: wrong number of arguments. got=%d, want=1", len(args))
 	}
 
 	// Just use the existing String() method
-	return StringValue(args[0].String())
+	return StringValue(args[0].String()), nil
 }
 
-// Cached builtin Values to avoid recreating them and growing the pool unnecessarily
-var builtinValueCache []Value
+// builderBuiltin implements the builder function, returning an empty string
+// builder
+func (env *builtinEnv) builderBuiltin(args ...Value) (Value, error) {
+	if len(args) != 0 {
+		return NilValue(), fmt.Errorf("builder: wrong number of arguments. got=%d, want=0", len(args))
+	}
 
-// initBuiltinCache initializes the builtin value cache
-func init() {
-	// Pre-allocate the pool with exact capacity to avoid reallocation
-	// This is critical: if the pool reallocates, pointers in builtinValueCache become invalid!
-	builtinFunctionPool = make([]interface{}, 0, len(Builtins))
-	builtinValueCache = make([]Value, len(Builtins))
+	return NewBuilderValue(), nil
+}
 
-	for i := range Builtins {
-		fn := Builtins[i]
-		// Add to pool to prevent garbage collection
-		builtinFunctionPool = append(builtinFunctionPool, fn)
-		fnPtr := &builtinFunctionPool[i]  // Use index instead of len-1
+// addBuiltin implements the add function, appending a value to a builder in
+// place as print would show it. It returns the builder.
+func (env *builtinEnv) addBuiltin(args ...Value) (Value, error) {
+	if len(args) != 2 {
+		return NilValue(), fmt.Errorf("add: wrong number of arguments. got=%d, want=2", len(args))
+	}
 
-		builtinValueCache[i] = Value{
-			Type: BuiltinFunctionType,
-			Data: uint64(uintptr(unsafe.Pointer(fnPtr))),
-		}
+	builderVal := args[0]
+	if builderVal.Type != BuilderType {
+		return NilValue(), fmt.Errorf("add: first argument must be a builder")
 	}
+
+	builderVal.AsBuilder().WriteString(args[1].String())
+	return builderVal, nil
+}
+
+// buildBuiltin implements the build function, returning a builder's contents
+// as a string. The builder can still be added to afterwards.
+func (env *builtinEnv) buildBuiltin(args ...Value) (Value, error) {
+	if len(args) != 1 {
+		return NilValue(), fmt.Errorf("build: wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	builderVal := args[0]
+	if builderVal.Type != BuilderType {
+		return NilValue(), fmt.Errorf("build: argument must be a builder")
+	}
+
+	return StringValue(builderVal.AsBuilder().String()), nil
+}
+
+// Cached builtin Values to avoid allocating a new one on every lookup
+var builtinValueCache = builtinValues(Builtins)
+
+// builtinValues wraps each builtin function as a Value
+func builtinValues(fns []BuiltinFunction) []Value {
+	values := make([]Value, len(fns))
+	for i, fn := range fns {
+		values[i] = NewBuiltinFunctionValue(fn)
+	}
+	return values
 }
 
 // getBuiltin returns a built-in function as a Value
 func (vm *VM) getBuiltin(index int) Value {
-	if index < 0 || index >= len(Builtins) {
+	if index < 0 || index >= len(vm.builtins) {
 		return NilValue()
 	}
 
 	// Return the cached value instead of creating a new one each time
-	return builtinValueCache[index]
+	return vm.builtins[index]
 }
 
-// executeBuiltin executes a built-in function
-func (vm *VM) executeBuiltin(fn BuiltinFunction, numArgs int) error {
-	args := make([]Value, numArgs)
-	for i := numArgs - 1; i >= 0; i-- {
-		args[i] = vm.pop()
-	}
+// BuiltinValues returns the builtins as Values, indexed like BuiltinNames,
+// bound to enums and writing to os.Stdout unless WithStdout says otherwise.
+// Output is not buffered. enums may gain definitions after the call. The
+// tree-walking interpreter uses it to call the same builtins as the VMs.
+func BuiltinValues(enums Enums, opts ...Option) []Value {
+	if enums == nil && len(opts) == 0 {
+		return builtinValueCache
+	}
+	c := newConfig(opts)
+	env := &builtinEnv{stdout: c.stdout, enums: enums, call: c.call, stdin: c.stdin, args: c.args}
+	return builtinValues(env.functions())
+}
 
-	// Pop the function itself
-	vm.pop()
+// executeBuiltin executes a built-in function called through OpCall, with the
+// function value below its arguments on the stack
+func (vm *VM) executeBuiltin(fn BuiltinFunction, numArgs int) error {
+	return vm.callBuiltin(fn, numArgs, 1)
+}
 
-	result := fn(args...)
+// callBuiltin calls fn with the top numArgs stack values, then replaces them
+// (and extra slots below them) with the result. The arguments are passed as
+// a view of the stack without copying.
+func (vm *VM) callBuiltin(fn BuiltinFunction, numArgs, extra int) error {
+	result, err := fn(vm.stack[vm.sp-numArgs : vm.sp]...)
+	if err != nil {
+		return err
+	}
+	vm.sp -= numArgs + extra
 	return vm.push(result)
 }
@@ -0,0 +1,26 @@
+package vm
+
+import (
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestBuiltinNamesMatchFunctions checks that coreBuiltinNames names the
+// functions at the same indexes, which compiled code calls builtins by
+func TestBuiltinNamesMatchFunctions(t *testing.T) {
+	all := defaultBuiltinEnv.functions()
+	functions := all[:len(all)-len(hostBuiltins)]
+	if len(coreBuiltinNames) != len(functions) {
+		t.Fatalf("%d builtin names for %d builtins", len(coreBuiltinNames), len(functions))
+	}
+	for i, fn := range functions {
+		// Method values are named like minlang/vm.(*builtinEnv).lenBuiltin-fm
+		full := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+		method := strings.TrimSuffix(full[strings.LastIndex(full, ".")+1:], "-fm")
+		if want := coreBuiltinNames[i] + "Builtin"; method != want {
+			t.Errorf("builtin %d is named %s but is %s", i, coreBuiltinNames[i], method)
+		}
+	}
+}
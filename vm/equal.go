@@ -0,0 +1,94 @@
+package vm
+
+import "unsafe"
+
+// Equality
+//
+// == compares values deeply: numbers by value, even an int with a float,
+// strings, bools and nil as themselves, and arrays, maps and structs by what
+// they hold, so [1, 2] == [1, 2] and two Points with the same fields are
+// equal whether or not they are the same struct, as are tuples. Structs must also have the
+// same type, and variants the same tag and equal payloads. Functions,
+// closures, builtins and builders are only equal to themselves.
+
+// Equal reports whether a and b are equal the way == compares them
+func Equal(a, b Value) bool {
+	return equality{}.equal(a, b)
+}
+
+// equality holds the pairs of collections being compared, so collections
+// that hold themselves compare without recursing forever
+type equality map[[2]unsafe.Pointer]bool
+
+func (seen equality) equal(a, b Value) bool {
+	if l, lok := numericAsFloat(a); lok {
+		if a.Type == IntType && b.Type == IntType {
+			return a.AsInt() == b.AsInt()
+		}
+		r, rok := numericAsFloat(b)
+		return rok && l == r
+	}
+	if a.Type != b.Type {
+		return false
+	}
+
+	switch a.Type {
+	case StringType:
+		return a.AsString() == b.AsString()
+	case BoolType:
+		return a.AsBool() == b.AsBool()
+	case NilType:
+		return true
+	case ErrorType:
+		return a.ErrorMessage() == b.ErrorMessage()
+	case VariantType:
+		return a.VariantTag() == b.VariantTag() && seen.equal(a.VariantPayload(), b.VariantPayload())
+	case ArrayType, MapType, StructType, TupleType:
+	default:
+		return a == b
+	}
+
+	pair := [2]unsafe.Pointer{a.ptr, b.ptr}
+	if a.ptr == b.ptr || seen[pair] {
+		return true
+	}
+	seen[pair] = true
+
+	switch a.Type {
+	case ArrayType, TupleType:
+		ea, eb := (*ArrayValue)(a.ptr).Elements, (*ArrayValue)(b.ptr).Elements
+		if len(ea) != len(eb) {
+			return false
+		}
+		for i := range ea {
+			if !seen.equal(ea[i], eb[i]) {
+				return false
+			}
+		}
+		return true
+	case MapType:
+		pa, pb := a.AsMap().Pairs, b.AsMap().Pairs
+		if len(pa) != len(pb) {
+			return false
+		}
+		for key, value := range pa {
+			other, ok := pb[key]
+			if !ok || !seen.equal(value, other) {
+				return false
+			}
+		}
+		return true
+	default: // StructType
+		sa, sb := a.AsStruct(), b.AsStruct()
+		if sa.TypeName != sb.TypeName || len(sa.FieldsArray) != len(sb.FieldsArray) {
+			return false
+		}
+		for name, value := range sa.Fields {
+			other, ok := sb.Fields[name]
+			if !ok || !seen.equal(value, other) {
+				return false
+			}
+		}
+		return true
+	}
+}
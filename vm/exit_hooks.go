@@ -0,0 +1,181 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Exit hooks
+//
+// onExit(fn) registers fn to run when the program finishes, so a script can
+// release what it holds however it got there. Hooks take no arguments and run
+// last registered first; one may register another, which runs next. A hook
+// that fails doesn't stop the hooks after it: they all run, and the program
+// ends with the errors of those that failed, joined.
+//
+// The VMs run a hook as a call made from the end of main: the dispatch loop
+// executes its frames like any others and, once it returns, finds main
+// finished again and starts the next one. exit abandons the calls active when
+// it's called and resumes main at its end the same way, so the hooks run
+// after it too.
+
+// ExitHook returns the function an onExit call with args registers, or an
+// error if args aren't a single function taking no arguments
+func ExitHook(args ...Value) (Value, error) {
+	if len(args) != 1 {
+		return NilValue(), fmt.Errorf("onExit: wrong number of arguments. got=%d, want=1", len(args))
+	}
+
+	hook := args[0]
+	var fn *Function
+	switch hook.Type {
+	case FunctionType:
+		fn = hook.AsFunction()
+	case ClosureType:
+		fn = hook.AsClosure().Fn
+	case BuiltinFunctionType:
+		return hook, nil
+	default:
+		return NilValue(), fmt.Errorf("onExit: argument must be a function")
+	}
+	if fn.NumParams != 0 {
+		return NilValue(), fmt.Errorf("onExit: function %s must take no arguments", fn.Name)
+	}
+	return hook, nil
+}
+
+// onExitBuiltin implements onExit(fn)
+func (env *builtinEnv) onExitBuiltin(args ...Value) (Value, error) {
+	hook, err := ExitHook(args...)
+	if err != nil {
+		return NilValue(), err
+	}
+	env.exitHooks = append(env.exitHooks, hook)
+	return NilValue(), nil
+}
+
+// nextExitHook removes and returns the most recently registered exit hook
+func (env *builtinEnv) nextExitHook() (Value, bool) {
+	n := len(env.exitHooks)
+	if n == 0 {
+		return NilValue(), false
+	}
+	hook := env.exitHooks[n-1]
+	env.exitHooks = env.exitHooks[:n-1]
+	return hook, true
+}
+
+// finishExitHooks returns err, the error a run ended with, given its
+// position by annotate, after running the exit hooks a failing one left, if
+// inHooks reports that one failed. resume runs them from the end of main. The
+// errors of the hooks that fail are joined.
+func (env *builtinEnv) finishExitHooks(err error, inHooks func() bool, resume func() error, annotate func(error) error) error {
+	errs := []error{err}
+	for err != nil && inHooks() && len(env.exitHooks) > 0 {
+		if err = annotate(FinishExit(resume(), resume)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return errors.Join(errs...)
+}
+
+// startExitHook calls the next exit hook once main has finished, and reports
+// whether there was one. The program's result, the value last popped, is
+// kept aside while hooks run and put back after the last.
+func (vm *VM) startExitHook() (bool, error) {
+	hook, ok := vm.env.nextExitHook()
+	if !ok {
+		if vm.exiting {
+			vm.sp = vm.exitSP
+			vm.stack[vm.sp] = vm.exitResult
+		}
+		return false, nil
+	}
+
+	if !vm.exiting {
+		vm.exiting = true
+		vm.exitSP, vm.exitResult = vm.sp, vm.stack[vm.sp]
+	}
+	main := vm.frames[0]
+	main.ip = len(main.Instructions())
+	vm.sp = vm.exitSP
+	if err := vm.push(hook); err != nil {
+		return true, err
+	}
+	return true, vm.executeCall(0)
+}
+
+// startExitHook calls the next exit hook once main has finished, and reports
+// whether there was one
+func (vm *RegisterVM) startExitHook() (bool, error) {
+	hook, ok := vm.env.nextExitHook()
+	if !ok {
+		return false, nil
+	}
+
+	vm.exiting = true
+	main := vm.frames[0]
+	main.pc = len(main.instructions)
+	if hook.Type == BuiltinFunctionType {
+		_, err := hook.AsBuiltinFunction()()
+		return true, err
+	}
+	return true, vm.callFunction(hook, 0, -1, 0)
+}
+
+// FinishExit returns the error a program that stopped with err ends with. If
+// exit stopped it, resume runs the exit hooks, which may exit again, and the
+// last exit's status is the result: nil for 0, an *ExitError otherwise.
+// Other errors are returned as they are.
+func FinishExit(err error, resume func() error) error {
+	var exit *ExitError
+	for errors.As(err, &exit) {
+		if err = resume(); err == nil {
+			if exit.Code == 0 {
+				return nil
+			}
+			return exit
+		}
+	}
+	return err
+}
+
+// isExit reports whether err is an exit rather than a failure, which isn't
+// given a position or stack trace
+func isExit(err error) bool {
+	var exit *ExitError
+	return errors.As(err, &exit)
+}
+
+// resumeAfterExit abandons the calls active when exit was called and runs
+// the exit hooks left from the end of main. The program's result is nil.
+func (vm *VM) resumeAfterExit() error {
+	if !vm.exiting {
+		vm.exiting = true
+		vm.exitSP, vm.exitResult = 0, NilValue()
+		vm.result = NilValue()
+	}
+	vm.framesIndex = 1
+	vm.handlers = nil
+	main := vm.frames[0]
+	main.ip = len(main.Instructions())
+	vm.sp = vm.exitSP
+	return vm.execute(0)
+}
+
+// resumeAfterExit abandons the calls active when exit was called and runs
+// the exit hooks left from the end of main. The program's result is nil.
+func (vm *RegisterVM) resumeAfterExit() error {
+	if !vm.exiting && vm.resultReg >= 0 {
+		vm.registers[vm.resultReg] = NilValue()
+	}
+	vm.exiting = true
+	vm.frameIndex = 1
+	vm.handlers = nil
+	vm.currentFrame = vm.frames[0]
+	vm.currentFrame.pc = len(vm.currentFrame.instructions)
+	return vm.execute(0)
+}
@@ -0,0 +1,145 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Formatting
+//
+// format(f, args...) is f with each verb replaced by the next argument,
+// formatted by Go's fmt, and printf(f, args...) prints it as a line, the way
+// print would. Verbs take the flags, width and precision Go's do, as in %-8s or
+// %08.3f, and each checks the type of its argument:
+//
+//	%d %b %o %x %X %c   int (%x and %X also take a string)
+//	%f %F %e %E %g %G   float or int
+//	%t                  bool
+//	%q                  string
+//	%s %v               anything, shown the way print shows it
+//
+// %% is a percent sign. An argument missing for a verb, one left over, or one
+// of the wrong type is an error rather than the notes Go writes into the
+// output.
+
+// sprintf returns format with its verbs replaced by args, for the builtin
+// name
+func sprintf(name, format string, args []Value) (string, error) {
+	var out strings.Builder
+	next := 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			out.WriteByte(format[i])
+			continue
+		}
+
+		start := i
+		i++
+		for i < len(format) && strings.IndexByte("+-# 0", format[i]) >= 0 {
+			i++
+		}
+		for i < len(format) && (isDigit(format[i]) || format[i] == '.') {
+			i++
+		}
+		if i == len(format) {
+			return "", fmt.Errorf("%s: format ends in the middle of a verb", name)
+		}
+		verb := format[i]
+		if verb == '%' && i == start+1 {
+			out.WriteByte('%')
+			continue
+		}
+
+		if next == len(args) {
+			return "", fmt.Errorf("%s: no argument for %%%c", name, verb)
+		}
+		arg, err := formatArg(name, verb, args[next])
+		if err != nil {
+			return "", err
+		}
+		next++
+		fmt.Fprintf(&out, format[start:i+1], arg)
+	}
+	if next < len(args) {
+		return "", fmt.Errorf("%s: more arguments than verbs (%d for %d)", name, len(args), next)
+	}
+	return out.String(), nil
+}
+
+// formatArg returns arg as the Go value verb formats, or an error if verb
+// doesn't take its type
+func formatArg(name string, verb byte, arg Value) (interface{}, error) {
+	want := ""
+	switch verb {
+	case 'd', 'b', 'o', 'c':
+		if arg.Type == IntType {
+			return arg.AsInt(), nil
+		}
+		want = "an int"
+	case 'x', 'X':
+		switch arg.Type {
+		case IntType:
+			return arg.AsInt(), nil
+		case StringType:
+			return arg.AsString(), nil
+		}
+		want = "an int or string"
+	case 'f', 'F', 'e', 'E', 'g', 'G':
+		switch arg.Type {
+		case FloatType:
+			return arg.AsFloat(), nil
+		case IntType:
+			return float64(arg.AsInt()), nil
+		}
+		want = "a number"
+	case 't':
+		if arg.Type == BoolType {
+			return arg.AsBool(), nil
+		}
+		want = "a bool"
+	case 'q':
+		if arg.Type == StringType {
+			return arg.AsString(), nil
+		}
+		want = "a string"
+	case 's', 'v':
+		return arg.String(), nil
+	default:
+		return nil, fmt.Errorf("%s: unknown verb %%%c", name, verb)
+	}
+	return nil, fmt.Errorf("%s: %%%c needs %s, got %s", name, verb, want, arg.Type)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// formatBuiltin implements format(f, args...), see sprintf
+func (env *builtinEnv) formatBuiltin(args ...Value) (Value, error) {
+	s, err := formatArgs("format", args)
+	if err != nil {
+		return NilValue(), err
+	}
+	return StringValue(s), nil
+}
+
+// printfBuiltin implements printf(f, args...), printing format(f, args...)
+func (env *builtinEnv) printfBuiltin(args ...Value) (Value, error) {
+	s, err := formatArgs("printf", args)
+	if err != nil {
+		return NilValue(), err
+	}
+	return env.printBuiltin(StringValue(s))
+}
+
+// formatArgs formats the arguments of the builtin name: a format string and
+// the values for its verbs
+func formatArgs(name string, args []Value) (string, error) {
+	if len(args) < 1 {
+		return "", fmt.Errorf("%s: wrong number of arguments. got=%d, want=1+", name, len(args))
+	}
+	if args[0].Type != StringType {
+		return "", fmt.Errorf("%s: first argument must be a format string", name)
+	}
+	return sprintf(name, args[0].AsString(), args[1:])
+}
@@ -0,0 +1,55 @@
+package vm
+
+import "testing"
+
+func TestSprintf(t *testing.T) {
+	tests := []struct {
+		format string
+		args   []Value
+		want   string
+	}{
+		{"x=%d y=%.2f", []Value{IntValue(42), FloatValue(3.14159)}, "x=42 y=3.14"},
+		{"%5d|%-5d|%05d", []Value{IntValue(7), IntValue(7), IntValue(-7)}, "    7|7    |-0007"},
+		{"%.1f %e", []Value{IntValue(2), FloatValue(1500)}, "2.0 1.500000e+03"},
+		{"%x %X %o %b %c", []Value{IntValue(255), StringValue("hi"), IntValue(8), IntValue(5), IntValue('A')}, "ff 6869 10 101 A"},
+		{"%t %q", []Value{BoolValue(false), StringValue(`say "hi"`)}, `false "say \"hi\""`},
+		{"%s and %v", []Value{IntValue(1), FloatValue(0.5)}, "1 and 0.500000"},
+		{"%-4s|%4s|", []Value{StringValue("ab"), StringValue("cd")}, "ab  |  cd|"},
+		{"100%%", nil, "100%"},
+		{"no verbs", nil, "no verbs"},
+	}
+
+	for _, tt := range tests {
+		got, err := sprintf("format", tt.format, tt.args)
+		if err != nil {
+			t.Errorf("format(%q): %v", tt.format, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("format(%q) = %q, want %q", tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestSprintfErrors(t *testing.T) {
+	tests := []struct {
+		format string
+		args   []Value
+		want   string
+	}{
+		{"%d", []Value{StringValue("1")}, "format: %d needs an int, got string"},
+		{"%f", []Value{BoolValue(true)}, "format: %f needs a number, got bool"},
+		{"%t", []Value{IntValue(1)}, "format: %t needs a bool, got int"},
+		{"%d %d", []Value{IntValue(1)}, "format: no argument for %d"},
+		{"%d", []Value{IntValue(1), IntValue(2)}, "format: more arguments than verbs (2 for 1)"},
+		{"%y", []Value{IntValue(1)}, "format: unknown verb %y"},
+		{"50%", nil, "format: format ends in the middle of a verb"},
+	}
+
+	for _, tt := range tests {
+		_, err := sprintf("format", tt.format, tt.args)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("format(%q): got error %v, want %q", tt.format, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package vm
+
+import "encoding/binary"
+
+// Global slot compaction
+//
+// The compiler gives every global its own slot, numbered in declaration
+// order, and the VMs would otherwise allocate GlobalsSize slots for any
+// program. Compaction renumbers the globals the program loads so they take
+// the first slots, in their original order, and gives every global that is
+// only ever stored to one shared slot after them. Stores to it still run, so
+// the values they store are computed as before, but nothing reads it back.
+// The bytecode's Globals then says how many slots the VMs need.
+
+// globalsSize returns the number of global slots to allocate for a program
+// whose bytecode says it uses n
+func globalsSize(n int) int {
+	if n == 0 {
+		return GlobalsSize
+	}
+	return n
+}
+
+// CompactGlobals returns a copy of bytecode with its global slots compacted.
+// The input is not modified.
+func CompactGlobals(bytecode *Bytecode) *Bytecode {
+	code := [][]byte{bytecode.Instructions}
+	for _, constant := range bytecode.Constants {
+		if constant.Type == FunctionType {
+			code = append(code, constant.AsFunction().Instructions)
+		}
+	}
+
+	loaded := make(map[int]bool)
+	for _, ins := range code {
+		for _, in := range decodeStackInstructions(ins) {
+			switch in.op {
+			case OpLoadGlobal, OpIncGlobal, OpDecGlobal:
+				loaded[in.operands[0]] = true
+			}
+		}
+	}
+	slots, count := globalSlots(loaded)
+
+	constants := make([]Value, len(bytecode.Constants))
+	for i, constant := range bytecode.Constants {
+		if constant.Type == FunctionType {
+			fn := *constant.AsFunction()
+			fn.Instructions = renumberGlobals(fn.Instructions, slots)
+			constant = NewFunctionValue(&fn)
+		}
+		constants[i] = constant
+	}
+
+	compacted := *bytecode
+	compacted.Instructions = renumberGlobals(bytecode.Instructions, slots)
+	compacted.Constants = constants
+	compacted.Globals = count
+	return &compacted
+}
+
+// renumberGlobals returns a copy of ins with each global operand replaced by
+// its slot
+func renumberGlobals(ins []byte, slots func(int) int) []byte {
+	renumbered := append([]byte(nil), ins...)
+	for _, in := range decodeStackInstructions(ins) {
+		switch in.op {
+		case OpLoadGlobal, OpStoreGlobal, OpIncGlobal, OpDecGlobal:
+			binary.BigEndian.PutUint16(renumbered[in.ip+1:], uint16(slots(in.operands[0])))
+		}
+	}
+	return renumbered
+}
+
+// CompactRegisterGlobals returns a copy of bytecode with its global slots
+// compacted, like CompactGlobals. The input is not modified.
+func CompactRegisterGlobals(bytecode *RegisterBytecode) *RegisterBytecode {
+	code := [][]RegisterInstruction{bytecode.Instructions}
+	for _, constant := range bytecode.Constants {
+		if constant.Type == FunctionType {
+			code = append(code, constant.AsFunction().RegisterInstructions)
+		}
+	}
+
+	loaded := make(map[int]bool)
+	for _, ins := range code {
+		for _, in := range ins {
+			if op, _, bx := in.DecodeBx(); op == OpRLoadGlobal {
+				loaded[int(bx)] = true
+			}
+		}
+	}
+	slots, count := globalSlots(loaded)
+
+	constants := make([]Value, len(bytecode.Constants))
+	for i, constant := range bytecode.Constants {
+		if constant.Type == FunctionType {
+			fn := *constant.AsFunction()
+			fn.RegisterInstructions = renumberRegisterGlobals(fn.RegisterInstructions, slots)
+			constant = NewFunctionValue(&fn)
+		}
+		constants[i] = constant
+	}
+
+	main := *bytecode.MainFunction
+	main.RegisterInstructions = renumberRegisterGlobals(main.RegisterInstructions, slots)
+
+	compacted := *bytecode
+	compacted.Instructions = renumberRegisterGlobals(bytecode.Instructions, slots)
+	compacted.Constants = constants
+	compacted.MainFunction = &main
+	compacted.Globals = count
+	return &compacted
+}
+
+// renumberRegisterGlobals returns a copy of ins with each global operand
+// replaced by its slot
+func renumberRegisterGlobals(ins []RegisterInstruction, slots func(int) int) []RegisterInstruction {
+	if ins == nil {
+		return nil
+	}
+	renumbered := make([]RegisterInstruction, len(ins))
+	for pc, in := range ins {
+		if op, a, bx := in.DecodeBx(); op == OpRLoadGlobal || op == OpRStoreGlobal {
+			in = EncodeRegisterInstructionBx(op, a, uint16(slots(int(bx))))
+		}
+		renumbered[pc] = in
+	}
+	return renumbered
+}
+
+// globalSlots numbers the loaded globals from 0 in their original order and
+// returns the slot of each global along with the number of slots: one per
+// loaded global, and the shared one for the rest
+func globalSlots(loaded map[int]bool) (func(int) int, int) {
+	slot := make(map[int]int, len(loaded))
+	for global := 0; len(slot) < len(loaded); global++ {
+		if loaded[global] {
+			slot[global] = len(slot)
+		}
+	}
+
+	unloaded := len(slot)
+	return func(global int) int {
+		if s, ok := slot[global]; ok {
+			return s
+		}
+		return unloaded
+	}, unloaded + 1
+}
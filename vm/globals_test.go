@@ -0,0 +1,102 @@
+package vm
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestCompactGlobals(t *testing.T) {
+	// g0 is only stored to; g5 is read by main and g9 by get
+	get := &Function{
+		Name:         "get",
+		Instructions: concatInstructions(Make(OpLoadGlobal, 9), Make(OpReturn)),
+	}
+	original := &Bytecode{
+		Instructions: concatInstructions(
+			Make(OpPush, 0),
+			Make(OpStoreGlobal, 9),
+			Make(OpPush, 1),
+			Make(OpStoreGlobal, 0),
+			Make(OpPush, 2),
+			Make(OpStoreGlobal, 5),
+			Make(OpPush, 3),
+			Make(OpCall, 0),
+			Make(OpLoadGlobal, 5),
+			Make(OpAdd),
+			Make(OpPop),
+		),
+		Constants: []Value{IntValue(10), IntValue(1), IntValue(32), NewFunctionValue(get)},
+	}
+	before := append([]byte(nil), original.Instructions...)
+
+	compacted := CompactGlobals(original)
+	if compacted.Globals != 3 {
+		t.Errorf("expected 3 global slots, got %d", compacted.Globals)
+	}
+	want := map[int]int{9: 1, 0: 2, 5: 0}
+	decoded := decodeStackInstructions(compacted.Instructions)
+	for i, in := range decodeStackInstructions(original.Instructions) {
+		if in.op == OpLoadGlobal || in.op == OpStoreGlobal {
+			if got := decoded[i].operands[0]; got != want[in.operands[0]] {
+				t.Errorf("global %d: expected slot %d, got %d", in.operands[0], want[in.operands[0]], got)
+			}
+		}
+	}
+	if got := decodeStackInstructions(compacted.Constants[3].AsFunction().Instructions)[0].operands[0]; got != 1 {
+		t.Errorf("get: expected slot 1, got %d", got)
+	}
+	if !bytes.Equal(original.Instructions, before) || get.Instructions[2] != 9 {
+		t.Errorf("input was modified")
+	}
+
+	machine := New(compacted)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if len(machine.globals) != 3 {
+		t.Errorf("expected 3 globals allocated, got %d", len(machine.globals))
+	}
+	if result := machine.LastPoppedStackElem(); result.AsInt() != 42 {
+		t.Errorf("wrong result. want=42, got=%s", result.String())
+	}
+}
+
+func TestCompactRegisterGlobals(t *testing.T) {
+	// g7 is only stored to; g3 is stored and read back
+	ins := []RegisterInstruction{
+		EncodeRegisterInstructionBx(OpRLoadK, 0, 0),
+		EncodeRegisterInstructionBx(OpRStoreGlobal, 0, 7),
+		EncodeRegisterInstructionBx(OpRLoadK, 1, 1),
+		EncodeRegisterInstructionBx(OpRStoreGlobal, 1, 3),
+		EncodeRegisterInstructionBx(OpRLoadGlobal, 2, 3),
+	}
+	original := &RegisterBytecode{
+		Instructions: ins,
+		Constants:    []Value{IntValue(1), IntValue(42)},
+		MainFunction: &Function{Name: "main", NumLocals: 3},
+		ResultReg:    2,
+	}
+
+	compacted := CompactRegisterGlobals(original)
+	if compacted.Globals != 2 {
+		t.Errorf("expected 2 global slots, got %d", compacted.Globals)
+	}
+	listing := RegisterDisassemble(compacted.Instructions)
+	for _, want := range []string{"STOREGLOBAL    R0 G1", "STOREGLOBAL    R1 G0", "LOADGLOBAL     R2 G0"} {
+		if !strings.Contains(listing, want) {
+			t.Errorf("expected %q\n%s", want, listing)
+		}
+	}
+	if _, _, bx := original.Instructions[1].DecodeBx(); bx != 7 {
+		t.Errorf("input was modified")
+	}
+
+	machine := NewRegisterVM(compacted)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("register vm error: %s", err)
+	}
+	if result := machine.LastValue(); result.AsInt() != 42 {
+		t.Errorf("wrong result. want=42, got=%s", result.String())
+	}
+}
@@ -0,0 +1,150 @@
+package vm
+
+import "fmt"
+
+// Higher-order builtins
+//
+// map, filter and reduce call a function the program passes them for each
+// element of an array. A builtin runs in Go, so to call a MinLang function it
+// goes back into the VM running it: the VM pushes a frame for the call and
+// runs a dispatch loop of its own until that frame returns, leaving the
+// frames below it, including the one that called the builtin, untouched. The
+// call can in turn call builtins that call back, to any depth the frame limit
+// allows. An error in the function stops the builtin, and the program with it
+// unless a try catches it.
+
+// CallFunc calls fn, a function, closure or builtin value, with args
+type CallFunc func(fn Value, args ...Value) (Value, error)
+
+// callValue calls fn with args from a builtin, running the VM until it
+// returns
+func (vm *VM) callValue(fn Value, args ...Value) (Value, error) {
+	switch fn.Type {
+	case BuiltinFunctionType:
+		return fn.AsBuiltinFunction()(args...)
+	case FunctionType, ClosureType:
+	default:
+		return NilValue(), ErrCallingNonFunction
+	}
+	if err := checkArity(fn, len(args)); err != nil {
+		return NilValue(), err
+	}
+
+	floor := vm.framesIndex
+	if err := vm.push(fn); err != nil {
+		return NilValue(), err
+	}
+	for _, arg := range args {
+		if err := vm.push(arg); err != nil {
+			return NilValue(), err
+		}
+	}
+	if err := vm.executeCall(len(args)); err != nil {
+		return NilValue(), err
+	}
+	if err := vm.execute(floor); err != nil {
+		return NilValue(), err
+	}
+	return vm.pop(), nil
+}
+
+// callValue calls fn with args from a builtin, running the VM until it
+// returns
+func (vm *RegisterVM) callValue(fn Value, args ...Value) (Value, error) {
+	if fn.Type == BuiltinFunctionType {
+		return fn.AsBuiltinFunction()(args...)
+	}
+	floor := vm.frameIndex
+	if err := vm.enterFunction(fn, args, -1, len(args)); err != nil {
+		return NilValue(), err
+	}
+	if err := vm.execute(floor); err != nil {
+		return NilValue(), err
+	}
+	return vm.returned, nil
+}
+
+// checkArity reports a function or closure fn that doesn't take n arguments
+func checkArity(fn Value, n int) error {
+	var f *Function
+	if fn.Type == ClosureType {
+		f = fn.AsClosure().Fn
+	} else {
+		f = fn.AsFunction()
+	}
+	if f.NumParams != n {
+		return fmt.Errorf("function %s expects %d arguments, got %d", f.Name, f.NumParams, n)
+	}
+	return nil
+}
+
+// callEach calls fn, for the builtin name, with each element of arr in turn,
+// passing each element and its result to visit
+func (env *builtinEnv) callEach(name string, arr *ArrayValue, fn Value, visit func(elem, result Value)) error {
+	if env.call == nil {
+		return fmt.Errorf("%s: functions can't be called here", name)
+	}
+	for _, elem := range arr.Elements {
+		result, err := env.call(fn, elem)
+		if err != nil {
+			return err
+		}
+		visit(elem, result)
+	}
+	return nil
+}
+
+// mapBuiltin implements map(arr, fn), a new array of fn applied to each
+// element of arr
+func (env *builtinEnv) mapBuiltin(args ...Value) (Value, error) {
+	arr, err := arrayArg("map", 2, args)
+	if err != nil {
+		return NilValue(), err
+	}
+	mapped := make([]Value, 0, len(arr.Elements))
+	err = env.callEach("map", arr, args[1], func(_, result Value) {
+		mapped = append(mapped, result)
+	})
+	if err != nil {
+		return NilValue(), err
+	}
+	return NewArrayFromElements(mapped), nil
+}
+
+// filterBuiltin implements filter(arr, fn), a new array of the elements of
+// arr for which fn is true
+func (env *builtinEnv) filterBuiltin(args ...Value) (Value, error) {
+	arr, err := arrayArg("filter", 2, args)
+	if err != nil {
+		return NilValue(), err
+	}
+	var kept []Value
+	err = env.callEach("filter", arr, args[1], func(elem, result Value) {
+		if result.IsTruthy() {
+			kept = append(kept, elem)
+		}
+	})
+	if err != nil {
+		return NilValue(), err
+	}
+	return NewArrayFromElements(kept), nil
+}
+
+// reduceBuiltin implements reduce(arr, fn, init), combining init with each
+// element of arr in turn as fn(acc, elem)
+func (env *builtinEnv) reduceBuiltin(args ...Value) (Value, error) {
+	arr, err := arrayArg("reduce", 3, args)
+	if err != nil {
+		return NilValue(), err
+	}
+	if env.call == nil {
+		return NilValue(), fmt.Errorf("reduce: functions can't be called here")
+	}
+	acc := args[2]
+	for _, elem := range arr.Elements {
+		if acc, err = env.call(args[1], acc, elem); err != nil {
+			return NilValue(), err
+		}
+	}
+	return acc, nil
+}
@@ -0,0 +1,86 @@
+package vm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Input and arguments
+//
+// readLine() returns the next line of standard input without its line ending,
+// or nil once the input is used up, so a program reads every line with
+//
+//	var line = readLine()
+//	for line != nil { ...; line = readLine() }
+//
+// input(prompt) prints prompt, without a newline, and then reads a line the
+// same way. args() is an array of the command-line arguments given after the
+// program's source file.
+
+// readLineBuiltin implements readLine()
+func (env *builtinEnv) readLineBuiltin(args ...Value) (Value, error) {
+	if len(args) != 0 {
+		return NilValue(), fmt.Errorf("readLine: wrong number of arguments. got=%d, want=0", len(args))
+	}
+	return env.readLine("readLine")
+}
+
+// inputBuiltin implements input() and input(prompt). Output printed so far is
+// flushed first, so the prompt and anything before it show before waiting.
+func (env *builtinEnv) inputBuiltin(args ...Value) (Value, error) {
+	if len(args) > 1 {
+		return NilValue(), fmt.Errorf("input: wrong number of arguments. got=%d, want=0 or 1", len(args))
+	}
+	if len(args) == 1 {
+		if args[0].Type != StringType {
+			return NilValue(), fmt.Errorf("input: prompt must be string")
+		}
+		if env.buf != nil {
+			env.buf.WriteString(args[0].AsString())
+		} else {
+			io.WriteString(env.out(), args[0].AsString())
+		}
+	}
+	if env.buf != nil {
+		env.buf.Flush()
+	}
+	return env.readLine("input")
+}
+
+// readLine reads the next line of input for the builtin name, or nil at the
+// end of the input
+func (env *builtinEnv) readLine(name string) (Value, error) {
+	if env.lines == nil {
+		stdin := env.stdin
+		if stdin == nil {
+			stdin = os.Stdin
+		}
+		env.lines = bufio.NewReader(stdin)
+	}
+	line, err := env.lines.ReadString('\n')
+	if err == io.EOF {
+		if line == "" {
+			return NilValue(), nil
+		}
+	} else if err != nil {
+		return NilValue(), fmt.Errorf("%s: %v", name, err)
+	}
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\r")
+	return StringValue(line), nil
+}
+
+// argsBuiltin implements args()
+func (env *builtinEnv) argsBuiltin(args ...Value) (Value, error) {
+	if len(args) != 0 {
+		return NilValue(), fmt.Errorf("args: wrong number of arguments. got=%d, want=0", len(args))
+	}
+	elements := make([]Value, len(env.args))
+	for i, arg := range env.args {
+		elements[i] = StringValue(arg)
+	}
+	return NewArrayFromElements(elements), nil
+}
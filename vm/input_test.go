@@ -0,0 +1,54 @@
+package vm
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestReadLine(t *testing.T) {
+	env := &builtinEnv{stdin: strings.NewReader("one\ntwo\r\n\nlast")}
+
+	for _, want := range []string{"one", "two", "", "last"} {
+		got, err := env.readLineBuiltin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Type != StringType || got.AsString() != want {
+			t.Fatalf("readLine() = %s, want %q", got.String(), want)
+		}
+	}
+	if got, _ := env.readLineBuiltin(); got.Type != NilType {
+		t.Errorf("readLine() at the end of input = %s, want nil", got.String())
+	}
+}
+
+func TestInputPrintsPrompt(t *testing.T) {
+	var out strings.Builder
+	env := &builtinEnv{stdout: &out, stdin: strings.NewReader("Ada\n")}
+	env.buf = bufio.NewWriter(&out)
+
+	env.printBuiltin(StringValue("before"))
+	got, err := env.inputBuiltin(StringValue("name? "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.AsString() != "Ada" {
+		t.Errorf("input() = %q, want %q", got.AsString(), "Ada")
+	}
+	if out.String() != "before\nname? " {
+		t.Errorf("output before reading = %q, want it flushed with the prompt", out.String())
+	}
+}
+
+func TestArgs(t *testing.T) {
+	env, _, _ := newConfig([]Option{WithArgs([]string{"a", "-b"})}).builtins(nil)
+	got, err := env.argsBuiltin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	elements := got.AsArray().Elements
+	if len(elements) != 2 || elements[0].AsString() != "a" || elements[1].AsString() != "-b" {
+		t.Errorf("args() = %v, want [a -b]", elements)
+	}
+}
@@ -11,17 +11,33 @@ type Instruction []byte
 // Make creates an instruction from an opcode and operands
 func Make(op OpCode, operands ...int) []byte {
 	ins := []byte{byte(op)}
+	width := OperandWidth(op)
 
 	for _, operand := range operands {
-		// Encode operands as 2-byte values (big-endian)
-		buf := make([]byte, 2)
-		binary.BigEndian.PutUint16(buf, uint16(operand))
+		// Encode operands as big-endian values of the opcode's width
+		buf := make([]byte, width)
+		if width == 4 {
+			binary.BigEndian.PutUint32(buf, uint32(operand))
+		} else {
+			binary.BigEndian.PutUint16(buf, uint16(operand))
+		}
 		ins = append(ins, buf...)
 	}
 
 	return ins
 }
 
+// OperandWidth returns the size in bytes of each of op's operands. Jump
+// targets and the wide opcodes' operands take 4 bytes, so code can grow past
+// 64KB and the constant pool past 65536 entries; all else takes 2.
+func OperandWidth(op OpCode) int {
+	switch op {
+	case OpJump, OpJumpIfFalse, OpJumpIfTrue, OpTry, OpPushWide, OpCopyConstWide, OpMakeClosureWide, OpCheckTypeWide:
+		return 4
+	}
+	return 2
+}
+
 // ReadOperand reads a 2-byte operand from the instruction stream
 func ReadOperand(ins []byte, offset int) (int, int) {
 	if offset+2 > len(ins) {
@@ -31,6 +47,15 @@ func ReadOperand(ins []byte, offset int) (int, int) {
 	return operand, offset + 2
 }
 
+// ReadWideOperand reads a 4-byte operand from the instruction stream
+func ReadWideOperand(ins []byte, offset int) (int, int) {
+	if offset+4 > len(ins) {
+		return 0, offset
+	}
+	operand := int(binary.BigEndian.Uint32(ins[offset:]))
+	return operand, offset + 4
+}
+
 // Disassemble converts bytecode to a human-readable format
 func Disassemble(bytecode []byte) string {
 	result := ""
@@ -51,7 +76,7 @@ func Disassemble(bytecode []byte) string {
 				i++
 			}
 		// Phase 4B: Inc/Dec have 2 operands (variable index and amount)
-		case OpIncGlobal, OpDecGlobal, OpIncLocal, OpDecLocal:
+		case OpIncGlobal, OpDecGlobal, OpIncLocal, OpDecLocal, OpCallBuiltin, OpCheckType:
 			if i+4 < len(bytecode) {
 				varIndex, _ := ReadOperand(bytecode, i+1)
 				amount, _ := ReadOperand(bytecode, i+3)
@@ -60,11 +85,28 @@ func Disassemble(bytecode []byte) string {
 			} else {
 				i++
 			}
-		case OpPush, OpLoadGlobal, OpStoreGlobal, OpLoadLocal, OpStoreLocal,
-			OpLoadFree, OpJump, OpJumpIfFalse, OpJumpIfTrue, OpCall,
-			OpGetBuiltin, OpArray, OpMap, OpStruct, OpGetField, OpSetField,
+		case OpJump, OpJumpIfFalse, OpJumpIfTrue, OpTry, OpPushWide, OpCopyConstWide:
+			if i+4 < len(bytecode) {
+				operand, _ := ReadWideOperand(bytecode, i+1)
+				result += fmt.Sprintf(" %d", operand)
+				i += 5
+			} else {
+				i++
+			}
+		case OpMakeClosureWide, OpCheckTypeWide:
+			if i+8 < len(bytecode) {
+				fnIndex, _ := ReadWideOperand(bytecode, i+1)
+				numFree, _ := ReadWideOperand(bytecode, i+5)
+				result += fmt.Sprintf(" %d %d", fnIndex, numFree)
+				i += 9
+			} else {
+				i++
+			}
+		case OpPush, OpCopyConst, OpLoadGlobal, OpStoreGlobal, OpLoadLocal, OpStoreLocal,
+			OpLoadFree, OpCall,
+			OpGetBuiltin, OpArray, OpMap, OpStruct, OpStructOrdered, OpTuple, OpTupleGet,
 			OpAddLocal, OpSubLocal, OpMulLocal, OpDivLocal,
-			OpGetFieldOffset, OpSetFieldOffset,
+			OpGetFieldOffset, OpSetFieldOffset, OpMakeVariant, OpTestVariant, OpTestArray,
 			// Phase 4A: Const ops have 1 operand (constant value)
 			OpAddConstInt, OpSubConstInt, OpMulConstInt, OpDivConstInt, OpModConstInt,
 			OpAddConstFloat, OpSubConstFloat, OpMulConstFloat, OpDivConstFloat,
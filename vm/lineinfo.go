@@ -0,0 +1,149 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Position is a line and column in MinLang source (both 1-based)
+type Position struct {
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// LineEntry says that instructions from Offset up to the next entry's
+// offset were compiled from source at Pos
+type LineEntry struct {
+	Offset int
+	Pos    Position
+}
+
+// LineTable maps instruction offsets (byte offsets for stack bytecode,
+// instruction indices for register bytecode) to source positions.
+// Entries are sorted by offset and only recorded when the position changes.
+type LineTable []LineEntry
+
+// Add records that code starting at offset came from pos. Entries at or past
+// offset are dropped first, since the compiler may truncate and re-emit code.
+func (t LineTable) Add(offset int, pos Position) LineTable {
+	for len(t) > 0 && t[len(t)-1].Offset >= offset {
+		t = t[:len(t)-1]
+	}
+	if len(t) > 0 && t[len(t)-1].Pos == pos {
+		return t
+	}
+	return append(t, LineEntry{Offset: offset, Pos: pos})
+}
+
+// Lookup returns the source position of the instruction at offset
+func (t LineTable) Lookup(offset int) (Position, bool) {
+	// First entry past offset; the one before it covers offset
+	i := sort.Search(len(t), func(i int) bool { return t[i].Offset > offset })
+	if i == 0 {
+		return Position{}, false
+	}
+	return t[i-1].Pos, true
+}
+
+// RuntimeError is an error raised while executing bytecode, annotated with
+// the source position of the instruction that failed and the call stack
+type RuntimeError struct {
+	Err   error
+	File  string       // Set by the caller, the VMs don't know the file name
+	Pos   Position     // Line 0 when the failing code has no line information
+	Trace []TraceEntry // Innermost call first, ending with main
+}
+
+// TraceEntry is one active call when a runtime error occurred
+type TraceEntry struct {
+	Function string
+	Pos      Position // Where the call was executing (line 0 if unknown)
+}
+
+// maxTraceEntries bounds printed traces; deep recursion keeps both ends
+const maxTraceEntries = 20
+
+func (e *RuntimeError) Error() string {
+	if e.Pos.Line == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %s", e.location(e.Pos), e.Err)
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+func (e *RuntimeError) location(pos Position) string {
+	if e.File != "" {
+		return e.File + ":" + pos.String()
+	}
+	return pos.String()
+}
+
+// StackTrace formats the call stack, one "  at function (file:line:col)"
+// line per frame
+func (e *RuntimeError) StackTrace() string {
+	var out strings.Builder
+
+	writeEntry := func(entry TraceEntry) {
+		if entry.Pos.Line == 0 {
+			fmt.Fprintf(&out, "  at %s\n", entry.Function)
+		} else {
+			fmt.Fprintf(&out, "  at %s (%s)\n", entry.Function, e.location(entry.Pos))
+		}
+	}
+
+	if len(e.Trace) <= maxTraceEntries {
+		for _, entry := range e.Trace {
+			writeEntry(entry)
+		}
+		return out.String()
+	}
+
+	half := maxTraceEntries / 2
+	for _, entry := range e.Trace[:half] {
+		writeEntry(entry)
+	}
+	fmt.Fprintf(&out, "  ... %d more frames\n", len(e.Trace)-maxTraceEntries)
+	for _, entry := range e.Trace[len(e.Trace)-half:] {
+		writeEntry(entry)
+	}
+	return out.String()
+}
+
+// newRuntimeError annotates err with the call stack at the point of failure.
+// The position of the innermost frame becomes the error's position.
+func newRuntimeError(err error, trace []TraceEntry) error {
+	var runtimeErr *RuntimeError
+	if errors.As(err, &runtimeErr) {
+		return err
+	}
+	runtimeErr = &RuntimeError{Err: err, Trace: trace}
+	if len(trace) > 0 {
+		runtimeErr.Pos = trace[0].Pos
+	}
+	return runtimeErr
+}
+
+// WithSourceFile sets the file name reported by a RuntimeError, if err is one
+// or joins some
+func WithSourceFile(err error, file string) error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			WithSourceFile(err, file)
+		}
+		return err
+	}
+	var runtimeErr *RuntimeError
+	if errors.As(err, &runtimeErr) {
+		runtimeErr.File = file
+	}
+	return err
+}
@@ -0,0 +1,111 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLineTableAddAndLookup(t *testing.T) {
+	var lines LineTable
+	lines = lines.Add(0, Position{Line: 1, Column: 1})
+	lines = lines.Add(3, Position{Line: 1, Column: 1}) // Same position, no new entry
+	lines = lines.Add(6, Position{Line: 2, Column: 5})
+	lines = lines.Add(9, Position{Line: 3, Column: 2})
+
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 entries, got %d: %v", len(lines), lines)
+	}
+
+	tests := []struct {
+		offset int
+		want   Position
+	}{
+		{0, Position{1, 1}},
+		{4, Position{1, 1}},
+		{6, Position{2, 5}},
+		{8, Position{2, 5}},
+		{42, Position{3, 2}},
+	}
+	for _, tt := range tests {
+		got, ok := lines.Lookup(tt.offset)
+		if !ok || got != tt.want {
+			t.Errorf("Lookup(%d) = %v, %v; want %v", tt.offset, got, ok, tt.want)
+		}
+	}
+
+	// Re-emitting from an earlier offset drops the entries it replaces
+	lines = lines.Add(6, Position{Line: 7, Column: 1})
+	if got, _ := lines.Lookup(42); got != (Position{7, 1}) {
+		t.Errorf("expected truncated table to map 42 to 7:1, got %v", got)
+	}
+
+	if _, ok := LineTable(nil).Lookup(0); ok {
+		t.Error("expected no position from empty table")
+	}
+}
+
+func TestRuntimeErrorPosition(t *testing.T) {
+	// 10 / 0 on line 3, column 8
+	bytecode := &Bytecode{
+		Instructions: concatInstructions(
+			Make(OpPush, 0),
+			Make(OpPush, 1),
+			Make(OpDiv),
+		),
+		Constants: []Value{IntValue(10), IntValue(0)},
+		Lines: LineTable{
+			{Offset: 0, Pos: Position{Line: 3, Column: 1}},
+			{Offset: 6, Pos: Position{Line: 3, Column: 8}},
+		},
+	}
+
+	err := New(bytecode).Run()
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected RuntimeError, got %v", err)
+	}
+	if runtimeErr.Pos != (Position{Line: 3, Column: 8}) {
+		t.Errorf("wrong position: %v", runtimeErr.Pos)
+	}
+	if !errors.Is(err, ErrDivisionByZero) {
+		t.Errorf("expected wrapped ErrDivisionByZero, got %v", err)
+	}
+
+	if got := WithSourceFile(err, "calc.min").Error(); got != "calc.min:3:8: division by zero" {
+		t.Errorf("wrong message: %q", got)
+	}
+}
+
+func TestRuntimeErrorStackTrace(t *testing.T) {
+	err := &RuntimeError{
+		Err:  ErrDivisionByZero,
+		File: "calc.min",
+		Trace: []TraceEntry{
+			{Function: "divide", Pos: Position{Line: 2, Column: 14}},
+			{Function: "<anonymous>"},
+			{Function: "main", Pos: Position{Line: 6, Column: 7}},
+		},
+	}
+	want := "  at divide (calc.min:2:14)\n  at <anonymous>\n  at main (calc.min:6:7)\n"
+	if got := err.StackTrace(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// Deep recursion keeps the innermost and outermost frames
+	err.Trace = nil
+	for i := 0; i < 50; i++ {
+		err.Trace = append(err.Trace, TraceEntry{Function: fmt.Sprintf("f%d", i)})
+	}
+	lines := strings.Split(strings.TrimSuffix(err.StackTrace(), "\n"), "\n")
+	if len(lines) != maxTraceEntries+1 {
+		t.Fatalf("expected %d lines, got %d", maxTraceEntries+1, len(lines))
+	}
+	if lines[0] != "  at f0" || lines[len(lines)-1] != "  at f49" {
+		t.Errorf("wrong ends: %q, %q", lines[0], lines[len(lines)-1])
+	}
+	if lines[maxTraceEntries/2] != "  ... 30 more frames" {
+		t.Errorf("wrong elision line: %q", lines[maxTraceEntries/2])
+	}
+}
@@ -0,0 +1,68 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Membership
+//
+// m[k] gives nil both for a missing key and for a key that holds nil, so
+// has(m, k) and k in m tell the two apart, and getOr(m, k, d) gives d only
+// when k is missing. in also finds an element of an array, compared as ==
+// compares, and a substring of a string.
+
+// In implements x in container: whether the map container has the key x, the
+// array container an element equal to x, or the string container the
+// substring x
+func In(x, container Value) (Value, error) {
+	switch container.Type {
+	case MapType:
+		_, ok := container.AsMap().Pairs[x.ToMapKey()]
+		return BoolValue(ok), nil
+	case ArrayType:
+		return BoolValue(indexOf(container.AsArray().Elements, x) >= 0), nil
+	case StringType:
+		if x.Type != StringType {
+			return NilValue(), fmt.Errorf("in: looking for %s in a string, want a string", x.Type)
+		}
+		return BoolValue(strings.Contains(container.AsString(), x.AsString())), nil
+	default:
+		return NilValue(), fmt.Errorf("in: expected a map, array or string, got %s", container.Type)
+	}
+}
+
+// mapArg returns the map args[0] for the builtin name taking want arguments
+func mapArg(name string, want int, args []Value) (*MapValue, error) {
+	if len(args) != want {
+		return nil, fmt.Errorf("%s: wrong number of arguments. got=%d, want=%d", name, len(args), want)
+	}
+	if args[0].Type != MapType {
+		return nil, fmt.Errorf("%s: first argument must be a map, got %s", name, args[0].Type)
+	}
+	return args[0].AsMap(), nil
+}
+
+// hasBuiltin implements has(m, k), whether the map m has the key k, even if
+// it holds nil
+func (env *builtinEnv) hasBuiltin(args ...Value) (Value, error) {
+	m, err := mapArg("has", 2, args)
+	if err != nil {
+		return NilValue(), err
+	}
+	_, ok := m.Pairs[args[1].ToMapKey()]
+	return BoolValue(ok), nil
+}
+
+// getOrBuiltin implements getOr(m, k, d), the value of the key k in the map
+// m, or d if m doesn't have k
+func (env *builtinEnv) getOrBuiltin(args ...Value) (Value, error) {
+	m, err := mapArg("getOr", 3, args)
+	if err != nil {
+		return NilValue(), err
+	}
+	if value, ok := m.Pairs[args[1].ToMapKey()]; ok {
+		return value, nil
+	}
+	return args[2], nil
+}
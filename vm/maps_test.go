@@ -0,0 +1,148 @@
+package vm
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMapBuiltins(t *testing.T) {
+	env := defaultBuiltinEnv
+	s := StringValue
+	m := NewMapValue()
+	m.AsMap().Set(s("a").ToMapKey(), IntValue(1))
+	m.AsMap().Set(s("n").ToMapKey(), NilValue())
+	tests := []struct {
+		name string
+		fn   BuiltinFunction
+		args []Value
+		want string
+	}{
+		{"has", env.hasBuiltin, []Value{m, s("a")}, "true"},
+		{"has", env.hasBuiltin, []Value{m, s("n")}, "true"},
+		{"has", env.hasBuiltin, []Value{m, s("z")}, "false"},
+		{"getOr", env.getOrBuiltin, []Value{m, s("a"), IntValue(5)}, "1"},
+		{"getOr", env.getOrBuiltin, []Value{m, s("n"), IntValue(5)}, "nil"},
+		{"getOr", env.getOrBuiltin, []Value{m, s("z"), IntValue(5)}, "5"},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.fn(tt.args...)
+		if err != nil {
+			t.Errorf("%s%v: %v", tt.name, tt.args, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("%s%v = %s, want %s", tt.name, tt.args, got, tt.want)
+		}
+	}
+
+	if _, err := env.hasBuiltin(NewArrayFromElements(nil), s("a")); err == nil {
+		t.Error("has on an array: expected an error")
+	}
+}
+
+func TestIn(t *testing.T) {
+	s := StringValue
+	m := NewMapValue()
+	m.AsMap().Set(IntValue(1).ToMapKey(), NilValue())
+	xs := NewArrayFromElements([]Value{IntValue(1), NewArrayFromElements([]Value{IntValue(2)})})
+	tests := []struct {
+		x, container Value
+		want         bool
+	}{
+		{IntValue(1), m, true},
+		{IntValue(2), m, false},
+		{FloatValue(1), xs, true},
+		{NewArrayFromElements([]Value{IntValue(2)}), xs, true},
+		{IntValue(2), xs, false},
+		{s("ell"), s("hello"), true},
+		{s("x"), s("hello"), false},
+	}
+
+	for _, tt := range tests {
+		got, err := In(tt.x, tt.container)
+		if err != nil {
+			t.Errorf("%s in %s: %v", tt.x, tt.container, err)
+			continue
+		}
+		if got.AsBool() != tt.want {
+			t.Errorf("%s in %s = %v, want %v", tt.x, tt.container, got.AsBool(), tt.want)
+		}
+	}
+
+	for _, tt := range [][2]Value{{IntValue(1), IntValue(1)}, {IntValue(1), s("1")}} {
+		if _, err := In(tt[0], tt[1]); err == nil {
+			t.Errorf("%s in %s: expected an error", tt[0], tt[1])
+		}
+	}
+}
+
+func TestMapOrder(t *testing.T) {
+	env := defaultBuiltinEnv
+	s := StringValue
+	m := NewMapValue()
+	for _, key := range []string{"c", "a", "b", "d"} {
+		m.AsMap().Set(s(key).ToMapKey(), s(key+key))
+	}
+	m.AsMap().Set(s("a").ToMapKey(), s("A"))
+	m.AsMap().Delete(s("b").ToMapKey())
+	m.AsMap().Set(s("b").ToMapKey(), s("B"))
+	m.AsMap().Delete(s("z").ToMapKey())
+	m.AsMap().Set(IntValue(2).ToMapKey(), s("two"))
+
+	tests := []struct {
+		name string
+		fn   BuiltinFunction
+		args []Value
+		want string
+	}{
+		{"keys", env.keysBuiltin, []Value{m}, `["c", "a", "d", "b", 2]`},
+		{"keys", env.keysBuiltin, []Value{m, BoolValue(true)}, `[2, "a", "b", "c", "d"]`},
+		{"values", env.valuesBuiltin, []Value{m}, `["cc", "A", "dd", "B", "two"]`},
+		{"keys of a copy", env.keysBuiltin, []Value{copyCollection(m)}, `["c", "a", "d", "b", 2]`},
+		{"keys of a deep copy", env.keysBuiltin, []Value{deepCopy(m)}, `["c", "a", "d", "b", 2]`},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.fn(tt.args...)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("%s = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMapDelete(t *testing.T) {
+	m := NewMapValue().AsMap()
+	m.Set(StringValue("").ToMapKey(), IntValue(-1))
+	for i := range int64(100) {
+		m.Set(IntValue(i).ToMapKey(), IntValue(i))
+	}
+	// Deleting enough keys closes the gaps as it goes; a key set again moves
+	// to the end
+	for i := range int64(100) {
+		if i%3 != 0 {
+			m.Delete(IntValue(i).ToMapKey())
+		}
+	}
+	m.Delete(StringValue("").ToMapKey())
+	m.Set(IntValue(4).ToMapKey(), IntValue(4))
+	m.Set(StringValue("").ToMapKey(), IntValue(-1))
+	m.Delete(IntValue(0).ToMapKey())
+
+	var want []MapKey
+	for i := int64(3); i < 100; i += 3 {
+		want = append(want, IntValue(i).ToMapKey())
+	}
+	want = append(want, IntValue(4).ToMapKey(), StringValue("").ToMapKey())
+
+	if got := m.Keys(); !slices.Equal(got, want) {
+		t.Errorf("keys = %v, want %v", got, want)
+	}
+	if len(m.Pairs) != len(want) {
+		t.Errorf("len = %d, want %d", len(m.Pairs), len(want))
+	}
+}
@@ -0,0 +1,127 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+)
+
+// Math
+//
+// The math builtins are Go's math functions. They take ints and floats
+// alike and return floats, except that floor, ceil, round and trunc return
+// the int they round to, and sign returns -1, 0 or 1. Angles are in radians.
+
+// floatArgs returns args as floats for the builtin name, which takes want
+// numbers
+func floatArgs(name string, want int, args []Value) ([]float64, error) {
+	if len(args) != want {
+		return nil, fmt.Errorf("%s: wrong number of arguments. got=%d, want=%d", name, len(args), want)
+	}
+	vals := make([]float64, len(args))
+	for i, arg := range args {
+		val, ok := numericAsFloat(arg)
+		if !ok {
+			return nil, fmt.Errorf("%s: arguments must be int or float", name)
+		}
+		vals[i] = val
+	}
+	return vals, nil
+}
+
+// applyMath implements the builtin name, which is fn of its one argument
+func applyMath(name string, fn func(float64) float64, args []Value) (Value, error) {
+	vals, err := floatArgs(name, 1, args)
+	if err != nil {
+		return NilValue(), err
+	}
+	return FloatValue(fn(vals[0])), nil
+}
+
+// sinBuiltin implements sin(x)
+func (env *builtinEnv) sinBuiltin(args ...Value) (Value, error) {
+	return applyMath("sin", math.Sin, args)
+}
+
+// cosBuiltin implements cos(x)
+func (env *builtinEnv) cosBuiltin(args ...Value) (Value, error) {
+	return applyMath("cos", math.Cos, args)
+}
+
+// tanBuiltin implements tan(x)
+func (env *builtinEnv) tanBuiltin(args ...Value) (Value, error) {
+	return applyMath("tan", math.Tan, args)
+}
+
+// expBuiltin implements exp(x), e to the power x
+func (env *builtinEnv) expBuiltin(args ...Value) (Value, error) {
+	return applyMath("exp", math.Exp, args)
+}
+
+// atan2Builtin implements atan2(y, x), the angle of the point (x, y)
+func (env *builtinEnv) atan2Builtin(args ...Value) (Value, error) {
+	vals, err := floatArgs("atan2", 2, args)
+	if err != nil {
+		return NilValue(), err
+	}
+	return FloatValue(math.Atan2(vals[0], vals[1])), nil
+}
+
+// logBuiltin implements log(x), the natural logarithm of a positive number
+func (env *builtinEnv) logBuiltin(args ...Value) (Value, error) {
+	vals, err := floatArgs("log", 1, args)
+	if err != nil {
+		return NilValue(), err
+	}
+	if vals[0] <= 0 {
+		return NilValue(), fmt.Errorf("log: argument must be positive")
+	}
+	return FloatValue(math.Log(vals[0])), nil
+}
+
+// roundBuiltin implements round(n), the nearest int, halves rounding away
+// from zero
+func (env *builtinEnv) roundBuiltin(args ...Value) (Value, error) {
+	return roundToInt("round", math.Round, args)
+}
+
+// truncBuiltin implements trunc(n), n without its fractional part
+func (env *builtinEnv) truncBuiltin(args ...Value) (Value, error) {
+	return roundToInt("trunc", math.Trunc, args)
+}
+
+// roundToInt implements the builtin name, which rounds its argument to an
+// int with round. An int is returned as is.
+func roundToInt(name string, round func(float64) float64, args []Value) (Value, error) {
+	if len(args) != 1 {
+		return NilValue(), fmt.Errorf("%s: wrong number of arguments. got=%d, want=1", name, len(args))
+	}
+	switch args[0].Type {
+	case IntType:
+		return args[0], nil
+	case FloatType:
+	default:
+		return NilValue(), fmt.Errorf("%s: argument must be int or float", name)
+	}
+
+	val := round(args[0].AsFloat())
+	if math.IsNaN(val) || val < math.MinInt64 || val >= math.MaxInt64 {
+		return NilValue(), fmt.Errorf("%s: %g is out of the int range", name, args[0].AsFloat())
+	}
+	return IntValue(int64(val)), nil
+}
+
+// signBuiltin implements sign(n), -1, 0 or 1 as n is negative, zero or
+// positive
+func (env *builtinEnv) signBuiltin(args ...Value) (Value, error) {
+	vals, err := floatArgs("sign", 1, args)
+	if err != nil {
+		return NilValue(), err
+	}
+	switch {
+	case vals[0] < 0:
+		return IntValue(-1), nil
+	case vals[0] > 0:
+		return IntValue(1), nil
+	}
+	return IntValue(0), nil
+}
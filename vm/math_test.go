@@ -0,0 +1,77 @@
+package vm
+
+import (
+	"math"
+	"testing"
+)
+
+func TestMathBuiltins(t *testing.T) {
+	env := defaultBuiltinEnv
+	tests := []struct {
+		name string
+		fn   BuiltinFunction
+		args []Value
+		want float64
+	}{
+		{"sqrt", env.sqrtBuiltin, []Value{IntValue(2)}, math.Sqrt2},
+		{"pow", env.powBuiltin, []Value{IntValue(2), IntValue(-2)}, 0.25},
+		{"pow", env.powBuiltin, []Value{FloatValue(27), FloatValue(1.0 / 3)}, 3},
+		{"sin", env.sinBuiltin, []Value{FloatValue(math.Pi / 2)}, 1},
+		{"cos", env.cosBuiltin, []Value{IntValue(0)}, 1},
+		{"tan", env.tanBuiltin, []Value{FloatValue(math.Pi / 4)}, 1},
+		{"atan2", env.atan2Builtin, []Value{IntValue(1), IntValue(-1)}, 3 * math.Pi / 4},
+		{"exp", env.expBuiltin, []Value{IntValue(1)}, math.E},
+		{"log", env.logBuiltin, []Value{FloatValue(math.E * math.E)}, 2},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.fn(tt.args...)
+		if err != nil {
+			t.Errorf("%s%v: %v", tt.name, tt.args, err)
+			continue
+		}
+		if got.Type != FloatType || math.Abs(got.AsFloat()-tt.want) > 1e-12 {
+			t.Errorf("%s%v = %s, want %g", tt.name, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestRoundingBuiltins(t *testing.T) {
+	env := defaultBuiltinEnv
+	tests := []struct {
+		name string
+		fn   BuiltinFunction
+		arg  Value
+		want int64
+	}{
+		{"floor", env.floorBuiltin, FloatValue(-2.5), -3},
+		{"ceil", env.ceilBuiltin, FloatValue(-2.5), -2},
+		{"round", env.roundBuiltin, FloatValue(-2.5), -3},
+		{"round", env.roundBuiltin, FloatValue(2.49), 2},
+		{"trunc", env.truncBuiltin, FloatValue(-2.7), -2},
+		{"trunc", env.truncBuiltin, IntValue(7), 7},
+		{"sign", env.signBuiltin, FloatValue(-0.5), -1},
+		{"sign", env.signBuiltin, IntValue(0), 0},
+		{"sign", env.signBuiltin, IntValue(9), 1},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.fn(tt.arg)
+		if err != nil {
+			t.Errorf("%s(%s): %v", tt.name, tt.arg, err)
+			continue
+		}
+		if got.Type != IntType || got.AsInt() != tt.want {
+			t.Errorf("%s(%s) = %s, want %d", tt.name, tt.arg, got, tt.want)
+		}
+	}
+
+	for _, arg := range []Value{FloatValue(1e300), FloatValue(math.NaN()), FloatValue(math.Inf(-1))} {
+		if _, err := env.roundBuiltin(arg); err == nil {
+			t.Errorf("round(%s): expected an error", arg)
+		}
+	}
+	if _, err := env.logBuiltin(IntValue(0)); err == nil {
+		t.Errorf("log(0): expected an error")
+	}
+}
@@ -144,8 +144,86 @@ const (
 	// Special operations
 	OpHalt       // Halt execution
 	OpPrint      // Built-in print (for debugging)
+
+	// Division with float promotion (--promote-int-div)
+	OpDivPromote // int or float / int or float → float
+
+	// Literal hoisting
+	OpCopyConst // Push a fresh copy of a constant array or map
+
+	// Direct builtin calls
+	OpCallBuiltin // Call builtin[operand 1] with operand 2 args from the stack
+
+	// Constant indices past 65535, with 4-byte operands
+	OpPushWide        // OpPush
+	OpCopyConstWide   // OpCopyConst
+	OpMakeClosureWide // OpMakeClosure
+
+	// Runtime checks of type annotations (--runtime-checks)
+	OpCheckType     // Fail unless TOS has type operand 2, with message constant operand 1
+	OpCheckTypeWide // OpCheckType
+
+	// Tagged unions, for enum payloads, results and optionals
+	OpMakeVariant    // TOS = variant with tag operand 1 carrying TOS
+	OpTestVariant    // TOS = TOS is a variant with tag operand 1
+	OpVariantTag     // TOS = tag of variant TOS
+	OpVariantPayload // TOS = payload of variant TOS
+
+	// Intrinsics: builtins called with arguments known to be numeric. The
+	// float forms also take ints, which float variables may hold.
+	OpAbsInt    // TOS = abs(TOS) - int
+	OpAbsFloat  // TOS = abs(TOS) - float
+	OpMinInt    // min of top two ints
+	OpMinFloat  // min of top two floats
+	OpMaxInt    // max of top two ints
+	OpMaxFloat  // max of top two floats
+	OpSqrtInt   // TOS = sqrt(TOS) - int → float
+	OpSqrtFloat // TOS = sqrt(TOS) - float
+
+	OpWrapInt32 // TOS = TOS wrapped to 32 bits, if an int (int32 mode)
+
+	OpSlice // Pop high, low and container; push container[low:high], nil bounds defaulting
+
+	// Error handling: see try.go
+	OpTry    // Catch errors at address operand 1 until the matching OpEndTry
+	OpEndTry // Stop catching errors at the innermost OpTry's address
+
+	// Struct and array case patterns
+	OpTestStruct // TOS = TOS1 is a struct of the type named TOS
+	OpTestArray  // TOS = TOS is an array of operand 1 elements
+
+	// Arithmetic that fails where ints overflow: see overflow.go
+	OpAddChecked // Add, with an error if the int result overflows
+	OpSubChecked // Subtract, with an error if the int result overflows
+	OpMulChecked // Multiply, with an error if the int result overflows
+	OpDivChecked // Divide, with an error if the int result overflows
+	OpNegChecked // Negate, with an error if the int result overflows
+
+	OpIn // TOS = TOS1 in TOS: see maps.go
+
+	// Tuples: see tuples.go
+	OpTuple    // Create a tuple of the top operand 1 values
+	OpTupleGet // TOS = TOS.operand 1
+
+	OpPopResult // Pop the value of a top-level expression statement, the program's result
 )
 
+// WideOpCode returns the form of op with 4-byte operands, for a constant
+// index that doesn't fit in 2 bytes, if op has one
+func WideOpCode(op OpCode) (OpCode, bool) {
+	switch op {
+	case OpPush:
+		return OpPushWide, true
+	case OpCopyConst:
+		return OpCopyConstWide, true
+	case OpMakeClosure:
+		return OpMakeClosureWide, true
+	case OpCheckType:
+		return OpCheckTypeWide, true
+	}
+	return op, false
+}
+
 // String returns the string representation of an opcode
 func (op OpCode) String() string {
 	switch op {
@@ -359,6 +437,76 @@ func (op OpCode) String() string {
 		return "HALT"
 	case OpPrint:
 		return "PRINT"
+	case OpPopResult:
+		return "POP_RESULT"
+	case OpDivPromote:
+		return "DIV_PROMOTE"
+	case OpCopyConst:
+		return "COPY_CONST"
+	case OpCallBuiltin:
+		return "CALL_BUILTIN"
+	case OpPushWide:
+		return "PUSH_WIDE"
+	case OpCopyConstWide:
+		return "COPY_CONST_WIDE"
+	case OpMakeClosureWide:
+		return "MAKE_CLOSURE_WIDE"
+	case OpCheckType:
+		return "CHECK_TYPE"
+	case OpCheckTypeWide:
+		return "CHECK_TYPE_WIDE"
+	case OpMakeVariant:
+		return "MAKE_VARIANT"
+	case OpTestVariant:
+		return "TEST_VARIANT"
+	case OpVariantTag:
+		return "VARIANT_TAG"
+	case OpVariantPayload:
+		return "VARIANT_PAYLOAD"
+	case OpAbsInt:
+		return "ABS_INT"
+	case OpAbsFloat:
+		return "ABS_FLOAT"
+	case OpMinInt:
+		return "MIN_INT"
+	case OpMinFloat:
+		return "MIN_FLOAT"
+	case OpMaxInt:
+		return "MAX_INT"
+	case OpMaxFloat:
+		return "MAX_FLOAT"
+	case OpSqrtInt:
+		return "SQRT_INT"
+	case OpSqrtFloat:
+		return "SQRT_FLOAT"
+	case OpWrapInt32:
+		return "WRAP_INT32"
+	case OpSlice:
+		return "SLICE"
+	case OpTry:
+		return "TRY"
+	case OpEndTry:
+		return "END_TRY"
+	case OpTestStruct:
+		return "TEST_STRUCT"
+	case OpTestArray:
+		return "TEST_ARRAY"
+	case OpAddChecked:
+		return "ADD_CHECKED"
+	case OpSubChecked:
+		return "SUB_CHECKED"
+	case OpMulChecked:
+		return "MUL_CHECKED"
+	case OpDivChecked:
+		return "DIV_CHECKED"
+	case OpNegChecked:
+		return "NEG_CHECKED"
+	case OpIn:
+		return "IN"
+	case OpTuple:
+		return "TUPLE"
+	case OpTupleGet:
+		return "TUPLE_GET"
 	default:
 		return "UNKNOWN"
 	}
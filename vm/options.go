@@ -0,0 +1,63 @@
+package vm
+
+import (
+	"io"
+)
+
+// Option configures a VM or RegisterVM at construction
+type Option func(*config)
+
+// config collects the settings applied by Options
+type config struct {
+	stdout io.Writer
+	stdin  io.Reader
+	args   []string
+	call   CallFunc
+}
+
+// WithStdout sends program output (print) to w instead of os.Stdout
+func WithStdout(w io.Writer) Option {
+	return func(c *config) {
+		c.stdout = w
+	}
+}
+
+// WithStdin makes input and readLine read from r instead of os.Stdin
+func WithStdin(r io.Reader) Option {
+	return func(c *config) {
+		c.stdin = r
+	}
+}
+
+// WithArgs sets the command-line arguments args() returns to the program
+func WithArgs(args []string) Option {
+	return func(c *config) {
+		c.args = args
+	}
+}
+
+// WithCall makes builtins that take a function, like map, call it with call.
+// The VMs call functions themselves and set their own; BuiltinValues needs
+// it for the builtins it returns to call any.
+func WithCall(call CallFunc) Option {
+	return func(c *config) {
+		c.call = call
+	}
+}
+
+func newConfig(opts []Option) config {
+	var c config
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// builtins returns the builtin functions and their Values for a VM running a
+// program with enums. Each VM gets its own, since print buffers output per VM.
+func (c config) builtins(enums Enums) (*builtinEnv, []BuiltinFunction, []Value) {
+	env := newVMBuiltinEnv(c.stdout, enums)
+	env.stdin, env.args = c.stdin, c.args
+	fns := env.functions()
+	return env, fns, builtinValues(fns)
+}
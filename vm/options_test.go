@@ -0,0 +1,126 @@
+package vm
+
+import (
+	"bytes"
+	"testing"
+)
+
+// printProgram calls print("hi", 42), then len() with no arguments, which
+// aborts the program before the final print("hi", 42)
+func printProgram() *Bytecode {
+	return &Bytecode{
+		Instructions: concatInstructions(
+			Make(OpGetBuiltin, 0),
+			Make(OpPush, 0),
+			Make(OpPush, 1),
+			Make(OpCall, 2),
+			Make(OpPop),
+			Make(OpGetBuiltin, 1),
+			Make(OpCall, 0),
+			Make(OpPop),
+			Make(OpGetBuiltin, 0),
+			Make(OpPush, 0),
+			Make(OpPush, 1),
+			Make(OpCall, 2),
+			Make(OpPop),
+		),
+		Constants: []Value{StringValue("hi"), IntValue(42)},
+	}
+}
+
+func TestWithStdoutAndBuiltinErrors(t *testing.T) {
+	translated, err := TranslateToRegister(printProgram())
+	if err != nil {
+		t.Fatalf("translation failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		run  func(stdout *bytes.Buffer) error
+	}{
+		{"stack", func(stdout *bytes.Buffer) error {
+			return New(printProgram(), WithStdout(stdout)).Run()
+		}},
+		{"register", func(stdout *bytes.Buffer) error {
+			return NewRegisterVM(translated, WithStdout(stdout)).Run()
+		}},
+	}
+
+	for _, tt := range tests {
+		var stdout bytes.Buffer
+		err := tt.run(&stdout)
+		if err == nil || err.Error() != "len: wrong number of arguments. got=0, want=1" {
+			t.Errorf("%s: expected the len error, got %v", tt.name, err)
+		}
+		if stdout.String() != "hi 42\n" {
+			t.Errorf("%s: stdout got %q", tt.name, stdout.String())
+		}
+	}
+}
+
+// countingWriter counts the Write calls it receives
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func TestPrintOutputIsBuffered(t *testing.T) {
+	program := &Bytecode{
+		Instructions: concatInstructions(
+			Make(OpGetBuiltin, 0),
+			Make(OpPush, 0),
+			Make(OpCall, 1),
+			Make(OpPop),
+			Make(OpGetBuiltin, 0),
+			Make(OpPush, 1),
+			Make(OpCall, 1),
+			Make(OpPop),
+		),
+		Constants: []Value{StringValue("one"), StringValue("two")},
+	}
+	translated, err := TranslateToRegister(program)
+	if err != nil {
+		t.Fatalf("translation failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		run  func(stdout *countingWriter) error
+	}{
+		{"stack", func(stdout *countingWriter) error {
+			return New(program, WithStdout(stdout)).Run()
+		}},
+		{"register", func(stdout *countingWriter) error {
+			return NewRegisterVM(translated, WithStdout(stdout)).Run()
+		}},
+	}
+
+	for _, tt := range tests {
+		var stdout countingWriter
+		if err := tt.run(&stdout); err != nil {
+			t.Fatalf("%s: run failed: %v", tt.name, err)
+		}
+		if stdout.String() != "one\ntwo\n" || stdout.writes != 1 {
+			t.Errorf("%s: expected both lines in one write, got %q in %d writes",
+				tt.name, stdout.String(), stdout.writes)
+		}
+	}
+}
+
+func TestRegisterBuiltinRejectsDuplicates(t *testing.T) {
+	for _, name := range []string{"len", ""} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("expected RegisterBuiltin(%q) to panic", name)
+				}
+			}()
+			RegisterBuiltin(name, func(args ...Value) (Value, error) { return NilValue(), nil })
+		}()
+	}
+}
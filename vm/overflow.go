@@ -0,0 +1,86 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+)
+
+// Overflow checks
+//
+// Ints are 64 bits and their arithmetic wraps around, so factorial(25)
+// quietly comes out negative. With overflow checks on, the compilers turn
+// int +, -, *, / and negation into the checked instructions, which stop the
+// program with an error where the result doesn't fit in an int. Operands
+// that aren't both ints get the usual arithmetic, so a string + still
+// concatenates.
+
+// CheckedInt returns l operator r for the int operator +, -, * or /, or an
+// error if the result doesn't fit in an int
+func CheckedInt(operator string, l, r int64) (int64, error) {
+	var result int64
+	var overflow bool
+	switch operator {
+	case "+":
+		result = l + r
+		overflow = (r > 0 && result < l) || (r < 0 && result > l)
+	case "-":
+		result = l - r
+		overflow = (r > 0 && result > l) || (r < 0 && result < l)
+	case "*":
+		result = l * r
+		overflow = l != 0 && (result/l != r || l == -1 && r == math.MinInt64)
+	case "/":
+		if r == 0 {
+			return 0, ErrDivisionByZero
+		}
+		overflow = l == math.MinInt64 && r == -1
+		if !overflow {
+			result = l / r
+		}
+	default:
+		return 0, fmt.Errorf("unknown integer operator: %s", operator)
+	}
+	if overflow {
+		return 0, fmt.Errorf("integer overflow: %d %s %d", l, operator, r)
+	}
+	return result, nil
+}
+
+// CheckedNeg returns -n, or an error if it doesn't fit in an int
+func CheckedNeg(n int64) (int64, error) {
+	if n == math.MinInt64 {
+		return 0, fmt.Errorf("integer overflow: -(%d)", n)
+	}
+	return -n, nil
+}
+
+// checkedOperation returns the operator of a checked stack instruction and
+// the instruction computing it for operands that aren't both ints
+func checkedOperation(op OpCode) (string, OpCode) {
+	switch op {
+	case OpAddChecked:
+		return "+", OpAdd
+	case OpSubChecked:
+		return "-", OpSub
+	case OpMulChecked:
+		return "*", OpMul
+	default:
+		return "/", OpDiv
+	}
+}
+
+// checkedRegisterOperation returns the operator of a checked register
+// instruction and the instruction computing it for operands that aren't
+// both ints
+func checkedRegisterOperation(op RegisterOpCode) (string, RegisterOpCode) {
+	switch op {
+	case OpRAddChecked:
+		return "+", OpRAdd
+	case OpRSubChecked:
+		return "-", OpRSub
+	case OpRMulChecked:
+		return "*", OpRMul
+	default:
+		return "/", OpRDiv
+	}
+}
@@ -0,0 +1,55 @@
+package vm
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCheckedInt(t *testing.T) {
+	tests := []struct {
+		operator string
+		l, r     int64
+		want     int64
+		overflow bool
+	}{
+		{"+", math.MaxInt64 - 1, 1, math.MaxInt64, false},
+		{"+", math.MaxInt64, 1, 0, true},
+		{"+", math.MinInt64, -1, 0, true},
+		{"+", math.MinInt64, math.MaxInt64, -1, false},
+		{"-", math.MinInt64 + 1, 1, math.MinInt64, false},
+		{"-", math.MinInt64, 1, 0, true},
+		{"-", 0, math.MinInt64, 0, true},
+		{"-", -1, math.MinInt64, math.MaxInt64, false},
+		{"*", 3037000499, 3037000499, 9223372030926249001, false},
+		{"*", 3037000500, 3037000500, 0, true},
+		{"*", -1, math.MinInt64, 0, true},
+		{"*", math.MinInt64, -1, 0, true},
+		{"*", math.MinInt64, 1, math.MinInt64, false},
+		{"*", 0, math.MinInt64, 0, false},
+		{"/", math.MinInt64, -1, 0, true},
+		{"/", math.MinInt64, 2, math.MinInt64 / 2, false},
+	}
+
+	for _, tt := range tests {
+		got, err := CheckedInt(tt.operator, tt.l, tt.r)
+		if tt.overflow {
+			if err == nil {
+				t.Errorf("%d %s %d: expected an overflow error, got %d", tt.l, tt.operator, tt.r, got)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%d %s %d: expected %d, got %d (%v)", tt.l, tt.operator, tt.r, tt.want, got, err)
+		}
+	}
+
+	if _, err := CheckedInt("/", 1, 0); err != ErrDivisionByZero {
+		t.Errorf("1 / 0: expected %v, got %v", ErrDivisionByZero, err)
+	}
+	if _, err := CheckedNeg(math.MinInt64); err == nil {
+		t.Errorf("-MinInt64: expected an overflow error")
+	}
+	if got, err := CheckedNeg(math.MaxInt64); err != nil || got != -math.MaxInt64 {
+		t.Errorf("-MaxInt64: expected %d, got %d (%v)", int64(-math.MaxInt64), got, err)
+	}
+}
@@ -0,0 +1,56 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Panics
+//
+// panic(v) stops the program with a runtime error carrying v, for library
+// code to report a failure its callers can't be expected to check for. Like
+// any runtime error it unwinds to the innermost try, whose catch gets it as
+// "panic: " and v. recover() in the catch returns v itself, so the catch can
+// tell a panic from other errors and act on what it carries. It returns nil
+// if the error caught wasn't a panic, and after it has returned the value
+// once, so a later call, in that catch or after it, doesn't see it again.
+
+// PanicError is the runtime error panic(v) stops the program with
+type PanicError struct {
+	Value Value
+}
+
+func (e *PanicError) Error() string {
+	return "panic: " + e.Value.String()
+}
+
+// caughtPanic returns err as the panic recover() returns the value of in the
+// catch that caught it, or nil if err isn't a panic
+func caughtPanic(err error) *PanicError {
+	var panicErr *PanicError
+	if errors.As(err, &panicErr) {
+		return panicErr
+	}
+	return nil
+}
+
+// panicBuiltin implements panic(v)
+func (env *builtinEnv) panicBuiltin(args ...Value) (Value, error) {
+	if len(args) != 1 {
+		return NilValue(), fmt.Errorf("panic: wrong number of arguments. got=%d, want=1", len(args))
+	}
+	return NilValue(), &PanicError{Value: args[0]}
+}
+
+// recoverBuiltin implements recover()
+func (env *builtinEnv) recoverBuiltin(args ...Value) (Value, error) {
+	if len(args) != 0 {
+		return NilValue(), fmt.Errorf("recover: wrong number of arguments. got=%d, want=0", len(args))
+	}
+	if env.recovered == nil {
+		return NilValue(), nil
+	}
+	recovered := env.recovered.Value
+	env.recovered = nil
+	return recovered, nil
+}
@@ -0,0 +1,29 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestRecoverReturnsThePanicOnce(t *testing.T) {
+	env := &builtinEnv{}
+	_, err := env.panicBuiltin(IntValue(42))
+	if err == nil || err.Error() != "panic: 42" {
+		t.Fatalf("panic(42) error = %v, want panic: 42", err)
+	}
+
+	// A try catches the panic wrapped with its position
+	env.recovered = caughtPanic(fmt.Errorf("1:1: %w", err))
+	if got, _ := env.recoverBuiltin(); got.Type != IntType || got.AsInt() != 42 {
+		t.Errorf("recover() = %s, want 42", got.String())
+	}
+	if got, _ := env.recoverBuiltin(); got.Type != NilType {
+		t.Errorf("second recover() = %s, want nil", got.String())
+	}
+
+	env.recovered = caughtPanic(errors.New("division by zero"))
+	if got, _ := env.recoverBuiltin(); got.Type != NilType {
+		t.Errorf("recover() after a runtime error = %s, want nil", got.String())
+	}
+}
@@ -0,0 +1,207 @@
+package vm
+
+// Peephole optimization
+//
+// The compiler lowers one statement at a time, which leaves short patterns
+// that a look at neighbouring instructions can simplify:
+//
+//   - a value pushed and popped right away (PUSH c; POP, LOAD_LOCAL x; POP)
+//     is dropped
+//   - LOAD_LOCAL x; STORE_LOCAL x (and the global form) is dropped
+//   - STORE_LOCAL x; LOAD_LOCAL x becomes DUP; STORE_LOCAL x
+//   - a jump to the next instruction is dropped, or becomes POP if conditional
+//   - a jump to an unconditional jump goes straight to its final target
+//
+// Only the first instruction of a pattern may be a jump target, so code that
+// jumps into a pattern keeps its meaning. In the main program the last value
+// popped is the program's result (LastPoppedStackElem), so the final pop is
+// kept. Jump operands and line tables are remapped to the new offsets.
+
+// Optimize returns a copy of bytecode with the peephole patterns applied to
+// the main program and every function, and its globals compacted (see
+// CompactGlobals). The input is not modified.
+func Optimize(bytecode *Bytecode) *Bytecode {
+	constants := make([]Value, len(bytecode.Constants))
+	for i, constant := range bytecode.Constants {
+		if constant.Type == FunctionType {
+			fn := *constant.AsFunction()
+			fn.Instructions, fn.Lines = optimizeInstructions(fn.Instructions, fn.Lines, false)
+			constant = NewFunctionValue(&fn)
+		}
+		constants[i] = constant
+	}
+
+	optimized := *bytecode
+	optimized.Instructions, optimized.Lines = optimizeInstructions(bytecode.Instructions, bytecode.Lines, true)
+	optimized.Constants = constants
+	return CompactGlobals(&optimized)
+}
+
+// optimizeInstructions applies the patterns until none match. keepResult
+// preserves the value of the last pop.
+func optimizeInstructions(ins []byte, lines LineTable, keepResult bool) ([]byte, LineTable) {
+	for {
+		optimized, optimizedLines, changed := peepholePass(ins, lines, keepResult)
+		if !changed {
+			return ins, lines
+		}
+		ins, lines = optimized, optimizedLines
+	}
+}
+
+// peepholePass rewrites each pattern once and reports whether any matched
+func peepholePass(ins []byte, lines LineTable, keepResult bool) ([]byte, LineTable, bool) {
+	decoded := decodeStackInstructions(ins)
+	index := make(map[int]int, len(decoded)) // ip -> decoded index
+	targets := make(map[int]bool)
+	resultPop := -1 // Index of the last instruction that pops, if kept
+	for i, in := range decoded {
+		index[in.ip] = i
+		if hasTarget(in.op) {
+			targets[in.operands[0]] = true
+		}
+		if pops, _, _ := stackEffect(in.op, in.operands); keepResult && pops > 0 {
+			resultPop = i
+		}
+	}
+
+	changed := false
+	var out []stackInstruction
+	first := make([]int, len(decoded)+1) // decoded index -> first out index at or after it
+	for i := 0; i < len(decoded); i++ {
+		first[i] = len(out)
+		cur := decoded[i]
+
+		if isJump(cur.op) {
+			if target := finalJumpTarget(decoded, index, cur.operands[0]); target != cur.operands[0] {
+				cur.operands = []int{target}
+				changed = true
+			}
+			if cur.operands[0] == cur.next {
+				changed = true
+				if cur.op != OpJump {
+					out = append(out, stackInstruction{ip: cur.ip, op: OpPop})
+				}
+				continue
+			}
+		}
+
+		if i+1 < len(decoded) && !targets[decoded[i+1].ip] && i+1 != resultPop {
+			next := decoded[i+1]
+			switch {
+			case pushesWithoutEffect(cur.op) && next.op == OpPop,
+				isLoad(cur.op) && next.op == storeFor(cur.op) && next.operands[0] == cur.operands[0]:
+				first[i+1] = len(out)
+				i++
+				changed = true
+				continue
+			case isStore(cur.op) && next.op == loadFor(cur.op) && next.operands[0] == cur.operands[0]:
+				out = append(out,
+					stackInstruction{ip: cur.ip, op: OpDup},
+					stackInstruction{ip: next.ip, op: cur.op, operands: cur.operands})
+				first[i+1] = len(out) - 1
+				i++
+				changed = true
+				continue
+			}
+		}
+
+		out = append(out, cur)
+	}
+	first[len(decoded)] = len(out)
+
+	if !changed {
+		return ins, lines, false
+	}
+
+	// Lay out the new stream, then point jumps and line entries at it
+	newIPs := make([]int, len(out)+1)
+	for i, in := range out {
+		newIPs[i+1] = newIPs[i] + 1 + OperandWidth(in.op)*len(in.operands)
+	}
+	remap := func(ip int) int {
+		i, ok := index[ip]
+		if !ok {
+			i = len(decoded) // End of the stream
+		}
+		return newIPs[first[i]]
+	}
+
+	optimized := make([]byte, 0, newIPs[len(out)])
+	for _, in := range out {
+		operands := in.operands
+		if hasTarget(in.op) {
+			operands = []int{remap(operands[0])}
+		}
+		optimized = append(optimized, Make(in.op, operands...)...)
+	}
+
+	var optimizedLines LineTable
+	for _, entry := range lines {
+		// An entry whose code was removed gives way to the next one
+		optimizedLines = optimizedLines.Add(remap(entry.Offset), entry.Pos)
+	}
+	return optimized, optimizedLines, true
+}
+
+// finalJumpTarget follows unconditional jumps from target, stopping at a
+// cycle so a jump-to-self loop is left alone
+func finalJumpTarget(decoded []stackInstruction, index map[int]int, target int) int {
+	seen := map[int]bool{target: true}
+	for {
+		i, ok := index[target]
+		if !ok || decoded[i].op != OpJump {
+			return target
+		}
+		next := decoded[i].operands[0]
+		if seen[next] {
+			return target
+		}
+		seen[next] = true
+		target = next
+	}
+}
+
+func isJump(op OpCode) bool {
+	return op == OpJump || op == OpJumpIfFalse || op == OpJumpIfTrue
+}
+
+// hasTarget reports whether op's operand is an address: a jump, or a try's
+// catch
+func hasTarget(op OpCode) bool {
+	return isJump(op) || op == OpTry
+}
+
+// pushesWithoutEffect reports whether op only pushes a value, so pushing and
+// popping it right away does nothing
+func pushesWithoutEffect(op OpCode) bool {
+	switch op {
+	case OpPush, OpPushWide, OpCopyConst, OpCopyConstWide, OpLoadLocal, OpLoadGlobal, OpLoadFree, OpGetBuiltin, OpDup:
+		return true
+	}
+	return false
+}
+
+func isLoad(op OpCode) bool {
+	return op == OpLoadLocal || op == OpLoadGlobal
+}
+
+func isStore(op OpCode) bool {
+	return op == OpStoreLocal || op == OpStoreGlobal
+}
+
+// storeFor returns the store matching a load of the same kind of variable
+func storeFor(load OpCode) OpCode {
+	if load == OpLoadLocal {
+		return OpStoreLocal
+	}
+	return OpStoreGlobal
+}
+
+// loadFor returns the load matching a store of the same kind of variable
+func loadFor(store OpCode) OpCode {
+	if store == OpStoreLocal {
+		return OpLoadLocal
+	}
+	return OpLoadGlobal
+}
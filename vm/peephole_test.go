@@ -0,0 +1,104 @@
+package vm
+
+import (
+	"testing"
+)
+
+func TestOptimizePatterns(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    []byte
+		expected []byte
+	}{
+		{
+			"push then pop",
+			concatInstructions(Make(OpPush, 0), Make(OpPop), Make(OpLoadLocal, 1), Make(OpPop), Make(OpReturn)),
+			Make(OpReturn),
+		},
+		{
+			"self assignment",
+			concatInstructions(Make(OpLoadLocal, 1), Make(OpStoreLocal, 1), Make(OpReturn)),
+			Make(OpReturn),
+		},
+		{
+			"store then load",
+			concatInstructions(Make(OpStoreLocal, 2), Make(OpLoadLocal, 2), Make(OpReturn)),
+			concatInstructions(Make(OpDup), Make(OpStoreLocal, 2), Make(OpReturn)),
+		},
+		{
+			"different slots are kept",
+			concatInstructions(Make(OpStoreLocal, 2), Make(OpLoadLocal, 3), Make(OpReturn)),
+			concatInstructions(Make(OpStoreLocal, 2), Make(OpLoadLocal, 3), Make(OpReturn)),
+		},
+		{
+			"jump to next",
+			concatInstructions(Make(OpJump, 5), Make(OpLoadLocal, 0), Make(OpLoadLocal, 1), Make(OpEq), Make(OpJumpIfFalse, 17), Make(OpReturn)),
+			concatInstructions(Make(OpLoadLocal, 0), Make(OpLoadLocal, 1), Make(OpEq), Make(OpPop), Make(OpReturn)),
+		},
+		{
+			// 0: JUMP_IF_FALSE 8; 5: PUSH 0; 8: POP; 9: JUMP 15; 14: RETURN; 15: JUMP 14.
+			// The POP is a jump target, and JUMP 15 leads to the next instruction.
+			"jump chain and pop targeted by a jump",
+			concatInstructions(Make(OpJumpIfFalse, 8), Make(OpPush, 0), Make(OpPop), Make(OpJump, 15), Make(OpReturn), Make(OpJump, 14)),
+			concatInstructions(Make(OpJumpIfFalse, 8), Make(OpPush, 0), Make(OpPop), Make(OpReturn), Make(OpJump, 9)),
+		},
+		{
+			// 0: TRY 15; 5: PUSH 0; 8: POP; 9: END_TRY; 10: JUMP 16; 15: POP; 16: RETURN.
+			// The catch moves up with the code before it.
+			"try keeps its catch",
+			concatInstructions(Make(OpTry, 15), Make(OpPush, 0), Make(OpPop), Make(OpEndTry), Make(OpJump, 16), Make(OpPop), Make(OpReturn)),
+			concatInstructions(Make(OpTry, 11), Make(OpEndTry), Make(OpJump, 12), Make(OpPop), Make(OpReturn)),
+		},
+	}
+
+	for _, tt := range tests {
+		fn := &Function{Name: "f", Instructions: tt.input}
+		optimized := Optimize(&Bytecode{Constants: []Value{NewFunctionValue(fn)}})
+
+		got := optimized.Constants[0].AsFunction().Instructions
+		if string(got) != string(tt.expected) {
+			t.Errorf("%s: expected\n%s\ngot\n%s", tt.name, Disassemble(tt.expected), Disassemble(got))
+		}
+		if string(fn.Instructions) != string(tt.input) {
+			t.Errorf("%s: the input function was modified", tt.name)
+		}
+	}
+}
+
+func TestOptimizeKeepsResultAndLines(t *testing.T) {
+	// 5; x = 1; 7 — the final PUSH 7; POP is the program's result
+	bytecode := &Bytecode{
+		Instructions: concatInstructions(
+			Make(OpPush, 0), Make(OpPop),
+			Make(OpPush, 1), Make(OpStoreGlobal, 0),
+			Make(OpPush, 2), Make(OpPop),
+		),
+		Constants: []Value{IntValue(5), IntValue(1), IntValue(7)},
+		Lines: LineTable{
+			{Offset: 0, Pos: Position{Line: 1, Column: 1}},
+			{Offset: 4, Pos: Position{Line: 2, Column: 1}},
+			{Offset: 10, Pos: Position{Line: 3, Column: 1}},
+		},
+	}
+
+	optimized := Optimize(bytecode)
+	expected := concatInstructions(Make(OpPush, 1), Make(OpStoreGlobal, 0), Make(OpPush, 2), Make(OpPop))
+	if string(optimized.Instructions) != string(expected) {
+		t.Fatalf("expected\n%s\ngot\n%s", Disassemble(expected), Disassemble(optimized.Instructions))
+	}
+
+	if pos, _ := optimized.Lines.Lookup(0); pos.Line != 2 {
+		t.Errorf("expected offset 0 on line 2, got %v", pos)
+	}
+	if pos, _ := optimized.Lines.Lookup(6); pos.Line != 3 {
+		t.Errorf("expected offset 6 on line 3, got %v", pos)
+	}
+
+	machine := New(optimized)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("run failed: %v", err)
+	}
+	if result := machine.LastPoppedStackElem(); result.AsInt() != 7 {
+		t.Errorf("expected result 7, got %s", result.String())
+	}
+}
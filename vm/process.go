@@ -0,0 +1,70 @@
+package vm
+
+import (
+	"fmt"
+	"os"
+)
+
+// Process
+//
+// getenv(name) is the value of an environment variable, or nil if it isn't
+// set, and setenv(name, value) sets one for the rest of the program and the
+// programs it starts. exit(code) ends the program with an exit status, 0 if
+// left out. It doesn't stop the process where it stands: the builtin returns
+// an *ExitError, which unwinds the program the way any error does, and the
+// VM then runs the exit hooks and flushes output before Run returns it, so a
+// script cleans up the same however it ends. cmd/minlang passes the status
+// on to the shell.
+
+// ExitError is the error Run returns when a program calls exit with a status
+// other than 0
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// getenvBuiltin implements getenv(name)
+func (env *builtinEnv) getenvBuiltin(args ...Value) (Value, error) {
+	strs, err := stringArgs("getenv", 1, args)
+	if err != nil {
+		return NilValue(), err
+	}
+	value, ok := os.LookupEnv(strs[0])
+	if !ok {
+		return NilValue(), nil
+	}
+	return StringValue(value), nil
+}
+
+// setenvBuiltin implements setenv(name, value)
+func (env *builtinEnv) setenvBuiltin(args ...Value) (Value, error) {
+	strs, err := stringArgs("setenv", 2, args)
+	if err != nil {
+		return NilValue(), err
+	}
+	if err := os.Setenv(strs[0], strs[1]); err != nil {
+		return NilValue(), fmt.Errorf("setenv: %v", err)
+	}
+	return NilValue(), nil
+}
+
+// exitBuiltin implements exit() and exit(code)
+func (env *builtinEnv) exitBuiltin(args ...Value) (Value, error) {
+	if len(args) > 1 {
+		return NilValue(), fmt.Errorf("exit: wrong number of arguments. got=%d, want=0 or 1", len(args))
+	}
+	code := int64(0)
+	if len(args) == 1 {
+		if args[0].Type != IntType {
+			return NilValue(), fmt.Errorf("exit: status must be int")
+		}
+		code = args[0].AsInt()
+	}
+	if code < 0 || code > 255 {
+		return NilValue(), fmt.Errorf("exit: status %d out of range 0 to 255", code)
+	}
+	return NilValue(), &ExitError{Code: int(code)}
+}
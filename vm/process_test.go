@@ -0,0 +1,21 @@
+package vm
+
+import "testing"
+
+func TestGetenv(t *testing.T) {
+	t.Setenv("MINLANG_TEST_VAR", "before")
+
+	got, err := defaultBuiltinEnv.getenvBuiltin(StringValue("MINLANG_TEST_VAR"))
+	if err != nil || got.AsString() != "before" {
+		t.Fatalf("getenv = %s, %v, want before", got.String(), err)
+	}
+	if _, err := defaultBuiltinEnv.setenvBuiltin(StringValue("MINLANG_TEST_VAR"), StringValue("after")); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := defaultBuiltinEnv.getenvBuiltin(StringValue("MINLANG_TEST_VAR")); got.AsString() != "after" {
+		t.Errorf("getenv after setenv = %s, want after", got.String())
+	}
+	if got, _ := defaultBuiltinEnv.getenvBuiltin(StringValue("MINLANG_TEST_UNSET")); got.Type != NilType {
+		t.Errorf("getenv of an unset variable = %s, want nil", got.String())
+	}
+}
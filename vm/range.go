@@ -0,0 +1,51 @@
+package vm
+
+import "fmt"
+
+// Ranges
+//
+// range(end), range(start, end) and range(start, end, step) are arrays of the
+// ints from start, 0 if left out, up to but not including end, counting by
+// step, 1 if left out. A negative step counts down, stopping above end, so
+// range(3, 0, -1) is [3, 2, 1]. Compilers don't build the array when a range
+// is only looped over, see compiler.lowerForIn.
+
+// rangeBuiltin implements range, an array of evenly spaced ints
+func (env *builtinEnv) rangeBuiltin(args ...Value) (Value, error) {
+	if len(args) < 1 || len(args) > 3 {
+		return NilValue(), fmt.Errorf("range: wrong number of arguments. got=%d, want=1 to 3", len(args))
+	}
+	for _, arg := range args {
+		if arg.Type != IntType {
+			return NilValue(), fmt.Errorf("range: arguments must be int")
+		}
+	}
+
+	start, end, step := int64(0), args[0].AsInt(), int64(1)
+	if len(args) > 1 {
+		start, end = args[0].AsInt(), args[1].AsInt()
+	}
+	if len(args) > 2 {
+		step = args[2].AsInt()
+	}
+	if step == 0 {
+		return NilValue(), fmt.Errorf("range: step must not be zero")
+	}
+
+	elements := make([]Value, rangeLength(start, end, step))
+	for i := range elements {
+		elements[i] = IntValue(start + int64(i)*step)
+	}
+	return NewArrayFromElements(elements), nil
+}
+
+// rangeLength returns the number of ints from start to end by step
+func rangeLength(start, end, step int64) int64 {
+	if step > 0 && start < end {
+		return (end - start + step - 1) / step
+	}
+	if step < 0 && start > end {
+		return (start - end - step - 1) / -step
+	}
+	return 0
+}
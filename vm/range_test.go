@@ -0,0 +1,60 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRange(t *testing.T) {
+	tests := []struct {
+		args []int64
+		want string
+	}{
+		{[]int64{4}, "[0 1 2 3]"},
+		{[]int64{0}, "[]"},
+		{[]int64{-3}, "[]"},
+		{[]int64{2, 5}, "[2 3 4]"},
+		{[]int64{5, 2}, "[]"},
+		{[]int64{0, 10, 3}, "[0 3 6 9]"},
+		{[]int64{0, 9, 3}, "[0 3 6]"},
+		{[]int64{3, 0, -1}, "[3 2 1]"},
+		{[]int64{10, -1, -4}, "[10 6 2]"},
+	}
+
+	for _, tt := range tests {
+		args := make([]Value, len(tt.args))
+		for i, arg := range tt.args {
+			args[i] = IntValue(arg)
+		}
+		result, err := defaultBuiltinEnv.rangeBuiltin(args...)
+		if err != nil {
+			t.Fatalf("range%v: %v", tt.args, err)
+		}
+		var got []int64
+		for _, elem := range result.AsArray().Elements {
+			got = append(got, elem.AsInt())
+		}
+		if s := fmt.Sprint(got); s != tt.want {
+			t.Errorf("range%v = %s, want %s", tt.args, s, tt.want)
+		}
+	}
+}
+
+func TestRangeErrors(t *testing.T) {
+	tests := []struct {
+		args []Value
+		want string
+	}{
+		{nil, "wrong number of arguments"},
+		{[]Value{IntValue(0), IntValue(5), IntValue(0)}, "step must not be zero"},
+		{[]Value{FloatValue(2.5)}, "arguments must be int"},
+	}
+
+	for _, tt := range tests {
+		_, err := defaultBuiltinEnv.rangeBuiltin(tt.args...)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("range%v: got error %v, want %q", tt.args, err, tt.want)
+		}
+	}
+}
@@ -1,5 +1,12 @@
 package vm
 
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
 // RegisterOpCode represents a register VM instruction
 type RegisterOpCode byte
 
@@ -44,10 +51,10 @@ const (
 	OpROr  // R(A) = R(B) || R(C)
 	OpRNot // R(A) = !R(B)
 
-	// Control flow
-	OpRJump    // PC = offset
-	OpRJumpT   // if R(A) then PC = offset
-	OpRJumpF   // if !R(A) then PC = offset
+	// Control flow (offsets are relative to the next instruction)
+	OpRJump    // PC += sAx
+	OpRJumpT   // if R(A) then PC += sBx
+	OpRJumpF   // if !R(A) then PC += sBx
 	OpRReturn  // return R(A)...R(A+n)
 	OpRReturnN // return (no value)
 
@@ -85,9 +92,126 @@ const (
 	OpRSquareInt   // R(A) = R(B) * R(B) - int (for Mandelbrot)
 	OpRSquareFloat // R(A) = R(B) * R(B) - float (for Mandelbrot)
 
+	// Generic operations (runtime type dispatch, used by translated stack bytecode)
+	OpRAdd          // R(A) = R(B) + R(C) - any numeric or string
+	OpRSub          // R(A) = R(B) - R(C)
+	OpRMul          // R(A) = R(B) * R(C)
+	OpRDiv          // R(A) = R(B) / R(C)
+	OpRMod          // R(A) = R(B) % R(C)
+	OpRNeg          // R(A) = -R(B)
+	OpREq           // R(A) = R(B) == R(C)
+	OpRNe           // R(A) = R(B) != R(C)
+	OpRLt           // R(A) = R(B) < R(C)
+	OpRGt           // R(A) = R(B) > R(C)
+	OpRLe           // R(A) = R(B) <= R(C)
+	OpRGe           // R(A) = R(B) >= R(C)
+	OpRInvoke       // R(A) = R(A)(R(A+1)...R(A+B)) - call function, closure or builtin value
+	OpRLoadBuiltin  // R(A) = builtin[Bx]
+	OpRMakeClosure  // R(A) = closure(K(Bx), R(A)...R(A+NumFree-1))
+	OpRLoadFree     // R(A) = free[Bx]
+	OpRArrayFrom    // R(A) = [R(A)...R(A+B-1)]
+	OpRMapFrom      // R(A) = {R(A): R(A+1), ...} - B pairs
+	OpRStructFrom   // R(A) = R(A+2B){R(A): R(A+1), ...} - B fields, C != 0 keeps field order
+	OpRGetFieldName // R(A) = R(B).(R(C)) - by name
+	OpRSetFieldName // R(A).(R(B)) = R(C) - by name
+
+	OpRDivPromote // R(A) = float(R(B)) / float(R(C)) - int or float operands
+	OpRLoadKCopy  // R(A) = copy of K(Bx) - constant array or map
+
 	OpRHalt // Halt execution
+
+	// Constant indices too large for Bx, taken from the EXTRAARG after them
+	OpRLoadKX       // R(A) = K(Ax)
+	OpRLoadKCopyX   // R(A) = copy of K(Ax)
+	OpRMakeClosureX // R(A) = closure(K(Ax), R(A)...R(A+NumFree-1))
+	OpRExtraArg     // Ax - operand of the instruction before, never executed
+
+	// Runtime checks of type annotations (--runtime-checks)
+	OpRCheckType // Fail unless R(A) has type B, with message K(Ax) from the EXTRAARG after it
+
+	// Tagged unions, for enum payloads, results and optionals
+	OpRMakeVariant    // R(A) = variant with tag Bx carrying R(A)
+	OpRTestVariant    // R(A) = R(A) is a variant with tag Bx
+	OpRVariantTag     // R(A) = tag of variant R(B)
+	OpRVariantPayload // R(A) = payload of variant R(B)
+
+	// Intrinsics: builtins called with arguments known to be numeric. The
+	// float forms also take ints, which float variables may hold.
+	OpRAbsInt    // R(A) = abs(R(B)) - int
+	OpRAbsFloat  // R(A) = abs(R(B)) - float
+	OpRMinInt    // R(A) = min(R(B), R(C)) - int
+	OpRMinFloat  // R(A) = min(R(B), R(C)) - float
+	OpRMaxInt    // R(A) = max(R(B), R(C)) - int
+	OpRMaxFloat  // R(A) = max(R(B), R(C)) - float
+	OpRSqrtInt   // R(A) = sqrt(R(B)) - int, result float
+	OpRSqrtFloat // R(A) = sqrt(R(B)) - float
+
+	// Compare and branch: the comparison decides whether the OpRJump after
+	// it is taken, so a condition never becomes a bool in a register. C is
+	// the result that takes the jump; any other result skips it. > and >=
+	// swap their operands, and != takes the jump on the opposite result.
+	OpRJumpLtInt   // if (R(A) < R(B)) == C then take the next jump, else skip it - int
+	OpRJumpLtFloat // if (R(A) < R(B)) == C then take the next jump, else skip it - float
+	OpRJumpLeInt   // if (R(A) <= R(B)) == C then take the next jump, else skip it - int
+	OpRJumpLeFloat // if (R(A) <= R(B)) == C then take the next jump, else skip it - float
+	OpRJumpEqInt   // if (R(A) == R(B)) == C then take the next jump, else skip it - int
+	OpRJumpEqFloat // if (R(A) == R(B)) == C then take the next jump, else skip it - float
+
+	OpRWrapInt32 // R(A) = R(A) wrapped to 32 bits, if an int (int32 mode)
+
+	// Loads of the most common values, with no constant behind them
+	OpRLoadTrue  // R(A) = true
+	OpRLoadFalse // R(A) = false
+	OpRLoadNil   // R(A) = nil
+	OpRLoadZero  // R(A) = 0 - int
+
+	OpRSlice // R(A) = R(B)[R(C):R(C+1)], nil bounds defaulting
+
+	// Error handling: see try.go
+	OpRTry    // Catch errors at PC + sBx, putting them in R(A), until the matching OpREndTry
+	OpREndTry // Stop catching errors at the innermost OpRTry's address
+
+	// Struct and array case patterns
+	OpRTestStruct // R(A) = R(B) is a struct of the type named R(C)
+	OpRTestArray  // R(A) = R(A) is an array of Bx elements
+
+	// Arithmetic that fails where ints overflow: see overflow.go
+	OpRAddChecked // R(A) = R(B) + R(C), with an error if the int result overflows
+	OpRSubChecked // R(A) = R(B) - R(C), with an error if the int result overflows
+	OpRMulChecked // R(A) = R(B) * R(C), with an error if the int result overflows
+	OpRDivChecked // R(A) = R(B) / R(C), with an error if the int result overflows
+	OpRNegChecked // R(A) = -R(B), with an error if the int result overflows
+
+	OpRIn // R(A) = R(B) in R(C): see maps.go
+
+	// Tuples: see tuples.go
+	OpRTupleFrom // R(A) = (R(A)...R(A+B-1))
+	OpRTupleGet  // R(A) = R(B).C
+
+	// x++, x--, and x = x + k for a small whole number k
+	OpRInc // R(A) = R(A) + Bx - int or float
+	OpRDec // R(A) = R(A) - Bx - int or float
 )
 
+// ImmediateLoad returns the opcode that loads v with no constant, if v is
+// true, false, nil or the int 0
+func ImmediateLoad(v Value) (RegisterOpCode, bool) {
+	switch v.Type {
+	case BoolType:
+		if v.AsBool() {
+			return OpRLoadTrue, true
+		}
+		return OpRLoadFalse, true
+	case NilType:
+		return OpRLoadNil, true
+	case IntType:
+		if v.AsInt() == 0 {
+			return OpRLoadZero, true
+		}
+	}
+	return 0, false
+}
+
 // RegisterInstruction represents a 32-bit register instruction
 type RegisterInstruction uint32
 
@@ -118,6 +242,149 @@ func (ins RegisterInstruction) DecodeBx() (op RegisterOpCode, a uint8, bx uint16
 	return
 }
 
+// Jump offsets are signed and relative to the instruction after the jump.
+// OpRJump has no register, so its offset fills A and Bx (sAx); OpRJumpT and
+// OpRJumpF test R(A) and keep the offset in Bx (sBx), as OpRTry does with the
+// offset of its catch.
+const (
+	MaxJumpOffset     = 1<<23 - 1 // Largest sAx
+	MaxCondJumpOffset = 1<<15 - 1 // Largest sBx
+)
+
+// EncodeRegisterJump creates a jump at pc to target, testing R(a) for the
+// conditional jumps. It fails if the distance doesn't fit the offset.
+func EncodeRegisterJump(op RegisterOpCode, a uint8, pc, target int) (RegisterInstruction, error) {
+	offset := target - (pc + 1)
+	if op == OpRJump {
+		if offset < -MaxJumpOffset-1 || offset > MaxJumpOffset {
+			return 0, fmt.Errorf("jump from %04d to %04d is too far (max %d instructions)", pc, target, MaxJumpOffset)
+		}
+		return RegisterInstruction(uint32(op)<<24 | uint32(offset)&0xFFFFFF), nil
+	}
+	if offset < -MaxCondJumpOffset-1 || offset > MaxCondJumpOffset {
+		return 0, fmt.Errorf("conditional jump from %04d to %04d is too far (max %d instructions)", pc, target, MaxCondJumpOffset)
+	}
+	return EncodeRegisterInstructionBx(op, a, uint16(offset)), nil
+}
+
+// JumpOffset returns the signed offset of a jump instruction
+func (ins RegisterInstruction) JumpOffset() int {
+	if RegisterOpCode(ins>>24) == OpRJump {
+		return int(int32(ins<<8) >> 8)
+	}
+	return int(int16(ins))
+}
+
+// FarJump reports whether op, a conditional jump at pc, can't reach target
+// with its offset. The compilers leave such jumps for LongJumps.
+func FarJump(op RegisterOpCode, pc, target int) bool {
+	offset := target - (pc + 1)
+	return (op == OpRJumpT || op == OpRJumpF) && (offset < -MaxCondJumpOffset-1 || offset > MaxCondJumpOffset)
+}
+
+// LongJumps rewrites code so that the conditional jumps in far, which map
+// their pcs to their targets, reach them: each becomes the opposite jump
+// over an OpRJump, whose offset is wider. Every other jump, and lines, are
+// moved to match, and a conditional jump the moves take out of range is
+// made long in turn.
+func LongJumps(code []RegisterInstruction, lines LineTable, far map[int]int) ([]RegisterInstruction, LineTable, error) {
+	for len(far) > 0 {
+		// Each long jump moves what comes after it down one
+		longPCs := slices.Sorted(maps.Keys(far))
+		moved := func(pc int) int {
+			n, _ := slices.BinarySearch(longPCs, pc)
+			return pc + n
+		}
+
+		out := make([]RegisterInstruction, 0, len(code)+len(far))
+		next := make(map[int]int)
+		for pc, ins := range code {
+			op, a, _ := ins.DecodeBx()
+			switch op {
+			case OpRJump, OpRJumpT, OpRJumpF, OpRTry:
+			default:
+				out = append(out, ins)
+				continue
+			}
+
+			if target, ok := far[pc]; ok {
+				opposite := OpRJumpT
+				if op == OpRJumpT {
+					opposite = OpRJumpF
+				}
+				jump, err := EncodeRegisterJump(OpRJump, 0, len(out)+1, moved(target))
+				if err != nil {
+					return nil, nil, err
+				}
+				out = append(out, EncodeRegisterInstructionBx(opposite, a, 1), jump)
+				continue
+			}
+
+			target := moved(pc + 1 + ins.JumpOffset())
+			if FarJump(op, len(out), target) {
+				next[len(out)] = target
+				out = append(out, ins)
+				continue
+			}
+			jump, err := EncodeRegisterJump(op, a, len(out), target)
+			if err != nil {
+				return nil, nil, err
+			}
+			out = append(out, jump)
+		}
+
+		movedLines := make(LineTable, len(lines))
+		for i, entry := range lines {
+			movedLines[i] = LineEntry{Offset: moved(entry.Offset), Pos: entry.Pos}
+		}
+		code, lines, far = out, movedLines, next
+	}
+	return code, lines, nil
+}
+
+// MaxExtraArg is the largest operand an EXTRAARG holds
+const MaxExtraArg = 1<<24 - 1
+
+// EncodeRegisterConstant creates op, which reads constant index from Bx, as
+// one instruction, or as op's X form and an EXTRAARG holding index when index
+// is too large for Bx
+func EncodeRegisterConstant(op RegisterOpCode, a uint8, index int) ([]RegisterInstruction, error) {
+	if index <= 0xFFFF {
+		return []RegisterInstruction{EncodeRegisterInstructionBx(op, a, uint16(index))}, nil
+	}
+	if index > MaxExtraArg {
+		return nil, fmt.Errorf("constant index %d is too large (max %d)", index, MaxExtraArg)
+	}
+
+	var wide RegisterOpCode
+	switch op {
+	case OpRLoadK:
+		wide = OpRLoadKX
+	case OpRLoadKCopy:
+		wide = OpRLoadKCopyX
+	case OpRMakeClosure:
+		wide = OpRMakeClosureX
+	default:
+		return nil, fmt.Errorf("%s has no form for constant index %d", op, index)
+	}
+	return []RegisterInstruction{
+		EncodeRegisterInstructionBx(wide, a, 0),
+		RegisterInstruction(uint32(OpRExtraArg)<<24 | uint32(index)),
+	}, nil
+}
+
+// EncodeRegisterCheckType creates a check that R(a) has type want, failing
+// with the message in constant index, and the EXTRAARG holding index
+func EncodeRegisterCheckType(a uint8, want ValueType, index int) ([]RegisterInstruction, error) {
+	if index > MaxExtraArg {
+		return nil, fmt.Errorf("constant index %d is too large (max %d)", index, MaxExtraArg)
+	}
+	return []RegisterInstruction{
+		EncodeRegisterInstruction(OpRCheckType, a, uint8(want), 0),
+		RegisterInstruction(uint32(OpRExtraArg)<<24 | uint32(index)),
+	}, nil
+}
+
 // String returns the string representation of a register opcode
 func (op RegisterOpCode) String() string {
 	switch op {
@@ -235,9 +502,222 @@ func (op RegisterOpCode) String() string {
 		return "SQUARE_INT"
 	case OpRSquareFloat:
 		return "SQUARE_FLOAT"
+	case OpRAdd:
+		return "ADD"
+	case OpRSub:
+		return "SUB"
+	case OpRMul:
+		return "MUL"
+	case OpRDiv:
+		return "DIV"
+	case OpRMod:
+		return "MOD"
+	case OpRNeg:
+		return "NEG"
+	case OpREq:
+		return "EQ"
+	case OpRNe:
+		return "NE"
+	case OpRLt:
+		return "LT"
+	case OpRGt:
+		return "GT"
+	case OpRLe:
+		return "LE"
+	case OpRGe:
+		return "GE"
+	case OpRInvoke:
+		return "INVOKE"
+	case OpRLoadBuiltin:
+		return "LOADBUILTIN"
+	case OpRMakeClosure:
+		return "CLOSURE"
+	case OpRLoadFree:
+		return "LOADFREE"
+	case OpRArrayFrom:
+		return "ARRAYFROM"
+	case OpRMapFrom:
+		return "MAPFROM"
+	case OpRStructFrom:
+		return "STRUCTFROM"
+	case OpRGetFieldName:
+		return "GETFIELDNAME"
+	case OpRSetFieldName:
+		return "SETFIELDNAME"
+	case OpRDivPromote:
+		return "DIVPROMOTE"
+	case OpRLoadKCopy:
+		return "LOADKCOPY"
 	case OpRHalt:
 		return "HALT"
+	case OpRLoadKX:
+		return "LOADKX"
+	case OpRLoadKCopyX:
+		return "LOADKCOPYX"
+	case OpRMakeClosureX:
+		return "CLOSUREX"
+	case OpRExtraArg:
+		return "EXTRAARG"
+	case OpRCheckType:
+		return "CHECKTYPE"
+	case OpRMakeVariant:
+		return "MAKEVARIANT"
+	case OpRTestVariant:
+		return "TESTVARIANT"
+	case OpRVariantTag:
+		return "VARIANTTAG"
+	case OpRVariantPayload:
+		return "VARIANTPAYLOAD"
+	case OpRTestStruct:
+		return "TESTSTRUCT"
+	case OpRTestArray:
+		return "TESTARRAY"
+	case OpRAddChecked:
+		return "ADD_CHECKED"
+	case OpRSubChecked:
+		return "SUB_CHECKED"
+	case OpRMulChecked:
+		return "MUL_CHECKED"
+	case OpRDivChecked:
+		return "DIV_CHECKED"
+	case OpRNegChecked:
+		return "NEG_CHECKED"
+	case OpRIn:
+		return "IN"
+	case OpRTupleFrom:
+		return "TUPLEFROM"
+	case OpRTupleGet:
+		return "TUPLEGET"
+	case OpRInc:
+		return "INC"
+	case OpRDec:
+		return "DEC"
+	case OpRAbsInt:
+		return "ABS_INT"
+	case OpRAbsFloat:
+		return "ABS_FLOAT"
+	case OpRMinInt:
+		return "MIN_INT"
+	case OpRMinFloat:
+		return "MIN_FLOAT"
+	case OpRMaxInt:
+		return "MAX_INT"
+	case OpRMaxFloat:
+		return "MAX_FLOAT"
+	case OpRSqrtInt:
+		return "SQRT_INT"
+	case OpRSqrtFloat:
+		return "SQRT_FLOAT"
+	case OpRJumpLtInt:
+		return "JUMPLT_INT"
+	case OpRJumpLtFloat:
+		return "JUMPLT_FLOAT"
+	case OpRJumpLeInt:
+		return "JUMPLE_INT"
+	case OpRJumpLeFloat:
+		return "JUMPLE_FLOAT"
+	case OpRJumpEqInt:
+		return "JUMPEQ_INT"
+	case OpRJumpEqFloat:
+		return "JUMPEQ_FLOAT"
+	case OpRWrapInt32:
+		return "WRAP_INT32"
+	case OpRLoadTrue:
+		return "LOADTRUE"
+	case OpRLoadFalse:
+		return "LOADFALSE"
+	case OpRLoadNil:
+		return "LOADNIL"
+	case OpRLoadZero:
+		return "LOADZERO"
+	case OpRSlice:
+		return "SLICE"
+	case OpRTry:
+		return "TRY"
+	case OpREndTry:
+		return "ENDTRY"
 	default:
 		return "UNKNOWN"
 	}
 }
+
+// RegisterDisassemble returns a readable listing of register instructions,
+// one per line with its pc. Operands are named by kind: R for registers, K
+// for constants, G for globals and F for captured variables; builtins are
+// shown by name, and counts and field offsets as plain numbers.
+func RegisterDisassemble(instructions []RegisterInstruction) string {
+	var out strings.Builder
+	for pc, ins := range instructions {
+		op, _, _, _ := ins.Decode()
+		line := fmt.Sprintf("%04d  %-14s %s", pc, op.String(), registerOperands(ins, pc))
+		out.WriteString(strings.TrimRight(line, " "))
+		out.WriteString("\n")
+	}
+	return out.String()
+}
+
+// registerOperands formats the operands of ins, found at pc, for
+// RegisterDisassemble
+func registerOperands(ins RegisterInstruction, pc int) string {
+	op, a, b, c := ins.Decode()
+	_, _, bx := ins.DecodeBx()
+
+	switch op {
+	case OpRLoadK, OpRLoadKCopy, OpRMakeClosure:
+		return fmt.Sprintf("R%d K%d", a, bx)
+	case OpRLoadGlobal, OpRStoreGlobal:
+		return fmt.Sprintf("R%d G%d", a, bx)
+	case OpRLoadFree:
+		return fmt.Sprintf("R%d F%d", a, bx)
+	case OpRLoadBuiltin:
+		return fmt.Sprintf("R%d %s", a, builtinName(int(bx)))
+	case OpRNewArray, OpRMakeVariant, OpRTestVariant, OpRTestArray, OpRInc, OpRDec:
+		return fmt.Sprintf("R%d %d", a, bx)
+	case OpRJump:
+		return fmt.Sprintf("-> %04d", pc+1+ins.JumpOffset())
+	case OpRJumpT, OpRJumpF, OpRTry:
+		return fmt.Sprintf("R%d -> %04d", a, pc+1+ins.JumpOffset())
+	case OpRJumpLtInt, OpRJumpLtFloat, OpRJumpLeInt, OpRJumpLeFloat, OpRJumpEqInt, OpRJumpEqFloat:
+		return fmt.Sprintf("R%d R%d %t", a, b, c != 0)
+
+	case OpRReturn, OpRNewMap, OpRNewStruct, OpRLoadKX, OpRLoadKCopyX, OpRMakeClosureX, OpRWrapInt32,
+		OpRLoadTrue, OpRLoadFalse, OpRLoadNil, OpRLoadZero:
+		return fmt.Sprintf("R%d", a)
+	case OpRExtraArg:
+		return fmt.Sprintf("K%d", ins&MaxExtraArg)
+	case OpRCheckType:
+		return fmt.Sprintf("R%d %s", a, ValueType(b))
+	case OpRReturnN, OpRHalt, OpREndTry:
+		return ""
+
+	case OpRMove, OpRNot, OpRNeg, OpRNegInt, OpRNegFloat, OpRSquareInt, OpRSquareFloat,
+		OpRVariantTag, OpRVariantPayload, OpRAbsInt, OpRAbsFloat, OpRSqrtInt, OpRSqrtFloat, OpRNegChecked:
+		return fmt.Sprintf("R%d R%d", a, b)
+	case OpRAddConstInt, OpRAddConstFloat, OpRMulConstInt, OpRMulConstFloat:
+		return fmt.Sprintf("R%d R%d K%d", a, b, c)
+	case OpRGetField, OpRTupleGet:
+		return fmt.Sprintf("R%d R%d %d", a, b, c)
+	case OpRSetField:
+		return fmt.Sprintf("R%d %d R%d", a, b, c)
+
+	case OpRBuiltin:
+		// B packs the builtin index and argument count into 4 bits each
+		return fmt.Sprintf("R%d %s R%d %d", a, builtinName(int(b&0x0F)), c, b>>4)
+	case OpRInvoke, OpRArrayFrom, OpRMapFrom, OpRTupleFrom:
+		return fmt.Sprintf("R%d %d", a, b)
+	case OpRStructFrom:
+		return fmt.Sprintf("R%d %d %d", a, b, c)
+
+	default:
+		return fmt.Sprintf("R%d R%d R%d", a, b, c)
+	}
+}
+
+// builtinName returns the name of the builtin at index, or the index itself
+// if there is no such builtin
+func builtinName(index int) string {
+	if names := BuiltinNames(); index < len(names) {
+		return names[index]
+	}
+	return fmt.Sprintf("builtin%d", index)
+}
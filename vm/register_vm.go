@@ -17,6 +17,8 @@ type RegisterFrame struct {
 	baseReg      int      // Base register for this frame
 	registers    []Value  // Local register window
 	resultReg    int      // Where to store return value in caller's frame
+	free         []Value  // Captured variables when called through a closure
+	constants    []Value  // The program's pool from the function's ConstantBase
 }
 
 // RegisterVM is a register-based virtual machine
@@ -33,22 +35,44 @@ type RegisterVM struct {
 
 	// Current frame cache (for performance)
 	currentFrame *RegisterFrame
+
+	env        *builtinEnv       // Output streams
+	builtinFns []BuiltinFunction // Builtins bound to env
+	builtins   []Value
+
+	resultReg int   // Main's register for the program's result, or -1
+	returned  Value // The value the last function to return returned
+	exiting   bool  // Main has finished and exit hooks are running
+
+	handlers []registerHandler // Active try blocks, innermost last
 }
 
 // NewRegisterVM creates a new register-based VM
-func NewRegisterVM(bytecode *RegisterBytecode) *RegisterVM {
+func NewRegisterVM(bytecode *RegisterBytecode, opts ...Option) *RegisterVM {
 	// Determine register count from main function's NumLocals
 	numRegs := bytecode.MainFunction.NumLocals
 	if numRegs < InitialRegs {
 		numRegs = InitialRegs
 	}
 
+	env, builtinFns, builtins := newConfig(opts).builtins(bytecode.Enums)
+
 	vm := &RegisterVM{
 		constants:  bytecode.Constants,
-		globals:    make([]Value, GlobalsSize),
+		globals:    make([]Value, globalsSize(bytecode.Globals)),
 		registers:  make([]Value, numRegs),
 		frames:     make([]*RegisterFrame, MaxFrames),
 		frameIndex: 0,
+		env:        env,
+		builtinFns: builtinFns,
+		builtins:   builtins,
+		resultReg:  bytecode.ResultReg,
+	}
+	env.call = vm.callValue
+
+	// The result is nil until an expression statement sets it
+	if vm.resultReg >= 0 {
+		vm.registers[vm.resultReg] = NilValue()
 	}
 
 	// Create main frame
@@ -58,6 +82,7 @@ func NewRegisterVM(bytecode *RegisterBytecode) *RegisterVM {
 		pc:           0,
 		baseReg:      0,
 		registers:    vm.registers, // Main frame uses full register file
+		constants:    vm.constants,
 	}
 
 	vm.frames[0] = mainFrame
@@ -67,40 +92,88 @@ func NewRegisterVM(bytecode *RegisterBytecode) *RegisterVM {
 	return vm
 }
 
+// LastValue returns the value of the last top-level expression statement the
+// program ran, like the stack VM's LastPoppedStackElem, or nil if there was
+// none
+func (vm *RegisterVM) LastValue() Value {
+	if vm.resultReg < 0 {
+		return NilValue()
+	}
+	return vm.registers[vm.resultReg]
+}
+
 // RegisterBytecode represents compiled register bytecode
 type RegisterBytecode struct {
 	Instructions []RegisterInstruction
 	Constants    []Value
 	MainFunction *Function
+	Enums        Enums // Enum definitions, for enumName and enumValue
+	ResultReg    int   // Main's register for the last top-level expression statement's value, or -1
+	Globals      int   // Global slots the program uses, or 0 for GlobalsSize
 }
 
 // Run executes the register bytecode
-func (vm *RegisterVM) Run() error {
+func (vm *RegisterVM) Run() (err error) {
+	// Write out buffered print output however the program ends
+	defer func() {
+		if flushErr := vm.env.flush(); err == nil {
+			err = flushErr
+		}
+	}()
+
+	// Annotate errors with the call stack and source positions
+	annotate := func(err error) error {
+		if err != nil && !isExit(err) {
+			return newRuntimeError(err, vm.stackTrace())
+		}
+		return err
+	}
+
+	err = annotate(FinishExit(vm.execute(0), vm.resumeAfterExit))
+	return vm.env.finishExitHooks(err, func() bool { return vm.exiting }, vm.resumeAfterExit, annotate)
+}
+
+// dispatch runs instructions until the frame at floor returns, or until the
+// program ends if floor is 0, stopping at the first error
+func (vm *RegisterVM) dispatch(floor int) (err error) {
 	frame := vm.currentFrame
 	ins := frame.instructions
 	pc := frame.pc
 	regs := frame.registers
 
+	// Leave the pc of the failing frame at its instruction, for stackTrace
+	defer func() {
+		if err != nil {
+			frame.pc = pc
+		}
+	}()
+
 	// Cache frequently accessed VM fields to reduce pointer dereferences
-	constants := vm.constants
+	constants := frame.constants
 	globals := vm.globals
 
 	// Main execution loop
 	for {
 		if pc >= len(ins) {
-			// Check if we're in main frame
+			// Main has finished: run the exit hooks, then exit
 			if vm.frameIndex <= 1 {
-				return nil
-			}
-			// Return from function with no value
-			if err := vm.returnFromFunction(0); err != nil {
+				frame.pc = pc
+				if started, err := vm.startExitHook(); err != nil || !started {
+					return err
+				}
+			} else if err := vm.returnFromFunction(0); err != nil {
+				// Return from function with no value
 				return err
 			}
+			if vm.frameIndex == floor {
+				return nil
+			}
 			// Reload frame
 			frame = vm.currentFrame
 			ins = frame.instructions
 			pc = frame.pc
 			regs = frame.registers
+			constants = frame.constants
 			continue
 		}
 
@@ -121,9 +194,25 @@ func (vm *RegisterVM) Run() error {
 			bx := uint16(instruction & 0xFFFF)
 			regs[a] = constants[bx]
 
+		case OpRLoadKCopy:
+			bx := uint16(instruction & 0xFFFF)
+			regs[a] = copyCollection(constants[bx])
+
 		case OpRMove:
 			regs[a] = regs[b]
 
+		case OpRLoadTrue:
+			regs[a] = BoolValue(true)
+
+		case OpRLoadFalse:
+			regs[a] = BoolValue(false)
+
+		case OpRLoadNil:
+			regs[a] = NilValue()
+
+		case OpRLoadZero:
+			regs[a] = IntValue(0)
+
 		// Arithmetic operations (NO TYPE CHECKS - compiler guarantees)
 		case OpRAddInt:
 			regs[a] = IntValue(regs[b].AsInt() + regs[c].AsInt())
@@ -157,6 +246,17 @@ func (vm *RegisterVM) Run() error {
 			}
 			regs[a] = FloatValue(regs[b].AsFloat() / divisor)
 
+		case OpRDivPromote:
+			dividend, okLeft := numericAsFloat(regs[b])
+			divisor, okRight := numericAsFloat(regs[c])
+			if !okLeft || !okRight {
+				return ErrUnsupportedOperands
+			}
+			if divisor == 0 {
+				return ErrDivisionByZero
+			}
+			regs[a] = FloatValue(dividend / divisor)
+
 		case OpRModInt:
 			divisor := regs[c].AsInt()
 			if divisor == 0 {
@@ -231,19 +331,68 @@ func (vm *RegisterVM) Run() error {
 
 		// Control flow
 		case OpRJump:
-			bx := uint16(instruction & 0xFFFF)
-			pc = int(bx)
+			// sAx format: bottom 24 bits hold a signed offset
+			pc += int(int32(instruction<<8) >> 8)
 
 		case OpRJumpT:
 			if regs[a].IsTruthy() {
-				bx := uint16(instruction & 0xFFFF)
-				pc = int(bx)
+				pc += int(int16(instruction))
 			}
 
 		case OpRJumpF:
 			if !regs[a].IsTruthy() {
-				bx := uint16(instruction & 0xFFFF)
-				pc = int(bx)
+				pc += int(int16(instruction))
+			}
+
+		case OpRTry:
+			catchPC := pc + int(int16(instruction))
+			vm.handlers = append(vm.handlers, registerHandler{frame: vm.frameIndex, pc: catchPC, reg: int(a)})
+
+		case OpREndTry:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+		// Compare and branch: take the OpRJump at pc when the comparison
+		// gives C, without dispatching it, or skip it
+		case OpRJumpLtInt:
+			if (regs[a].AsInt() < regs[b].AsInt()) == (c != 0) {
+				pc += 1 + int(int32(ins[pc]<<8)>>8)
+			} else {
+				pc++
+			}
+
+		case OpRJumpLtFloat:
+			if (regs[a].AsFloat() < regs[b].AsFloat()) == (c != 0) {
+				pc += 1 + int(int32(ins[pc]<<8)>>8)
+			} else {
+				pc++
+			}
+
+		case OpRJumpLeInt:
+			if (regs[a].AsInt() <= regs[b].AsInt()) == (c != 0) {
+				pc += 1 + int(int32(ins[pc]<<8)>>8)
+			} else {
+				pc++
+			}
+
+		case OpRJumpLeFloat:
+			if (regs[a].AsFloat() <= regs[b].AsFloat()) == (c != 0) {
+				pc += 1 + int(int32(ins[pc]<<8)>>8)
+			} else {
+				pc++
+			}
+
+		case OpRJumpEqInt:
+			if (regs[a].AsInt() == regs[b].AsInt()) == (c != 0) {
+				pc += 1 + int(int32(ins[pc]<<8)>>8)
+			} else {
+				pc++
+			}
+
+		case OpRJumpEqFloat:
+			if (regs[a].AsFloat() == regs[b].AsFloat()) == (c != 0) {
+				pc += 1 + int(int32(ins[pc]<<8)>>8)
+			} else {
+				pc++
 			}
 
 		case OpRReturn:
@@ -252,21 +401,29 @@ func (vm *RegisterVM) Run() error {
 			if err := vm.returnFromFunction(int(a)); err != nil {
 				return err
 			}
+			if vm.frameIndex == floor {
+				return nil
+			}
 			// Reload frame after return
 			frame = vm.currentFrame
 			ins = frame.instructions
 			pc = frame.pc
 			regs = frame.registers
+			constants = frame.constants
 
 		case OpRReturnN:
 			frame.pc = pc
 			if err := vm.returnFromFunction(-1); err != nil {
 				return err
 			}
+			if vm.frameIndex == floor {
+				return nil
+			}
 			frame = vm.currentFrame
 			ins = frame.instructions
 			pc = frame.pc
 			regs = frame.registers
+			constants = frame.constants
 
 		// Function calls
 		case OpRCall:
@@ -276,7 +433,7 @@ func (vm *RegisterVM) Run() error {
 			// a = result register
 			// Decode number of arguments from next byte
 			frame.pc = pc
-			if err := vm.callFunction(int(b), int(c), int(a)); err != nil {
+			if err := vm.callFunction(regs[b], int(c), int(a), -1); err != nil {
 				return err
 			}
 			// Reload frame
@@ -284,6 +441,7 @@ func (vm *RegisterVM) Run() error {
 			ins = frame.instructions
 			pc = frame.pc
 			regs = frame.registers
+			constants = frame.constants
 
 		case OpRBuiltin:
 			// R(A) = builtin[B](R(C)...R(C+n))
@@ -320,7 +478,17 @@ func (vm *RegisterVM) Run() error {
 				if idx < 0 || idx >= len(str) {
 					return fmt.Errorf("string index out of bounds: %d", idx)
 				}
-				regs[a] = StringValue(string(str[idx]))
+				regs[a] = StringValue(str[idx : idx+1])
+
+			case MapType:
+				if val, ok := container.AsMap().Pairs[index.ToMapKey()]; ok {
+					regs[a] = val
+				} else {
+					regs[a] = NilValue()
+				}
+
+			default:
+				return fmt.Errorf("index operator not supported for type %d", container.Type)
 			}
 
 		case OpRSetIdx:
@@ -329,12 +497,22 @@ func (vm *RegisterVM) Run() error {
 			index := regs[b]
 			value := regs[c]
 
-			idx := int(index.AsInt())
-			arrayVal := container.AsArray()
-			if idx < 0 || idx >= len(arrayVal.Elements) {
-				return fmt.Errorf("array index out of bounds: %d", idx)
+			switch container.Type {
+			case ArrayType:
+				idx := int(index.AsInt())
+				arrayVal := container.AsArray()
+				if idx < 0 || idx >= len(arrayVal.Elements) {
+					return fmt.Errorf("array index out of bounds: %d", idx)
+				}
+				arrayVal.Set(idx, value)
+
+			case MapType:
+				// The register compiler doesn't know container types, so map stores arrive here too
+				container.AsMap().Set(index.ToMapKey(), value)
+
+			default:
+				return fmt.Errorf("index assignment not supported for type %d", container.Type)
 			}
-			arrayVal.Elements[idx] = value
 
 		// Map operations
 		case OpRNewMap:
@@ -352,15 +530,13 @@ func (vm *RegisterVM) Run() error {
 
 		case OpRMapSet:
 			// R(A)[R(B)] = R(C)
-			mapVal := regs[a].AsMap()
-			key := regs[b].ToMapKey()
-			value := regs[c]
-			mapVal.Pairs[key] = value
+			regs[a].AsMap().Set(regs[b].ToMapKey(), regs[c])
 
 		// Struct operations
 		case OpRNewStruct:
-			// TODO: Implement struct creation
-			regs[a] = NilValue()
+			// Struct literals are built by OpRStructFrom, which has their
+			// field names and values
+			return fmt.Errorf("OpRNewStruct is not supported, build structs with OpRStructFrom")
 
 		case OpRGetField:
 			// R(A) = R(B).field(C) - C is field offset
@@ -372,11 +548,9 @@ func (vm *RegisterVM) Run() error {
 
 		case OpRSetField:
 			// R(A).field(B) = R(C) - B is field offset
-			structVal := regs[a].AsStruct()
-			if int(b) >= len(structVal.FieldsArray) {
+			if !regs[a].AsStruct().SetFieldAt(int(b), regs[c]) {
 				return fmt.Errorf("field offset out of bounds: %d", b)
 			}
-			structVal.FieldsArray[b] = regs[c]
 
 		// Global operations
 		case OpRLoadGlobal:
@@ -413,8 +587,299 @@ func (vm *RegisterVM) Run() error {
 			val := regs[b].AsFloat()
 			regs[a] = FloatValue(val * val)
 
+		// Intrinsics, matching the builtins they stand in for
+		case OpRAbsInt:
+			if val := regs[b].AsInt(); val < 0 {
+				regs[a] = IntValue(-val)
+			} else {
+				regs[a] = regs[b]
+			}
+
+		case OpRAbsFloat:
+			regs[a] = absNumber(regs[b])
+
+		case OpRMinInt:
+			if regs[b].AsInt() < regs[c].AsInt() {
+				regs[a] = regs[b]
+			} else {
+				regs[a] = regs[c]
+			}
+
+		case OpRMinFloat:
+			regs[a] = minNumbers(regs[b], regs[c])
+
+		case OpRMaxInt:
+			if regs[b].AsInt() > regs[c].AsInt() {
+				regs[a] = regs[b]
+			} else {
+				regs[a] = regs[c]
+			}
+
+		case OpRMaxFloat:
+			regs[a] = maxNumbers(regs[b], regs[c])
+
+		case OpRSqrtInt, OpRSqrtFloat:
+			val := float64(regs[b].AsInt())
+			if op == OpRSqrtFloat {
+				val, _ = numericAsFloat(regs[b])
+			}
+			result, err := squareRoot(val)
+			if err != nil {
+				return err
+			}
+			regs[a] = result
+
+		case OpRWrapInt32:
+			regs[a] = WrapInt32(regs[a])
+
+		case OpRSlice:
+			result, err := Slice(regs[b], regs[c], regs[c+1])
+			if err != nil {
+				return err
+			}
+			regs[a] = result
+
+		// Generic operations (runtime type dispatch)
+		case OpRAdd, OpRSub, OpRMul, OpRDiv, OpRMod:
+			result, err := genericArithmetic(op, regs[b], regs[c])
+			if err != nil {
+				return err
+			}
+			regs[a] = result
+
+		case OpREq, OpRNe, OpRLt, OpRGt, OpRLe, OpRGe:
+			result, err := genericComparison(op, regs[b], regs[c])
+			if err != nil {
+				return err
+			}
+			regs[a] = result
+
+		case OpRNeg:
+			switch regs[b].Type {
+			case IntType:
+				regs[a] = IntValue(-regs[b].AsInt())
+			case FloatType:
+				regs[a] = FloatValue(-regs[b].AsFloat())
+			default:
+				return ErrUnsupportedNegation
+			}
+
+		case OpRAddChecked, OpRSubChecked, OpRMulChecked, OpRDivChecked:
+			operator, unchecked := checkedRegisterOperation(op)
+			if regs[b].Type == IntType && regs[c].Type == IntType {
+				result, err := CheckedInt(operator, regs[b].AsInt(), regs[c].AsInt())
+				if err != nil {
+					return err
+				}
+				regs[a] = IntValue(result)
+			} else {
+				result, err := genericArithmetic(unchecked, regs[b], regs[c])
+				if err != nil {
+					return err
+				}
+				regs[a] = result
+			}
+
+		case OpRNegChecked:
+			switch regs[b].Type {
+			case IntType:
+				result, err := CheckedNeg(regs[b].AsInt())
+				if err != nil {
+					return err
+				}
+				regs[a] = IntValue(result)
+			case FloatType:
+				regs[a] = FloatValue(-regs[b].AsFloat())
+			default:
+				return ErrUnsupportedNegation
+			}
+
+		case OpRTupleFrom:
+			elements := make([]Value, b)
+			copy(elements, regs[int(a):int(a)+int(b)])
+			regs[a] = NewTupleValue(elements)
+
+		case OpRTupleGet:
+			element, err := TupleElement(regs[b], int(c))
+			if err != nil {
+				return err
+			}
+			regs[a] = element
+
+		case OpRIn:
+			result, err := In(regs[b], regs[c])
+			if err != nil {
+				return err
+			}
+			regs[a] = result
+
+		case OpRInvoke:
+			// R(A) = R(A)(R(A+1)...R(A+B))
+			if regs[a].Type == BuiltinFunctionType {
+				argStart := int(a) + 1
+				result, err := regs[a].AsBuiltinFunction()(regs[argStart : argStart+int(b)]...)
+				if err != nil {
+					return err
+				}
+				regs[a] = result
+				break
+			}
+			frame.pc = pc
+			if err := vm.callFunction(regs[a], int(a)+1, int(a), int(b)); err != nil {
+				return err
+			}
+			frame = vm.currentFrame
+			ins = frame.instructions
+			pc = frame.pc
+			regs = frame.registers
+			constants = frame.constants
+
+		case OpRLoadBuiltin:
+			bx := uint16(instruction & 0xFFFF)
+			if int(bx) < len(vm.builtins) {
+				regs[a] = vm.builtins[bx]
+			} else {
+				regs[a] = NilValue()
+			}
+
+		case OpRMakeClosure:
+			bx := uint16(instruction & 0xFFFF)
+			fn := constants[bx].AsFunction()
+			free := make([]Value, fn.NumFree)
+			copy(free, regs[int(a):int(a)+fn.NumFree])
+			regs[a] = NewClosureValue(fn, free)
+
+		case OpRLoadFree:
+			bx := uint16(instruction & 0xFFFF)
+			regs[a] = frame.free[bx]
+
+		case OpRArrayFrom:
+			array := NewArrayValue(int(b))
+			copy(array.AsArray().Elements, regs[int(a):int(a)+int(b)])
+			regs[a] = array
+
+		case OpRMapFrom:
+			mapVal := newMap(int(b))
+			pairs := mapVal.AsMap()
+			for i := 0; i < int(b); i++ {
+				pairs.Set(regs[int(a)+2*i].ToMapKey(), regs[int(a)+2*i+1])
+			}
+			regs[a] = mapVal
+
+		case OpRStructFrom:
+			typeName := regs[int(a)+2*int(b)]
+			if typeName.Type != StringType {
+				return fmt.Errorf("struct type name must be string")
+			}
+			fieldNames := make([]string, b)
+			fieldValues := make([]Value, b)
+			for i := 0; i < int(b); i++ {
+				name := regs[int(a)+2*i]
+				if name.Type != StringType {
+					return fmt.Errorf("struct field name must be string")
+				}
+				fieldNames[i] = name.AsString()
+				fieldValues[i] = regs[int(a)+2*i+1]
+			}
+			if c != 0 {
+				regs[a] = NewStructValueOrdered(typeName.AsString(), fieldNames, fieldValues)
+			} else {
+				fields := make(map[string]Value, b)
+				for i, name := range fieldNames {
+					fields[name] = fieldValues[i]
+				}
+				regs[a] = NewStructValue(typeName.AsString(), fields)
+			}
+
+		case OpRGetFieldName:
+			if regs[b].Type != StructType {
+				return fmt.Errorf("field access not supported for type %d", regs[b].Type)
+			}
+			structVal := regs[b].AsStruct()
+			fieldName := regs[c].AsString()
+			val, ok := structVal.Fields[fieldName]
+			if !ok {
+				return fmt.Errorf("field %s not found in struct %s", fieldName, structVal.TypeName)
+			}
+			regs[a] = val
+
+		case OpRSetFieldName:
+			if regs[a].Type != StructType {
+				return fmt.Errorf("field access not supported for type %d", regs[a].Type)
+			}
+			regs[a].AsStruct().SetField(regs[b].AsString(), regs[c])
+
 		case OpRHalt:
-			return nil
+			frame.pc = pc
+			if started, err := vm.startExitHook(); err != nil || !started {
+				return err
+			}
+			frame = vm.currentFrame
+			ins = frame.instructions
+			pc = frame.pc
+			regs = frame.registers
+			constants = frame.constants
+
+		// Constant index in the EXTRAARG that follows
+		case OpRLoadKX:
+			regs[a] = constants[ins[pc]&MaxExtraArg]
+			pc++
+
+		case OpRLoadKCopyX:
+			regs[a] = copyCollection(constants[ins[pc]&MaxExtraArg])
+			pc++
+
+		case OpRCheckType:
+			if err := CheckType(regs[a], ValueType(b), constants[ins[pc]&MaxExtraArg].AsString()); err != nil {
+				return err
+			}
+			pc++
+
+		case OpRMakeVariant:
+			bx := uint16(instruction & 0xFFFF)
+			regs[a] = NewVariantValue(int(bx), regs[a])
+
+		case OpRTestVariant:
+			bx := uint16(instruction & 0xFFFF)
+			regs[a] = BoolValue(regs[a].Type == VariantType && regs[a].VariantTag() == int(bx))
+
+		case OpRTestStruct:
+			regs[a] = BoolValue(regs[b].IsStructNamed(regs[c].AsString()))
+
+		case OpRInc, OpRDec:
+			amount := int64(instruction & 0xFFFF)
+			if op == OpRDec {
+				amount = -amount
+			}
+			switch regs[a].Type {
+			case IntType:
+				regs[a] = IntValue(regs[a].AsInt() + amount)
+			case FloatType:
+				regs[a] = FloatValue(regs[a].AsFloat() + float64(amount))
+			default:
+				return ErrUnsupportedOperands
+			}
+
+		case OpRTestArray:
+			bx := uint16(instruction & 0xFFFF)
+			regs[a] = BoolValue(regs[a].Type == ArrayType && len(regs[a].AsArray().Elements) == int(bx))
+
+		case OpRVariantTag, OpRVariantPayload:
+			if regs[b].Type != VariantType {
+				return fmt.Errorf("expected a variant, got %s", regs[b].Type)
+			}
+			if op == OpRVariantTag {
+				regs[a] = IntValue(int64(regs[b].VariantTag()))
+			} else {
+				regs[a] = regs[b].VariantPayload()
+			}
+
+		case OpRMakeClosureX:
+			fn := constants[ins[pc]&MaxExtraArg].AsFunction()
+			pc++
+			free := make([]Value, fn.NumFree)
+			copy(free, regs[int(a):int(a)+fn.NumFree])
+			regs[a] = NewClosureValue(fn, free)
 
 		default:
 			return fmt.Errorf("unknown register opcode: %d", op)
@@ -425,21 +890,53 @@ func (vm *RegisterVM) Run() error {
 	}
 }
 
-// callFunction handles function calls in the register VM
-func (vm *RegisterVM) callFunction(fnReg, argReg, resultReg int) error {
-	function := vm.currentFrame.registers[fnReg]
+// stackTrace describes the active frames, innermost first. Each frame's pc
+// has moved past the instruction it is executing, so pc-1 is that instruction.
+func (vm *RegisterVM) stackTrace() []TraceEntry {
+	trace := make([]TraceEntry, 0, vm.frameIndex)
+	for i := vm.frameIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+		pos, _ := frame.function.RegisterLines.Lookup(frame.pc - 1)
+		trace = append(trace, TraceEntry{Function: frame.function.Name, Pos: pos})
+	}
+	return trace
+}
 
+// callFunction handles function calls in the register VM, calling function.
+// The arguments in argReg... are copied into the callee's own registers,
+// starting at 0.
+// numArgs is checked against the function's parameters unless it is -1, for
+// OpRCall, which doesn't record it.
+func (vm *RegisterVM) callFunction(function Value, argReg, resultReg, numArgs int) error {
+	regs := vm.currentFrame.registers
+	if err := vm.enterFunction(function, regs[min(argReg, len(regs)):], resultReg, numArgs); err != nil {
+		return err
+	}
+	vm.currentFrame.baseReg = argReg
+	return nil
+}
+
+// enterFunction pushes a frame calling function with args, whose result goes
+// to resultReg of the current frame, or nowhere if it is -1. numArgs is
+// checked as for callFunction.
+func (vm *RegisterVM) enterFunction(function Value, args []Value, resultReg, numArgs int) error {
 	// Only handle Function and Closure types
 	var fn *Function
+	var free []Value
 	switch function.Type {
 	case FunctionType:
 		fn = function.AsFunction()
 	case ClosureType:
 		fn = function.AsClosure().Fn
+		free = function.AsClosure().Free
 	default:
 		return ErrCallingNonFunction
 	}
 
+	if numArgs >= 0 && numArgs != fn.NumParams {
+		return fmt.Errorf("function %s expects %d arguments, got %d", fn.Name, fn.NumParams, numArgs)
+	}
+
 	// Verify function has register instructions
 	if len(fn.RegisterInstructions) == 0 {
 		return fmt.Errorf("function %s has no register bytecode", fn.Name)
@@ -462,27 +959,18 @@ func (vm *RegisterVM) callFunction(fnReg, argReg, resultReg int) error {
 		numRegs = fn.NumParams + 16 // Ensure enough for params + temps
 	}
 
-	// Arguments are already in registers argReg..argReg+NumParams
-	// We'll use those registers as the base for the new frame
-
 	// Set up new frame
 	newFrame.function = fn
 	newFrame.instructions = fn.RegisterInstructions
 	newFrame.pc = 0
-	newFrame.baseReg = argReg
 	newFrame.resultReg = resultReg // Store where to put return value
+	newFrame.free = free
+	newFrame.constants = vm.constants[fn.ConstantBase:]
 
 	// Create register window for new frame
-	// Arguments are in argReg..argReg+NumParams-1
-	// Function expects them in registers 0..NumParams-1
+	// Function expects its arguments in registers 0..NumParams-1
 	newFrame.registers = make([]Value, numRegs)
-
-	// Copy arguments to function's register 0, 1, 2, ... (parameter positions)
-	for i := 0; i < fn.NumParams; i++ {
-		if argReg+i < len(vm.currentFrame.registers) {
-			newFrame.registers[i] = vm.currentFrame.registers[argReg+i]
-		}
-	}
+	copy(newFrame.registers[:fn.NumParams], args)
 
 	vm.frameIndex++
 	vm.currentFrame = newFrame
@@ -496,11 +984,12 @@ func (vm *RegisterVM) returnFromFunction(resultReg int) error {
 		return nil // Main frame, exit program
 	}
 
-	// Save return value if any
-	var returnValue Value
+	// Save return value if any, for a caller in Go as well
+	returnValue := NilValue()
 	if resultReg >= 0 {
 		returnValue = vm.currentFrame.registers[resultReg]
 	}
+	vm.returned = returnValue
 
 	// Save the result register location from the callee frame
 	calleeResultReg := vm.currentFrame.resultReg
@@ -519,11 +1008,11 @@ func (vm *RegisterVM) returnFromFunction(resultReg int) error {
 
 // callBuiltin handles builtin function calls
 func (vm *RegisterVM) callBuiltin(index, argReg, resultReg, numArgs int) error {
-	if index >= len(Builtins) {
+	if index >= len(vm.builtinFns) {
 		return fmt.Errorf("unknown builtin: %d", index)
 	}
 
-	builtin := Builtins[index]
+	builtin := vm.builtinFns[index]
 
 	// Zero-copy: pass slice view directly (optimization - avoids allocation)
 	// Args are guaranteed to be in consecutive registers argReg..argReg+numArgs-1
@@ -532,8 +1021,145 @@ func (vm *RegisterVM) callBuiltin(index, argReg, resultReg, numArgs int) error {
 		endReg = len(vm.currentFrame.registers)
 	}
 
-	result := builtin(vm.currentFrame.registers[argReg:endReg]...)
+	result, err := builtin(vm.currentFrame.registers[argReg:endReg]...)
+	if err != nil {
+		return err
+	}
 	vm.currentFrame.registers[resultReg] = result
 
 	return nil
 }
+
+// genericArithmetic applies an arithmetic opcode with the stack VM's runtime
+// type rules: string concatenation for +, int math for two ints, otherwise
+// float math with int promotion
+func genericArithmetic(op RegisterOpCode, left, right Value) (Value, error) {
+	if op == OpRAdd && (left.Type == StringType || right.Type == StringType) {
+		return StringValue(left.String() + right.String()), nil
+	}
+
+	if left.Type == IntType && right.Type == IntType {
+		l, r := left.AsInt(), right.AsInt()
+		switch op {
+		case OpRAdd:
+			return IntValue(l + r), nil
+		case OpRSub:
+			return IntValue(l - r), nil
+		case OpRMul:
+			return IntValue(l * r), nil
+		case OpRDiv:
+			if r == 0 {
+				return NilValue(), ErrDivisionByZero
+			}
+			return IntValue(l / r), nil
+		case OpRMod:
+			if r == 0 {
+				return NilValue(), ErrModuloByZero
+			}
+			return IntValue(l % r), nil
+		}
+	}
+
+	l, lok := numericAsFloat(left)
+	r, rok := numericAsFloat(right)
+	if !lok || !rok {
+		return NilValue(), ErrUnsupportedOperands
+	}
+
+	switch op {
+	case OpRAdd:
+		return FloatValue(l + r), nil
+	case OpRSub:
+		return FloatValue(l - r), nil
+	case OpRMul:
+		return FloatValue(l * r), nil
+	case OpRDiv:
+		if r == 0 {
+			return NilValue(), ErrDivisionByZero
+		}
+		return FloatValue(l / r), nil
+	default:
+		return NilValue(), fmt.Errorf("unknown float operator: %s", op)
+	}
+}
+
+// genericComparison applies a comparison opcode with the stack VM's runtime type rules
+func genericComparison(op RegisterOpCode, left, right Value) (Value, error) {
+	if left.Type == IntType && right.Type == IntType {
+		l, r := left.AsInt(), right.AsInt()
+		switch op {
+		case OpREq:
+			return BoolValue(l == r), nil
+		case OpRNe:
+			return BoolValue(l != r), nil
+		case OpRLt:
+			return BoolValue(l < r), nil
+		case OpRGt:
+			return BoolValue(l > r), nil
+		case OpRLe:
+			return BoolValue(l <= r), nil
+		default:
+			return BoolValue(l >= r), nil
+		}
+	}
+
+	l, lok := numericAsFloat(left)
+	r, rok := numericAsFloat(right)
+	if lok && rok {
+		switch op {
+		case OpREq:
+			return BoolValue(l == r), nil
+		case OpRNe:
+			return BoolValue(l != r), nil
+		case OpRLt:
+			return BoolValue(l < r), nil
+		case OpRGt:
+			return BoolValue(l > r), nil
+		case OpRLe:
+			return BoolValue(l <= r), nil
+		default:
+			return BoolValue(l >= r), nil
+		}
+	}
+
+	if left.Type == BoolType && right.Type == BoolType {
+		switch op {
+		case OpREq:
+			return BoolValue(left.AsBool() == right.AsBool()), nil
+		case OpRNe:
+			return BoolValue(left.AsBool() != right.AsBool()), nil
+		}
+	}
+
+	// Strings compare for equality only
+	if left.Type == StringType && right.Type == StringType {
+		switch op {
+		case OpREq:
+			return BoolValue(left.AsString() == right.AsString()), nil
+		case OpRNe:
+			return BoolValue(left.AsString() != right.AsString()), nil
+		}
+	}
+
+	// Everything else compares for equality only, deeply
+	switch op {
+	case OpREq:
+		return BoolValue(Equal(left, right)), nil
+	case OpRNe:
+		return BoolValue(!Equal(left, right)), nil
+	}
+
+	return NilValue(), ErrUnsupportedComparison
+}
+
+// numericAsFloat widens an int or float Value to float64
+func numericAsFloat(v Value) (float64, bool) {
+	switch v.Type {
+	case IntType:
+		return float64(v.AsInt()), true
+	case FloatType:
+		return v.AsFloat(), true
+	default:
+		return 0, false
+	}
+}
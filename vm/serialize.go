@@ -0,0 +1,416 @@
+package vm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// Serialized bytecode (.minb) layout, all integers big-endian:
+//
+//	magic       [4]byte  "MINB"
+//	version     uint16
+//	instructions         length-prefixed byte slice
+//	lines                uint32 count, then (offset, line, column) triples
+//	constants            uint32 count, then one tagged value each
+//	enums                uint32 count, then name + (variant, tagged value) pairs
+//	globals     uint32   global slots used, 0 if not counted
+//	builtins             uint32 count, then the builtin names in index order
+//
+// Function constants are written inline; their instructions index into the
+// same shared constant pool as the main program. Constant arrays and maps
+// (hoisted literals) are written as a count followed by their elements, and
+// variants as their tag followed by their payload. Instructions call
+// builtins by index, so the names the program was compiled with are written
+// too, and loading fails unless the running builtins start with them.
+const (
+	BytecodeMagic   = "MINB"
+	BytecodeVersion = 9
+)
+
+// Errors returned when loading serialized bytecode
+var (
+	ErrBadMagic           = errors.New("not a minlang bytecode file (bad magic)")
+	ErrUnsupportedVersion = errors.New("unsupported bytecode version")
+	ErrBuiltinsChanged    = errors.New("bytecode was compiled with other builtins")
+)
+
+// WriteBytecode serializes bytecode (instructions, constants and the enum
+// registry) to w in the .minb format.
+func WriteBytecode(w io.Writer, bytecode *Bytecode) error {
+	bw := bufio.NewWriter(w)
+	enc := &bytecodeEncoder{w: bw}
+
+	enc.writeRaw([]byte(BytecodeMagic))
+	enc.writeUint16(BytecodeVersion)
+	enc.writeBytes(bytecode.Instructions)
+	enc.writeLines(bytecode.Lines)
+
+	enc.writeUint32(uint32(len(bytecode.Constants)))
+	for _, constant := range bytecode.Constants {
+		enc.writeValue(constant)
+	}
+
+	// Enums are defined at compile time, so they must travel with the bytecode
+	enumNames := make([]string, 0, len(bytecode.Enums))
+	for name := range bytecode.Enums {
+		enumNames = append(enumNames, name)
+	}
+	sort.Strings(enumNames)
+
+	enc.writeUint32(uint32(len(enumNames)))
+	for _, name := range enumNames {
+		variants := bytecode.Enums[name]
+		enc.writeString(name)
+		enc.writeUint32(uint32(len(variants)))
+		for _, variant := range variants {
+			enc.writeString(variant.Name)
+			enc.writeValue(variant.Value)
+		}
+	}
+	enc.writeUint32(uint32(bytecode.Globals))
+
+	names := BuiltinNames()
+	enc.writeUint32(uint32(len(names)))
+	for _, name := range names {
+		enc.writeString(name)
+	}
+
+	if enc.err != nil {
+		return enc.err
+	}
+	return bw.Flush()
+}
+
+// ReadBytecode loads bytecode previously written by WriteBytecode.
+func ReadBytecode(r io.Reader) (*Bytecode, error) {
+	dec := &bytecodeDecoder{r: bufio.NewReader(r)}
+
+	magic := dec.readRaw(len(BytecodeMagic))
+	if dec.err != nil {
+		return nil, dec.err
+	}
+	if string(magic) != BytecodeMagic {
+		return nil, ErrBadMagic
+	}
+
+	version := dec.readUint16()
+	if dec.err != nil {
+		return nil, dec.err
+	}
+	if version != BytecodeVersion {
+		return nil, fmt.Errorf("%w: %d (expected %d)", ErrUnsupportedVersion, version, BytecodeVersion)
+	}
+
+	bytecode := &Bytecode{}
+	bytecode.Instructions = dec.readBytes()
+	bytecode.Lines = dec.readLines()
+
+	numConstants := dec.readUint32()
+	bytecode.Constants = make([]Value, 0, numConstants)
+	for i := uint32(0); i < numConstants && dec.err == nil; i++ {
+		bytecode.Constants = append(bytecode.Constants, dec.readValue())
+	}
+
+	numEnums := dec.readUint32()
+	if numEnums > 0 {
+		bytecode.Enums = make(Enums, numEnums)
+	}
+	for i := uint32(0); i < numEnums && dec.err == nil; i++ {
+		name := dec.readString()
+		numVariants := dec.readUint32()
+		variants := make([]EnumVariant, 0, numVariants)
+		for j := uint32(0); j < numVariants && dec.err == nil; j++ {
+			name := dec.readString()
+			variants = append(variants, EnumVariant{Name: name, Value: dec.readValue()})
+		}
+		if dec.err == nil {
+			bytecode.Enums[name] = variants
+		}
+	}
+	bytecode.Globals = int(dec.readUint32())
+
+	names := BuiltinNames()
+	numBuiltins := dec.readUint32()
+	for i := uint32(0); i < numBuiltins && dec.err == nil; i++ {
+		name := dec.readString()
+		if dec.err != nil {
+			break
+		}
+		if int(i) >= len(names) {
+			return nil, fmt.Errorf("%w: builtin %d, %s, is unknown", ErrBuiltinsChanged, i, name)
+		}
+		if names[i] != name {
+			return nil, fmt.Errorf("%w: builtin %d is %s, expected %s", ErrBuiltinsChanged, i, names[i], name)
+		}
+	}
+
+	if dec.err != nil {
+		return nil, dec.err
+	}
+	if bytecode.Globals > GlobalsSize {
+		return nil, fmt.Errorf("bytecode uses %d global slots (max %d)", bytecode.Globals, GlobalsSize)
+	}
+	return bytecode, nil
+}
+
+// bytecodeEncoder writes primitive fields, remembering the first error
+type bytecodeEncoder struct {
+	w   io.Writer
+	err error
+	buf [8]byte
+}
+
+func (e *bytecodeEncoder) writeRaw(b []byte) {
+	if e.err != nil {
+		return
+	}
+	_, e.err = e.w.Write(b)
+}
+
+func (e *bytecodeEncoder) writeByte(b byte) {
+	e.buf[0] = b
+	e.writeRaw(e.buf[:1])
+}
+
+func (e *bytecodeEncoder) writeUint16(v uint16) {
+	binary.BigEndian.PutUint16(e.buf[:2], v)
+	e.writeRaw(e.buf[:2])
+}
+
+func (e *bytecodeEncoder) writeUint32(v uint32) {
+	binary.BigEndian.PutUint32(e.buf[:4], v)
+	e.writeRaw(e.buf[:4])
+}
+
+func (e *bytecodeEncoder) writeUint64(v uint64) {
+	binary.BigEndian.PutUint64(e.buf[:8], v)
+	e.writeRaw(e.buf[:8])
+}
+
+func (e *bytecodeEncoder) writeBytes(b []byte) {
+	e.writeUint32(uint32(len(b)))
+	e.writeRaw(b)
+}
+
+func (e *bytecodeEncoder) writeString(s string) {
+	e.writeBytes([]byte(s))
+}
+
+func (e *bytecodeEncoder) writeLines(lines LineTable) {
+	e.writeUint32(uint32(len(lines)))
+	for _, entry := range lines {
+		e.writeUint32(uint32(entry.Offset))
+		e.writeUint32(uint32(entry.Pos.Line))
+		e.writeUint32(uint32(entry.Pos.Column))
+	}
+}
+
+func (e *bytecodeEncoder) writeValue(v Value) {
+	if e.err != nil {
+		return
+	}
+
+	e.writeByte(byte(v.Type))
+
+	switch v.Type {
+	case IntType:
+		e.writeUint64(uint64(v.AsInt()))
+	case FloatType:
+		e.writeUint64(math.Float64bits(v.AsFloat()))
+	case BoolType:
+		if v.AsBool() {
+			e.writeByte(1)
+		} else {
+			e.writeByte(0)
+		}
+	case StringType:
+		e.writeString(v.AsString())
+	case NilType:
+		// No payload
+	case FunctionType:
+		fn := v.AsFunction()
+		e.writeString(fn.Name)
+		e.writeUint32(uint32(fn.NumParams))
+		e.writeUint32(uint32(fn.NumLocals))
+		e.writeBytes(fn.Instructions)
+		e.writeUint32(uint32(len(fn.RegisterInstructions)))
+		for _, ins := range fn.RegisterInstructions {
+			e.writeUint32(uint32(ins))
+		}
+		e.writeLines(fn.Lines)
+		e.writeLines(fn.RegisterLines)
+	case ArrayType:
+		elements := v.AsArray().Elements
+		e.writeUint32(uint32(len(elements)))
+		for _, element := range elements {
+			e.writeValue(element)
+		}
+	case VariantType:
+		e.writeUint32(uint32(v.VariantTag()))
+		e.writeValue(v.VariantPayload())
+	case MapType:
+		// In the map's order, which is the same every time the program is
+		// compiled and which keys() follows after it's read back
+		keys := v.AsMap().Keys()
+		e.writeUint32(uint32(len(keys)))
+		for _, key := range keys {
+			if key.IsInt {
+				e.writeValue(IntValue(key.IntVal))
+			} else {
+				e.writeValue(StringValue(key.StrVal))
+			}
+			e.writeValue(v.AsMap().Pairs[key])
+		}
+	default:
+		e.err = fmt.Errorf("cannot serialize constant of type %d", v.Type)
+	}
+}
+
+// bytecodeDecoder reads primitive fields, remembering the first error
+type bytecodeDecoder struct {
+	r   io.Reader
+	err error
+	buf [8]byte
+}
+
+func (d *bytecodeDecoder) readRaw(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(d.r, b); err != nil {
+		d.err = fmt.Errorf("truncated bytecode: %w", err)
+		return nil
+	}
+	return b
+}
+
+func (d *bytecodeDecoder) readByte() byte {
+	if d.err != nil {
+		return 0
+	}
+	if _, err := io.ReadFull(d.r, d.buf[:1]); err != nil {
+		d.err = fmt.Errorf("truncated bytecode: %w", err)
+		return 0
+	}
+	return d.buf[0]
+}
+
+func (d *bytecodeDecoder) readUint16() uint16 {
+	if d.err != nil {
+		return 0
+	}
+	if _, err := io.ReadFull(d.r, d.buf[:2]); err != nil {
+		d.err = fmt.Errorf("truncated bytecode: %w", err)
+		return 0
+	}
+	return binary.BigEndian.Uint16(d.buf[:2])
+}
+
+func (d *bytecodeDecoder) readUint32() uint32 {
+	if d.err != nil {
+		return 0
+	}
+	if _, err := io.ReadFull(d.r, d.buf[:4]); err != nil {
+		d.err = fmt.Errorf("truncated bytecode: %w", err)
+		return 0
+	}
+	return binary.BigEndian.Uint32(d.buf[:4])
+}
+
+func (d *bytecodeDecoder) readUint64() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	if _, err := io.ReadFull(d.r, d.buf[:8]); err != nil {
+		d.err = fmt.Errorf("truncated bytecode: %w", err)
+		return 0
+	}
+	return binary.BigEndian.Uint64(d.buf[:8])
+}
+
+func (d *bytecodeDecoder) readBytes() []byte {
+	n := d.readUint32()
+	return d.readRaw(int(n))
+}
+
+func (d *bytecodeDecoder) readString() string {
+	return string(d.readBytes())
+}
+
+func (d *bytecodeDecoder) readLines() LineTable {
+	n := d.readUint32()
+	if n == 0 || d.err != nil {
+		return nil
+	}
+	lines := make(LineTable, 0, n)
+	for i := uint32(0); i < n && d.err == nil; i++ {
+		offset := int(d.readUint32())
+		line := int(d.readUint32())
+		column := int(d.readUint32())
+		lines = append(lines, LineEntry{Offset: offset, Pos: Position{Line: line, Column: column}})
+	}
+	return lines
+}
+
+func (d *bytecodeDecoder) readValue() Value {
+	valueType := ValueType(d.readByte())
+	if d.err != nil {
+		return NilValue()
+	}
+
+	switch valueType {
+	case IntType:
+		return IntValue(int64(d.readUint64()))
+	case FloatType:
+		return FloatValue(math.Float64frombits(d.readUint64()))
+	case BoolType:
+		return BoolValue(d.readByte() != 0)
+	case StringType:
+		return StringValue(d.readString())
+	case NilType:
+		return NilValue()
+	case FunctionType:
+		fn := &Function{}
+		fn.Name = d.readString()
+		fn.NumParams = int(d.readUint32())
+		fn.NumLocals = int(d.readUint32())
+		fn.Instructions = d.readBytes()
+		numRegisterIns := d.readUint32()
+		if numRegisterIns > 0 {
+			fn.RegisterInstructions = make([]RegisterInstruction, 0, numRegisterIns)
+			for i := uint32(0); i < numRegisterIns && d.err == nil; i++ {
+				fn.RegisterInstructions = append(fn.RegisterInstructions, RegisterInstruction(d.readUint32()))
+			}
+		}
+		fn.Lines = d.readLines()
+		fn.RegisterLines = d.readLines()
+		return NewFunctionValue(fn)
+	case ArrayType:
+		count := d.readUint32()
+		var elements []Value
+		for i := uint32(0); i < count && d.err == nil; i++ {
+			elements = append(elements, d.readValue())
+		}
+		return NewArrayFromElements(elements)
+	case VariantType:
+		tag := int(d.readUint32())
+		return NewVariantValue(tag, d.readValue())
+	case MapType:
+		count := d.readUint32()
+		m := NewMapValue()
+		for i := uint32(0); i < count && d.err == nil; i++ {
+			key := d.readValue()
+			m.AsMap().Set(key.ToMapKey(), d.readValue())
+		}
+		return m
+	default:
+		d.err = fmt.Errorf("unknown constant type %d in bytecode", valueType)
+		return NilValue()
+	}
+}
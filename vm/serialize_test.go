@@ -0,0 +1,174 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestBytecodeRoundTrip(t *testing.T) {
+	fn := &Function{
+		Name:      "double",
+		NumParams: 1,
+		NumLocals: 1,
+		Instructions: concatInstructions(
+			Make(OpLoadLocal, 0),
+			Make(OpLoadLocal, 0),
+			Make(OpAdd),
+			Make(OpReturn),
+		),
+		Lines: LineTable{{Offset: 0, Pos: Position{Line: 2, Column: 12}}},
+	}
+
+	original := &Bytecode{
+		Instructions: concatInstructions(
+			Make(OpPush, 0),
+			Make(OpPush, 1),
+			Make(OpCall, 1),
+			Make(OpPop),
+			Make(OpHalt),
+		),
+		Constants: []Value{
+			NewFunctionValue(fn),
+			IntValue(21),
+			FloatValue(2.5),
+			BoolValue(true),
+			StringValue("hello"),
+			NilValue(),
+			NewArrayFromElements([]Value{IntValue(1), StringValue("two"), FloatValue(3.5)}),
+			mapConstant(),
+		},
+		Lines: LineTable{
+			{Offset: 0, Pos: Position{Line: 4, Column: 1}},
+			{Offset: 6, Pos: Position{Line: 4, Column: 7}},
+		},
+		Globals: 3,
+	}
+
+	var buf bytes.Buffer
+	if err := WriteBytecode(&buf, original); err != nil {
+		t.Fatalf("WriteBytecode failed: %v", err)
+	}
+
+	loaded, err := ReadBytecode(&buf)
+	if err != nil {
+		t.Fatalf("ReadBytecode failed: %v", err)
+	}
+
+	if !bytes.Equal(loaded.Instructions, original.Instructions) {
+		t.Errorf("instructions differ.\nwant=%v\ngot=%v", original.Instructions, loaded.Instructions)
+	}
+
+	if !reflect.DeepEqual(loaded.Lines, original.Lines) {
+		t.Errorf("line table differs.\nwant=%v\ngot=%v", original.Lines, loaded.Lines)
+	}
+
+	if loaded.Globals != original.Globals {
+		t.Errorf("wrong globals. want=%d, got=%d", original.Globals, loaded.Globals)
+	}
+
+	if len(loaded.Constants) != len(original.Constants) {
+		t.Fatalf("wrong number of constants. want=%d, got=%d", len(original.Constants), len(loaded.Constants))
+	}
+
+	for i, want := range original.Constants[1:] {
+		got := loaded.Constants[i+1]
+		if got.Type != want.Type || got.String() != want.String() {
+			t.Errorf("constant %d differs. want=%s, got=%s", i+1, want.String(), got.String())
+		}
+	}
+
+	loadedFn := loaded.Constants[0].AsFunction()
+	if loadedFn.Name != fn.Name || loadedFn.NumParams != fn.NumParams || loadedFn.NumLocals != fn.NumLocals {
+		t.Errorf("function header differs. got=%+v", loadedFn)
+	}
+	if !bytes.Equal(loadedFn.Instructions, fn.Instructions) {
+		t.Errorf("function instructions differ")
+	}
+	if !reflect.DeepEqual(loadedFn.Lines, fn.Lines) {
+		t.Errorf("function line table differs. got=%v", loadedFn.Lines)
+	}
+
+	machine := New(loaded)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if result := machine.LastPoppedStackElem(); result.AsInt() != 42 {
+		t.Errorf("wrong result. want=42, got=%s", result.String())
+	}
+}
+
+func TestReadBytecodeRejectsBadInput(t *testing.T) {
+	if _, err := ReadBytecode(bytes.NewReader([]byte("NOPE\x00\x01"))); !errors.Is(err, ErrBadMagic) {
+		t.Errorf("expected ErrBadMagic, got %v", err)
+	}
+
+	if _, err := ReadBytecode(bytes.NewReader([]byte("MINB\x00\x63"))); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("expected ErrUnsupportedVersion, got %v", err)
+	}
+
+	if _, err := ReadBytecode(bytes.NewReader([]byte("MINB"))); err == nil {
+		t.Errorf("expected error for truncated input")
+	}
+}
+
+// TestReadBytecodeChecksBuiltins checks that bytecode compiled with other
+// builtins, whose indexes its instructions would call, is rejected
+func TestReadBytecodeChecksBuiltins(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteBytecode(&buf, &Bytecode{Instructions: Make(OpHalt)}); err != nil {
+		t.Fatalf("write error: %s", err)
+	}
+	if _, err := ReadBytecode(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("read error: %s", err)
+	}
+
+	data := buf.Bytes()
+	at := bytes.LastIndex(data, []byte("typeof"))
+	copy(data[at:], "typeOf")
+	_, err := ReadBytecode(bytes.NewReader(data))
+	if !errors.Is(err, ErrBuiltinsChanged) {
+		t.Errorf("expected ErrBuiltinsChanged, got %v", err)
+	}
+}
+
+func mapConstant() Value {
+	m := NewMapValue()
+	m.AsMap().Set(MapKey{StrVal: "a"}, IntValue(1))
+	m.AsMap().Set(MapKey{IsInt: true, IntVal: 7}, BoolValue(true))
+	return m
+}
+
+func TestBytecodeRoundTripEnums(t *testing.T) {
+	original := &Bytecode{
+		Instructions: Make(OpHalt),
+		Enums: Enums{
+			"Status": {{Name: "Ok", Value: IntValue(200)}, {Name: "NotFound", Value: IntValue(404)}},
+			"Color":  {{Name: "Red", Value: StringValue("red")}, {Name: "Green", Value: StringValue("green")}},
+			"Shape":  {{Name: "Circle", Value: NewVariantValue(0, NilValue())}, {Name: "Empty", Value: NewVariantValue(1, NilValue())}},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteBytecode(&buf, original); err != nil {
+		t.Fatalf("WriteBytecode failed: %v", err)
+	}
+	loaded, err := ReadBytecode(&buf)
+	if err != nil {
+		t.Fatalf("ReadBytecode failed: %v", err)
+	}
+
+	for name, want := range original.Enums {
+		got := loaded.Enums[name]
+		if len(got) != len(want) {
+			t.Fatalf("enum %s has %d variants, want %d", name, len(got), len(want))
+		}
+		for i := range want {
+			if got[i].Name != want[i].Name || !got[i].Is(want[i].Value) {
+				t.Errorf("enum %s variant %d = %s %s, want %s %s",
+					name, i, got[i].Name, got[i].Value.String(), want[i].Name, want[i].Value.String())
+			}
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package vm
+
+import "fmt"
+
+// Slicing
+//
+// a[low:high] is a new array holding the elements of a from low up to but
+// not including high; s[low:high] is the substring of s between those byte
+// offsets. A missing low is 0 and a missing high is the length, so a[:] is a
+// copy of a. The elements of a sliced array are shared with the original the
+// way assigning them would share them, but the array itself is new: setting
+// an element of one doesn't change the other.
+
+// Slice returns container[low:high], where a nil bound is the default for
+// its end, or an error if container can't be sliced or the bounds are out of
+// range
+func Slice(container, low, high Value) (Value, error) {
+	var length int
+	switch container.Type {
+	case ArrayType:
+		length = len(container.AsArray().Elements)
+	case StringType:
+		length = len(container.AsString())
+	default:
+		return NilValue(), fmt.Errorf("cannot slice %s", container.Type)
+	}
+
+	lo, err := sliceBound(low, 0)
+	if err != nil {
+		return NilValue(), err
+	}
+	hi, err := sliceBound(high, length)
+	if err != nil {
+		return NilValue(), err
+	}
+	if lo < 0 || hi > length || lo > hi {
+		return NilValue(), fmt.Errorf("slice bounds out of range [%d:%d] with length %d", lo, hi, length)
+	}
+
+	if container.Type == StringType {
+		return StringValue(container.AsString()[lo:hi]), nil
+	}
+	elements := make([]Value, hi-lo)
+	copy(elements, container.AsArray().Elements[lo:hi])
+	return NewArrayFromElements(elements), nil
+}
+
+// sliceBound returns the int bound holds, or def if it is nil
+func sliceBound(bound Value, def int) (int, error) {
+	switch bound.Type {
+	case NilType:
+		return def, nil
+	case IntType:
+		return int(bound.AsInt()), nil
+	default:
+		return 0, fmt.Errorf("slice bound must be integer, got %s", bound.Type)
+	}
+}
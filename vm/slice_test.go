@@ -0,0 +1,68 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSlice(t *testing.T) {
+	array := NewArrayFromElements([]Value{IntValue(1), IntValue(2), IntValue(3)})
+	tests := []struct {
+		container Value
+		low, high Value
+		want      string
+		err       string
+	}{
+		{array, IntValue(1), IntValue(3), "[2, 3]", ""},
+		{array, NilValue(), IntValue(1), "[1]", ""},
+		{array, IntValue(3), NilValue(), "[]", ""},
+		{StringValue("héllo"), IntValue(3), NilValue(), "llo", ""},
+		{StringValue("hello"), NilValue(), NilValue(), "hello", ""},
+		{array, IntValue(2), IntValue(1), "", "slice bounds out of range [2:1] with length 3"},
+		{array, IntValue(-1), NilValue(), "", "slice bounds out of range [-1:3] with length 3"},
+		{StringValue("abc"), NilValue(), IntValue(4), "", "slice bounds out of range [0:4] with length 3"},
+		{array, FloatValue(1), NilValue(), "", "slice bound must be integer, got float"},
+		{IntValue(5), NilValue(), NilValue(), "", "cannot slice int"},
+	}
+
+	for _, tt := range tests {
+		got, err := Slice(tt.container, tt.low, tt.high)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("Slice(%s, %s, %s) error = %v, want %q", sliceString(tt.container), tt.low, tt.high, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Slice(%s, %s, %s) error = %v", sliceString(tt.container), tt.low, tt.high, err)
+			continue
+		}
+		if s := sliceString(got); s != tt.want {
+			t.Errorf("Slice(%s, %s, %s) = %s, want %s", sliceString(tt.container), tt.low, tt.high, s, tt.want)
+		}
+	}
+}
+
+// sliceString formats v, listing the elements of an array
+func sliceString(v Value) string {
+	if v.Type != ArrayType {
+		return v.String()
+	}
+	var elements []string
+	for _, e := range v.AsArray().Elements {
+		elements = append(elements, e.String())
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+func TestSliceCopiesArray(t *testing.T) {
+	array := NewArrayFromElements([]Value{IntValue(1), IntValue(2)})
+	sliced, err := Slice(array, NilValue(), NilValue())
+	if err != nil {
+		t.Fatal(err)
+	}
+	sliced.AsArray().Elements[0] = IntValue(9)
+	if got := array.AsArray().Elements[0].AsInt(); got != 1 {
+		t.Errorf("setting an element of a slice changed the original to %d", got)
+	}
+}
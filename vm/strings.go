@@ -0,0 +1,134 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// String utilities
+//
+// These work on bytes like len and s[i] do: indexOf returns a byte offset,
+// and trim, replace and the rest treat their arguments as plain byte
+// strings, except that toUpper and toLower change the case of any letter,
+// not only ASCII ones. indexOf and contains also search arrays, see
+// indexOfBuiltin.
+
+// stringArgs returns args as strings for the builtin name, which takes want
+// arguments
+func stringArgs(name string, want int, args []Value) ([]string, error) {
+	if len(args) != want {
+		return nil, fmt.Errorf("%s: wrong number of arguments. got=%d, want=%d", name, len(args), want)
+	}
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		if arg.Type != StringType {
+			return nil, fmt.Errorf("%s: arguments must be strings", name)
+		}
+		strs[i] = arg.AsString()
+	}
+	return strs, nil
+}
+
+// startsWithBuiltin implements startsWith(s, prefix)
+func (env *builtinEnv) startsWithBuiltin(args ...Value) (Value, error) {
+	strs, err := stringArgs("startsWith", 2, args)
+	if err != nil {
+		return NilValue(), err
+	}
+	return BoolValue(strings.HasPrefix(strs[0], strs[1])), nil
+}
+
+// endsWithBuiltin implements endsWith(s, suffix)
+func (env *builtinEnv) endsWithBuiltin(args ...Value) (Value, error) {
+	strs, err := stringArgs("endsWith", 2, args)
+	if err != nil {
+		return NilValue(), err
+	}
+	return BoolValue(strings.HasSuffix(strs[0], strs[1])), nil
+}
+
+// replaceBuiltin implements replace(s, old, new), s with every old replaced
+// by new
+func (env *builtinEnv) replaceBuiltin(args ...Value) (Value, error) {
+	strs, err := stringArgs("replace", 3, args)
+	if err != nil {
+		return NilValue(), err
+	}
+	return StringValue(strings.ReplaceAll(strs[0], strs[1], strs[2])), nil
+}
+
+// toUpperBuiltin implements toUpper(s)
+func (env *builtinEnv) toUpperBuiltin(args ...Value) (Value, error) {
+	strs, err := stringArgs("toUpper", 1, args)
+	if err != nil {
+		return NilValue(), err
+	}
+	return StringValue(strings.ToUpper(strs[0])), nil
+}
+
+// toLowerBuiltin implements toLower(s)
+func (env *builtinEnv) toLowerBuiltin(args ...Value) (Value, error) {
+	strs, err := stringArgs("toLower", 1, args)
+	if err != nil {
+		return NilValue(), err
+	}
+	return StringValue(strings.ToLower(strs[0])), nil
+}
+
+// trimBuiltin implements trim(s), s without leading and trailing whitespace,
+// and trim(s, chars), s without leading and trailing bytes found in chars
+func (env *builtinEnv) trimBuiltin(args ...Value) (Value, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return NilValue(), fmt.Errorf("trim: wrong number of arguments. got=%d, want=1 or 2", len(args))
+	}
+	strs, err := stringArgs("trim", len(args), args)
+	if err != nil {
+		return NilValue(), err
+	}
+	if len(strs) == 2 {
+		return StringValue(strings.Trim(strs[0], strs[1])), nil
+	}
+	return StringValue(strings.TrimSpace(strs[0])), nil
+}
+
+// repeatBuiltin implements repeat(s, n), n copies of s
+func (env *builtinEnv) repeatBuiltin(args ...Value) (Value, error) {
+	if len(args) != 2 {
+		return NilValue(), fmt.Errorf("repeat: wrong number of arguments. got=%d, want=2", len(args))
+	}
+	if args[0].Type != StringType {
+		return NilValue(), fmt.Errorf("repeat: first argument must be string")
+	}
+	n, err := arraySize("repeat", "count", args[1])
+	if err != nil {
+		return NilValue(), err
+	}
+	s := args[0].AsString()
+	if n > 0 && len(s) > maxStringLength/n {
+		return NilValue(), fmt.Errorf("repeat: result too long")
+	}
+	return StringValue(strings.Repeat(s, n)), nil
+}
+
+// maxStringLength bounds the strings repeat builds
+const maxStringLength = 1 << 30
+
+// joinBuiltin implements join(arr, sep), the strings of arr with sep
+// between each
+func (env *builtinEnv) joinBuiltin(args ...Value) (Value, error) {
+	arr, err := arrayArg("join", 2, args)
+	if err != nil {
+		return NilValue(), err
+	}
+	if args[1].Type != StringType {
+		return NilValue(), fmt.Errorf("join: separator must be string")
+	}
+	strs := make([]string, len(arr.Elements))
+	for i, elem := range arr.Elements {
+		if elem.Type != StringType {
+			return NilValue(), fmt.Errorf("join: elements must be strings, got %s", elem.Type)
+		}
+		strs[i] = elem.AsString()
+	}
+	return StringValue(strings.Join(strs, args[1].AsString())), nil
+}
@@ -0,0 +1,70 @@
+package vm
+
+import "testing"
+
+func TestStringBuiltins(t *testing.T) {
+	env := defaultBuiltinEnv
+	s := StringValue
+	tests := []struct {
+		name string
+		fn   BuiltinFunction
+		args []Value
+		want string
+	}{
+		{"startsWith", env.startsWithBuiltin, []Value{s("minlang"), s("min")}, "true"},
+		{"endsWith", env.endsWithBuiltin, []Value{s("minlang"), s("min")}, "false"},
+		{"contains", env.containsBuiltin, []Value{s("minlang"), s("nla")}, "true"},
+		{"indexOf", env.indexOfBuiltin, []Value{s("héllo"), s("l")}, "3"},
+		{"indexOf", env.indexOfBuiltin, []Value{s("abc"), s("")}, "0"},
+		{"replace", env.replaceBuiltin, []Value{s("a.b.c"), s("."), s("::")}, "a::b::c"},
+		{"toUpper", env.toUpperBuiltin, []Value{s("héllo")}, "HÉLLO"},
+		{"toLower", env.toLowerBuiltin, []Value{s("MinLang")}, "minlang"},
+		{"trim", env.trimBuiltin, []Value{s("\t x y \n")}, "x y"},
+		{"trim", env.trimBuiltin, []Value{s("--x-y--"), s("-")}, "x-y"},
+		{"repeat", env.repeatBuiltin, []Value{s("ab"), IntValue(3)}, "ababab"},
+		{"join", env.joinBuiltin, []Value{NewArrayFromElements([]Value{s("a"), s("b")}), s(", ")}, "a, b"},
+		{"join", env.joinBuiltin, []Value{NewArrayFromElements(nil), s(", ")}, ""},
+		{"ord", env.ordBuiltin, []Value{s("A")}, "65"},
+		{"ord", env.ordBuiltin, []Value{s("é")}, "233"},
+		{"ord", env.ordBuiltin, []Value{s("é"[:1])}, "195"},
+		{"chr", env.chrBuiltin, []Value{IntValue(65)}, "A"},
+		{"chr", env.chrBuiltin, []Value{IntValue(233)}, "é"},
+	}
+
+	for _, tt := range tests {
+		got, err := tt.fn(tt.args...)
+		if err != nil {
+			t.Errorf("%s%v: %v", tt.name, tt.args, err)
+			continue
+		}
+		if got.String() != tt.want {
+			t.Errorf("%s%v = %s, want %s", tt.name, tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestStringBuiltinErrors(t *testing.T) {
+	env := defaultBuiltinEnv
+	tests := []struct {
+		fn   BuiltinFunction
+		args []Value
+		want string
+	}{
+		{env.startsWithBuiltin, []Value{StringValue("a"), IntValue(1)}, "startsWith: arguments must be strings"},
+		{env.repeatBuiltin, []Value{StringValue("a"), IntValue(-1)}, "repeat: count must be non-negative"},
+		{env.ordBuiltin, []Value{StringValue("ab")}, `ord: expected one character, got "ab"`},
+		{env.ordBuiltin, []Value{StringValue("")}, `ord: expected one character, got ""`},
+		{env.chrBuiltin, []Value{IntValue(-1)}, "chr: -1 is not a code point"},
+		{env.chrBuiltin, []Value{IntValue(0xD800)}, "chr: 55296 is not a code point"},
+		{env.repeatBuiltin, []Value{StringValue("ab"), IntValue(1 << 60)}, "repeat: result too long"},
+		{env.joinBuiltin, []Value{NewArrayFromElements([]Value{IntValue(1)}), StringValue(",")}, "join: elements must be strings, got int"},
+		{env.trimBuiltin, nil, "trim: wrong number of arguments. got=0, want=1 or 2"},
+	}
+
+	for _, tt := range tests {
+		_, err := tt.fn(tt.args...)
+		if err == nil || err.Error() != tt.want {
+			t.Errorf("got error %v, want %q", err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package vm
+
+import (
+	"fmt"
+	"time"
+)
+
+// Time
+//
+// now() is the wall clock time in milliseconds since the Unix epoch, and
+// clock() the milliseconds, as a float, since the program started, read from
+// a monotonic clock so differences between readings time the program even if
+// the wall clock is set meanwhile. sleep(ms) pauses the program, and
+// formatTime(ms) shows a time from now() in UTC, or with a Go time layout as
+// formatTime(ms, "15:04").
+
+// clockStart is when clock() counts from
+var clockStart = time.Now()
+
+// defaultTimeLayout is how formatTime shows a time without a layout
+const defaultTimeLayout = "2006-01-02 15:04:05.000"
+
+// nowBuiltin implements now()
+func (env *builtinEnv) nowBuiltin(args ...Value) (Value, error) {
+	if len(args) != 0 {
+		return NilValue(), fmt.Errorf("now: wrong number of arguments. got=%d, want=0", len(args))
+	}
+	return IntValue(time.Now().UnixMilli()), nil
+}
+
+// clockBuiltin implements clock()
+func (env *builtinEnv) clockBuiltin(args ...Value) (Value, error) {
+	if len(args) != 0 {
+		return NilValue(), fmt.Errorf("clock: wrong number of arguments. got=%d, want=0", len(args))
+	}
+	return FloatValue(float64(time.Since(clockStart)) / float64(time.Millisecond)), nil
+}
+
+// sleepBuiltin implements sleep(ms). Output printed so far is flushed first,
+// so it shows before the pause rather than after.
+func (env *builtinEnv) sleepBuiltin(args ...Value) (Value, error) {
+	vals, err := floatArgs("sleep", 1, args)
+	if err != nil {
+		return NilValue(), err
+	}
+	if vals[0] < 0 {
+		return NilValue(), fmt.Errorf("sleep: duration must be non-negative")
+	}
+	if env.buf != nil {
+		env.buf.Flush()
+	}
+	time.Sleep(time.Duration(vals[0] * float64(time.Millisecond)))
+	return NilValue(), nil
+}
+
+// formatTimeBuiltin implements formatTime(ms) and formatTime(ms, layout)
+func (env *builtinEnv) formatTimeBuiltin(args ...Value) (Value, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return NilValue(), fmt.Errorf("formatTime: wrong number of arguments. got=%d, want=1 or 2", len(args))
+	}
+	if args[0].Type != IntType {
+		return NilValue(), fmt.Errorf("formatTime: time must be int milliseconds")
+	}
+	layout := defaultTimeLayout
+	if len(args) == 2 {
+		if args[1].Type != StringType {
+			return NilValue(), fmt.Errorf("formatTime: layout must be string")
+		}
+		layout = args[1].AsString()
+	}
+	return StringValue(time.UnixMilli(args[0].AsInt()).UTC().Format(layout)), nil
+}
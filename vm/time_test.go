@@ -0,0 +1,48 @@
+package vm
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestFormatTime(t *testing.T) {
+	tests := []struct {
+		args []Value
+		want string
+	}{
+		{[]Value{IntValue(0)}, "1970-01-01 00:00:00.000"},
+		{[]Value{IntValue(1700000000123)}, "2023-11-14 22:13:20.123"},
+		{[]Value{IntValue(-1000), StringValue("2006-01-02T15:04:05")}, "1969-12-31T23:59:59"},
+	}
+
+	for _, tt := range tests {
+		got, err := defaultBuiltinEnv.formatTimeBuiltin(tt.args...)
+		if err != nil {
+			t.Fatalf("formatTime%v: %v", tt.args, err)
+		}
+		if got.AsString() != tt.want {
+			t.Errorf("formatTime%v = %q, want %q", tt.args, got.AsString(), tt.want)
+		}
+	}
+}
+
+func TestSleepFlushesOutput(t *testing.T) {
+	var out strings.Builder
+	env := &builtinEnv{stdout: &out}
+	env.buf = bufio.NewWriter(&out)
+
+	env.printBuiltin(StringValue("before"))
+	start, _ := env.clockBuiltin()
+	if _, err := env.sleepBuiltin(IntValue(5)); err != nil {
+		t.Fatal(err)
+	}
+	end, _ := env.clockBuiltin()
+
+	if out.String() != "before\n" {
+		t.Errorf("output before sleeping = %q, want it flushed", out.String())
+	}
+	if elapsed := end.AsFloat() - start.AsFloat(); elapsed < 5 {
+		t.Errorf("clock advanced %gms over sleep(5)", elapsed)
+	}
+}
@@ -0,0 +1,613 @@
+package vm
+
+import (
+	"fmt"
+)
+
+// TranslateToRegister converts stack bytecode into register bytecode so the
+// register VM can run anything the stack compiler supports.
+//
+// Each stack slot is simulated as a virtual register: in a function, locals
+// keep their stack indices (registers 0..NumLocals-1) and the operand stack at
+// depth d lives in register NumLocals+d. The main program has no locals, so its
+// operand stack starts at register 0. Two scratch registers past the deepest
+// stack slot hold temporaries such as constant operands. Main has one more
+// register past those, which each pop copies into, holding the program's
+// result.
+func TranslateToRegister(bytecode *Bytecode) (*RegisterBytecode, error) {
+	t := &translator{
+		constants:     make([]Value, len(bytecode.Constants)),
+		intConstants:  make(map[int64]int),
+		translatedFns: make(map[int]*Function),
+	}
+	copy(t.constants, bytecode.Constants)
+
+	// Create register copies of every function first so closures created
+	// anywhere can record their free variable count on the copy
+	for i, constant := range bytecode.Constants {
+		if constant.Type != FunctionType {
+			continue
+		}
+		fn := constant.AsFunction()
+		copyFn := &Function{Name: fn.Name, NumParams: fn.NumParams}
+		t.translatedFns[i] = copyFn
+		t.constants[i] = NewFunctionValue(copyFn)
+	}
+
+	for i, constant := range bytecode.Constants {
+		if constant.Type != FunctionType {
+			continue
+		}
+		fn := constant.AsFunction()
+		ins, lines, numRegs, err := t.translate(fn.Instructions, fn.Lines, fn.NumLocals, false)
+		if err != nil {
+			return nil, fmt.Errorf("function %s: %w", fn.Name, err)
+		}
+		t.translatedFns[i].RegisterInstructions = ins
+		t.translatedFns[i].RegisterLines = lines
+		t.translatedFns[i].NumLocals = numRegs
+	}
+
+	mainIns, mainLines, numRegs, err := t.translate(bytecode.Instructions, bytecode.Lines, 0, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RegisterBytecode{
+		Instructions: mainIns,
+		Constants:    t.constants,
+		MainFunction: &Function{
+			Name:                 "main",
+			NumLocals:            numRegs,
+			RegisterInstructions: mainIns,
+			RegisterLines:        mainLines,
+		},
+		Enums:     bytecode.Enums,
+		ResultReg: numRegs - 1,
+		Globals:   bytecode.Globals,
+	}, nil
+}
+
+// translator holds state shared across all translated instruction streams
+type translator struct {
+	constants     []Value
+	intConstants  map[int64]int     // Small ints added for inc/dec amounts
+	translatedFns map[int]*Function // constant index -> register copy
+}
+
+// intConstant returns the pool index of an int constant, adding it if needed
+func (t *translator) intConstant(v int64) int {
+	if idx, ok := t.intConstants[v]; ok {
+		return idx
+	}
+	t.constants = append(t.constants, IntValue(v))
+	t.intConstants[v] = len(t.constants) - 1
+	return len(t.constants) - 1
+}
+
+// stackOperandCount returns the number of operands following op
+func stackOperandCount(op OpCode) int {
+	switch op {
+	case OpMakeClosure, OpMakeClosureWide, OpIncGlobal, OpDecGlobal, OpIncLocal, OpDecLocal, OpCallBuiltin,
+		OpCheckType, OpCheckTypeWide:
+		return 2
+	case OpPush, OpPushWide, OpCopyConst, OpCopyConstWide, OpLoadGlobal, OpStoreGlobal, OpLoadLocal, OpStoreLocal,
+		OpLoadFree, OpJump, OpJumpIfFalse, OpJumpIfTrue, OpTry, OpCall,
+		OpGetBuiltin, OpArray, OpMap, OpStruct, OpStructOrdered, OpTuple, OpTupleGet,
+		OpAddLocal, OpSubLocal, OpMulLocal, OpDivLocal,
+		OpGetFieldOffset, OpSetFieldOffset, OpMakeVariant, OpTestVariant, OpTestArray,
+		OpAddConstInt, OpSubConstInt, OpMulConstInt, OpDivConstInt, OpModConstInt,
+		OpAddConstFloat, OpSubConstFloat, OpMulConstFloat, OpDivConstFloat,
+		OpLtConstInt, OpGtConstInt, OpLeConstInt, OpGeConstInt, OpEqConstInt, OpNeConstInt,
+		OpLtConstFloat, OpGtConstFloat, OpLeConstFloat, OpGeConstFloat, OpEqConstFloat, OpNeConstFloat:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// stackEffect returns how many values op pops and pushes
+func stackEffect(op OpCode, operands []int) (pops, pushes int, err error) {
+	switch op {
+	case OpPush, OpPushWide, OpCopyConst, OpCopyConstWide, OpLoadGlobal, OpLoadLocal, OpLoadFree, OpGetBuiltin:
+		return 0, 1, nil
+	case OpPop, OpPopResult, OpStoreGlobal, OpStoreLocal, OpJumpIfFalse, OpJumpIfTrue, OpReturn, OpPrint:
+		return 1, 0, nil
+	case OpDup:
+		return 1, 2, nil
+	case OpSwap:
+		return 2, 2, nil
+	case OpAdd, OpSub, OpMul, OpDiv, OpMod,
+		OpAddInt, OpAddFloat, OpAddString, OpSubInt, OpSubFloat, OpMulInt, OpMulFloat,
+		OpDivInt, OpDivFloat, OpDivPromote, OpModInt,
+		OpEq, OpNe, OpLt, OpGt, OpLe, OpGe,
+		OpEqInt, OpEqFloat, OpEqString, OpEqBool, OpNeInt, OpNeFloat, OpNeString, OpNeBool,
+		OpLtInt, OpLtFloat, OpGtInt, OpGtFloat, OpLeInt, OpLeFloat, OpGeInt, OpGeFloat,
+		OpAnd, OpOr, OpArrayGet, OpMapGet, OpGetField, OpTestStruct,
+		OpMinInt, OpMinFloat, OpMaxInt, OpMaxFloat,
+		OpAddChecked, OpSubChecked, OpMulChecked, OpDivChecked, OpIn:
+		return 2, 1, nil
+	case OpNeg, OpNot, OpCheckType, OpCheckTypeWide, OpAddLocal, OpSubLocal, OpMulLocal, OpDivLocal,
+		OpGetFieldOffset, OpSquareInt, OpSquareFloat,
+		OpMakeVariant, OpTestVariant, OpVariantTag, OpVariantPayload, OpTestArray,
+		OpAbsInt, OpAbsFloat, OpSqrtInt, OpSqrtFloat, OpWrapInt32, OpNegChecked, OpTupleGet,
+		OpAddConstInt, OpSubConstInt, OpMulConstInt, OpDivConstInt, OpModConstInt,
+		OpAddConstFloat, OpSubConstFloat, OpMulConstFloat, OpDivConstFloat,
+		OpLtConstInt, OpGtConstInt, OpLeConstInt, OpGeConstInt, OpEqConstInt, OpNeConstInt,
+		OpLtConstFloat, OpGtConstFloat, OpLeConstFloat, OpGeConstFloat, OpEqConstFloat, OpNeConstFloat:
+		return 1, 1, nil
+	case OpArraySet, OpMapSet, OpSetField:
+		return 3, 0, nil
+	case OpSlice:
+		return 3, 1, nil
+	case OpSetFieldOffset:
+		return 2, 0, nil
+	case OpJump, OpHalt, OpIncGlobal, OpDecGlobal, OpIncLocal, OpDecLocal, OpTry, OpEndTry:
+		return 0, 0, nil
+	case OpCall:
+		return operands[0] + 1, 1, nil
+	case OpCallBuiltin:
+		return operands[1], 1, nil
+	case OpMakeClosure, OpMakeClosureWide:
+		return operands[1], 1, nil
+	case OpArray, OpTuple:
+		return operands[0], 1, nil
+	case OpMap:
+		return 2 * operands[0], 1, nil
+	case OpStruct, OpStructOrdered:
+		return 2*operands[0] + 1, 1, nil
+	default:
+		return 0, 0, fmt.Errorf("cannot translate opcode %s", op)
+	}
+}
+
+// stackInstruction is a decoded stack instruction
+type stackInstruction struct {
+	ip       int
+	op       OpCode
+	operands []int
+	next     int
+}
+
+func decodeStackInstructions(ins []byte) []stackInstruction {
+	var decoded []stackInstruction
+	for ip := 0; ip < len(ins); {
+		op := OpCode(ins[ip])
+		n := stackOperandCount(op)
+		width := OperandWidth(op)
+		operands := make([]int, n)
+		for i := 0; i < n; i++ {
+			if width == 4 {
+				operands[i], _ = ReadWideOperand(ins, ip+1+width*i)
+			} else {
+				operands[i], _ = ReadOperand(ins, ip+1+width*i)
+			}
+		}
+		next := ip + 1 + width*n
+		decoded = append(decoded, stackInstruction{ip: ip, op: op, operands: operands, next: next})
+		ip = next
+	}
+	return decoded
+}
+
+// stackDepths computes the operand stack depth before each reachable
+// instruction, returning the depths (-1 when unreachable) and the maximum depth
+func stackDepths(decoded []stackInstruction, index map[int]int) ([]int, int, error) {
+	depths := make([]int, len(decoded))
+	for i := range depths {
+		depths[i] = -1
+	}
+
+	maxDepth := 0
+	worklist := []int{}
+	visit := func(ip, depth int) error {
+		i, ok := index[ip]
+		if !ok {
+			// Falling off the end of the stream is a normal exit
+			return nil
+		}
+		if depths[i] == -1 {
+			depths[i] = depth
+			worklist = append(worklist, i)
+			return nil
+		}
+		if depths[i] != depth {
+			return fmt.Errorf("inconsistent stack depth at %04d (%d vs %d)", ip, depths[i], depth)
+		}
+		return nil
+	}
+
+	if len(decoded) > 0 {
+		depths[0] = 0
+		worklist = append(worklist, 0)
+	}
+
+	for len(worklist) > 0 {
+		i := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+		si := decoded[i]
+
+		pops, pushes, err := stackEffect(si.op, si.operands)
+		if err != nil {
+			return nil, 0, err
+		}
+		if depths[i] < pops {
+			return nil, 0, fmt.Errorf("stack underflow at %04d", si.ip)
+		}
+		depth := depths[i] - pops + pushes
+		if depth > maxDepth {
+			maxDepth = depth
+		}
+
+		switch si.op {
+		case OpJump:
+			err = visit(si.operands[0], depth)
+		case OpJumpIfFalse, OpJumpIfTrue:
+			if err = visit(si.operands[0], depth); err == nil {
+				err = visit(si.next, depth)
+			}
+		case OpTry:
+			// The catch starts with the error pushed
+			if err = visit(si.operands[0], depth+1); err == nil {
+				err = visit(si.next, depth)
+			}
+		case OpReturn, OpHalt:
+			// No successors
+		default:
+			err = visit(si.next, depth)
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return depths, maxDepth, nil
+}
+
+// typedRegisterOps maps type-specialized stack opcodes to their register equivalents
+var typedRegisterOps = map[OpCode]RegisterOpCode{
+	OpAdd: OpRAdd, OpSub: OpRSub, OpMul: OpRMul, OpDiv: OpRDiv, OpMod: OpRMod,
+	OpEq: OpREq, OpNe: OpRNe, OpLt: OpRLt, OpGt: OpRGt, OpLe: OpRLe, OpGe: OpRGe,
+	OpAddInt: OpRAddInt, OpAddFloat: OpRAddFloat, OpAddString: OpRAdd,
+	OpSubInt: OpRSubInt, OpSubFloat: OpRSubFloat,
+	OpMulInt: OpRMulInt, OpMulFloat: OpRMulFloat,
+	OpDivInt: OpRDivInt, OpDivFloat: OpRDivFloat, OpDivPromote: OpRDivPromote, OpModInt: OpRModInt,
+	OpEqInt: OpREqInt, OpEqFloat: OpREqFloat, OpEqString: OpREqString, OpEqBool: OpREqBool,
+	OpNeInt: OpRNeInt, OpNeFloat: OpRNeFloat, OpNeString: OpRNeString, OpNeBool: OpRNeBool,
+	OpLtInt: OpRLtInt, OpLtFloat: OpRLtFloat, OpGtInt: OpRGtInt, OpGtFloat: OpRGtFloat,
+	OpLeInt: OpRLeInt, OpLeFloat: OpRLeFloat, OpGeInt: OpRGeInt, OpGeFloat: OpRGeFloat,
+	OpAnd: OpRAnd, OpOr: OpROr,
+	OpAddChecked: OpRAddChecked, OpSubChecked: OpRSubChecked,
+	OpMulChecked: OpRMulChecked, OpDivChecked: OpRDivChecked,
+	OpIn: OpRIn,
+
+	// Local and constant operand forms reuse the same register arithmetic
+	OpAddLocal: OpRAdd, OpSubLocal: OpRSub, OpMulLocal: OpRMul, OpDivLocal: OpRDiv,
+	OpAddConstInt: OpRAddInt, OpSubConstInt: OpRSubInt, OpMulConstInt: OpRMulInt,
+	OpDivConstInt: OpRDivInt, OpModConstInt: OpRModInt,
+	OpAddConstFloat: OpRAddFloat, OpSubConstFloat: OpRSubFloat,
+	OpMulConstFloat: OpRMulFloat, OpDivConstFloat: OpRDivFloat,
+	OpLtConstInt: OpRLtInt, OpGtConstInt: OpRGtInt, OpLeConstInt: OpRLeInt,
+	OpGeConstInt: OpRGeInt, OpEqConstInt: OpREqInt, OpNeConstInt: OpRNeInt,
+	OpLtConstFloat: OpRLtFloat, OpGtConstFloat: OpRGtFloat, OpLeConstFloat: OpRLeFloat,
+	OpGeConstFloat: OpRGeFloat, OpEqConstFloat: OpREqFloat, OpNeConstFloat: OpRNeFloat,
+}
+
+// fusedConstOps are the register opcodes that take a constant index in C
+var fusedConstOps = map[OpCode]RegisterOpCode{
+	OpAddConstInt: OpRAddConstInt, OpMulConstInt: OpRMulConstInt,
+	OpAddConstFloat: OpRAddConstFloat, OpMulConstFloat: OpRMulConstFloat,
+}
+
+// translate converts one stack instruction stream, returning the register
+// instructions, their source positions and the number of registers the
+// frame needs. With keepResult, the last register holds the value OpPopResult
+// last popped.
+func (t *translator) translate(ins []byte, lines LineTable, numLocals int, keepResult bool) ([]RegisterInstruction, LineTable, int, error) {
+	decoded := decodeStackInstructions(ins)
+	index := make(map[int]int, len(decoded))
+	for i, si := range decoded {
+		index[si.ip] = i
+	}
+
+	depths, maxDepth, err := stackDepths(decoded, index)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	numRegs := numLocals + maxDepth + 2
+	resultReg := -1
+	if keepResult {
+		resultReg = numRegs
+		numRegs++
+	}
+	if numRegs > MaxRegisters {
+		return nil, nil, 0, fmt.Errorf("needs %d registers (max %d)", numRegs, MaxRegisters)
+	}
+
+	var out []RegisterInstruction
+	var outLines LineTable
+	emit := func(op RegisterOpCode, a, b, c int) {
+		out = append(out, EncodeRegisterInstruction(op, uint8(a), uint8(b), uint8(c)))
+	}
+	emitBx := func(op RegisterOpCode, a, bx int) {
+		out = append(out, EncodeRegisterInstructionBx(op, uint8(a), uint16(bx)))
+	}
+	// emitK emits an instruction reading constant index, which may not fit Bx
+	emitK := func(op RegisterOpCode, a, index int) error {
+		k, err := EncodeRegisterConstant(op, uint8(a), index)
+		out = append(out, k...)
+		return err
+	}
+
+	// emitCheckType emits a check that R(a) has type want, failing with
+	// message constant index
+	emitCheckType := func(a, want, index int) error {
+		k, err := EncodeRegisterCheckType(uint8(a), ValueType(want), index)
+		out = append(out, k...)
+		return err
+	}
+
+	pcMap := make(map[int]int, len(decoded)+1) // stack ip -> register pc
+	type jumpFixup struct {
+		pc, target int
+	}
+	var fixups []jumpFixup
+
+	for i, si := range decoded {
+		pcMap[si.ip] = len(out)
+		if depths[i] < 0 {
+			// Unreachable code is dropped
+			continue
+		}
+		if pos, ok := lines.Lookup(si.ip); ok {
+			outLines = outLines.Add(len(out), pos)
+		}
+
+		d := depths[i]
+		reg := func(slot int) int { return numLocals + slot }
+		top := reg(d - 1)
+		scratch := reg(d)
+
+		switch si.op {
+		case OpPush, OpPushWide:
+			if op, ok := ImmediateLoad(t.constants[si.operands[0]]); ok {
+				emit(op, reg(d), 0, 0)
+			} else {
+				err = emitK(OpRLoadK, reg(d), si.operands[0])
+			}
+		case OpCopyConst, OpCopyConstWide:
+			err = emitK(OpRLoadKCopy, reg(d), si.operands[0])
+		case OpPop:
+			// Value simply stays in its register
+		case OpPopResult:
+			if resultReg >= 0 {
+				emit(OpRMove, resultReg, top, 0)
+			}
+		case OpDup:
+			emit(OpRMove, reg(d), top, 0)
+		case OpSwap:
+			emit(OpRMove, scratch, top, 0)
+			emit(OpRMove, top, reg(d-2), 0)
+			emit(OpRMove, reg(d-2), scratch, 0)
+
+		case OpAdd, OpSub, OpMul, OpDiv, OpMod,
+			OpAddInt, OpAddFloat, OpAddString, OpSubInt, OpSubFloat, OpMulInt, OpMulFloat,
+			OpDivInt, OpDivFloat, OpDivPromote, OpModInt,
+			OpEq, OpNe, OpLt, OpGt, OpLe, OpGe,
+			OpEqInt, OpEqFloat, OpEqString, OpEqBool, OpNeInt, OpNeFloat, OpNeString, OpNeBool,
+			OpLtInt, OpLtFloat, OpGtInt, OpGtFloat, OpLeInt, OpLeFloat, OpGeInt, OpGeFloat,
+			OpAnd, OpOr, OpAddChecked, OpSubChecked, OpMulChecked, OpDivChecked, OpIn:
+			emit(typedRegisterOps[si.op], reg(d-2), reg(d-2), top)
+
+		case OpAddLocal, OpSubLocal, OpMulLocal, OpDivLocal:
+			emit(typedRegisterOps[si.op], top, top, si.operands[0])
+
+		case OpAddConstInt, OpMulConstInt, OpAddConstFloat, OpMulConstFloat:
+			constIndex := si.operands[0]
+			if constIndex <= 0xFF {
+				emit(fusedConstOps[si.op], top, top, constIndex)
+			} else {
+				emitBx(OpRLoadK, scratch, constIndex)
+				emit(typedRegisterOps[si.op], top, top, scratch)
+			}
+
+		case OpSubConstInt, OpDivConstInt, OpModConstInt, OpSubConstFloat, OpDivConstFloat,
+			OpLtConstInt, OpGtConstInt, OpLeConstInt, OpGeConstInt, OpEqConstInt, OpNeConstInt,
+			OpLtConstFloat, OpGtConstFloat, OpLeConstFloat, OpGeConstFloat, OpEqConstFloat, OpNeConstFloat:
+			emitBx(OpRLoadK, scratch, si.operands[0])
+			emit(typedRegisterOps[si.op], top, top, scratch)
+
+		case OpIncGlobal, OpDecGlobal:
+			op := OpRInc
+			if si.op == OpDecGlobal {
+				op = OpRDec
+			}
+			emitBx(OpRLoadGlobal, scratch, si.operands[0])
+			emitBx(op, scratch, si.operands[1])
+			emitBx(OpRStoreGlobal, scratch, si.operands[0])
+
+		case OpIncLocal, OpDecLocal:
+			op := OpRInc
+			if si.op == OpDecLocal {
+				op = OpRDec
+			}
+			emitBx(op, si.operands[0], si.operands[1])
+
+		case OpNeg:
+			emit(OpRNeg, top, top, 0)
+		case OpNot:
+			emit(OpRNot, top, top, 0)
+		case OpAbsInt:
+			emit(OpRAbsInt, top, top, 0)
+		case OpAbsFloat:
+			emit(OpRAbsFloat, top, top, 0)
+		case OpSqrtInt:
+			emit(OpRSqrtInt, top, top, 0)
+		case OpSqrtFloat:
+			emit(OpRSqrtFloat, top, top, 0)
+		case OpWrapInt32:
+			emit(OpRWrapInt32, top, 0, 0)
+		case OpNegChecked:
+			emit(OpRNegChecked, top, top, 0)
+		case OpMinInt:
+			emit(OpRMinInt, reg(d-2), reg(d-2), top)
+		case OpMinFloat:
+			emit(OpRMinFloat, reg(d-2), reg(d-2), top)
+		case OpMaxInt:
+			emit(OpRMaxInt, reg(d-2), reg(d-2), top)
+		case OpMaxFloat:
+			emit(OpRMaxFloat, reg(d-2), reg(d-2), top)
+		case OpMakeVariant:
+			emitBx(OpRMakeVariant, top, si.operands[0])
+		case OpTestVariant:
+			emitBx(OpRTestVariant, top, si.operands[0])
+		case OpVariantTag:
+			emit(OpRVariantTag, top, top, 0)
+		case OpVariantPayload:
+			emit(OpRVariantPayload, top, top, 0)
+		case OpTestStruct:
+			emit(OpRTestStruct, reg(d-2), reg(d-2), top)
+		case OpTestArray:
+			emitBx(OpRTestArray, top, si.operands[0])
+		case OpSquareInt:
+			emit(OpRSquareInt, top, top, 0)
+		case OpSquareFloat:
+			emit(OpRSquareFloat, top, top, 0)
+
+		case OpLoadGlobal:
+			emitBx(OpRLoadGlobal, reg(d), si.operands[0])
+		case OpStoreGlobal:
+			emitBx(OpRStoreGlobal, top, si.operands[0])
+		case OpLoadLocal:
+			emit(OpRMove, reg(d), si.operands[0], 0)
+		case OpStoreLocal:
+			emit(OpRMove, si.operands[0], top, 0)
+		case OpLoadFree:
+			emitBx(OpRLoadFree, reg(d), si.operands[0])
+		case OpGetBuiltin:
+			emitBx(OpRLoadBuiltin, reg(d), si.operands[0])
+
+		case OpJump:
+			fixups = append(fixups, jumpFixup{pc: len(out), target: si.operands[0]})
+			emitBx(OpRJump, 0, 0)
+		case OpJumpIfFalse:
+			fixups = append(fixups, jumpFixup{pc: len(out), target: si.operands[0]})
+			emitBx(OpRJumpF, top, 0)
+		case OpJumpIfTrue:
+			fixups = append(fixups, jumpFixup{pc: len(out), target: si.operands[0]})
+			emitBx(OpRJumpT, top, 0)
+		case OpTry:
+			fixups = append(fixups, jumpFixup{pc: len(out), target: si.operands[0]})
+			emitBx(OpRTry, reg(d), 0)
+		case OpEndTry:
+			emit(OpREndTry, 0, 0, 0)
+
+		case OpCall:
+			numArgs := si.operands[0]
+			emit(OpRInvoke, reg(d-1-numArgs), numArgs, 0)
+		case OpCallBuiltin:
+			index, numArgs := si.operands[0], si.operands[1]
+			base := reg(d - numArgs)
+			if index <= 0x0F && numArgs <= 0x0F {
+				emit(OpRBuiltin, base, index|numArgs<<4, base)
+				break
+			}
+			// Too large for OpRBuiltin's 4-bit fields: shift the arguments up
+			// one register (into the first scratch slot) to make room for the
+			// builtin value and invoke it
+			for i := numArgs - 1; i >= 0; i-- {
+				emit(OpRMove, base+i+1, base+i, 0)
+			}
+			emitBx(OpRLoadBuiltin, base, index)
+			emit(OpRInvoke, base, numArgs, 0)
+		case OpReturn:
+			emit(OpRReturn, top, 0, 0)
+		case OpCheckType, OpCheckTypeWide:
+			err = emitCheckType(top, si.operands[1], si.operands[0])
+		case OpMakeClosure, OpMakeClosureWide:
+			fnIndex, numFree := si.operands[0], si.operands[1]
+			fn, ok := t.translatedFns[fnIndex]
+			if !ok {
+				return nil, nil, 0, fmt.Errorf("closure constant %d is not a function", fnIndex)
+			}
+			fn.NumFree = numFree
+			err = emitK(OpRMakeClosure, reg(d-numFree), fnIndex)
+
+		case OpArray:
+			n := si.operands[0]
+			emit(OpRArrayFrom, reg(d-n), n, 0)
+		case OpTuple:
+			n := si.operands[0]
+			emit(OpRTupleFrom, reg(d-n), n, 0)
+		case OpTupleGet:
+			emit(OpRTupleGet, top, top, si.operands[0])
+		case OpArrayGet:
+			emit(OpRGetIdx, reg(d-2), reg(d-2), top)
+		case OpArraySet:
+			emit(OpRSetIdx, reg(d-3), reg(d-2), top)
+		case OpSlice:
+			emit(OpRSlice, reg(d-3), reg(d-3), reg(d-2))
+		case OpMap:
+			n := si.operands[0]
+			emit(OpRMapFrom, reg(d-2*n), n, 0)
+		case OpMapGet:
+			emit(OpRMapGet, reg(d-2), reg(d-2), top)
+		case OpMapSet:
+			emit(OpRMapSet, reg(d-3), reg(d-2), top)
+
+		case OpStruct, OpStructOrdered:
+			n := si.operands[0]
+			ordered := 0
+			if si.op == OpStructOrdered {
+				ordered = 1
+			}
+			emit(OpRStructFrom, reg(d-2*n-1), n, ordered)
+		case OpGetField:
+			emit(OpRGetFieldName, reg(d-2), reg(d-2), top)
+		case OpSetField:
+			emit(OpRSetFieldName, reg(d-3), reg(d-2), top)
+		case OpGetFieldOffset:
+			if si.operands[0] > 0xFF {
+				return nil, nil, 0, fmt.Errorf("field offset %d too large for register encoding", si.operands[0])
+			}
+			emit(OpRGetField, top, top, si.operands[0])
+		case OpSetFieldOffset:
+			if si.operands[0] > 0xFF {
+				return nil, nil, 0, fmt.Errorf("field offset %d too large for register encoding", si.operands[0])
+			}
+			emit(OpRSetField, reg(d-2), si.operands[0], top)
+
+		case OpHalt:
+			emit(OpRHalt, 0, 0, 0)
+
+		default:
+			return nil, nil, 0, fmt.Errorf("cannot translate opcode %s at %04d", si.op, si.ip)
+		}
+		if err != nil {
+			return nil, nil, 0, err
+		}
+	}
+	pcMap[len(ins)] = len(out)
+
+	far := make(map[int]int)
+	for _, f := range fixups {
+		target, ok := pcMap[f.target]
+		if !ok {
+			return nil, nil, 0, fmt.Errorf("jump to invalid address %04d", f.target)
+		}
+		op, a, _ := out[f.pc].DecodeBx()
+		if FarJump(op, f.pc, target) {
+			far[f.pc] = target
+			continue
+		}
+		if out[f.pc], err = EncodeRegisterJump(op, a, f.pc, target); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+	if out, outLines, err = LongJumps(out, outLines, far); err != nil {
+		return nil, nil, 0, err
+	}
+
+	return out, outLines, numRegs, nil
+}
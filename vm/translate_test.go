@@ -0,0 +1,330 @@
+package vm
+
+import (
+	"strings"
+	"testing"
+)
+
+func runTranslated(t *testing.T, bytecode *Bytecode) *RegisterVM {
+	t.Helper()
+
+	registerBytecode, err := TranslateToRegister(bytecode)
+	if err != nil {
+		t.Fatalf("translation error: %s", err)
+	}
+
+	machine := NewRegisterVM(registerBytecode)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("register vm error: %s", err)
+	}
+	return machine
+}
+
+func TestTranslateArithmeticAndGlobals(t *testing.T) {
+	// g0 = 6 * 7; g0 = g0 + 1
+	bytecode := &Bytecode{
+		Instructions: concatInstructions(
+			Make(OpPush, 0),
+			Make(OpPush, 1),
+			Make(OpMul),
+			Make(OpStoreGlobal, 0),
+			Make(OpIncGlobal, 0, 1),
+		),
+		Constants: []Value{IntValue(6), IntValue(7)},
+	}
+
+	machine := runTranslated(t, bytecode)
+	if got := machine.globals[0]; got.Type != IntType || got.AsInt() != 43 {
+		t.Errorf("expected 43, got %s", got.String())
+	}
+}
+
+func TestTranslateLoopWithJumps(t *testing.T) {
+	// g0 = 0; g1 = 0; while g0 < 5 { g1 = g1 + g0; g0 = g0 + 1 }
+	bytecode := &Bytecode{
+		Instructions: concatInstructions(
+			Make(OpPush, 0),         // 0000
+			Make(OpStoreGlobal, 0),  // 0003
+			Make(OpPush, 0),         // 0006
+			Make(OpStoreGlobal, 1),  // 0009
+			Make(OpPush, 1),         // 0012 loop start
+			Make(OpLoadGlobal, 0),   // 0015
+			Make(OpGt),              // 0018 5 > g0
+			Make(OpJumpIfFalse, 44), // 0019
+			Make(OpLoadGlobal, 1),   // 0024
+			Make(OpLoadGlobal, 0),   // 0027
+			Make(OpAdd),             // 0030
+			Make(OpStoreGlobal, 1),  // 0031
+			Make(OpIncGlobal, 0, 1), // 0034
+			Make(OpJump, 12),        // 0039
+		),
+		Constants: []Value{IntValue(0), IntValue(5)},
+	}
+
+	machine := runTranslated(t, bytecode)
+	if got := machine.globals[1]; got.AsInt() != 10 {
+		t.Errorf("expected 10, got %s", got.String())
+	}
+}
+
+func TestTranslateFunctionCallAndBuiltin(t *testing.T) {
+	// func square(n) { return n * n }; g0 = square(len([1, 2, 3]))
+	square := &Function{
+		Name:      "square",
+		NumParams: 1,
+		NumLocals: 1,
+		Instructions: concatInstructions(
+			Make(OpLoadLocal, 0),
+			Make(OpLoadLocal, 0),
+			Make(OpMul),
+			Make(OpReturn),
+		),
+	}
+
+	bytecode := &Bytecode{
+		Instructions: concatInstructions(
+			Make(OpPush, 0),
+			Make(OpGetBuiltin, 1), // len
+			Make(OpPush, 1),
+			Make(OpPush, 2),
+			Make(OpPush, 3),
+			Make(OpArray, 3),
+			Make(OpCall, 1),
+			Make(OpCall, 1),
+			Make(OpStoreGlobal, 0),
+		),
+		Constants: []Value{NewFunctionValue(square), IntValue(1), IntValue(2), IntValue(3)},
+	}
+
+	machine := runTranslated(t, bytecode)
+	if got := machine.globals[0]; got.AsInt() != 9 {
+		t.Errorf("expected 9, got %s", got.String())
+	}
+}
+
+func TestTranslateCallBuiltin(t *testing.T) {
+	// g0 = len("abc"); g1 = string(g0) (builtin 20 needs the OpRInvoke fallback)
+	bytecode := &Bytecode{
+		Instructions: concatInstructions(
+			Make(OpPush, 0),
+			Make(OpCallBuiltin, 1, 1),
+			Make(OpStoreGlobal, 0),
+			Make(OpLoadGlobal, 0),
+			Make(OpCallBuiltin, 20, 1),
+			Make(OpStoreGlobal, 1),
+		),
+		Constants: []Value{StringValue("abc")},
+	}
+
+	machine := runTranslated(t, bytecode)
+	if got := machine.globals[0]; got.Type != IntType || got.AsInt() != 3 {
+		t.Errorf("expected 3, got %s", got.String())
+	}
+	if got := machine.globals[1]; got.Type != StringType || got.AsString() != "3" {
+		t.Errorf("expected \"3\", got %s", got.String())
+	}
+
+	stackVM := New(bytecode)
+	if err := stackVM.Run(); err != nil {
+		t.Fatalf("stack vm error: %s", err)
+	}
+	if got := stackVM.globals[1]; got.Type != StringType || got.AsString() != "3" {
+		t.Errorf("stack vm: expected \"3\", got %s", got.String())
+	}
+}
+
+func TestTranslateClosure(t *testing.T) {
+	// adder captures free[0] and adds its argument
+	adder := &Function{
+		Name:      "adder",
+		NumParams: 1,
+		NumLocals: 1,
+		Instructions: concatInstructions(
+			Make(OpLoadFree, 0),
+			Make(OpLoadLocal, 0),
+			Make(OpAdd),
+			Make(OpReturn),
+		),
+	}
+
+	bytecode := &Bytecode{
+		Instructions: concatInstructions(
+			Make(OpPush, 1),
+			Make(OpMakeClosure, 0, 1),
+			Make(OpPush, 2),
+			Make(OpCall, 1),
+			Make(OpStoreGlobal, 0),
+		),
+		Constants: []Value{NewFunctionValue(adder), IntValue(40), IntValue(2)},
+	}
+
+	machine := runTranslated(t, bytecode)
+	if got := machine.globals[0]; got.AsInt() != 42 {
+		t.Errorf("expected 42, got %s", got.String())
+	}
+}
+
+func TestTranslateRejectsInconsistentStack(t *testing.T) {
+	// One path reaches 0011 with an extra value on the stack
+	bytecode := &Bytecode{
+		Instructions: concatInstructions(
+			Make(OpPush, 0),        // 0000
+			Make(OpJumpIfTrue, 11), // 0003
+			Make(OpPush, 0),        // 0008
+			Make(OpPop),            // 0011
+		),
+		Constants: []Value{BoolValue(true)},
+	}
+
+	if _, err := TranslateToRegister(bytecode); err == nil {
+		t.Fatal("expected inconsistent stack depth error")
+	}
+}
+
+func TestTranslateVariants(t *testing.T) {
+	// g0 = variant 3("some"); g1 = g0 is 3; g2 = g0 is 2; g3 = tag(g0); g4 = payload(g0)
+	bytecode := &Bytecode{
+		Instructions: concatInstructions(
+			Make(OpPush, 0),
+			Make(OpMakeVariant, 3),
+			Make(OpStoreGlobal, 0),
+			Make(OpLoadGlobal, 0),
+			Make(OpTestVariant, 3),
+			Make(OpStoreGlobal, 1),
+			Make(OpLoadGlobal, 0),
+			Make(OpTestVariant, 2),
+			Make(OpStoreGlobal, 2),
+			Make(OpLoadGlobal, 0),
+			Make(OpVariantTag),
+			Make(OpStoreGlobal, 3),
+			Make(OpLoadGlobal, 0),
+			Make(OpVariantPayload),
+			Make(OpStoreGlobal, 4),
+		),
+		Constants: []Value{StringValue("some")},
+	}
+
+	stackVM := New(bytecode)
+	if err := stackVM.Run(); err != nil {
+		t.Fatalf("stack vm error: %s", err)
+	}
+	machine := runTranslated(t, bytecode)
+
+	for name, globals := range map[string][]Value{"stack": stackVM.globals, "register": machine.globals} {
+		if got := globals[0].String(); got != "<variant 3: some>" {
+			t.Errorf("%s vm: expected <variant 3: some>, got %s", name, got)
+		}
+		if !globals[1].AsBool() || globals[2].AsBool() {
+			t.Errorf("%s vm: expected tag tests true, false, got %s, %s", name, globals[1], globals[2])
+		}
+		if got := globals[3]; got.Type != IntType || got.AsInt() != 3 {
+			t.Errorf("%s vm: expected tag 3, got %s", name, got)
+		}
+		if got := globals[4]; got.Type != StringType || got.AsString() != "some" {
+			t.Errorf("%s vm: expected payload some, got %s", name, got)
+		}
+	}
+}
+
+func TestTranslateShapeTests(t *testing.T) {
+	// g0 = point is a Point; g1 = point is a Line; g2 = pair has 2 elements;
+	// g3 = pair has 3; g4 = point has 2
+	bytecode := &Bytecode{
+		Instructions: concatInstructions(
+			Make(OpPush, 0),
+			Make(OpPush, 2),
+			Make(OpTestStruct),
+			Make(OpStoreGlobal, 0),
+			Make(OpPush, 0),
+			Make(OpPush, 3),
+			Make(OpTestStruct),
+			Make(OpStoreGlobal, 1),
+			Make(OpPush, 1),
+			Make(OpTestArray, 2),
+			Make(OpStoreGlobal, 2),
+			Make(OpPush, 1),
+			Make(OpTestArray, 3),
+			Make(OpStoreGlobal, 3),
+			Make(OpPush, 0),
+			Make(OpTestArray, 2),
+			Make(OpStoreGlobal, 4),
+		),
+		Constants: []Value{
+			NewStructValue("Point", map[string]Value{"x": IntValue(1), "y": IntValue(2)}),
+			NewArrayFromElements([]Value{IntValue(1), IntValue(2)}),
+			StringValue("Point"),
+			StringValue("Line"),
+		},
+	}
+
+	stackVM := New(bytecode)
+	if err := stackVM.Run(); err != nil {
+		t.Fatalf("stack vm error: %s", err)
+	}
+	machine := runTranslated(t, bytecode)
+
+	want := []bool{true, false, true, false, false}
+	for name, globals := range map[string][]Value{"stack": stackVM.globals, "register": machine.globals} {
+		for i, w := range want {
+			if got := globals[i]; got.Type != BoolType || got.AsBool() != w {
+				t.Errorf("%s vm: g%d expected %t, got %s", name, i, w, got)
+			}
+		}
+	}
+}
+
+func TestTranslateVariantPayloadOfNonVariant(t *testing.T) {
+	bytecode := &Bytecode{
+		Instructions: concatInstructions(
+			Make(OpPush, 0),
+			Make(OpVariantPayload),
+			Make(OpPop),
+		),
+		Constants: []Value{IntValue(1)},
+	}
+
+	if err := New(bytecode).Run(); err == nil || !strings.Contains(err.Error(), "expected a variant, got int") {
+		t.Errorf("stack vm: expected variant error, got %v", err)
+	}
+	registerBytecode, err := TranslateToRegister(bytecode)
+	if err != nil {
+		t.Fatalf("translation error: %s", err)
+	}
+	if err := NewRegisterVM(registerBytecode).Run(); err == nil || !strings.Contains(err.Error(), "expected a variant, got int") {
+		t.Errorf("register vm: expected variant error, got %v", err)
+	}
+}
+
+func TestTranslateImmediateLoads(t *testing.T) {
+	// g0 = true; g1 = false; g2 = nil; g3 = 0
+	bytecode := &Bytecode{
+		Instructions: concatInstructions(
+			Make(OpPush, 0),
+			Make(OpStoreGlobal, 0),
+			Make(OpPush, 1),
+			Make(OpStoreGlobal, 1),
+			Make(OpPush, 2),
+			Make(OpStoreGlobal, 2),
+			Make(OpPush, 3),
+			Make(OpStoreGlobal, 3),
+		),
+		Constants: []Value{BoolValue(true), BoolValue(false), NilValue(), IntValue(0)},
+	}
+
+	registerBytecode, err := TranslateToRegister(bytecode)
+	if err != nil {
+		t.Fatalf("translation error: %s", err)
+	}
+	listing := RegisterDisassemble(registerBytecode.Instructions)
+	if strings.Contains(listing, OpRLoadK.String()+" ") {
+		t.Errorf("expected no %s\n%s", OpRLoadK, listing)
+	}
+
+	machine := runTranslated(t, bytecode)
+	want := []string{"true", "false", "nil", "0"}
+	for i, w := range want {
+		if got := machine.globals[i].String(); got != w {
+			t.Errorf("g%d: expected %s, got %s", i, w, got)
+		}
+	}
+}
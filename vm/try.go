@@ -0,0 +1,106 @@
+package vm
+
+// Error handling
+//
+// try { body } catch e { handler } compiles to
+//
+//	TRY catch
+//	body
+//	END_TRY
+//	JUMP end
+//	catch: store the error in e
+//	handler
+//	end:
+//
+// TRY registers a handler: the frame it runs in, where its catch starts and,
+// in the stack VM, how deep the stack was. END_TRY drops it again, as do the
+// returns, breaks and continues that leave the body. When a runtime error
+// stops dispatch, execute hands it to the innermost handler: the frames
+// called since the try are abandoned, the stack is cut back to where it was,
+// and the catch runs with the error as a value whose String is its message.
+// If the error is a panic, recover() returns its value, see panic.go.
+//
+// A builtin calling back into the VM, as map does, runs a dispatch loop of
+// its own with the frame it called from as its floor. That loop catches only
+// errors whose handler sits above the floor; any other error returns to the
+// builtin, fails it, and reaches the handler from the frame that called it.
+// Exits are not errors the program can catch.
+
+// handler is a try block active in the stack VM
+type handler struct {
+	frame int // framesIndex when the try began
+	sp    int // Stack depth when the try began
+	ip    int // Start of the catch
+}
+
+// registerHandler is a try block active in the register VM
+type registerHandler struct {
+	frame int // frameIndex when the try began
+	pc    int // Start of the catch
+	reg   int // Register the catch finds the error in
+}
+
+// execute runs instructions until the frame at floor returns, or until the
+// program ends if floor is 0, running the catch of a try the program is in
+// when an error occurs
+func (vm *VM) execute(floor int) error {
+	for {
+		err := vm.dispatch(floor)
+		if err == nil || !vm.catch(err, floor) {
+			return err
+		}
+	}
+}
+
+// catch resumes at the innermost handler's catch with err pushed, if there is
+// a handler above floor to catch it
+func (vm *VM) catch(err error, floor int) bool {
+	if isExit(err) || len(vm.handlers) == 0 {
+		return false
+	}
+	h := vm.handlers[len(vm.handlers)-1]
+	if h.frame <= floor {
+		return false
+	}
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+	vm.framesIndex = h.frame
+	vm.frames[h.frame-1].ip = h.ip
+	vm.sp = h.sp
+	vm.stack[vm.sp] = ErrorValue(err.Error())
+	vm.sp++
+	vm.env.recovered = caughtPanic(err)
+	return true
+}
+
+// execute runs instructions until the frame at floor returns, or until the
+// program ends if floor is 0, running the catch of a try the program is in
+// when an error occurs
+func (vm *RegisterVM) execute(floor int) error {
+	for {
+		err := vm.dispatch(floor)
+		if err == nil || !vm.catch(err, floor) {
+			return err
+		}
+	}
+}
+
+// catch resumes at the innermost handler's catch with err in its register,
+// if there is a handler above floor to catch it
+func (vm *RegisterVM) catch(err error, floor int) bool {
+	if isExit(err) || len(vm.handlers) == 0 {
+		return false
+	}
+	h := vm.handlers[len(vm.handlers)-1]
+	if h.frame <= floor {
+		return false
+	}
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+	vm.frameIndex = h.frame
+	vm.currentFrame = vm.frames[h.frame-1]
+	vm.currentFrame.pc = h.pc
+	vm.currentFrame.registers[h.reg] = ErrorValue(err.Error())
+	vm.env.recovered = caughtPanic(err)
+	return true
+}
@@ -0,0 +1,50 @@
+package vm
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// Tuples
+//
+// A tuple (a, b) is a fixed number of values of any types, read by position
+// as t.0, t.1 and so on. It holds its elements the way an array does but
+// can't be changed, so a function can return one for several results and
+// entries(m) can hand out a map's keys with their values.
+
+// NewTupleValue returns the tuple of elements, which it keeps
+func NewTupleValue(elements []Value) Value {
+	return Value{Type: TupleType, ptr: unsafe.Pointer(&ArrayValue{Elements: elements})}
+}
+
+// TupleElements returns the elements of the tuple v, which must not be
+// changed
+func (v Value) TupleElements() []Value {
+	return (*ArrayValue)(v.ptr).Elements
+}
+
+// TupleElement implements t.i, the element of the tuple t at position i
+func TupleElement(t Value, i int) (Value, error) {
+	if t.Type != TupleType {
+		return NilValue(), fmt.Errorf("cannot take element %d of %s: not a tuple", i, t.Type)
+	}
+	elements := t.TupleElements()
+	if i >= len(elements) {
+		return NilValue(), fmt.Errorf("tuple of %d elements has no element %d", len(elements), i)
+	}
+	return elements[i], nil
+}
+
+// entriesBuiltin implements entries(m), a (key, value) tuple for each key of
+// the map m, in the order of its keys
+func (env *builtinEnv) entriesBuiltin(args ...Value) (Value, error) {
+	m, err := mapArg("entries", 1, args)
+	if err != nil {
+		return NilValue(), err
+	}
+	entries := make([]Value, len(m.Pairs))
+	for i, key := range m.Keys() {
+		entries[i] = NewTupleValue([]Value{key.Value(), m.Pairs[key]})
+	}
+	return NewArrayFromElements(entries), nil
+}
@@ -0,0 +1,38 @@
+package vm
+
+import "testing"
+
+func TestTuples(t *testing.T) {
+	s := StringValue
+	pair := NewTupleValue([]Value{IntValue(1), s("a")})
+	if pair.String() != `(1, "a")` {
+		t.Errorf(`pair.String() = %s, want (1, "a")`, pair)
+	}
+	if got, err := TupleElement(pair, 1); err != nil || got.String() != "a" {
+		t.Errorf("pair.1 = %v, %v, want a", got, err)
+	}
+	if _, err := TupleElement(pair, 2); err == nil || err.Error() != "tuple of 2 elements has no element 2" {
+		t.Errorf("pair.2: got error %v", err)
+	}
+	if _, err := TupleElement(IntValue(3), 0); err == nil || err.Error() != "cannot take element 0 of int: not a tuple" {
+		t.Errorf("3.0: got error %v", err)
+	}
+
+	if !Equal(pair, NewTupleValue([]Value{IntValue(1), s("a")})) {
+		t.Error("equal tuples compare unequal")
+	}
+	if Equal(pair, NewArrayFromElements([]Value{IntValue(1), s("a")})) {
+		t.Error("a tuple equals an array")
+	}
+
+	m := NewMapValue()
+	m.AsMap().Set(s("b").ToMapKey(), IntValue(2))
+	m.AsMap().Set(s("a").ToMapKey(), IntValue(1))
+	entries, err := defaultBuiltinEnv.entriesBuiltin(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entries.String() != `[("b", 2), ("a", 1)]` {
+		t.Errorf(`entries(m) = %s, want [("b", 2), ("a", 1)]`, entries)
+	}
+}
@@ -1,43 +1,16 @@
 package vm
 
 import (
+	"cmp"
 	"fmt"
 	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"unsafe"
 )
 
-// Pool size limit to prevent unbounded memory growth in long-running programs
-// When a pool exceeds this size, we keep only the most recent entries.
-// This assumes newer allocations are more likely to still be in use.
-const MaxPoolSize = 100000
-
-// String pool to keep strings alive for GC
-// The GC doesn't track uint64, so we need to keep references to heap-allocated data
-// No locking needed - VM is single-threaded
-var stringPool []*string
-
-// Object pools to keep heap-allocated objects alive for GC
-// The GC doesn't track uint64, so we need to keep references to heap-allocated data
-var functionPool []*Function
-var closurePool []*Closure
-var arrayPool []*ArrayValue
-var mapPool []*MapValue
-var structPool []*StructValue
-
-// Builtin function pool - stores function pointers on heap to prevent dangling pointers
-// Note: BuiltinFunction is defined in builtins.go as func(args ...Value) Value
-var builtinFunctionPool []interface{}
-
-// trimPool keeps a pool at a reasonable size by keeping only recent entries
-func trimPool[T any](pool *[]T) {
-	if len(*pool) > MaxPoolSize {
-		// Keep the most recent MaxPoolSize/2 entries
-		// This gives us headroom before hitting the limit again
-		keepSize := MaxPoolSize / 2
-		*pool = (*pool)[len(*pool)-keepSize:]
-	}
-}
-
 // ValueType represents the type of a value
 type ValueType byte
 
@@ -53,14 +26,70 @@ const (
 	ClosureType
 	BuiltinFunctionType
 	NilType
+	BuilderType
+	VariantType
+	ErrorType
+	TupleType
 )
 
+// String returns the name of a value type, as used in runtime errors
+func (t ValueType) String() string {
+	switch t {
+	case IntType:
+		return "int"
+	case FloatType:
+		return "float"
+	case BoolType:
+		return "bool"
+	case StringType:
+		return "string"
+	case ArrayType:
+		return "array"
+	case MapType:
+		return "map"
+	case StructType:
+		return "struct"
+	case FunctionType:
+		return "function"
+	case ClosureType:
+		return "closure"
+	case BuiltinFunctionType:
+		return "builtin"
+	case NilType:
+		return "nil"
+	case BuilderType:
+		return "builder"
+	case VariantType:
+		return "variant"
+	case ErrorType:
+		return "error"
+	case TupleType:
+		return "tuple"
+	default:
+		return fmt.Sprintf("type %d", byte(t))
+	}
+}
+
+// CheckType returns an error unless v has type want, for the runtime checks
+// of type annotations. Closures and builtins pass as functions. The error is
+// message, which describes what was checked, followed by v's actual type.
+func CheckType(v Value, want ValueType, message string) error {
+	if v.Type == want ||
+		want == FunctionType && (v.Type == ClosureType || v.Type == BuiltinFunctionType) {
+		return nil
+	}
+	return fmt.Errorf("%s, got %s", message, v.Type)
+}
+
 // Value represents a runtime value in the VM
-// Uses a tagged union to avoid interface{} boxing overhead
+// Uses a tagged union to avoid interface{} boxing overhead.
+// Heap objects are referenced through ptr, a real Go pointer, so the garbage
+// collector sees them and frees them once no Value refers to them.
 type Value struct {
 	Type ValueType
-	_    [7]byte // Explicit padding for 8-byte alignment
-	Data uint64  // Union: holds int64, float64, bool, or pointer
+	_    [7]byte        // Explicit padding for 8-byte alignment
+	Data uint64         // Union: holds int64, float64, bool, or string length
+	ptr  unsafe.Pointer // Heap object (string bytes, array, map, ...), nil for scalars
 }
 
 // Integer values
@@ -72,12 +101,26 @@ func (v Value) AsInt() int64 {
 	return int64(v.Data)
 }
 
+// WrapInt32 truncates an int to 32 bits, two's complement, for int32 mode.
+// Values of other types are returned unchanged.
+func WrapInt32(v Value) Value {
+	if v.Type != IntType {
+		return v
+	}
+	return IntValue(int64(int32(v.AsInt())))
+}
+
 // Float values
 func FloatValue(f float64) Value {
 	return Value{Type: FloatType, Data: math.Float64bits(f)}
 }
 
+// AsFloat returns v as a float. Ints, which the type checker lets stand for
+// floats, are converted, so float opcodes accept them.
 func (v Value) AsFloat() float64 {
+	if v.Type == IntType {
+		return float64(v.AsInt())
+	}
 	return math.Float64frombits(v.Data)
 }
 
@@ -95,21 +138,13 @@ func (v Value) AsBool() bool {
 }
 
 // String values
+// The string's bytes are referenced directly, so no extra allocation is needed
 func StringValue(s string) Value {
-	// Allocate string on heap and add to pool to keep it alive for GC
-	ptr := new(string)
-	*ptr = s
-
-	// Add to pool so GC doesn't collect it (no locking needed - VM is single-threaded)
-	stringPool = append(stringPool, ptr)
-	trimPool(&stringPool)
-
-	return Value{Type: StringType, Data: uint64(uintptr(unsafe.Pointer(ptr)))}
+	return Value{Type: StringType, Data: uint64(len(s)), ptr: unsafe.Pointer(unsafe.StringData(s))}
 }
 
 func (v Value) AsString() string {
-	ptr := (*string)(unsafe.Pointer(uintptr(v.Data)))
-	return *ptr
+	return unsafe.String((*byte)(v.ptr), int(v.Data))
 }
 
 // Nil value
@@ -148,68 +183,191 @@ func (v Value) String() string {
 		return v.AsString()
 	case NilType:
 		return "nil"
-	case ArrayType:
-		return fmt.Sprintf("%v", v.AsArray())
-	case MapType:
-		return fmt.Sprintf("%v", v.AsMap())
-	case StructType:
-		return fmt.Sprintf("%v", v.AsStruct())
+	case ArrayType, MapType, StructType, TupleType:
+		var out strings.Builder
+		printer{}.write(&out, v)
+		return out.String()
 	case FunctionType:
 		return "<function>"
 	case ClosureType:
 		return "<closure>"
 	case BuiltinFunctionType:
 		return "<builtin>"
+	case BuilderType:
+		return "<builder>"
+	case VariantType:
+		return fmt.Sprintf("<variant %d: %s>", v.VariantTag(), v.VariantPayload().String())
+	case ErrorType:
+		return v.ErrorMessage()
 	default:
 		return "<unknown>"
 	}
 }
 
+// Builder values
+// A builder is a string under construction. It is appended to in place, so
+// adding to it doesn't copy what it already holds.
+func NewBuilderValue() Value {
+	return Value{Type: BuilderType, ptr: unsafe.Pointer(&strings.Builder{})}
+}
+
+func (v Value) AsBuilder() *strings.Builder {
+	return (*strings.Builder)(v.ptr)
+}
+
+// Error values
+// An error is the runtime error a catch block receives, shown as its message.
+// The message is held the way a string's bytes are.
+func ErrorValue(message string) Value {
+	return Value{Type: ErrorType, Data: uint64(len(message)), ptr: unsafe.Pointer(unsafe.StringData(message))}
+}
+
+func (v Value) ErrorMessage() string {
+	return unsafe.String((*byte)(v.ptr), int(v.Data))
+}
+
+// Variant values
+// A variant is a tagged union: a tag saying which alternative it is, and the
+// payload that alternative carries (nil if none). Enum payloads, results and
+// optionals all share this representation. The payload is boxed so the tag
+// stays in Data; a variant is never changed once made.
+func NewVariantValue(tag int, payload Value) Value {
+	return Value{Type: VariantType, Data: uint64(tag), ptr: unsafe.Pointer(&payload)}
+}
+
+func (v Value) VariantTag() int {
+	return int(v.Data)
+}
+
+func (v Value) VariantPayload() Value {
+	return *(*Value)(v.ptr)
+}
+
 // ArrayValue represents an array
 type ArrayValue struct {
 	Elements []Value
+	store    *arrayStore // Set once append leaves spare capacity past Elements; see arrays.go
 }
 
 func NewArrayValue(size int) Value {
-	arr := &ArrayValue{Elements: make([]Value, size)}
-	// Add to pool to keep it alive for GC
-	arrayPool = append(arrayPool, arr)
-	trimPool(&arrayPool)
-	return Value{
-		Type: ArrayType,
-		Data: uint64(uintptr(unsafe.Pointer(arr))),
-	}
+	return NewArrayFromElements(make([]Value, size))
+}
+
+// NewArrayFromElements wraps an existing slice as an array value
+func NewArrayFromElements(elements []Value) Value {
+	return arrayValue(&ArrayValue{Elements: elements})
+}
+
+func arrayValue(a *ArrayValue) Value {
+	return Value{Type: ArrayType, ptr: unsafe.Pointer(a)}
 }
 
 func (v Value) AsArray() *ArrayValue {
-	return (*ArrayValue)(unsafe.Pointer(uintptr(v.Data)))
+	return (*ArrayValue)(v.ptr)
 }
 
-// MapKey represents a map key that can be int or string without allocation
+// MapKey represents a map key that can be int or string without allocation.
+// IntVal and IsInt are adjacent so Go hashes them in one pass, leaving one
+// string hash and one memory hash per key. Go maps always hash the whole key,
+// so a hash cached on a string Value could not be handed to them.
 type MapKey struct {
-	IsInt bool
-	IntVal int64
 	StrVal string
+	IntVal int64
+	IsInt  bool
 }
 
-// MapValue represents a map
+// Value returns the key as the value keys() gives for it: an int, or a
+// string for any other key
+func (k MapKey) Value() Value {
+	if k.IsInt {
+		return IntValue(k.IntVal)
+	}
+	return StringValue(k.StrVal)
+}
+
+// MapValue represents a map. Its keys keep the order they were first set in,
+// which keys() and values() follow, so a program's output doesn't depend on
+// Go's map order. Read Pairs directly, but change it only with Set and
+// Delete, which keep the order.
 type MapValue struct {
 	Pairs map[MapKey]Value
+	order []MapKey       // Keys in the order first set, with gaps where Delete removed one
+	index map[MapKey]int // Each key's place in order, built by the first Delete
+	gaps  int            // Places in order Delete left empty
 }
 
 func NewMapValue() Value {
-	m := &MapValue{Pairs: make(map[MapKey]Value)}
-	// Add to pool to keep it alive for GC
-	mapPool = append(mapPool, m)
-	trimPool(&mapPool)
-	return Value{
-		Type: MapType,
-		Data: uint64(uintptr(unsafe.Pointer(m))),
+	return newMap(0)
+}
+
+// newMap returns an empty map with room for size keys
+func newMap(size int) Value {
+	m := &MapValue{Pairs: make(map[MapKey]Value, size), order: make([]MapKey, 0, size)}
+	return Value{Type: MapType, ptr: unsafe.Pointer(m)}
+}
+
+// Set sets the value of key. A new key goes after the others; one m already
+// has keeps its place.
+func (m *MapValue) Set(key MapKey, value Value) {
+	n := len(m.Pairs)
+	m.Pairs[key] = value
+	if len(m.Pairs) > n {
+		if m.index != nil {
+			m.index[key] = len(m.order)
+		}
+		m.order = append(m.order, key)
 	}
 }
 
+// Delete removes key from m, leaving a gap in the order that Keys, or enough
+// later deletes, close. The first delete from a map indexes its keys; after
+// that, a delete takes constant time on average.
+func (m *MapValue) Delete(key MapKey) {
+	if _, ok := m.Pairs[key]; !ok {
+		return
+	}
+	delete(m.Pairs, key)
+	if m.index == nil {
+		m.index = make(map[MapKey]int, len(m.order))
+		for i, k := range m.order {
+			m.index[k] = i
+		}
+	}
+	m.order[m.index[key]] = MapKey{}
+	delete(m.index, key)
+	m.gaps++
+	if m.gaps > len(m.order)/2 {
+		m.closeGaps()
+	}
+}
+
+// closeGaps removes the places Delete emptied from the order. A place holds
+// a key only if the index still points there, as a key deleted and set again
+// moves to the end.
+func (m *MapValue) closeGaps() {
+	keys := m.order[:0]
+	for i, key := range m.order {
+		if j, ok := m.index[key]; ok && j == i {
+			m.index[key] = len(keys)
+			keys = append(keys, key)
+		}
+	}
+	clear(m.order[len(keys):])
+	m.order = keys
+	m.gaps = 0
+}
+
+// Keys returns m's keys in the order they were first set. The slice is m's
+// own, so it must not be changed.
+func (m *MapValue) Keys() []MapKey {
+	if m.gaps > 0 {
+		m.closeGaps()
+	}
+	return m.order
+}
+
 func (v Value) AsMap() *MapValue {
-	return (*MapValue)(unsafe.Pointer(uintptr(v.Data)))
+	return (*MapValue)(v.ptr)
 }
 
 // ToMapKey converts a Value to a MapKey without allocation for ints
@@ -220,32 +378,139 @@ func (v Value) ToMapKey() MapKey {
 	return MapKey{IsInt: false, StrVal: v.String()}
 }
 
+// copyCollection returns a shallow copy of an array or map; other values are
+// returned as is. Used to hand out private copies of constant collections.
+func copyCollection(v Value) Value {
+	switch v.Type {
+	case ArrayType:
+		elements := make([]Value, len(v.AsArray().Elements))
+		copy(elements, v.AsArray().Elements)
+		return NewArrayFromElements(elements)
+	case MapType:
+		original := v.AsMap()
+		c := newMap(len(original.Pairs))
+		for _, key := range original.Keys() {
+			c.AsMap().Set(key, original.Pairs[key])
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+// deepCopy returns a copy of v that shares no array, map, struct or builder
+// with it, even through a variant's payload
+func deepCopy(v Value) Value {
+	return deepCopier{}.copy(v)
+}
+
+// deepCopier maps each collection already copied to its copy, so values
+// reachable along several paths, or from themselves, keep that shape
+type deepCopier map[unsafe.Pointer]Value
+
+func (copies deepCopier) copy(v Value) Value {
+	switch v.Type {
+	case ArrayType, MapType, StructType, BuilderType, TupleType:
+	case VariantType:
+		return NewVariantValue(v.VariantTag(), copies.copy(v.VariantPayload()))
+	default:
+		return v
+	}
+	if c, ok := copies[v.ptr]; ok {
+		return c
+	}
+
+	switch v.Type {
+	case ArrayType:
+		elements := make([]Value, len(v.AsArray().Elements))
+		c := NewArrayFromElements(elements)
+		copies[v.ptr] = c
+		for i, elem := range v.AsArray().Elements {
+			elements[i] = copies.copy(elem)
+		}
+		return c
+	case TupleType:
+		elements := make([]Value, len(v.TupleElements()))
+		c := NewTupleValue(elements)
+		copies[v.ptr] = c
+		for i, elem := range v.TupleElements() {
+			elements[i] = copies.copy(elem)
+		}
+		return c
+	case MapType:
+		original := v.AsMap()
+		c := newMap(len(original.Pairs))
+		copies[v.ptr] = c
+		for _, key := range original.Keys() {
+			c.AsMap().Set(key, copies.copy(original.Pairs[key]))
+		}
+		return c
+	case StructType:
+		original := v.AsStruct()
+		s := &StructValue{
+			TypeName:    original.TypeName,
+			Fields:      make(map[string]Value, len(original.FieldOrder)),
+			FieldsArray: make([]Value, len(original.FieldsArray)),
+			FieldOrder:  slices.Clone(original.FieldOrder),
+		}
+		c := Value{Type: StructType, ptr: unsafe.Pointer(s)}
+		copies[v.ptr] = c
+		for i, value := range original.FieldsArray {
+			s.FieldsArray[i] = copies.copy(value)
+			s.Fields[s.FieldOrder[i]] = s.FieldsArray[i]
+		}
+		return c
+	default: // BuilderType
+		c := NewBuilderValue()
+		c.AsBuilder().WriteString(v.AsBuilder().String())
+		copies[v.ptr] = c
+		return c
+	}
+}
+
 // StructValue represents a struct instance
+// FieldsArray and FieldOrder are populated for every struct, however it was
+// created, so offset-based access always works. Fields mirrors them for
+// name-based access; write through SetField or SetFieldAt to keep both in sync.
 type StructValue struct {
 	TypeName    string
-	Fields      map[string]Value // For name-based access (backward compatibility)
+	Fields      map[string]Value // For name-based access
 	FieldsArray []Value          // For offset-based access (Phase 3 optimization)
-	FieldOrder  []string         // Field names in order (for debugging/printing)
+	FieldOrder  []string         // Field names in the same order as FieldsArray
 }
 
+// IsStructNamed reports whether v is a struct of the type named name
+func (v Value) IsStructNamed(name string) bool {
+	return v.Type == StructType && v.AsStruct().TypeName == name
+}
+
+// NewStructValue creates a struct from named fields when the declared field
+// order isn't known. Fields are laid out sorted by name so the layout is
+// deterministic.
 func NewStructValue(typeName string, fields map[string]Value) Value {
-	s := &StructValue{
-		TypeName: typeName,
-		Fields:   fields,
+	fieldNames := make([]string, 0, len(fields))
+	for name := range fields {
+		fieldNames = append(fieldNames, name)
 	}
-	// Add to pool to keep it alive for GC
-	structPool = append(structPool, s)
-	trimPool(&structPool)
-	return Value{
-		Type: StructType,
-		Data: uint64(uintptr(unsafe.Pointer(s))),
+	sort.Strings(fieldNames)
+
+	fieldValues := make([]Value, len(fieldNames))
+	for i, name := range fieldNames {
+		fieldValues[i] = fields[name]
+	}
+
+	s := &StructValue{
+		TypeName:    typeName,
+		Fields:      fields,
+		FieldsArray: fieldValues,
+		FieldOrder:  fieldNames,
 	}
+	return Value{Type: StructType, ptr: unsafe.Pointer(s)}
 }
 
 // NewStructValueOrdered creates a struct with ordered fields (Phase 3 optimization)
 // This enables fast offset-based field access
 func NewStructValueOrdered(typeName string, fieldNames []string, fieldValues []Value) Value {
-	// Create map for backward compatibility
 	fields := make(map[string]Value, len(fieldNames))
 	for i, name := range fieldNames {
 		fields[name] = fieldValues[i]
@@ -257,17 +522,143 @@ func NewStructValueOrdered(typeName string, fieldNames []string, fieldValues []V
 		FieldsArray: fieldValues,
 		FieldOrder:  fieldNames,
 	}
-	// Add to pool to keep it alive for GC
-	structPool = append(structPool, s)
-	trimPool(&structPool)
-	return Value{
-		Type: StructType,
-		Data: uint64(uintptr(unsafe.Pointer(s))),
+	return Value{Type: StructType, ptr: unsafe.Pointer(s)}
+}
+
+// FieldOffset returns the index of a field in FieldsArray, or -1 if not found
+func (s *StructValue) FieldOffset(name string) int {
+	for i, fieldName := range s.FieldOrder {
+		if fieldName == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// SetField writes a field by name, adding it if the struct doesn't have it
+func (s *StructValue) SetField(name string, value Value) {
+	if offset := s.FieldOffset(name); offset >= 0 {
+		s.FieldsArray[offset] = value
+	} else {
+		s.FieldOrder = append(s.FieldOrder, name)
+		s.FieldsArray = append(s.FieldsArray, value)
+	}
+	s.Fields[name] = value
+}
+
+// SetFieldAt writes the field at offset, returning false if it is out of range
+func (s *StructValue) SetFieldAt(offset int, value Value) bool {
+	if offset < 0 || offset >= len(s.FieldsArray) {
+		return false
+	}
+	s.FieldsArray[offset] = value
+	s.Fields[s.FieldOrder[offset]] = value
+	return true
+}
+
+// String formats the struct with its fields in order, e.g. Point{x: 1, y: 2}
+func (s *StructValue) String() string {
+	return Value{Type: StructType, ptr: unsafe.Pointer(s)}.String()
+}
+
+// printer writes arrays, maps, structs and tuples the way they are written
+// in source, [1, 2], {"a": 1}, Point{x: 1, y: 2} and (1, "a"), with the strings in them
+// quoted and the keys of maps in order, ints before strings. It holds the
+// collections being written, so one that holds itself is written as ...
+// inside itself.
+type printer map[unsafe.Pointer]bool
+
+// write writes v to out the way it appears inside a collection
+func (p printer) write(out *strings.Builder, v Value) {
+	switch v.Type {
+	case StringType:
+		out.WriteString(strconv.Quote(v.AsString()))
+		return
+	case ArrayType, MapType, StructType, TupleType:
+	default:
+		out.WriteString(v.String())
+		return
+	}
+	if p[v.ptr] {
+		out.WriteString("...")
+		return
+	}
+	p[v.ptr] = true
+	defer delete(p, v.ptr)
+
+	switch v.Type {
+	case ArrayType:
+		out.WriteString("[")
+		for i, elem := range v.AsArray().Elements {
+			if i > 0 {
+				out.WriteString(", ")
+			}
+			p.write(out, elem)
+		}
+		out.WriteString("]")
+	case TupleType:
+		out.WriteString("(")
+		for i, elem := range v.TupleElements() {
+			if i > 0 {
+				out.WriteString(", ")
+			}
+			p.write(out, elem)
+		}
+		out.WriteString(")")
+	case MapType:
+		pairs := v.AsMap().Pairs
+		keys := make([]MapKey, 0, len(pairs))
+		for key := range pairs {
+			keys = append(keys, key)
+		}
+		slices.SortFunc(keys, compareMapKeys)
+		out.WriteString("{")
+		for i, key := range keys {
+			if i > 0 {
+				out.WriteString(", ")
+			}
+			if key.IsInt {
+				out.WriteString(strconv.FormatInt(key.IntVal, 10))
+			} else {
+				out.WriteString(strconv.Quote(key.StrVal))
+			}
+			out.WriteString(": ")
+			p.write(out, pairs[key])
+		}
+		out.WriteString("}")
+	default: // StructType
+		s := v.AsStruct()
+		out.WriteString(s.TypeName)
+		out.WriteString("{")
+		for i, name := range s.FieldOrder {
+			if i > 0 {
+				out.WriteString(", ")
+			}
+			out.WriteString(name)
+			out.WriteString(": ")
+			p.write(out, s.FieldsArray[i])
+		}
+		out.WriteString("}")
+	}
+}
+
+// compareMapKeys orders map keys for printing: ints, in order, then strings
+func compareMapKeys(a, b MapKey) int {
+	switch {
+	case a.IsInt && b.IsInt:
+		return cmp.Compare(a.IntVal, b.IntVal)
+	case a.IsInt != b.IsInt:
+		if a.IsInt {
+			return -1
+		}
+		return 1
+	default:
+		return strings.Compare(a.StrVal, b.StrVal)
 	}
 }
 
 func (v Value) AsStruct() *StructValue {
-	return (*StructValue)(unsafe.Pointer(uintptr(v.Data)))
+	return (*StructValue)(v.ptr)
 }
 
 // Function represents a compiled function
@@ -277,18 +668,19 @@ type Function struct {
 	NumLocals            int
 	Instructions         []byte                // Stack bytecode (for stack VM)
 	RegisterInstructions []RegisterInstruction // Register bytecode (for register VM)
-	Constants            []Value
+	Constants            []Value               // The register compiler's per-function pool, before linking
+	ConstantBase         int                   // Where the function's pool starts in the program's, once linked
+	NumFree              int                   // Captured variables expected by OpRMakeClosure
+	Lines                LineTable             // Source positions for Instructions
+	RegisterLines        LineTable             // Source positions for RegisterInstructions
 }
 
 func NewFunctionValue(fn *Function) Value {
-	// Add to pool to keep it alive for GC
-	functionPool = append(functionPool, fn)
-	trimPool(&functionPool)
-	return Value{Type: FunctionType, Data: uint64(uintptr(unsafe.Pointer(fn)))}
+	return Value{Type: FunctionType, ptr: unsafe.Pointer(fn)}
 }
 
 func (v Value) AsFunction() *Function {
-	return (*Function)(unsafe.Pointer(uintptr(v.Data)))
+	return (*Function)(v.ptr)
 }
 
 // Closure represents a closure (function + captured variables)
@@ -299,30 +691,20 @@ type Closure struct {
 
 func NewClosureValue(fn *Function, free []Value) Value {
 	cl := &Closure{Fn: fn, Free: free}
-	// Add to pool to keep it alive for GC
-	closurePool = append(closurePool, cl)
-	trimPool(&closurePool)
-	return Value{
-		Type: ClosureType,
-		Data: uint64(uintptr(unsafe.Pointer(cl))),
-	}
+	return Value{Type: ClosureType, ptr: unsafe.Pointer(cl)}
 }
 
 func (v Value) AsClosure() *Closure {
-	return (*Closure)(unsafe.Pointer(uintptr(v.Data)))
+	return (*Closure)(v.ptr)
 }
 
-// AsBuiltinFunction extracts a builtin function from a Value
+// NewBuiltinFunctionValue wraps a builtin function as a Value
 // Note: BuiltinFunction is defined in builtins.go as a named type
-func (v Value) AsBuiltinFunction() func(args ...Value) Value {
-	// The Data field contains a pointer to an interface{} in the builtinFunctionPool
-	// which holds the actual function value
-	interfacePtr := (*interface{})(unsafe.Pointer(uintptr(v.Data)))
-	// Cast to BuiltinFunction (the named type, not the underlying function type)
-	fn, ok := (*interfacePtr).(BuiltinFunction)
-	if !ok {
-		// This shouldn't happen, but provide a safe fallback
-		panic(fmt.Sprintf("AsBuiltinFunction: stored value is not a builtin function, got %T", *interfacePtr))
-	}
-	return fn
+func NewBuiltinFunctionValue(fn BuiltinFunction) Value {
+	return Value{Type: BuiltinFunctionType, ptr: unsafe.Pointer(&fn)}
+}
+
+// AsBuiltinFunction extracts a builtin function from a Value
+func (v Value) AsBuiltinFunction() BuiltinFunction {
+	return *(*BuiltinFunction)(v.ptr)
 }
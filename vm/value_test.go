@@ -0,0 +1,174 @@
+package vm
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValuesSurviveGC(t *testing.T) {
+	arr := NewArrayValue(3)
+	arr.AsArray().Elements[0] = StringValue("kept")
+	arr.AsArray().Elements[1] = NewMapValue()
+	arr.AsArray().Elements[1].AsMap().Set(MapKey{IsInt: true, IntVal: 1}, StringValue("one"))
+	arr.AsArray().Elements[2] = NewBuiltinFunctionValue(Builtins[1]) // len
+
+	// Far more short-lived values than the old pools could hold
+	for i := 0; i < 300000; i++ {
+		_ = StringValue(fmt.Sprint(i))
+	}
+	runtime.GC()
+
+	elements := arr.AsArray().Elements
+	if got := elements[0].AsString(); got != "kept" {
+		t.Errorf("string corrupted after GC: %q", got)
+	}
+	if got := elements[1].AsMap().Pairs[MapKey{IsInt: true, IntVal: 1}].AsString(); got != "one" {
+		t.Errorf("map entry corrupted after GC: %q", got)
+	}
+	if got, _ := elements[2].AsBuiltinFunction()(StringValue("abc")); got.AsInt() != 3 {
+		t.Errorf("builtin corrupted after GC: %v", got)
+	}
+}
+
+func TestUnreachableValuesAreCollected(t *testing.T) {
+	collected := make(chan struct{})
+	func() {
+		arr := NewArrayValue(1024)
+		runtime.SetFinalizer(arr.AsArray(), func(*ArrayValue) { close(collected) })
+	}()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		runtime.GC()
+		select {
+		case <-collected:
+			return
+		case <-deadline:
+			t.Fatal("array was never collected")
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestEmptyStringValue(t *testing.T) {
+	if got := StringValue("").AsString(); got != "" {
+		t.Errorf("expected empty string, got %q", got)
+	}
+	if StringValue("").IsTruthy() {
+		t.Error("expected empty string to be falsy")
+	}
+}
+
+func TestDeepCopy(t *testing.T) {
+	inner := NewMapValue()
+	inner.AsMap().Set(MapKey{StrVal: "n"}, IntValue(1))
+	point := NewStructValueOrdered("Point", []string{"x", "y"}, []Value{IntValue(1), inner})
+
+	// An array holding the map twice, the struct, and itself
+	arr := NewArrayFromElements([]Value{inner, inner, point, NilValue()})
+	arr.AsArray().Elements[3] = arr
+
+	c := deepCopy(arr)
+	elements := c.AsArray().Elements
+	if c.AsArray() == arr.AsArray() || elements[0].AsMap() == inner.AsMap() {
+		t.Fatal("copy shares collections with the original")
+	}
+	if elements[0].AsMap() != elements[1].AsMap() {
+		t.Error("a map reached twice was copied twice")
+	}
+	if elements[3].AsArray() != c.AsArray() {
+		t.Error("the cycle doesn't lead back to the copy")
+	}
+
+	copied := elements[2].AsStruct()
+	if copied == point.AsStruct() || copied.FieldsArray[1].AsMap() != elements[0].AsMap() {
+		t.Error("struct fields weren't copied along with the struct")
+	}
+	copied.SetField("x", IntValue(5))
+	elements[0].AsMap().Set(MapKey{StrVal: "n"}, IntValue(2))
+	if point.AsStruct().Fields["x"].AsInt() != 1 || inner.AsMap().Pairs[MapKey{StrVal: "n"}].AsInt() != 1 {
+		t.Error("writing to the copy changed the original")
+	}
+}
+
+func TestVariantValue(t *testing.T) {
+	payload := NewArrayFromElements([]Value{IntValue(1)})
+	v := NewVariantValue(2, payload)
+	if v.VariantTag() != 2 || v.VariantPayload().AsArray() != payload.AsArray() {
+		t.Fatalf("expected tag 2 carrying the array, got %s", v)
+	}
+	if none := NewVariantValue(0, NilValue()); none.String() != "<variant 0: nil>" {
+		t.Errorf("unexpected string for a variant without payload: %s", none)
+	}
+
+	c := deepCopy(v)
+	if c.VariantTag() != 2 || c.VariantPayload().AsArray() == payload.AsArray() {
+		t.Error("copy shares its payload with the original")
+	}
+}
+
+func TestEqual(t *testing.T) {
+	point := func(x, y Value) Value {
+		return NewStructValueOrdered("Point", []string{"x", "y"}, []Value{x, y})
+	}
+	dict := func(key string, value Value) Value {
+		m := NewMapValue()
+		m.AsMap().Set(MapKey{StrVal: key}, value)
+		return m
+	}
+	array := func(elements ...Value) Value { return NewArrayFromElements(elements) }
+
+	tests := []struct {
+		name  string
+		a, b  Value
+		equal bool
+	}{
+		{"int and float", IntValue(2), FloatValue(2), true},
+		{"int and string", IntValue(1), StringValue("1"), false},
+		{"arrays", array(IntValue(1), StringValue("a")), array(IntValue(1), StringValue("a")), true},
+		{"array lengths", array(IntValue(1)), array(IntValue(1), IntValue(1)), false},
+		{"maps", dict("a", array()), dict("a", array()), true},
+		{"map keys", dict("a", IntValue(1)), dict("b", IntValue(1)), false},
+		{"structs", point(IntValue(1), array()), point(IntValue(1), array()), true},
+		{"struct fields", point(IntValue(1), IntValue(2)), point(IntValue(1), IntValue(3)), false},
+		{"struct types", point(IntValue(1), IntValue(2)),
+			NewStructValueOrdered("Vec", []string{"x", "y"}, []Value{IntValue(1), IntValue(2)}), false},
+		{"variants", NewVariantValue(1, array(IntValue(1))), NewVariantValue(1, array(IntValue(1))), true},
+		{"variant tags", NewVariantValue(1, NilValue()), NewVariantValue(2, NilValue()), false},
+		{"nil and array", NilValue(), array(), false},
+	}
+	for _, tt := range tests {
+		if got := Equal(tt.a, tt.b); got != tt.equal {
+			t.Errorf("%s: Equal(%s, %s) = %t", tt.name, tt.a, tt.b, got)
+		}
+	}
+
+	// Arrays that hold themselves
+	a, b := array(IntValue(1), NilValue()), array(IntValue(1), NilValue())
+	a.AsArray().Elements[1], b.AsArray().Elements[1] = a, b
+	if !Equal(a, b) {
+		t.Error("arrays holding themselves aren't equal")
+	}
+}
+
+func TestCollectionString(t *testing.T) {
+	m := NewMapValue()
+	m.AsMap().Set(MapKey{StrVal: "b"}, StringValue("x"))
+	m.AsMap().Set(MapKey{IsInt: true, IntVal: 2}, IntValue(1))
+	m.AsMap().Set(MapKey{StrVal: "a"}, FloatValue(0.5))
+	point := NewStructValueOrdered("Point", []string{"x", "label"}, []Value{IntValue(1), StringValue("p")})
+	arr := NewArrayFromElements([]Value{m, point, NilValue()})
+
+	want := `[{2: 1, "a": 0.500000, "b": "x"}, Point{x: 1, label: "p"}, nil]`
+	if got := arr.String(); got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+
+	arr.AsArray().Elements[2] = arr
+	if got := NewArrayFromElements([]Value{arr}).String(); !strings.HasSuffix(got, "...]]") {
+		t.Errorf("expected the cycle written as ..., got %s", got)
+	}
+}
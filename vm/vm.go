@@ -55,14 +55,28 @@ type VM struct {
 
 	frames      []*Frame
 	framesIndex int
+
+	env        *builtinEnv       // Output streams
+	builtinFns []BuiltinFunction // Builtins bound to env
+	builtins   []Value           // builtinFns as Values
+
+	result Value // The value OpPopResult last popped, see LastValue
+
+	exiting    bool  // Main has finished and exit hooks are running
+	exitSP     int   // sp when main finished
+	exitResult Value // The program's result, kept aside while exit hooks run
+
+	handlers []handler // Active try blocks, innermost last
 }
 
 // New creates a new VM
-func New(bytecode *Bytecode) *VM {
+func New(bytecode *Bytecode, opts ...Option) *VM {
 	mainFn := &Function{
+		Name:         "main",
 		Instructions: bytecode.Instructions,
 		NumLocals:    0,
 		NumParams:    0,
+		Lines:        bytecode.Lines,
 	}
 	mainClosure := &Closure{Fn: mainFn, Free: nil}  // Use nil instead of empty slice
 	mainFrame := NewFrame(mainClosure, 0)
@@ -70,20 +84,43 @@ func New(bytecode *Bytecode) *VM {
 	frames := make([]*Frame, MaxFrames)
 	frames[0] = mainFrame
 
-	return &VM{
+	env, builtinFns, builtins := newConfig(opts).builtins(bytecode.Enums)
+
+	vm := &VM{
 		constants:   bytecode.Constants,
 		stack:       make([]Value, StackSize),
 		sp:          0,
-		globals:     make([]Value, GlobalsSize),
+		globals:     make([]Value, globalsSize(bytecode.Globals)),
 		frames:      frames,
 		framesIndex: 1,
+		env:         env,
+		builtinFns:  builtinFns,
+		builtins:    builtins,
+		result:      NilValue(),
 	}
+	env.call = vm.callValue
+	return vm
 }
 
 // Bytecode represents compiled bytecode
 type Bytecode struct {
 	Instructions []byte
 	Constants    []Value
+	Lines        LineTable // Source positions for Instructions
+	Enums        Enums     // Enum definitions, for enumName and enumValue
+	Globals      int       // Global slots the program uses, or 0 for GlobalsSize
+}
+
+// stackTrace describes the active frames, innermost first. Each frame's ip
+// has moved past the instruction it is executing, so ip-1 lies within it.
+func (vm *VM) stackTrace() []TraceEntry {
+	trace := make([]TraceEntry, 0, vm.framesIndex)
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+		pos, _ := frame.cl.Fn.Lines.Lookup(frame.ip - 1)
+		trace = append(trace, TraceEntry{Function: frame.cl.Fn.Name, Pos: pos})
+	}
+	return trace
 }
 
 // currentFrame returns the current frame
@@ -116,8 +153,47 @@ func (vm *VM) LastPoppedStackElem() Value {
 	return vm.stack[vm.sp]
 }
 
+// LastValue returns the value of the last top-level expression statement the
+// program ran, or nil if there was none, as the register VM's LastValue does
+func (vm *VM) LastValue() Value {
+	return vm.result
+}
+
 // Run executes the bytecode
-func (vm *VM) Run() error {
+func (vm *VM) Run() (err error) {
+	// Write out buffered print output however the program ends
+	defer func() {
+		if flushErr := vm.env.flush(); err == nil {
+			err = flushErr
+		}
+	}()
+
+	// Annotate errors with the call stack and source positions
+	annotate := func(err error) error {
+		if err != nil && !isExit(err) {
+			return newRuntimeError(err, vm.stackTrace())
+		}
+		return err
+	}
+
+	err = annotate(FinishExit(vm.execute(0), vm.resumeAfterExit))
+	return vm.env.finishExitHooks(err, func() bool { return vm.exiting }, vm.resumeAfterExit, annotate)
+}
+
+// dispatch runs instructions until the frame at floor returns, or until the
+// program ends if floor is 0, stopping at the first error
+func (vm *VM) dispatch(floor int) (err error) {
+	var frame *Frame
+	var ins []byte
+	var ip int
+
+	// Leave the ip of the failing frame at its instruction, for stackTrace
+	defer func() {
+		if err != nil && frame != nil {
+			frame.ip = ip
+		}
+	}()
+
 	// Outer loop - manages frames
 	for {
 		if vm.framesIndex == 0 {
@@ -125,9 +201,9 @@ func (vm *VM) Run() error {
 		}
 
 		// Cache frame and instructions to avoid repeated lookups
-		frame := vm.frames[vm.framesIndex-1]
-		ins := frame.Instructions()
-		ip := frame.ip
+		frame = vm.frames[vm.framesIndex-1]
+		ins = frame.Instructions()
+		ip = frame.ip
 
 		// fmt.Printf("DEBUG: Starting frame, ip=%d, insLen=%d\n", ip, len(ins))
 
@@ -147,9 +223,53 @@ func (vm *VM) Run() error {
 					return err
 				}
 
+			case OpPushWide:
+				constIndex, _ := ReadWideOperand(ins, ip)
+				ip += 4
+
+				err := vm.push(vm.constants[constIndex])
+				if err != nil {
+					return err
+				}
+
+			case OpCopyConst, OpCopyConstWide:
+				var constIndex int
+				if op == OpCopyConst {
+					constIndex, _ = ReadOperand(ins, ip)
+					ip += 2
+				} else {
+					constIndex, _ = ReadWideOperand(ins, ip)
+					ip += 4
+				}
+
+				// Constant collections are shared, so each evaluation gets its own copy
+				err := vm.push(copyCollection(vm.constants[constIndex]))
+				if err != nil {
+					return err
+				}
+
 			case OpPop:
 				vm.pop()
 
+			case OpPopResult:
+				vm.result = vm.pop()
+
+			case OpCheckType, OpCheckTypeWide:
+				var constIndex, want int
+				if op == OpCheckType {
+					constIndex, _ = ReadOperand(ins, ip)
+					want, _ = ReadOperand(ins, ip+2)
+					ip += 4
+				} else {
+					constIndex, _ = ReadWideOperand(ins, ip)
+					want, _ = ReadWideOperand(ins, ip+4)
+					ip += 8
+				}
+
+				if err := CheckType(vm.stack[vm.sp-1], ValueType(want), vm.constants[constIndex].AsString()); err != nil {
+					return err
+				}
+
 			case OpDup:
 				if vm.sp <= 0 {
 					return fmt.Errorf("stack underflow on OpDup: sp=%d", vm.sp)
@@ -335,6 +455,22 @@ func (vm *VM) Run() error {
 					return err
 				}
 
+			case OpDivPromote:
+				right := vm.pop()
+				left := vm.pop()
+				dividend, okLeft := numericAsFloat(left)
+				divisor, okRight := numericAsFloat(right)
+				if !okLeft || !okRight {
+					return ErrUnsupportedOperands
+				}
+				if divisor == 0 {
+					return ErrDivisionByZero
+				}
+				err := vm.push(FloatValue(dividend / divisor))
+				if err != nil {
+					return err
+				}
+
 			case OpModInt:
 				right := vm.pop()
 				left := vm.pop()
@@ -532,14 +668,22 @@ func (vm *VM) Run() error {
 				vm.stack[frame.basePointer+localIndex] = vm.pop()
 
 			case OpJump:
-				pos, _ := ReadOperand(ins, ip)
+				pos, _ := ReadWideOperand(ins, ip)
 				ip = pos
 				frame.ip = ip
 				break innerLoop // Break inner loop to reload frame
 
+			case OpTry:
+				catchIP, _ := ReadWideOperand(ins, ip)
+				ip += 4
+				vm.handlers = append(vm.handlers, handler{frame: vm.framesIndex, sp: vm.sp, ip: catchIP})
+
+			case OpEndTry:
+				vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
 			case OpJumpIfFalse:
-				pos, _ := ReadOperand(ins, ip)
-				ip += 2
+				pos, _ := ReadWideOperand(ins, ip)
+				ip += 4
 
 				condition := vm.pop()
 				if !condition.IsTruthy() {
@@ -549,8 +693,8 @@ func (vm *VM) Run() error {
 				}
 
 			case OpJumpIfTrue:
-				pos, _ := ReadOperand(ins, ip)
-				ip += 2
+				pos, _ := ReadWideOperand(ins, ip)
+				ip += 4
 
 				condition := vm.pop()
 				if condition.IsTruthy() {
@@ -572,6 +716,21 @@ func (vm *VM) Run() error {
 				// fmt.Printf("DEBUG: OpCall completed, breaking to reload frame\n")
 				break innerLoop // Break to reload new frame
 
+			case OpCallBuiltin:
+				builtinIndex, _ := ReadOperand(ins, ip)
+				numArgs, _ := ReadOperand(ins, ip+2)
+				ip += 4
+
+				if builtinIndex >= len(vm.builtinFns) {
+					return fmt.Errorf("unknown builtin: %d", builtinIndex)
+				}
+
+				// Builtins never push frames, so no need to reload the frame
+				err := vm.callBuiltin(vm.builtinFns[builtinIndex], numArgs, 0)
+				if err != nil {
+					return err
+				}
+
 			case OpReturn:
 				returnValue := vm.pop()
 				// fmt.Printf("DEBUG: OpReturn with value %v\n", returnValue)
@@ -589,10 +748,17 @@ func (vm *VM) Run() error {
 				// fmt.Printf("DEBUG: OpReturn pushed value, returning to previous frame\n")
 				break innerLoop // Break to reload previous frame
 
-			case OpMakeClosure:
-				fnIndex, _ := ReadOperand(ins, ip)
-				numFree, _ := ReadOperand(ins, ip+2)
-				ip += 4
+			case OpMakeClosure, OpMakeClosureWide:
+				var fnIndex, numFree int
+				if op == OpMakeClosure {
+					fnIndex, _ = ReadOperand(ins, ip)
+					numFree, _ = ReadOperand(ins, ip+2)
+					ip += 4
+				} else {
+					fnIndex, _ = ReadWideOperand(ins, ip)
+					numFree, _ = ReadWideOperand(ins, ip+4)
+					ip += 8
+				}
 
 				fn := vm.constants[fnIndex].AsFunction()
 
@@ -681,8 +847,8 @@ func (vm *VM) Run() error {
 						return fmt.Errorf("string index out of bounds: %d", idx)
 					}
 
-					// Return a single-character string
-					err := vm.push(StringValue(string(str[idx])))
+					// Return the byte at idx as a one-byte string
+					err := vm.push(StringValue(str[idx : idx+1]))
 					if err != nil {
 						return err
 					}
@@ -715,24 +881,22 @@ func (vm *VM) Run() error {
 					return fmt.Errorf("array index out of bounds: %d", idx)
 				}
 
-				arrayVal.Elements[idx] = value
+				arrayVal.Set(idx, value)
 
 			case OpMap:
 				size, _ := ReadOperand(ins, ip)
 				ip += 2
 
-				mapVal := NewMapValue()
+				mapVal := newMap(size)
 				mapData := mapVal.AsMap()
 
-				// Pop key-value pairs from stack
+				// Set the key-value pairs in the order they were pushed
+				base := vm.sp - 2*size
 				for i := 0; i < size; i++ {
-					value := vm.pop()
-					key := vm.pop()
-
 					// Use optimized map key (no allocation for ints)
-					mapKey := key.ToMapKey()
-					mapData.Pairs[mapKey] = value
+					mapData.Set(vm.stack[base+2*i].ToMapKey(), vm.stack[base+2*i+1])
 				}
+				vm.sp = base
 
 				err := vm.push(mapVal)
 				if err != nil {
@@ -766,9 +930,7 @@ func (vm *VM) Run() error {
 				key := vm.pop()
 				mapVal := vm.pop()
 
-				mapKey := key.ToMapKey()
-				mapData := mapVal.AsMap()
-				mapData.Pairs[mapKey] = value
+				mapVal.AsMap().Set(key.ToMapKey(), value)
 
 			case OpStruct:
 				numFields, _ := ReadOperand(ins, ip)
@@ -838,7 +1000,7 @@ func (vm *VM) Run() error {
 				structData := structVal.AsStruct()
 				fieldName := fieldNameVal.AsString()
 
-				structData.Fields[fieldName] = value
+				structData.SetField(fieldName, value)
 
 			// Phase 3 optimization: Offset-based struct operations
 			case OpStructOrdered:
@@ -863,8 +1025,7 @@ func (vm *VM) Run() error {
 				}
 
 				// Create struct with ordered fields (Phase 3 optimization)
-				// This populates both FieldsArray (for fast offset access) and
-				// Fields map (for backward compatibility)
+				// Declaration order lets the compiler use offset access
 				structVal := NewStructValueOrdered(typeNameVal.AsString(), fieldNames, fieldValues)
 
 				err := vm.push(structVal)
@@ -909,11 +1070,45 @@ func (vm *VM) Run() error {
 				structData := structVal.AsStruct()
 
 				// Direct array access - no map lookup! (Phase 3 optimization)
-				if offset < 0 || offset >= len(structData.FieldsArray) {
+				if !structData.SetFieldAt(offset, value) {
 					return fmt.Errorf("field offset %d out of bounds", offset)
 				}
 
-				structData.FieldsArray[offset] = value
+			case OpMakeVariant:
+				tag, _ := ReadOperand(ins, ip)
+				ip += 2
+
+				vm.stack[vm.sp-1] = NewVariantValue(tag, vm.stack[vm.sp-1])
+
+			case OpTestVariant:
+				tag, _ := ReadOperand(ins, ip)
+				ip += 2
+
+				v := vm.stack[vm.sp-1]
+				vm.stack[vm.sp-1] = BoolValue(v.Type == VariantType && v.VariantTag() == tag)
+
+			case OpTestStruct:
+				name := vm.pop()
+				v := vm.stack[vm.sp-1]
+				vm.stack[vm.sp-1] = BoolValue(v.IsStructNamed(name.AsString()))
+
+			case OpTestArray:
+				n, _ := ReadOperand(ins, ip)
+				ip += 2
+
+				v := vm.stack[vm.sp-1]
+				vm.stack[vm.sp-1] = BoolValue(v.Type == ArrayType && len(v.AsArray().Elements) == n)
+
+			case OpVariantTag, OpVariantPayload:
+				v := vm.stack[vm.sp-1]
+				if v.Type != VariantType {
+					return fmt.Errorf("expected a variant, got %s", v.Type)
+				}
+				if op == OpVariantTag {
+					vm.stack[vm.sp-1] = IntValue(int64(v.VariantTag()))
+				} else {
+					vm.stack[vm.sp-1] = v.VariantPayload()
+				}
 
 			// Phase 4A: Immediate constant arithmetic operations
 			case OpAddConstInt:
@@ -1101,6 +1296,116 @@ func (vm *VM) Run() error {
 					return err
 				}
 
+			// Intrinsics, matching the builtins they stand in for
+			case OpAbsInt:
+				if val := vm.stack[vm.sp-1].AsInt(); val < 0 {
+					vm.stack[vm.sp-1] = IntValue(-val)
+				}
+
+			case OpAbsFloat:
+				vm.stack[vm.sp-1] = absNumber(vm.stack[vm.sp-1])
+
+			case OpMinInt:
+				right := vm.pop()
+				if !(vm.stack[vm.sp-1].AsInt() < right.AsInt()) {
+					vm.stack[vm.sp-1] = right
+				}
+
+			case OpMinFloat:
+				right := vm.pop()
+				vm.stack[vm.sp-1] = minNumbers(vm.stack[vm.sp-1], right)
+
+			case OpMaxInt:
+				right := vm.pop()
+				if !(vm.stack[vm.sp-1].AsInt() > right.AsInt()) {
+					vm.stack[vm.sp-1] = right
+				}
+
+			case OpMaxFloat:
+				right := vm.pop()
+				vm.stack[vm.sp-1] = maxNumbers(vm.stack[vm.sp-1], right)
+
+			case OpSqrtInt, OpSqrtFloat:
+				val := float64(vm.stack[vm.sp-1].AsInt())
+				if op == OpSqrtFloat {
+					val, _ = numericAsFloat(vm.stack[vm.sp-1])
+				}
+				result, err := squareRoot(val)
+				if err != nil {
+					return err
+				}
+				vm.stack[vm.sp-1] = result
+
+			case OpWrapInt32:
+				vm.stack[vm.sp-1] = WrapInt32(vm.stack[vm.sp-1])
+
+			case OpAddChecked, OpSubChecked, OpMulChecked, OpDivChecked:
+				operator, unchecked := checkedOperation(op)
+				left, right := vm.stack[vm.sp-2], vm.stack[vm.sp-1]
+				if left.Type == IntType && right.Type == IntType {
+					result, err := CheckedInt(operator, left.AsInt(), right.AsInt())
+					if err != nil {
+						return err
+					}
+					vm.sp--
+					vm.stack[vm.sp-1] = IntValue(result)
+				} else if err := vm.executeBinaryOperation(unchecked); err != nil {
+					return err
+				}
+
+			case OpNegChecked:
+				operand := vm.stack[vm.sp-1]
+				if operand.Type == IntType {
+					result, err := CheckedNeg(operand.AsInt())
+					if err != nil {
+						return err
+					}
+					vm.stack[vm.sp-1] = IntValue(result)
+				} else if operand.Type == FloatType {
+					vm.stack[vm.sp-1] = FloatValue(-operand.AsFloat())
+				} else {
+					return fmt.Errorf("unsupported operand type for negation: %d", operand.Type)
+				}
+
+			case OpTuple:
+				size, _ := ReadOperand(ins, ip)
+				ip += 2
+
+				elements := make([]Value, size)
+				copy(elements, vm.stack[vm.sp-size:vm.sp])
+				vm.sp -= size
+				err := vm.push(NewTupleValue(elements))
+				if err != nil {
+					return err
+				}
+
+			case OpTupleGet:
+				index, _ := ReadOperand(ins, ip)
+				ip += 2
+
+				element, err := TupleElement(vm.stack[vm.sp-1], index)
+				if err != nil {
+					return err
+				}
+				vm.stack[vm.sp-1] = element
+
+			case OpIn:
+				container := vm.pop()
+				result, err := In(vm.stack[vm.sp-1], container)
+				if err != nil {
+					return err
+				}
+				vm.stack[vm.sp-1] = result
+
+			case OpSlice:
+				high := vm.pop()
+				low := vm.pop()
+				result, err := Slice(vm.stack[vm.sp-1], low, high)
+				if err != nil {
+					return err
+				}
+				vm.stack[vm.sp-1] = result
+
 			// Phase 4D: Compare with immediate constant
 			case OpLtConstInt:
 				constIndex, _ := ReadOperand(ins, ip)
@@ -1247,11 +1552,14 @@ func (vm *VM) Run() error {
 				}
 
 			case OpPrint:
-				val := vm.pop()
-				fmt.Println(val.String())
+				vm.env.printBuiltin(vm.pop())
 
 			case OpHalt:
-				return nil
+				ip = len(ins)
+				if started, err := vm.startExitHook(); err != nil || !started {
+					return err
+				}
+				break innerLoop
 			}
 		}
 
@@ -1260,15 +1568,23 @@ func (vm *VM) Run() error {
 		// this syncs the IP for the frame we just left, which is fine.
 		frame.ip = ip
 
+		// A function called from a builtin has returned to it
+		if vm.framesIndex == floor {
+			return nil
+		}
+
 		// Reload frame variables after potential frame change
 		// (OpCall, OpReturn, OpJump can all change frames or IP)
 		frame = vm.frames[vm.framesIndex-1]
 		ins = frame.Instructions()
 		ip = frame.ip
 
-		// If we're in the main frame and completed all instructions, exit
+		// If we're in the main frame and completed all instructions, run
+		// the exit hooks, then exit
 		if vm.framesIndex == 1 && ip >= len(ins) {
-			return nil
+			if started, err := vm.startExitHook(); err != nil || !started {
+				return err
+			}
 		}
 	}
 
@@ -1408,6 +1724,24 @@ func (vm *VM) executeComparison(op OpCode) error {
 		}
 	}
 
+	// Strings compare for equality only
+	if left.Type == StringType && right.Type == StringType {
+		switch op {
+		case OpEq:
+			return vm.push(BoolValue(left.AsString() == right.AsString()))
+		case OpNe:
+			return vm.push(BoolValue(left.AsString() != right.AsString()))
+		}
+	}
+
+	// Everything else compares for equality only, deeply
+	switch op {
+	case OpEq:
+		return vm.push(BoolValue(Equal(left, right)))
+	case OpNe:
+		return vm.push(BoolValue(!Equal(left, right)))
+	}
+
 	return ErrUnsupportedComparison
 }
 
@@ -219,13 +219,13 @@ func TestConditionals(t *testing.T) {
 			// if (true) { 10 } else { 20 }
 			&Bytecode{
 				Instructions: concatInstructions(
-					Make(OpPush, 0),        // 0-2: true
-					Make(OpJumpIfFalse, 12), // 3-5: jump to 12 if false (else branch)
-					Make(OpPush, 1),        // 6-8: 10
-					Make(OpJump, 15),       // 9-11: jump to 15 (after else)
-					Make(OpPush, 2),        // 12-14: 20
-					Make(OpPop),            // 15
-					Make(OpHalt),           // 16
+					Make(OpPush, 0),         // 0-2: true
+					Make(OpJumpIfFalse, 16), // 3-7: jump to 16 if false (else branch)
+					Make(OpPush, 1),         // 8-10: 10
+					Make(OpJump, 19),        // 11-15: jump to 19 (after else)
+					Make(OpPush, 2),         // 16-18: 20
+					Make(OpPop),             // 19
+					Make(OpHalt),            // 20
 				),
 				Constants: []Value{BoolValue(true), IntValue(10), IntValue(20)},
 			},
@@ -235,13 +235,13 @@ func TestConditionals(t *testing.T) {
 			// if (false) { 10 } else { 20 }
 			&Bytecode{
 				Instructions: concatInstructions(
-					Make(OpPush, 0),        // 0-2: false
-					Make(OpJumpIfFalse, 12), // 3-5: jump to 12 if false (else branch)
-					Make(OpPush, 1),        // 6-8: 10
-					Make(OpJump, 15),       // 9-11: jump to 15 (after else)
-					Make(OpPush, 2),        // 12-14: 20
-					Make(OpPop),            // 15
-					Make(OpHalt),           // 16
+					Make(OpPush, 0),         // 0-2: false
+					Make(OpJumpIfFalse, 16), // 3-7: jump to 16 if false (else branch)
+					Make(OpPush, 1),         // 8-10: 10
+					Make(OpJump, 19),        // 11-15: jump to 19 (after else)
+					Make(OpPush, 2),         // 16-18: 20
+					Make(OpPop),             // 19
+					Make(OpHalt),            // 20
 				),
 				Constants: []Value{BoolValue(false), IntValue(10), IntValue(20)},
 			},
@@ -341,3 +341,31 @@ func concatInstructions(s ...[]byte) []byte {
 	}
 	return out
 }
+
+func TestRegisterDisassemble(t *testing.T) {
+	jump, err := EncodeRegisterJump(OpRJumpF, 2, 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	instructions := []RegisterInstruction{
+		EncodeRegisterInstructionBx(OpRLoadK, 0, 1),
+		EncodeRegisterInstructionBx(OpRLoadGlobal, 1, 300),
+		EncodeRegisterInstruction(OpRAddInt, 2, 0, 1),
+		EncodeRegisterInstruction(OpRBuiltin, 3, 0|2<<4, 1), // print with 2 arguments
+		jump,
+		EncodeRegisterInstruction(OpRInvoke, 4, 2, 0),
+		EncodeRegisterInstruction(OpRReturnN, 0, 0, 0),
+	}
+
+	expected := `0000  LOADK          R0 K1
+0001  LOADGLOBAL     R1 G300
+0002  ADD_INT        R2 R0 R1
+0003  BUILTIN        R3 print R1 2
+0004  JUMPF          R2 -> 0000
+0005  INVOKE         R4 2
+0006  RETURNN
+`
+	if got := RegisterDisassemble(instructions); got != expected {
+		t.Errorf("expected\n%s\ngot\n%s", expected, got)
+	}
+}